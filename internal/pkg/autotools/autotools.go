@@ -18,6 +18,15 @@ import (
 	"github.com/gvallee/go_util/pkg/util"
 )
 
+// Runner executes commands, e.g., to mock them in tests (see buildenv.Runner)
+type Runner interface {
+	// LookPath searches for an executable, like exec.LookPath
+	LookPath(file string) (string, error)
+
+	// RunAdvcmd executes a command and returns its output, like advexec.Advcmd.Run
+	RunAdvcmd(cmd *advexec.Advcmd) advexec.Result
+}
+
 // Config represents the configuration of the autotools-compliant software to configure/compile/install
 type Config struct {
 	// DetectDone specifies whether Detect() has been called on the configuration
@@ -46,6 +55,28 @@ type Config struct {
 
 	// ConfigurePreludeCmd is the command to invoke before trying to configure the software
 	ConfigurePreludeCmd string
+
+	// CacheFile is the path to the cache file configure must use, e.g., to share results across packages (optional)
+	CacheFile string
+
+	// Runner executes the commands, the commands being executed on the host when nil (optional)
+	Runner Runner
+}
+
+// lookPath searches for an executable, using the runner of the configuration if any
+func (cfg *Config) lookPath(file string) (string, error) {
+	if cfg.Runner != nil {
+		return cfg.Runner.LookPath(file)
+	}
+	return exec.LookPath(file)
+}
+
+// run executes a command, using the runner of the configuration if any
+func (cfg *Config) run(cmd *advexec.Advcmd) advexec.Result {
+	if cfg.Runner != nil {
+		return cfg.Runner.RunAdvcmd(cmd)
+	}
+	return cmd.Run()
 }
 
 func autogen(cfg *Config) error {
@@ -72,7 +103,7 @@ func autogen(cfg *Config) error {
 	cmd.ManifestDir = cfg.Install
 	cmd.ExecDir = cfg.Source
 	cmd.Env = cfg.ConfigureEnv
-	res := cmd.Run()
+	res := cfg.run(&cmd)
 	if res.Err != nil {
 		return fmt.Errorf("unable to run autogen from %s, command failed: %w - stdout: %s - stderr: %s", cfg.Source, res.Err, res.Stdout, res.Stderr)
 	}
@@ -152,7 +183,7 @@ func (cfg *Config) Configure() error {
 	// Run any configure prelude first
 	if cfg.ConfigurePreludeCmd != "" {
 		tokens := strings.Split(cfg.ConfigurePreludeCmd, " ")
-		cmdBin, err := exec.LookPath(tokens[0])
+		cmdBin, err := cfg.lookPath(tokens[0])
 		if err != nil {
 			return fmt.Errorf("unable to run prelude, cannot find %s", tokens[0])
 		}
@@ -163,7 +194,7 @@ func (cfg *Config) Configure() error {
 		preludeCmd.ManifestName = "configure_prelude"
 		preludeCmd.ManifestDir = cfg.Install
 		preludeCmd.ExecDir = cfg.Source
-		res := preludeCmd.Run()
+		res := cfg.run(&preludeCmd)
 		if res.Err != nil {
 			return fmt.Errorf("unable to execute configure prelude %s: %w", cfg.ConfigurePreludeCmd, res.Err)
 		}
@@ -185,6 +216,9 @@ func (cfg *Config) Configure() error {
 		cmdArgs = append(cmdArgs, "--prefix")
 		cmdArgs = append(cmdArgs, cfg.Install)
 	}
+	if cfg.CacheFile != "" {
+		cmdArgs = append(cmdArgs, "--cache-file="+cfg.CacheFile)
+	}
 	if len(cfg.ExtraConfigureArgs) > 0 {
 		cmdArgs = append(cmdArgs, cfg.ExtraConfigureArgs...)
 	}
@@ -204,7 +238,7 @@ func (cfg *Config) Configure() error {
 		cmd.Env = append(cmd.Env, cfg.ConfigureEnv...)
 		log.Printf("-> configure environment: %s\n", strings.Join(cmd.Env, " "))
 	}
-	res := cmd.Run()
+	res := cfg.run(&cmd)
 	if res.Err != nil {
 		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", res.Err, res.Stdout, res.Stderr)
 	}
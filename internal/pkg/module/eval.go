@@ -0,0 +1,83 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package module
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// cleanShellPath is the PATH of the clean shell modulefiles are loaded in
+const cleanShellPath = "/usr/local/bin:/usr/bin:/bin"
+
+// evalScript loads a module, whose name is the first argument, with the module command given as the other
+// arguments and prints the resulting environment. Module commands print the shell code to evaluate, which
+// fails when the modulefile cannot be loaded, e.g., 'false' with Lmod.
+const evalScript = `code=$("$@" sh load "$0") || exit 1
+eval "$code" || exit 1
+env -0`
+
+// FindModuleCmd returns the command, i.e., the binary and its arguments, able to load modulefiles of a given
+// dialect: modulecmd of Environment Modules or Lmod for Tcl modulefiles and Lmod for Lua modulefiles
+func FindModuleCmd(dialect Dialect) ([]string, error) {
+	var candidates [][]string
+	if dialect != DialectLua {
+		if modulecmdBin, err := exec.LookPath("modulecmd"); err == nil {
+			candidates = append(candidates, []string{modulecmdBin})
+		}
+		// Environment Modules 4 and later are implemented in Tcl
+		modulecmdScript := filepath.Join(os.Getenv("MODULESHOME"), "libexec", "modulecmd.tcl")
+		if tclshBin, err := exec.LookPath("tclsh"); err == nil && os.Getenv("MODULESHOME") != "" {
+			if _, err := os.Stat(modulecmdScript); err == nil {
+				candidates = append(candidates, []string{tclshBin, modulecmdScript})
+			}
+		}
+	}
+	if lmodCmd := os.Getenv("LMOD_CMD"); lmodCmd != "" {
+		candidates = append(candidates, []string{lmodCmd})
+	} else if lmodBin, err := exec.LookPath("lmod"); err == nil {
+		candidates = append(candidates, []string{lmodBin})
+	}
+	if len(candidates) == 0 {
+		return nil, fmt.Errorf("no module command able to load %s modulefiles found, e.g., modulecmd or Lmod", dialect)
+	}
+	return candidates[0], nil
+}
+
+// Eval loads a module from a directory of modulefiles, with a module command (see FindModuleCmd), in a clean shell,
+// i.e., with only the directory in MODULEPATH and a standard PATH, and returns the resulting environment. An
+// error is returned when the module cannot be loaded, e.g., because of a syntax error in its modulefile.
+func Eval(moduleCmd []string, modulefileDir string, name string) (map[string]string, error) {
+	if len(moduleCmd) == 0 {
+		return nil, fmt.Errorf("undefined module command")
+	}
+	args := append([]string{"-c", evalScript, name}, moduleCmd...)
+	cmd := exec.Command("/bin/sh", args...)
+	cmd.Env = []string{"PATH=" + cleanShellPath, "HOME=" + os.Getenv("HOME"), "MODULEPATH=" + modulefileDir}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load %s: %w - stderr: %s", name, err, strings.TrimSpace(stderr.String()))
+	}
+	// Environment Modules 3 reports errors without failing
+	if strings.Contains(strings.ToLower(stderr.String()), "error") {
+		return nil, fmt.Errorf("unable to load %s: %s", name, strings.TrimSpace(stderr.String()))
+	}
+
+	env := make(map[string]string)
+	for _, entry := range strings.Split(stdout.String(), "\x00") {
+		if tokens := strings.SplitN(entry, "=", 2); len(tokens) == 2 {
+			env[tokens[0]] = tokens[1]
+		}
+	}
+	return env, nil
+}
@@ -10,6 +10,18 @@ import (
 	"io/ioutil"
 	"path/filepath"
 	"strings"
+	"text/template"
+)
+
+// Dialect is the language of modulefiles
+type Dialect string
+
+const (
+	// DialectTcl is the Tcl dialect of modulefiles, supported by both Environment Modules and Lmod
+	DialectTcl Dialect = "tcl"
+
+	// DialectLua is the Lua dialect of modulefiles, only supported by Lmod
+	DialectLua Dialect = "lua"
 )
 
 const (
@@ -20,57 +32,244 @@ const (
 	setKeyword         = "set "
 	setenvKeyword      = "setenv "
 	prependPathKeyword = "prepend-path "
+	whatisKeyword      = "module-whatis "
+
+	// familyDirective is the Lmod family directive, only executed when the module system supports it,
+	// i.e., Lmod or recent versions of Environment Modules
+	familyDirective = "if {[info commands family] ne \"\"} {\n    family %s\n}\n"
+
+	luaExtension = ".lua"
 )
 
-// Generate the file required to be able to use module for a specific software component.
-// envVars specifies all the environment variables that needs to be set
-// envLayout specifies the various environment variable to be preprended, the key is the target (e.g., PATH or LD_LIBRARY_PATH), the values path to a install directory
-func Generate(path, copyright, customEnvVarPrefix, name string, requires []string, conflicts []string, vars map[string]string, envVars map[string]string, envLayout map[string][]string) error {
-	modulefilePath := filepath.Join(path, name)
+// StackInfo is the metadata of the stack a modulefile belongs to
+type StackInfo struct {
+	// Name is the name of the stack
+	Name string
+
+	// System is the system targeted by the stack, e.g., host
+	System string
+
+	// Type is the type of the stack, i.e., private or public
+	Type string
+
+	// Dir is the base directory of the stack
+	Dir string
+}
+
+// Modulefile gathers the content of the modulefile of a software component. It is also the data
+// available to modulefile templates, e.g., {{.Name}} or {{range $k, $v := .EnvVars}}.
+type Modulefile struct {
+	// Name is the name of the module, e.g., ompi
+	Name string
+
+	// Version is the version of the software component, if known
+	Version string
+
+	// Stack is the metadata of the stack the module belongs to
+	Stack StackInfo
+
+	// Copyright is the copyright included at the beginning of the modulefile, as Tcl comments, i.e., lines starting with '#'
+	Copyright string
+
+	// Whatis is the lines describing the software component, e.g., "Description: Open MPI", displayed by 'module whatis'
+	Whatis []string
+
+	// Requires is the list of modules to load with the module
+	Requires []string
+
+	// Conflicts is the list of modules that cannot be loaded at the same time, e.g., other versions of the same software
+	Conflicts []string
+
+	// Family is, when not empty, the family of the module (e.g., mpi) so that module systems supporting families swap modules of the same family
+	Family string
+
+	// Vars is the set of variables local to the modulefile
+	Vars map[string]string
+
+	// EnvVars specifies all the environment variables that needs to be set, with their final names, e.g., HPCX_OMPI_DIR
+	EnvVars map[string]string
+
+	// EnvLayout specifies the various environment variable to be preprended, the key is the target (e.g., PATH or LD_LIBRARY_PATH), the values path to a install directory
+	EnvLayout map[string][]string
+}
+
+// tclQuote returns a string as a Tcl word between double quotes, in which substitutions are disabled
+func tclQuote(s string) string {
+	var quoted strings.Builder
+	quoted.WriteString("\"")
+	for _, r := range s {
+		switch r {
+		case '"', '\\', '$', '[', ']':
+			quoted.WriteRune('\\')
+		}
+		quoted.WriteRune(r)
+	}
+	quoted.WriteString("\"")
+	return quoted.String()
+}
 
+func (m *Modulefile) tcl() string {
 	content := modulePrelude
 
-	content += copyright + "\n\n"
+	content += m.Copyright + "\n\n"
+
+	if len(m.Whatis) > 0 {
+		for _, line := range m.Whatis {
+			content += whatisKeyword + tclQuote(line) + "\n"
+		}
+		content += "\n"
+	}
 
-	for _, dep := range requires {
+	for _, dep := range m.Requires {
 		content += requireKeyword + dep + "\n"
 	}
 
 	content += "\n"
 
-	for _, conflict := range conflicts {
+	for _, conflict := range m.Conflicts {
 		content += conflictKeyword + conflict + "\n"
 	}
 
+	if m.Family != "" {
+		content += fmt.Sprintf(familyDirective, m.Family)
+	}
+
 	content += "\n"
 
-	for varName, varValue := range vars {
+	for varName, varValue := range m.Vars {
 		content += setKeyword + varName + " " + varValue + "\n"
 	}
 
 	content += "\n"
 
-	for varName, varValue := range envVars {
-		if customEnvVarPrefix == "" {
-			content += setenvKeyword + varName + " " + varValue + "\n"
-		} else {
-			if strings.HasPrefix(varName, customEnvVarPrefix) {
-				content += setenvKeyword + varName + " " + varValue + "\n"
-			} else {
-				content += setenvKeyword + customEnvVarPrefix + varName + " " + varValue + "\n"
-			}
-		}
+	for varName, varValue := range m.EnvVars {
+		content += setenvKeyword + varName + " " + varValue + "\n"
 	}
 
 	content += "\n"
 
-	for envvar, paths := range envLayout {
+	for envvar, paths := range m.EnvLayout {
 		for _, path := range paths {
 			content += prependPathKeyword + envvar + " " + path + "\n"
 		}
 	}
 
-	err := ioutil.WriteFile(modulefilePath, []byte(content), defaultPermission)
+	return content
+}
+
+func (m *Modulefile) lua() string {
+	var content string
+
+	// Tcl comments of the copyright are converted into Lua comments
+	for _, line := range strings.Split(m.Copyright, "\n") {
+		if strings.HasPrefix(line, "#") {
+			line = "--" + strings.TrimPrefix(line, "#")
+		}
+		content += line + "\n"
+	}
+
+	content += "\n"
+
+	if len(m.Whatis) > 0 {
+		for _, line := range m.Whatis {
+			content += fmt.Sprintf("whatis(%q)\n", line)
+		}
+		content += "\n"
+	}
+
+	for _, dep := range m.Requires {
+		content += fmt.Sprintf("load(%q)\n", dep)
+	}
+
+	content += "\n"
+
+	for _, conflict := range m.Conflicts {
+		content += fmt.Sprintf("conflict(%q)\n", conflict)
+	}
+
+	if m.Family != "" {
+		content += fmt.Sprintf("family(%q)\n", m.Family)
+	}
+
+	content += "\n"
+
+	for varName, varValue := range m.Vars {
+		content += fmt.Sprintf("local %s = %q\n", varName, varValue)
+	}
+
+	content += "\n"
+
+	for varName, varValue := range m.EnvVars {
+		content += fmt.Sprintf("setenv(%q, %q)\n", varName, varValue)
+	}
+
+	content += "\n"
+
+	for envvar, paths := range m.EnvLayout {
+		for _, path := range paths {
+			content += fmt.Sprintf("prepend_path(%q, %q)\n", envvar, path)
+		}
+	}
+
+	return content
+}
+
+// Content returns the default content of the modulefile in a given dialect, e.g., for templates that only
+// add site-specific boilerplate around it with {{.Content "tcl"}}
+func (m *Modulefile) Content(dialect Dialect) (string, error) {
+	switch dialect {
+	case DialectTcl, "":
+		return m.tcl(), nil
+	case DialectLua:
+		return m.lua(), nil
+	}
+	return "", fmt.Errorf("unsupported modulefile dialect: %s", dialect)
+}
+
+func getModulefilePath(path string, dialect Dialect, name string) string {
+	modulefilePath := filepath.Join(path, name)
+	if dialect == DialectLua {
+		modulefilePath += luaExtension
+	}
+	return modulefilePath
+}
+
+// Generate the file required to be able to use module for a specific software component, in a given
+// dialect. Lua modulefiles have the .lua extension as required by Lmod.
+func Generate(path string, dialect Dialect, m *Modulefile) error {
+	content, err := m.Content(dialect)
+	if err != nil {
+		return err
+	}
+
+	modulefilePath := getModulefilePath(path, dialect, m.Name)
+	err = ioutil.WriteFile(modulefilePath, []byte(content), defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to write content of %s: %w", modulefilePath, err)
+	}
+	return nil
+}
+
+// LoadTemplate parses a modulefile template, i.e., a text/template executed with a Modulefile
+func LoadTemplate(templatePath string) (*template.Template, error) {
+	tmpl, err := template.ParseFiles(templatePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse template %s: %w", templatePath, err)
+	}
+	return tmpl, nil
+}
+
+// GenerateFromTemplate generates the modulefile of a software component in a given dialect from a
+// template instead of the default content, e.g., to include site-specific boilerplate
+func GenerateFromTemplate(path string, dialect Dialect, tmpl *template.Template, m *Modulefile) error {
+	var content strings.Builder
+	err := tmpl.Execute(&content, m)
+	if err != nil {
+		return fmt.Errorf("unable to execute template %s for %s: %w", tmpl.Name(), m.Name, err)
+	}
+
+	modulefilePath := getModulefilePath(path, dialect, m.Name)
+	err = ioutil.WriteFile(modulefilePath, []byte(content.String()), defaultPermission)
 	if err != nil {
 		return fmt.Errorf("unable to write content of %s: %w", modulefilePath, err)
 	}
@@ -0,0 +1,47 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+// Package testutil provides the helpers shared by the tests of the packages of the module
+package testutil
+
+import (
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+// CreateLocalSoftware creates a minimal autotools-like software package that
+// can be configured, compiled and installed without network access, and returns
+// its directory. The test is skipped when make is not available.
+func CreateLocalSoftware(t *testing.T) string {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not available, skipping test")
+	}
+
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+
+	configureScript := `#!/bin/sh
+prefix=/usr/local
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--prefix) shift; prefix="$1" ;;
+		--prefix=*) prefix="${1#--prefix=}" ;;
+	esac
+	shift
+done
+printf 'PREFIX=%s\n\nall:\n\tprintf "#!/bin/sh\\necho hello\\n" > helloworld\n\tchmod +x helloworld\n\ninstall:\n\tmkdir -p $(PREFIX)/bin\n\tcp helloworld $(PREFIX)/bin/\n' "$prefix" > Makefile
+`
+	err = ioutil.WriteFile(filepath.Join(srcDir, "configure"), []byte(configureScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create configure script: %s", err)
+	}
+
+	return srcDir
+}
@@ -7,15 +7,43 @@ package app
 
 import "github.com/gvallee/go_software_build/internal/pkg/autotools"
 
+const (
+	// SourceTypeTarball is the type of source code distributed as an archive that needs to be unpacked
+	SourceTypeTarball = "tarball"
+
+	// SourceTypeGit is the type of source code hosted in a Git repository
+	SourceTypeGit = "git"
+
+	// SourceTypeDir is the type of source code available in a local directory
+	SourceTypeDir = "dir"
+
+	// SourceTypeFile is the type of source code made of a single file that must not be unpacked, e.g., an installer
+	SourceTypeFile = "file"
+)
+
 type SourceCode struct {
 	// URL is the url to use to download the app
 	URL string
 
+	// Type is the type of the source code, e.g., SourceTypeGit (optional). When not set, the type is
+	// detected from the URL, which is not always possible, e.g., for Git repositories whose URL does
+	// not end with .git or for tarballs served by a script.
+	Type string
+
 	// Branch is the specific flavor of the code to use. Directly applicable to git for example
 	Branch string
 
 	// Command to execute before checking out a branch
 	BranchCheckoutPrelude string
+
+	// Mirrors is a list of alternate URLs to use when the source code cannot be downloaded from URL (optional)
+	Mirrors []string
+
+	// Checksum is the expected SHA256 checksum of the downloaded file, e.g., a tarball (optional)
+	Checksum string
+
+	// Revision is the expected SHA of the commit checked out when getting a Git repository (optional)
+	Revision string
 }
 
 // Info gathers information about a given application
@@ -38,6 +66,9 @@ type Info struct {
 	// InstallCmd is the command to execute to install the app (in case it is not a standard command)
 	InstallCmd string
 
+	// SubDir is the subdirectory of the source tree from which the app is configured and built, e.g., contrib/tool (optional)
+	SubDir string
+
 	// Version is the version of the application to concider
 	Version string
 
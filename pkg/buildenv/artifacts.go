@@ -0,0 +1,367 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ArtifactoryServer is the type of JFrog Artifactory servers
+	ArtifactoryServer = "artifactory"
+
+	// NexusServer is the type of Sonatype Nexus (version 3) servers
+	NexusServer = "nexus"
+
+	// LatestVersion is the placeholder to use in a URL pointing at an artifact server, in place of
+	// a directory named after a version, to get the latest version available, e.g.,
+	// https://example.com/artifactory/generic-local/openmpi/[latest]/openmpi.tar.gz
+	LatestVersion = "[latest]"
+
+	// artifactoryAPIKeyHeader is the header used to authenticate with an API key on Artifactory servers
+	artifactoryAPIKeyHeader = "X-JFrog-Art-Api"
+
+	// artifactoryChecksumHeader is the header used by Artifactory to provide the SHA256 of artifacts
+	artifactoryChecksumHeader = "X-Checksum-Sha256"
+)
+
+// ArtifactServer describes an Artifactory or Nexus server hosting source code and/or exported stacks.
+// All the URLs starting with the URL of the server are handled through the server's REST API.
+// As for Credential, values may refer to environment variables, e.g., "$ARTIFACTORY_API_KEY".
+type ArtifactServer struct {
+	// Type is the type of the server, ArtifactoryServer or NexusServer
+	Type string `json:"type"`
+
+	// URL is the base URL of the server, e.g., https://example.com/artifactory for Artifactory or
+	// https://example.com/repository for Nexus; the repository name comes right after it in artifact URLs
+	URL string `json:"url"`
+
+	// APIURL is the base URL of the REST API of a Nexus server, e.g., https://example.com. When not set, it
+	// is deduced from URL. Not used for Artifactory servers.
+	APIURL string `json:"api_url"`
+
+	// APIKey is the API key to use with an Artifactory server (optional)
+	APIKey string `json:"api_key"`
+
+	// Username is the user name for basic authentication, e.g., the name of a Nexus user token (optional)
+	Username string `json:"username"`
+
+	// Password is the password for basic authentication, e.g., the passcode of a Nexus user token (optional)
+	Password string `json:"password"`
+
+	// env is the build environment the server is used from
+	env *Info
+}
+
+// getArtifactServer returns the artifact server handling a URL, nil if the URL is not handled by any server
+func (env *Info) getArtifactServer(url string) *ArtifactServer {
+	for idx := range env.ArtifactServers {
+		s := env.ArtifactServers[idx]
+		if s.URL != "" && strings.HasPrefix(url, strings.TrimSuffix(s.URL, "/")+"/") {
+			s.env = env
+			return &s
+		}
+	}
+	return nil
+}
+
+// hasCredentials returns whether credentials are specified for the server
+func (s *ArtifactServer) hasCredentials() bool {
+	return s.APIKey != "" || s.Username != "" || s.Password != ""
+}
+
+// do authenticates and sends a request to the server, with the HTTP client of the build environment. Credentials
+// are only sent over TLS with a verified certificate.
+func (s *ArtifactServer) do(req *http.Request) (*http.Response, error) {
+	if s.hasCredentials() {
+		err := s.env.checkSecureRequest(req)
+		if err != nil {
+			return nil, err
+		}
+		s.authenticate(req)
+	}
+	client, err := s.env.getHTTPClient()
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// authenticate adds the credentials of the server to a request and returns the name of the custom headers that were added
+func (s *ArtifactServer) authenticate(req *http.Request) []string {
+	var headers []string
+	if s.Username != "" || s.Password != "" {
+		req.SetBasicAuth(os.ExpandEnv(s.Username), os.ExpandEnv(s.Password))
+	}
+	if s.APIKey != "" && s.Type == ArtifactoryServer {
+		req.Header.Set(artifactoryAPIKeyHeader, os.ExpandEnv(s.APIKey))
+		headers = append(headers, artifactoryAPIKeyHeader)
+	}
+	return headers
+}
+
+// splitURL returns the repository and the path within the repository of an artifact URL
+func (s *ArtifactServer) splitURL(url string) (string, string, error) {
+	relPath := strings.TrimPrefix(url, strings.TrimSuffix(s.URL, "/")+"/")
+	tokens := strings.SplitN(relPath, "/", 2)
+	if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+		return "", "", fmt.Errorf("invalid artifact URL %s: repository or path is missing", url)
+	}
+	return tokens[0], tokens[1], nil
+}
+
+// getAPIURL returns the base URL of the REST API of the server
+func (s *ArtifactServer) getAPIURL() string {
+	if s.APIURL != "" {
+		return strings.TrimSuffix(s.APIURL, "/")
+	}
+	baseURL := strings.TrimSuffix(s.URL, "/")
+	if s.Type == NexusServer {
+		return strings.TrimSuffix(baseURL, "/repository")
+	}
+	return baseURL
+}
+
+// getJSON sends a GET request to the server and decodes the JSON answer
+func (s *ArtifactServer) getJSON(ctx context.Context, url string, data interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid request for %s: %w", url, err)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get %s: %s", url, resp.Status)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read answer from %s: %w", url, err)
+	}
+	err = json.Unmarshal(content, data)
+	if err != nil {
+		return fmt.Errorf("invalid answer from %s: %w", url, err)
+	}
+	return nil
+}
+
+// nexusAsset is an asset as returned by the search API of Nexus
+type nexusAsset struct {
+	Path     string            `json:"path"`
+	Checksum map[string]string `json:"checksum"`
+}
+
+// searchNexusAssets returns all the assets of a Nexus repository matching a query, e.g., "group=/foo"
+func (s *ArtifactServer) searchNexusAssets(ctx context.Context, repo string, query string) ([]nexusAsset, error) {
+	var assets []nexusAsset
+	continuationToken := ""
+	for {
+		url := s.getAPIURL() + "/service/rest/v1/search/assets?repository=" + neturl.QueryEscape(repo) + "&" + query
+		if continuationToken != "" {
+			url += "&continuationToken=" + neturl.QueryEscape(continuationToken)
+		}
+		var page struct {
+			Items             []nexusAsset `json:"items"`
+			ContinuationToken string       `json:"continuationToken"`
+		}
+		err := s.getJSON(ctx, url, &page)
+		if err != nil {
+			return nil, err
+		}
+		assets = append(assets, page.Items...)
+		if page.ContinuationToken == "" {
+			return assets, nil
+		}
+		continuationToken = page.ContinuationToken
+	}
+}
+
+// listVersions returns the name of all the subdirectories of a directory of a repository
+func (s *ArtifactServer) listVersions(ctx context.Context, repo string, dir string) ([]string, error) {
+	var versions []string
+	switch s.Type {
+	case ArtifactoryServer:
+		var folder struct {
+			Children []struct {
+				URI    string `json:"uri"`
+				Folder bool   `json:"folder"`
+			} `json:"children"`
+		}
+		err := s.getJSON(ctx, s.getAPIURL()+"/api/storage/"+repo+"/"+dir, &folder)
+		if err != nil {
+			return nil, err
+		}
+		for _, child := range folder.Children {
+			if child.Folder {
+				versions = append(versions, strings.TrimPrefix(child.URI, "/"))
+			}
+		}
+	case NexusServer:
+		// Nexus does not provide a directory listing, the versions are deduced from the path of the assets
+		assets, err := s.searchNexusAssets(ctx, repo, "group="+neturl.QueryEscape("/"+dir+"/*"))
+		if err != nil {
+			return nil, err
+		}
+		seen := make(map[string]bool)
+		for _, asset := range assets {
+			relPath := strings.TrimPrefix(strings.TrimPrefix(asset.Path, "/"), dir+"/")
+			tokens := strings.SplitN(relPath, "/", 2)
+			if len(tokens) == 2 && !seen[tokens[0]] {
+				seen[tokens[0]] = true
+				versions = append(versions, tokens[0])
+			}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported type of artifact server: %s", s.Type)
+	}
+	return versions, nil
+}
+
+// compareVersions compares two version strings such as 1.10.2 and 1.9, numerical components
+// being compared as numbers. It returns a negative value if a < b, 0 if a == b and a positive value if a > b.
+func compareVersions(a string, b string) int {
+	split := func(v string) []string {
+		return strings.FieldsFunc(v, func(r rune) bool { return r == '.' || r == '-' || r == '_' })
+	}
+	tokensA := split(strings.TrimPrefix(a, "v"))
+	tokensB := split(strings.TrimPrefix(b, "v"))
+	for i := 0; i < len(tokensA) && i < len(tokensB); i++ {
+		numA, errA := strconv.Atoi(tokensA[i])
+		numB, errB := strconv.Atoi(tokensB[i])
+		switch {
+		case errA == nil && errB == nil:
+			if numA != numB {
+				return numA - numB
+			}
+		case tokensA[i] != tokensB[i]:
+			return strings.Compare(tokensA[i], tokensB[i])
+		}
+	}
+	return len(tokensA) - len(tokensB)
+}
+
+// resolveLatest replaces the LatestVersion placeholder of an artifact URL, if any, with the latest version available
+func (s *ArtifactServer) resolveLatest(ctx context.Context, url string) (string, error) {
+	idx := strings.Index(url, "/"+LatestVersion)
+	if idx == -1 {
+		return url, nil
+	}
+	repo, dir, err := s.splitURL(url[:idx])
+	if err != nil {
+		return "", err
+	}
+	versions, err := s.listVersions(ctx, repo, dir)
+	if err != nil {
+		return "", fmt.Errorf("unable to get the versions available for %s: %w", url, err)
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no version available for %s", url)
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+	latest := versions[len(versions)-1]
+	log.Printf("-> Latest version for %s is %s", url, latest)
+	return strings.Replace(url, LatestVersion, latest, 1), nil
+}
+
+// getChecksum returns the SHA256 of an artifact as advertised by the server, an empty string if not available
+func (s *ArtifactServer) getChecksum(ctx context.Context, url string) (string, error) {
+	switch s.Type {
+	case ArtifactoryServer:
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return "", fmt.Errorf("invalid request for %s: %w", url, err)
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return "", fmt.Errorf("unable to get %s: %w", url, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("unable to get %s: %s", url, resp.Status)
+		}
+		return resp.Header.Get(artifactoryChecksumHeader), nil
+	case NexusServer:
+		repo, artifactPath, err := s.splitURL(url)
+		if err != nil {
+			return "", err
+		}
+		assets, err := s.searchNexusAssets(ctx, repo, "name="+neturl.QueryEscape(artifactPath))
+		if err != nil {
+			return "", err
+		}
+		for _, asset := range assets {
+			if strings.TrimPrefix(asset.Path, "/") == artifactPath {
+				return asset.Checksum["sha256"], nil
+			}
+		}
+		return "", nil
+	default:
+		return "", fmt.Errorf("unsupported type of artifact server: %s", s.Type)
+	}
+}
+
+// Publish uploads a file, e.g., an exported stack, to an artifact server. If url ends with a '/', the
+// name of the file is appended to it. The checksum of the file is sent along so the server can check
+// the integrity of the upload.
+func (env *Info) Publish(filePath string, url string) error {
+	s := env.getArtifactServer(url)
+	if s == nil {
+		return fmt.Errorf("%s does not point at any known artifact server", url)
+	}
+	if strings.HasSuffix(url, "/") {
+		url += path.Base(filePath)
+	}
+
+	checksum, err := FileChecksum(filePath)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", filePath, err)
+	}
+	defer f.Close()
+	fileInfo, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", filePath, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPut, url, f)
+	if err != nil {
+		return fmt.Errorf("invalid request for %s: %w", url, err)
+	}
+	req.ContentLength = fileInfo.Size()
+	if s.Type == ArtifactoryServer {
+		req.Header.Set(artifactoryChecksumHeader, checksum)
+	}
+
+	log.Printf("-> Publishing %s to %s", filePath, url)
+	resp, err := s.do(req)
+	if err != nil {
+		return fmt.Errorf("unable to upload %s to %s: %w", filePath, url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("unable to upload %s to %s: %s - %s", filePath, url, resp.Status, string(body))
+	}
+	return nil
+}
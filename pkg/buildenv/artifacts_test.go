@@ -0,0 +1,183 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gvallee/go_software_build/pkg/app"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a      string
+		b      string
+		result int
+	}{
+		{"1.10.2", "1.9", 1},
+		{"1.9", "1.10.2", -1},
+		{"v2.0", "2.0", 0},
+		{"2.0", "2.0.1", -1},
+	}
+	for _, tt := range tests {
+		res := compareVersions(tt.a, tt.b)
+		if (res < 0 && tt.result >= 0) || (res > 0 && tt.result <= 0) || (res == 0 && tt.result != 0) {
+			t.Fatalf("compareVersions(%s, %s) returned %d", tt.a, tt.b, res)
+		}
+	}
+}
+
+func TestArtifactory(t *testing.T) {
+	content := []byte("artifact content")
+	hash := sha256.Sum256(content)
+	checksum := hex.EncodeToString(hash[:])
+	var uploaded []byte
+	var uploadedChecksum string
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-JFrog-Art-Api") != "mykey" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.URL.Path == "/artifactory/api/storage/generic/openmpi":
+			w.Write([]byte(`{"children": [{"uri": "/4.1.9", "folder": true}, {"uri": "/4.1.10", "folder": true}, {"uri": "/README", "folder": false}]}`))
+		case r.URL.Path == "/artifactory/generic/openmpi/4.1.10/openmpi.tar.gz" && r.Method != http.MethodPut:
+			w.Header().Set("X-Checksum-Sha256", checksum)
+			w.Write(content)
+		case r.Method == http.MethodPut:
+			uploadedChecksum = r.Header.Get("X-Checksum-Sha256")
+			uploaded, _ = ioutil.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	os.Setenv("TEST_ARTIFACTORY_KEY", "mykey")
+	defer os.Unsetenv("TEST_ARTIFACTORY_KEY")
+	var env Info
+	env.SrcDir = testDir
+	env.CABundle = writeCABundle(t, server, testDir)
+	env.ArtifactServers = []ArtifactServer{{Type: ArtifactoryServer, URL: server.URL + "/artifactory", APIKey: "$TEST_ARTIFACTORY_KEY"}}
+
+	var a app.Info
+	a.Name = "openmpi"
+	a.Source.URL = server.URL + "/artifactory/generic/openmpi/" + LatestVersion + "/openmpi.tar.gz"
+	err = env.download(context.Background(), &a)
+	if err != nil {
+		t.Fatalf("download() failed: %s", err)
+	}
+	downloaded, err := ioutil.ReadFile(env.SrcPath)
+	if err != nil || string(downloaded) != string(content) {
+		t.Fatalf("invalid downloaded file %s", env.SrcPath)
+	}
+
+	err = env.Publish(env.SrcPath, server.URL+"/artifactory/exports/")
+	if err != nil {
+		t.Fatalf("Publish() failed: %s", err)
+	}
+	if string(uploaded) != string(content) || uploadedChecksum != checksum {
+		t.Fatalf("invalid upload")
+	}
+
+	err = env.Publish(env.SrcPath, "https://unknown.example.com/")
+	if err == nil {
+		t.Fatalf("Publish() succeeded with an unknown server")
+	}
+}
+
+func TestNexus(t *testing.T) {
+	content := []byte("artifact content")
+	hash := sha256.Sum256(content)
+	checksum := hex.EncodeToString(hash[:])
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if !ok || user != "token" || password != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/service/rest/v1/search/assets":
+			type asset struct {
+				Path     string            `json:"path"`
+				Checksum map[string]string `json:"checksum"`
+			}
+			var page struct {
+				Items             []asset `json:"items"`
+				ContinuationToken string  `json:"continuationToken,omitempty"`
+			}
+			switch {
+			case r.URL.Query().Get("group") == "/ucx/*" && r.URL.Query().Get("continuationToken") == "":
+				page.Items = []asset{{Path: "ucx/1.9.0/ucx.tar.gz"}}
+				page.ContinuationToken = "next"
+			case r.URL.Query().Get("group") == "/ucx/*":
+				page.Items = []asset{{Path: "ucx/1.14.1/ucx.tar.gz"}}
+			case r.URL.Query().Get("name") == "ucx/1.14.1/ucx.tar.gz":
+				page.Items = []asset{{Path: "ucx/1.14.1/ucx.tar.gz", Checksum: map[string]string{"sha256": checksum}}}
+			}
+			json.NewEncoder(w).Encode(&page)
+		case "/repository/raw/ucx/1.14.1/ucx.tar.gz":
+			w.Write(content)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var env Info
+	env.SrcDir = testDir
+	env.CABundle = writeCABundle(t, server, testDir)
+	env.ArtifactServers = []ArtifactServer{{Type: NexusServer, URL: server.URL + "/repository", Username: "token", Password: "secret"}}
+
+	var a app.Info
+	a.Name = "ucx"
+	a.Source.URL = server.URL + "/repository/raw/ucx/" + LatestVersion + "/ucx.tar.gz"
+	err = env.download(context.Background(), &a)
+	if err != nil {
+		t.Fatalf("download() failed: %s", err)
+	}
+	if filepath.Base(env.SrcPath) != "ucx.tar.gz" {
+		t.Fatalf("invalid downloaded file %s", env.SrcPath)
+	}
+
+	// A local file that does not match the checksum advertised by the server must be replaced
+	err = ioutil.WriteFile(env.SrcPath, []byte("corrupted"), 0644)
+	if err != nil {
+		t.Fatalf("unable to modify %s: %s", env.SrcPath, err)
+	}
+	err = env.download(context.Background(), &a)
+	if err != nil {
+		t.Fatalf("download() failed: %s", err)
+	}
+	downloaded, err := ioutil.ReadFile(env.SrcPath)
+	if err != nil || string(downloaded) != string(content) {
+		t.Fatalf("corrupted file was not downloaded again")
+	}
+}
@@ -11,24 +11,36 @@ package buildenv
 
 import (
 	"bytes"
+	"context"
 	"fmt"
-	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/gvallee/go_exec/pkg/advexec"
 	"github.com/gvallee/go_software_build/pkg/app"
+	"github.com/gvallee/go_software_build/pkg/metrics"
 	"github.com/gvallee/go_util/pkg/util"
 )
 
 const (
 	defaultDirMode = 0755
+
+	// DefaultEscalationCmd is the command used by default to execute commands with elevated privileges
+	DefaultEscalationCmd = "sudo"
+
+	// MakeInstallStage is the stage of RunMake() installing the software
+	MakeInstallStage = "install"
 )
 
+// EscalateFn is the function prototype to transform a command (binary and arguments) into a command
+// executed with elevated privileges; it returns the binary and arguments to actually execute.
+type EscalateFn func(binPath string, args []string) (string, []string, error)
+
 // Info gathers the details of the build environment
 type Info struct {
 	// SrcPath is the path to the downloaded tarball
@@ -51,18 +63,177 @@ type Info struct {
 	// This value is part of the build environment configuration
 	BuildDir string
 
+	// DestDir is, when set, the staging directory the software is installed in, i.e., the DESTDIR of the
+	// installation: files meant to be installed in <prefix> are installed in <DestDir>/<prefix> (optional)
+	DestDir string
+
+	// InstallVersion is, when set, the version of the software being installed in the environment, which is then
+	// installed in <InstallDir>/<name>/<InstallVersion> instead of <InstallDir>/<name> so that several versions
+	// of the software can coexist (optional)
+	InstallVersion string
+
+	// InstallHash is, when set, the hash of the configuration of the software being installed in the environment,
+	// which is then installed in <InstallDir>/<name>-<InstallHash>, taking precedence over InstallVersion, so that
+	// several configurations of the software can coexist (optional)
+	InstallHash string
+
+	// OptimizationProfile is the name of the optimization profile whose flags are injected into the environment,
+	// e.g., x86-64-v3. It is set by SetOptimizationProfile().
+	OptimizationProfile string
+
+	// BuildType is the build type the software is built with, e.g., debug, which sets the compiler flags and
+	// CMAKE_BUILD_TYPE or the meson build type. It is set by SetBuildType().
+	BuildType string
+
+	// Sanitizers is the sanitizers the software is instrumented with, e.g., address. It is set by SetSanitizers().
+	Sanitizers []string
+
 	// Env is the environment to use with the build environment
-	Env []string
+	Env Env
 
 	// ConfigureExtraArgs is the extra arguments to use when running configure
 	ConfigureExtraArgs []string
 
 	// MakeExtraArgs is the extra arguments to use when running make
 	MakeExtraArgs []string
+
+	// MakeVars is the variables to set on the make command line, e.g., CC=gcc. Unlike variables from the environment,
+	// they override the assignments of the Makefile.
+	MakeVars map[string]string
+
+	// Limits is the resource limits of the build commands, e.g., niceness or maximum number of parallel jobs
+	Limits ResourceLimits
+
+	// Isolation is, when set, how the build commands are isolated from the host, e.g., to detect undeclared
+	// dependencies. It relies on bubblewrap (optional)
+	Isolation *Isolation
+
+	// BuildTargets is the list of make targets to build the software, e.g., "all docs". The default target is used when empty.
+	BuildTargets []string
+
+	// InstallTargets is the list of make targets to install the software, e.g., "install-strip". "install" is used when empty.
+	InstallTargets []string
+
+	// EscalationCmd is the command used to execute commands requiring elevated privileges, e.g., "doas" or "sudo -A".
+	// When empty, DefaultEscalationCmd is used.
+	EscalationCmd string
+
+	// Escalate is an optional callback to transform commands requiring elevated privileges.
+	// When set, it takes precedence over EscalationCmd.
+	Escalate EscalateFn
+
+	// SrcChecksum is the SHA256 checksum of the source code when it is a file, e.g., a tarball
+	// This value is set by the tool after getting the package's source code
+	SrcChecksum string
+
+	// SrcRevision is the SHA of the commit checked out when the source code comes from a Git repository
+	// This value is set by the tool after getting the package's source code
+	SrcRevision string
+
+	// MirrorRewrites is a set of rules to rewrite URLs in order to use an organization-wide mirror.
+	// The key is a URL prefix, e.g., "https://github.com/", and the value the prefix to use instead.
+	// A rewritten URL is tried before the original URL and the application's mirrors.
+	MirrorRewrites map[string]string
+
+	// Credentials are the credentials used to download files, based on their URL (optional)
+	Credentials []Credential
+
+	// NetrcFile is the path to the netrc file providing the credentials for the hosts without an entry in Credentials.
+	// When not set, the file specified by the NETRC environment variable or ~/.netrc is used if it exists.
+	NetrcFile string
+
+	// CABundle is the path to a PEM file with the certificates of additional authorities to trust when downloading
+	// files, e.g., the internal authority of an organization (optional)
+	CABundle string
+
+	// InsecureTLS specifies whether the certificates of the servers are not verified when downloading files, e.g.,
+	// for servers with self-signed certificates. Credentials are never sent in this mode. (optional)
+	InsecureTLS bool
+
+	// GitLabHosts are the host names of the self-hosted GitLab instances, e.g., gitlab.example.com, whose projects
+	// can be used to resolve versions and get release assets; only gitlab.com is supported otherwise (optional)
+	GitLabHosts []string
+
+	// Fetchers are fetchers specific to the environment, tried before the fetchers registered with RegisterFetcher() (optional)
+	Fetchers []Fetcher
+
+	// ArtifactServers are the Artifactory and Nexus servers to get source code from or to publish files to (optional)
+	ArtifactServers []ArtifactServer
+
+	// ConfigureCacheDir is the directory where configure cache files are stored, one per toolchain (optional).
+	// When set, the results of configure are shared by all the autotools packages built with the same toolchain.
+	ConfigureCacheDir string
+
+	// GitCacheDir is the directory where bare copies of the Git repositories are cached (optional).
+	// When set, repositories are cloned from the cache, which is updated first, so that only the
+	// changes since the last build are fetched from the network.
+	GitCacheDir string
+
+	// SkipUpdate specifies whether source code that was already fetched, e.g., a Git checkout, is used as-is
+	// instead of being updated
+	SkipUpdate bool
+
+	// Runner executes the commands of the build environment, e.g., to mock them in tests. HostRunner is used
+	// when nil (optional).
+	Runner Runner
+
+	// FS is the file system of the build environment. HostFS is used when nil (optional).
+	FS FS
+}
+
+// escalate returns the binary and arguments to use to execute a command with elevated privileges
+func (env *Info) escalate(binPath string, args []string) (string, []string, error) {
+	if env.Escalate != nil {
+		return env.Escalate(binPath, args)
+	}
+
+	escalationCmd := env.EscalationCmd
+	if escalationCmd == "" {
+		escalationCmd = DefaultEscalationCmd
+	}
+	tokens := strings.Fields(escalationCmd)
+	if len(tokens) == 0 {
+		return "", nil, fmt.Errorf("invalid escalation command: %s", env.EscalationCmd)
+	}
+	escalationBin, err := env.GetRunner().LookPath(tokens[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find the %s binary: %w", tokens[0], err)
+	}
+	escalatedArgs := append([]string{}, tokens[1:]...)
+	escalatedArgs = append(escalatedArgs, binPath)
+	escalatedArgs = append(escalatedArgs, args...)
+	return escalationBin, escalatedArgs, nil
 }
 
 // Unpack extracts the source code from a package/tarball/zip file.
+// When the application is built from a subdirectory (see app.Info.SubDir), env.SrcDir points at it once unpacked.
 func (env *Info) Unpack(appInfo *app.Info) error {
+	err := env.unpack(appInfo)
+	if err != nil {
+		return err
+	}
+	return env.enterSubDir(appInfo)
+}
+
+// enterSubDir updates the source directory when the application is built from a subdirectory of its source tree
+func (env *Info) enterSubDir(appInfo *app.Info) error {
+	if appInfo.SubDir == "" {
+		return nil
+	}
+	subDir := filepath.Clean(appInfo.SubDir)
+	if filepath.IsAbs(subDir) || subDir == ".." || strings.HasPrefix(subDir, "../") {
+		return fmt.Errorf("invalid subdirectory %s, it must be relative to the source tree", appInfo.SubDir)
+	}
+	srcDir := filepath.Join(env.SrcDir, subDir)
+	if !util.IsDir(srcDir) {
+		return fmt.Errorf("%s does not exist in the source tree of %s", appInfo.SubDir, appInfo.Name)
+	}
+	env.SrcDir = srcDir
+	log.Printf("-> SrcDir is now %s", env.SrcDir)
+	return nil
+}
+
+func (env *Info) unpack(appInfo *app.Info) error {
 	log.Println("- Unpacking software...")
 
 	// Sanity checks
@@ -83,8 +254,16 @@ func (env *Info) Unpack(appInfo *app.Info) error {
 		}
 	*/
 
+	if appInfo.Source.Type == app.SourceTypeFile || appInfo.Source.Type == app.SourceTypeGit || appInfo.Source.Type == app.SourceTypeDir {
+		log.Printf("%s does not need to be unpacked (%s), skipping...", env.SrcPath, appInfo.Source.Type)
+		return nil
+	}
+
 	// Figure out the extension of the tarball
 	format := util.DetectTarballFormat(srcObject)
+	if format == "" && appInfo.Source.Type == app.SourceTypeTarball {
+		return fmt.Errorf("unable to detect the format of %s, the name of the file must have a known extension", srcObject)
+	}
 	if format == "" {
 		// A typical use case here is a single file that just needs to be compiled
 		log.Printf("%s does not seem to need to be unpacked (unsupported format?), skipping...", env.SrcDir)
@@ -93,7 +272,7 @@ func (env *Info) Unpack(appInfo *app.Info) error {
 
 	// At the moment we always assume we have to use the tar command
 	// (and it is a fair assumption for our current context)
-	tarPath, err := exec.LookPath("tar")
+	tarPath, err := env.GetRunner().LookPath("tar")
 	if err != nil {
 		return fmt.Errorf("tar is not available: %w", err)
 	}
@@ -110,13 +289,22 @@ func (env *Info) Unpack(appInfo *app.Info) error {
 	cmd.Dir = env.SrcDir
 	cmd.Stderr = &stderr
 	cmd.Stdout = &stdout
-	err = cmd.Run()
+	err = env.GetRunner().Run(cmd)
 	if err != nil {
 		return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
 	}
 
+	// When possible, we get the directory created while untaring the tarball from its content,
+	// which is safe even when the source directory is shared with other packages
+	topDir := env.getTarballTopDir(tarPath, tarArg, srcObject)
+	if topDir != "" && env.isDir(filepath.Join(env.SrcDir, topDir)) {
+		env.SrcDir = filepath.Join(env.SrcDir, topDir)
+		log.Printf("-> SrcDir is now %s", env.SrcDir)
+		return nil
+	}
+
 	// We save the directory created while untaring the tarball
-	entries, err := ioutil.ReadDir(env.SrcDir)
+	entries, err := env.GetFS().ReadDir(env.SrcDir)
 	if err != nil {
 		return fmt.Errorf("failed to read directory %s: %w", env.SrcDir, err)
 	}
@@ -139,47 +327,162 @@ func (env *Info) Unpack(appInfo *app.Info) error {
 	return nil
 }
 
-// RunMake executes the appropriate command to build the software
+// getTarballTopDir returns the name of the unique top-level directory of a tarball, an empty string
+// if it cannot be figured out or if the tarball does not have a unique top-level directory
+func (env *Info) getTarballTopDir(tarPath string, tarExtractArg string, tarball string) string {
+	listArg := strings.Replace(tarExtractArg, "x", "t", 1)
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(tarPath, listArg, tarball)
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+	err := env.GetRunner().Run(cmd)
+	if err != nil {
+		return ""
+	}
+
+	topDir := ""
+	for _, entry := range strings.Split(stdout.String(), "\n") {
+		entry = strings.TrimPrefix(entry, "./")
+		if entry == "" {
+			continue
+		}
+		dir := strings.Split(entry, "/")[0]
+		if topDir == "" {
+			topDir = dir
+		}
+		if dir != topDir {
+			return ""
+		}
+	}
+	return topDir
+}
+
+// getMakeTargets returns the make targets for a stage, taking into account the targets
+// specified for the build (empty stage) and install stages
+func (env *Info) getMakeTargets(stage string) []string {
+	switch {
+	case stage == "" && len(env.BuildTargets) > 0:
+		return env.BuildTargets
+	case stage == MakeInstallStage && len(env.InstallTargets) > 0:
+		return env.InstallTargets
+	case stage != "":
+		return []string{stage}
+	}
+	return nil
+}
+
+// getMakeVarsArgs returns the make arguments setting the make variables, sorted by name
+func (env *Info) getMakeVarsArgs() []string {
+	var names []string
+	for name := range env.MakeVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var args []string
+	for _, name := range names {
+		args = append(args, name+"="+env.MakeVars[name])
+	}
+	return args
+}
+
+// RunMake executes the appropriate command to build the software. The stage is the make target to
+// use, the default target when empty; the targets of the build and install stages can be overwritten
+// with BuildTargets and InstallTargets.
 func (env *Info) RunMake(sudo bool, stage string, makefilePath string, args []string) error {
+	return env.RunMakeWithResult(sudo, stage, makefilePath, args).Err
+}
+
+// RunMakeWithResult is similar to RunMake but also returns the output of make, e.g., to analyze the result of tests
+func (env *Info) RunMakeWithResult(sudo bool, stage string, makefilePath string, args []string) advexec.Result {
+	var res advexec.Result
 	// Some sanity checks
 	if env.SrcDir == "" {
-		return fmt.Errorf("env.SrcDir is undefined")
+		res.Err = fmt.Errorf("env.SrcDir is undefined")
+		return res
 	}
 
 	var makeCmd advexec.Advcmd
 	makeCmd.ManifestName = "make"
-	if stage != "" {
-		args = append(args, stage)
+	targets := env.getMakeTargets(stage)
+	if len(targets) > 0 {
+		args = append(args, targets...)
 		makeCmd.ManifestName = strings.Join(args, "_")
 	}
 
-	args = append([]string{"-j"}, args...)
-	logMsg := "make " + strings.Join(args, " ")
-	if !sudo {
-		makeCmd.BinPath = "make"
-	} else {
-		sudoBin, err := exec.LookPath("sudo")
-		logMsg = sudoBin + " " + logMsg
+	args = append([]string{env.GetJobsArg()}, args...)
+	args = append(args, env.MakeExtraArgs...)
+	args = append(args, env.getMakeVarsArgs()...)
+	makeCmd.BinPath = "make"
+	if sudo {
+		var err error
+		makeCmd.BinPath, args, err = env.escalate(makeCmd.BinPath, args)
 		if err != nil {
-			return fmt.Errorf("failed to find the sudo binary: %s", err)
+			res.Err = fmt.Errorf("unable to execute make with elevated privileges: %w", err)
+			return res
 		}
-		args = append([]string{"make"}, args...)
-		makeCmd.BinPath = sudoBin
+	}
+	var err error
+	makeCmd.BinPath, args, err = env.LimitCmd(makeCmd.BinPath, args)
+	if err != nil {
+		res.Err = fmt.Errorf("unable to limit the resources of make: %w", err)
+		return res
 	}
 	makeCmd.CmdArgs = append(makeCmd.CmdArgs, args...)
-	makeCmd.CmdArgs = append(makeCmd.CmdArgs, env.MakeExtraArgs...)
-	log.Printf("* Executing (from %s): %s", env.SrcDir, logMsg)
+	log.Printf("* Executing (from %s): %s %s", env.SrcDir, makeCmd.BinPath, strings.Join(makeCmd.CmdArgs, " "))
 	if len(env.Env) > 0 {
 		log.Printf("-> Using env: %s\n", env.Env)
 		makeCmd.Env = env.Env
 	}
 	makeCmd.ExecDir = filepath.Dir(makefilePath)
-	res := makeCmd.Run()
+	res = env.GetRunner().RunAdvcmd(&makeCmd)
 	if res.Err != nil {
-		return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", res.Err, res.Stdout, res.Stderr)
+		res.Err = fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", res.Err, res.Stdout, res.Stderr)
 	}
 
-	return nil
+	return res
+}
+
+// RunCmd executes a command from a given directory using the build environment, with elevated privileges
+// when sudo is true. When manifestDir is set, a manifest named after manifestName is created in it.
+func (env *Info) RunCmd(sudo bool, execDir string, manifestDir string, manifestName string, binPath string, args []string) error {
+	return env.RunCmdWithResult(sudo, execDir, manifestDir, manifestName, binPath, args).Err
+}
+
+// RunCmdWithResult is similar to RunCmd but also returns the output of the command
+func (env *Info) RunCmdWithResult(sudo bool, execDir string, manifestDir string, manifestName string, binPath string, args []string) advexec.Result {
+	var res advexec.Result
+	var cmd advexec.Advcmd
+	cmd.BinPath = binPath
+	if sudo {
+		var err error
+		cmd.BinPath, args, err = env.escalate(cmd.BinPath, args)
+		if err != nil {
+			res.Err = fmt.Errorf("unable to execute %s with elevated privileges: %w", binPath, err)
+			return res
+		}
+	}
+	var err error
+	cmd.BinPath, args, err = env.LimitCmd(cmd.BinPath, args)
+	if err != nil {
+		res.Err = fmt.Errorf("unable to limit the resources of %s: %w", binPath, err)
+		return res
+	}
+	cmd.CmdArgs = args
+	cmd.ExecDir = execDir
+	cmd.ManifestName = manifestName
+	cmd.ManifestDir = manifestDir
+	log.Printf("* Executing (from %s): %s %s", execDir, cmd.BinPath, strings.Join(cmd.CmdArgs, " "))
+	if len(env.Env) > 0 {
+		log.Printf("-> Using env: %s\n", env.Env)
+		cmd.Env = env.Env
+	}
+	res = env.GetRunner().RunAdvcmd(&cmd)
+	if res.Err != nil {
+		res.Err = fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", res.Err, res.Stdout, res.Stderr)
+	}
+
+	return res
 }
 
 // CopyTarball copies a tarball to a build directory
@@ -195,19 +498,17 @@ func (env *Info) copyTarball(p *app.Info) error {
 	}
 
 	targetDir := filepath.Join(env.BuildDir, p.Name)
-	if !util.PathExists(targetDir) {
-		err := os.MkdirAll(targetDir, defaultDirMode)
-		if err != nil {
-			return err
-		}
+	err := env.mkdirAll(targetDir)
+	if err != nil {
+		return err
 	}
 	targetTarballPath := filepath.Join(targetDir, p.Tarball)
 
-	if util.FileExists(targetTarballPath) {
+	if env.pathExists(targetTarballPath) {
 		log.Printf("%s already exists, not copying", targetTarballPath)
 	} else {
 		// The begining of the URL starts with 'file://' which we do not want
-		err := util.CopyFile(p.Source.URL[7:], targetTarballPath)
+		err := util.CopyFile(getLocalPath(p.Source.URL), targetTarballPath)
 		if err != nil {
 			return fmt.Errorf("cannot copy file %s to %s: %w", p.Source.URL, targetTarballPath, err)
 		}
@@ -219,9 +520,9 @@ func (env *Info) copyTarball(p *app.Info) error {
 	return nil
 }
 
-func (env *Info) gitCheckout(p *app.Info) error {
+func (env *Info) gitCheckout(ctx context.Context, p *app.Info) error {
 	// todo: should it be cached in sysCfg and passed in?
-	gitBin, err := exec.LookPath("git")
+	gitBin, err := env.GetRunner().LookPath("git")
 	if err != nil {
 		return fmt.Errorf("failed to find git: %w", err)
 	}
@@ -229,62 +530,76 @@ func (env *Info) gitCheckout(p *app.Info) error {
 	repoName := filepath.Base(p.Source.URL)
 	repoName = strings.Replace(repoName, ".git", "", 1)
 	targetDir := filepath.Join(env.BuildDir, p.Name)
-	if !util.PathExists(targetDir) {
-		err = os.MkdirAll(targetDir, defaultDirMode)
-		if err != nil {
-			return err
-		}
+	err = env.mkdirAll(targetDir)
+	if err != nil {
+		return err
 	}
 	checkoutPath := filepath.Join(targetDir, repoName)
 
-	if util.PathExists(checkoutPath) {
-		gitCmd := exec.Command(gitBin, "pull")
+	if env.pathExists(checkoutPath) && env.SkipUpdate {
+		log.Printf("%s already exists, not updating", checkoutPath)
+	} else if env.pathExists(checkoutPath) {
+		gitCmd := exec.CommandContext(ctx, gitBin, "pull")
 		log.Printf("Running from %s: %s pull\n", checkoutPath, gitBin)
 		gitCmd.Dir = checkoutPath
 		var stderr, stdout bytes.Buffer
 		gitCmd.Stderr = &stderr
 		gitCmd.Stdout = &stdout
-		err = gitCmd.Run()
+		err = env.GetRunner().Run(gitCmd)
 		if err != nil {
 			return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
 		}
 	} else {
-		gitCloneCmd := exec.Command(gitBin, "clone", p.Source.URL)
-		log.Printf("Running from %s: %s clone %s\n", env.BuildDir, gitBin, p.Source.URL)
-		gitCloneCmd.Dir = targetDir
+		cloned := false
+		if env.GitCacheDir != "" {
+			err = env.gitCloneFromCache(ctx, gitBin, p.Source.URL, targetDir, repoName)
+			if err == nil {
+				cloned = true
+			} else {
+				log.Printf("-> Unable to use the Git cache, cloning %s directly: %s", p.Source.URL, err)
+				env.GetFS().RemoveAll(checkoutPath)
+			}
+		}
 		var stderr, stdout bytes.Buffer
-		gitCloneCmd.Stderr = &stderr
-		gitCloneCmd.Stdout = &stdout
-		err = gitCloneCmd.Run()
-		if err != nil {
-			return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+		if !cloned {
+			// The name of the checkout is explicit since git does not strip the .git suffix of local files,
+			// e.g., Git bundles
+			gitCloneCmd := exec.CommandContext(ctx, gitBin, "clone", p.Source.URL, repoName)
+			log.Printf("Running from %s: %s clone %s %s\n", env.BuildDir, gitBin, p.Source.URL, repoName)
+			gitCloneCmd.Dir = targetDir
+			gitCloneCmd.Stderr = &stderr
+			gitCloneCmd.Stdout = &stdout
+			err = env.GetRunner().Run(gitCloneCmd)
+			if err != nil {
+				return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+			}
 		}
 
 		if p.Source.BranchCheckoutPrelude != "" {
 			tokens := strings.Split(p.Source.BranchCheckoutPrelude, " ")
-			cmdBin, err := exec.LookPath(tokens[0])
+			cmdBin, err := env.GetRunner().LookPath(tokens[0])
 			if err != nil {
 				return fmt.Errorf("unable to run prelude before checking out the branch, cannot find %s", tokens[0])
 			}
 
-			gitCheckoutPreludeCmd := exec.Command(cmdBin, tokens[1:]...)
+			gitCheckoutPreludeCmd := exec.CommandContext(ctx, cmdBin, tokens[1:]...)
 			log.Printf("Running from %s: %s %s\n", env.BuildDir, cmdBin, strings.Join(tokens[1:], " "))
 			gitCheckoutPreludeCmd.Dir = filepath.Join(targetDir, repoName)
 			gitCheckoutPreludeCmd.Stderr = &stderr
 			gitCheckoutPreludeCmd.Stdout = &stdout
-			err = gitCheckoutPreludeCmd.Run()
+			err = env.GetRunner().Run(gitCheckoutPreludeCmd)
 			if err != nil {
 				return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
 			}
 		}
 
 		if p.Source.Branch != "" {
-			gitCheckoutCmd := exec.Command(gitBin, "checkout", p.Source.Branch)
+			gitCheckoutCmd := exec.CommandContext(ctx, gitBin, "checkout", p.Source.Branch)
 			log.Printf("Running from %s: %s checkout %s\n", env.BuildDir, gitBin, p.Source.Branch)
 			gitCheckoutCmd.Dir = filepath.Join(targetDir, repoName)
 			gitCheckoutCmd.Stderr = &stderr
 			gitCheckoutCmd.Stdout = &stdout
-			err = gitCheckoutCmd.Run()
+			err = env.GetRunner().Run(gitCheckoutCmd)
 			if err != nil {
 				return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
 			}
@@ -300,8 +615,43 @@ func (env *Info) gitCheckout(p *app.Info) error {
 	return nil
 }
 
-// Get is the function to get a given source code
+// rewriteURL applies the mirror rewrite rules to a URL, the longest matching prefix wins.
+// An empty string is returned if no rule applies.
+func (env *Info) rewriteURL(url string) string {
+	matchingPrefix := ""
+	for prefix := range env.MirrorRewrites {
+		if strings.HasPrefix(url, prefix) && len(prefix) > len(matchingPrefix) {
+			matchingPrefix = prefix
+		}
+	}
+	if matchingPrefix == "" {
+		return ""
+	}
+	return env.MirrorRewrites[matchingPrefix] + strings.TrimPrefix(url, matchingPrefix)
+}
+
+// getSourceURLs returns the ordered list of URLs to try to get the source code of an application
+func (env *Info) getSourceURLs(p *app.Info) []string {
+	var urls []string
+	rewrittenURL := env.rewriteURL(p.Source.URL)
+	if rewrittenURL != "" {
+		urls = append(urls, rewrittenURL)
+	}
+	urls = append(urls, p.Source.URL)
+	urls = append(urls, p.Source.Mirrors...)
+	return urls
+}
+
+// Get is the function to get a given source code.
+// When the source code cannot be fetched from its URL, the mirrors are tried in order.
 func (env *Info) Get(p *app.Info) error {
+	return env.GetWithContext(context.Background(), p)
+}
+
+// GetWithContext gets a given source code, using the first fetcher supporting its URL (see Fetcher).
+// The context can be used to cancel downloads. When the source code cannot be fetched from its URL,
+// the mirrors are tried in order.
+func (env *Info) GetWithContext(ctx context.Context, p *app.Info) error {
 	log.Printf("- Getting %s from %s...\n", p.Name, p.Source.URL)
 
 	// Sanity checks
@@ -309,68 +659,66 @@ func (env *Info) Get(p *app.Info) error {
 		return fmt.Errorf("invalid Get() parameter(s)")
 	}
 
-	// Detect the type of URL, e.g., file vs. http*
-	urlFormat := util.DetectURLType(p.Source.URL)
-	if urlFormat == "" {
-		return fmt.Errorf("impossible to detect type from URL %s", p.Source.URL)
+	var errs []string
+	for _, url := range env.getSourceURLs(p) {
+		mirroredApp := *p
+		mirroredApp.Source.URL = url
+		if url != p.Source.URL && p.Tarball == "" && getURLType(p) != util.GitURL {
+			// The name of the file is always based on the original URL, regardless of the mirror being used
+			mirroredApp.Tarball = path.Base(p.Source.URL)
+		}
+		err := env.fetch(ctx, &mirroredApp)
+		if err == nil {
+			p.Tarball = mirroredApp.Tarball
+			return env.checkSource(p)
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("unable to get %s: %w", p.Name, ctx.Err())
+		}
+		log.Printf("-> Unable to get %s from %s: %s", p.Name, url, err)
+		errs = append(errs, err.Error())
 	}
 
-	switch urlFormat {
-	case util.FileURL:
-		path := p.Source.URL[7:]
-		if !util.IsDir(path) {
-			err := env.copyTarball(p)
-			if err != nil {
-				return fmt.Errorf("env.copyTarball() failed: %w", err)
-			}
-		} else {
-			// If we deal with a directory, we always copy it directly to the build directory because
-			// it is a pain to safely cache
-			targetDir := filepath.Join(env.BuildDir, p.Name)
-			if !util.PathExists(targetDir) {
-				err := os.MkdirAll(targetDir, 0755)
-				if err != nil {
-					return err
-				}
-			}
-			var cmd advexec.Advcmd
-			var err error
-			cmd.BinPath, err = exec.LookPath("cp")
-			if err != nil {
-				return fmt.Errorf("cp command not available")
-			}
-			cmd.CmdArgs = append(cmd.CmdArgs, "-rf")
-			cmd.CmdArgs = append(cmd.CmdArgs, path)
-			cmd.CmdArgs = append(cmd.CmdArgs, targetDir)
-			res := cmd.Run()
-			if res.Err != nil {
-				return fmt.Errorf("unable to copy %s into %s: %w, stdout: %s, stderr: %s", path, targetDir, res.Err, res.Stdout, res.Stderr)
-			}
+	return fmt.Errorf("unable to get %s: %s", p.Name, strings.Join(errs, "; "))
+}
+
+// checkSource computes the checksum or revision of the source code that was just fetched, respectively
+// when it is a file or a Git checkout, and when expected values are specified, checks that they match.
+func (env *Info) checkSource(p *app.Info) error {
+	env.SrcChecksum = ""
+	env.SrcRevision = ""
 
-			env.SrcPath = filepath.Join(targetDir, filepath.Base(p.Source.URL))
-			env.SrcDir = env.SrcPath
+	if env.isDir(env.SrcPath) {
+		if !env.pathExists(filepath.Join(env.SrcPath, ".git")) {
+			return nil
 		}
-	case util.HttpURL:
-		err := env.download(p)
+		revision, err := gitRevision(env.GetRunner(), env.SrcPath)
 		if err != nil {
-			return fmt.Errorf("env.download() failed, impossible to download %s: %w", p.Name, err)
+			return fmt.Errorf("unable to get the revision of %s: %w", env.SrcPath, err)
 		}
-	case util.GitURL:
-		// If we deal with a Git repository, we always clone it in the build directory because
-		// it is a pain to safely cache
-		env.SrcPath = env.BuildDir
-		err := env.gitCheckout(p)
-		if err != nil {
-			return fmt.Errorf("impossible to get Git repository %s: %s", p.Source.URL, err)
+		env.SrcRevision = revision
+		// Only tags are expected to always point at the same commit, branches move
+		if p.Source.Revision != "" && gitIsTag(env.GetRunner(), env.SrcPath, p.Source.Branch) && p.Source.Revision != revision {
+			return fmt.Errorf("revision mismatch for %s: %s is at %s instead of %s, the tag may have been moved", p.Name, p.Source.Branch, revision, p.Source.Revision)
 		}
-	default:
-		return fmt.Errorf("impossible to detect URL type: %s", p.Source.URL)
+		return nil
 	}
 
+	if !util.FileExists(env.SrcPath) {
+		return nil
+	}
+	checksum, err := FileChecksum(env.SrcPath)
+	if err != nil {
+		return err
+	}
+	env.SrcChecksum = checksum
+	if p.Source.Checksum != "" && !strings.EqualFold(p.Source.Checksum, checksum) {
+		return fmt.Errorf("checksum mismatch for %s: %s instead of %s", env.SrcPath, checksum, p.Source.Checksum)
+	}
 	return nil
 }
 
-func (env *Info) download(p *app.Info) error {
+func (env *Info) download(ctx context.Context, p *app.Info) error {
 	// Sanity checks
 	if p.Source.URL == "" {
 		return fmt.Errorf("p.URL is undefined")
@@ -380,36 +728,53 @@ func (env *Info) download(p *app.Info) error {
 		return fmt.Errorf("env.SrcPath is undefined")
 	}
 
-	if !util.PathExists(env.SrcDir) {
-		err := os.Mkdir(env.SrcDir, defaultDirMode)
+	err := env.mkdirAll(env.SrcDir)
+	if err != nil {
+		return err
+	}
+
+	url := p.Source.URL
+	checksum := p.Source.Checksum
+	artifactServer := env.getArtifactServer(url)
+	if artifactServer != nil {
+		url, err = artifactServer.resolveLatest(ctx, url)
 		if err != nil {
 			return err
 		}
+		if checksum == "" {
+			checksum, err = artifactServer.getChecksum(ctx, url)
+			if err != nil {
+				log.Printf("-> Unable to get the checksum of %s from the server: %s", url, err)
+			}
+		}
 	}
+
 	if p.Tarball == "" {
-		p.Tarball = filepath.Base(p.Source.URL)
+		p.Tarball = filepath.Base(url)
 	}
 	targetFile := filepath.Join(env.SrcDir, p.Tarball)
+	if util.FileExists(targetFile) && checksum != "" {
+		existingChecksum, err := FileChecksum(targetFile)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(existingChecksum, checksum) {
+			log.Printf("- %s already exists but does not match the expected checksum, downloading again...", targetFile)
+			err = env.GetFS().Remove(targetFile)
+			if err != nil {
+				return fmt.Errorf("unable to remove %s: %w", targetFile, err)
+			}
+		}
+	}
 	if util.FileExists(targetFile) {
 		log.Printf("- %s already exists, not downloading...", targetFile)
+		metrics.Default.Inc(metrics.CacheHitsTotal, metrics.Labels{"cache": "source"})
 	} else {
-		log.Printf("- Downloading %s from %s into %s...", p.Name, p.Source.URL, env.SrcDir)
-
-		// todo: do not assume wget
-		binPath, err := exec.LookPath("wget")
-		if err != nil {
-			return fmt.Errorf("cannot find wget: %s", err)
-		}
-
-		log.Printf("* Executing from %s: %s %s", env.SrcDir, binPath, p.Source.URL)
-		var stdout, stderr bytes.Buffer
-		cmd := exec.Command(binPath, "--no-check-certificate", p.Source.URL)
-		cmd.Dir = env.SrcDir
-		cmd.Stderr = &stderr
-		cmd.Stdout = &stdout
-		err = cmd.Run()
+		metrics.Default.Inc(metrics.CacheMissesTotal, metrics.Labels{"cache": "source"})
+		log.Printf("- Downloading %s from %s into %s...", p.Name, url, env.SrcDir)
+		err := env.downloadFile(ctx, url, targetFile, checksum)
 		if err != nil {
-			return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+			return err
 		}
 	}
 	env.SrcPath = targetFile
@@ -438,7 +803,7 @@ func getNameFromFilename(filename string) string {
 }
 
 func (env *Info) getAppInstallDirFromURL(a *app.Info) string {
-	switch util.DetectURLType(a.Source.URL) {
+	switch getURLType(a) {
 	case util.FileURL:
 		filename := path.Base(a.Source.URL)
 		filename = getNameFromFilename(filename)
@@ -470,7 +835,14 @@ func (env *Info) getTargetDir(basedir string, a *app.Info) string {
 
 // GetAppInstallDir returns the full path where a specific application is to be installed
 func (env *Info) GetAppInstallDir(a *app.Info) string {
-	return env.getTargetDir(env.InstallDir, a)
+	targetDir := env.getTargetDir(env.InstallDir, a)
+	if targetDir != "" && a.Name != "" && env.InstallHash != "" {
+		return targetDir + "-" + env.InstallHash
+	}
+	if targetDir != "" && a.Name != "" && env.InstallVersion != "" {
+		return filepath.Join(targetDir, env.InstallVersion)
+	}
+	return targetDir
 }
 
 // GetAppBuildDir returns the full path where a specific application is to be installed
@@ -481,7 +853,7 @@ func (env *Info) GetAppBuildDir(a *app.Info) string {
 // IsInstalled checks whether a specific software package is already installed in a specific build environment
 func (env *Info) IsInstalled(p *app.Info) bool {
 	installDir := env.GetAppInstallDir(p)
-	return util.PathExists(installDir)
+	return env.pathExists(installDir)
 }
 
 // GetEnvPath returns the string representing the value for the PATH environment
@@ -491,29 +863,25 @@ func (env *Info) GetEnvPath() string {
 }
 
 // GetEnvLDPath returns the string representing the value for the LD_LIBRARY_PATH
-// environment variable to use
+// environment variable to use, DYLD_LIBRARY_PATH on macOS (see LibraryPathVar)
 func (env *Info) GetEnvLDPath() string {
-	return filepath.Join(env.InstallDir, "lib") + ":" + os.Getenv("LD_LIBRARY_PATH")
+	return filepath.Join(env.InstallDir, "lib") + ":" + os.Getenv(LibraryPathVar())
 }
 
-func (env *Info) lookPath(bin string) string {
-	for _, e := range env.Env {
-		envEntry := strings.Split(e, "=")
-		if envEntry[0] == "PATH" {
-			tokens := strings.Split(envEntry[1], ":")
-			for _, t := range tokens {
-				fullPath := filepath.Join(t, bin)
-				if util.FileExists(fullPath) {
-					return fullPath
-				}
-			}
-		}
+// GetPrefixEnv returns the environment of the commands installing a software in a directory, e.g., a custom
+// install command, i.e., the build environment with PREFIX set to the installation directory
+func (env *Info) GetPrefixEnv(installDir string) Env {
+	prefixEnv := append(Env{}, env.Env...)
+	if len(prefixEnv) == 0 {
+		prefixEnv = os.Environ()
 	}
-
-	return bin
+	prefixEnv.Set("PREFIX", installDir)
+	return prefixEnv
 }
 
-// Install is a generic function to install a software
+// Install is a generic function to install a software with its own install command, e.g., './b2 install
+// --prefix=$PREFIX'. The command is executed by a shell from the source directory, so it can include quoted
+// arguments, variables and redirections, PREFIX being the directory where the software must be installed.
 func (env *Info) Install(p *app.Info) error {
 	if p.InstallCmd == "" {
 		log.Println("* Application does not need installation, skipping...")
@@ -521,17 +889,19 @@ func (env *Info) Install(p *app.Info) error {
 	}
 
 	var cmd advexec.Advcmd
-	cmdElts := strings.Split(p.InstallCmd, " ")
-	cmd.BinPath = env.lookPath(cmdElts[0])
-	cmd.CmdArgs = cmdElts[1:]
+	var err error
+	cmd.BinPath, cmd.CmdArgs, err = env.LimitCmd("/bin/sh", []string{"-c", p.InstallCmd})
+	if err != nil {
+		return fmt.Errorf("unable to limit the resources of the install command: %w", err)
+	}
 	cmd.ExecDir = env.SrcDir
 	cmd.ManifestName = "install"
 	cmd.ManifestDir = env.InstallDir
-	cmd.Env = env.Env
+	cmd.Env = env.GetPrefixEnv(env.InstallDir)
 
-	log.Printf("Executing from %s: %s %s.", env.SrcDir, cmd.BinPath, strings.Join(cmdElts[1:], " "))
+	log.Printf("Executing from %s with PREFIX=%s: %s", env.SrcDir, env.InstallDir, p.InstallCmd)
 	log.Printf("Environment: %s\n", strings.Join(env.Env, "\n"))
-	res := cmd.Run()
+	res := env.GetRunner().RunAdvcmd(&cmd)
 	if res.Err != nil {
 		return fmt.Errorf("failed to install %s: %s; stdout: %s; stderr: %s", p.Name, res.Err, res.Stdout, res.Stderr)
 	}
@@ -541,23 +911,17 @@ func (env *Info) Install(p *app.Info) error {
 
 // Init ensures that the buildenv is correctly initialized
 func (env *Info) Init() error {
-	if !util.PathExists(env.ScratchDir) {
-		err := os.MkdirAll(env.ScratchDir, 0755)
-		if err != nil {
-			return fmt.Errorf("failed to create scratch directory %s: %w", env.ScratchDir, err)
-		}
+	err := env.mkdirAll(env.ScratchDir)
+	if err != nil {
+		return fmt.Errorf("failed to create scratch directory %s: %w", env.ScratchDir, err)
 	}
-	if !util.PathExists(env.BuildDir) {
-		err := os.MkdirAll(env.BuildDir, 0755)
-		if err != nil {
-			return fmt.Errorf("failed to create build directory %s: %w", env.BuildDir, err)
-		}
+	err = env.mkdirAll(env.BuildDir)
+	if err != nil {
+		return fmt.Errorf("failed to create build directory %s: %w", env.BuildDir, err)
 	}
-	if !util.PathExists(env.InstallDir) {
-		err := os.MkdirAll(env.InstallDir, 0755)
-		if err != nil {
-			return fmt.Errorf("failed to create build directory %s: %w", env.InstallDir, err)
-		}
+	err = env.mkdirAll(env.InstallDir)
+	if err != nil {
+		return fmt.Errorf("failed to create build directory %s: %w", env.InstallDir, err)
 	}
 	return nil
 }
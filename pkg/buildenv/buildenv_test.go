@@ -238,3 +238,193 @@ func TestGetAppInstallDir(t *testing.T) {
 		}
 	}
 }
+
+func TestEscalate(t *testing.T) {
+	var env Info
+
+	env.EscalationCmd = "env -i"
+	bin, args, err := env.escalate("make", []string{"install"})
+	if err != nil {
+		t.Skipf("env is not available: %s", err)
+	}
+	if filepath.Base(bin) != "env" {
+		t.Fatalf("escalation binary is %s instead of env", bin)
+	}
+	expectedArgs := []string{"-i", "make", "install"}
+	if strings.Join(args, " ") != strings.Join(expectedArgs, " ") {
+		t.Fatalf("escalated arguments are %s instead of %s", args, expectedArgs)
+	}
+
+	env.Escalate = func(binPath string, args []string) (string, []string, error) {
+		return "pkexec", append([]string{binPath}, args...), nil
+	}
+	bin, args, err = env.escalate("make", []string{"install"})
+	if err != nil {
+		t.Fatalf("escalate() failed: %s", err)
+	}
+	if bin != "pkexec" || strings.Join(args, " ") != "make install" {
+		t.Fatalf("escalation callback was not used: %s %s", bin, args)
+	}
+}
+
+func TestUnpackInSharedSrcDir(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	// Simulate other packages already present in the source directory
+	err = os.MkdirAll(filepath.Join(srcDir, "other-package-1.0"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(srcDir, "other-package-1.0.tar.gz"), []byte("dummy"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create file: %s", err)
+	}
+
+	var a app.Info
+	a.Name = "helloworld"
+	a.Source.URL = "file://" + filepath.Join("helloworld", "1.0.0.tar.gz")
+	a.Tarball = "1.0.0.tar.gz"
+	err = util.CopyFile(filepath.Join("helloworld", "1.0.0.tar.gz"), filepath.Join(srcDir, a.Tarball))
+	if err != nil {
+		t.Fatalf("unable to copy tarball: %s", err)
+	}
+
+	var env Info
+	env.SrcDir = srcDir
+	env.SrcPath = filepath.Join(srcDir, a.Tarball)
+	env.BuildDir = srcDir
+	err = env.Unpack(&a)
+	if err != nil {
+		t.Fatalf("Unpack() failed: %s", err)
+	}
+
+	expectedSrcDir := filepath.Join(srcDir, "c_hello_world-1.0.0")
+	if env.SrcDir != expectedSrcDir {
+		t.Fatalf("SrcDir is %s instead of %s", env.SrcDir, expectedSrcDir)
+	}
+}
+
+func TestMirrors(t *testing.T) {
+	buildDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(buildDir)
+
+	mirrorDir, err := filepath.Abs("helloworld")
+	if err != nil {
+		t.Fatalf("unable to get absolute path: %s", err)
+	}
+
+	var a app.Info
+	a.Name = "helloworld"
+	a.Source.URL = "file:///a/path/that/does/not/exist/1.0.0.tar.gz"
+	a.Source.Mirrors = []string{"file:///another/path/that/does/not/exist/1.0.0.tar.gz", "file://" + filepath.Join(mirrorDir, "1.0.0.tar.gz")}
+
+	var env Info
+	env.BuildDir = buildDir
+	env.SrcDir = buildDir
+	err = env.Get(&a)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err)
+	}
+	if !util.FileExists(env.SrcPath) || filepath.Base(env.SrcPath) != "1.0.0.tar.gz" {
+		t.Fatalf("tarball was not fetched from the mirror: %s", env.SrcPath)
+	}
+
+	a.Source.Mirrors = nil
+	env.MirrorRewrites = map[string]string{
+		"file:///a/":                   "file:///wrong/",
+		"file:///a/path/that/does/not": "file://" + mirrorDir,
+	}
+	if env.rewriteURL(a.Source.URL) != "file://"+filepath.Join(mirrorDir, "exist", "1.0.0.tar.gz") {
+		t.Fatalf("the longest matching prefix was not used: %s", env.rewriteURL(a.Source.URL))
+	}
+	env.MirrorRewrites = map[string]string{"file:///a/path/that/does/not/exist": "file://" + mirrorDir}
+	err = os.RemoveAll(filepath.Join(buildDir, a.Name))
+	if err != nil {
+		t.Fatalf("unable to clean up: %s", err)
+	}
+	err = env.Get(&a)
+	if err != nil {
+		t.Fatalf("Get() failed with a mirror rewrite rule: %s", err)
+	}
+
+	a.Source.URL = "file:///a/path/that/does/not/exist/2.0.0.tar.gz"
+	a.Tarball = ""
+	env.MirrorRewrites = nil
+	err = env.Get(&a)
+	if err == nil {
+		t.Fatalf("Get() succeeded without any valid source")
+	}
+}
+
+func TestConfigureCacheFile(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(cacheDir)
+
+	var env Info
+	cacheFile, err := env.GetConfigureCacheFile()
+	if err != nil || cacheFile != "" {
+		t.Fatalf("a configure cache is used without ConfigureCacheDir: %s", cacheFile)
+	}
+
+	env.ConfigureCacheDir = cacheDir
+	env.Env = []string{"PATH=" + os.Getenv("PATH"), "CC=gcc", "CFLAGS=-O2"}
+	cacheFile1, err := env.GetConfigureCacheFile()
+	if err != nil {
+		t.Fatalf("GetConfigureCacheFile() failed: %s", err)
+	}
+	if filepath.Dir(cacheFile1) != cacheDir {
+		t.Fatalf("cache file %s is not in %s", cacheFile1, cacheDir)
+	}
+	cacheFile2, _ := env.GetConfigureCacheFile()
+	if cacheFile1 != cacheFile2 {
+		t.Fatalf("cache files differ for the same toolchain: %s vs. %s", cacheFile1, cacheFile2)
+	}
+
+	env.Env = []string{"PATH=" + os.Getenv("PATH"), "CC=gcc", "CFLAGS=-O3"}
+	cacheFile3, _ := env.GetConfigureCacheFile()
+	if cacheFile1 == cacheFile3 {
+		t.Fatalf("same cache file for different toolchains")
+	}
+}
+
+func TestMakeVars(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not available, skipping test")
+	}
+
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	makefilePath := filepath.Join(srcDir, "Makefile")
+	err = ioutil.WriteFile(makefilePath, []byte("MSG = makefile\nCC = cc\nall:\n\techo $(MSG) $(CC) > out\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create Makefile: %s", err)
+	}
+
+	var testEnv Info
+	testEnv.SrcDir = srcDir
+	testEnv.MakeVars = map[string]string{"MSG": "cmdline", "CC": "dummy"}
+	err = testEnv.RunMake(false, "", makefilePath, nil)
+	if err != nil {
+		t.Fatalf("unable to run make: %s", err)
+	}
+	out, err := ioutil.ReadFile(filepath.Join(srcDir, "out"))
+	if err != nil {
+		t.Fatalf("unable to read output: %s", err)
+	}
+	if strings.TrimSpace(string(out)) != "cmdline dummy" {
+		t.Fatalf("make variables were not overridden: %s", string(out))
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// BuildTypeDebug is the build type producing unoptimized binaries with debug information
+	BuildTypeDebug = "debug"
+
+	// BuildTypeRelease is the build type producing optimized binaries without assertions nor debug information
+	BuildTypeRelease = "release"
+
+	// BuildTypeRelWithDebInfo is the build type producing optimized binaries with debug information
+	BuildTypeRelWithDebInfo = "relwithdebinfo"
+)
+
+// buildType is how a build type translates for the different build systems
+type buildType struct {
+	// flags are the compiler flags injected into the environment, for autotools and Makefiles
+	flags string
+
+	// cmake is the value of CMAKE_BUILD_TYPE
+	cmake string
+
+	// meson is the value of the --buildtype option of meson setup
+	meson string
+}
+
+var buildTypes = map[string]buildType{
+	BuildTypeDebug:          {flags: "-O0 -g", cmake: "Debug", meson: "debug"},
+	BuildTypeRelease:        {flags: "-O2 -DNDEBUG", cmake: "Release", meson: "release"},
+	BuildTypeRelWithDebInfo: {flags: "-O2 -g -DNDEBUG", cmake: "RelWithDebInfo", meson: "debugoptimized"},
+}
+
+// GetBuildTypes returns the name of all the build types
+func GetBuildTypes() []string {
+	var types []string
+	for name := range buildTypes {
+		types = append(types, name)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// SetBuildType selects the build type of the build environment (see BuildType) and injects its flags into CFLAGS,
+// CXXFLAGS, FCFLAGS and FFLAGS. It must be called before SetOptimizationProfile() so that the optimization level of
+// the build type takes precedence over the one of the profile; flags already set in the environment still come last.
+func (env *Info) SetBuildType(name string) error {
+	bt, ok := buildTypes[name]
+	if !ok {
+		return fmt.Errorf("unknown build type %s, valid build types are: %s", name, strings.Join(GetBuildTypes(), ", "))
+	}
+	if len(env.Env) == 0 {
+		// Env is the entire environment when set
+		env.Env = os.Environ()
+	}
+	for _, varName := range optimizationFlagsVars {
+		env.Env.Prepend(varName, bt.flags, " ")
+	}
+	env.BuildType = name
+	return nil
+}
+
+// GetCMakeBuildType returns the value of CMAKE_BUILD_TYPE for the build type of the environment, an empty string
+// when the build type is not set
+func (env *Info) GetCMakeBuildType() string {
+	return buildTypes[env.BuildType].cmake
+}
+
+// GetMesonBuildType returns the value of the --buildtype option of meson for the build type of the environment, an
+// empty string when the build type is not set
+func (env *Info) GetMesonBuildType() string {
+	return buildTypes[env.BuildType].meson
+}
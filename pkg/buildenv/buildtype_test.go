@@ -0,0 +1,49 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"testing"
+)
+
+func TestBuildType(t *testing.T) {
+	savedGOARCH := goarch
+	defer func() { goarch = savedGOARCH }()
+	goarch = "amd64"
+
+	env := Info{Env: []string{"PATH=/usr/bin", "CFLAGS=-Wall"}}
+	if env.GetCMakeBuildType() != "" || env.GetMesonBuildType() != "" {
+		t.Fatalf("build type is set by default")
+	}
+	err := env.SetBuildType(BuildTypeDebug)
+	if err != nil {
+		t.Fatalf("unable to set the build type: %s", err)
+	}
+	err = env.SetOptimizationProfile(ProfileX86_64_V3)
+	if err != nil {
+		t.Fatalf("unable to set the optimization profile: %s", err)
+	}
+	// The optimization level of the build type comes after the one of the profile
+	if env.getEnvValue("CFLAGS") != "-O2 -march=x86-64-v3 -O0 -g -Wall" || env.getEnvValue("FFLAGS") != "-O2 -march=x86-64-v3 -O0 -g" {
+		t.Fatalf("invalid environment: %v", env.Env)
+	}
+	if env.BuildType != BuildTypeDebug || env.GetCMakeBuildType() != "Debug" || env.GetMesonBuildType() != "debug" {
+		t.Fatalf("invalid build type: %s", env.BuildType)
+	}
+
+	env = Info{Env: []string{"PATH=/usr/bin"}}
+	err = env.SetBuildType(BuildTypeRelWithDebInfo)
+	if err != nil {
+		t.Fatalf("unable to set the build type: %s", err)
+	}
+	if env.GetCMakeBuildType() != "RelWithDebInfo" || env.GetMesonBuildType() != "debugoptimized" {
+		t.Fatalf("invalid build type: %s", env.BuildType)
+	}
+	err = env.SetBuildType("unknown")
+	if err == nil {
+		t.Fatalf("unknown build type was accepted")
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// FileChecksum returns the SHA256 checksum of a file, as a hexadecimal string
+func FileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	hasher := sha256.New()
+	_, err = io.Copy(hasher, f)
+	if err != nil {
+		return "", fmt.Errorf("unable to read %s: %w", path, err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// GitRevision returns the SHA of the commit currently checked out in a Git repository
+func GitRevision(repoDir string) (string, error) {
+	return gitRevision(HostRunner{}, repoDir)
+}
+
+func gitRevision(runner Runner, repoDir string) (string, error) {
+	gitBin, err := runner.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("failed to find git: %w", err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(gitBin, "rev-parse", "HEAD")
+	cmd.Dir = repoDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = runner.Run(cmd)
+	if err != nil {
+		return "", fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// GitIsTag checks whether a reference of a Git repository is a tag
+func GitIsTag(repoDir string, ref string) bool {
+	return gitIsTag(HostRunner{}, repoDir, ref)
+}
+
+func gitIsTag(runner Runner, repoDir string, ref string) bool {
+	if ref == "" {
+		return false
+	}
+
+	gitBin, err := runner.LookPath("git")
+	if err != nil {
+		return false
+	}
+
+	cmd := exec.Command(gitBin, "show-ref", "--verify", "--quiet", "refs/tags/"+ref)
+	cmd.Dir = repoDir
+	return runner.Run(cmd) == nil
+}
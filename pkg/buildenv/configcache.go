@@ -0,0 +1,112 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/metrics"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// toolchainVars are the environment variables, known as precious variables by autoconf, that define a toolchain.
+// Autoconf refuses to reuse a cache created with different values.
+var toolchainVars = []string{"CC", "CFLAGS", "CXX", "CXXFLAGS", "FC", "FCFLAGS", "F77", "FFLAGS", "CPP", "CPPFLAGS", "LDFLAGS", "LIBS"}
+
+// defaultCompilers are the compilers used by configure when the corresponding variables are not set
+var defaultCompilers = map[string]string{"CC": "cc", "CXX": "c++", "FC": "gfortran"}
+
+// getEnvValue returns the value of an environment variable in the build environment.
+// env.Env is the entire environment when set, otherwise the environment of the current process is used.
+func (env *Info) getEnvValue(name string) string {
+	if len(env.Env) == 0 {
+		return os.Getenv(name)
+	}
+	value, _ := env.Env.Get(name)
+	return value
+}
+
+// getCompiler returns the path and version, i.e., the first line of the output of --version, of the compiler
+// defined by a toolchain variable, e.g., CC. Empty strings are returned when the compiler cannot be found.
+func (env *Info) getCompiler(name string) (string, string) {
+	compiler := strings.Fields(env.getEnvValue(name))
+	if len(compiler) == 0 {
+		compiler = []string{defaultCompilers[name]}
+	}
+	compilerPath, err := env.GetRunner().LookPath(compiler[0])
+	if err != nil {
+		return "", ""
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(compilerPath, "--version")
+	cmd.Stdout = &stdout
+	env.GetRunner().Run(cmd)
+	return compilerPath, strings.TrimSpace(strings.SplitN(stdout.String(), "\n", 2)[0])
+}
+
+// getToolchainID returns an identifier of the toolchain of the build environment, based on the
+// compilers, their version and the flags
+func (env *Info) getToolchainID() string {
+	hasher := sha256.New()
+	for _, name := range toolchainVars {
+		value := env.getEnvValue(name)
+		fmt.Fprintf(hasher, "%s=%s\n", name, value)
+
+		if _, isCompiler := defaultCompilers[name]; !isCompiler {
+			continue
+		}
+		compilerPath, version := env.getCompiler(name)
+		if compilerPath == "" {
+			continue
+		}
+		// The version of the compiler may change while its path does not, e.g., after a system update
+		fmt.Fprintf(hasher, "%s:%s\n", compilerPath, version)
+	}
+	return hex.EncodeToString(hasher.Sum(nil))[:16]
+}
+
+// GetToolchain returns the compilers of the build environment that can be found, the key being the
+// toolchain variable, e.g., CC, and the value the path and version of the compiler, e.g.,
+// "/usr/bin/cc: cc (GCC) 12.2.0"
+func (env *Info) GetToolchain() map[string]string {
+	toolchain := make(map[string]string)
+	for name := range defaultCompilers {
+		compilerPath, version := env.getCompiler(name)
+		if compilerPath == "" {
+			continue
+		}
+		toolchain[name] = compilerPath + ": " + version
+	}
+	return toolchain
+}
+
+// GetConfigureCacheFile returns the path to the configure cache file shared by all the packages built
+// with the same toolchain (see ConfigureCacheDir). An empty string is returned when no cache must be used.
+func (env *Info) GetConfigureCacheFile() (string, error) {
+	if env.ConfigureCacheDir == "" {
+		return "", nil
+	}
+	if !util.PathExists(env.ConfigureCacheDir) {
+		err := os.MkdirAll(env.ConfigureCacheDir, defaultDirMode)
+		if err != nil {
+			return "", fmt.Errorf("unable to create %s: %w", env.ConfigureCacheDir, err)
+		}
+	}
+	cacheFile := filepath.Join(env.ConfigureCacheDir, "config.cache-"+env.getToolchainID())
+	if util.FileExists(cacheFile) {
+		metrics.Default.Inc(metrics.CacheHitsTotal, metrics.Labels{"cache": "configure"})
+	} else {
+		metrics.Default.Inc(metrics.CacheMissesTotal, metrics.Labels{"cache": "configure"})
+	}
+	return cacheFile, nil
+}
@@ -0,0 +1,181 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// Credential specifies how to authenticate when downloading files from a set of URLs.
+// All the values may refer to environment variables, e.g., "$GITLAB_TOKEN", so that
+// secrets do not need to be stored in configuration files.
+type Credential struct {
+	// URLPrefix is the prefix of the URLs the credential applies to, e.g., "https://gitlab.example.com/"
+	URLPrefix string `json:"url_prefix"`
+
+	// Username is the user name for basic authentication (optional)
+	Username string `json:"username"`
+
+	// Password is the password for basic authentication (optional)
+	Password string `json:"password"`
+
+	// Headers are additional HTTP headers to send, e.g., {"PRIVATE-TOKEN": "$GITLAB_TOKEN"} (optional)
+	Headers map[string]string `json:"headers"`
+}
+
+// getCredential returns the credential to use for a URL, the one with the longest matching prefix winning;
+// nil if no credential applies
+func (env *Info) getCredential(url string) *Credential {
+	var cred *Credential
+	for idx := range env.Credentials {
+		c := &env.Credentials[idx]
+		if strings.HasPrefix(url, c.URLPrefix) && (cred == nil || len(c.URLPrefix) > len(cred.URLPrefix)) {
+			cred = c
+		}
+	}
+	return cred
+}
+
+// getNetrcPath returns the path to the netrc file to use, an empty string if there is none
+func (env *Info) getNetrcPath() string {
+	if env.NetrcFile != "" {
+		return env.NetrcFile
+	}
+	if os.Getenv("NETRC") != "" {
+		return os.Getenv("NETRC")
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(homeDir, ".netrc")
+}
+
+// lookupNetrc returns the login and password of a host from a netrc file, using the default entry
+// when the host is not explicitly listed. The boolean specifies whether an entry was found.
+func lookupNetrc(netrcPath string, host string) (string, string, bool, error) {
+	if !util.FileExists(netrcPath) {
+		return "", "", false, nil
+	}
+	content, err := ioutil.ReadFile(netrcPath)
+	if err != nil {
+		return "", "", false, fmt.Errorf("unable to read %s: %w", netrcPath, err)
+	}
+
+	type entry struct {
+		login    string
+		password string
+	}
+	var hostEntry, defaultEntry *entry
+	var current *entry
+	inMacro := false
+	scanner := bufio.NewScanner(strings.NewReader(string(content)))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro definition ends with an empty line
+			if strings.TrimSpace(line) == "" {
+				inMacro = false
+			}
+			continue
+		}
+		tokens := strings.Fields(line)
+		for i := 0; i < len(tokens); i++ {
+			switch tokens[i] {
+			case "machine":
+				current = nil
+				if i+1 < len(tokens) {
+					i++
+					if tokens[i] == host && hostEntry == nil {
+						hostEntry = new(entry)
+						current = hostEntry
+					}
+				}
+			case "default":
+				current = nil
+				if defaultEntry == nil {
+					defaultEntry = new(entry)
+					current = defaultEntry
+				}
+			case "login", "password", "account":
+				if i+1 < len(tokens) {
+					i++
+					if current != nil && tokens[i-1] == "login" {
+						current.login = tokens[i]
+					} else if current != nil && tokens[i-1] == "password" {
+						current.password = tokens[i]
+					}
+				}
+			case "macdef":
+				inMacro = true
+				i = len(tokens)
+			}
+		}
+	}
+
+	if hostEntry != nil {
+		return hostEntry.login, hostEntry.password, true, nil
+	}
+	if defaultEntry != nil {
+		return defaultEntry.login, defaultEntry.password, true, nil
+	}
+	return "", "", false, nil
+}
+
+// authenticate adds the credentials matching the URL of a request, if any, to the request. The credentials
+// of artifact servers take precedence over Credential entries, which take precedence over netrc, which takes
+// precedence over the GITHUB_TOKEN and GITLAB_TOKEN environment variables for the GitHub and GitLab APIs.
+// Credentials are only sent over TLS with a verified certificate: the request fails if artifact server or
+// Credential entries apply to an insecure request, netrc and the tokens are simply not used.
+// It returns the name of the custom headers that were added, which must not be forwarded to other hosts.
+func (env *Info) authenticate(req *http.Request) ([]string, error) {
+	secureErr := env.checkSecureRequest(req)
+
+	artifactServer := env.getArtifactServer(req.URL.String())
+	if artifactServer != nil && artifactServer.hasCredentials() {
+		if secureErr != nil {
+			return nil, secureErr
+		}
+		return artifactServer.authenticate(req), nil
+	}
+
+	var headers []string
+	cred := env.getCredential(req.URL.String())
+	if cred != nil {
+		if secureErr != nil {
+			return nil, secureErr
+		}
+		if cred.Username != "" || cred.Password != "" {
+			req.SetBasicAuth(os.ExpandEnv(cred.Username), os.ExpandEnv(cred.Password))
+		}
+		for name, value := range cred.Headers {
+			req.Header.Set(name, os.ExpandEnv(value))
+			headers = append(headers, name)
+		}
+		return headers, nil
+	}
+
+	if secureErr != nil {
+		return headers, nil
+	}
+	login, password, found, err := lookupNetrc(env.getNetrcPath(), req.URL.Hostname())
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		req.SetBasicAuth(login, password)
+		return headers, nil
+	}
+	return env.addForgeToken(req), nil
+}
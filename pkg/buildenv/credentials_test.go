@@ -0,0 +1,143 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"context"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCABundle writes the certificate of a test TLS server to a PEM file in dir, for the build environment to
+// trust the server (see Info.CABundle)
+func writeCABundle(t *testing.T, server *httptest.Server, dir string) string {
+	bundlePath := filepath.Join(dir, "ca.pem")
+	content := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	err := ioutil.WriteFile(bundlePath, content, 0644)
+	if err != nil {
+		t.Fatalf("unable to write %s: %s", bundlePath, err)
+	}
+	return bundlePath
+}
+
+func TestAuthenticatedDownload(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		if r.Header.Get("PRIVATE-TOKEN") == "secret" || (ok && user == "user" && password == "pass") {
+			w.Write([]byte("content"))
+			return
+		}
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+	server := httptest.NewTLSServer(handler)
+	defer server.Close()
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+	targetFile := filepath.Join(testDir, "file.tar.gz")
+
+	var env Info
+	env.NetrcFile = filepath.Join(testDir, "netrc")
+	env.CABundle = writeCABundle(t, server, testDir)
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, "")
+	if err == nil {
+		t.Fatalf("download succeeded without credentials")
+	}
+
+	os.Setenv("TEST_PRIVATE_TOKEN", "secret")
+	defer os.Unsetenv("TEST_PRIVATE_TOKEN")
+	env.Credentials = []Credential{
+		{URLPrefix: "https://", Username: "wrong", Password: "wrong"},
+		{URLPrefix: server.URL + "/", Headers: map[string]string{"PRIVATE-TOKEN": "$TEST_PRIVATE_TOKEN"}},
+	}
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, "")
+	if err != nil {
+		t.Fatalf("download with a custom header failed: %s", err)
+	}
+
+	// Credentials are not sent without verifying the certificate of the server
+	os.Remove(targetFile)
+	env.CABundle = ""
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, "")
+	if err == nil || !strings.Contains(err.Error(), "certificate") {
+		t.Fatalf("certificate signed by an unknown authority accepted: %v", err)
+	}
+	env.InsecureTLS = true
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, "")
+	if err == nil || !strings.Contains(err.Error(), "refusing to send credentials") {
+		t.Fatalf("credentials sent without verifying the certificate: %v", err)
+	}
+	env.InsecureTLS = false
+
+	// Nor over plain HTTP
+	plainServer := httptest.NewServer(handler)
+	defer plainServer.Close()
+	env.Credentials = []Credential{{URLPrefix: plainServer.URL + "/", Headers: map[string]string{"PRIVATE-TOKEN": "secret"}}}
+	err = env.downloadFile(context.Background(), plainServer.URL+"/file.tar.gz", targetFile, "")
+	if err == nil || !strings.Contains(err.Error(), "refusing to send credentials") {
+		t.Fatalf("credentials sent over plain HTTP: %v", err)
+	}
+
+	env.CABundle = writeCABundle(t, server, testDir)
+	env.Credentials = nil
+	host := strings.Split(strings.TrimPrefix(server.URL, "https://"), ":")[0]
+	netrc := "machine other.example.com login foo password bar\nmachine " + host + "\n\tlogin user\n\tpassword pass\n"
+	err = ioutil.WriteFile(env.NetrcFile, []byte(netrc), 0600)
+	if err != nil {
+		t.Fatalf("unable to create netrc file: %s", err)
+	}
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, "")
+	if err != nil {
+		t.Fatalf("download with netrc credentials failed: %s", err)
+	}
+	err = env.downloadFile(context.Background(), plainServer.URL+"/file.tar.gz", targetFile, "")
+	if err == nil {
+		t.Fatalf("netrc credentials sent over plain HTTP")
+	}
+}
+
+func TestCustomHeadersNotForwarded(t *testing.T) {
+	var forwardedToken string
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedToken = r.Header.Get("PRIVATE-TOKEN")
+		w.Write([]byte("content"))
+	}))
+	defer other.Close()
+	// Use a different host name for the same loopback interface so the redirection is cross-host
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, otherURL+"/file.tar.gz", http.StatusFound)
+	}))
+	defer server.Close()
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var env Info
+	env.NetrcFile = filepath.Join(testDir, "netrc")
+	env.CABundle = writeCABundle(t, server, testDir)
+	env.Credentials = []Credential{{URLPrefix: server.URL, Headers: map[string]string{"PRIVATE-TOKEN": "secret"}}}
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", filepath.Join(testDir, "file.tar.gz"), "")
+	if err != nil {
+		t.Fatalf("download failed: %s", err)
+	}
+	if forwardedToken != "" {
+		t.Fatalf("custom header was forwarded to another host")
+	}
+}
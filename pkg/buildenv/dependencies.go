@@ -0,0 +1,42 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// AddDependencyFlags makes the software installed in installDir, a dependency of the software built in the build
+// environment, visible to the software whose configuration does not take the location of its dependencies as
+// options, e.g., --with-<dependency>=<dir>: its include directory is added to CPPFLAGS, its library directories to
+// LDFLAGS, with a run-time search path, and to PKG_CONFIG_PATH when they have pkg-config files, and installDir to
+// CMAKE_PREFIX_PATH. Dependencies added last take precedence.
+func (env *Info) AddDependencyFlags(installDir string) {
+	if len(env.Env) == 0 {
+		// Env is the entire environment when set
+		env.Env = os.Environ()
+	}
+
+	includeDir := filepath.Join(installDir, "include")
+	if util.IsDir(includeDir) {
+		env.Env.Prepend("CPPFLAGS", "-I"+includeDir, " ")
+	}
+	for _, libDirname := range []string{"lib64", "lib"} {
+		libDir := filepath.Join(installDir, libDirname)
+		if !util.IsDir(libDir) {
+			continue
+		}
+		env.Env.Prepend("LDFLAGS", "-L"+libDir+" -Wl,-rpath,"+libDir, " ")
+		pkgConfigDir := filepath.Join(libDir, "pkgconfig")
+		if util.IsDir(pkgConfigDir) {
+			env.Env.Prepend("PKG_CONFIG_PATH", pkgConfigDir, ":")
+		}
+	}
+	env.Env.Prepend("CMAKE_PREFIX_PATH", installDir, ":")
+}
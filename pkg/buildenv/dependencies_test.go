@@ -0,0 +1,42 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddDependencyFlags(t *testing.T) {
+	installDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(installDir)
+	for _, dir := range []string{"include", "lib/pkgconfig"} {
+		err = os.MkdirAll(filepath.Join(installDir, dir), 0755)
+		if err != nil {
+			t.Fatalf("unable to create directory: %s", err)
+		}
+	}
+
+	env := Info{Env: []string{"PATH=/usr/bin", "LDFLAGS=-L/opt/lib"}}
+	env.AddDependencyFlags(installDir)
+	libDir := filepath.Join(installDir, "lib")
+	expected := map[string]string{
+		"CPPFLAGS":          "-I" + filepath.Join(installDir, "include"),
+		"LDFLAGS":           "-L" + libDir + " -Wl,-rpath," + libDir + " -L/opt/lib",
+		"PKG_CONFIG_PATH":   filepath.Join(libDir, "pkgconfig"),
+		"CMAKE_PREFIX_PATH": installDir,
+	}
+	for name, value := range expected {
+		if env.getEnvValue(name) != value {
+			t.Fatalf("invalid value of %s: %s instead of %s", name, env.getEnvValue(name), value)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/metrics"
+)
+
+const (
+	// PartialDownloadSuffix is the suffix of the files being downloaded. A file with this suffix is
+	// the beginning of an interrupted download, which is resumed on the next attempt.
+	PartialDownloadSuffix = ".part"
+
+	// maxDownloadAttempts is the number of times a download is resumed after an error before giving up
+	maxDownloadAttempts = 5
+)
+
+// downloadRetryDelay is the base delay between two download attempts, multiplied by the number of attempts
+var downloadRetryDelay = 2 * time.Second
+
+// httpClient is the client used for all downloads, unless the build environment has specific TLS settings
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+	},
+}
+
+// tlsClients are the clients of the TLS settings other than the default ones, so that connections are reused
+var (
+	tlsClients      = make(map[string]*http.Client)
+	tlsClientsMutex sync.Mutex
+)
+
+// getHTTPClient returns the client to use for the requests of the build environment, based on its TLS settings
+// (see CABundle and InsecureTLS)
+func (env *Info) getHTTPClient() (*http.Client, error) {
+	if env.CABundle == "" && !env.InsecureTLS {
+		return httpClient, nil
+	}
+	key := fmt.Sprintf("%s:%t", env.CABundle, env.InsecureTLS)
+	tlsClientsMutex.Lock()
+	defer tlsClientsMutex.Unlock()
+	if client, ok := tlsClients[key]; ok {
+		return client, nil
+	}
+
+	tlsConfig := &tls.Config{InsecureSkipVerify: env.InsecureTLS}
+	if env.CABundle != "" {
+		pem, err := ioutil.ReadFile(env.CABundle)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", env.CABundle, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", env.CABundle)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: tlsConfig,
+		},
+	}
+	tlsClients[key] = client
+	return client, nil
+}
+
+// checkSecureRequest returns an error if a request is not sent over TLS with a verified certificate, the only
+// requests credentials can be attached to
+func (env *Info) checkSecureRequest(req *http.Request) error {
+	if req.URL.Scheme != "https" {
+		return fmt.Errorf("refusing to send credentials to %s over plain HTTP", req.URL.Host)
+	}
+	if env.InsecureTLS {
+		return fmt.Errorf("refusing to send credentials to %s without verifying its certificate", req.URL.Host)
+	}
+	return nil
+}
+
+// isTransientError returns whether the error of a request is transient, i.e., a timeout or a connection reset,
+// as opposed to permanent errors, e.g., an unknown host or an invalid certificate, that retrying would not fix
+func isTransientError(err error) bool {
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	return errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
+
+// downloadFile downloads a file from a URL. Data is first written to targetFile with the PartialDownloadSuffix
+// suffix, and the file is renamed once the download is complete and verified. If a partial file already exists,
+// for instance because a previous download was interrupted, the download resumes where it stopped, as long as
+// the server supports range requests. Credentials are added to the requests based on env.Credentials or,
+// if none applies, the netrc file. expectedChecksum is the expected SHA256 of the file (optional).
+func (env *Info) downloadFile(ctx context.Context, url string, targetFile string, expectedChecksum string) (err error) {
+	partFile := targetFile + PartialDownloadSuffix
+
+	defer func() {
+		status := "success"
+		if err != nil {
+			status = "failure"
+		}
+		metrics.Default.Inc(metrics.DownloadsTotal, metrics.Labels{"status": status})
+	}()
+
+	for attempt := 1; attempt <= maxDownloadAttempts; attempt++ {
+		if attempt > 1 {
+			log.Printf("-> Download of %s failed (%s), resuming (attempt %d/%d)...", url, err, attempt, maxDownloadAttempts)
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Duration(attempt-1) * downloadRetryDelay):
+			}
+		}
+		var retry bool
+		retry, err = env.downloadPart(ctx, url, partFile)
+		if err == nil || !retry {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if expectedChecksum != "" {
+		checksum, err := FileChecksum(partFile)
+		if err != nil {
+			return err
+		}
+		if !strings.EqualFold(checksum, expectedChecksum) {
+			// The data is corrupted, resuming would not help
+			os.Remove(partFile)
+			return fmt.Errorf("checksum mismatch for %s: %s instead of %s", url, checksum, expectedChecksum)
+		}
+	}
+
+	err = os.Rename(partFile, targetFile)
+	if err != nil {
+		return fmt.Errorf("unable to rename %s: %w", partFile, err)
+	}
+	return nil
+}
+
+// Download downloads a file from a URL into targetFile, with the credentials of the build environment and by
+// resuming interrupted downloads (see Credentials). expectedChecksum is the expected SHA256 of the file (optional).
+func (env *Info) Download(ctx context.Context, url string, targetFile string, expectedChecksum string) error {
+	return env.downloadFile(ctx, url, targetFile, expectedChecksum)
+}
+
+// downloadPart downloads the remaining data of a file into partFile, which may already hold the beginning of the file.
+// The boolean returned specifies whether the error, if any, is transient and the download should be attempted again.
+func (env *Info) downloadPart(ctx context.Context, url string, partFile string) (bool, error) {
+	var offset int64
+	fileInfo, err := os.Stat(partFile)
+	if err == nil {
+		offset = fileInfo.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return false, fmt.Errorf("invalid request for %s: %w", url, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	customHeaders, err := env.authenticate(req)
+	if err != nil {
+		return false, err
+	}
+
+	envClient, err := env.getHTTPClient()
+	if err != nil {
+		return false, err
+	}
+	// Go already drops the Authorization header when redirected to another host, do the same for custom headers,
+	// and drop all the credentials when redirected to plain HTTP
+	client := *envClient
+	client.CheckRedirect = func(redirectedReq *http.Request, via []*http.Request) error {
+		if len(via) >= 10 {
+			return fmt.Errorf("too many redirects")
+		}
+		if redirectedReq.URL.Host != via[0].URL.Host || redirectedReq.URL.Scheme != "https" {
+			for _, name := range customHeaders {
+				redirectedReq.Header.Del(name)
+			}
+		}
+		if redirectedReq.URL.Scheme != "https" {
+			redirectedReq.Header.Del("Authorization")
+		}
+		return nil
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return isTransientError(err), fmt.Errorf("unable to get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	expectedSize := resp.ContentLength
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Either a new download or the server does not support range requests: start from scratch
+		if offset > 0 {
+			log.Printf("-> %s does not support resuming downloads, restarting from the beginning", url)
+		}
+		flags |= os.O_TRUNC
+		offset = 0
+	case http.StatusPartialContent:
+		log.Printf("-> Resuming download of %s from byte %d", url, offset)
+		flags |= os.O_APPEND
+		if expectedSize >= 0 {
+			expectedSize += offset
+		}
+	case http.StatusRequestedRangeNotSatisfiable:
+		// The partial file is not consistent with the remote file, e.g., the file changed on the server
+		os.Remove(partFile)
+		return true, fmt.Errorf("unable to resume the download of %s", url)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return false, fmt.Errorf("unable to get %s: %s, check the credentials", url, resp.Status)
+	default:
+		retry := resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests
+		return retry, fmt.Errorf("unable to get %s: %s", url, resp.Status)
+	}
+
+	f, err := os.OpenFile(partFile, flags, 0644)
+	if err != nil {
+		return false, fmt.Errorf("unable to open %s: %w", partFile, err)
+	}
+	written, err := io.Copy(f, resp.Body)
+	closeErr := f.Close()
+	if err != nil {
+		return true, fmt.Errorf("download of %s interrupted: %w", url, err)
+	}
+	if closeErr != nil {
+		return false, fmt.Errorf("unable to write %s: %w", partFile, closeErr)
+	}
+
+	if expectedSize >= 0 && offset+written != expectedSize {
+		return true, fmt.Errorf("incomplete download of %s: %d bytes instead of %d", url, offset+written, expectedSize)
+	}
+	return false, nil
+}
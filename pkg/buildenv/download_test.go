@@ -0,0 +1,150 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestResumableDownload(t *testing.T) {
+	downloadRetryDelay = 0
+	content := bytes.Repeat([]byte("0123456789"), 10000)
+	hash := sha256.Sum256(content)
+	checksum := hex.EncodeToString(hash[:])
+
+	// The first request is interrupted halfway, the following ones are served normally
+	var mutex sync.Mutex
+	var requests, rangeRequests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		n := requests
+		if r.Header.Get("Range") != "" {
+			rangeRequests++
+		}
+		mutex.Unlock()
+		if n == 1 {
+			w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+			w.WriteHeader(http.StatusOK)
+			w.Write(content[:len(content)/2])
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "file.tar.gz", time.Now(), bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var env Info
+	targetFile := filepath.Join(testDir, "file.tar.gz")
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, checksum)
+	if err != nil {
+		t.Fatalf("downloadFile() failed: %s", err)
+	}
+	downloaded, err := ioutil.ReadFile(targetFile)
+	if err != nil {
+		t.Fatalf("unable to read downloaded file: %s", err)
+	}
+	if !bytes.Equal(downloaded, content) {
+		t.Fatalf("downloaded file is corrupted (%d bytes instead of %d)", len(downloaded), len(content))
+	}
+	if rangeRequests == 0 {
+		t.Fatalf("download was not resumed")
+	}
+	if _, err := os.Stat(targetFile + PartialDownloadSuffix); err == nil {
+		t.Fatalf("partial file was not removed")
+	}
+
+	// A partial file from a previous run must be resumed
+	os.Remove(targetFile)
+	err = ioutil.WriteFile(targetFile+PartialDownloadSuffix, content[:1000], 0644)
+	if err != nil {
+		t.Fatalf("unable to create partial file: %s", err)
+	}
+	rangeRequests = 0
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, checksum)
+	if err != nil {
+		t.Fatalf("downloadFile() failed to resume: %s", err)
+	}
+	if rangeRequests != 1 {
+		t.Fatalf("%d range requests instead of 1", rangeRequests)
+	}
+
+	// Corrupted data must be detected
+	os.Remove(targetFile)
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, "1234")
+	if err == nil {
+		t.Fatalf("downloadFile() succeeded with an invalid checksum")
+	}
+	if _, err := os.Stat(targetFile); err == nil {
+		t.Fatalf("file with an invalid checksum was kept")
+	}
+}
+
+func TestDownloadRetries(t *testing.T) {
+	defer func(delay time.Duration) {
+		downloadRetryDelay = delay
+	}(downloadRetryDelay)
+	downloadRetryDelay = 0
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+	targetFile := filepath.Join(testDir, "file.tar.gz")
+
+	// Transient errors of the server are retried
+	var mutex sync.Mutex
+	var requests int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		requests++
+		n := requests
+		mutex.Unlock()
+		if n == 1 {
+			http.Error(w, "busy", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte("content"))
+	}))
+	defer server.Close()
+	var env Info
+	err = env.downloadFile(context.Background(), server.URL+"/file.tar.gz", targetFile, "")
+	if err != nil {
+		t.Fatalf("downloadFile() failed: %s", err)
+	}
+	if requests != 2 {
+		t.Fatalf("%d requests instead of 2", requests)
+	}
+
+	// Permanent errors are not, e.g., no server at all
+	closedServer := httptest.NewServer(http.NotFoundHandler())
+	closedURL := closedServer.URL
+	closedServer.Close()
+	downloadRetryDelay = time.Hour
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	err = env.downloadFile(ctx, closedURL+"/file.tar.gz", targetFile, "")
+	if err == nil || ctx.Err() != nil {
+		t.Fatalf("download from a server that does not exist was retried: %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Env is an environment, i.e., a list of NAME=value variables such as the ones of exec.Cmd.Env. Like with
+// exec.Cmd, the last definition of a variable takes precedence when it is defined more than once.
+type Env []string
+
+// splitVar returns the name and value of a NAME=value variable, ok being false when it is not a variable
+func splitVar(v string) (string, string, bool) {
+	idx := strings.Index(v, "=")
+	if idx <= 0 {
+		return "", "", false
+	}
+	return v[:idx], v[idx+1:], true
+}
+
+// ParseEnv parses a list of NAME=value variables separated by spaces, e.g., from a stack definition. Like in a
+// shell, values containing spaces must be quoted or escaped, e.g., CFLAGS="-O2 -g" CC=gcc.
+func ParseEnv(s string) (Env, error) {
+	var env Env
+	var token strings.Builder
+	inToken := false
+	var quote rune
+	escaped := false
+	for _, r := range s {
+		switch {
+		case escaped:
+			token.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inToken = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				token.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inToken = true
+		case unicode.IsSpace(r):
+			if inToken {
+				env = append(env, token.String())
+				token.Reset()
+				inToken = false
+			}
+		default:
+			token.WriteRune(r)
+			inToken = true
+		}
+	}
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %s", s)
+	}
+	if inToken {
+		env = append(env, token.String())
+	}
+
+	for _, v := range env {
+		if _, _, ok := splitVar(v); !ok {
+			return nil, fmt.Errorf("invalid variable %s, NAME=value expected", v)
+		}
+	}
+	return env, nil
+}
+
+// Get returns the value of a variable and whether it is defined
+func (e Env) Get(name string) (string, bool) {
+	value := ""
+	found := false
+	for _, v := range e {
+		n, val, ok := splitVar(v)
+		if ok && n == name {
+			value = val
+			found = true
+		}
+	}
+	return value, found
+}
+
+// Set sets the value of a variable, replacing all its previous definitions. The variable keeps the position
+// of its first definition.
+func (e *Env) Set(name string, value string) {
+	var newEnv Env
+	set := false
+	for _, v := range *e {
+		n, _, ok := splitVar(v)
+		if !ok || n != name {
+			newEnv = append(newEnv, v)
+			continue
+		}
+		if !set {
+			newEnv = append(newEnv, name+"="+value)
+			set = true
+		}
+	}
+	if !set {
+		newEnv = append(newEnv, name+"="+value)
+	}
+	*e = newEnv
+}
+
+// Unset removes all the definitions of a variable
+func (e *Env) Unset(name string) {
+	var newEnv Env
+	for _, v := range *e {
+		n, _, ok := splitVar(v)
+		if !ok || n != name {
+			newEnv = append(newEnv, v)
+		}
+	}
+	*e = newEnv
+}
+
+// Prepend adds a value at the beginning of a variable, sep being the separator of the elements of the variable,
+// e.g., ":" for PATH or " " for CFLAGS. The variable is set to value when it is not defined or empty.
+func (e *Env) Prepend(name string, value string, sep string) {
+	current, _ := e.Get(name)
+	if current != "" {
+		value += sep + current
+	}
+	e.Set(name, value)
+}
+
+// Append adds a value at the end of a variable, sep being the separator of the elements of the variable,
+// e.g., ":" for PATH or " " for CFLAGS. The variable is set to value when it is not defined or empty.
+func (e *Env) Append(name string, value string, sep string) {
+	current, _ := e.Get(name)
+	if current != "" {
+		value = current + sep + value
+	}
+	e.Set(name, value)
+}
+
+// Merge returns an environment with the variables of both environments, the variables of other taking precedence
+func (e Env) Merge(other Env) Env {
+	merged := append(Env{}, e...)
+	for _, v := range other {
+		n, val, ok := splitVar(v)
+		if ok {
+			merged.Set(n, val)
+		}
+	}
+	return merged
+}
+
+// Dedup returns the environment with a single definition of each variable, i.e., the one taking precedence,
+// at the position of its first definition
+func (e Env) Dedup() Env {
+	return Env{}.Merge(e)
+}
+
+// String returns the environment as a list of NAME=value variables separated by spaces that ParseEnv can parse,
+// values being quoted when necessary
+func (e Env) String() string {
+	var vars []string
+	for _, v := range e {
+		name, value, ok := splitVar(v)
+		if !ok {
+			continue
+		}
+		if value != "" && !strings.ContainsAny(value, " \t\n\"'\\") {
+			vars = append(vars, v)
+			continue
+		}
+		var quoted strings.Builder
+		quoted.WriteString(name + "=\"")
+		for _, r := range value {
+			if r == '"' || r == '\\' {
+				quoted.WriteRune('\\')
+			}
+			quoted.WriteRune(r)
+		}
+		quoted.WriteString("\"")
+		vars = append(vars, quoted.String())
+	}
+	return strings.Join(vars, " ")
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	tests := []struct {
+		s           string
+		expected    Env
+		expectError bool
+	}{
+		{s: "", expected: nil},
+		{s: "CC=gcc  CXX=g++", expected: Env{"CC=gcc", "CXX=g++"}},
+		{s: `CFLAGS="-O2 -g" LDFLAGS='-L/opt/lib -lm' MSG=a\ b`, expected: Env{"CFLAGS=-O2 -g", "LDFLAGS=-L/opt/lib -lm", "MSG=a b"}},
+		{s: `OPTS=--with-x="a b"`, expected: Env{"OPTS=--with-x=a b"}},
+		{s: "EMPTY=", expected: Env{"EMPTY="}},
+		{s: `CFLAGS="-O2`, expectError: true},
+		{s: "CC=gcc -O2", expectError: true},
+		{s: "=gcc", expectError: true},
+	}
+	for _, tt := range tests {
+		env, err := ParseEnv(tt.s)
+		if tt.expectError {
+			if err == nil {
+				t.Fatalf("parsing %s did not fail: %v", tt.s, env)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unable to parse %s: %s", tt.s, err)
+		}
+		if strings.Join(env, "|") != strings.Join(tt.expected, "|") {
+			t.Fatalf("%s was parsed as %q instead of %q", tt.s, env, tt.expected)
+		}
+	}
+}
+
+func TestEnvOperations(t *testing.T) {
+	env := Env{"PATH=/usr/bin", "CFLAGS=-g", "PATH=/bin", "OPTS=a=b"}
+	if value, ok := env.Get("PATH"); !ok || value != "/bin" {
+		t.Fatalf("the last definition of PATH must take precedence: %s", value)
+	}
+	if value, _ := env.Get("OPTS"); value != "a=b" {
+		t.Fatalf("invalid value with '=': %s", value)
+	}
+	if _, ok := env.Get("CC"); ok {
+		t.Fatalf("undefined variable was found")
+	}
+
+	env.Prepend("PATH", "/opt/bin", ":")
+	env.Append("CFLAGS", "-O2", " ")
+	env.Append("LDFLAGS", "-lm", " ")
+	expected := "PATH=/opt/bin:/bin|CFLAGS=-g -O2|OPTS=a=b|LDFLAGS=-lm"
+	if strings.Join(env, "|") != expected {
+		t.Fatalf("invalid environment %q instead of %s", env, expected)
+	}
+
+	env.Unset("OPTS")
+	merged := env.Merge(Env{"CFLAGS=-O3", "CC=gcc"})
+	expected = "PATH=/opt/bin:/bin|CFLAGS=-O3|LDFLAGS=-lm|CC=gcc"
+	if strings.Join(merged, "|") != expected {
+		t.Fatalf("invalid merged environment %q instead of %s", merged, expected)
+	}
+	if len(env) != 3 {
+		t.Fatalf("Merge() modified the environment: %q", env)
+	}
+
+	deduped := Env{"A=1", "B=2", "A=3"}.Dedup()
+	if strings.Join(deduped, "|") != "A=3|B=2" {
+		t.Fatalf("invalid deduplicated environment: %q", deduped)
+	}
+
+	env = Env{"CC=gcc", `CFLAGS=-O2 -DNAME="x"`, "EMPTY=", `DIR=C:\tmp`}
+	str := env.String()
+	if str != `CC=gcc CFLAGS="-O2 -DNAME=\"x\"" EMPTY="" DIR="C:\\tmp"` {
+		t.Fatalf("invalid string for the environment: %s", str)
+	}
+	parsed, err := ParseEnv(str)
+	if err != nil || strings.Join(parsed, "|") != strings.Join(env, "|") {
+		t.Fatalf("unable to parse the string of the environment: %q, %v", parsed, err)
+	}
+}
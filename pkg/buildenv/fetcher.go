@@ -0,0 +1,223 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gvallee/go_software_build/pkg/app"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// Fetcher is the interface to implement to get source code from a specific type of URL, e.g.,
+// an internal blob store. Fetchers are selected based on the URL of the source code.
+type Fetcher interface {
+	// Supports returns whether the fetcher can get source code from a URL
+	Supports(url string) bool
+
+	// Fetch gets the source code of an application, from p.Source.URL, into the directories of the
+	// build environment dest. On success, dest.SrcPath must point at the fetched file or directory
+	// and, when the source code does not require unpacking, dest.SrcDir at the source directory.
+	// A fetcher is free to set p.Tarball to specify the name of the fetched file.
+	Fetch(ctx context.Context, p *app.Info, dest *Info) error
+}
+
+var (
+	fetchersLock sync.RWMutex
+
+	// fetchers is the list of fetchers registered with RegisterFetcher()
+	fetchers []Fetcher
+
+	// builtinFetchers is the list of fetchers provided by the package, used when no other fetcher supports a URL
+	builtinFetchers = []Fetcher{&fileFetcher{}, &httpFetcher{}, &gitFetcher{}}
+)
+
+// RegisterFetcher makes a fetcher available to all build environments. Registered fetchers are tried
+// in the reverse order of registration, before the built-in fetchers, so they can override them.
+func RegisterFetcher(f Fetcher) {
+	fetchersLock.Lock()
+	defer fetchersLock.Unlock()
+	fetchers = append([]Fetcher{f}, fetchers...)
+}
+
+// getFetcher returns the fetcher to use for a URL: the fetchers of the environment (see Info.Fetchers)
+// come first, then the registered fetchers and finally the built-in fetchers
+func (env *Info) getFetcher(url string) Fetcher {
+	for _, f := range env.Fetchers {
+		if f.Supports(url) {
+			return f
+		}
+	}
+
+	fetchersLock.RLock()
+	defer fetchersLock.RUnlock()
+	for _, f := range fetchers {
+		if f.Supports(url) {
+			return f
+		}
+	}
+	for _, f := range builtinFetchers {
+		if f.Supports(url) {
+			return f
+		}
+	}
+	return nil
+}
+
+// fetch gets the source code of an application from its URL with the appropriate fetcher. When the type
+// of the source code is explicitly specified, the corresponding built-in fetcher is used.
+func (env *Info) fetch(ctx context.Context, p *app.Info) error {
+	var f Fetcher
+	if p.Source.Type != "" {
+		switch getURLType(p) {
+		case util.FileURL:
+			f = &fileFetcher{}
+		case util.HttpURL:
+			f = &httpFetcher{}
+		case util.GitURL:
+			f = &gitFetcher{}
+		default:
+			return fmt.Errorf("%s is not a valid URL for source code of type %s", p.Source.URL, p.Source.Type)
+		}
+	} else {
+		f = env.getFetcher(p.Source.URL)
+	}
+	if f == nil {
+		return fmt.Errorf("impossible to detect URL type: %s", p.Source.URL)
+	}
+	return f.Fetch(ctx, p, env)
+}
+
+// detectURLType is a safe wrapper around util.DetectURLType(), which does not support short URLs
+func detectURLType(url string) string {
+	if len(url) < len("file://") {
+		return ""
+	}
+	return util.DetectURLType(url)
+}
+
+// getURLType returns the type of URL of an application's source code, e.g., util.GitURL, taking
+// into account the type of source code when specified
+func getURLType(p *app.Info) string {
+	switch p.Source.Type {
+	case "":
+		return detectURLType(p.Source.URL)
+	case app.SourceTypeGit:
+		return util.GitURL
+	case app.SourceTypeDir:
+		if strings.HasPrefix(p.Source.URL, "/") || detectURLType(p.Source.URL) == util.FileURL {
+			return util.FileURL
+		}
+	case app.SourceTypeTarball, app.SourceTypeFile:
+		if strings.HasPrefix(p.Source.URL, "/") || detectURLType(p.Source.URL) == util.FileURL {
+			return util.FileURL
+		}
+		if strings.HasPrefix(p.Source.URL, "http://") || strings.HasPrefix(p.Source.URL, "https://") {
+			return util.HttpURL
+		}
+	}
+	return ""
+}
+
+// getLocalPath returns the path on the local file system a file:// URL, or a plain path, points at
+func getLocalPath(url string) string {
+	return strings.TrimPrefix(url, "file://")
+}
+
+// fileFetcher gets source code from the local file system, i.e., file:// URLs pointing at a tarball or a directory
+type fileFetcher struct{}
+
+func (f *fileFetcher) Supports(url string) bool {
+	return detectURLType(url) == util.FileURL
+}
+
+func (f *fileFetcher) Fetch(ctx context.Context, p *app.Info, env *Info) error {
+	path := getLocalPath(p.Source.URL)
+	if p.Source.Type == app.SourceTypeDir && !util.IsDir(path) {
+		return fmt.Errorf("%s is not a directory", path)
+	}
+	if !util.IsDir(path) {
+		err := env.copyTarball(p)
+		if err != nil {
+			return fmt.Errorf("env.copyTarball() failed: %w", err)
+		}
+		return nil
+	}
+
+	// If we deal with a directory, we always copy it directly to the build directory because
+	// it is a pain to safely cache. rsync is preferred when available so that a previous copy,
+	// for instance of a developer's working tree, is efficiently updated, including deleted files.
+	targetDir := filepath.Join(env.BuildDir, p.Name)
+	err := env.mkdirAll(targetDir)
+	if err != nil {
+		return err
+	}
+	var args []string
+	binPath, err := env.GetRunner().LookPath("rsync")
+	if err == nil {
+		args = append(args, "-a", "--delete")
+	} else {
+		binPath, err = env.GetRunner().LookPath("cp")
+		if err != nil {
+			return fmt.Errorf("neither rsync nor cp are available")
+		}
+		args = append(args, "-rf")
+	}
+	args = append(args, strings.TrimSuffix(path, "/"), targetDir)
+	log.Printf("-> Running %s %s", binPath, strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, binPath, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = env.GetRunner().Run(cmd)
+	if err != nil {
+		return fmt.Errorf("unable to copy %s into %s: %w, stdout: %s, stderr: %s", path, targetDir, err, stdout.String(), stderr.String())
+	}
+
+	env.SrcPath = filepath.Join(targetDir, filepath.Base(p.Source.URL))
+	env.SrcDir = env.SrcPath
+	return nil
+}
+
+// httpFetcher downloads source code from http:// and https:// URLs
+type httpFetcher struct{}
+
+func (f *httpFetcher) Supports(url string) bool {
+	return detectURLType(url) == util.HttpURL
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, p *app.Info, env *Info) error {
+	err := env.download(ctx, p)
+	if err != nil {
+		return fmt.Errorf("env.download() failed, impossible to download %s: %w", p.Name, err)
+	}
+	return nil
+}
+
+// gitFetcher clones Git repositories
+type gitFetcher struct{}
+
+func (f *gitFetcher) Supports(url string) bool {
+	return detectURLType(url) == util.GitURL
+}
+
+func (f *gitFetcher) Fetch(ctx context.Context, p *app.Info, env *Info) error {
+	// If we deal with a Git repository, we always clone it in the build directory because
+	// it is a pain to safely cache
+	env.SrcPath = env.BuildDir
+	err := env.gitCheckout(ctx, p)
+	if err != nil {
+		return fmt.Errorf("impossible to get Git repository %s: %s", p.Source.URL, err)
+	}
+	return nil
+}
@@ -0,0 +1,168 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gvallee/go_software_build/pkg/app"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// blobFetcher is a fetcher for a fictional blob:// protocol, which creates a file with the name of the blob
+type blobFetcher struct {
+	calls int
+}
+
+func (f *blobFetcher) Supports(url string) bool {
+	return strings.HasPrefix(url, "blob://")
+}
+
+func (f *blobFetcher) Fetch(ctx context.Context, p *app.Info, dest *Info) error {
+	f.calls++
+	p.Tarball = strings.TrimPrefix(p.Source.URL, "blob://")
+	dest.SrcPath = filepath.Join(dest.SrcDir, p.Tarball)
+	return ioutil.WriteFile(dest.SrcPath, []byte("blob"), 0644)
+}
+
+func TestCustomFetcher(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var a app.Info
+	a.Name = "myblob"
+	a.Source.URL = "blob://myblob.tar.gz"
+	var env Info
+	env.SrcDir = testDir
+	err = env.Get(&a)
+	if err == nil {
+		t.Fatalf("Get() succeeded with an unsupported URL")
+	}
+
+	registered := new(blobFetcher)
+	RegisterFetcher(registered)
+	defer func() {
+		fetchersLock.Lock()
+		fetchers = nil
+		fetchersLock.Unlock()
+	}()
+	err = env.Get(&a)
+	if err != nil {
+		t.Fatalf("Get() failed with a registered fetcher: %s", err)
+	}
+	if registered.calls != 1 || !util.FileExists(filepath.Join(testDir, "myblob.tar.gz")) || env.SrcChecksum == "" {
+		t.Fatalf("registered fetcher was not used")
+	}
+
+	// Fetchers specific to the environment take precedence
+	envFetcher := new(blobFetcher)
+	env.Fetchers = []Fetcher{envFetcher}
+	err = env.Get(&a)
+	if err != nil {
+		t.Fatalf("Get() failed with an environment fetcher: %s", err)
+	}
+	if envFetcher.calls != 1 || registered.calls != 1 {
+		t.Fatalf("environment fetcher was not used")
+	}
+}
+
+func TestGetCancellation(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	var a app.Info
+	a.Name = "software"
+	a.Source.URL = server.URL + "/software.tar.gz"
+	var env Info
+	env.SrcDir = testDir
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = env.GetWithContext(ctx, &a)
+	if err == nil || !strings.Contains(err.Error(), context.Canceled.Error()) {
+		t.Fatalf("Get() was not canceled: %v", err)
+	}
+}
+
+func TestSourceType(t *testing.T) {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not available")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	// A Git repository without the .git suffix is not detected as such
+	repoURL, _ := createGitRepository(t, gitBin, testDir, "myrepo")
+	var a app.Info
+	a.Name = "myrepo"
+	a.Source.URL = "file://" + repoURL
+	a.Source.Type = app.SourceTypeGit
+	var env Info
+	env.BuildDir = filepath.Join(testDir, "build")
+	err = env.Get(&a)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err)
+	}
+	if env.SrcRevision == "" || !util.FileExists(filepath.Join(env.SrcDir, "file1")) {
+		t.Fatalf("Git repository was not cloned")
+	}
+	err = env.Unpack(&a)
+	if err != nil {
+		t.Fatalf("Unpack() failed: %s", err)
+	}
+
+	// A tarball of type file must not be unpacked
+	tarball, err := filepath.Abs(filepath.Join("helloworld", "1.0.0.tar.gz"))
+	if err != nil {
+		t.Fatalf("unable to get absolute path: %s", err)
+	}
+	var fileApp app.Info
+	fileApp.Name = "installer"
+	fileApp.Source.URL = tarball
+	fileApp.Source.Type = app.SourceTypeFile
+	var fileEnv Info
+	fileEnv.BuildDir = filepath.Join(testDir, "build")
+	err = fileEnv.Get(&fileApp)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err)
+	}
+	err = fileEnv.Unpack(&fileApp)
+	if err != nil {
+		t.Fatalf("Unpack() failed: %s", err)
+	}
+	if util.PathExists(filepath.Join(fileEnv.SrcDir, "c_hello_world-1.0.0")) {
+		t.Fatalf("file was unpacked")
+	}
+
+	fileApp.Source.Type = app.SourceTypeDir
+	err = fileEnv.Get(&fileApp)
+	if err == nil {
+		t.Fatalf("Get() succeeded with a file of type %s", app.SourceTypeDir)
+	}
+}
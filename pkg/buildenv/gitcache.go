@@ -0,0 +1,92 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gvallee/go_software_build/pkg/metrics"
+)
+
+// gitCacheLocks serializes the operations on a given cached repository, the key being the path to the cache
+var gitCacheLocks sync.Map
+
+func (env *Info) runGit(ctx context.Context, gitBin string, dir string, args ...string) error {
+	log.Printf("Running from %s: %s %s\n", dir, gitBin, strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, gitBin, args...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := env.GetRunner().Run(cmd)
+	if err != nil {
+		return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+	}
+	return nil
+}
+
+// getGitCachePath returns the path to the bare repository caching a given Git URL
+func (env *Info) getGitCachePath(url string) string {
+	repoName := strings.TrimSuffix(filepath.Base(url), ".git")
+	hash := sha256.Sum256([]byte(url))
+	return filepath.Join(env.GitCacheDir, repoName+"-"+hex.EncodeToString(hash[:])[:12]+".git")
+}
+
+// updateGitCache makes sure the bare repository caching a Git URL exists and is up-to-date, and returns its path.
+// Only the changes since the last update are fetched when the cache already exists.
+func (env *Info) updateGitCache(ctx context.Context, gitBin string, url string) (string, error) {
+	err := env.mkdirAll(env.GitCacheDir)
+	if err != nil {
+		return "", fmt.Errorf("unable to create %s: %w", env.GitCacheDir, err)
+	}
+
+	cachePath := env.getGitCachePath(url)
+	lock, _ := gitCacheLocks.LoadOrStore(cachePath, new(sync.Mutex))
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	if env.pathExists(cachePath) {
+		metrics.Default.Inc(metrics.CacheHitsTotal, metrics.Labels{"cache": "git"})
+		err := env.runGit(ctx, gitBin, cachePath, "remote", "update", "--prune")
+		if err != nil {
+			return "", fmt.Errorf("unable to update the cache of %s: %w", url, err)
+		}
+		return cachePath, nil
+	}
+
+	metrics.Default.Inc(metrics.CacheMissesTotal, metrics.Labels{"cache": "git"})
+	err = env.runGit(ctx, gitBin, env.GitCacheDir, "clone", "--mirror", url, cachePath)
+	if err != nil {
+		// Do not leave a partial cache behind
+		env.GetFS().RemoveAll(cachePath)
+		return "", fmt.Errorf("unable to create the cache of %s: %w", url, err)
+	}
+	return cachePath, nil
+}
+
+// gitCloneFromCache clones a Git repository from the cache into targetDir/repoName. The clone is local and
+// therefore cheap; the remote of the new clone points to the original URL so it can later be updated as usual.
+func (env *Info) gitCloneFromCache(ctx context.Context, gitBin string, url string, targetDir string, repoName string) error {
+	cachePath, err := env.updateGitCache(ctx, gitBin, url)
+	if err != nil {
+		return err
+	}
+
+	err = env.runGit(ctx, gitBin, targetDir, "clone", cachePath, repoName)
+	if err != nil {
+		return err
+	}
+	return env.runGit(ctx, gitBin, filepath.Join(targetDir, repoName), "remote", "set-url", "origin", url)
+}
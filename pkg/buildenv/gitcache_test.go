@@ -0,0 +1,106 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gvallee/go_software_build/pkg/app"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// commitFile creates a new commit with a single file in a Git working tree and pushes it
+func commitFile(t *testing.T, gitBin string, workDir string, filename string) {
+	err := ioutil.WriteFile(filepath.Join(workDir, filename), []byte(filename), 0644)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", filename, err)
+	}
+	for _, args := range [][]string{
+		{"add", filename},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-m", filename},
+		{"push", "origin", "HEAD"},
+	} {
+		err = (&Info{}).runGit(context.Background(), gitBin, workDir, args...)
+		if err != nil {
+			t.Fatalf("git command failed: %s", err)
+		}
+	}
+}
+
+// createGitRepository creates a bare repository in testDir/upstream/repoName with a first commit, and
+// returns the path to the repository and to a working tree that can be used to push new commits
+func createGitRepository(t *testing.T, gitBin string, testDir string, repoName string) (string, string) {
+	repoURL := filepath.Join(testDir, "upstream", repoName)
+	err := os.MkdirAll(repoURL, 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	err = (&Info{}).runGit(context.Background(), gitBin, repoURL, "init", "--bare")
+	if err != nil {
+		t.Fatalf("unable to create upstream repository: %s", err)
+	}
+	workDir := filepath.Join(testDir, "work-"+repoName)
+	err = (&Info{}).runGit(context.Background(), gitBin, testDir, "clone", repoURL, workDir)
+	if err != nil {
+		t.Fatalf("unable to clone upstream repository: %s", err)
+	}
+	commitFile(t, gitBin, workDir, "file1")
+	return repoURL, workDir
+}
+
+func TestGitCache(t *testing.T) {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git is not available")
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	repoURL, workDir := createGitRepository(t, gitBin, testDir, "myrepo.git")
+
+	var env Info
+	env.GitCacheDir = filepath.Join(testDir, "cache")
+	var a app.Info
+	a.Name = "myrepo"
+	a.Source.URL = repoURL
+	env.BuildDir = filepath.Join(testDir, "build1")
+	err = env.Get(&a)
+	if err != nil {
+		t.Fatalf("Get() failed: %s", err)
+	}
+	if !util.PathExists(env.getGitCachePath(repoURL)) {
+		t.Fatalf("Git cache was not created")
+	}
+
+	// New commits must be visible in a fresh build directory
+	commitFile(t, gitBin, workDir, "file2")
+	env.BuildDir = filepath.Join(testDir, "build2")
+	err = env.Get(&a)
+	if err != nil {
+		t.Fatalf("Get() failed with existing cache: %s", err)
+	}
+	if !util.FileExists(filepath.Join(env.SrcDir, "file2")) {
+		t.Fatalf("cached repository was not updated")
+	}
+
+	out, err := exec.Command(gitBin, "-C", env.SrcDir, "remote", "get-url", "origin").Output()
+	if err != nil {
+		t.Fatalf("unable to get origin of the clone: %s", err)
+	}
+	if strings.TrimSpace(string(out)) != repoURL {
+		t.Fatalf("origin of the clone is %s instead of %s", out, repoURL)
+	}
+}
@@ -0,0 +1,82 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Isolation specifies how the build commands are isolated from the host. They are executed with bubblewrap in
+// unshared user and mount namespaces, in which the file system of the host is read-only, except the directories
+// of the build environment, e.g., BuildDir, and in which the content of HiddenDirs is hidden, so that undeclared
+// dependencies and installations outside of the build environment make the build fail.
+type Isolation struct {
+	// HiddenDirs is the directories whose content is hidden from the build commands, e.g., the installation
+	// directory of a stack, except ReadOnlyDirs and WritableDirs
+	HiddenDirs []string `json:"hidden_dirs"`
+
+	// ReadOnlyDirs is the directories visible but read-only in the isolated build environment, e.g., the
+	// installation directories of the declared dependencies
+	ReadOnlyDirs []string `json:"read_only_dirs"`
+
+	// WritableDirs is the directories writable in the isolated build environment in addition to the directories
+	// of the build environment, e.g., the installation directory of the software being built
+	WritableDirs []string `json:"writable_dirs"`
+}
+
+// isInDirs checks whether a path is one of a list of directories or one of their parents
+func isInDirs(path string, dirs []string) bool {
+	for _, dir := range dirs {
+		dir = filepath.Clean(dir)
+		if dir == path || strings.HasPrefix(dir, path+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// getIsolationArgs returns the bubblewrap arguments isolating the build commands, the entries of HiddenDirs
+// being listed when the command is executed so that software installed since then is also hidden
+func (env *Info) getIsolationArgs() ([]string, error) {
+	args := []string{"--unshare-user", "--die-with-parent", "--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp"}
+	writableDirs := []string{env.ScratchDir, env.BuildDir, env.SrcDir, env.InstallDir, env.DestDir, env.ConfigureCacheDir}
+	writableDirs = append(writableDirs, env.Isolation.WritableDirs...)
+	for _, dir := range writableDirs {
+		if dir != "" {
+			args = append(args, "--bind-try", dir, dir)
+		}
+	}
+
+	keptDirs := append(append([]string{}, env.Isolation.ReadOnlyDirs...), env.Isolation.WritableDirs...)
+	for _, hiddenDir := range env.Isolation.HiddenDirs {
+		if !env.pathExists(hiddenDir) {
+			continue
+		}
+		entries, err := env.GetFS().ReadDir(hiddenDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read content of %s: %w", hiddenDir, err)
+		}
+		for _, entry := range entries {
+			path := filepath.Join(hiddenDir, entry.Name())
+			if isInDirs(path, keptDirs) {
+				continue
+			}
+			if entry.IsDir() {
+				args = append(args, "--tmpfs", path)
+			} else {
+				args = append(args, "--ro-bind", "/dev/null", path)
+			}
+		}
+	}
+
+	// The directories of the dependencies may be in writable directories, e.g., the installation directory
+	for _, dir := range env.Isolation.ReadOnlyDirs {
+		args = append(args, "--ro-bind-try", dir, dir)
+	}
+	return args, nil
+}
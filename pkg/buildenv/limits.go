@@ -0,0 +1,102 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// ResourceLimits specifies the resources build commands can use, e.g., so that builds on shared
+// login nodes do not starve other users
+type ResourceLimits struct {
+	// Nice is the niceness adjustment of build commands, from 1 (slightly lower priority) to 19 (lowest priority). Ignored when 0.
+	Nice int `json:"nice"`
+
+	// IOClass is the I/O scheduling class of build commands as defined by ionice, e.g., 3 for idle. Ignored when 0.
+	IOClass int `json:"io_class"`
+
+	// MemoryMax is the maximum amount of memory build commands can use, e.g., "8G". It relies on systemd-run.
+	MemoryMax string `json:"memory_max"`
+
+	// CPUQuota is the maximum CPU time build commands can use, relative to a single CPU, e.g., "400%". It relies on systemd-run.
+	CPUQuota string `json:"cpu_quota"`
+
+	// MaxJobs is the maximum number of parallel jobs of the builds, e.g., make -j<MaxJobs>. Unlimited when 0.
+	MaxJobs int `json:"max_jobs"`
+}
+
+// GetJobsArg returns the make argument specifying the number of parallel jobs
+func (env *Info) GetJobsArg() string {
+	if env.Limits.MaxJobs > 0 {
+		return "-j" + strconv.Itoa(env.Limits.MaxJobs)
+	}
+	return "-j"
+}
+
+// LimitCmd returns the binary and arguments to use to execute a command within the resource limits
+// of the build environment, isolated from the host when Isolation is set. The command is returned
+// unchanged when no limit is specified.
+func (env *Info) LimitCmd(binPath string, args []string) (string, []string, error) {
+	var prefix []string
+
+	if env.Limits.MemoryMax != "" || env.Limits.CPUQuota != "" {
+		systemdRunBin, err := env.GetRunner().LookPath("systemd-run")
+		if err != nil {
+			return "", nil, fmt.Errorf("systemd-run is required for memory and CPU limits: %w", err)
+		}
+		prefix = append(prefix, systemdRunBin, "--scope", "--quiet")
+		if os.Geteuid() != 0 {
+			prefix = append(prefix, "--user")
+		}
+		if env.Limits.MemoryMax != "" {
+			prefix = append(prefix, "-p", "MemoryMax="+env.Limits.MemoryMax)
+		}
+		if env.Limits.CPUQuota != "" {
+			prefix = append(prefix, "-p", "CPUQuota="+env.Limits.CPUQuota)
+		}
+		prefix = append(prefix, "--")
+	}
+
+	if env.Limits.Nice != 0 {
+		niceBin, err := env.GetRunner().LookPath("nice")
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to find the nice binary: %w", err)
+		}
+		prefix = append(prefix, niceBin, "-n", strconv.Itoa(env.Limits.Nice))
+	}
+
+	if env.Limits.IOClass != 0 {
+		ioniceBin, err := env.GetRunner().LookPath("ionice")
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to find the ionice binary: %w", err)
+		}
+		prefix = append(prefix, ioniceBin, "-c", strconv.Itoa(env.Limits.IOClass))
+	}
+
+	if env.Isolation != nil {
+		bwrapBin, err := env.GetRunner().LookPath("bwrap")
+		if err != nil {
+			return "", nil, fmt.Errorf("bubblewrap is required for isolated builds: %w", err)
+		}
+		isolationArgs, err := env.getIsolationArgs()
+		if err != nil {
+			return "", nil, err
+		}
+		prefix = append(prefix, bwrapBin)
+		prefix = append(prefix, isolationArgs...)
+		prefix = append(prefix, "--")
+	}
+
+	if len(prefix) == 0 {
+		return binPath, args, nil
+	}
+	limitedArgs := append([]string{}, prefix[1:]...)
+	limitedArgs = append(limitedArgs, binPath)
+	limitedArgs = append(limitedArgs, args...)
+	return prefix[0], limitedArgs, nil
+}
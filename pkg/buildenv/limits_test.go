@@ -0,0 +1,108 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLimitCmd(t *testing.T) {
+	var env Info
+	binPath, args, err := env.LimitCmd("make", []string{"all"})
+	if err != nil || binPath != "make" || len(args) != 1 {
+		t.Fatalf("command without limits was modified: %s %s (%v)", binPath, args, err)
+	}
+	if env.GetJobsArg() != "-j" {
+		t.Fatalf("unexpected jobs argument without limit: %s", env.GetJobsArg())
+	}
+	env.Limits.MaxJobs = 4
+	if env.GetJobsArg() != "-j4" {
+		t.Fatalf("unexpected jobs argument: %s", env.GetJobsArg())
+	}
+
+	if _, err := exec.LookPath("systemd-run"); err == nil {
+		env.Limits.MemoryMax = "1G"
+		env.Limits.CPUQuota = "200%"
+		binPath, args, err = env.LimitCmd("make", []string{"all"})
+		if err != nil {
+			t.Fatalf("unable to limit command: %s", err)
+		}
+		cmdline := strings.Join(append([]string{binPath}, args...), " ")
+		if !strings.Contains(cmdline, "-p MemoryMax=1G -p CPUQuota=200% -- make all") {
+			t.Fatalf("unexpected command: %s", cmdline)
+		}
+		env.Limits.MemoryMax = ""
+		env.Limits.CPUQuota = ""
+	}
+
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("nice not available, skipping test")
+	}
+	if _, err := exec.LookPath("ionice"); err != nil {
+		t.Skip("ionice not available, skipping test")
+	}
+	env.Limits.Nice = 5
+	env.Limits.IOClass = 3
+	res := env.RunCmdWithResult(false, "", "", "", "/bin/sh", []string{"-c", "nice; ionice"})
+	if res.Err != nil {
+		t.Fatalf("unable to run command: %s", res.Err)
+	}
+	if res.Stdout != "5\nidle\n" {
+		t.Fatalf("command did not run with the expected limits: %s", res.Stdout)
+	}
+}
+
+func TestIsolation(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+	installDir := filepath.Join(testDir, "install")
+	for _, name := range []string{"dep", "undeclared", "comp"} {
+		err = os.MkdirAll(filepath.Join(installDir, name), 0755)
+		if err != nil {
+			t.Fatalf("unable to create directory: %s", err)
+		}
+	}
+
+	env := Info{
+		BuildDir:   filepath.Join(testDir, "build"),
+		InstallDir: installDir,
+		Runner:     &mockRunner{},
+		Isolation: &Isolation{
+			HiddenDirs:   []string{installDir},
+			ReadOnlyDirs: []string{filepath.Join(installDir, "dep")},
+			WritableDirs: []string{filepath.Join(installDir, "comp", "1.0")},
+		},
+	}
+	binPath, args, err := env.LimitCmd("make", []string{"all"})
+	if err != nil {
+		t.Fatalf("unable to isolate command: %s", err)
+	}
+	cmdline := strings.Join(append([]string{binPath}, args...), " ")
+	expectedArgs := []string{
+		"/mock/bin/bwrap --unshare-user",
+		"--bind-try " + installDir + " " + installDir,
+		"--tmpfs " + filepath.Join(installDir, "undeclared"),
+		"--ro-bind-try " + filepath.Join(installDir, "dep") + " " + filepath.Join(installDir, "dep") + " -- make all",
+	}
+	for _, expectedArg := range expectedArgs {
+		if !strings.Contains(cmdline, expectedArg) {
+			t.Fatalf("%q is not in the isolated command: %s", expectedArg, cmdline)
+		}
+	}
+	for _, name := range []string{"dep", "comp"} {
+		if strings.Contains(cmdline, "--tmpfs "+filepath.Join(installDir, name)) {
+			t.Fatalf("%s is hidden: %s", name, cmdline)
+		}
+	}
+}
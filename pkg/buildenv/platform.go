@@ -0,0 +1,169 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+var (
+	// goos is the operating system software is built for, a variable so that tests can emulate macOS
+	goos = runtime.GOOS
+
+	// goarch is the architecture software is built for, e.g., amd64 or arm64
+	goarch = runtime.GOARCH
+)
+
+// machOMagics are the magic numbers of Mach-O files, i.e., 32-bit, 64-bit and universal binaries and libraries
+var machOMagics = []uint32{0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe, 0xcafebabe}
+
+// soPattern matches the extension of shared libraries on Linux, possibly followed by a version or a wildcard
+var soPattern = regexp.MustCompile(`\.so(\.[0-9.]*|\*)*$`)
+
+// IsDarwin returns whether software is built on macOS
+func IsDarwin() bool {
+	return goos == "darwin"
+}
+
+// LibraryPathVar returns the environment variable the dynamic linker uses to find shared libraries, i.e.,
+// DYLD_LIBRARY_PATH on macOS and LD_LIBRARY_PATH otherwise
+func LibraryPathVar() string {
+	if IsDarwin() {
+		return "DYLD_LIBRARY_PATH"
+	}
+	return "LD_LIBRARY_PATH"
+}
+
+// SharedLibExt returns the extension of shared libraries, i.e., .dylib on macOS and .so otherwise
+func SharedLibExt() string {
+	if IsDarwin() {
+		return ".dylib"
+	}
+	return ".so"
+}
+
+// GetSharedLibPattern converts a path pattern for Linux shared libraries, e.g., lib/libucp.so*, into the
+// pattern matching the same libraries on the current platform, e.g., lib/libucp*.dylib on macOS where the
+// version comes before the extension (libucp.0.dylib). Other patterns are returned unchanged.
+func GetSharedLibPattern(pattern string) string {
+	if !IsDarwin() || !soPattern.MatchString(pattern) {
+		return pattern
+	}
+	return soPattern.ReplaceAllString(pattern, "*.dylib")
+}
+
+// IsMachO returns whether a file is a Mach-O file, i.e., a macOS binary or library
+func IsMachO(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	var magic uint32
+	err = binary.Read(f, binary.BigEndian, &magic)
+	if err != nil {
+		return false
+	}
+	for _, m := range machOMagics {
+		if magic == m {
+			// 0xcafebabe is also the magic number of Java class files, which have a large version number where
+			// universal binaries have their (small) number of architectures
+			if magic == 0xcafebabe {
+				var nArch uint32
+				err = binary.Read(f, binary.BigEndian, &nArch)
+				return err == nil && nArch < 20
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// IsBSDTar returns whether a tar command is bsdtar, i.e., libarchive, the default on macOS, instead of GNU tar
+func IsBSDTar(tarBin string) bool {
+	var stdout bytes.Buffer
+	cmd := exec.Command(tarBin, "--version")
+	cmd.Stdout = &stdout
+	err := cmd.Run()
+	return err == nil && strings.Contains(stdout.String(), "bsdtar")
+}
+
+// GetTarCreateCmd returns the command creating, from dir, a bzip2-compressed tarball with files. With bsdtar,
+// macOS metadata, e.g., extended attributes and AppleDouble ._* files, are not included so that the tarball can
+// be extracted with GNU tar, e.g., on Linux clusters.
+func GetTarCreateCmd(tarBin string, dir string, tarball string, files []string) *exec.Cmd {
+	args := []string{"-cjf", tarball}
+	if IsDarwin() && IsBSDTar(tarBin) {
+		args = append([]string{"--no-mac-metadata"}, args...)
+	}
+	cmd := exec.Command(tarBin, append(args, files...)...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "COPYFILE_DISABLE=1")
+	return cmd
+}
+
+// tarCompressions are the magic numbers of the compression formats of tarballs and the option of tar to decompress them
+var tarCompressions = []struct {
+	magic  []byte
+	option string
+}{
+	{[]byte{0x1f, 0x8b}, "-z"},
+	{[]byte("BZh"), "-j"},
+	{[]byte{0xfd, '7', 'z', 'X', 'Z', 0x00}, "-J"},
+	{[]byte{0x28, 0xb5, 0x2f, 0xfd}, "--zstd"},
+}
+
+// tarMagicOffset is the offset of the magic string of uncompressed POSIX and GNU tarballs, i.e., "ustar"
+const tarMagicOffset = 257
+
+// GetTarDecompressOption returns the option of tar to decompress a tarball, i.e., -z for gzip, -j for bzip2, -J for
+// xz and --zstd for zstd, or an empty string when it is not compressed. The compression is detected from the content
+// of the tarball regardless of its extension.
+func GetTarDecompressOption(tarball string) (string, error) {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	header := make([]byte, tarMagicOffset+5)
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", fmt.Errorf("unable to read %s: %w", tarball, err)
+	}
+	header = header[:n]
+	for _, compression := range tarCompressions {
+		if bytes.HasPrefix(header, compression.magic) {
+			return compression.option, nil
+		}
+	}
+	if len(header) == tarMagicOffset+5 && string(header[tarMagicOffset:]) == "ustar" {
+		return "", nil
+	}
+	return "", fmt.Errorf("%s is not a tarball or its compression is not supported", tarball)
+}
+
+// GetTarExtractCmd returns the command extracting a tarball, compressed or not (see GetTarDecompressOption), in dir
+func GetTarExtractCmd(tarBin string, dir string, tarball string) (*exec.Cmd, error) {
+	option, err := GetTarDecompressOption(tarball)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"-xf", tarball}
+	if option != "" {
+		args = append([]string{option}, args...)
+	}
+	cmd := exec.Command(tarBin, args...)
+	cmd.Dir = dir
+	return cmd, nil
+}
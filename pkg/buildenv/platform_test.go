@@ -0,0 +1,109 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDarwinPlatform(t *testing.T) {
+	savedGOOS := goos
+	defer func() { goos = savedGOOS }()
+
+	goos = "linux"
+	if LibraryPathVar() != "LD_LIBRARY_PATH" || GetSharedLibPattern("lib/libucp.so*") != "lib/libucp.so*" {
+		t.Fatalf("invalid Linux platform")
+	}
+
+	goos = "darwin"
+	if LibraryPathVar() != "DYLD_LIBRARY_PATH" || SharedLibExt() != ".dylib" {
+		t.Fatalf("invalid macOS platform")
+	}
+	tests := map[string]string{
+		"lib/libucp.so*":    "lib/libucp*.dylib",
+		"lib/libucp.so":     "lib/libucp*.dylib",
+		"lib/libucp.so.0.1": "lib/libucp*.dylib",
+		"bin/ucx_info":      "bin/ucx_info",
+		"lib/libucp.a":      "lib/libucp.a",
+	}
+	for pattern, expected := range tests {
+		if GetSharedLibPattern(pattern) != expected {
+			t.Fatalf("%s was converted to %s instead of %s", pattern, GetSharedLibPattern(pattern), expected)
+		}
+	}
+}
+
+func TestIsMachO(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	files := map[string][]byte{
+		"arm64":     {0xcf, 0xfa, 0xed, 0xfe, 0x0c, 0x00, 0x00, 0x01},
+		"universal": {0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x02},
+		"class":     {0xca, 0xfe, 0xba, 0xbe, 0x00, 0x00, 0x00, 0x34},
+		"elf":       {0x7f, 'E', 'L', 'F', 0x02, 0x01, 0x01, 0x00},
+		"empty":     {},
+	}
+	expected := map[string]bool{"arm64": true, "universal": true}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		err = ioutil.WriteFile(path, content, 0644)
+		if err != nil {
+			t.Fatalf("unable to write %s: %s", path, err)
+		}
+		if IsMachO(path) != expected[name] {
+			t.Fatalf("IsMachO(%s) returned %v", name, IsMachO(path))
+		}
+	}
+}
+
+func TestGetTarDecompressOption(t *testing.T) {
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	tarHeader := make([]byte, 512)
+	copy(tarHeader[257:], "ustar")
+	files := map[string][]byte{
+		"stack.tar.bz2": {0x1f, 0x8b, 0x08, 0x00},
+		"stack.tgz":     []byte("BZh91AY"),
+		"stack.tar.xz":  {0xfd, '7', 'z', 'X', 'Z', 0x00, 0x00},
+		"stack.tar.zst": {0x28, 0xb5, 0x2f, 0xfd, 0x00},
+		"stack.tar.gz":  tarHeader,
+		"stack.zip":     {'P', 'K', 0x03, 0x04},
+		"empty":         {},
+	}
+	expected := map[string]string{
+		"stack.tar.bz2": "-z",
+		"stack.tgz":     "-j",
+		"stack.tar.xz":  "-J",
+		"stack.tar.zst": "--zstd",
+		"stack.tar.gz":  "",
+	}
+	for name, content := range files {
+		path := filepath.Join(tempDir, name)
+		err = ioutil.WriteFile(path, content, 0644)
+		if err != nil {
+			t.Fatalf("unable to write %s: %s", path, err)
+		}
+		option, err := GetTarDecompressOption(path)
+		expectedOption, supported := expected[name]
+		if supported && (err != nil || option != expectedOption) {
+			t.Fatalf("GetTarDecompressOption(%s) returned %q, %v instead of %q", name, option, err, expectedOption)
+		}
+		if !supported && err == nil {
+			t.Fatalf("GetTarDecompressOption(%s) succeeded with an unsupported archive", name)
+		}
+	}
+}
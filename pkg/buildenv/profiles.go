@@ -0,0 +1,96 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// ProfileGeneric is the optimization profile producing binaries that run on any CPU of the architecture
+	ProfileGeneric = "generic"
+
+	// ProfileNative is the optimization profile tuning binaries for the CPU of the build host, which may not run on other CPUs
+	ProfileNative = "native"
+
+	// ProfileX86_64_V3 is the optimization profile for x86-64 CPUs supporting AVX2, e.g., Haswell and later
+	ProfileX86_64_V3 = "x86-64-v3"
+
+	// ProfileNeoverseN1 is the optimization profile for Arm Neoverse N1 CPUs, e.g., AWS Graviton2 and Ampere Altra
+	ProfileNeoverseN1 = "neoverse-n1"
+)
+
+// optimizationProfiles are the compiler flags of each optimization profile, for each architecture (GOARCH)
+// the profile supports
+var optimizationProfiles = map[string]map[string]string{
+	ProfileGeneric: {
+		"amd64": "-O2 -march=x86-64 -mtune=generic",
+		"arm64": "-O2 -march=armv8-a",
+		"":      "-O2",
+	},
+	ProfileNative: {
+		"amd64": "-O2 -march=native",
+		"arm64": "-O2 -mcpu=native",
+		"":      "-O2 -mcpu=native",
+	},
+	ProfileX86_64_V3: {
+		"amd64": "-O2 -march=x86-64-v3",
+	},
+	ProfileNeoverseN1: {
+		"arm64": "-O2 -mcpu=neoverse-n1",
+	},
+}
+
+// optimizationFlagsVars are the environment variables the flags of optimization profiles are injected into
+var optimizationFlagsVars = []string{"CFLAGS", "CXXFLAGS", "FCFLAGS", "FFLAGS"}
+
+// GetOptimizationProfiles returns the name of all the optimization profiles
+func GetOptimizationProfiles() []string {
+	var profiles []string
+	for name := range optimizationProfiles {
+		profiles = append(profiles, name)
+	}
+	sort.Strings(profiles)
+	return profiles
+}
+
+// GetOptimizationFlags returns the compiler flags of an optimization profile for the architecture software is built for
+func GetOptimizationFlags(profile string) (string, error) {
+	archFlags, ok := optimizationProfiles[profile]
+	if !ok {
+		return "", fmt.Errorf("unknown optimization profile %s, valid profiles are: %s", profile, strings.Join(GetOptimizationProfiles(), ", "))
+	}
+	flags, ok := archFlags[goarch]
+	if !ok {
+		flags, ok = archFlags[""]
+	}
+	if !ok {
+		return "", fmt.Errorf("optimization profile %s is not supported on %s", profile, goarch)
+	}
+	return flags, nil
+}
+
+// SetOptimizationProfile selects the optimization profile of the build environment (see OptimizationProfile) and
+// injects its flags into CFLAGS, CXXFLAGS, FCFLAGS and FFLAGS. Flags already set in the environment come after the
+// flags of the profile so that they take precedence.
+func (env *Info) SetOptimizationProfile(profile string) error {
+	flags, err := GetOptimizationFlags(profile)
+	if err != nil {
+		return err
+	}
+	if len(env.Env) == 0 {
+		// Env is the entire environment when set
+		env.Env = os.Environ()
+	}
+	for _, name := range optimizationFlagsVars {
+		env.Env.Prepend(name, flags, " ")
+	}
+	env.OptimizationProfile = profile
+	return nil
+}
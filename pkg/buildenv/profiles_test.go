@@ -0,0 +1,58 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"testing"
+)
+
+func TestOptimizationProfiles(t *testing.T) {
+	savedGOARCH := goarch
+	defer func() { goarch = savedGOARCH }()
+
+	goarch = "amd64"
+	env := Info{Env: []string{"PATH=/usr/bin", "CFLAGS=-g"}}
+	err := env.SetOptimizationProfile(ProfileX86_64_V3)
+	if err != nil {
+		t.Fatalf("unable to set the optimization profile: %s", err)
+	}
+	if env.OptimizationProfile != ProfileX86_64_V3 {
+		t.Fatalf("optimization profile was not recorded: %s", env.OptimizationProfile)
+	}
+	if env.getEnvValue("CFLAGS") != "-O2 -march=x86-64-v3 -g" || env.getEnvValue("CXXFLAGS") != "-O2 -march=x86-64-v3" || env.getEnvValue("PATH") != "/usr/bin" {
+		t.Fatalf("invalid environment: %v", env.Env)
+	}
+
+	// Profiles are specific to architectures
+	err = env.SetOptimizationProfile(ProfileNeoverseN1)
+	if err == nil {
+		t.Fatalf("neoverse-n1 profile was accepted on amd64")
+	}
+	goarch = "arm64"
+	flags, err := GetOptimizationFlags(ProfileNeoverseN1)
+	if err != nil || flags != "-O2 -mcpu=neoverse-n1" {
+		t.Fatalf("invalid flags for neoverse-n1: %s (%v)", flags, err)
+	}
+	goarch = "ppc64le"
+	flags, err = GetOptimizationFlags(ProfileGeneric)
+	if err != nil || flags != "-O2" {
+		t.Fatalf("invalid flags for the generic profile: %s (%v)", flags, err)
+	}
+	_, err = GetOptimizationFlags("unknown")
+	if err == nil {
+		t.Fatalf("unknown profile was accepted")
+	}
+
+	// Without custom environment, the profile extends the environment of the process
+	env = Info{}
+	err = env.SetOptimizationProfile(ProfileGeneric)
+	if err != nil {
+		t.Fatalf("unable to set the optimization profile: %s", err)
+	}
+	if env.getEnvValue("PATH") == "" || env.getEnvValue("FFLAGS") != "-O2" {
+		t.Fatalf("invalid environment: %v", env.Env)
+	}
+}
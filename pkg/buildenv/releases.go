@@ -0,0 +1,370 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// LatestRelease is the version to use to get the latest release of a software hosted on GitHub or GitLab
+	LatestRelease = "latest"
+
+	forgeGitHub = "github"
+	forgeGitLab = "gitlab"
+
+	// gitlabHost is the host of the public GitLab instance, self-hosted instances must be listed in Info.GitLabHosts
+	gitlabHost = "gitlab.com"
+)
+
+// githubAPIURL is the base URL of the GitHub REST API
+var githubAPIURL = "https://api.github.com"
+
+// releaseVersionRegexp matches the versions of stable releases, e.g., 4.1.5 but not 5.0.0rc1
+var releaseVersionRegexp = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*$`)
+
+// forgeProject is a project hosted on GitHub or GitLab
+type forgeProject struct {
+	// kind is the type of the forge, forgeGitHub or forgeGitLab
+	kind string
+
+	// apiURL is the base URL of the REST API of the forge
+	apiURL string
+
+	// path is the path of the project, e.g., open-mpi/ompi
+	path string
+}
+
+// isGitLabHost returns whether a host is gitlab.com or one of the self-hosted GitLab instances of the build environment
+func (env *Info) isGitLabHost(host string) bool {
+	if host == gitlabHost {
+		return true
+	}
+	for _, h := range env.GitLabHosts {
+		if host == h {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForgeURL returns the GitHub or GitLab project a URL refers to, e.g., https://github.com/open-mpi/ompi.git,
+// git@github.com:open-mpi/ompi.git or https://github.com/open-mpi/ompi/releases/download/v4.1.5/openmpi-4.1.5.tar.gz
+func (env *Info) parseForgeURL(url string) (*forgeProject, error) {
+	var host, projectPath string
+	if strings.HasPrefix(url, "git@") {
+		tokens := strings.SplitN(strings.TrimPrefix(url, "git@"), ":", 2)
+		if len(tokens) != 2 {
+			return nil, fmt.Errorf("invalid URL %s", url)
+		}
+		host = tokens[0]
+		projectPath = tokens[1]
+	} else {
+		u, err := neturl.Parse(url)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL %s: %w", url, err)
+		}
+		host = u.Host
+		projectPath = u.Path
+	}
+
+	tokens := strings.Split(strings.Trim(projectPath, "/"), "/")
+	if len(tokens) < 2 {
+		return nil, fmt.Errorf("%s does not refer to a project", url)
+	}
+	p := new(forgeProject)
+	p.path = tokens[0] + "/" + strings.TrimSuffix(tokens[1], ".git")
+	switch {
+	case host == "github.com":
+		p.kind = forgeGitHub
+		p.apiURL = githubAPIURL
+	case env.isGitLabHost(host):
+		p.kind = forgeGitLab
+		p.apiURL = "https://" + host + "/api/v4"
+	default:
+		return nil, fmt.Errorf("%s is not hosted on GitHub or GitLab", url)
+	}
+	return p, nil
+}
+
+// addForgeToken authenticates a request to the GitHub or GitLab API with the token of the GITHUB_TOKEN or
+// GITLAB_TOKEN environment variable, if set, so that private projects can be accessed and rate limits are
+// higher. Tokens are only sent over TLS with a verified certificate, to the GitHub API and to gitlab.com or
+// the GitLab instances of the build environment (see GitLabHosts). It returns the name of the custom headers
+// that were added.
+func (env *Info) addForgeToken(req *http.Request) []string {
+	if env.checkSecureRequest(req) != nil {
+		return nil
+	}
+	githubAPI, err := neturl.Parse(githubAPIURL)
+	switch {
+	case err == nil && req.URL.Host == githubAPI.Host && os.Getenv("GITHUB_TOKEN") != "":
+		req.Header.Set("Authorization", "Bearer "+os.Getenv("GITHUB_TOKEN"))
+		if strings.Contains(req.URL.Path, "/releases/assets/") {
+			// Get the content of the asset rather than its description
+			req.Header.Set("Accept", "application/octet-stream")
+		}
+	case env.isGitLabHost(req.URL.Host) && strings.HasPrefix(req.URL.Path, "/api/v4/") && os.Getenv("GITLAB_TOKEN") != "":
+		req.Header.Set("PRIVATE-TOKEN", os.Getenv("GITLAB_TOKEN"))
+		return []string{"PRIVATE-TOKEN"}
+	}
+	return nil
+}
+
+// getForgeJSON sends a GET request to the API of the forge and decodes the JSON answer. The request is
+// authenticated like downloads (see Credential), so that private projects can be accessed.
+func (env *Info) getForgeJSON(ctx context.Context, p *forgeProject, apiPath string, data interface{}) error {
+	url := p.apiURL + apiPath
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("invalid request for %s: %w", url, err)
+	}
+	_, err = env.authenticate(req)
+	if err != nil {
+		return err
+	}
+
+	client, err := env.getHTTPClient()
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to get %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unable to get %s: %s", url, resp.Status)
+	}
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("unable to read answer from %s: %w", url, err)
+	}
+	err = json.Unmarshal(content, data)
+	if err != nil {
+		return fmt.Errorf("invalid answer from %s: %w", url, err)
+	}
+	return nil
+}
+
+// forgeRelease is a release or a tag as returned by the GitHub and GitLab APIs
+type forgeRelease struct {
+	TagName         string `json:"tag_name"`
+	Name            string `json:"name"`
+	Draft           bool   `json:"draft"`
+	Prerelease      bool   `json:"prerelease"`
+	UpcomingRelease bool   `json:"upcoming_release"`
+}
+
+// listReleaseTags returns the tags of the published releases of a project or, if the project does not
+// publish releases, all its tags. Only the first 100 entries returned by the API are considered.
+func (env *Info) listReleaseTags(ctx context.Context, p *forgeProject) ([]string, error) {
+	var releasesPath, tagsPath string
+	switch p.kind {
+	case forgeGitHub:
+		releasesPath = "/repos/" + p.path + "/releases?per_page=100"
+		tagsPath = "/repos/" + p.path + "/tags?per_page=100"
+	case forgeGitLab:
+		projectID := neturl.PathEscape(p.path)
+		releasesPath = "/projects/" + projectID + "/releases?per_page=100"
+		tagsPath = "/projects/" + projectID + "/repository/tags?per_page=100"
+	}
+
+	var releases []forgeRelease
+	err := env.getForgeJSON(ctx, p, releasesPath, &releases)
+	if err != nil {
+		return nil, err
+	}
+	var tags []string
+	for _, r := range releases {
+		if !r.Draft && !r.Prerelease && !r.UpcomingRelease && r.TagName != "" {
+			tags = append(tags, r.TagName)
+		}
+	}
+	if len(tags) > 0 {
+		return tags, nil
+	}
+
+	var allTags []forgeRelease
+	err = env.getForgeJSON(ctx, p, tagsPath, &allTags)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range allTags {
+		tags = append(tags, t.Name)
+	}
+	return tags, nil
+}
+
+// IsVersionConstraint returns whether a version is LatestRelease or a constraint such as ">=4.1 <5"
+// rather than an actual version
+func IsVersionConstraint(version string) bool {
+	return version == LatestRelease || strings.ContainsAny(version, "<>=!")
+}
+
+// MatchVersion returns whether a version satisfies a constraint, i.e., LatestRelease, which all
+// versions satisfy, or a space-separated list of comparisons that must all be true, e.g., ">=4.1 <5".
+// The supported operators are =, !=, <, <=, > and >=.
+func MatchVersion(version string, constraint string) (bool, error) {
+	if constraint == LatestRelease {
+		return true, nil
+	}
+	for _, c := range strings.Fields(constraint) {
+		op := strings.TrimRight(c, "0123456789.vx*")
+		ref := strings.TrimPrefix(c, op)
+		if ref == "" {
+			return false, fmt.Errorf("invalid version constraint %s", constraint)
+		}
+		cmp := compareVersions(version, ref)
+		var ok bool
+		switch op {
+		case "=", "==", "":
+			ok = cmp == 0
+		case "!=":
+			ok = cmp != 0
+		case "<":
+			ok = cmp < 0
+		case "<=":
+			ok = cmp <= 0
+		case ">":
+			ok = cmp > 0
+		case ">=":
+			ok = cmp >= 0
+		default:
+			return false, fmt.Errorf("invalid operator %s in version constraint %s", op, constraint)
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// ResolveVersion returns the most recent version of a software hosted on GitHub or GitLab that satisfies
+// a constraint (see MatchVersion), based on the releases or the tags of the project url refers to. Both the
+// version, e.g., 4.1.5, and the corresponding tag, e.g., v4.1.5, are returned. Release candidates and
+// other tags that are not stable versions are ignored.
+func (env *Info) ResolveVersion(ctx context.Context, url string, constraint string) (string, string, error) {
+	p, err := env.parseForgeURL(url)
+	if err != nil {
+		return "", "", err
+	}
+	tags, err := env.listReleaseTags(ctx, p)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to get the releases of %s: %w", p.path, err)
+	}
+
+	var candidates []string
+	for _, tag := range tags {
+		version := strings.TrimPrefix(tag, "v")
+		if !releaseVersionRegexp.MatchString(version) {
+			continue
+		}
+		ok, err := MatchVersion(version, constraint)
+		if err != nil {
+			return "", "", err
+		}
+		if ok {
+			candidates = append(candidates, tag)
+		}
+	}
+	if len(candidates) == 0 {
+		return "", "", fmt.Errorf("no release of %s matches %s", p.path, constraint)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return compareVersions(candidates[i], candidates[j]) < 0
+	})
+	tag := candidates[len(candidates)-1]
+	log.Printf("-> Version %s of %s resolved to %s", constraint, p.path, tag)
+	return strings.TrimPrefix(tag, "v"), tag, nil
+}
+
+// releaseAsset is a file attached to a release as returned by the GitHub and GitLab APIs
+type releaseAsset struct {
+	Name               string `json:"name"`
+	URL                string `json:"url"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+	DirectAssetURL     string `json:"direct_asset_url"`
+}
+
+// GetReleaseAssetURL returns the URL to download the asset of a release of a project hosted on GitHub or GitLab,
+// as well as the name of the asset. The asset is the first one whose name matches pattern, e.g., openmpi-*.tar.bz2,
+// and tag is the tag of the release, e.g., v4.1.5, or LatestRelease. For private GitHub projects, i.e., when
+// GITHUB_TOKEN is set, the URL is the one of the API, which requires authentication.
+func (env *Info) GetReleaseAssetURL(ctx context.Context, url string, tag string, pattern string) (string, string, error) {
+	p, err := env.parseForgeURL(url)
+	if err != nil {
+		return "", "", err
+	}
+
+	var releasePath string
+	switch {
+	case p.kind == forgeGitHub && tag == LatestRelease:
+		releasePath = "/repos/" + p.path + "/releases/latest"
+	case p.kind == forgeGitHub:
+		releasePath = "/repos/" + p.path + "/releases/tags/" + neturl.PathEscape(tag)
+	case tag == LatestRelease:
+		releasePath = "/projects/" + neturl.PathEscape(p.path) + "/releases/permalink/latest"
+	default:
+		releasePath = "/projects/" + neturl.PathEscape(p.path) + "/releases/" + neturl.PathEscape(tag)
+	}
+
+	var release struct {
+		TagName string          `json:"tag_name"`
+		Assets  json.RawMessage `json:"assets"`
+	}
+	err = env.getForgeJSON(ctx, p, releasePath, &release)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to get release %s of %s: %w", tag, p.path, err)
+	}
+	var assets []releaseAsset
+	if p.kind == forgeGitHub {
+		err = json.Unmarshal(release.Assets, &assets)
+	} else {
+		var gitlabAssets struct {
+			Links []releaseAsset `json:"links"`
+		}
+		err = json.Unmarshal(release.Assets, &gitlabAssets)
+		assets = gitlabAssets.Links
+	}
+	if err != nil {
+		return "", "", fmt.Errorf("invalid assets for release %s of %s: %w", tag, p.path, err)
+	}
+
+	var names []string
+	for _, asset := range assets {
+		match, err := path.Match(pattern, asset.Name)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid asset pattern %s: %w", pattern, err)
+		}
+		if !match {
+			names = append(names, asset.Name)
+			continue
+		}
+		switch {
+		case p.kind == forgeGitHub && os.Getenv("GITHUB_TOKEN") != "" && asset.URL != "":
+			return asset.URL, asset.Name, nil
+		case asset.BrowserDownloadURL != "":
+			return asset.BrowserDownloadURL, asset.Name, nil
+		case asset.DirectAssetURL != "":
+			return asset.DirectAssetURL, asset.Name, nil
+		default:
+			return asset.URL, asset.Name, nil
+		}
+	}
+	return "", "", fmt.Errorf("no asset of release %s of %s matches %s, available assets: %s", release.TagName, p.path, pattern, strings.Join(names, ", "))
+}
@@ -0,0 +1,212 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestResolveVersion(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/open-mpi/ompi/releases":
+			w.Write([]byte(`[{"tag_name": "v5.0.1rc1", "prerelease": true}, {"tag_name": "v5.0.0"}, {"tag_name": "v4.1.10"}, {"tag_name": "v4.1.9"}, {"tag_name": "v6.0.0", "draft": true}]`))
+		case "/repos/gvallee/notags/releases":
+			w.Write([]byte(`[]`))
+		case "/repos/gvallee/notags/tags":
+			w.Write([]byte(`[{"name": "1.2"}, {"name": "1.10"}, {"name": "nightly"}]`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	savedAPIURL := githubAPIURL
+	githubAPIURL = server.URL
+	defer func() { githubAPIURL = savedAPIURL }()
+
+	tests := []struct {
+		url             string
+		constraint      string
+		expectedVersion string
+		expectedTag     string
+		expectError     bool
+	}{
+		{url: "https://github.com/open-mpi/ompi.git", constraint: LatestRelease, expectedVersion: "5.0.0", expectedTag: "v5.0.0"},
+		{url: "git@github.com:open-mpi/ompi.git", constraint: ">=4.1 <5", expectedVersion: "4.1.10", expectedTag: "v4.1.10"},
+		{url: "https://github.com/open-mpi/ompi/releases/download/v4.1.9/openmpi-4.1.9.tar.gz", constraint: "<4.1.10", expectedVersion: "4.1.9", expectedTag: "v4.1.9"},
+		{url: "https://github.com/gvallee/notags", constraint: LatestRelease, expectedVersion: "1.10", expectedTag: "1.10"},
+		{url: "https://github.com/open-mpi/ompi.git", constraint: ">6", expectError: true},
+		{url: "https://github.com/unknown/project", constraint: LatestRelease, expectError: true},
+		{url: "https://example.com/project.tar.gz", constraint: LatestRelease, expectError: true},
+	}
+
+	var env Info
+	for _, tt := range tests {
+		version, tag, err := env.ResolveVersion(context.Background(), tt.url, tt.constraint)
+		if tt.expectError {
+			if err == nil {
+				t.Fatalf("%s (%s) resolved to %s instead of failing", tt.url, tt.constraint, version)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unable to resolve %s (%s): %s", tt.url, tt.constraint, err)
+		}
+		if version != tt.expectedVersion || tag != tt.expectedTag {
+			t.Fatalf("%s (%s) resolved to %s/%s instead of %s/%s", tt.url, tt.constraint, version, tag, tt.expectedVersion, tt.expectedTag)
+		}
+	}
+}
+
+func TestGetReleaseAssetURL(t *testing.T) {
+	var authorization string
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		assets := `"assets": [{"name": "openmpi-5.0.0.tar.gz", "url": "https://api.github.com/repos/open-mpi/ompi/releases/assets/1", "browser_download_url": "https://github.com/open-mpi/ompi/releases/download/v5.0.0/openmpi-5.0.0.tar.gz"}, {"name": "openmpi-5.0.0.tar.bz2", "url": "https://api.github.com/repos/open-mpi/ompi/releases/assets/2", "browser_download_url": "https://github.com/open-mpi/ompi/releases/download/v5.0.0/openmpi-5.0.0.tar.bz2"}]`
+		switch r.URL.Path {
+		case "/repos/open-mpi/ompi/releases/latest", "/repos/open-mpi/ompi/releases/tags/v5.0.0":
+			w.Write([]byte(`{"tag_name": "v5.0.0", ` + assets + `}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+	savedAPIURL := githubAPIURL
+	githubAPIURL = server.URL
+	defer func() { githubAPIURL = savedAPIURL }()
+	savedToken, tokenSet := os.LookupEnv("GITHUB_TOKEN")
+	os.Unsetenv("GITHUB_TOKEN")
+	defer func() {
+		if tokenSet {
+			os.Setenv("GITHUB_TOKEN", savedToken)
+		} else {
+			os.Unsetenv("GITHUB_TOKEN")
+		}
+	}()
+
+	tests := []struct {
+		tag         string
+		pattern     string
+		token       string
+		expectedURL string
+		expectError bool
+	}{
+		{tag: LatestRelease, pattern: "openmpi-*.tar.bz2", expectedURL: "https://github.com/open-mpi/ompi/releases/download/v5.0.0/openmpi-5.0.0.tar.bz2"},
+		{tag: "v5.0.0", pattern: "*.tar.gz", expectedURL: "https://github.com/open-mpi/ompi/releases/download/v5.0.0/openmpi-5.0.0.tar.gz"},
+		{tag: "v5.0.0", pattern: "*.tar.gz", token: "secret", expectedURL: "https://api.github.com/repos/open-mpi/ompi/releases/assets/1"},
+		{tag: "v5.0.0", pattern: "*.zip", expectError: true},
+		{tag: "v4.1.5", pattern: "*.tar.gz", expectError: true},
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+	var env Info
+	env.CABundle = writeCABundle(t, server, testDir)
+	for _, tt := range tests {
+		os.Setenv("GITHUB_TOKEN", tt.token)
+		url, name, err := env.GetReleaseAssetURL(context.Background(), "https://github.com/open-mpi/ompi", tt.tag, tt.pattern)
+		if tt.expectError {
+			if err == nil {
+				t.Fatalf("asset %s of release %s resolved to %s instead of failing", tt.pattern, tt.tag, url)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unable to get asset %s of release %s: %s", tt.pattern, tt.tag, err)
+		}
+		if url != tt.expectedURL || name != path.Base(tt.expectedURL) && tt.token == "" {
+			t.Fatalf("asset %s of release %s resolved to %s (%s) instead of %s", tt.pattern, tt.tag, url, name, tt.expectedURL)
+		}
+		if tt.token != "" && authorization != "Bearer "+tt.token {
+			t.Fatalf("invalid authorization header for the GitHub API: %s", authorization)
+		}
+	}
+}
+
+func TestGitLabHosts(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() == "/api/v4/projects/hpc%2Fucx/releases" {
+			w.Write([]byte(`[{"tag_name": "v1.15.0"}, {"tag_name": "v1.14.1"}]`))
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+	host := strings.TrimPrefix(server.URL, "https://")
+
+	var env Info
+	env.CABundle = writeCABundle(t, server, testDir)
+	for _, url := range []string{server.URL + "/hpc/ucx.git", "https://gitlab.attacker.example/hpc/ucx.git"} {
+		_, _, err = env.ResolveVersion(context.Background(), url, LatestRelease)
+		if err == nil || !strings.Contains(err.Error(), "is not hosted on GitHub or GitLab") {
+			t.Fatalf("%s is considered as a GitLab instance without being configured: %v", url, err)
+		}
+	}
+
+	env.GitLabHosts = []string{host}
+	version, _, err := env.ResolveVersion(context.Background(), server.URL+"/hpc/ucx.git", LatestRelease)
+	if err != nil {
+		t.Fatalf("unable to resolve the version of a project of a self-hosted GitLab instance: %s", err)
+	}
+	if version != "1.15.0" {
+		t.Fatalf("version resolved to %s instead of 1.15.0", version)
+	}
+}
+
+func TestForgeTokens(t *testing.T) {
+	for name, value := range map[string]string{"GITHUB_TOKEN": "github-secret", "GITLAB_TOKEN": "gitlab-secret"} {
+		savedValue, set := os.LookupEnv(name)
+		os.Setenv(name, value)
+		defer func(name string) {
+			if set {
+				os.Setenv(name, savedValue)
+			} else {
+				os.Unsetenv(name)
+			}
+		}(name)
+	}
+
+	tests := []struct {
+		url           string
+		insecure      bool
+		expectedToken string
+	}{
+		{url: "https://api.github.com/repos/open-mpi/ompi/releases", expectedToken: "Bearer github-secret"},
+		{url: "https://gitlab.com/api/v4/projects/1/releases", expectedToken: "gitlab-secret"},
+		{url: "https://gitlab.example.com/api/v4/projects/1/releases", expectedToken: "gitlab-secret"},
+		{url: "https://gitlab.attacker.example/api/v4/projects/1/releases"},
+		{url: "https://api.github.com.attacker.example/repos/open-mpi/ompi/releases"},
+		{url: "http://gitlab.example.com/api/v4/projects/1/releases"},
+		{url: "https://api.github.com/repos/open-mpi/ompi/releases", insecure: true},
+	}
+	for _, tt := range tests {
+		env := Info{GitLabHosts: []string{"gitlab.example.com"}, InsecureTLS: tt.insecure}
+		req, err := http.NewRequest(http.MethodGet, tt.url, nil)
+		if err != nil {
+			t.Fatalf("invalid request for %s: %s", tt.url, err)
+		}
+		env.addForgeToken(req)
+		token := req.Header.Get("Authorization") + req.Header.Get("PRIVATE-TOKEN")
+		if token != tt.expectedToken {
+			t.Fatalf("token sent to %s (insecure: %t): %q instead of %q", tt.url, tt.insecure, token, tt.expectedToken)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+)
+
+// Runner executes the commands of a build environment, e.g., git, tar or make. A custom runner can be set
+// in the build environment (see Info.Runner) to mock these commands, for instance to test failure paths
+// without network access or compilers.
+type Runner interface {
+	// LookPath searches for an executable, like exec.LookPath
+	LookPath(file string) (string, error)
+
+	// Run executes a command and waits for its completion, like exec.Cmd.Run
+	Run(cmd *exec.Cmd) error
+
+	// RunAdvcmd executes a command and returns its output, like advexec.Advcmd.Run
+	RunAdvcmd(cmd *advexec.Advcmd) advexec.Result
+}
+
+// FS is the file system operations of a build environment. A custom file system can be set in the build
+// environment (see Info.FS), e.g., to emulate errors such as a full disk.
+type FS interface {
+	// Stat returns a FileInfo describing a file, like os.Stat
+	Stat(name string) (os.FileInfo, error)
+
+	// MkdirAll creates a directory and all its missing parents, like os.MkdirAll
+	MkdirAll(path string, perm os.FileMode) error
+
+	// Remove removes a file or an empty directory, like os.Remove
+	Remove(name string) error
+
+	// RemoveAll removes a path and all its content, like os.RemoveAll
+	RemoveAll(path string) error
+
+	// Rename renames a file or a directory, like os.Rename
+	Rename(oldpath string, newpath string) error
+
+	// ReadDir returns the content of a directory, like ioutil.ReadDir
+	ReadDir(dirname string) ([]os.FileInfo, error)
+}
+
+// HostRunner is the default runner, executing commands on the host. It can be embedded in a custom runner
+// to only mock some of the operations.
+type HostRunner struct{}
+
+// LookPath searches for an executable in the directories of the PATH environment variable
+func (r HostRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+// Run executes a command and waits for its completion
+func (r HostRunner) Run(cmd *exec.Cmd) error {
+	return cmd.Run()
+}
+
+// RunAdvcmd executes a command and returns its output
+func (r HostRunner) RunAdvcmd(cmd *advexec.Advcmd) advexec.Result {
+	return cmd.Run()
+}
+
+// HostFS is the default file system, i.e., the file system of the host. It can be embedded in a custom
+// file system to only mock some of the operations.
+type HostFS struct{}
+
+// Stat returns a FileInfo describing a file
+func (fs HostFS) Stat(name string) (os.FileInfo, error) {
+	return os.Stat(name)
+}
+
+// MkdirAll creates a directory and all its missing parents
+func (fs HostFS) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(path, perm)
+}
+
+// Remove removes a file or an empty directory
+func (fs HostFS) Remove(name string) error {
+	return os.Remove(name)
+}
+
+// RemoveAll removes a path and all its content
+func (fs HostFS) RemoveAll(path string) error {
+	return os.RemoveAll(path)
+}
+
+// Rename renames a file or a directory
+func (fs HostFS) Rename(oldpath string, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
+// ReadDir returns the content of a directory
+func (fs HostFS) ReadDir(dirname string) ([]os.FileInfo, error) {
+	return ioutil.ReadDir(dirname)
+}
+
+// GetRunner returns the runner executing the commands of the build environment, HostRunner by default
+func (env *Info) GetRunner() Runner {
+	if env.Runner != nil {
+		return env.Runner
+	}
+	return HostRunner{}
+}
+
+// GetFS returns the file system of the build environment, HostFS by default
+func (env *Info) GetFS() FS {
+	if env.FS != nil {
+		return env.FS
+	}
+	return HostFS{}
+}
+
+// pathExists checks whether a path exists on the file system of the build environment
+func (env *Info) pathExists(path string) bool {
+	_, err := env.GetFS().Stat(path)
+	return err == nil
+}
+
+// isDir checks whether a path is a directory on the file system of the build environment
+func (env *Info) isDir(path string) bool {
+	info, err := env.GetFS().Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// mkdirAll creates a directory, and its missing parents, on the file system of the build environment
+// when it does not already exist
+func (env *Info) mkdirAll(dir string) error {
+	if env.pathExists(dir) {
+		return nil
+	}
+	return env.GetFS().MkdirAll(dir, defaultDirMode)
+}
@@ -0,0 +1,118 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_software_build/pkg/app"
+)
+
+// mockRunner records the commands instead of executing them, the commands including failPattern failing
+type mockRunner struct {
+	cmds        []string
+	failPattern string
+}
+
+func (r *mockRunner) LookPath(file string) (string, error) {
+	return "/mock/bin/" + file, nil
+}
+
+func (r *mockRunner) Run(cmd *exec.Cmd) error {
+	cmdline := strings.Join(cmd.Args, " ")
+	r.cmds = append(r.cmds, cmdline)
+	if r.failPattern != "" && strings.Contains(cmdline, r.failPattern) {
+		fmt.Fprintf(cmd.Stderr, "mock failure")
+		return fmt.Errorf("exit status 1")
+	}
+	return nil
+}
+
+func (r *mockRunner) RunAdvcmd(cmd *advexec.Advcmd) advexec.Result {
+	var res advexec.Result
+	cmdline := strings.TrimSpace(cmd.BinPath + " " + strings.Join(cmd.CmdArgs, " "))
+	r.cmds = append(r.cmds, cmdline)
+	if r.failPattern != "" && strings.Contains(cmdline, r.failPattern) {
+		res.Stderr = "mock failure"
+		res.Err = fmt.Errorf("exit status 1")
+	}
+	return res
+}
+
+// mockFS is the file system of the host where directories cannot be created
+type mockFS struct {
+	HostFS
+}
+
+func (fs mockFS) MkdirAll(path string, perm os.FileMode) error {
+	return fmt.Errorf("no space left on device")
+}
+
+func TestMockedRunner(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	runner := &mockRunner{failPattern: "checkout"}
+	env := &Info{
+		BuildDir: filepath.Join(testDir, "build"),
+		SrcDir:   filepath.Join(testDir, "src"),
+		Runner:   runner,
+	}
+	p := &app.Info{Name: "repo"}
+	p.Source.URL = "https://github.com/example/repo.git"
+	p.Source.Branch = "v1.0"
+	err = env.Get(p)
+	if err == nil || !strings.Contains(err.Error(), "mock failure") {
+		t.Fatalf("the failure of git checkout was not reported: %v", err)
+	}
+	expectedCmds := []string{
+		"/mock/bin/git clone https://github.com/example/repo.git repo",
+		"/mock/bin/git checkout v1.0",
+	}
+	if strings.Join(runner.cmds, "|") != strings.Join(expectedCmds, "|") {
+		t.Fatalf("unexpected commands %q instead of %q", runner.cmds, expectedCmds)
+	}
+
+	runner = &mockRunner{}
+	env.Runner = runner
+	env.SrcDir = "/mock/src"
+	env.MakeVars = map[string]string{"CC": "gcc"}
+	err = env.RunMake(false, MakeInstallStage, "/mock/src/Makefile", nil)
+	if err != nil {
+		t.Fatalf("unable to run make: %s", err)
+	}
+	if len(runner.cmds) != 1 || runner.cmds[0] != "make -j install CC=gcc" {
+		t.Fatalf("unexpected commands: %q", runner.cmds)
+	}
+	runner.failPattern = "make"
+	err = env.RunMake(false, "", "/mock/src/Makefile", nil)
+	if err == nil || !strings.Contains(err.Error(), "mock failure") {
+		t.Fatalf("the failure of make was not reported: %v", err)
+	}
+}
+
+func TestMockedFS(t *testing.T) {
+	env := &Info{
+		ScratchDir: "/mock/scratch",
+		BuildDir:   "/mock/build",
+		InstallDir: "/mock/install",
+		FS:         mockFS{},
+	}
+	err := env.Init()
+	if err == nil || !strings.Contains(err.Error(), "no space left on device") {
+		t.Fatalf("the failure to create the directories was not reported: %v", err)
+	}
+}
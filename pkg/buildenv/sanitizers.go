@@ -0,0 +1,88 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// SanitizerAddress is AddressSanitizer (ASan), detecting out-of-bounds accesses and use-after-free bugs
+	SanitizerAddress = "address"
+
+	// SanitizerUndefined is UndefinedBehaviorSanitizer (UBSan), detecting undefined behaviors such as overflows
+	SanitizerUndefined = "undefined"
+
+	// SanitizerThread is ThreadSanitizer (TSan), detecting data races; it cannot be combined with AddressSanitizer
+	SanitizerThread = "thread"
+)
+
+// sanitizerRuntimeEnv is the environment variable configuring the runtime of each sanitizer and its default value,
+// which keeps instrumented MPI stacks usable, e.g., the leaks of MPI runtimes are not reported
+var sanitizerRuntimeEnv = map[string][2]string{
+	SanitizerAddress:   {"ASAN_OPTIONS", "detect_leaks=0:abort_on_error=1"},
+	SanitizerUndefined: {"UBSAN_OPTIONS", "print_stacktrace=1"},
+	SanitizerThread:    {"TSAN_OPTIONS", "second_deadlock_stack=1"},
+}
+
+// GetSanitizers returns the name of all the sanitizers
+func GetSanitizers() []string {
+	var sanitizers []string
+	for name := range sanitizerRuntimeEnv {
+		sanitizers = append(sanitizers, name)
+	}
+	sort.Strings(sanitizers)
+	return sanitizers
+}
+
+// GetSanitizerFlags returns the compiler flags to instrument software with a set of sanitizers, e.g.,
+// "-fsanitize=address,undefined -fno-omit-frame-pointer"
+func GetSanitizerFlags(sanitizers []string) (string, error) {
+	enabled := make(map[string]bool)
+	for _, name := range sanitizers {
+		if _, ok := sanitizerRuntimeEnv[name]; !ok {
+			return "", fmt.Errorf("unknown sanitizer %s, valid sanitizers are: %s", name, strings.Join(GetSanitizers(), ", "))
+		}
+		enabled[name] = true
+	}
+	if enabled[SanitizerAddress] && enabled[SanitizerThread] {
+		return "", fmt.Errorf("the %s and %s sanitizers cannot be combined", SanitizerAddress, SanitizerThread)
+	}
+	return "-fsanitize=" + strings.Join(sanitizers, ",") + " -fno-omit-frame-pointer", nil
+}
+
+// GetSanitizerEnv returns the environment variables configuring the runtime of a set of sanitizers, e.g.,
+// ASAN_OPTIONS, to set when running the instrumented software
+func GetSanitizerEnv(sanitizers []string) map[string]string {
+	env := make(map[string]string)
+	for _, name := range sanitizers {
+		if runtimeEnv, ok := sanitizerRuntimeEnv[name]; ok {
+			env[runtimeEnv[0]] = runtimeEnv[1]
+		}
+	}
+	return env
+}
+
+// SetSanitizers instruments the software built in the build environment with a set of sanitizers (see Sanitizers)
+// by injecting their flags into CFLAGS, CXXFLAGS, FCFLAGS, FFLAGS and LDFLAGS
+func (env *Info) SetSanitizers(sanitizers []string) error {
+	flags, err := GetSanitizerFlags(sanitizers)
+	if err != nil {
+		return err
+	}
+	if len(env.Env) == 0 {
+		// Env is the entire environment when set
+		env.Env = os.Environ()
+	}
+	for _, name := range append(optimizationFlagsVars, "LDFLAGS") {
+		env.Env.Prepend(name, flags, " ")
+	}
+	env.Sanitizers = sanitizers
+	return nil
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"testing"
+)
+
+func TestSanitizers(t *testing.T) {
+	env := Info{Env: []string{"PATH=/usr/bin", "LDFLAGS=-L/opt/lib"}}
+	err := env.SetSanitizers([]string{SanitizerAddress, SanitizerUndefined})
+	if err != nil {
+		t.Fatalf("unable to set the sanitizers: %s", err)
+	}
+	flags := "-fsanitize=address,undefined -fno-omit-frame-pointer"
+	if env.getEnvValue("CFLAGS") != flags || env.getEnvValue("LDFLAGS") != flags+" -L/opt/lib" || len(env.Sanitizers) != 2 {
+		t.Fatalf("invalid environment: %v", env.Env)
+	}
+	runtimeEnv := GetSanitizerEnv(env.Sanitizers)
+	if runtimeEnv["ASAN_OPTIONS"] == "" || runtimeEnv["UBSAN_OPTIONS"] == "" || len(runtimeEnv) != 2 {
+		t.Fatalf("invalid runtime environment: %v", runtimeEnv)
+	}
+
+	invalidSanitizers := [][]string{
+		{"unknown"},
+		{SanitizerAddress, SanitizerThread},
+	}
+	for _, sanitizers := range invalidSanitizers {
+		_, err = GetSanitizerFlags(sanitizers)
+		if err == nil {
+			t.Fatalf("invalid sanitizers %v were accepted", sanitizers)
+		}
+	}
+}
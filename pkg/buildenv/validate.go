@@ -0,0 +1,122 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// isWritableDir returns why a directory cannot be used to write files, an empty string if it can. A directory
+// that does not exist yet is writable if it can be created, i.e., if its closest existing parent is writable.
+func isWritableDir(dir string) string {
+	for {
+		info, err := os.Stat(dir)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Sprintf("%s is not a directory", dir)
+			}
+			break
+		}
+		if !os.IsNotExist(err) {
+			return err.Error()
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return fmt.Sprintf("%s does not exist", dir)
+		}
+		dir = parent
+	}
+
+	f, err := ioutil.TempFile(dir, ".write_test")
+	if err != nil {
+		return fmt.Sprintf("%s is not writable", dir)
+	}
+	f.Close()
+	os.Remove(f.Name())
+	return ""
+}
+
+// isSubDir returns whether dir is parent or one of its sub-directories
+func isSubDir(dir string, parent string) bool {
+	return dir == parent || strings.HasPrefix(dir, parent+string(filepath.Separator))
+}
+
+// Validate checks that the directories of the build environment are set, absolute, writable and not nested inside
+// each other. All the problems are reported at once, before anything is built.
+func (env *Info) Validate() error {
+	var problems []string
+
+	requiredDirs := []struct {
+		name string
+		dir  string
+	}{
+		{"scratch directory", env.ScratchDir},
+		{"build directory", env.BuildDir},
+		{"install directory", env.InstallDir},
+	}
+	optionalDirs := []struct {
+		name string
+		dir  string
+	}{
+		{"configure cache directory", env.ConfigureCacheDir},
+		{"Git cache directory", env.GitCacheDir},
+	}
+
+	var validDirs []int
+	for idx, d := range requiredDirs {
+		if d.dir == "" {
+			problems = append(problems, fmt.Sprintf("%s is undefined", d.name))
+			continue
+		}
+		if !filepath.IsAbs(d.dir) {
+			problems = append(problems, fmt.Sprintf("%s %s is not an absolute path", d.name, d.dir))
+			continue
+		}
+		if reason := isWritableDir(d.dir); reason != "" {
+			problems = append(problems, fmt.Sprintf("invalid %s: %s", d.name, reason))
+		}
+		validDirs = append(validDirs, idx)
+	}
+	for _, d := range optionalDirs {
+		if d.dir == "" {
+			continue
+		}
+		if !filepath.IsAbs(d.dir) {
+			problems = append(problems, fmt.Sprintf("%s %s is not an absolute path", d.name, d.dir))
+			continue
+		}
+		if reason := isWritableDir(d.dir); reason != "" {
+			problems = append(problems, fmt.Sprintf("invalid %s: %s", d.name, reason))
+		}
+	}
+
+	// Nested directories would for instance make cleaning the build directory remove the installed software
+	for i := 0; i < len(validDirs); i++ {
+		for j := i + 1; j < len(validDirs); j++ {
+			d1 := requiredDirs[validDirs[i]]
+			d2 := requiredDirs[validDirs[j]]
+			dir1 := filepath.Clean(d1.dir)
+			dir2 := filepath.Clean(d2.dir)
+			switch {
+			case dir1 == dir2:
+				problems = append(problems, fmt.Sprintf("%s and %s are both %s", d1.name, d2.name, dir1))
+			case isSubDir(dir1, dir2):
+				problems = append(problems, fmt.Sprintf("%s %s is inside %s %s", d1.name, dir1, d2.name, dir2))
+			case isSubDir(dir2, dir1):
+				problems = append(problems, fmt.Sprintf("%s %s is inside %s %s", d2.name, dir2, d1.name, dir1))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid build environment: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
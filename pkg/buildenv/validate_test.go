@@ -0,0 +1,92 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package buildenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+	notADir := filepath.Join(testDir, "file")
+	err = ioutil.WriteFile(notADir, []byte("test"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", notADir, err)
+	}
+
+	tests := []struct {
+		env              Info
+		expectedProblems []string
+	}{
+		{
+			// Directories that do not exist yet are valid as long as they can be created
+			env: Info{
+				ScratchDir: filepath.Join(testDir, "scratch"),
+				BuildDir:   filepath.Join(testDir, "build"),
+				InstallDir: filepath.Join(testDir, "a", "b", "install"),
+			},
+		},
+		{
+			env: Info{},
+			expectedProblems: []string{
+				"scratch directory is undefined",
+				"build directory is undefined",
+				"install directory is undefined",
+			},
+		},
+		{
+			env: Info{
+				ScratchDir:  "scratch",
+				BuildDir:    filepath.Join(testDir, "build"),
+				InstallDir:  filepath.Join(notADir, "install"),
+				GitCacheDir: "cache",
+			},
+			expectedProblems: []string{
+				"scratch directory scratch is not an absolute path",
+				"invalid install directory: ",
+				"Git cache directory cache is not an absolute path",
+			},
+		},
+		{
+			env: Info{
+				ScratchDir: filepath.Join(testDir, "build"),
+				BuildDir:   filepath.Join(testDir, "build") + "/",
+				InstallDir: filepath.Join(testDir, "build", "install"),
+			},
+			expectedProblems: []string{
+				"scratch directory and build directory are both " + filepath.Join(testDir, "build"),
+				"install directory " + filepath.Join(testDir, "build", "install") + " is inside scratch directory",
+				"install directory " + filepath.Join(testDir, "build", "install") + " is inside build directory",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		err := tt.env.Validate()
+		if len(tt.expectedProblems) == 0 {
+			if err != nil {
+				t.Fatalf("valid build environment was rejected: %s", err)
+			}
+			continue
+		}
+		if err == nil {
+			t.Fatalf("invalid build environment was not detected: %v", tt.env)
+		}
+		for _, problem := range tt.expectedProblems {
+			if !strings.Contains(err.Error(), problem) {
+				t.Fatalf("%s is not reported: %s", problem, err)
+			}
+		}
+	}
+}
@@ -9,16 +9,20 @@
 package builder
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
 	"path"
 	"path/filepath"
+	"time"
 
 	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_exec/pkg/manifest"
 	"github.com/gvallee/go_software_build/internal/pkg/autotools"
 	"github.com/gvallee/go_software_build/pkg/app"
 	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_software_build/pkg/metrics"
 	"github.com/gvallee/go_util/pkg/util"
 )
 
@@ -28,19 +32,32 @@ type GetConfigureExtraArgsFn func() []string
 // ConfigureFn is the function prototype to configuration a specific software
 type ConfigureFn func(*buildenv.Info, string, []string, string) error
 
+// StageFn is the function prototype for Go callbacks reporting the stages of a build, e.g., StageConfigure, when
+// they start and complete, err being the error of a stage that failed
+type StageFn func(stage string, done bool, err error)
+
 // Builder gathers all the data specific to a software builder
 type Builder struct {
 	// Persistent is the path where to store all the software when we need a persistent install (in opposition to temporary install)
 	// Persistent is an empty string when there is no need for a persistent install
 	Persistent string
 
-	// SudoRequired specifies if install commands needs to be executed with sudo
-	// Note that it is assumed sudo does not require a password, there is no support for interactive password management
+	// Force specifies whether the software must be installed again even if it is already installed,
+	// e.g., when it is built from source code that is being modified
+	Force bool
+
+	// SudoRequired specifies if install commands needs to be executed with elevated privileges.
+	// The command used for it is defined by the build environment (see Env.EscalationCmd and Env.Escalate), sudo by default.
+	// Note that there is no support for interactive password management, e.g., sudo must not require a password or rely on an askpass helper
 	SudoRequired bool
 
-	// Configure is the function to call to configure the software
+	// Configure is the function to call to configure the software when it relies on autotools
 	Configure ConfigureFn
 
+	// BuildSystem is the build system used to configure, build, install and test the software.
+	// When not set, it is automatically detected from the source code (see RegisterBuildSystem)
+	BuildSystem BuildSystem
+
 	// ConfigureExtraArgs is the extra arguments for the configuration command
 	ConfigureExtraArgs []string
 
@@ -52,6 +69,46 @@ type Builder struct {
 
 	// BuildScript is the script to invoke to build the package
 	BuildScript string
+
+	// PreInstallCmd is a command executed from the source/build directory, right before installing the package (optional)
+	PreInstallCmd string
+
+	// PostInstallCmd is a command executed from the installation directory, right after installing the package (optional)
+	PostInstallCmd string
+
+	// StagedInstall specifies whether the software is installed in a staging directory, i.e., with DESTDIR, before
+	// being copied to its installation directory. The exact list of installed files is then recorded (see
+	// InstalledFilesFilename) and a failed installation does not leave a partially written installation directory.
+	// The software must support DESTDIR and it is not used when SudoRequired is set (optional)
+	StagedInstall bool
+
+	// InstalledFiles is the list of the files installed by the last staged installation, relative to the
+	// installation directory of the software
+	InstalledFiles []string
+
+	// CommandsFile is the path to the file the commands executed by Install() and Test() are recorded in,
+	// with their environment, so that they can be replayed, e.g., to iterate on a failing step (see
+	// ReplayCommands) (optional)
+	CommandsFile string
+
+	// TraceContext is the context holding the span the spans of the build stages are children of, e.g., the
+	// installation of a stack (optional, see metrics.SetTracer)
+	TraceContext context.Context
+
+	// OnStage is called when each stage of the build starts and completes, e.g., to report the progress of the
+	// build (optional)
+	OnStage StageFn
+
+	// stage is the stage of the build being executed (see startStage) and commands the commands recorded
+	// since the beginning of the build (see CommandsFile)
+	stage    string
+	commands []RecordedCommand
+
+	// stageDurations is the duration of the stages executed by the last call to Install()
+	stageDurations map[string]time.Duration
+
+	// built specifies whether the software was built by Install(), i.e., it can be tested
+	built bool
 }
 
 var makefileSpellings = []string{"Makefile", "makefile"}
@@ -59,12 +116,18 @@ var makefileSpellings = []string{"Makefile", "makefile"}
 // GenericConfigure is a generic function to configure a software, basically a wrapper around autotool's configure
 func GenericConfigure(env *buildenv.Info, appName string, extraArgs []string, configurePreludeCmd string) error {
 	var ac autotools.Config
-	ac.Install = filepath.Join(env.InstallDir, appName)
+	ac.Install = env.GetAppInstallDir(&app.Info{Name: appName})
 	ac.Source = env.SrcDir
 	ac.ConfigureEnv = env.Env
 	ac.ExtraConfigureArgs = extraArgs
 	ac.ConfigurePreludeCmd = configurePreludeCmd
-	err := ac.Configure()
+	cacheFile, err := env.GetConfigureCacheFile()
+	if err != nil {
+		return fmt.Errorf("unable to get the configure cache: %w", err)
+	}
+	ac.CacheFile = cacheFile
+	ac.Runner = env.GetRunner()
+	err = ac.Configure()
 	if err != nil {
 		return fmt.Errorf("failed to configure software: %s", err)
 	}
@@ -113,28 +176,18 @@ func (b *Builder) compile(pkg *app.Info, env *buildenv.Info) advexec.Result {
 		}
 		log.Printf("-> Building with %s from %s\n", destFile, env.SrcDir)
 		var cmd advexec.Advcmd
-		cmd.BinPath = destFile
+		var err error
+		cmd.BinPath, cmd.CmdArgs, err = env.LimitCmd(destFile, nil)
+		if err != nil {
+			res.Err = err
+			return res
+		}
 		cmd.ExecDir = env.SrcDir
-		res = cmd.Run()
+		res = env.GetRunner().RunAdvcmd(&cmd)
 		return res
 	}
 
-	if env.SrcDir == "" {
-		res.Err = fmt.Errorf("invalid parameter(s)")
-		return res
-	}
-
-	pkg.AutotoolsCfg.Detect()
-	makefilePath, makeExtraArgs, err := findMakefile(env)
-	if err != nil {
-		log.Printf("-> No Makefile, trying to figure out how to compile/install %s...", pkg.Name)
-		res.Err = fmt.Errorf("failed to figure out how to compile %s", pkg.Name)
-		return res
-	}
-
-	makefileStage := ""
-	res.Err = env.RunMake(false, makefileStage, makefilePath, makeExtraArgs)
-	return res
+	return b.getBuildSystem().Build(b)
 }
 
 func (b *Builder) install(pkg *app.Info, env *buildenv.Info) advexec.Result {
@@ -145,50 +198,56 @@ func (b *Builder) install(pkg *app.Info, env *buildenv.Info) advexec.Result {
 		return res
 	}
 
-	if pkg.AutotoolsCfg.HasMakeInstall {
-		// The Makefile has a 'install' target so we just use it
-		targetDir := filepath.Join(env.InstallDir, pkg.Name)
-		if !util.PathExists(targetDir) {
-			err := os.Mkdir(targetDir, 0755)
+	if b.StagedInstall && env.DestDir == "" {
+		if !b.SudoRequired {
+			return b.stagedInstall(pkg, env)
+		}
+		log.Printf("[WARN] staged installations are not supported with elevated privileges, installing %s directly", pkg.Name)
+	}
+
+	if pkg.InstallCmd != "" {
+		// The package has its own install command, e.g., './b2 install'
+		targetDir := env.GetAppInstallDir(pkg)
+		if !env.IsInstalled(pkg) {
+			err := env.GetFS().MkdirAll(targetDir, 0755)
 			if err != nil {
 				res.Err = err
 				return res
 			}
 		}
 
-		log.Printf("- Installing %s in %s using 'make install'...", pkg.Name, targetDir)
-		makefilePath, makeExtraArgs, err := findMakefile(env)
-		if err != nil {
-			res.Err = fmt.Errorf("unable to find Makefile: %s", err)
-			return res
-		}
-		res.Err = env.RunMake(b.SudoRequired, "install", makefilePath, makeExtraArgs)
+		log.Printf("- Installing %s in %s using '%s'...", pkg.Name, targetDir, pkg.InstallCmd)
+		appEnv := *env
+		appEnv.InstallDir = targetDir
+		res.Err = appEnv.Install(pkg)
 	} else {
-		// Copy binaries and libraries to the install directory
-		log.Printf("- 'make install' not available, copying files...")
-		var cmd advexec.Advcmd
-		cmd.BinPath = "cp"
-		cmd.CmdArgs = []string{"-rf", env.GetAppBuildDir(pkg), env.InstallDir}
-		res := cmd.Run()
-		if res.Err != nil {
-			return res
-		}
+		res = b.getBuildSystem().Install(b)
 	}
 
 	return res
 }
 
-// Install installs a software package on the host
-func (b *Builder) Install() advexec.Result {
+// Install installs a software package on the host and returns where and how it was built and installed
+func (b *Builder) Install() *InstallResult {
+	b.built = false
+	b.stageDurations = make(map[string]time.Duration)
+	res := b.installSoftware()
+	result := b.newInstallResult(res)
+	b.stageDurations = nil
+	return result
+}
+
+// installSoftware downloads, builds and installs the software, unless it is already installed
+func (b *Builder) installSoftware() advexec.Result {
 	var res advexec.Result
 
 	// Sanity checks
 	if b.Env.InstallDir == "" {
-		res.Err = fmt.Errorf("undefined install directory")
+		res.Err = b.newBuildError(StagePrepare, fmt.Errorf("undefined install directory"))
 		return res
 	}
 	if b.App.Source.URL == "" {
-		res.Err = fmt.Errorf("undefined application's URL")
+		res.Err = b.newBuildError(StagePrepare, fmt.Errorf("undefined application's URL"))
 		return res
 	}
 
@@ -201,56 +260,170 @@ func (b *Builder) Install() advexec.Result {
 		}
 		appInstallDir = b.Env.GetAppInstallDir(&b.App)
 	}
-	if util.PathExists(appInstallDir) {
-		log.Printf("* %s already exists, skipping installation...", appInstallDir)
-		b.Env.SrcDir = appInstallDir
-		return res
+	if b.Env.IsInstalled(&b.App) {
+		if !b.Force {
+			log.Printf("* %s already exists, skipping installation...", appInstallDir)
+			b.Env.SrcDir = appInstallDir
+			return res
+		}
+		log.Printf("* %s already exists, installing again...", appInstallDir)
+		res.Err = b.Env.GetFS().RemoveAll(appInstallDir)
+		if res.Err != nil {
+			res.Err = b.newBuildError(StagePrepare, fmt.Errorf("unable to remove %s: %w", appInstallDir, res.Err))
+			return res
+		}
 	}
 
 	log.Printf("* %s does not exists, installing from scratch\n", appInstallDir)
+	if b.CommandsFile != "" {
+		// Commands of a previous build must not be replayed
+		b.commands = nil
+		os.Remove(b.CommandsFile)
+		stopRecording := b.recordCommands()
+		defer stopRecording()
+	}
 
+	endStage := b.startStage(StageDownload)
 	res.Err = b.Env.Get(&b.App)
+	endStage(res.Err)
 	if res.Err != nil {
-		res.Err = fmt.Errorf("failed to download software from %s: %s", b.App.Source.URL, res.Err)
+		res.Err = b.newBuildError(StageDownload, fmt.Errorf("failed to download software from %s: %w", b.App.Source.URL, res.Err))
 		return res
 	}
 	if b.Env.SrcPath == "" {
-		res.Err = fmt.Errorf("failed to get a path to the source")
+		res.Err = b.newBuildError(StageDownload, fmt.Errorf("failed to get a path to the source"))
 		return res
 	}
 
+	endStage = b.startStage(StageUnpack)
 	res.Err = b.Env.Unpack(&b.App)
+	endStage(res.Err)
 	if res.Err != nil {
-		res.Err = fmt.Errorf("failed to unpack %s: %s", b.App.Name, res.Err)
+		res.Err = b.newBuildError(StageUnpack, res.Err)
 		return res
 	}
 
-	b.App.AutotoolsCfg.Source = b.Env.SrcDir
-	b.App.AutotoolsCfg.Detect()
-
-	// Right now, we assume we do not have to install autotools, which is a bad assumption
-	var extraArgs []string
-	if len(b.App.AutotoolsCfg.ExtraConfigureArgs) > 0 {
-		extraArgs = append(extraArgs, b.App.AutotoolsCfg.ExtraConfigureArgs...)
+	if b.BuildSystem == nil {
+		b.BuildSystem = b.getBuildSystem()
 	}
-	res.Err = b.Configure(&b.Env, b.App.Name, extraArgs, b.App.AutotoolsCfg.ConfigurePreludeCmd)
+	endStage = b.startStage(StageConfigure)
+	res.Err = b.BuildSystem.Configure(b)
+	endStage(res.Err)
 	if res.Err != nil {
-		res.Err = fmt.Errorf("failed to configure %s: %s", b.App.Name, res.Err)
+		res.Err = b.newBuildError(StageConfigure, res.Err)
 		return res
 	}
 
+	endStage = b.startStage(StageCompile)
 	res = b.compile(&b.App, &b.Env)
+	endStage(res.Err)
 	if res.Err != nil {
 		res.Stderr = fmt.Sprintf("failed to compile %s: %s", b.App.Name, res.Err)
+		res.Err = b.newBuildError(StageCompile, res.Err)
+		return res
+	}
+
+	appInstallDir = b.Env.GetAppInstallDir(&b.App)
+	endStage = b.startStage(StageInstall)
+	res = b.runHook("pre_install", b.PreInstallCmd, b.Env.SrcDir, appInstallDir)
+	if res.Err != nil {
+		endStage(res.Err)
+		res.Err = b.newBuildError(StageInstall, res.Err)
 		return res
 	}
 
 	res = b.install(&b.App, &b.Env)
 	if res.Err != nil {
 		res.Stderr = fmt.Sprintf("failed to install software: %s", res.Err)
+		endStage(res.Err)
+		res.Err = b.newBuildError(StageInstall, res.Err)
+		return res
+	}
+
+	res = b.runHook("post_install", b.PostInstallCmd, appInstallDir, appInstallDir)
+	endStage(res.Err)
+	if res.Err != nil {
+		res.Err = b.newBuildError(StageInstall, res.Err)
 		return res
 	}
 
+	b.built = true
+	return res
+}
+
+// startStage starts measuring a stage of the build, e.g., StageConfigure, and returns the function completing it
+// with the result of the stage: its duration and failure, if any, are recorded (see metrics.StageDurationSeconds),
+// its span ended and its completion reported (see OnStage)
+func (b *Builder) startStage(stage string) func(err error) {
+	start := time.Now()
+	b.stage = stage
+	_, span := metrics.StartSpan(b.TraceContext, "build "+stage)
+	span.SetAttribute("software", b.App.Name)
+	span.SetAttribute("stage", stage)
+	if b.OnStage != nil {
+		b.OnStage(stage, false, nil)
+	}
+	return func(err error) {
+		if b.OnStage != nil {
+			b.OnStage(stage, true, err)
+		}
+		b.stage = ""
+		if b.stageDurations != nil {
+			b.stageDurations[stage] += time.Since(start)
+		}
+		labels := metrics.Labels{"stage": stage}
+		metrics.Default.ObserveSince(metrics.StageDurationSeconds, labels, start)
+		if err != nil {
+			metrics.Default.Inc(metrics.StageFailuresTotal, labels)
+			span.RecordError(err)
+		}
+		span.End()
+	}
+}
+
+// runHook executes a user-defined command through a shell, using the build environment.
+// The command, its output and when it was executed are saved in a manifest.
+func (b *Builder) runHook(name string, hookCmd string, execDir string, manifestDir string) advexec.Result {
+	var res advexec.Result
+	if hookCmd == "" {
+		return res
+	}
+
+	if _, err := b.Env.GetFS().Stat(execDir); err != nil {
+		err := b.Env.GetFS().MkdirAll(execDir, 0755)
+		if err != nil {
+			res.Err = fmt.Errorf("unable to create %s: %w", execDir, err)
+			return res
+		}
+	}
+
+	log.Printf("- Running %s command for %s: %s", name, b.App.Name, hookCmd)
+	var cmd advexec.Advcmd
+	cmd.BinPath = "/bin/sh"
+	cmd.CmdArgs = []string{"-c", hookCmd}
+	cmd.ExecDir = execDir
+	cmd.Env = b.Env.GetPrefixEnv(manifestDir)
+	res = b.Env.GetRunner().RunAdvcmd(&cmd)
+
+	manifestData := []string{"Command: " + hookCmd}
+	manifestData = append(manifestData, "Execution path: "+execDir)
+	manifestData = append(manifestData, "Execution time: "+time.Now().Format("2006-01-02 15:04:05"))
+	manifestData = append(manifestData, "Stdout:\n"+res.Stdout)
+	manifestData = append(manifestData, "Stderr:\n"+res.Stderr)
+	if res.Err != nil {
+		manifestData = append(manifestData, "Error: "+res.Err.Error())
+	}
+	if util.PathExists(manifestDir) {
+		err := manifest.Create(filepath.Join(manifestDir, name+".MANIFEST"), manifestData)
+		if err != nil {
+			// This is not a fatal error, we just log it
+			log.Printf("failed to create manifest: %s", err)
+		}
+	}
+
+	if res.Err != nil {
+		res.Err = fmt.Errorf("%s command failed: %w - stdout: %s - stderr: %s", name, res.Err, res.Stdout, res.Stderr)
+	}
 	return res
 }
 
@@ -258,8 +431,8 @@ func (b *Builder) Install() advexec.Result {
 func (b *Builder) Uninstall() advexec.Result {
 	var res advexec.Result
 	if b.Persistent == "" {
-		if util.PathExists(b.Env.InstallDir) {
-			err := os.RemoveAll(b.Env.InstallDir)
+		if _, err := b.Env.GetFS().Stat(b.Env.InstallDir); err == nil {
+			err := b.Env.GetFS().RemoveAll(b.Env.InstallDir)
 			if err != nil {
 				res.Err = err
 				return res
@@ -272,6 +445,61 @@ func (b *Builder) Uninstall() advexec.Result {
 	return res
 }
 
+// removeDir removes a directory of the build environment, if it exists
+func (b *Builder) removeDir(dir string) error {
+	if dir == "" || filepath.Clean(dir) == "/" {
+		return fmt.Errorf("refusing to remove invalid directory %q", dir)
+	}
+	if _, err := b.Env.GetFS().Stat(dir); err != nil {
+		return nil
+	}
+	log.Printf("- Removing %s", dir)
+	err := b.Env.GetFS().RemoveAll(dir)
+	if err != nil {
+		return fmt.Errorf("unable to remove %s: %w", dir, err)
+	}
+	return nil
+}
+
+// Clean removes the build and scratch artifacts of the software, keeping its installation
+func (b *Builder) Clean() error {
+	if b.App.Name == "" && b.App.Source.URL == "" {
+		return fmt.Errorf("application's name is undefined")
+	}
+
+	var dirs []string
+	if b.Env.BuildDir != "" {
+		dirs = append(dirs, b.Env.GetAppBuildDir(&b.App))
+	}
+	if b.Env.ScratchDir != "" && b.App.Name != "" {
+		dirs = append(dirs, filepath.Join(b.Env.ScratchDir, b.App.Name))
+	}
+	for _, dir := range dirs {
+		err := b.removeDir(dir)
+		if err != nil {
+			return err
+		}
+	}
+	b.built = false
+	return nil
+}
+
+// CleanAll wipes the whole build environment, i.e., the scratch, build and install directories,
+// including all the software installed in it
+func (b *Builder) CleanAll() error {
+	for _, dir := range []string{b.Env.ScratchDir, b.Env.BuildDir, b.Env.InstallDir} {
+		if dir == "" {
+			continue
+		}
+		err := b.removeDir(dir)
+		if err != nil {
+			return err
+		}
+	}
+	b.built = false
+	return nil
+}
+
 // Load is the function that will figure out the function to call for various stages of the code configuration/compilation/installation/execution
 func (b *Builder) Load(persistent bool) error {
 	// fixme: at this point, we know the app and we have the builder object
@@ -313,13 +541,13 @@ func (b *Builder) Compile() error {
 	if !util.PathExists(buildEnv.BuildDir) {
 		err := util.DirInit(buildEnv.BuildDir)
 		if err != nil {
-			return fmt.Errorf("failed to initialize directory %s: %s", buildEnv.BuildDir, err)
+			return b.newBuildError(StagePrepare, fmt.Errorf("failed to initialize directory %s: %w", buildEnv.BuildDir, err))
 		}
 	}
 	if !util.PathExists(buildEnv.InstallDir) {
 		err := util.DirInit(buildEnv.InstallDir)
 		if err != nil {
-			return fmt.Errorf("failed to initialize directory %s: %s", buildEnv.InstallDir, err)
+			return b.newBuildError(StagePrepare, fmt.Errorf("failed to initialize directory %s: %w", buildEnv.InstallDir, err))
 		}
 	}
 
@@ -329,20 +557,20 @@ func (b *Builder) Compile() error {
 	// Download the app
 	err := buildEnv.Get(&b.App)
 	if err != nil {
-		return fmt.Errorf("unable to get the application from %s: %s", b.App.Source.URL, err)
+		return b.newBuildError(StageDownload, fmt.Errorf("unable to get the application from %s: %w", b.App.Source.URL, err))
 	}
 
 	// Unpacking the app
 	err = buildEnv.Unpack(&b.App)
 	if err != nil {
-		return fmt.Errorf("unable to unpack the application %s: %s", buildEnv.SrcPath, err)
+		return b.newBuildError(StageUnpack, fmt.Errorf("unable to unpack the application %s: %w", buildEnv.SrcPath, err))
 	}
 
 	// Install the app
 	log.Println("-> Building the application...")
 	err = buildEnv.Install(&b.App)
 	if err != nil {
-		return fmt.Errorf("unable to install package: %s", err)
+		return b.newBuildError(StageInstall, err)
 	}
 
 	// todo: we do not have a good way to know if an app is actually install in InstallDir or
@@ -6,13 +6,19 @@
 package builder
 
 import (
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_software_build/internal/pkg/testutil"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
 	"github.com/gvallee/go_util/pkg/util"
 )
 
@@ -181,7 +187,7 @@ func TestPersistentBuildFromLocalTarball(t *testing.T) {
 		t.Fatalf("unable to load builder: %s", err)
 	}
 
-	res = b.Install()
+	res = b.Install().Result
 	if res.Err != nil {
 		t.Fatalf("unable to install test tarball: %s", res.Err)
 	}
@@ -207,3 +213,665 @@ func TestPersistentBuildFromLocalTarball(t *testing.T) {
 		t.Fatalf("expected tarball is missing: %s instead of %s", b.Env.SrcPath, expectedTarball)
 	}
 }
+
+func TestInstallHooks(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+
+	b.App.Name = "hooks"
+	b.App.Source.URL = "file://" + srcDir
+	b.PreInstallCmd = "touch pre_install_done"
+	b.PostInstallCmd = "touch post_install_done"
+	err := b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+
+	appInstallDir := filepath.Join(b.Env.InstallDir, b.App.Name)
+	expectedFiles := []string{
+		filepath.Join(appInstallDir, "bin", "helloworld"),
+		filepath.Join(b.Env.SrcDir, "pre_install_done"),
+		filepath.Join(appInstallDir, "post_install_done"),
+		filepath.Join(appInstallDir, "pre_install.MANIFEST"),
+		filepath.Join(appInstallDir, "post_install.MANIFEST"),
+	}
+	for _, f := range expectedFiles {
+		if !util.FileExists(f) {
+			t.Fatalf("expected file %s does not exist", f)
+		}
+	}
+
+	b2, cleanupFn2 := setBuilder(t)
+	defer cleanupFn2()
+	b2.App.Name = "failing_hook"
+	b2.App.Source.URL = "file://" + srcDir
+	b2.PostInstallCmd = "false"
+	err = b2.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res = b2.Install()
+	if res.Err == nil {
+		t.Fatalf("install succeeded while the post-install command failed")
+	}
+}
+
+func TestManifests(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+
+	b.App.Name = "manifests"
+	b.App.Source.URL = "file://" + srcDir
+	b.PreInstallCmd = "echo pre-install output"
+	b.PostInstallCmd = "echo post-install error >&2"
+	err := b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+
+	manifests, err := b.GetManifests()
+	if err != nil {
+		t.Fatalf("GetManifests() failed: %s", err)
+	}
+	var names []string
+	for _, m := range manifests {
+		names = append(names, m.Name)
+	}
+	if strings.Join(names, ",") != "configure,pre_install,post_install" {
+		t.Fatalf("invalid manifests: %v", names)
+	}
+
+	configure := manifests[0]
+	if len(configure.Args) < 3 || filepath.Base(configure.Args[0]) != "configure" || configure.Args[1] != "--prefix" || configure.Args[2] != b.Env.GetAppInstallDir(&b.App) {
+		t.Fatalf("invalid configure command: %q", configure.Args)
+	}
+	if configure.Dir != b.Env.SrcDir || configure.Time.IsZero() || time.Since(configure.Time) > time.Hour {
+		t.Fatalf("invalid configure manifest: %+v", configure)
+	}
+	if len(configure.FileHashes) == 0 {
+		t.Fatalf("the hash of configure was not recorded: %+v", configure)
+	}
+	if manifests[1].Command != "echo pre-install output" || manifests[1].Stdout != "pre-install output\n" {
+		t.Fatalf("invalid pre_install manifest: %+v", manifests[1])
+	}
+	if manifests[2].Stdout != "" || manifests[2].Stderr != "post-install error\n" || manifests[2].Error != "" {
+		t.Fatalf("invalid post_install manifest: %+v", manifests[2])
+	}
+}
+
+func TestInstallResult(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+
+	b.App.Name = "result"
+	b.App.Source.URL = "file://" + srcDir
+	b.CommandsFile = filepath.Join(b.Env.ScratchDir, "commands.json")
+	err := b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+	if !res.Built || res.InstallDir != b.Env.GetAppInstallDir(&b.App) || res.SrcDir != b.Env.SrcDir {
+		t.Fatalf("invalid result: %+v", res)
+	}
+	if res.BuildSystem != "autotools" || res.BuildDir != res.SrcDir {
+		t.Fatalf("invalid build system %s or build directory %s", res.BuildSystem, res.BuildDir)
+	}
+	for _, stage := range []string{StageDownload, StageUnpack, StageConfigure, StageCompile, StageInstall} {
+		if _, ok := res.StageDurations[stage]; !ok {
+			t.Fatalf("duration of the %s stage not recorded: %v", stage, res.StageDurations)
+		}
+	}
+	if len(res.LogFiles) != 2 || filepath.Base(res.LogFiles[0]) != "configure"+ManifestSuffix || res.LogFiles[1] != b.CommandsFile {
+		t.Fatalf("invalid log files: %v", res.LogFiles)
+	}
+
+	// The software being installed, nothing is built the second time
+	res = b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package again: %s", res.Err)
+	}
+	if res.Built || res.BuildSystem != "" || res.BuildDir != "" || len(res.StageDurations) != 0 {
+		t.Fatalf("invalid result for a software already installed: %+v", res)
+	}
+	if res.InstallDir != b.Env.GetAppInstallDir(&b.App) {
+		t.Fatalf("invalid installation directory: %s", res.InstallDir)
+	}
+}
+
+func TestCustomInstallCmd(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	installScript := "#!/bin/sh\nmkdir -p \"$1/bin\" && echo \"$2\" > \"$1/bin/tool\"\n"
+	err = ioutil.WriteFile(filepath.Join(srcDir, "install.sh"), []byte(installScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create install script: %s", err)
+	}
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+
+	b.App.Name = "custom"
+	b.App.Source.URL = "file://" + srcDir
+	appInstallDir := filepath.Join(b.Env.InstallDir, b.App.Name)
+	// The command is run by a shell, PREFIX being the install directory of the package
+	b.App.InstallCmd = `./install.sh "$PREFIX" 'quoted argument'`
+	err = b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+
+	expectedFile := filepath.Join(appInstallDir, "bin", "tool")
+	content, err := ioutil.ReadFile(expectedFile)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", expectedFile, err)
+	}
+	if string(content) != "quoted argument\n" {
+		t.Fatalf("arguments of the install command were not preserved: %q", content)
+	}
+}
+
+func TestSubDir(t *testing.T) {
+	rootDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(rootDir)
+	err = os.MkdirAll(filepath.Join(rootDir, "contrib"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	err = os.Rename(testutil.CreateLocalSoftware(t), filepath.Join(rootDir, "contrib", "tool"))
+	if err != nil {
+		t.Fatalf("unable to move the software: %s", err)
+	}
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+
+	b.App.Name = "tool"
+	b.App.Source.URL = "file://" + rootDir
+	b.App.SubDir = "contrib/tool"
+	err = b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+	expectedFile := filepath.Join(b.Env.InstallDir, b.App.Name, "bin", "helloworld")
+	if !util.FileExists(expectedFile) {
+		t.Fatalf("expected file %s does not exist", expectedFile)
+	}
+
+	b2, cleanupFn2 := setBuilder(t)
+	defer cleanupFn2()
+	b2.App.Name = "tool"
+	b2.App.Source.URL = "file://" + rootDir
+	b2.App.SubDir = "../tool"
+	err = b2.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res = b2.Install()
+	if res.Err == nil {
+		t.Fatalf("installation succeeded with an invalid subdirectory")
+	}
+}
+
+func TestMakeTargets(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not available, skipping test")
+	}
+
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+	b.App.Name = "targets"
+	b.App.Source.URL = "file://" + srcDir
+	b.Env.BuildTargets = []string{"all", "docs"}
+	b.Env.InstallTargets = []string{"install-strip"}
+	appInstallDir := filepath.Join(b.Env.InstallDir, b.App.Name)
+
+	makefile := "all:\n\ttouch all_done\n\ndocs:\n\ttouch docs_done\n\ninstall:\n\tfalse\n\ninstall-strip:\n\tmkdir -p " + appInstallDir + " && touch " + appInstallDir + "/stripped\n"
+	err = ioutil.WriteFile(filepath.Join(srcDir, "Makefile"), []byte(makefile), 0644)
+	if err != nil {
+		t.Fatalf("unable to create Makefile: %s", err)
+	}
+
+	err = b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+
+	expectedFiles := []string{
+		filepath.Join(b.Env.SrcDir, "all_done"),
+		filepath.Join(b.Env.SrcDir, "docs_done"),
+		filepath.Join(appInstallDir, "stripped"),
+	}
+	for _, f := range expectedFiles {
+		if !util.FileExists(f) {
+			t.Fatalf("expected file %s does not exist", f)
+		}
+	}
+}
+
+func TestParseTestCounts(t *testing.T) {
+	tests := []struct {
+		output   string
+		passed   int
+		failed   int
+		skipped  int
+		total    int
+		detected bool
+	}{
+		{"# TOTAL: 4\n# PASS:  2\n# SKIP:  1\n# XFAIL: 0\n# FAIL:  1\n# XPASS: 0\n# ERROR: 0\n", 2, 1, 1, 4, true},
+		{"# TOTAL: 1\n# PASS:  1\n# TOTAL: 2\n# PASS:  1\n# XFAIL: 1\n", 3, 0, 0, 3, true},
+		{"    Start 1: a\n1/3 Test #3: c ...***Skipped\n  3 - c (Skipped)\n67% tests passed, 1 tests failed out of 3\n", 1, 1, 1, 3, true},
+		{"Ok:                 5\nExpected Fail:      1\nFail:               0\nUnexpected Pass:    0\nSkipped:            2\nTimeout:            1\n", 6, 1, 2, 9, true},
+		{"all good\n", 0, 0, 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		var res TestResult
+		res.parseCounts(tt.output)
+		if res.CountsAvailable != tt.detected || res.Passed != tt.passed || res.Failed != tt.failed || res.Skipped != tt.skipped || res.Total != tt.total {
+			t.Fatalf("unexpected counts for %q: %+v", tt.output, res)
+		}
+	}
+}
+
+func TestBuilderTest(t *testing.T) {
+	for _, failures := range []int{0, 1} {
+		srcDir := testutil.CreateLocalSoftware(t)
+		defer os.RemoveAll(srcDir)
+		// Add a check target to the Makefile generated by the configure script
+		checkTarget := fmt.Sprintf("\ncheck:\n\t@echo '# TOTAL: 3'\n\t@echo '# PASS:  %d'\n\t@echo '# FAIL:  %d'\n", 3-failures, failures)
+		err := ioutil.WriteFile(filepath.Join(srcDir, "check.mk"), []byte(checkTarget), 0644)
+		if err != nil {
+			t.Fatalf("unable to create check target: %s", err)
+		}
+		f, err := os.OpenFile(filepath.Join(srcDir, "configure"), os.O_APPEND|os.O_WRONLY, 0755)
+		if err != nil {
+			t.Fatalf("unable to open configure script: %s", err)
+		}
+		_, err = f.WriteString("cat check.mk >> Makefile\n")
+		f.Close()
+		if err != nil {
+			t.Fatalf("unable to update configure script: %s", err)
+		}
+
+		b, cleanupFn := setBuilder(t)
+		defer cleanupFn()
+		b.App.Name = "tested"
+		b.App.Source.URL = "file://" + srcDir
+
+		res := b.Test()
+		if res.Err == nil {
+			t.Fatalf("testing software that is not built succeeded")
+		}
+
+		err = b.Load(false)
+		if err != nil {
+			t.Fatalf("unable to load the builder: %s", err)
+		}
+		installRes := b.Install()
+		if installRes.Err != nil {
+			t.Fatalf("unable to install the software package: %s", installRes.Err)
+		}
+
+		res = b.Test()
+		if res.BuildSystem != "autotools" || !res.CountsAvailable || res.Total != 3 || res.Failed != failures {
+			t.Fatalf("unexpected test result: %+v", res)
+		}
+		if res.Success() != (failures == 0) {
+			t.Fatalf("unexpected test success: %+v", res)
+		}
+	}
+}
+
+func TestClean(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	installScript := "#!/bin/sh\nmkdir -p \"$1/bin\" && echo \"$2\" > \"$1/bin/tool\"\n"
+	err = ioutil.WriteFile(filepath.Join(srcDir, "install.sh"), []byte(installScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create install script: %s", err)
+	}
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+	b.App.Name = "clean"
+	b.App.Source.URL = "file://" + srcDir
+	appInstallDir := filepath.Join(b.Env.InstallDir, b.App.Name)
+	// The command is run by a shell, PREFIX being the install directory of the package
+	b.App.InstallCmd = `./install.sh "$PREFIX" 'quoted argument'`
+	err = b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+	appScratchDir := filepath.Join(b.Env.ScratchDir, b.App.Name)
+	err = os.MkdirAll(appScratchDir, 0755)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", appScratchDir, err)
+	}
+
+	err = b.Clean()
+	if err != nil {
+		t.Fatalf("unable to clean: %s", err)
+	}
+	if util.PathExists(filepath.Join(b.Env.BuildDir, b.App.Name)) || util.PathExists(appScratchDir) {
+		t.Fatalf("build artifacts of %s were not removed", b.App.Name)
+	}
+	if !util.FileExists(filepath.Join(appInstallDir, "bin", "tool")) {
+		t.Fatalf("installation of %s was removed", b.App.Name)
+	}
+
+	err = b.CleanAll()
+	if err != nil {
+		t.Fatalf("unable to clean the build environment: %s", err)
+	}
+	for _, dir := range []string{b.Env.ScratchDir, b.Env.BuildDir, b.Env.InstallDir} {
+		if util.PathExists(dir) {
+			t.Fatalf("%s was not removed", dir)
+		}
+	}
+}
+
+func TestBuildErrors(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	err = ioutil.WriteFile(filepath.Join(srcDir, "install.sh"), []byte("#!/bin/sh\nexit 1\n"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create install script: %s", err)
+	}
+
+	tests := []struct {
+		url      string
+		expected error
+		stage    string
+	}{
+		{"file://" + filepath.Join(srcDir, "does_not_exist.tar.gz"), ErrDownload, StageDownload},
+		{"file://" + srcDir, ErrInstall, StageInstall},
+	}
+	for _, tt := range tests {
+		b, cleanupFn := setBuilder(t)
+		defer cleanupFn()
+		b.App.Name = "failing"
+		b.App.Source.URL = tt.url
+		b.App.InstallCmd = "./install.sh"
+		b.CommandsFile = filepath.Join(b.Env.ScratchDir, "commands.json")
+		err = b.Load(false)
+		if err != nil {
+			t.Fatalf("unable to load the builder: %s", err)
+		}
+
+		res := b.Install()
+		if !errors.Is(res.Err, tt.expected) {
+			t.Fatalf("%s is not a %s error", res.Err, tt.stage)
+		}
+		for _, stageErr := range []error{ErrDownload, ErrUnpack, ErrConfigure, ErrCompile, ErrInstall, ErrTest} {
+			if stageErr != tt.expected && errors.Is(res.Err, stageErr) {
+				t.Fatalf("%s is wrongly classified as %s", res.Err, stageErr)
+			}
+		}
+		var buildErr *BuildError
+		if !errors.As(res.Err, &buildErr) || buildErr.Component != b.App.Name || buildErr.Stage != tt.stage {
+			t.Fatalf("unexpected build error: %s", res.Err)
+		}
+		// Commands are recorded from the configure stage on
+		expectedLogPath := ""
+		if tt.stage != StageDownload {
+			expectedLogPath = b.CommandsFile
+		}
+		if buildErr.LogPath != expectedLogPath {
+			t.Fatalf("log of the %s failure is %q instead of %q", tt.stage, buildErr.LogPath, expectedLogPath)
+		}
+	}
+}
+
+// failingMakeRunner executes commands on the host, except make which always fails
+type failingMakeRunner struct {
+	buildenv.HostRunner
+}
+
+func (r failingMakeRunner) RunAdvcmd(cmd *advexec.Advcmd) advexec.Result {
+	if cmd.BinPath == "make" {
+		return advexec.Result{Err: fmt.Errorf("exit status 2"), Stderr: "mock compilation failure"}
+	}
+	return cmd.Run()
+}
+
+func TestMockedBuildFailure(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	err = ioutil.WriteFile(filepath.Join(srcDir, "Makefile"), []byte("all:\n\ttrue\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create Makefile: %s", err)
+	}
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+	b.App.Name = "mocked"
+	b.App.Source.URL = "file://" + srcDir
+	b.Env.Runner = failingMakeRunner{}
+	err = b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res := b.Install()
+	if !errors.Is(res.Err, ErrCompile) || !strings.Contains(res.Err.Error(), "mock compilation failure") {
+		t.Fatalf("the failure of make was not reported as a compile error: %v", res.Err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	gccBin, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("gcc not available, skipping test")
+	}
+	if _, err := exec.LookPath("ldd"); err != nil {
+		t.Skip("ldd not available, skipping test")
+	}
+	if _, err := exec.LookPath("pkg-config"); err != nil {
+		t.Skip("pkg-config not available, skipping test")
+	}
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+	b.App.Name = "verified"
+	appInstallDir := filepath.Join(b.Env.InstallDir, b.App.Name)
+
+	// Install a library, a binary linked against it and a pkg-config file
+	for _, dir := range []string{"bin", "lib/pkgconfig", "src"} {
+		err = os.MkdirAll(filepath.Join(appInstallDir, dir), 0755)
+		if err != nil {
+			t.Fatalf("unable to create directory: %s", err)
+		}
+	}
+	srcDir := filepath.Join(appInstallDir, "src")
+	err = ioutil.WriteFile(filepath.Join(srcDir, "lib.c"), []byte("int verified(void) { return 0; }\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create file: %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(srcDir, "main.c"), []byte("int verified(void);\nint main(void) { return verified(); }\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create file: %s", err)
+	}
+	libPath := filepath.Join(appInstallDir, "lib", "libverified.so")
+	cmds := [][]string{
+		{"-shared", "-fPIC", "-o", libPath, filepath.Join(srcDir, "lib.c")},
+		{"-o", filepath.Join(appInstallDir, "bin", "verified"), filepath.Join(srcDir, "main.c"), "-L" + filepath.Dir(libPath), "-lverified"},
+	}
+	for _, args := range cmds {
+		out, err := exec.Command(gccBin, args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("unable to compile: %s - %s", err, string(out))
+		}
+	}
+	pcFile := "prefix=" + appInstallDir + "\nName: verified\nDescription: test\nVersion: 1.0\nLibs: -L${prefix}/lib -lverified\n"
+	err = ioutil.WriteFile(filepath.Join(appInstallDir, "lib", "pkgconfig", "verified.pc"), []byte(pcFile), 0644)
+	if err != nil {
+		t.Fatalf("unable to create file: %s", err)
+	}
+
+	v := &Verification{
+		Files:           []string{"bin/verified", "lib/libverified.so*"},
+		CheckSharedLibs: true,
+		PkgConfig:       []string{"verified"},
+	}
+	err = b.Verify(v)
+	if err != nil {
+		t.Fatalf("verification of a valid installation failed: %s", err)
+	}
+
+	invalidVerifications := []*Verification{
+		{Files: []string{"bin/does_not_exist"}},
+		{PkgConfig: []string{"does_not_exist"}},
+	}
+	for _, invalid := range invalidVerifications {
+		err = b.Verify(invalid)
+		if !errors.Is(err, ErrVerify) {
+			t.Fatalf("verification of an invalid installation did not fail as expected: %v", err)
+		}
+	}
+
+	err = os.Remove(libPath)
+	if err != nil {
+		t.Fatalf("unable to remove %s: %s", libPath, err)
+	}
+	v.Files = []string{"bin/verified"}
+	v.PkgConfig = nil
+	err = b.Verify(v)
+	if !errors.Is(err, ErrVerify) || !strings.Contains(err.Error(), "libverified.so") {
+		t.Fatalf("missing shared library not detected: %v", err)
+	}
+}
+
+func TestStagedInstall(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not available, skipping test")
+	}
+
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	configureScript := `#!/bin/sh
+prefix=/usr/local
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--prefix) shift; prefix="$1" ;;
+		--prefix=*) prefix="${1#--prefix=}" ;;
+	esac
+	shift
+done
+printf 'PREFIX=%s\n\nall:\n\tprintf "#!/bin/sh\\necho hello\\n" > helloworld\n\tchmod +x helloworld\n\ninstall:\n\tmkdir -p $(DESTDIR)$(PREFIX)/bin\n\tcp helloworld $(DESTDIR)$(PREFIX)/bin/\n' "$prefix" > Makefile
+`
+	err = ioutil.WriteFile(filepath.Join(srcDir, "configure"), []byte(configureScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create configure script: %s", err)
+	}
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+	b.App.Name = "staged"
+	b.App.Source.URL = "file://" + srcDir
+	b.StagedInstall = true
+	err = b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+
+	appInstallDir := filepath.Join(b.Env.InstallDir, b.App.Name)
+	for _, f := range []string{filepath.Join(appInstallDir, "bin", "helloworld"), filepath.Join(appInstallDir, InstalledFilesFilename)} {
+		if !util.FileExists(f) {
+			t.Fatalf("expected file %s does not exist", f)
+		}
+	}
+	if util.PathExists(b.getStagingDir()) {
+		t.Fatalf("staging directory %s was not removed", b.getStagingDir())
+	}
+	files, err := b.GetInstalledFiles()
+	if err != nil {
+		t.Fatalf("unable to get the installed files: %s", err)
+	}
+	if len(files) != 1 || files[0] != "bin/helloworld" || len(b.InstalledFiles) != 1 {
+		t.Fatalf("unexpected installed files: %v", files)
+	}
+
+	// Software that ignores DESTDIR installs directly in its installation directory, which is detected
+	legacySrcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(legacySrcDir)
+	b2, cleanupFn2 := setBuilder(t)
+	defer cleanupFn2()
+	b2.App.Name = "legacy"
+	b2.App.Source.URL = "file://" + legacySrcDir
+	b2.StagedInstall = true
+	err = b2.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+	res = b2.Install()
+	if res.Err == nil || !strings.Contains(res.Err.Error(), "may not support DESTDIR") {
+		t.Fatalf("staged installation of software without DESTDIR support did not fail as expected: %v", res.Err)
+	}
+}
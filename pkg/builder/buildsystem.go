@@ -0,0 +1,375 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// outOfTreeBuildDir is the name of the directory, in the source directory, used by build systems
+	// relying on out-of-tree builds, e.g., CMake and Meson
+	outOfTreeBuildDir = "_build"
+)
+
+// BuildSystem is the interface to implement to support a build system, e.g., CMake.
+// All the methods operate on the builder, whose environment (b.Env) points at the source
+// code of the application (b.App) once it is unpacked.
+type BuildSystem interface {
+	// Name returns the name of the build system, e.g., "cmake"
+	Name() string
+
+	// Detect returns whether the source code can be built with the build system
+	Detect(b *Builder) bool
+
+	// Configure configures the software
+	Configure(b *Builder) error
+
+	// Build compiles the software
+	Build(b *Builder) advexec.Result
+
+	// Install installs the software in its installation directory
+	Install(b *Builder) advexec.Result
+
+	// Test runs the test suite of the software
+	Test(b *Builder) advexec.Result
+}
+
+var (
+	buildSystemsLock sync.RWMutex
+
+	// buildSystems is the list of build systems registered with RegisterBuildSystem()
+	buildSystems []BuildSystem
+
+	// builtinBuildSystems is the list of build systems provided by the package, in the order they are
+	// detected. Autotools comes first since it was historically the only supported build system.
+	builtinBuildSystems = []BuildSystem{&autotoolsBuildSystem{}, &cmakeBuildSystem{}, &mesonBuildSystem{}, &makeBuildSystem{}}
+)
+
+// RegisterBuildSystem makes a build system available to all builders. Registered build systems are
+// detected in the reverse order of registration, before the built-in build systems, so they can override them.
+func RegisterBuildSystem(bs BuildSystem) {
+	buildSystemsLock.Lock()
+	defer buildSystemsLock.Unlock()
+	buildSystems = append([]BuildSystem{bs}, buildSystems...)
+}
+
+// getBuildSystems returns all the available build systems, in the order they must be detected
+func getBuildSystems() []BuildSystem {
+	buildSystemsLock.RLock()
+	defer buildSystemsLock.RUnlock()
+	var list []BuildSystem
+	list = append(list, buildSystems...)
+	return append(list, builtinBuildSystems...)
+}
+
+// LookupBuildSystem returns the build system with a given name, nil if it does not exist
+func LookupBuildSystem(name string) BuildSystem {
+	for _, bs := range getBuildSystems() {
+		if bs.Name() == name {
+			return bs
+		}
+	}
+	return nil
+}
+
+// getBuildSystem returns the build system to use, either the one explicitly set or the first one detected.
+// Autotools is used when no build system is detected, for backward compatibility.
+func (b *Builder) getBuildSystem() BuildSystem {
+	if b.BuildSystem != nil {
+		return b.BuildSystem
+	}
+	for _, bs := range getBuildSystems() {
+		if bs.Detect(b) {
+			log.Printf("-> Detected build system for %s: %s", b.App.Name, bs.Name())
+			return bs
+		}
+	}
+	log.Printf("-> Unable to detect the build system of %s, assuming autotools", b.App.Name)
+	return &autotoolsBuildSystem{}
+}
+
+// runConfigurePrelude executes the configure prelude of the application, if any
+func runConfigurePrelude(b *Builder) error {
+	preludeCmd := b.App.AutotoolsCfg.ConfigurePreludeCmd
+	if preludeCmd == "" {
+		return nil
+	}
+	tokens := strings.Split(preludeCmd, " ")
+	cmdBin, err := b.Env.GetRunner().LookPath(tokens[0])
+	if err != nil {
+		return fmt.Errorf("unable to run prelude, cannot find %s", tokens[0])
+	}
+	return b.Env.RunCmd(false, b.Env.SrcDir, b.Env.GetAppInstallDir(&b.App), "configure_prelude", cmdBin, tokens[1:])
+}
+
+// autotoolsBuildSystem supports software with a configure or autogen script
+type autotoolsBuildSystem struct{}
+
+func (bs *autotoolsBuildSystem) Name() string {
+	return "autotools"
+}
+
+func (bs *autotoolsBuildSystem) Detect(b *Builder) bool {
+	for _, script := range []string{"configure", "autogen.sh", "autogen.pl"} {
+		if util.FileExists(filepath.Join(b.Env.SrcDir, script)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (bs *autotoolsBuildSystem) Configure(b *Builder) error {
+	b.App.AutotoolsCfg.Source = b.Env.SrcDir
+	b.App.AutotoolsCfg.Detect()
+
+	// Right now, we assume we do not have to install autotools, which is a bad assumption
+	var extraArgs []string
+	if len(b.App.AutotoolsCfg.ExtraConfigureArgs) > 0 {
+		extraArgs = append(extraArgs, b.App.AutotoolsCfg.ExtraConfigureArgs...)
+	}
+	configureFn := b.Configure
+	if configureFn == nil {
+		configureFn = GenericConfigure
+	}
+	return configureFn(&b.Env, b.App.Name, extraArgs, b.App.AutotoolsCfg.ConfigurePreludeCmd)
+}
+
+func (bs *autotoolsBuildSystem) Build(b *Builder) advexec.Result {
+	var res advexec.Result
+	if b.Env.SrcDir == "" {
+		res.Err = fmt.Errorf("invalid parameter(s)")
+		return res
+	}
+
+	b.App.AutotoolsCfg.Detect()
+	makefilePath, makeExtraArgs, err := findMakefile(&b.Env)
+	if err != nil && b.App.InstallCmd != "" {
+		log.Printf("-> No Makefile, %s is expected to be compiled by its install command", b.App.Name)
+		return res
+	}
+	if err != nil {
+		log.Printf("-> No Makefile, trying to figure out how to compile/install %s...", b.App.Name)
+		res.Err = fmt.Errorf("failed to figure out how to compile %s", b.App.Name)
+		return res
+	}
+
+	makefileStage := ""
+	res.Err = b.Env.RunMake(false, makefileStage, makefilePath, makeExtraArgs)
+	return res
+}
+
+func (bs *autotoolsBuildSystem) Install(b *Builder) advexec.Result {
+	var res advexec.Result
+	env := &b.Env
+	pkg := &b.App
+
+	if pkg.AutotoolsCfg.HasMakeInstall || len(env.InstallTargets) > 0 {
+		// The Makefile has a 'install' target, or install targets are specified, so we just use it
+		targetDir := env.GetAppInstallDir(pkg)
+		if !env.IsInstalled(pkg) {
+			err := env.GetFS().MkdirAll(targetDir, 0755)
+			if err != nil {
+				res.Err = err
+				return res
+			}
+		}
+
+		log.Printf("- Installing %s in %s using 'make'...", pkg.Name, targetDir)
+		makefilePath, makeExtraArgs, err := findMakefile(env)
+		if err != nil {
+			res.Err = fmt.Errorf("unable to find Makefile: %s", err)
+			return res
+		}
+		res.Err = env.RunMake(b.SudoRequired, buildenv.MakeInstallStage, makefilePath, makeExtraArgs)
+		return res
+	}
+
+	// Copy binaries and libraries to the install directory
+	log.Printf("- 'make install' not available, copying files...")
+	var cmd advexec.Advcmd
+	cmd.BinPath = "cp"
+	installDir := filepath.Join(env.DestDir, env.InstallDir)
+	if env.DestDir != "" {
+		err := env.GetFS().MkdirAll(installDir, 0755)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+	}
+	cmd.CmdArgs = []string{"-rf", env.GetAppBuildDir(pkg), installDir}
+	if env.InstallVersion != "" {
+		targetDir := filepath.Join(env.DestDir, env.GetAppInstallDir(pkg))
+		err := env.GetFS().MkdirAll(targetDir, 0755)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		cmd.CmdArgs = []string{"-rf", env.GetAppBuildDir(pkg) + "/.", targetDir}
+	}
+	return env.GetRunner().RunAdvcmd(&cmd)
+}
+
+func (bs *autotoolsBuildSystem) Test(b *Builder) advexec.Result {
+	var res advexec.Result
+	makefilePath, makeExtraArgs, err := findMakefile(&b.Env)
+	if err != nil {
+		res.Err = fmt.Errorf("unable to find Makefile: %s", err)
+		return res
+	}
+
+	// Autotools packages traditionally provide a 'check' target, other Makefiles a 'test' target
+	for _, target := range []string{"check", "test"} {
+		if b.App.AutotoolsCfg.MakefileHasTarget(target, makefilePath) {
+			return b.Env.RunMakeWithResult(false, target, makefilePath, makeExtraArgs)
+		}
+	}
+	res.Err = fmt.Errorf("%s does not have a test target", makefilePath)
+	return res
+}
+
+// makeBuildSystem supports software only providing a Makefile
+type makeBuildSystem struct {
+	autotoolsBuildSystem
+}
+
+func (bs *makeBuildSystem) Name() string {
+	return "make"
+}
+
+func (bs *makeBuildSystem) Detect(b *Builder) bool {
+	_, _, err := findMakefile(&b.Env)
+	return err == nil
+}
+
+// cmakeBuildSystem supports software relying on CMake
+type cmakeBuildSystem struct{}
+
+func (bs *cmakeBuildSystem) Name() string {
+	return "cmake"
+}
+
+func (bs *cmakeBuildSystem) Detect(b *Builder) bool {
+	return util.FileExists(filepath.Join(b.Env.SrcDir, "CMakeLists.txt"))
+}
+
+func (bs *cmakeBuildSystem) Configure(b *Builder) error {
+	err := runConfigurePrelude(b)
+	if err != nil {
+		return err
+	}
+
+	cmakeBin, err := b.Env.GetRunner().LookPath("cmake")
+	if err != nil {
+		return fmt.Errorf("cmake is not available: %w", err)
+	}
+	appInstallDir := b.Env.GetAppInstallDir(&b.App)
+	args := []string{"-S", b.Env.SrcDir, "-B", filepath.Join(b.Env.SrcDir, outOfTreeBuildDir), "-DCMAKE_INSTALL_PREFIX=" + appInstallDir}
+	if buildType := b.Env.GetCMakeBuildType(); buildType != "" {
+		args = append(args, "-DCMAKE_BUILD_TYPE="+buildType)
+	}
+	args = append(args, b.App.AutotoolsCfg.ExtraConfigureArgs...)
+	return b.Env.RunCmd(false, b.Env.SrcDir, appInstallDir, "configure", cmakeBin, args)
+}
+
+func (bs *cmakeBuildSystem) Build(b *Builder) advexec.Result {
+	var res advexec.Result
+	cmakeBin, err := b.Env.GetRunner().LookPath("cmake")
+	if err != nil {
+		res.Err = fmt.Errorf("cmake is not available: %w", err)
+		return res
+	}
+	args := []string{"--build", filepath.Join(b.Env.SrcDir, outOfTreeBuildDir), "--parallel"}
+	if b.Env.Limits.MaxJobs > 0 {
+		args = append(args, strconv.Itoa(b.Env.Limits.MaxJobs))
+	}
+	res.Err = b.Env.RunCmd(false, b.Env.SrcDir, "", "", cmakeBin, args)
+	return res
+}
+
+func (bs *cmakeBuildSystem) Install(b *Builder) advexec.Result {
+	var res advexec.Result
+	cmakeBin, err := b.Env.GetRunner().LookPath("cmake")
+	if err != nil {
+		res.Err = fmt.Errorf("cmake is not available: %w", err)
+		return res
+	}
+	args := []string{"--build", filepath.Join(b.Env.SrcDir, outOfTreeBuildDir), "--target", "install"}
+	res.Err = b.Env.RunCmd(b.SudoRequired, b.Env.SrcDir, b.Env.GetAppInstallDir(&b.App), "install", cmakeBin, args)
+	return res
+}
+
+func (bs *cmakeBuildSystem) Test(b *Builder) advexec.Result {
+	var res advexec.Result
+	ctestBin, err := b.Env.GetRunner().LookPath("ctest")
+	if err != nil {
+		res.Err = fmt.Errorf("ctest is not available: %w", err)
+		return res
+	}
+	return b.Env.RunCmdWithResult(false, filepath.Join(b.Env.SrcDir, outOfTreeBuildDir), "", "", ctestBin, []string{"--output-on-failure"})
+}
+
+// mesonBuildSystem supports software relying on Meson
+type mesonBuildSystem struct{}
+
+func (bs *mesonBuildSystem) Name() string {
+	return "meson"
+}
+
+func (bs *mesonBuildSystem) Detect(b *Builder) bool {
+	return util.FileExists(filepath.Join(b.Env.SrcDir, "meson.build"))
+}
+
+// run executes a meson command on the build directory
+func (bs *mesonBuildSystem) run(b *Builder, sudo bool, manifestName string, args []string) advexec.Result {
+	mesonBin, err := b.Env.GetRunner().LookPath("meson")
+	if err != nil {
+		return advexec.Result{Err: fmt.Errorf("meson is not available: %w", err)}
+	}
+	manifestDir := ""
+	if manifestName != "" {
+		manifestDir = b.Env.GetAppInstallDir(&b.App)
+	}
+	return b.Env.RunCmdWithResult(sudo, b.Env.SrcDir, manifestDir, manifestName, mesonBin, args)
+}
+
+func (bs *mesonBuildSystem) Configure(b *Builder) error {
+	err := runConfigurePrelude(b)
+	if err != nil {
+		return err
+	}
+	args := []string{"setup", outOfTreeBuildDir, "--prefix=" + b.Env.GetAppInstallDir(&b.App)}
+	if buildType := b.Env.GetMesonBuildType(); buildType != "" {
+		args = append(args, "--buildtype="+buildType)
+	}
+	args = append(args, b.App.AutotoolsCfg.ExtraConfigureArgs...)
+	return bs.run(b, false, "configure", args).Err
+}
+
+func (bs *mesonBuildSystem) Build(b *Builder) advexec.Result {
+	args := []string{"compile", "-C", outOfTreeBuildDir}
+	if b.Env.Limits.MaxJobs > 0 {
+		args = append(args, "-j", strconv.Itoa(b.Env.Limits.MaxJobs))
+	}
+	return bs.run(b, false, "", args)
+}
+
+func (bs *mesonBuildSystem) Install(b *Builder) advexec.Result {
+	return bs.run(b, b.SudoRequired, "install", []string{"install", "-C", outOfTreeBuildDir})
+}
+
+func (bs *mesonBuildSystem) Test(b *Builder) advexec.Result {
+	return bs.run(b, false, "", []string{"test", "-C", outOfTreeBuildDir})
+}
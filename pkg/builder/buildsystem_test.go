@@ -0,0 +1,143 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// scriptBuildSystem is a build system for software providing a custom_build.sh script
+type scriptBuildSystem struct {
+	steps []string
+}
+
+func (bs *scriptBuildSystem) Name() string {
+	return "script"
+}
+
+func (bs *scriptBuildSystem) Detect(b *Builder) bool {
+	return util.FileExists(filepath.Join(b.Env.SrcDir, "custom_build.sh"))
+}
+
+func (bs *scriptBuildSystem) Configure(b *Builder) error {
+	bs.steps = append(bs.steps, "configure")
+	return nil
+}
+
+func (bs *scriptBuildSystem) Build(b *Builder) advexec.Result {
+	bs.steps = append(bs.steps, "build")
+	return advexec.Result{}
+}
+
+func (bs *scriptBuildSystem) Install(b *Builder) advexec.Result {
+	bs.steps = append(bs.steps, "install")
+	var cmd advexec.Advcmd
+	cmd.BinPath = "./custom_build.sh"
+	cmd.CmdArgs = []string{b.Env.GetAppInstallDir(&b.App)}
+	cmd.ExecDir = b.Env.SrcDir
+	return cmd.Run()
+}
+
+func (bs *scriptBuildSystem) Test(b *Builder) advexec.Result {
+	bs.steps = append(bs.steps, "test")
+	return advexec.Result{}
+}
+
+func TestBuildSystemDetection(t *testing.T) {
+	tests := []struct {
+		files    []string
+		expected string
+	}{
+		{[]string{"configure", "Makefile"}, "autotools"},
+		{[]string{"autogen.sh"}, "autotools"},
+		{[]string{"CMakeLists.txt"}, "cmake"},
+		{[]string{"meson.build"}, "meson"},
+		{[]string{"Makefile"}, "make"},
+		{[]string{"README"}, "autotools"},
+	}
+
+	for _, tt := range tests {
+		b, cleanupFn := setBuilder(t)
+		for _, f := range tt.files {
+			err := ioutil.WriteFile(filepath.Join(b.Env.SrcDir, f), []byte{}, 0644)
+			if err != nil {
+				t.Fatalf("unable to create %s: %s", f, err)
+			}
+		}
+		bs := b.getBuildSystem()
+		cleanupFn()
+		if bs.Name() != tt.expected {
+			t.Fatalf("detected build system for %s is %s instead of %s", tt.files, bs.Name(), tt.expected)
+		}
+	}
+
+	if LookupBuildSystem("cmake") == nil {
+		t.Fatalf("unable to find the cmake build system")
+	}
+	if LookupBuildSystem("unknown") != nil {
+		t.Fatalf("found a build system that does not exist")
+	}
+}
+
+func TestCustomBuildSystem(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	buildScript := "#!/bin/sh\nmkdir -p $1/bin && touch $1/bin/tool\n"
+	err = ioutil.WriteFile(filepath.Join(srcDir, "custom_build.sh"), []byte(buildScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create build script: %s", err)
+	}
+	// The custom build system must take precedence over the built-in ones
+	err = ioutil.WriteFile(filepath.Join(srcDir, "Makefile"), []byte("all:\n\tfalse\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create Makefile: %s", err)
+	}
+
+	bs := new(scriptBuildSystem)
+	RegisterBuildSystem(bs)
+	if LookupBuildSystem("script") != bs {
+		t.Fatalf("unable to find the registered build system")
+	}
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+	b.App.Name = "scripted"
+	b.App.Source.URL = "file://" + srcDir
+	err = b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+	if b.BuildSystem != bs {
+		t.Fatalf("the registered build system was not selected")
+	}
+	expectedSteps := []string{"configure", "build", "install"}
+	if len(bs.steps) != len(expectedSteps) {
+		t.Fatalf("unexpected build steps: %s", bs.steps)
+	}
+	for i := range expectedSteps {
+		if bs.steps[i] != expectedSteps[i] {
+			t.Fatalf("unexpected build steps: %s", bs.steps)
+		}
+	}
+	expectedFile := filepath.Join(b.Env.InstallDir, b.App.Name, "bin", "tool")
+	if !util.FileExists(expectedFile) {
+		t.Fatalf("expected file %s does not exist", expectedFile)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// StagePrepare is the stage preparing the build of the software, e.g., setting up its build environment
+	StagePrepare = "prepare"
+
+	// StageDownload is the stage getting the source code of the software
+	StageDownload = "download"
+
+	// StageUnpack is the stage unpacking the source code of the software
+	StageUnpack = "unpack"
+
+	// StageConfigure is the stage configuring the software
+	StageConfigure = "configure"
+
+	// StageCompile is the stage compiling the software
+	StageCompile = "compile"
+
+	// StageInstall is the stage installing the software, including the pre/post-install commands
+	StageInstall = "install"
+
+	// StageTest is the stage running the tests of the software
+	StageTest = "test"
+
+	// StageVerify is the stage verifying the installation of the software
+	StageVerify = "verify"
+)
+
+var (
+	// ErrPrepare is the error matching, with errors.Is(), failures to prepare the build, e.g., an invalid environment
+	ErrPrepare = errors.New("preparation failed")
+
+	// ErrDownload is the error matching, with errors.Is(), failures to get the source code, e.g., network issues
+	ErrDownload = errors.New("download failed")
+
+	// ErrUnpack is the error matching, with errors.Is(), failures to unpack the source code
+	ErrUnpack = errors.New("unpack failed")
+
+	// ErrConfigure is the error matching, with errors.Is(), failures to configure the software
+	ErrConfigure = errors.New("configure failed")
+
+	// ErrCompile is the error matching, with errors.Is(), failures to compile the software
+	ErrCompile = errors.New("compile failed")
+
+	// ErrInstall is the error matching, with errors.Is(), failures to install the software
+	ErrInstall = errors.New("install failed")
+
+	// ErrTest is the error matching, with errors.Is(), failures of the tests of the software
+	ErrTest = errors.New("test failed")
+
+	// ErrVerify is the error matching, with errors.Is(), installations that are not valid, e.g., missing shared libraries
+	ErrVerify = errors.New("verification failed")
+
+	stageErrors = map[string]error{
+		StagePrepare:   ErrPrepare,
+		StageDownload:  ErrDownload,
+		StageUnpack:    ErrUnpack,
+		StageConfigure: ErrConfigure,
+		StageCompile:   ErrCompile,
+		StageInstall:   ErrInstall,
+		StageTest:      ErrTest,
+		StageVerify:    ErrVerify,
+	}
+)
+
+// BuildError is the error returned when a stage of the build of a software fails. The stage can be checked
+// with errors.Is() and the sentinel errors, e.g., errors.Is(err, ErrDownload), or errors.As(), which also gives
+// the software and the log of the failure, so that failures can be handled without parsing error messages.
+type BuildError struct {
+	// Component is the name of the software that failed to build
+	Component string
+
+	// Stage is the stage that failed, e.g., StageCompile
+	Stage string
+
+	// LogPath is the path to the file recording the commands executed to build the software, including the
+	// one that failed, if any (see Builder.CommandsFile)
+	LogPath string
+
+	// Err is the underlying error
+	Err error
+}
+
+func (e *BuildError) Error() string {
+	return fmt.Sprintf("%s of %s failed: %s", e.Stage, e.Component, e.Err)
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.Err
+}
+
+// Is returns whether the target is the sentinel error of the stage that failed
+func (e *BuildError) Is(target error) bool {
+	return target != nil && stageErrors[e.Stage] == target
+}
+
+// newBuildError returns an error for the failure of a stage of the build of the builder's software
+func (b *Builder) newBuildError(stage string, err error) error {
+	buildErr := &BuildError{Component: b.App.Name, Stage: stage, Err: err}
+	if b.CommandsFile != "" && util.FileExists(b.CommandsFile) {
+		buildErr.LogPath = b.CommandsFile
+	}
+	return buildErr
+}
@@ -0,0 +1,151 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	// ManifestSuffix is the suffix of the execution manifests recorded in the installation directory of the
+	// software for the commands executed to build it, e.g., configure.MANIFEST
+	ManifestSuffix = ".MANIFEST"
+
+	// manifestTimeLayout is the format of the execution times recorded in the manifests
+	manifestTimeLayout = "2006-01-02 15:04:05"
+)
+
+// manifestHashRegexp matches the lines of manifests recording the SHA256 of a file, e.g., of the binary executed
+var manifestHashRegexp = regexp.MustCompile(`^(/.*): ([0-9a-f]{64})?$`)
+
+// ExecManifest is the execution manifest of a command executed to build the software, e.g., configure or a hook
+type ExecManifest struct {
+	// Name is the name of the manifest, e.g., configure or pre_install
+	Name string
+
+	// Path is the path to the manifest
+	Path string
+
+	// Command is the command that was executed, as recorded
+	Command string
+
+	// Args is the binary executed and its arguments, as recorded, i.e., split on white spaces
+	Args []string
+
+	// Dir is the directory the command was executed from
+	Dir string
+
+	// Time is when the command was executed, in the local time zone
+	Time time.Time
+
+	// Input is the input of the command, for plugins
+	Input string
+
+	// Stdout and Stderr are the output of the command, for hooks and plugins
+	Stdout string
+	Stderr string
+
+	// Error is the error of the command, if it failed
+	Error string
+
+	// FileHashes is the SHA256 of the files recorded by the manifest, e.g., the binary executed, by absolute path
+	FileHashes map[string]string
+
+	// Data is the other data recorded by the manifest, one entry per line
+	Data []string
+}
+
+// LoadManifest parses an execution manifest, as recorded for the commands executed to build the software
+func LoadManifest(path string) (*ExecManifest, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+
+	m := &ExecManifest{
+		Name:       strings.TrimSuffix(filepath.Base(path), ManifestSuffix),
+		Path:       path,
+		FileHashes: make(map[string]string),
+	}
+	// Stdout and Stderr span several lines, until the next section
+	var section *[]string
+	var stdout, stderr []string
+	for _, line := range strings.Split(string(content), "\n") {
+		switch {
+		case section == nil && strings.HasPrefix(line, "Command: "):
+			m.Command = strings.TrimSpace(strings.TrimPrefix(line, "Command: "))
+			m.Args = strings.Fields(m.Command)
+		case section == nil && strings.HasPrefix(line, "Execution path: "):
+			m.Dir = strings.TrimPrefix(line, "Execution path: ")
+		case section == nil && strings.HasPrefix(line, "Execution time: "):
+			m.Time, err = time.ParseInLocation(manifestTimeLayout, strings.TrimPrefix(line, "Execution time: "), time.Local)
+			if err != nil {
+				return nil, fmt.Errorf("invalid execution time in %s: %w", path, err)
+			}
+		case section == nil && strings.HasPrefix(line, "Input: "):
+			m.Input = strings.TrimPrefix(line, "Input: ")
+		case section != &stderr && line == "Stdout:":
+			section = &stdout
+		case line == "Stderr:":
+			section = &stderr
+		case strings.HasPrefix(line, "Error: "):
+			section = nil
+			m.Error = strings.TrimPrefix(line, "Error: ")
+		case section != nil:
+			*section = append(*section, line)
+		case manifestHashRegexp.MatchString(line):
+			match := manifestHashRegexp.FindStringSubmatch(line)
+			m.FileHashes[match[1]] = match[2]
+		case line != "":
+			m.Data = append(m.Data, line)
+		}
+	}
+	m.Stdout = strings.Join(stdout, "\n")
+	m.Stderr = strings.Join(stderr, "\n")
+	return m, nil
+}
+
+// GetManifests returns the execution manifests recorded in a directory, e.g., the installation directory of a
+// software, in the order they were recorded
+func GetManifests(dir string) ([]*ExecManifest, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+ManifestSuffix))
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the manifests in %s: %w", dir, err)
+	}
+
+	var manifests []*ExecManifest
+	modTimes := make(map[string]time.Time)
+	for _, path := range paths {
+		fileInfo, err := os.Stat(path)
+		if err != nil || fileInfo.IsDir() {
+			continue
+		}
+		m, err := LoadManifest(path)
+		if err != nil {
+			return nil, err
+		}
+		modTimes[path] = fileInfo.ModTime()
+		manifests = append(manifests, m)
+	}
+	// Execution times are recorded with a precision of a second, the manifests being created right after
+	sort.SliceStable(manifests, func(i, j int) bool {
+		return modTimes[manifests[i].Path].Before(modTimes[manifests[j].Path])
+	})
+	return manifests, nil
+}
+
+// GetManifests returns the execution manifests of the commands executed to build and install the software, in
+// the order they were executed, e.g., for debugging or auditing
+func (b *Builder) GetManifests() ([]*ExecManifest, error) {
+	return GetManifests(b.Env.GetAppInstallDir(&b.App))
+}
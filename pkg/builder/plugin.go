@@ -0,0 +1,199 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_exec/pkg/manifest"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// PluginStageConfigure is the stage a plugin is invoked for to configure the software
+	PluginStageConfigure = "configure"
+
+	// PluginStageBuild is the stage a plugin is invoked for to build the software
+	PluginStageBuild = "build"
+
+	// PluginStageInstall is the stage a plugin is invoked for to install the software
+	PluginStageInstall = "install"
+
+	// PluginStageTest is the stage a plugin is invoked for to test the software
+	PluginStageTest = "test"
+)
+
+// PluginInput is the description of the build environment a plugin receives as JSON on its standard input
+type PluginInput struct {
+	// Stage is the stage the plugin is invoked for, e.g., PluginStageBuild. It is also the first argument of the plugin.
+	Stage string `json:"stage"`
+
+	// Name is the name of the software
+	Name string `json:"name"`
+
+	// Version is the version of the software, if known
+	Version string `json:"version"`
+
+	// URL is the URL the source code was retrieved from
+	URL string `json:"url"`
+
+	// SrcDir is the directory where the source code is, from which the plugin is executed
+	SrcDir string `json:"src_dir"`
+
+	// BuildDir is the build directory of the build environment
+	BuildDir string `json:"build_dir"`
+
+	// ScratchDir is the scratch directory of the build environment
+	ScratchDir string `json:"scratch_dir"`
+
+	// InstallDir is the directory where the software must be installed
+	InstallDir string `json:"install_dir"`
+
+	// Env is the environment of the build, also used to execute the plugin when not empty
+	Env []string `json:"env"`
+
+	// ConfigureArgs is the list of extra arguments to configure the software
+	ConfigureArgs []string `json:"configure_args"`
+
+	// MakeExtraArgs is the list of extra arguments for make, when the plugin relies on it
+	MakeExtraArgs []string `json:"make_extra_args"`
+
+	// MakeVars is the variables to set on the make command line, when the plugin relies on make
+	MakeVars map[string]string `json:"make_vars"`
+
+	// MaxJobs is the maximum number of parallel jobs the plugin should use, unlimited when 0
+	MaxJobs int `json:"max_jobs"`
+
+	// SudoRequired specifies whether the installation requires elevated privileges
+	SudoRequired bool `json:"sudo_required"`
+}
+
+// pluginBuildSystem is a build system delegating all the stages to an external executable. The executable
+// is invoked with the stage as its only argument and the build environment (see PluginInput) on stdin.
+type pluginBuildSystem struct {
+	path string
+}
+
+// NewPluginBuildSystem returns a build system relying on the plugin executable at a given path.
+// Such a build system is never automatically detected, it must be explicitly set, e.g., Builder.BuildSystem.
+func NewPluginBuildSystem(path string) (BuildSystem, error) {
+	pluginPath, err := exec.LookPath(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid plugin %s: %w", path, err)
+	}
+	pluginPath, err = filepath.Abs(pluginPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get absolute path of %s: %w", pluginPath, err)
+	}
+	return &pluginBuildSystem{path: pluginPath}, nil
+}
+
+func (bs *pluginBuildSystem) Name() string {
+	return "plugin:" + filepath.Base(bs.path)
+}
+
+func (bs *pluginBuildSystem) Detect(b *Builder) bool {
+	return false
+}
+
+// run invokes the plugin for a given stage. The command, its input and output are saved in a manifest.
+func (bs *pluginBuildSystem) run(b *Builder, stage string) advexec.Result {
+	var res advexec.Result
+
+	input := PluginInput{
+		Stage:         stage,
+		Name:          b.App.Name,
+		Version:       b.App.Version,
+		URL:           b.App.Source.URL,
+		SrcDir:        b.Env.SrcDir,
+		BuildDir:      b.Env.BuildDir,
+		ScratchDir:    b.Env.ScratchDir,
+		InstallDir:    b.Env.GetAppInstallDir(&b.App),
+		Env:           b.Env.Env,
+		ConfigureArgs: b.App.AutotoolsCfg.ExtraConfigureArgs,
+		MakeExtraArgs: b.Env.MakeExtraArgs,
+		MakeVars:      b.Env.MakeVars,
+		MaxJobs:       b.Env.Limits.MaxJobs,
+		SudoRequired:  b.SudoRequired,
+	}
+	data, err := json.Marshal(&input)
+	if err != nil {
+		res.Err = fmt.Errorf("unable to encode the plugin input: %w", err)
+		return res
+	}
+
+	log.Printf("- Running plugin %s for the %s stage of %s", bs.path, stage, b.App.Name)
+	var stdout, stderr bytes.Buffer
+	binPath, args, err := b.Env.LimitCmd(bs.path, []string{stage})
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	cmd := exec.Command(binPath, args...)
+	cmd.Dir = b.Env.SrcDir
+	if len(b.Env.Env) > 0 {
+		cmd.Env = b.Env.Env
+	}
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	res.Err = b.Env.GetRunner().Run(cmd)
+	res.Stdout = stdout.String()
+	res.Stderr = stderr.String()
+
+	manifestData := []string{"Command: " + bs.path + " " + stage}
+	manifestData = append(manifestData, "Input: "+string(data))
+	manifestData = append(manifestData, "Execution path: "+b.Env.SrcDir)
+	manifestData = append(manifestData, "Execution time: "+time.Now().Format("2006-01-02 15:04:05"))
+	manifestData = append(manifestData, "Stdout:\n"+res.Stdout)
+	manifestData = append(manifestData, "Stderr:\n"+res.Stderr)
+	if res.Err != nil {
+		manifestData = append(manifestData, "Error: "+res.Err.Error())
+	}
+	if util.PathExists(input.InstallDir) {
+		err := manifest.Create(filepath.Join(input.InstallDir, "plugin_"+stage+".MANIFEST"), manifestData)
+		if err != nil {
+			// This is not a fatal error, we just log it
+			log.Printf("failed to create manifest: %s", err)
+		}
+	}
+
+	if res.Err != nil {
+		res.Err = fmt.Errorf("plugin %s failed during the %s stage: %w - stdout: %s - stderr: %s", bs.path, stage, res.Err, res.Stdout, res.Stderr)
+	}
+	return res
+}
+
+func (bs *pluginBuildSystem) Configure(b *Builder) error {
+	return bs.run(b, PluginStageConfigure).Err
+}
+
+func (bs *pluginBuildSystem) Build(b *Builder) advexec.Result {
+	return bs.run(b, PluginStageBuild)
+}
+
+func (bs *pluginBuildSystem) Install(b *Builder) advexec.Result {
+	appInstallDir := b.Env.GetAppInstallDir(&b.App)
+	if !util.PathExists(appInstallDir) {
+		err := os.MkdirAll(appInstallDir, 0755)
+		if err != nil {
+			return advexec.Result{Err: err}
+		}
+	}
+	return bs.run(b, PluginStageInstall)
+}
+
+func (bs *pluginBuildSystem) Test(b *Builder) advexec.Result {
+	return bs.run(b, PluginStageTest)
+}
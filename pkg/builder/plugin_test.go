@@ -0,0 +1,103 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+func TestPluginBuildSystem(t *testing.T) {
+	pluginDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(pluginDir)
+	// The plugin saves its input and, when installing, creates the file listed in the source code
+	pluginScript := `#!/bin/sh
+cat > plugin_$1.json
+if [ "$1" = "install" ]; then
+	prefix=$(sed -n 's/.*"install_dir":"\([^"]*\)".*/\1/p' plugin_install.json)
+	mkdir -p $prefix/bin && cp $(cat FILES) $prefix/bin/
+fi
+`
+	pluginPath := filepath.Join(pluginDir, "plugin.sh")
+	err = ioutil.WriteFile(pluginPath, []byte(pluginScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create plugin: %s", err)
+	}
+
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	err = ioutil.WriteFile(filepath.Join(srcDir, "FILES"), []byte("tool\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create file: %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(srcDir, "tool"), []byte("#!/bin/sh\n"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create file: %s", err)
+	}
+
+	b, cleanupFn := setBuilder(t)
+	defer cleanupFn()
+	b.App.Name = "plugged"
+	b.App.Source.URL = "file://" + srcDir
+	b.App.AutotoolsCfg.ExtraConfigureArgs = []string{"--with-feature"}
+	b.BuildSystem, err = NewPluginBuildSystem(pluginPath)
+	if err != nil {
+		t.Fatalf("unable to load the plugin: %s", err)
+	}
+	err = b.Load(false)
+	if err != nil {
+		t.Fatalf("unable to load the builder: %s", err)
+	}
+
+	res := b.Install()
+	if res.Err != nil {
+		t.Fatalf("unable to install the software package: %s", res.Err)
+	}
+
+	appInstallDir := filepath.Join(b.Env.InstallDir, b.App.Name)
+	expectedFiles := []string{
+		filepath.Join(appInstallDir, "bin", "tool"),
+		filepath.Join(appInstallDir, "plugin_install.MANIFEST"),
+	}
+	for _, f := range expectedFiles {
+		if !util.FileExists(f) {
+			t.Fatalf("expected file %s does not exist", f)
+		}
+	}
+	for _, stage := range []string{PluginStageConfigure, PluginStageBuild, PluginStageInstall} {
+		data, err := ioutil.ReadFile(filepath.Join(b.Env.SrcDir, "plugin_"+stage+".json"))
+		if err != nil {
+			t.Fatalf("plugin not invoked for the %s stage: %s", stage, err)
+		}
+		var input PluginInput
+		err = json.Unmarshal(data, &input)
+		if err != nil {
+			t.Fatalf("invalid plugin input: %s", err)
+		}
+		if input.Stage != stage || input.Name != b.App.Name || input.InstallDir != appInstallDir {
+			t.Fatalf("unexpected plugin input for the %s stage: %s", stage, string(data))
+		}
+		if len(input.ConfigureArgs) != 1 || input.ConfigureArgs[0] != "--with-feature" {
+			t.Fatalf("unexpected configure arguments: %s", input.ConfigureArgs)
+		}
+	}
+
+	_, err = NewPluginBuildSystem(filepath.Join(pluginDir, "does_not_exist"))
+	if err == nil {
+		t.Fatalf("loading a plugin that does not exist succeeded")
+	}
+}
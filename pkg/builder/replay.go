@@ -0,0 +1,209 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+)
+
+// RecordedCommand is a command executed to build the software, recorded with its environment so that it can be
+// replayed (see Builder.CommandsFile and ReplayCommands)
+type RecordedCommand struct {
+	// Stage is the stage the command was executed for, e.g., StageConfigure
+	Stage string `json:"stage"`
+
+	// Path is the path to the binary executed
+	Path string `json:"path"`
+
+	// Args is the arguments of the command
+	Args []string `json:"args"`
+
+	// Dir is the directory the command was executed from
+	Dir string `json:"dir"`
+
+	// Env is the environment of the command, the environment of the caller when empty
+	Env []string `json:"env"`
+
+	// Time is when the command was executed
+	Time time.Time `json:"time"`
+
+	// Error is the error of the command, if it failed
+	Error string `json:"error,omitempty"`
+}
+
+// ReplayOptions specifies how recorded commands are replayed (see ReplayCommands)
+type ReplayOptions struct {
+	// Stage is the stage whose commands are replayed, e.g., StageCompile, the stage of the last command that
+	// failed when empty
+	Stage string
+
+	// Env is the variables overriding the recorded environment, e.g., CFLAGS=-O0 -g (optional)
+	Env buildenv.Env
+
+	// Stdout and Stderr are where the output of the commands is written, os.Stdout and os.Stderr when nil
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// recordingRunner records the commands executed through a runner (see Builder.CommandsFile)
+type recordingRunner struct {
+	buildenv.Runner
+	b *Builder
+}
+
+// RunAdvcmd executes a command and records it
+func (r *recordingRunner) RunAdvcmd(cmd *advexec.Advcmd) advexec.Result {
+	res := r.Runner.RunAdvcmd(cmd)
+	r.b.recordCommand(cmd, res.Err)
+	return res
+}
+
+// recordCommands starts recording the commands of the configure, compile, install and test stages in
+// CommandsFile and returns the function stopping it
+func (b *Builder) recordCommands() func() {
+	runner := b.Env.Runner
+	b.Env.Runner = &recordingRunner{Runner: b.Env.GetRunner(), b: b}
+	return func() {
+		b.Env.Runner = runner
+	}
+}
+
+// recordCommand records a command executed for the current stage of the build, if any
+func (b *Builder) recordCommand(cmd *advexec.Advcmd, err error) {
+	switch b.stage {
+	case StageConfigure, StageCompile, StageInstall, StageTest:
+	default:
+		return
+	}
+	recorded := RecordedCommand{
+		Stage: b.stage,
+		Path:  cmd.BinPath,
+		Args:  cmd.CmdArgs,
+		Dir:   cmd.ExecDir,
+		Env:   cmd.Env,
+		Time:  time.Now(),
+	}
+	if recorded.Dir == "" && cmd.Cmd != nil {
+		recorded.Dir = cmd.Cmd.Dir
+	}
+	if err != nil {
+		recorded.Error = err.Error()
+	}
+	b.commands = append(b.commands, recorded)
+
+	// The commands are saved right away so that they are available even if the build is interrupted
+	saveErr := saveCommands(b.CommandsFile, b.commands)
+	if saveErr != nil {
+		log.Printf("[WARN] unable to record the commands of %s: %s", b.App.Name, saveErr)
+	}
+}
+
+// saveCommands writes recorded commands. The file is only readable by its owner since the environment of the
+// commands may include secrets.
+func saveCommands(path string, commands []RecordedCommand) error {
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(path), err)
+	}
+	content, err := json.MarshalIndent(commands, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to encode the commands: %w", err)
+	}
+	err = ioutil.WriteFile(path, content, 0600)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadCommands loads the commands recorded during the last build of a software (see Builder.CommandsFile)
+func LoadCommands(path string) ([]RecordedCommand, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	var commands []RecordedCommand
+	err = json.Unmarshal(content, &commands)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", path, err)
+	}
+	return commands, nil
+}
+
+// ReplayCommands executes again, with the same arguments, directory and environment, the commands of a stage
+// recorded during the last build of a software (see Builder.CommandsFile), by default the commands of the stage
+// that failed. It makes it possible to iterate on a failing step, e.g., with different compiler flags, without
+// building the software from scratch. Replaying stops at the first command that fails.
+func ReplayCommands(path string, opts *ReplayOptions) error {
+	if opts == nil {
+		opts = new(ReplayOptions)
+	}
+	commands, err := LoadCommands(path)
+	if err != nil {
+		return err
+	}
+
+	stage := opts.Stage
+	if stage == "" {
+		for _, cmd := range commands {
+			if cmd.Error != "" {
+				stage = cmd.Stage
+			}
+		}
+		if stage == "" {
+			return fmt.Errorf("no command failed according to %s, the stage to replay must be specified", path)
+		}
+	}
+	var toReplay []RecordedCommand
+	for _, cmd := range commands {
+		if cmd.Stage == stage {
+			toReplay = append(toReplay, cmd)
+		}
+	}
+	if len(toReplay) == 0 {
+		return fmt.Errorf("no command of the %s stage is recorded in %s", stage, path)
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	stderr := opts.Stderr
+	if stderr == nil {
+		stderr = os.Stderr
+	}
+	for _, recorded := range toReplay {
+		env := buildenv.Env(recorded.Env)
+		if len(env) == 0 {
+			env = os.Environ()
+		}
+		env = env.Merge(opts.Env)
+
+		log.Printf("-> Replaying (from %s): %s %s", recorded.Dir, recorded.Path, strings.Join(recorded.Args, " "))
+		cmd := exec.Command(recorded.Path, recorded.Args...)
+		cmd.Dir = recorded.Dir
+		cmd.Env = env
+		cmd.Stdout = stdout
+		cmd.Stderr = stderr
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("%s %s failed: %w", recorded.Path, strings.Join(recorded.Args, " "), err)
+		}
+	}
+	return nil
+}
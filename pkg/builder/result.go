@@ -0,0 +1,75 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// InstallResult is the result of Install(): the result of the last command executed and where and how the
+// software was built and installed, so that callers do not have to figure it out from the environment
+type InstallResult struct {
+	advexec.Result
+
+	// InstallDir is the directory where the software is installed
+	InstallDir string
+
+	// BuildDir is the directory where the software was built, i.e., its source directory or the out-of-tree
+	// build directory, e.g., for CMake; empty when it was not built
+	BuildDir string
+
+	// SrcDir is the directory of the source code of the software, the installation directory when it was
+	// already installed
+	SrcDir string
+
+	// BuildSystem is the name of the build system the software was built with, e.g., autotools; empty when it
+	// was not built
+	BuildSystem string
+
+	// Built specifies whether the software was built, i.e., it was not already installed (see Builder.Force)
+	Built bool
+
+	// StageDurations is the duration of each stage executed, by stage, e.g., StageConfigure
+	StageDurations map[string]time.Duration
+
+	// LogFiles is the paths to the files recording the execution of the build, i.e., the execution manifests
+	// and the recorded commands (see CommandsFile), in the order they were written
+	LogFiles []string
+}
+
+// newInstallResult returns the result of an installation from the result of its last command
+func (b *Builder) newInstallResult(res advexec.Result) *InstallResult {
+	result := &InstallResult{
+		Result:         res,
+		InstallDir:     b.Env.GetAppInstallDir(&b.App),
+		SrcDir:         b.Env.SrcDir,
+		Built:          b.built,
+		StageDurations: b.stageDurations,
+	}
+	if _, configured := b.stageDurations[StageConfigure]; configured && b.BuildSystem != nil {
+		result.BuildSystem = b.BuildSystem.Name()
+		result.BuildDir = b.Env.SrcDir
+		switch result.BuildSystem {
+		case "cmake", "meson":
+			result.BuildDir = filepath.Join(b.Env.SrcDir, outOfTreeBuildDir)
+		}
+	}
+
+	manifests, err := GetManifests(result.InstallDir)
+	if err == nil {
+		for _, m := range manifests {
+			result.LogFiles = append(result.LogFiles, m.Path)
+		}
+	}
+	if b.CommandsFile != "" && util.FileExists(b.CommandsFile) {
+		result.LogFiles = append(result.LogFiles, b.CommandsFile)
+	}
+	return result
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_software_build/pkg/app"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+)
+
+const (
+	// InstalledFilesFilename is the name of the file, in the installation directory of the software, listing
+	// the files installed by a staged installation (see Builder.StagedInstall), one path relative to the
+	// installation directory per line
+	InstalledFilesFilename = "installed_files.txt"
+
+	stagingDirname = "destdir"
+)
+
+// getStagingDir returns the directory where the software is installed before being copied to its installation
+// directory, i.e., the DESTDIR of the installation
+func (b *Builder) getStagingDir() string {
+	return filepath.Join(b.Env.ScratchDir, b.App.Name, stagingDirname)
+}
+
+// listStagedFiles returns the files installed in the staging directory, relative to the staged installation
+// directory. Files installed outside of the installation directory are reported in a warning and ignored.
+func listStagedFiles(stagingDir string, stagedInstallDir string) ([]string, error) {
+	var files []string
+	var outside []string
+	err := filepath.Walk(stagingDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(stagedInstallDir, path)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			outside = append(outside, strings.TrimPrefix(path, stagingDir))
+			return nil
+		}
+		files = append(files, relPath)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to list the files in %s: %w", stagingDir, err)
+	}
+	if len(outside) > 0 {
+		log.Printf("[WARN] files installed outside of the installation directory are ignored: %s", strings.Join(outside, ", "))
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// copyTree copies the content of a directory into another one, preserving modes and symbolic links
+func (b *Builder) copyTree(srcDir string, dstDir string) error {
+	var cmd advexec.Advcmd
+	cmd.BinPath = "cp"
+	cmd.CmdArgs = []string{"-a", srcDir + "/.", dstDir}
+	res := b.Env.GetRunner().RunAdvcmd(&cmd)
+	if res.Err != nil {
+		return fmt.Errorf("unable to copy %s to %s: %w - stdout: %s - stderr: %s", srcDir, dstDir, res.Err, res.Stdout, res.Stderr)
+	}
+	return nil
+}
+
+// syncStagedInstall moves the software installed in the staging directory to its installation directory. The
+// new content of the installation directory is assembled next to it and swapped with it once complete, so that
+// a failure does not leave a partially written installation directory.
+func (b *Builder) syncStagedInstall(stagedInstallDir string, appInstallDir string) error {
+	fs := b.Env.GetFS()
+	newDir := appInstallDir + ".staging"
+	oldDir := appInstallDir + ".old"
+	for _, dir := range []string{newDir, oldDir} {
+		err := fs.RemoveAll(dir)
+		if err != nil {
+			return fmt.Errorf("unable to remove %s: %w", dir, err)
+		}
+	}
+	err := fs.MkdirAll(newDir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", newDir, err)
+	}
+	defer fs.RemoveAll(newDir)
+
+	// The installation directory may already have content, e.g., the manifests of the configuration
+	_, err = fs.Stat(appInstallDir)
+	installDirExists := err == nil
+	if installDirExists {
+		err = b.copyTree(appInstallDir, newDir)
+		if err != nil {
+			return err
+		}
+	}
+	err = b.copyTree(stagedInstallDir, newDir)
+	if err != nil {
+		return err
+	}
+
+	if installDirExists {
+		err = fs.Rename(appInstallDir, oldDir)
+		if err != nil {
+			return fmt.Errorf("unable to rename %s: %w", appInstallDir, err)
+		}
+	}
+	err = fs.Rename(newDir, appInstallDir)
+	if err != nil {
+		if installDirExists {
+			fs.Rename(oldDir, appInstallDir)
+		}
+		return fmt.Errorf("unable to rename %s: %w", newDir, err)
+	}
+	if installDirExists {
+		err = fs.RemoveAll(oldDir)
+		if err != nil {
+			return fmt.Errorf("unable to remove %s: %w", oldDir, err)
+		}
+	}
+	return nil
+}
+
+// stagedInstall installs the software in a staging directory, records the list of the installed files and
+// then copies them to the installation directory of the software (see Builder.StagedInstall)
+func (b *Builder) stagedInstall(pkg *app.Info, env *buildenv.Info) advexec.Result {
+	var res advexec.Result
+	stagingDir := b.getStagingDir()
+	res.Err = b.removeDir(stagingDir)
+	if res.Err != nil {
+		return res
+	}
+	res.Err = env.GetFS().MkdirAll(stagingDir, 0755)
+	if res.Err != nil {
+		res.Err = fmt.Errorf("unable to create %s: %w", stagingDir, res.Err)
+		return res
+	}
+	defer env.GetFS().RemoveAll(stagingDir)
+
+	// Makefiles generated by autotools, CMake and Meson all install in $DESTDIR/<prefix>
+	savedEnv := env.Env
+	installEnv := append(buildenv.Env{}, env.Env...)
+	if len(installEnv) == 0 {
+		installEnv = os.Environ()
+	}
+	installEnv.Set("DESTDIR", stagingDir)
+	env.Env = installEnv
+	env.DestDir = stagingDir
+	log.Printf("- Installing %s in staging directory %s", pkg.Name, stagingDir)
+	res = b.install(pkg, env)
+	env.Env = savedEnv
+	env.DestDir = ""
+	if res.Err != nil {
+		return res
+	}
+
+	appInstallDir := env.GetAppInstallDir(pkg)
+	stagedInstallDir := filepath.Join(stagingDir, appInstallDir)
+	files, err := listStagedFiles(stagingDir, stagedInstallDir)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	if len(files) == 0 {
+		res.Err = fmt.Errorf("nothing was installed in %s, %s may not support DESTDIR", stagedInstallDir, pkg.Name)
+		return res
+	}
+	err = ioutil.WriteFile(filepath.Join(stagedInstallDir, InstalledFilesFilename), []byte(strings.Join(files, "\n")+"\n"), 0644)
+	if err != nil {
+		res.Err = fmt.Errorf("unable to write the list of installed files: %w", err)
+		return res
+	}
+
+	res.Err = b.syncStagedInstall(stagedInstallDir, appInstallDir)
+	if res.Err != nil {
+		return res
+	}
+	b.InstalledFiles = files
+	log.Printf("- %d files of %s installed in %s", len(files), pkg.Name, appInstallDir)
+	return res
+}
+
+// GetInstalledFiles returns the files installed by a staged installation of the software, relative to its
+// installation directory, as recorded in InstalledFilesFilename
+func (b *Builder) GetInstalledFiles() ([]string, error) {
+	listPath := filepath.Join(b.Env.GetAppInstallDir(&b.App), InstalledFilesFilename)
+	content, err := ioutil.ReadFile(listPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", listPath, err)
+	}
+	var files []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if line != "" {
+			files = append(files, line)
+		}
+	}
+	return files, nil
+}
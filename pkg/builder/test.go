@@ -0,0 +1,141 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+)
+
+// TestResult is the result of running the test suite of a software package
+type TestResult struct {
+	advexec.Result
+
+	// BuildSystem is the name of the build system used to run the tests
+	BuildSystem string
+
+	// CountsAvailable specifies whether the following counts could be extracted from the output of the tests
+	CountsAvailable bool
+
+	// Total is the number of tests executed
+	Total int
+
+	// Passed is the number of tests that passed, including expected failures
+	Passed int
+
+	// Failed is the number of tests that failed, including unexpected passes and errors
+	Failed int
+
+	// Skipped is the number of tests that were skipped
+	Skipped int
+}
+
+var (
+	// automakeSummaryRegex matches the lines of the test suite summary of automake, e.g., "# PASS: 3"
+	automakeSummaryRegex = regexp.MustCompile(`(?m)^# (TOTAL|PASS|SKIP|XFAIL|FAIL|XPASS|ERROR):\s+(\d+)`)
+
+	// ctestSummaryRegex matches the summary of ctest, e.g., "80% tests passed, 1 tests failed out of 5"
+	ctestSummaryRegex = regexp.MustCompile(`(?m)^\d+% tests passed, (\d+) tests? failed out of (\d+)`)
+
+	// ctestSkippedRegex matches the tests skipped by ctest, e.g., "  2 - test_gpu (Skipped)"
+	ctestSkippedRegex = regexp.MustCompile(`(?m)\(Skipped\)\s*$`)
+
+	// mesonSummaryRegex matches the lines of the summary of meson, e.g., "Ok: 3"
+	mesonSummaryRegex = regexp.MustCompile(`(?m)^(Ok|Expected Fail|Fail|Unexpected Pass|Skipped|Timeout):\s+(\d+)`)
+)
+
+// Success returns whether the tests ran and none of them failed
+func (r *TestResult) Success() bool {
+	return r.Err == nil && r.Failed == 0
+}
+
+// parseCounts extracts the number of passed, failed and skipped tests from the output of the tests.
+// The summaries of automake, ctest and meson are supported.
+func (r *TestResult) parseCounts(output string) {
+	if matches := automakeSummaryRegex.FindAllStringSubmatch(output, -1); len(matches) > 0 {
+		// Recursive Makefiles print a summary per directory
+		for _, m := range matches {
+			n, _ := strconv.Atoi(m[2])
+			switch m[1] {
+			case "TOTAL":
+				r.Total += n
+			case "PASS", "XFAIL":
+				r.Passed += n
+			case "SKIP":
+				r.Skipped += n
+			case "FAIL", "XPASS", "ERROR":
+				r.Failed += n
+			}
+		}
+		r.CountsAvailable = true
+		return
+	}
+
+	if m := ctestSummaryRegex.FindStringSubmatch(output); m != nil {
+		r.Failed, _ = strconv.Atoi(m[1])
+		r.Total, _ = strconv.Atoi(m[2])
+		r.Skipped = len(ctestSkippedRegex.FindAllString(output, -1))
+		r.Passed = r.Total - r.Failed - r.Skipped
+		r.CountsAvailable = true
+		return
+	}
+
+	if matches := mesonSummaryRegex.FindAllStringSubmatch(output, -1); len(matches) > 0 {
+		for _, m := range matches {
+			n, _ := strconv.Atoi(m[2])
+			switch m[1] {
+			case "Ok", "Expected Fail":
+				r.Passed += n
+			case "Skipped":
+				r.Skipped += n
+			case "Fail", "Unexpected Pass", "Timeout":
+				r.Failed += n
+			}
+		}
+		r.Total = r.Passed + r.Failed + r.Skipped
+		r.CountsAvailable = true
+	}
+}
+
+// Built returns whether the software was built by the builder, i.e., Install() did not skip it
+func (b *Builder) Built() bool {
+	return b.built
+}
+
+// Test runs the test suite of the software, e.g., 'make check' or ctest, once it is built with Install()
+func (b *Builder) Test() TestResult {
+	var res TestResult
+	if !b.built {
+		res.Err = b.newBuildError(StageTest, fmt.Errorf("%s was not built, unable to test it", b.App.Name))
+		return res
+	}
+
+	if b.CommandsFile != "" {
+		stopRecording := b.recordCommands()
+		defer stopRecording()
+	}
+	bs := b.getBuildSystem()
+	res.BuildSystem = bs.Name()
+	log.Printf("- Testing %s with %s...", b.App.Name, res.BuildSystem)
+	endStage := b.startStage(StageTest)
+	res.Result = bs.Test(b)
+	res.parseCounts(res.Stdout)
+	if res.CountsAvailable {
+		log.Printf("-> %s tests: %d passed, %d failed, %d skipped", b.App.Name, res.Passed, res.Failed, res.Skipped)
+	}
+	if res.Err == nil && res.Failed > 0 {
+		res.Err = fmt.Errorf("%d test(s) failed", res.Failed)
+	}
+	endStage(res.Err)
+	if res.Err != nil {
+		res.Err = b.newBuildError(StageTest, res.Err)
+	}
+	return res
+}
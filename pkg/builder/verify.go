@@ -0,0 +1,200 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// Verification specifies how to verify that a software package is correctly installed
+type Verification struct {
+	// Files is the list of files, e.g., binaries and libraries, expected in the installation directory.
+	// Paths are relative to the installation directory and can be patterns, e.g., lib/libucp.so*, which also
+	// match the shared libraries named the macOS way, e.g., lib/libucp.0.dylib, on macOS
+	Files []string `json:"files"`
+
+	// CheckSharedLibs specifies whether the shared libraries the ELF files listed in Files depend on must be
+	// checked with ldd, the lib and lib64 subdirectories of the installation directory being added to LD_LIBRARY_PATH.
+	// On macOS, the Mach-O files are checked with otool instead.
+	CheckSharedLibs bool `json:"check_shared_libs"`
+
+	// PkgConfig is the list of packages, e.g., ucx, that pkg-config must be able to resolve using the
+	// pkg-config files of the installation directory
+	PkgConfig []string `json:"pkg_config"`
+}
+
+// verifyEnv returns the environment to verify the installation, i.e., the build environment in
+// which a variable such as LD_LIBRARY_PATH is prefixed with subdirectories of the installation directory
+func (b *Builder) verifyEnv(varName string, subdirs []string) []string {
+	env := append(buildenv.Env{}, b.Env.Env...)
+	if len(env) == 0 {
+		env = os.Environ()
+	}
+
+	appInstallDir := b.Env.GetAppInstallDir(&b.App)
+	var dirs []string
+	for _, subdir := range subdirs {
+		dirs = append(dirs, filepath.Join(appInstallDir, subdir))
+	}
+	env.Prepend(varName, strings.Join(dirs, ":"), ":")
+	return env
+}
+
+// isELF returns whether a file is an ELF file, i.e., a binary or library ldd can analyze
+func isELF(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	magic := make([]byte, 4)
+	n, _ := f.Read(magic)
+	return n == 4 && bytes.Equal(magic, []byte("\x7fELF"))
+}
+
+// checkSharedLibs returns the shared libraries a binary or library depends on that cannot be found
+func (b *Builder) checkSharedLibs(lddBin string, path string) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(lddBin, path)
+	cmd.Env = b.verifyEnv("LD_LIBRARY_PATH", []string{"lib", "lib64"})
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := b.Env.GetRunner().Run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("ldd %s failed: %w - stdout: %s - stderr: %s", path, err, stdout.String(), stderr.String())
+	}
+
+	var missing []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.Contains(line, "not found") {
+			missing = append(missing, strings.TrimSpace(strings.Split(line, "=>")[0]))
+		}
+	}
+	return missing, nil
+}
+
+// checkMachOSharedLibs returns the shared libraries a macOS binary or library depends on that cannot be found,
+// based on the output of otool -L. Libraries relative to the run-time search paths, e.g., @rpath/libucp.dylib,
+// are resolved by the dynamic linker and are not checked.
+func (b *Builder) checkMachOSharedLibs(otoolBin string, path string) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(otoolBin, "-L", path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := b.Env.GetRunner().Run(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("otool -L %s failed: %w - stdout: %s - stderr: %s", path, err, stdout.String(), stderr.String())
+	}
+
+	var missing []string
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		// The first line is the name of the file itself, dependencies are indented
+		if !strings.HasPrefix(line, "\t") {
+			continue
+		}
+		lib := strings.TrimSpace(strings.Split(line, " (")[0])
+		if !filepath.IsAbs(lib) {
+			continue
+		}
+		// System libraries are in the dyld shared cache and not on the file system since macOS 11
+		if strings.HasPrefix(lib, "/usr/lib/") || strings.HasPrefix(lib, "/System/") {
+			continue
+		}
+		if !util.FileExists(lib) {
+			missing = append(missing, lib)
+		}
+	}
+	return missing, nil
+}
+
+// Verify checks that the software is correctly installed, e.g., that expected files are present
+// and that their shared libraries can be found. All the problems are reported at once.
+func (b *Builder) Verify(v *Verification) error {
+	if v == nil {
+		return nil
+	}
+
+	appInstallDir := b.Env.GetAppInstallDir(&b.App)
+	if !util.PathExists(appInstallDir) {
+		return b.newBuildError(StageVerify, fmt.Errorf("%s does not exist", appInstallDir))
+	}
+
+	log.Printf("- Verifying the installation of %s...", b.App.Name)
+	var problems []string
+	var files []string
+	for _, pattern := range v.Files {
+		matches, err := filepath.Glob(filepath.Join(appInstallDir, pattern))
+		if err != nil {
+			return b.newBuildError(StageVerify, fmt.Errorf("invalid pattern %s: %w", pattern, err))
+		}
+		if len(matches) == 0 && buildenv.GetSharedLibPattern(pattern) != pattern {
+			// Shared libraries are named differently on macOS, e.g., libucp.0.dylib instead of libucp.so.0
+			matches, _ = filepath.Glob(filepath.Join(appInstallDir, buildenv.GetSharedLibPattern(pattern)))
+		}
+		if len(matches) == 0 {
+			problems = append(problems, fmt.Sprintf("%s is missing", pattern))
+		}
+		files = append(files, matches...)
+	}
+
+	if v.CheckSharedLibs && len(files) > 0 {
+		tool := "ldd"
+		checkSharedLibs := b.checkSharedLibs
+		isLib := isELF
+		if buildenv.IsDarwin() {
+			tool = "otool"
+			checkSharedLibs = b.checkMachOSharedLibs
+			isLib = buildenv.IsMachO
+		}
+		lddBin, err := b.Env.GetRunner().LookPath(tool)
+		if err != nil {
+			return b.newBuildError(StageVerify, fmt.Errorf("%s is required to check shared libraries: %w", tool, err))
+		}
+		for _, f := range files {
+			if !isLib(f) {
+				continue
+			}
+			missing, err := checkSharedLibs(lddBin, f)
+			if err != nil {
+				return b.newBuildError(StageVerify, err)
+			}
+			if len(missing) > 0 {
+				problems = append(problems, fmt.Sprintf("%s depends on missing libraries: %s", f, strings.Join(missing, ", ")))
+			}
+		}
+	}
+
+	if len(v.PkgConfig) > 0 {
+		pkgConfigBin, err := b.Env.GetRunner().LookPath("pkg-config")
+		if err != nil {
+			return b.newBuildError(StageVerify, fmt.Errorf("pkg-config is required to check pkg-config files: %w", err))
+		}
+		for _, pkg := range v.PkgConfig {
+			var stderr bytes.Buffer
+			cmd := exec.Command(pkgConfigBin, "--print-errors", "--libs", "--cflags", pkg)
+			cmd.Env = b.verifyEnv("PKG_CONFIG_PATH", []string{"lib/pkgconfig", "lib64/pkgconfig", "share/pkgconfig"})
+			cmd.Stderr = &stderr
+			err := b.Env.GetRunner().Run(cmd)
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("pkg-config cannot resolve %s: %s", pkg, strings.TrimSpace(stderr.String())))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		return b.newBuildError(StageVerify, fmt.Errorf("%s", strings.Join(problems, "; ")))
+	}
+	return nil
+}
@@ -0,0 +1,503 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package dashboard implements a web dashboard of the installations of stacks, e.g., the nightly builds of a build
+// farm: builders stream the progress of their installations (see stack.Config.EventStream and Publisher) and
+// upload their logs and artifacts, and the dashboard shows the stacks being built and the results of the previous
+// builds of all the builders in one place.
+//
+// The dashboard is served by the HTTP server of the application using the package, which mounts the handler of the
+// dashboard (see Dashboard.Handler), or with Dashboard.ListenAndServe. Builders must send the token of the dashboard
+// with their events and artifacts (see Dashboard.Token and Publisher.Token).
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/stack"
+)
+
+const (
+	// RunRunning is the status of the installations in progress
+	RunRunning = "running"
+
+	// RunSuccess is the status of the installations that succeeded
+	RunSuccess = "success"
+
+	// RunFailure is the status of the installations that failed
+	RunFailure = "failure"
+
+	// RunInterrupted is the status of the installations that never completed, i.e., whose builder started another
+	// installation of the same stack, e.g., after crashing
+	RunInterrupted = "interrupted"
+
+	// DefaultMaxRuns is the default maximum number of installations in the history of a dashboard
+	DefaultMaxRuns = 200
+
+	// DefaultMaxUploadSize is the default maximum size, in bytes, of the events and artifacts posted by a builder in
+	// one request
+	DefaultMaxUploadSize = 100 * 1024 * 1024
+
+	// runFileName is the name of the file recording an installation in the history directory
+	runFileName = "run.json"
+
+	// artifactsDirName is the name of the directory of the artifacts of an installation in the history directory
+	artifactsDirName = "artifacts"
+)
+
+// unsafeChars matches the characters that cannot be part of the identifiers of installations and of the names of
+// artifacts, which are used as file names
+var unsafeChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// ComponentRun is the installation of a component of a stack
+type ComponentRun struct {
+	// Name is the name of the component
+	Name string `json:"name"`
+
+	// Status is the status of the installation of the component, e.g., stack.StatusInstalled, "running" while
+	// it is installed
+	Status string `json:"status"`
+
+	// Stage is the current stage of the build of the component, e.g., compile
+	Stage string `json:"stage,omitempty"`
+
+	// Error is the error that made the installation of the component fail, if any
+	Error string `json:"error,omitempty"`
+
+	// LogExcerpt is the end of the log of the failure, if any
+	LogExcerpt string `json:"log_excerpt,omitempty"`
+
+	// LogPath is the path to the log of the failure on the builder, if any
+	LogPath string `json:"log_path,omitempty"`
+
+	// Started and Completed are when the installation of the component started and completed
+	Started   time.Time `json:"started"`
+	Completed time.Time `json:"completed,omitempty"`
+}
+
+// Run is the installation of a stack by a builder
+type Run struct {
+	// ID is the unique identifier of the installation
+	ID string `json:"id"`
+
+	// Builder is the name of the builder installing the stack, e.g., its hostname
+	Builder string `json:"builder"`
+
+	// Stack is the name of the stack
+	Stack string `json:"stack"`
+
+	// Toolchain is the toolchain of the compiler matrix the stack is built with, if any
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// Status is the status of the installation, e.g., RunRunning
+	Status string `json:"status"`
+
+	// Error is the error that made the installation fail, if any
+	Error string `json:"error,omitempty"`
+
+	// Percent is the percentage of the components whose installation completed
+	Percent float64 `json:"percent"`
+
+	// ETASeconds is the estimated remaining time of the installation, in seconds, when known
+	ETASeconds float64 `json:"eta_seconds,omitempty"`
+
+	// Started, Updated and Completed are when the installation started, reported progress for the last time and
+	// completed
+	Started   time.Time `json:"started"`
+	Updated   time.Time `json:"updated"`
+	Completed time.Time `json:"completed,omitempty"`
+
+	// Components is the installations of the components, in the order they started
+	Components []*ComponentRun `json:"components"`
+
+	// Artifacts is the names of the artifacts uploaded for the installation, e.g., the logs of the components
+	Artifacts []string `json:"artifacts,omitempty"`
+}
+
+// Duration returns how long the installation took, or has been running
+func (r *Run) Duration() time.Duration {
+	if r.Completed.IsZero() {
+		return r.Updated.Sub(r.Started).Round(time.Second)
+	}
+	return r.Completed.Sub(r.Started).Round(time.Second)
+}
+
+// getComponent returns the installation of a component, adding it when unknown
+func (r *Run) getComponent(name string) *ComponentRun {
+	for _, comp := range r.Components {
+		if comp.Name == name {
+			return comp
+		}
+	}
+	comp := &ComponentRun{Name: name, Status: RunRunning}
+	r.Components = append(r.Components, comp)
+	return comp
+}
+
+// copy returns a deep copy of the installation, which can be used without holding the lock of the dashboard
+func (r *Run) copy() *Run {
+	c := *r
+	c.Components = nil
+	for _, comp := range r.Components {
+		compCopy := *comp
+		c.Components = append(c.Components, &compCopy)
+	}
+	c.Artifacts = append([]string(nil), r.Artifacts...)
+	return &c
+}
+
+// Dashboard gathers the installations of stacks by one or more builders
+type Dashboard struct {
+	// HistoryDir is the directory where the installations and their artifacts are recorded, so that the history
+	// survives restarts of the dashboard (optional). Artifacts can only be uploaded when set.
+	HistoryDir string
+
+	// MaxRuns is the maximum number of installations in the history, the oldest completed ones being removed,
+	// DefaultMaxRuns when not set (optional)
+	MaxRuns int
+
+	// Token is the secret the builders must send, as "Authorization: Bearer <token>", to post events and artifacts.
+	// Posting is refused when not set, the dashboard then only shows the installations of its history.
+	Token string
+
+	// MaxUploadSize is the maximum size, in bytes, of the events and artifacts posted in one request,
+	// DefaultMaxUploadSize when not set (optional)
+	MaxUploadSize int64
+
+	// mutex protects the installations
+	mutex sync.Mutex
+
+	// runs is the installations, in the order they started
+	runs []*Run
+
+	// current is the latest installation of each stack of each builder, the key being returned by getRunKey()
+	current map[string]*Run
+}
+
+// getRunKey returns the key identifying the installations of a stack by a builder
+func getRunKey(builder string, stackName string, toolchain string) string {
+	return builder + "\x00" + stackName + "\x00" + toolchain
+}
+
+// New returns a dashboard recording its history in historyDir, if not empty, including the installations
+// previously recorded there
+func New(historyDir string) (*Dashboard, error) {
+	d := &Dashboard{
+		HistoryDir: historyDir,
+		current:    make(map[string]*Run),
+	}
+	if historyDir == "" {
+		return d, nil
+	}
+	err := os.MkdirAll(historyDir, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create %s: %w", historyDir, err)
+	}
+	entries, err := ioutil.ReadDir(historyDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", historyDir, err)
+	}
+	for _, entry := range entries {
+		runFile := filepath.Join(historyDir, entry.Name(), runFileName)
+		content, err := ioutil.ReadFile(runFile)
+		if err != nil {
+			continue
+		}
+		run := new(Run)
+		err = json.Unmarshal(content, run)
+		if err != nil {
+			return nil, fmt.Errorf("unable to unmarshal content of %s: %w", runFile, err)
+		}
+		d.runs = append(d.runs, run)
+	}
+	sort.SliceStable(d.runs, func(i, j int) bool {
+		return d.runs[i].Started.Before(d.runs[j].Started)
+	})
+	// Installations in progress when the dashboard stopped may still be running
+	for _, run := range d.runs {
+		d.current[getRunKey(run.Builder, run.Stack, run.Toolchain)] = run
+	}
+	return d, nil
+}
+
+// getRunDir returns the directory where an installation is recorded
+func (d *Dashboard) getRunDir(run *Run) string {
+	return filepath.Join(d.HistoryDir, run.ID)
+}
+
+// save records an installation in the history directory, if any
+func (d *Dashboard) save(run *Run) error {
+	if d.HistoryDir == "" {
+		return nil
+	}
+	runDir := d.getRunDir(run)
+	err := os.MkdirAll(runDir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", runDir, err)
+	}
+	content, err := json.MarshalIndent(run, "", "  ")
+	if err != nil {
+		return fmt.Errorf("unable to encode installation %s: %w", run.ID, err)
+	}
+	// Readers never see a partially written file
+	runFile := filepath.Join(runDir, runFileName)
+	err = ioutil.WriteFile(runFile+".tmp", content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", runFile, err)
+	}
+	err = os.Rename(runFile+".tmp", runFile)
+	if err != nil {
+		return fmt.Errorf("unable to rename %s: %w", runFile+".tmp", err)
+	}
+	return nil
+}
+
+// prune removes the oldest completed installations beyond the maximum size of the history
+func (d *Dashboard) prune() {
+	maxRuns := d.MaxRuns
+	if maxRuns <= 0 {
+		maxRuns = DefaultMaxRuns
+	}
+	var kept []*Run
+	excess := len(d.runs) - maxRuns
+	for _, run := range d.runs {
+		if excess > 0 && run.Status != RunRunning {
+			excess--
+			if d.HistoryDir != "" {
+				os.RemoveAll(d.getRunDir(run))
+			}
+			key := getRunKey(run.Builder, run.Stack, run.Toolchain)
+			if d.current[key] == run {
+				delete(d.current, key)
+			}
+			continue
+		}
+		kept = append(kept, run)
+	}
+	d.runs = kept
+}
+
+// startRun records the start of an installation of a stack by a builder
+func (d *Dashboard) startRun(builder string, stackName string, toolchain string, started time.Time) *Run {
+	key := getRunKey(builder, stackName, toolchain)
+	if prev, ok := d.current[key]; ok && prev.Status == RunRunning {
+		prev.Status = RunInterrupted
+		_ = d.save(prev)
+	}
+
+	idParts := []string{builder, stackName}
+	if toolchain != "" {
+		idParts = append(idParts, toolchain)
+	}
+	idParts = append(idParts, started.UTC().Format("20060102-150405"))
+	baseID := unsafeChars.ReplaceAllString(strings.Join(idParts, "-"), "_")
+	id := baseID
+	for n := 2; d.getRun(id) != nil; n++ {
+		id = fmt.Sprintf("%s-%d", baseID, n)
+	}
+	run := &Run{
+		ID:        id,
+		Builder:   builder,
+		Stack:     stackName,
+		Toolchain: toolchain,
+		Status:    RunRunning,
+		Started:   started,
+		Updated:   started,
+	}
+	d.runs = append(d.runs, run)
+	d.current[key] = run
+	d.prune()
+	return run
+}
+
+// getRun returns an installation based on its identifier, nil if unknown
+func (d *Dashboard) getRun(id string) *Run {
+	for _, run := range d.runs {
+		if run.ID == id {
+			return run
+		}
+	}
+	return nil
+}
+
+// Record records an event of the progress of the installation of a stack by a builder (see
+// stack.Config.EventStream). Events of installations whose start was not recorded, e.g., because the dashboard
+// was restarted, start a new installation.
+func (d *Dashboard) Record(builder string, event *stack.ProgressEvent) error {
+	if event.Stack == "" {
+		return fmt.Errorf("event %s does not specify its stack", event.Type)
+	}
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.current == nil {
+		d.current = make(map[string]*Run)
+	}
+	run, ok := d.current[getRunKey(builder, event.Stack, event.Toolchain)]
+	if event.Type == stack.ProgressStackStarted || !ok || run.Status != RunRunning {
+		run = d.startRun(builder, event.Stack, event.Toolchain, event.Time)
+	}
+	run.Updated = event.Time
+	run.Percent = event.Percent
+	if event.Total > 0 {
+		run.ETASeconds = event.ETASeconds
+	}
+
+	save := false
+	switch event.Type {
+	case stack.ProgressStackStarted:
+		save = true
+	case stack.ProgressComponentStarted:
+		comp := run.getComponent(event.Component)
+		comp.Status = RunRunning
+		comp.Stage = ""
+		comp.Error = ""
+		comp.LogExcerpt = ""
+		comp.LogPath = ""
+		comp.Started = event.Time
+		comp.Completed = time.Time{}
+	case stack.ProgressStageStarted:
+		run.getComponent(event.Component).Stage = event.Stage
+	case stack.ProgressComponentCompleted:
+		comp := run.getComponent(event.Component)
+		comp.Status = event.Status
+		comp.Stage = ""
+		comp.Error = event.Error
+		comp.LogExcerpt = event.LogExcerpt
+		comp.LogPath = event.LogPath
+		comp.Completed = event.Time
+		if comp.Started.IsZero() {
+			comp.Started = event.Time
+		}
+		save = true
+	case stack.ProgressStackCompleted:
+		run.Status = RunSuccess
+		if event.Status == "failure" {
+			run.Status = RunFailure
+		}
+		run.Error = event.Error
+		run.ETASeconds = 0
+		run.Completed = event.Time
+		save = true
+	}
+	if !save {
+		return nil
+	}
+	return d.save(run)
+}
+
+// getMaxUploadSize returns the maximum size of the events and artifacts posted in one request
+func (d *Dashboard) getMaxUploadSize() int64 {
+	if d.MaxUploadSize <= 0 {
+		return DefaultMaxUploadSize
+	}
+	return d.MaxUploadSize
+}
+
+// AddArtifact records an artifact of the current installation of a stack by a builder, e.g., the log of a
+// component or the SBOM of the stack. The history directory of the dashboard must be set and the artifact
+// cannot be larger than MaxUploadSize.
+func (d *Dashboard) AddArtifact(builder string, stackName string, toolchain string, name string, content io.Reader) error {
+	if d.HistoryDir == "" {
+		return fmt.Errorf("the dashboard has no history directory to store artifacts")
+	}
+	name = unsafeChars.ReplaceAllString(filepath.Base(name), "_")
+	if name == "" || name == "." || name == ".." {
+		return fmt.Errorf("invalid artifact name %q", name)
+	}
+
+	d.mutex.Lock()
+	run, ok := d.current[getRunKey(builder, stackName, toolchain)]
+	if !ok {
+		d.mutex.Unlock()
+		return fmt.Errorf("no installation of %s by %s", stackName, builder)
+	}
+	artifactsDir := filepath.Join(d.getRunDir(run), artifactsDirName)
+	d.mutex.Unlock()
+
+	err := os.MkdirAll(artifactsDir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", artifactsDir, err)
+	}
+	artifactPath := filepath.Join(artifactsDir, name)
+	f, err := os.Create(artifactPath)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", artifactPath, err)
+	}
+	maxSize := d.getMaxUploadSize()
+	written, err := io.Copy(f, io.LimitReader(content, maxSize+1))
+	closeErr := f.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err == nil && written > maxSize {
+		err = fmt.Errorf("the artifact is larger than %d bytes", maxSize)
+	}
+	if err != nil {
+		os.Remove(artifactPath)
+		return fmt.Errorf("unable to write %s: %w", artifactPath, err)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, artifact := range run.Artifacts {
+		if artifact == name {
+			return nil
+		}
+	}
+	run.Artifacts = append(run.Artifacts, name)
+	sort.Strings(run.Artifacts)
+	return d.save(run)
+}
+
+// Runs returns the installations, the most recent first
+func (d *Dashboard) Runs() []*Run {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	var runs []*Run
+	for idx := len(d.runs) - 1; idx >= 0; idx-- {
+		runs = append(runs, d.runs[idx].copy())
+	}
+	return runs
+}
+
+// Run returns an installation based on its identifier, nil if unknown
+func (d *Dashboard) Run(id string) *Run {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if run := d.getRun(id); run != nil {
+		return run.copy()
+	}
+	return nil
+}
+
+// getArtifactPath returns the path to an artifact of an installation, an empty string if unknown
+func (d *Dashboard) getArtifactPath(id string, name string) string {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	run := d.getRun(id)
+	if run == nil || d.HistoryDir == "" {
+		return ""
+	}
+	for _, artifact := range run.Artifacts {
+		if artifact == name {
+			return filepath.Join(d.getRunDir(run), artifactsDirName, name)
+		}
+	}
+	return ""
+}
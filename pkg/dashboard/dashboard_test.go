@@ -0,0 +1,245 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package dashboard
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/stack"
+)
+
+// getURL returns the body of the response to a GET request, failing the test if not successful
+func getURL(t *testing.T, url string) string {
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("unable to get %s: %s", url, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("unable to read the response of %s: %s", url, err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unable to get %s: %s", url, resp.Status)
+	}
+	return string(body)
+}
+
+func TestDashboard(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not available, skipping test")
+	}
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create the temporary directory for testing: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	srcDir := filepath.Join(testDir, "src")
+	err = os.MkdirAll(srcDir, 0755)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", srcDir, err)
+	}
+	configureScript := "#!/bin/sh\nprintf 'all:\\n\\ttrue\\n\\ninstall:\\n\\ttrue\\n' > Makefile\n"
+	err = ioutil.WriteFile(filepath.Join(srcDir, "configure"), []byte(configureScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create configure script: %s", err)
+	}
+
+	historyDir := filepath.Join(testDir, "history")
+	d, err := New(historyDir)
+	if err != nil {
+		t.Fatalf("New() failed: %s", err)
+	}
+	d.Token = "secret"
+	server := httptest.NewServer(d.Handler())
+	defer server.Close()
+
+	cfg := &stack.Config{
+		Loaded:      true,
+		KeepGoing:   true,
+		EventStream: NewPublisher(server.URL+"/", "node1", "$TEST_DASHBOARD_TOKEN"),
+		Data: stack.Stack{
+			StackConfig: &stack.StackCfg{
+				InstallDir: filepath.Join(testDir, "install"),
+				System:     "host",
+			},
+			StackDefinition: &stack.StackDef{
+				Name:   "nightly",
+				System: "host",
+				Type:   "public",
+				Components: []stack.Component{
+					{Name: "working", URL: "file://" + srcDir},
+					{Name: "failing", URL: "file://" + srcDir, InstallCmd: "false"},
+				},
+			},
+		},
+	}
+	os.Setenv("TEST_DASHBOARD_TOKEN", "secret")
+	defer os.Unsetenv("TEST_DASHBOARD_TOKEN")
+	err = cfg.InstallStack()
+	if err == nil {
+		t.Fatalf("stack installation succeeded while expected to fail")
+	}
+
+	var runs []*Run
+	err = json.Unmarshal([]byte(getURL(t, server.URL+"/api/runs")), &runs)
+	if err != nil {
+		t.Fatalf("unable to decode the installations: %s", err)
+	}
+	if len(runs) != 1 {
+		t.Fatalf("%d installations instead of 1", len(runs))
+	}
+	run := runs[0]
+	if run.Builder != "node1" || run.Stack != "nightly" || run.Status != RunFailure || run.Percent != 100 {
+		t.Fatalf("unexpected installation: %+v", run)
+	}
+	if len(run.Components) != 2 || run.Components[0].Status != stack.StatusInstalled || run.Components[1].Status != stack.StatusFailed {
+		t.Fatalf("unexpected components: %+v", run.Components)
+	}
+	if run.Components[1].Error == "" || run.Components[1].LogPath == "" {
+		t.Fatalf("the failure of failing is not recorded: %+v", run.Components[1])
+	}
+	if len(run.Artifacts) != 1 || run.Artifacts[0] != "failing.log" {
+		t.Fatalf("the log of failing was not uploaded: %v", run.Artifacts)
+	}
+
+	for path, expected := range map[string]string{
+		"/":               "node1",
+		"/runs/" + run.ID: "failing.log",
+		"/runs/" + run.ID + "/artifacts/failing.log": "false",
+	} {
+		if page := getURL(t, server.URL+path); !strings.Contains(page, expected) {
+			t.Fatalf("%s does not include %q:\n%s", path, expected, page)
+		}
+	}
+	resp, err := http.Get(server.URL + "/runs/" + run.ID + "/artifacts/..%2Frun.json")
+	if err != nil {
+		t.Fatalf("unable to get an invalid artifact: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("invalid artifact served: %s", resp.Status)
+	}
+
+	// Builders must be authenticated and cannot post more than the maximum size
+	d.MaxUploadSize = 10
+	for _, tt := range []struct {
+		token    string
+		content  string
+		expected int
+	}{
+		{token: "", content: "log", expected: http.StatusUnauthorized},
+		{token: "wrong", content: "log", expected: http.StatusUnauthorized},
+		{token: "secret", content: "a log larger than the maximum size", expected: http.StatusBadRequest},
+		{token: "secret", content: "log", expected: http.StatusNoContent},
+	} {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/api/artifacts?builder=node1&stack=nightly&name=test.log", strings.NewReader(tt.content))
+		if err != nil {
+			t.Fatalf("invalid request: %s", err)
+		}
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("unable to post an artifact: %s", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.expected {
+			t.Fatalf("artifact posted with token %q: %s instead of %d", tt.token, resp.Status, tt.expected)
+		}
+	}
+	run = d.Run(run.ID)
+	if len(run.Artifacts) != 2 || run.Artifacts[1] != "test.log" {
+		t.Fatalf("unexpected artifacts: %v", run.Artifacts)
+	}
+	d.Token = ""
+	resp, err = http.Post(server.URL+"/api/events?builder=node1", "application/json", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatalf("unable to post events: %s", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("events posted to a dashboard without token: %s", resp.Status)
+	}
+
+	// The history survives restarts
+	d, err = New(historyDir)
+	if err != nil {
+		t.Fatalf("New() failed: %s", err)
+	}
+	reloaded := d.Run(run.ID)
+	if reloaded == nil || reloaded.Status != RunFailure || len(reloaded.Components) != 2 {
+		t.Fatalf("installation %s not reloaded: %+v", run.ID, reloaded)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	d, err := New("")
+	if err != nil {
+		t.Fatalf("New() failed: %s", err)
+	}
+	d.MaxRuns = 2
+	start := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	events := []stack.ProgressEvent{
+		// The start of the first installation is missed
+		{Type: stack.ProgressComponentStarted, Stack: "s", Component: "a", Index: 1, Total: 2},
+		// The builder restarts the installation
+		{Type: stack.ProgressStackStarted, Stack: "s", Total: 2},
+		{Type: stack.ProgressComponentStarted, Stack: "s", Component: "a", Index: 1, Total: 2},
+		{Type: stack.ProgressStageStarted, Stack: "s", Component: "a", Stage: "compile"},
+	}
+	for idx := range events {
+		events[idx].Time = start.Add(time.Duration(idx) * time.Minute)
+		err := d.Record("node1", &events[idx])
+		if err != nil {
+			t.Fatalf("Record() failed: %s", err)
+		}
+	}
+	runs := d.Runs()
+	if len(runs) != 2 || runs[1].Status != RunInterrupted || runs[0].Status != RunRunning {
+		t.Fatalf("unexpected installations: %+v", runs)
+	}
+	if runs[0].Components[0].Stage != "compile" {
+		t.Fatalf("the stage of a is not recorded: %+v", runs[0].Components[0])
+	}
+
+	// Completed installations are removed from the history first
+	err = d.Record("node2", &stack.ProgressEvent{Type: stack.ProgressStackStarted, Stack: "s", Time: start.Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Record() failed: %s", err)
+	}
+	runs = d.Runs()
+	if len(runs) != 2 || runs[0].Builder != "node2" || runs[1].Builder != "node1" || runs[1].Status != RunRunning {
+		t.Fatalf("unexpected installations after pruning: %+v", runs)
+	}
+
+	if err := d.Record("node1", &stack.ProgressEvent{Type: stack.ProgressStackStarted}); err == nil {
+		t.Fatalf("event without stack recorded")
+	}
+	if err := d.AddArtifact("node1", "s", "", "log", strings.NewReader("")); err == nil {
+		t.Fatalf("artifact stored without history directory")
+	}
+	d.HistoryDir = t.TempDir()
+	d.MaxUploadSize = 4
+	if err := d.AddArtifact("node1", "s", "", "log", strings.NewReader("12345")); err == nil {
+		t.Fatalf("artifact larger than the maximum size stored")
+	}
+	if err := d.AddArtifact("node1", "s", "", "log", strings.NewReader("1234")); err != nil {
+		t.Fatalf("AddArtifact() failed: %s", err)
+	}
+}
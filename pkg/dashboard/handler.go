@@ -0,0 +1,265 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package dashboard
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/stack"
+)
+
+// maxEventSize is the maximum size of an event posted to the dashboard
+const maxEventSize = 1024 * 1024
+
+// pageTemplates is the HTML pages of the dashboard, which refresh themselves every 10 seconds
+var pageTemplates = template.Must(template.New("").Funcs(template.FuncMap{
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Local().Format("2006-01-02 15:04:05")
+	},
+	"eta": func(seconds float64) string {
+		return (time.Duration(seconds) * time.Second).String()
+	},
+}).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="10">
+<title>{{.}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+pre { margin: 0; max-height: 20em; overflow: auto; background: #f6f6f6; }
+.running { color: #1f5fbf; }
+.success, .installed { color: #1e7b1e; }
+.failure, .failed { color: #b01c1c; }
+.interrupted, .skipped { color: #a86b00; }
+</style>
+</head>
+<body>
+{{end}}
+
+{{define "index"}}{{template "header" "Stack installations"}}
+<h1>Stack installations</h1>
+<table>
+<tr><th>Started</th><th>Builder</th><th>Stack</th><th>Toolchain</th><th>Status</th><th>Progress</th><th>Duration</th></tr>
+{{range .}}<tr>
+<td><a href="runs/{{.ID}}">{{date .Started}}</a></td>
+<td>{{.Builder}}</td>
+<td>{{.Stack}}</td>
+<td>{{.Toolchain}}</td>
+<td class="{{.Status}}">{{.Status}}</td>
+<td>{{printf "%.0f" .Percent}}%{{if and (eq .Status "running") .ETASeconds}} (ETA {{eta .ETASeconds}}){{end}}</td>
+<td>{{.Duration}}</td>
+</tr>{{else}}<tr><td colspan="7">No installation yet</td></tr>{{end}}
+</table>
+</body>
+</html>
+{{end}}
+
+{{define "run"}}{{template "header" .ID}}
+<p><a href="../">All installations</a></p>
+<h1>{{.Stack}}{{if .Toolchain}} ({{.Toolchain}}){{end}} on {{.Builder}}</h1>
+<p>
+Status: <span class="{{.Status}}">{{.Status}}</span> - {{printf "%.0f" .Percent}}%{{if and (eq .Status "running") .ETASeconds}} (ETA {{eta .ETASeconds}}){{end}}<br>
+Started: {{date .Started}}{{if not .Completed.IsZero}} - Completed: {{date .Completed}}{{end}} ({{.Duration}})
+</p>
+{{if .Error}}<pre>{{.Error}}</pre>{{end}}
+<h2>Components</h2>
+<table>
+<tr><th>Component</th><th>Status</th><th>Started</th><th>Completed</th><th>Error</th></tr>
+{{range .Components}}<tr>
+<td>{{.Name}}</td>
+<td class="{{.Status}}">{{.Status}}{{if .Stage}} ({{.Stage}}){{end}}</td>
+<td>{{date .Started}}</td>
+<td>{{date .Completed}}</td>
+<td>{{if .Error}}<pre>{{.Error}}{{if .LogExcerpt}}
+{{.LogExcerpt}}{{end}}</pre>{{if .LogPath}}Log on the builder: {{.LogPath}}{{end}}{{end}}</td>
+</tr>{{end}}
+</table>
+{{if .Artifacts}}<h2>Logs and artifacts</h2>
+<ul>
+{{$id := .ID}}{{range .Artifacts}}<li><a href="{{$id}}/artifacts/{{.}}">{{.}}</a></li>
+{{end}}</ul>{{end}}
+</body>
+</html>
+{{end}}
+`))
+
+// writeJSON writes a value in JSON as the response to a request
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	err := encoder.Encode(v)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// writePage writes an HTML page of the dashboard as the response to a request
+func writePage(w http.ResponseWriter, name string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := pageTemplates.ExecuteTemplate(w, name, data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// checkToken checks that a request of a builder carries the token of the dashboard, writing the error response
+// when it does not
+func (d *Dashboard) checkToken(w http.ResponseWriter, req *http.Request) bool {
+	if d.Token == "" {
+		http.Error(w, "posting to the dashboard is disabled, no token is set", http.StatusForbidden)
+		return false
+	}
+	expected := "Bearer " + d.Token
+	if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), []byte(expected)) != 1 {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "invalid token", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleEvents records the events posted by a builder, one JSON document per line, e.g., the event stream of the
+// installation of a stack (see stack.Config.EventStream)
+func (d *Dashboard) handleEvents(w http.ResponseWriter, req *http.Request) {
+	builder := req.URL.Query().Get("builder")
+	if builder == "" {
+		http.Error(w, "undefined builder", http.StatusBadRequest)
+		return
+	}
+	scanner := bufio.NewScanner(req.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		event := new(stack.ProgressEvent)
+		err := json.Unmarshal([]byte(line), event)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid event: %s", err), http.StatusBadRequest)
+			return
+		}
+		err = d.Record(builder, event)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		http.Error(w, fmt.Sprintf("unable to read the events: %s", err), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleArtifact records an artifact posted by a builder for its current installation of a stack
+func (d *Dashboard) handleArtifact(w http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	builder := query.Get("builder")
+	stackName := query.Get("stack")
+	name := query.Get("name")
+	if builder == "" || stackName == "" || name == "" {
+		http.Error(w, "the builder, the stack and the name of the artifact must be specified", http.StatusBadRequest)
+		return
+	}
+	err := d.AddArtifact(builder, stackName, query.Get("toolchain"), name, req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Handler returns an HTTP handler serving the dashboard, to mount in the HTTP server of the application, e.g.,
+// http.Handle("/dashboard/", http.StripPrefix("/dashboard", d.Handler())). The POST requests must carry the token
+// of the dashboard (see Token) and their body cannot be larger than MaxUploadSize.
+//
+//	GET  /                              the web page of the installations
+//	GET  /runs/<id>                     the web page of an installation, with its logs and artifacts
+//	GET  /runs/<id>/artifacts/<name>    an artifact of an installation
+//	GET  /api/runs                      the installations, in JSON
+//	GET  /api/runs/<id>                 an installation, in JSON
+//	POST /api/events?builder=<name>     events of the installation of a stack, in JSON lines (see Publisher)
+//	POST /api/artifacts?builder=<name>&stack=<stack>&toolchain=<toolchain>&name=<name>
+//	                                    an artifact of the current installation of a stack, e.g., a log
+func (d *Dashboard) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path := strings.Trim(req.URL.Path, "/")
+		parts := strings.Split(path, "/")
+		if req.Method == http.MethodPost && (path == "api/events" || path == "api/artifacts") {
+			if !d.checkToken(w, req) {
+				return
+			}
+			req.Body = http.MaxBytesReader(w, req.Body, d.getMaxUploadSize())
+		}
+		switch {
+		case req.Method == http.MethodPost && path == "api/events":
+			d.handleEvents(w, req)
+		case req.Method == http.MethodPost && path == "api/artifacts":
+			d.handleArtifact(w, req)
+		case req.Method != http.MethodGet && req.Method != http.MethodHead:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		case path == "":
+			writePage(w, "index", d.Runs())
+		case path == "api/runs":
+			writeJSON(w, d.Runs())
+		case len(parts) == 3 && parts[0] == "api" && parts[1] == "runs":
+			run := d.Run(parts[2])
+			if run == nil {
+				http.NotFound(w, req)
+				return
+			}
+			writeJSON(w, run)
+		case len(parts) == 2 && parts[0] == "runs":
+			run := d.Run(parts[1])
+			if run == nil {
+				http.NotFound(w, req)
+				return
+			}
+			writePage(w, "run", run)
+		case len(parts) == 4 && parts[0] == "runs" && parts[2] == "artifacts":
+			artifactPath := d.getArtifactPath(parts[1], parts[3])
+			if artifactPath == "" {
+				http.NotFound(w, req)
+				return
+			}
+			// Logs are displayed in the browser, other artifacts are downloaded
+			if strings.HasSuffix(artifactPath, ".log") || strings.HasSuffix(artifactPath, ".txt") {
+				w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			} else {
+				w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", parts[3]))
+			}
+			http.ServeFile(w, req, artifactPath)
+		default:
+			http.NotFound(w, req)
+		}
+	})
+}
+
+// ListenAndServe serves the dashboard on a TCP address, e.g., ":8080", until an error occurs
+func (d *Dashboard) ListenAndServe(addr string) error {
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           d.Handler(),
+		ReadHeaderTimeout: 30 * time.Second,
+	}
+	return server.ListenAndServe()
+}
@@ -0,0 +1,139 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package dashboard
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/stack"
+)
+
+// publishTimeout is the maximum duration of a request of a publisher to a dashboard
+var publishTimeout = 30 * time.Second
+
+// Publisher publishes the installations of a builder to a dashboard. It is the event stream of the installations
+// (see stack.Config.EventStream), e.g., cfg.EventStream = dashboard.NewPublisher("https://builds.example.com",
+// hostname, "$DASHBOARD_TOKEN"), and uploads the logs of the components that fail to install.
+type Publisher struct {
+	// URL is the URL the dashboard is served at, e.g., https://builds.example.com/dashboard
+	URL string
+
+	// Builder is the name of the builder, e.g., its hostname
+	Builder string
+
+	// Token is the token of the dashboard (see Dashboard.Token), which may refer to an environment variable, e.g.,
+	// "$DASHBOARD_TOKEN"
+	Token string
+
+	// Headers is the additional HTTP headers of the requests, e.g., for a proxy in front of the dashboard, values
+	// referring to environment variables (optional)
+	Headers map[string]string
+
+	// buf is the incomplete line of the stream
+	buf []byte
+
+	// stack and toolchain identify the current installation
+	stack     string
+	toolchain string
+}
+
+// NewPublisher returns a publisher of the installations of a builder to the dashboard served at a URL, with the
+// token of the dashboard
+func NewPublisher(dashboardURL string, builder string, token string) *Publisher {
+	return &Publisher{
+		URL:     strings.TrimSuffix(dashboardURL, "/"),
+		Builder: builder,
+		Token:   token,
+	}
+}
+
+// post posts content to an endpoint of the dashboard
+func (p *Publisher) post(endpoint string, query url.Values, content io.Reader) error {
+	query.Set("builder", p.Builder)
+	target := fmt.Sprintf("%s/%s?%s", p.URL, endpoint, query.Encode())
+	req, err := http.NewRequest(http.MethodPost, target, content)
+	if err != nil {
+		return fmt.Errorf("invalid request for %s: %w", target, err)
+	}
+	for name, value := range p.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+os.ExpandEnv(p.Token))
+	}
+	client := &http.Client{Timeout: publishTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("unable to post to %s: %w", p.URL, err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unable to post to %s: %s - %s", p.URL, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
+
+// Write implements io.Writer, posting the complete lines, i.e., the events of the installation, to the dashboard.
+// The logs of the components that failed are uploaded when they are available.
+func (p *Publisher) Write(data []byte) (int, error) {
+	p.buf = append(p.buf, data...)
+	idx := bytes.LastIndexByte(p.buf, '\n')
+	if idx == -1 {
+		return len(data), nil
+	}
+	lines := p.buf[:idx+1]
+	p.buf = append([]byte(nil), p.buf[idx+1:]...)
+
+	var logs []*stack.ProgressEvent
+	for _, line := range bytes.Split(bytes.TrimSpace(lines), []byte("\n")) {
+		event := new(stack.ProgressEvent)
+		if err := json.Unmarshal(line, event); err != nil {
+			continue
+		}
+		p.stack = event.Stack
+		p.toolchain = event.Toolchain
+		if event.Type == stack.ProgressComponentCompleted && event.LogPath != "" {
+			logs = append(logs, event)
+		}
+	}
+	err := p.post("api/events", url.Values{}, bytes.NewReader(lines))
+	if err != nil {
+		return 0, err
+	}
+	for _, event := range logs {
+		// The installation does not fail because a log is missing
+		_ = p.Upload(event.Component+".log", event.LogPath)
+	}
+	return len(data), nil
+}
+
+// Upload uploads a file as an artifact of the current installation, e.g., the SBOM of the stack once installed
+func (p *Publisher) Upload(name string, path string) error {
+	if p.stack == "" {
+		return fmt.Errorf("no installation in progress")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", path, err)
+	}
+	defer f.Close()
+	query := url.Values{}
+	query.Set("stack", p.stack)
+	query.Set("toolchain", p.toolchain)
+	query.Set("name", filepath.Base(name))
+	return p.post("api/artifacts", query, f)
+}
@@ -0,0 +1,368 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package metrics instruments the builds and the installations of stacks with counters and histograms, exposed
+// in the Prometheus text format, and optional tracing spans, so that a build farm can monitor them like any
+// other service.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DownloadsTotal is the number of files downloaded, labeled by status (success or failure)
+	DownloadsTotal = "go_software_build_downloads_total"
+
+	// CacheHitsTotal is the number of times the source code or configure results were found in a cache, labeled
+	// by cache (source, git or configure)
+	CacheHitsTotal = "go_software_build_cache_hits_total"
+
+	// CacheMissesTotal is the number of times the source code or configure results were not found in a cache,
+	// labeled by cache (source, git or configure)
+	CacheMissesTotal = "go_software_build_cache_misses_total"
+
+	// StageDurationSeconds is the duration of the stages of the builds, labeled by stage (see builder.StageDownload)
+	StageDurationSeconds = "go_software_build_stage_duration_seconds"
+
+	// StageFailuresTotal is the number of builds that failed, labeled by the stage that failed
+	StageFailuresTotal = "go_software_build_stage_failures_total"
+
+	// ComponentInstallsTotal is the number of installations of components of stacks, labeled by component and
+	// status (installed, failed or external)
+	ComponentInstallsTotal = "go_software_build_component_installs_total"
+
+	// ComponentInstallDurationSeconds is the duration of the successful installations of components of stacks,
+	// labeled by component
+	ComponentInstallDurationSeconds = "go_software_build_component_install_duration_seconds"
+
+	// StackInstallsTotal is the number of installations of stacks, labeled by stack and status (success or
+	// failure)
+	StackInstallsTotal = "go_software_build_stack_installs_total"
+
+	// StackInstallDurationSeconds is the duration of the installations of stacks, labeled by stack
+	StackInstallDurationSeconds = "go_software_build_stack_install_duration_seconds"
+)
+
+// DurationBuckets is the default upper bounds, in seconds, of the buckets of the histograms of durations, builds
+// lasting from seconds to hours
+var DurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1800, 3600, 7200}
+
+// Labels is the labels of a series of a metric, e.g., {"stage": "configure"}
+type Labels map[string]string
+
+const (
+	counterType   = "counter"
+	histogramType = "histogram"
+)
+
+// series is the value of a metric for a set of labels
+type series struct {
+	labels Labels
+
+	// value is the value of a counter
+	value float64
+
+	// counts is the number of observations of a histogram in each bucket, not cumulative
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// metric is a counter or a histogram and all its series
+type metric struct {
+	name       string
+	help       string
+	metricType string
+	buckets    []float64
+	series     map[string]*series
+}
+
+// Registry is a set of metrics. Metrics are created when first updated, NewCounter and NewHistogram only being
+// needed to document them or to set the buckets of histograms.
+type Registry struct {
+	mutex   sync.Mutex
+	metrics map[string]*metric
+}
+
+// Default is the registry the builds and the installations of stacks are instrumented with
+var Default = NewRegistry()
+
+func init() {
+	Default.NewCounter(DownloadsTotal, "Number of files downloaded, by status.")
+	Default.NewCounter(CacheHitsTotal, "Number of cache hits, by cache.")
+	Default.NewCounter(CacheMissesTotal, "Number of cache misses, by cache.")
+	Default.NewHistogram(StageDurationSeconds, "Duration of the build stages in seconds, by stage.", DurationBuckets)
+	Default.NewCounter(StageFailuresTotal, "Number of failed builds, by stage.")
+	Default.NewCounter(ComponentInstallsTotal, "Number of installations of stack components, by component and status.")
+	Default.NewHistogram(ComponentInstallDurationSeconds, "Duration of the successful installations of stack components in seconds, by component.", DurationBuckets)
+	Default.NewCounter(StackInstallsTotal, "Number of installations of stacks, by stack and status.")
+	Default.NewHistogram(StackInstallDurationSeconds, "Duration of the installations of stacks in seconds, by stack.", DurationBuckets)
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]*metric)}
+}
+
+// getMetric returns a metric, creating it when it does not exist yet. The caller must hold the mutex.
+func (r *Registry) getMetric(name string, metricType string) *metric {
+	m, ok := r.metrics[name]
+	if !ok {
+		m = &metric{name: name, metricType: metricType, series: make(map[string]*series)}
+		if metricType == histogramType {
+			m.buckets = DurationBuckets
+		}
+		r.metrics[name] = m
+	}
+	return m
+}
+
+// NewCounter declares a counter and its description
+func (r *Registry) NewCounter(name string, help string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.getMetric(name, counterType).help = help
+}
+
+// NewHistogram declares a histogram, its description and the upper bounds of its buckets, DurationBuckets when
+// empty
+func (r *Registry) NewHistogram(name string, help string, buckets []float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m := r.getMetric(name, histogramType)
+	m.help = help
+	if len(buckets) > 0 {
+		m.buckets = append([]float64{}, buckets...)
+		sort.Float64s(m.buckets)
+	}
+}
+
+// labelsKey returns the string identifying a set of labels, which is also their Prometheus representation
+func labelsKey(labels Labels) string {
+	var names []string
+	for name := range labels {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		pairs = append(pairs, name+"=\""+escapeLabelValue(labels[name])+"\"")
+	}
+	return strings.Join(pairs, ",")
+}
+
+// escapeLabelValue escapes the characters that cannot appear as such in label values
+func escapeLabelValue(value string) string {
+	value = strings.ReplaceAll(value, "\\", "\\\\")
+	value = strings.ReplaceAll(value, "\"", "\\\"")
+	return strings.ReplaceAll(value, "\n", "\\n")
+}
+
+// getSeries returns the series of a metric for a set of labels, creating it when it does not exist yet. The
+// caller must hold the mutex.
+func (m *metric) getSeries(labels Labels) *series {
+	key := labelsKey(labels)
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: make(Labels)}
+		for name, value := range labels {
+			s.labels[name] = value
+		}
+		if m.metricType == histogramType {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Add adds a value, which must be positive, to a counter
+func (r *Registry) Add(name string, labels Labels, value float64) {
+	if value < 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m := r.getMetric(name, counterType)
+	if m.metricType != counterType {
+		return
+	}
+	m.getSeries(labels).value += value
+}
+
+// Inc increments a counter
+func (r *Registry) Inc(name string, labels Labels) {
+	r.Add(name, labels, 1)
+}
+
+// Observe adds an observation to a histogram
+func (r *Registry) Observe(name string, labels Labels, value float64) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m := r.getMetric(name, histogramType)
+	if m.metricType != histogramType {
+		return
+	}
+	s := m.getSeries(labels)
+	for idx, bound := range m.buckets {
+		if value <= bound {
+			s.counts[idx]++
+			break
+		}
+	}
+	s.sum += value
+	s.count++
+}
+
+// ObserveSince adds the time elapsed since start, in seconds, to a histogram
+func (r *Registry) ObserveSince(name string, labels Labels, start time.Time) {
+	r.Observe(name, labels, time.Since(start).Seconds())
+}
+
+// Value returns the value of a counter or the number of observations of a histogram, 0 when the series does not
+// exist
+func (r *Registry) Value(name string, labels Labels) float64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	m, ok := r.metrics[name]
+	if !ok {
+		return 0
+	}
+	s, ok := m.series[labelsKey(labels)]
+	if !ok {
+		return 0
+	}
+	if m.metricType == histogramType {
+		return float64(s.count)
+	}
+	return s.value
+}
+
+// formatValue formats a value as expected by Prometheus
+func formatValue(value float64) string {
+	if math.IsInf(value, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// formatSeries formats the name and labels of a series, with an additional label (optional)
+func formatSeries(name string, labels Labels, extraName string, extraValue string) string {
+	key := labelsKey(labels)
+	if extraName != "" {
+		if key != "" {
+			key += ","
+		}
+		key += extraName + "=\"" + escapeLabelValue(extraValue) + "\""
+	}
+	if key == "" {
+		return name
+	}
+	return name + "{" + key + "}"
+}
+
+// WritePrometheus writes all the metrics of the registry in the Prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var names []string
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, name := range names {
+		m := r.metrics[name]
+		if m.help != "" {
+			sb.WriteString(fmt.Sprintf("# HELP %s %s\n", name, m.help))
+		}
+		sb.WriteString(fmt.Sprintf("# TYPE %s %s\n", name, m.metricType))
+
+		var keys []string
+		for key := range m.series {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			s := m.series[key]
+			if m.metricType == counterType {
+				sb.WriteString(fmt.Sprintf("%s %s\n", formatSeries(name, s.labels, "", ""), formatValue(s.value)))
+				continue
+			}
+			var cumulative uint64
+			for idx, bound := range m.buckets {
+				cumulative += s.counts[idx]
+				sb.WriteString(fmt.Sprintf("%s %d\n", formatSeries(name+"_bucket", s.labels, "le", formatValue(bound)), cumulative))
+			}
+			sb.WriteString(fmt.Sprintf("%s %d\n", formatSeries(name+"_bucket", s.labels, "le", "+Inf"), s.count))
+			sb.WriteString(fmt.Sprintf("%s %s\n", formatSeries(name+"_sum", s.labels, "", ""), formatValue(s.sum)))
+			sb.WriteString(fmt.Sprintf("%s %d\n", formatSeries(name+"_count", s.labels, "", ""), s.count))
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// WriteFile writes all the metrics of the registry in the Prometheus text format into a file, e.g., for the
+// textfile collector of the node exporter. The file is written through a temporary file so that it is never read
+// partially.
+func (r *Registry) WriteFile(path string) error {
+	var sb strings.Builder
+	err := r.WritePrometheus(&sb)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("unable to create a temporary file for %s: %w", path, err)
+	}
+	tmpPath := tmpFile.Name()
+	_, err = tmpFile.WriteString(sb.String())
+	closeErr := tmpFile.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("unable to write %s: %w", tmpPath, err)
+	}
+	err = os.Chmod(tmpPath, 0644)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("unable to set the permissions of %s: %w", tmpPath, err)
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("unable to rename %s: %w", tmpPath, err)
+	}
+	return nil
+}
+
+// Handler returns an HTTP handler serving all the metrics of the registry in the Prometheus text format, to be
+// scraped by Prometheus, e.g., http.Handle("/metrics", metrics.Default.Handler())
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		err := r.WritePrometheus(w)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+	})
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test counter.")
+	r.NewHistogram("test_seconds", "Test histogram.", []float64{10, 1})
+	r.Inc("test_total", Labels{"status": "success"})
+	r.Add("test_total", Labels{"status": "success"}, 2)
+	r.Inc("test_total", Labels{"status": "fail\"ure"})
+	r.Add("test_total", Labels{"status": "success"}, -1)
+	r.Observe("test_seconds", Labels{"stage": "configure"}, 0.5)
+	r.Observe("test_seconds", Labels{"stage": "configure"}, 5)
+	r.Observe("test_seconds", Labels{"stage": "configure"}, 50)
+	r.Inc("undeclared_total", nil)
+
+	if r.Value("test_total", Labels{"status": "success"}) != 3 {
+		t.Fatalf("invalid counter value: %f", r.Value("test_total", Labels{"status": "success"}))
+	}
+	if r.Value("test_seconds", Labels{"stage": "configure"}) != 3 {
+		t.Fatalf("invalid histogram count: %f", r.Value("test_seconds", Labels{"stage": "configure"}))
+	}
+	if r.Value("test_total", Labels{"status": "unknown"}) != 0 {
+		t.Fatalf("unknown series has a value")
+	}
+
+	var sb strings.Builder
+	err := r.WritePrometheus(&sb)
+	if err != nil {
+		t.Fatalf("WritePrometheus() failed: %s", err)
+	}
+	expected := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{stage="configure",le="1"} 1
+test_seconds_bucket{stage="configure",le="10"} 2
+test_seconds_bucket{stage="configure",le="+Inf"} 3
+test_seconds_sum{stage="configure"} 55.5
+test_seconds_count{stage="configure"} 3
+# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{status="fail\"ure"} 1
+test_total{status="success"} 3
+# TYPE undeclared_total counter
+undeclared_total 1
+`
+	if sb.String() != expected {
+		t.Fatalf("invalid exposition:\n%s\nexpected:\n%s", sb.String(), expected)
+	}
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if rec.Body.String() != expected {
+		t.Fatalf("invalid content served:\n%s", rec.Body.String())
+	}
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain") {
+		t.Fatalf("invalid content type: %s", rec.Header().Get("Content-Type"))
+	}
+
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tempDir)
+	metricsFile := filepath.Join(tempDir, "build.prom")
+	err = r.WriteFile(metricsFile)
+	if err != nil {
+		t.Fatalf("WriteFile() failed: %s", err)
+	}
+	content, err := ioutil.ReadFile(metricsFile)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", metricsFile, err)
+	}
+	if string(content) != expected {
+		t.Fatalf("invalid content of %s:\n%s", metricsFile, string(content))
+	}
+}
+
+type testSpan struct {
+	name       string
+	attributes map[string]string
+	err        error
+	ended      bool
+}
+
+func (s *testSpan) SetAttribute(key string, value string) { s.attributes[key] = value }
+func (s *testSpan) RecordError(err error)                 { s.err = err }
+func (s *testSpan) End()                                  { s.ended = true }
+
+type testTracer struct {
+	spans []*testSpan
+}
+
+func (tr *testTracer) Start(ctx context.Context, name string) (context.Context, Span) {
+	s := &testSpan{name: name, attributes: make(map[string]string)}
+	tr.spans = append(tr.spans, s)
+	return ctx, s
+}
+
+func TestStartSpan(t *testing.T) {
+	// No span is recorded without tracer
+	ctx, span := StartSpan(nil, "noop")
+	if ctx == nil {
+		t.Fatalf("StartSpan() returned a nil context")
+	}
+	span.SetAttribute("key", "value")
+	span.End()
+
+	tracer := new(testTracer)
+	SetTracer(tracer)
+	defer SetTracer(nil)
+	_, span = StartSpan(context.Background(), "test")
+	span.SetAttribute("key", "value")
+	span.RecordError(errors.New("failure"))
+	span.End()
+	if len(tracer.spans) != 1 {
+		t.Fatalf("%d spans recorded instead of 1", len(tracer.spans))
+	}
+	s := tracer.spans[0]
+	if s.name != "test" || s.attributes["key"] != "value" || s.err == nil || !s.ended {
+		t.Fatalf("invalid span: %+v", s)
+	}
+}
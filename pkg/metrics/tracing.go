@@ -0,0 +1,65 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package metrics
+
+import (
+	"context"
+	"sync"
+)
+
+// Span is an operation being traced, e.g., the installation of a component. Its methods are a subset of the
+// ones of OpenTelemetry spans so that OpenTelemetry can be plugged in with a thin adapter (see SetTracer).
+type Span interface {
+	// SetAttribute sets an attribute of the span, e.g., the name of the component being installed
+	SetAttribute(key string, value string)
+
+	// RecordError records that the operation failed
+	RecordError(err error)
+
+	// End completes the span
+	End()
+}
+
+// Tracer creates spans, the span being a child of the span of ctx, if any, and the returned context holding the
+// new span
+type Tracer interface {
+	Start(ctx context.Context, name string) (context.Context, Span)
+}
+
+var (
+	tracerMutex sync.RWMutex
+	tracer      Tracer
+)
+
+// noopSpan is the span used when no tracer is set
+type noopSpan struct{}
+
+func (noopSpan) SetAttribute(key string, value string) {}
+func (noopSpan) RecordError(err error)                 {}
+func (noopSpan) End()                                  {}
+
+// SetTracer sets the tracer the builds and the installations of stacks are traced with, tracing being disabled
+// when nil, the default
+func SetTracer(t Tracer) {
+	tracerMutex.Lock()
+	defer tracerMutex.Unlock()
+	tracer = t
+}
+
+// StartSpan starts a span with the tracer (see SetTracer), a span doing nothing being returned when tracing is
+// disabled. ctx may be nil.
+func StartSpan(ctx context.Context, name string) (context.Context, Span) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	tracerMutex.RLock()
+	t := tracer
+	tracerMutex.RUnlock()
+	if t == nil {
+		return ctx, noopSpan{}
+	}
+	return t.Start(ctx, name)
+}
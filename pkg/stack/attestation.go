@@ -0,0 +1,367 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+)
+
+const (
+	// AttestationFilename is the name of the signed attestation, in the installation directory of each component,
+	// stating how the files of the component were produced (see StackCfg.AttestationKey)
+	AttestationFilename = "attestation.intoto.json"
+
+	// AttestationSuffix is the suffix appended to the name of the tarball created by Export() to get the name of
+	// its attestation
+	AttestationSuffix = ".intoto.json"
+
+	// InTotoPayloadType is the type of the payload of the DSSE envelopes of the attestations
+	InTotoPayloadType = "application/vnd.in-toto+json"
+
+	// InTotoStatementType is the type of the in-toto statements of the attestations
+	InTotoStatementType = "https://in-toto.io/Statement/v0.1"
+
+	// SLSAProvenanceType is the type of the predicate of the attestations
+	SLSAProvenanceType = "https://slsa.dev/provenance/v0.2"
+
+	// BuilderID identifies the builder in the attestations
+	BuilderID = "https://github.com/gvallee/go_software_build"
+
+	// buildTypeComponent and buildTypeExport are the types of build described by the attestations
+	buildTypeComponent = BuilderID + "/component@v1"
+	buildTypeExport    = BuilderID + "/export@v1"
+)
+
+// InTotoSubject is an artifact an attestation is about
+type InTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// SLSABuilder identifies the builder of the subjects of an attestation
+type SLSABuilder struct {
+	ID string `json:"id"`
+}
+
+// SLSAInvocation describes how the build was invoked
+type SLSAInvocation struct {
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Environment map[string]interface{} `json:"environment,omitempty"`
+}
+
+// SLSAMetadata is the metadata of a build
+type SLSAMetadata struct {
+	BuildStartedOn  *time.Time `json:"buildStartedOn,omitempty"`
+	BuildFinishedOn *time.Time `json:"buildFinishedOn,omitempty"`
+}
+
+// SLSAMaterial is an input of a build, e.g., the source code of a component
+type SLSAMaterial struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+// SLSAProvenance is the SLSA provenance predicate of an attestation
+type SLSAProvenance struct {
+	Builder    SLSABuilder    `json:"builder"`
+	BuildType  string         `json:"buildType"`
+	Invocation SLSAInvocation `json:"invocation"`
+	Metadata   SLSAMetadata   `json:"metadata"`
+	Materials  []SLSAMaterial `json:"materials,omitempty"`
+}
+
+// InTotoStatement is the in-toto statement of an attestation
+type InTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []InTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     SLSAProvenance  `json:"predicate"`
+}
+
+// DSSESignature is a signature of a DSSE envelope
+type DSSESignature struct {
+	KeyID string `json:"keyid"`
+	Sig   string `json:"sig"`
+}
+
+// DSSEEnvelope is a signed attestation, the payload being an encoded InTotoStatement
+type DSSEEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     string          `json:"payload"`
+	Signatures  []DSSESignature `json:"signatures"`
+}
+
+// dssePAE returns the pre-authentication encoding of a DSSE payload, i.e., what is actually signed
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// getKeyID returns the identifier of a public key, i.e., its SHA256 checksum
+func getKeyID(publicKey ed25519.PublicKey) string {
+	sum := sha256.Sum256(publicKey)
+	return hex.EncodeToString(sum[:])
+}
+
+// loadAttestationKey loads the Ed25519 private key used to sign the attestations (see StackCfg.AttestationKey).
+// A nil key is returned when attestations are not enabled.
+func (c *Config) loadAttestationKey() (ed25519.PrivateKey, error) {
+	keyPath := c.Data.StackConfig.AttestationKey
+	if keyPath == "" {
+		return nil, nil
+	}
+	if !filepath.IsAbs(keyPath) && c.ConfigFilePath != "" {
+		keyPath = filepath.Join(filepath.Dir(c.ConfigFilePath), keyPath)
+	}
+	content, err := ioutil.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", keyPath, err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the private key from %s: %w", keyPath, err)
+	}
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 private key", keyPath)
+	}
+	return privateKey, nil
+}
+
+// writeAttestation signs a statement and writes the resulting DSSE envelope in a file
+func writeAttestation(attestationPath string, key ed25519.PrivateKey, statement *InTotoStatement) error {
+	payload, err := json.Marshal(statement)
+	if err != nil {
+		return fmt.Errorf("unable to encode the attestation: %w", err)
+	}
+	envelope := DSSEEnvelope{
+		PayloadType: InTotoPayloadType,
+		Payload:     base64.StdEncoding.EncodeToString(payload),
+		Signatures: []DSSESignature{
+			{
+				KeyID: getKeyID(key.Public().(ed25519.PublicKey)),
+				Sig:   base64.StdEncoding.EncodeToString(ed25519.Sign(key, dssePAE(InTotoPayloadType, payload))),
+			},
+		},
+	}
+	content, err := json.MarshalIndent(envelope, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to encode the attestation: %w", err)
+	}
+	err = ioutil.WriteFile(attestationPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", attestationPath, err)
+	}
+	return nil
+}
+
+// getSubjects returns the regular files of a directory, with their checksum, except the attestation itself
+func getSubjects(dir string) ([]InTotoSubject, error) {
+	var subjects []InTotoSubject
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || path == filepath.Join(dir, AttestationFilename) {
+			return nil
+		}
+		checksum, err := buildenv.FileChecksum(path)
+		if err != nil {
+			return err
+		}
+		name, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		subjects = append(subjects, InTotoSubject{Name: name, Digest: map[string]string{"sha256": checksum}})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to get the files of %s: %w", dir, err)
+	}
+	return subjects, nil
+}
+
+// getMaterial returns the source code of a component as a material of a build
+func getMaterial(url string, checksum string, revision string) SLSAMaterial {
+	material := SLSAMaterial{URI: url}
+	if checksum != "" || revision != "" {
+		material.Digest = make(map[string]string)
+	}
+	if checksum != "" {
+		material.Digest["sha256"] = checksum
+	}
+	if revision != "" {
+		material.Digest["gitCommit"] = revision
+	}
+	return material
+}
+
+// writeComponentAttestation generates the signed attestation of a component that was just installed in
+// compInstallDir, based on its provenance
+func writeComponentAttestation(compInstallDir string, key ed25519.PrivateKey, p *Provenance) error {
+	subjects, err := getSubjects(compInstallDir)
+	if err != nil {
+		return err
+	}
+	statement := &InTotoStatement{
+		Type:          InTotoStatementType,
+		Subject:       subjects,
+		PredicateType: SLSAProvenanceType,
+		Predicate: SLSAProvenance{
+			Builder:   SLSABuilder{ID: BuilderID},
+			BuildType: buildTypeComponent,
+			Invocation: SLSAInvocation{
+				Parameters: map[string]interface{}{
+					"name":              p.Name,
+					"version":           p.Version,
+					"build_system":      p.BuildSystem,
+					"optimization":      p.OptimizationProfile,
+					"configure_prelude": p.ConfigurePrelude,
+					"configure_args":    p.ConfigureArgs,
+				},
+				Environment: map[string]interface{}{
+					"host":      p.Host,
+					"toolchain": p.Toolchain,
+					"build_env": p.BuildEnv,
+				},
+			},
+			Metadata: SLSAMetadata{
+				BuildStartedOn:  &p.Start,
+				BuildFinishedOn: &p.End,
+			},
+			Materials: []SLSAMaterial{getMaterial(p.URL, p.SHA256, p.Revision)},
+		},
+	}
+	return writeAttestation(filepath.Join(compInstallDir, AttestationFilename), key, statement)
+}
+
+// writeExportAttestation generates the signed attestation of a tarball created by Export(), the materials
+// being the source code of the components of the stack
+func (c *Config) writeExportAttestation(tarballPath string, key ed25519.PrivateKey) error {
+	checksum, err := buildenv.FileChecksum(tarballPath)
+	if err != nil {
+		return fmt.Errorf("unable to get the checksum of %s: %w", tarballPath, err)
+	}
+	now := time.Now()
+	statement := &InTotoStatement{
+		Type:          InTotoStatementType,
+		Subject:       []InTotoSubject{{Name: filepath.Base(tarballPath), Digest: map[string]string{"sha256": checksum}}},
+		PredicateType: SLSAProvenanceType,
+		Predicate: SLSAProvenance{
+			Builder:   SLSABuilder{ID: BuilderID},
+			BuildType: buildTypeExport,
+			Invocation: SLSAInvocation{
+				Parameters: map[string]interface{}{"stack": c.Data.StackDefinition.Name},
+			},
+			Metadata: SLSAMetadata{BuildFinishedOn: &now},
+		},
+	}
+	for _, comp := range c.Data.StackDefinition.Components {
+		if comp.Disabled {
+			continue
+		}
+		url := comp.URL
+		var checksum, revision string
+		if c.state != nil {
+			c.state.mutex.Lock()
+			if compState, ok := c.state.Components[comp.Name]; ok {
+				url = compState.URL
+				checksum = compState.SHA256
+				revision = compState.Revision
+			}
+			c.state.mutex.Unlock()
+		}
+		if url != "" {
+			statement.Predicate.Materials = append(statement.Predicate.Materials, getMaterial(url, checksum, revision))
+		}
+	}
+	return writeAttestation(tarballPath+AttestationSuffix, key, statement)
+}
+
+// VerifyAttestation checks the signature of an attestation with the Ed25519 public key of the builder, in the
+// PEM format, and checks that the files of dir, i.e., the installation directory of a component or the directory
+// of an exported tarball, match the subjects of the attestation. The statement of the attestation is returned.
+func VerifyAttestation(attestationPath string, publicKeyPath string, dir string) (*InTotoStatement, error) {
+	content, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", publicKeyPath, err)
+	}
+	block, _ := pem.Decode(content)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", publicKeyPath)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse the public key from %s: %w", publicKeyPath, err)
+	}
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an Ed25519 public key", publicKeyPath)
+	}
+
+	content, err = ioutil.ReadFile(attestationPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", attestationPath, err)
+	}
+	var envelope DSSEEnvelope
+	err = json.Unmarshal(content, &envelope)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", attestationPath, err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("invalid payload in %s: %w", attestationPath, err)
+	}
+	verified := false
+	for _, signature := range envelope.Signatures {
+		sig, err := base64.StdEncoding.DecodeString(signature.Sig)
+		if err != nil {
+			continue
+		}
+		if ed25519.Verify(publicKey, dssePAE(envelope.PayloadType, payload), sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, fmt.Errorf("%s is not signed with the key from %s", attestationPath, publicKeyPath)
+	}
+
+	statement := new(InTotoStatement)
+	err = json.Unmarshal(payload, statement)
+	if err != nil {
+		return nil, fmt.Errorf("invalid statement in %s: %w", attestationPath, err)
+	}
+	for _, subject := range statement.Subject {
+		subjectPath := filepath.Join(dir, subject.Name)
+		checksum, err := buildenv.FileChecksum(subjectPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the checksum of %s: %w", subjectPath, err)
+		}
+		if checksum != subject.Digest["sha256"] {
+			return nil, fmt.Errorf("%s does not match its attestation", subjectPath)
+		}
+	}
+	return statement, nil
+}
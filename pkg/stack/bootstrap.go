@@ -0,0 +1,150 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// defaultToolchainCompilers are the compilers looked for in the bin directory of a toolchain component when its
+// compilers are not specified, for each toolchain variable and in order of preference (see Component.Compilers)
+var defaultToolchainCompilers = map[string][]string{
+	"CC":  {"gcc", "clang", "cc"},
+	"CXX": {"g++", "clang++", "c++"},
+	"FC":  {"gfortran", "flang"},
+}
+
+// getBootstrapComponents returns the components installed in the first phase of the installation of the stack,
+// i.e., the toolchain components (see Component.Toolchain) and the components they depend on
+func (c *Config) getBootstrapComponents() map[string]bool {
+	bootstrap := make(map[string]bool)
+	var toBootstrap []string
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if comp.Toolchain && !comp.Disabled {
+			toBootstrap = append(toBootstrap, comp.Name)
+		}
+	}
+	for len(toBootstrap) > 0 {
+		name := toBootstrap[0]
+		toBootstrap = toBootstrap[1:]
+		if bootstrap[name] {
+			continue
+		}
+		bootstrap[name] = true
+		if comp := c.getComponent(name); comp != nil {
+			toBootstrap = append(toBootstrap, getDependencies(comp)...)
+		}
+	}
+	return bootstrap
+}
+
+// getInstallOrder returns the index of the components of the stack in the order they are installed, i.e., the
+// components of the first phase (see getBootstrapComponents) then the other ones, in the order of the definition
+func (c *Config) getInstallOrder() []int {
+	bootstrap := c.getBootstrapComponents()
+	var firstPhase, secondPhase []int
+	for idx, comp := range c.Data.StackDefinition.Components {
+		if bootstrap[comp.Name] {
+			firstPhase = append(firstPhase, idx)
+		} else {
+			secondPhase = append(secondPhase, idx)
+		}
+	}
+	return append(firstPhase, secondPhase...)
+}
+
+// getToolchainCompilers returns the compilers a toolchain component installed in installDir provides, the key
+// being the toolchain variable, e.g., CC, and the value the absolute path to the compiler
+func getToolchainCompilers(comp *Component, installDir string) map[string]string {
+	binDir := filepath.Join(installDir, "bin")
+	compilers := make(map[string]string)
+	if len(comp.Compilers) > 0 {
+		for name, compiler := range comp.Compilers {
+			if !filepath.IsAbs(compiler) {
+				compiler = filepath.Join(binDir, compiler)
+			}
+			compilers[name] = compiler
+		}
+		return compilers
+	}
+	for name, candidates := range defaultToolchainCompilers {
+		for _, candidate := range candidates {
+			compilerPath := filepath.Join(binDir, candidate)
+			if util.FileExists(compilerPath) {
+				compilers[name] = compilerPath
+				break
+			}
+		}
+	}
+	return compilers
+}
+
+// setBootstrapToolchainEnv injects the toolchain components installed in the first phase of the installation of
+// the stack into the build environment of a component of the second phase: their compilers are set, e.g., CC,
+// their bin directories come first in PATH and their libraries, e.g., libstdc++, are added to LD_LIBRARY_PATH
+// and to the run-time search path of the binaries. Variables set in the build environment of the component take
+// precedence.
+func (c *Config) setBootstrapToolchainEnv(env *buildenv.Info, comp *Component) {
+	bootstrap := c.getBootstrapComponents()
+	if len(bootstrap) == 0 || bootstrap[comp.Name] {
+		return
+	}
+	// Errors in the build environment of the component are reported when it is parsed
+	compEnv, _ := buildenv.ParseEnv(comp.BuildEnv)
+
+	for idx := range c.Data.StackDefinition.Components {
+		toolchain := &c.Data.StackDefinition.Components[idx]
+		if !toolchain.Toolchain || toolchain.Disabled {
+			continue
+		}
+		c.mutex.RLock()
+		installDir, installed := c.InstalledComponents[toolchain.Name]
+		c.mutex.RUnlock()
+		if !installed {
+			continue
+		}
+
+		log.Printf("-> Building %s with toolchain %s", comp.Name, toolchain.Name)
+		if len(env.Env) == 0 {
+			// Env is the entire environment when set
+			env.Env = os.Environ()
+		}
+		compilers := getToolchainCompilers(toolchain, installDir)
+		var names []string
+		for name := range compilers {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, ok := compEnv.Get(name); !ok {
+				env.Env.Set(name, compilers[name])
+			}
+		}
+
+		binDir := filepath.Join(installDir, "bin")
+		if util.IsDir(binDir) {
+			env.Env.Prepend("PATH", binDir, ":")
+		}
+		for _, libDirname := range []string{"lib", "lib64"} {
+			libDir := filepath.Join(installDir, libDirname)
+			if !util.IsDir(libDir) {
+				continue
+			}
+			env.Env.Prepend("LD_LIBRARY_PATH", libDir, ":")
+			if _, ok := compEnv.Get("LDFLAGS"); !ok {
+				env.Env.Prepend("LDFLAGS", "-Wl,-rpath,"+libDir, " ")
+			}
+		}
+	}
+}
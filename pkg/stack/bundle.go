@@ -0,0 +1,396 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gvallee/go_software_build/pkg/app"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// BundleManifestFilename is the name of the manifest of a bundle, listing the source code of all the components
+	BundleManifestFilename = "bundle.json"
+
+	// BundleDefinitionFilename is the name of the copy of the definition of the stack in a bundle
+	BundleDefinitionFilename = "stack_def.json"
+
+	// BundleChecksumsFilename is the name of the file with the SHA256 checksum of all the files of a bundle,
+	// in the format of sha256sum
+	BundleChecksumsFilename = "SHA256SUMS"
+
+	bundleSourcesDir = "sources"
+	bundleSuffix     = ".tar.bz2"
+)
+
+// bundle is a bundle created by CreateBundle() that the source code of the components is taken from
+type bundle struct {
+	// dir is the directory where the bundle is extracted
+	dir string
+
+	// sources is the source code of the components in the bundle, the key being the name of the component
+	sources map[string]SourceEntry
+}
+
+// getURL returns the URL of the copy of the source code of a component in the bundle, an empty string if
+// the bundle does not have it
+func (b *bundle) getURL(name string) string {
+	entry, ok := b.sources[name]
+	if !ok {
+		return ""
+	}
+	path := filepath.Join(b.dir, bundleSourcesDir, entry.Path)
+	if entry.Type == app.SourceTypeGit {
+		// Git bundles are cloned from their path
+		return path
+	}
+	return "file://" + path
+}
+
+// getRewrites returns the rules rewriting the URL of the components to the copy of their source code in the bundle
+func (b *bundle) getRewrites() map[string]string {
+	rewrites := make(map[string]string)
+	for name, entry := range b.sources {
+		rewrites[entry.URL] = b.getURL(name)
+	}
+	return rewrites
+}
+
+// getBundleSource returns the source code of a component in the bundle the stack uses, if any
+func (c *Config) getBundleSource(name string) (SourceEntry, bool) {
+	if c.bundle == nil {
+		return SourceEntry{}, false
+	}
+	entry, ok := c.bundle.sources[name]
+	return entry, ok
+}
+
+// createGitBundle replaces a Git checkout by a Git bundle with all its branches and tags, which is a single
+// file that can be cloned like a repository, and returns the path to the bundle
+func createGitBundle(checkoutDir string) (string, error) {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("failed to find git: %w", err)
+	}
+	bundlePath := checkoutDir + ".git"
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(gitBin, "bundle", "create", bundlePath, "HEAD", "--all")
+	cmd.Dir = checkoutDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("unable to create a Git bundle from %s: %w - stdout: %s - stderr: %s", checkoutDir, err, stdout.String(), stderr.String())
+	}
+	err = os.RemoveAll(checkoutDir)
+	if err != nil {
+		return "", fmt.Errorf("unable to remove %s: %w", checkoutDir, err)
+	}
+	return bundlePath, nil
+}
+
+// writeChecksums writes in a file of dir, in the format of sha256sum, the checksum of all the files of paths,
+// relative to dir
+func writeChecksums(dir string, paths []string, checksumsFilename string) error {
+	var lines []string
+	for _, p := range paths {
+		err := filepath.Walk(filepath.Join(dir, p), func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if !info.Mode().IsRegular() {
+				return nil
+			}
+			relPath, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			checksum, err := buildenv.FileChecksum(path)
+			if err != nil {
+				return err
+			}
+			lines = append(lines, checksum+"  "+relPath)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("unable to compute the checksums of %s: %w", filepath.Join(dir, p), err)
+		}
+	}
+	sort.Strings(lines)
+	checksumsPath := filepath.Join(dir, checksumsFilename)
+	err := ioutil.WriteFile(checksumsPath, []byte(strings.Join(lines, "\n")+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", checksumsPath, err)
+	}
+	return nil
+}
+
+// checkChecksums checks that the files of dir match their checksum in a file written by writeChecksums() and
+// returns all the problems found, e.g., missing files or checksum mismatches
+func checkChecksums(dir string, checksumsFilename string) ([]string, error) {
+	checksumsPath := filepath.Join(dir, checksumsFilename)
+	f, err := os.Open(checksumsPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open %s: %w", checksumsPath, err)
+	}
+	defer f.Close()
+
+	var problems []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		tokens := strings.SplitN(scanner.Text(), "  ", 2)
+		if len(tokens) != 2 {
+			continue
+		}
+		path := filepath.Join(dir, tokens[1])
+		checksum, err := buildenv.FileChecksum(path)
+		if err != nil {
+			problems = append(problems, fmt.Sprintf("%s is missing", tokens[1]))
+			continue
+		}
+		if checksum != tokens[0] {
+			problems = append(problems, fmt.Sprintf("checksum mismatch for %s", tokens[1]))
+		}
+	}
+	err = scanner.Err()
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", checksumsPath, err)
+	}
+	return problems, nil
+}
+
+// writeBundleChecksums writes the checksum of all the files of a bundle (see BundleChecksumsFilename)
+func writeBundleChecksums(dir string) error {
+	return writeChecksums(dir, []string{"."}, BundleChecksumsFilename)
+}
+
+// checkBundleChecksums checks that the files of a bundle match their checksum (see BundleChecksumsFilename).
+// All the problems are reported at once.
+func checkBundleChecksums(dir string) error {
+	problems, err := checkChecksums(dir, BundleChecksumsFilename)
+	if err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid bundle %s: %s", dir, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// CreateBundle creates a bundle with everything required to install the stack without any network access,
+// e.g., on an air-gapped cluster: the source code of all the enabled components, Git repositories being
+// stored as Git bundles, the definition of the stack, its lockfile (see StateFilename) and the checksum
+// of all the files. The bundle is a bzip2-compressed tarball, e.g., /path/to/mystack-bundle.tar.bz2,
+// to install with UseBundle().
+func (c *Config) CreateBundle(bundlePath string) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("unable to load configuration: %w", err)
+		}
+	}
+	if !strings.HasSuffix(bundlePath, bundleSuffix) {
+		return fmt.Errorf("the name of the bundle must end with %s: %s", bundleSuffix, bundlePath)
+	}
+	bundlePath, err := filepath.Abs(bundlePath)
+	if err != nil {
+		return fmt.Errorf("unable to get the absolute path of %s: %w", bundlePath, err)
+	}
+	err = c.loadStackState()
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+
+	workDir, err := ioutil.TempDir(filepath.Dir(bundlePath), ".bundle-")
+	if err != nil {
+		return fmt.Errorf("unable to create a temporary directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+	bundleName := strings.TrimSuffix(filepath.Base(bundlePath), bundleSuffix)
+	bundleDir := filepath.Join(workDir, bundleName)
+	sourcesDir := filepath.Join(bundleDir, bundleSourcesDir)
+	err = os.MkdirAll(sourcesDir, defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", sourcesDir, err)
+	}
+
+	var mutex sync.Mutex
+	var manifest SourceManifest
+	manifest.Stack = c.Data.StackDefinition.Name
+	err = c.forEachComponent(c.FetchJobs, func(comp *Component) error {
+		entry, err := c.downloadComponentSource(comp, sourcesDir)
+		if err != nil {
+			return err
+		}
+		// Local directories may be Git working trees whose changes are not committed
+		isGit := comp.SourceType == app.SourceTypeGit || util.DetectURLType(entry.URL) == util.GitURL
+		if isGit {
+			gitBundlePath, err := createGitBundle(filepath.Join(sourcesDir, entry.Path))
+			if err != nil {
+				return err
+			}
+			entry.Path = strings.TrimPrefix(gitBundlePath, sourcesDir+"/")
+			entry.Type = app.SourceTypeGit
+		}
+		if _, overridden := c.SourceOverrides[comp.Name]; !overridden {
+			c.state.recordSource(comp.Name, entry.URL, comp.Branch, comp.Version, entry.SHA256, entry.Revision)
+		}
+		mutex.Lock()
+		manifest.Sources = append(manifest.Sources, *entry)
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to get the source code of the stack: %w", err)
+	}
+	sort.Slice(manifest.Sources, func(i, j int) bool {
+		return manifest.Sources[i].Component < manifest.Sources[j].Component
+	})
+
+	content, err := json.MarshalIndent(&manifest, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to create the manifest of the bundle: %w", err)
+	}
+	manifestPath := filepath.Join(bundleDir, BundleManifestFilename)
+	err = ioutil.WriteFile(manifestPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", manifestPath, err)
+	}
+	if c.DefFilePath != "" {
+		// Remote definitions are in the cache once the stack is loaded
+		defFilePath, err := c.getLocalFile(c.DefFilePath, c.DefFileSHA256)
+		if err != nil {
+			return err
+		}
+		err = util.CopyFile(defFilePath, filepath.Join(bundleDir, BundleDefinitionFilename))
+		if err != nil {
+			return fmt.Errorf("unable to copy %s: %w", c.DefFilePath, err)
+		}
+	} else {
+		content, err := json.MarshalIndent(c.Data.StackDefinition, "", "\t")
+		if err != nil {
+			return fmt.Errorf("unable to encode the definition of the stack: %w", err)
+		}
+		err = ioutil.WriteFile(filepath.Join(bundleDir, BundleDefinitionFilename), content, 0644)
+		if err != nil {
+			return fmt.Errorf("unable to write the definition of the stack: %w", err)
+		}
+	}
+	// The lockfile makes sure the versions and source code used offline are the ones of the bundle
+	err = c.state.write(filepath.Join(bundleDir, StateFilename))
+	if err != nil {
+		return err
+	}
+	err = writeBundleChecksums(bundleDir)
+	if err != nil {
+		return err
+	}
+
+	tarBin, err := exec.LookPath("tar")
+	if err != nil {
+		return fmt.Errorf("tar is not available: %w", err)
+	}
+	tarCmd := buildenv.GetTarCreateCmd(tarBin, workDir, bundlePath, []string{bundleName})
+	var stderr, stdout bytes.Buffer
+	tarCmd.Stderr = &stderr
+	tarCmd.Stdout = &stdout
+	err = tarCmd.Run()
+	if err != nil {
+		return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+	}
+
+	fmt.Printf("Bundle successfully created: %s\n", bundlePath)
+	return nil
+}
+
+// UseBundle makes the stack use the source code and lockfile of a bundle created by CreateBundle() so that it
+// can be installed without any network access. bundlePath is either the bundle or the directory where it was
+// extracted; a bundle is extracted next to it, e.g., in /path/to/mystack-bundle for /path/to/mystack-bundle.tar.bz2.
+// The checksums of the files of the bundle are checked. When the path to the definition of the stack is not
+// set, the definition of the bundle is used. UseBundle() must be called before the stack is loaded so that the
+// versions of the components are the ones of the lockfile.
+func (c *Config) UseBundle(bundlePath string) error {
+	bundleDir, err := filepath.Abs(bundlePath)
+	if err != nil {
+		return fmt.Errorf("unable to get the absolute path of %s: %w", bundlePath, err)
+	}
+	if !util.IsDir(bundleDir) {
+		if !strings.HasSuffix(bundleDir, bundleSuffix) {
+			return fmt.Errorf("%s is not a bundle", bundlePath)
+		}
+		tarBin, err := exec.LookPath("tar")
+		if err != nil {
+			return fmt.Errorf("tar is not available: %w", err)
+		}
+		log.Printf("-> Extracting %s", bundleDir)
+		tarCmd := exec.Command(tarBin, "-xjf", bundleDir)
+		tarCmd.Dir = filepath.Dir(bundleDir)
+		var stderr, stdout bytes.Buffer
+		tarCmd.Stderr = &stderr
+		tarCmd.Stdout = &stdout
+		err = tarCmd.Run()
+		if err != nil {
+			return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+		}
+		bundleDir = strings.TrimSuffix(bundleDir, bundleSuffix)
+	}
+
+	err = checkBundleChecksums(bundleDir)
+	if err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(bundleDir, BundleManifestFilename)
+	content, err := ioutil.ReadFile(manifestPath)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", manifestPath, err)
+	}
+	var manifest SourceManifest
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		return fmt.Errorf("unable to parse %s: %w", manifestPath, err)
+	}
+	lock, err := loadState(bundleDir)
+	if err != nil {
+		return fmt.Errorf("unable to load the lockfile of the bundle: %w", err)
+	}
+
+	b := &bundle{dir: bundleDir, sources: make(map[string]SourceEntry)}
+	for _, entry := range manifest.Sources {
+		b.sources[entry.Component] = entry
+	}
+	c.mutex.Lock()
+	c.bundle = b
+	c.state = lock
+	c.mutex.Unlock()
+
+	if c.DefFilePath == "" {
+		c.DefFilePath = filepath.Join(bundleDir, BundleDefinitionFilename)
+	}
+	if !c.Loaded {
+		err = c.Load()
+		if err != nil {
+			return fmt.Errorf("unable to load configuration: %w", err)
+		}
+	}
+	if c.Data.StackDefinition.Name != manifest.Stack {
+		return fmt.Errorf("%s is a bundle of stack %s, not %s", bundlePath, manifest.Stack, c.Data.StackDefinition.Name)
+	}
+	return nil
+}
@@ -0,0 +1,184 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// clonedContent is the files and directories of a stack, relative to its base directory, copied by CloneTo(). The
+// build and source directories of the components are not copied.
+var clonedContent = []string{"install", "modulefiles", "modulefiles_lua", debugDirname, StateFilename, SBOMFilename}
+
+// relocatedTextFileExts is the extensions of the installed text files referring to the installation directory of
+// their component that are relocated, i.e., pkg-config files and libtool archives
+var relocatedTextFileExts = []string{".pc", ".la"}
+
+// getELFRpath returns the RUNPATH, or else the RPATH, of an ELF binary or library and whether the file is one
+func getELFRpath(path string) (string, bool) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return "", false
+	}
+	for _, tag := range []elf.DynTag{elf.DT_RUNPATH, elf.DT_RPATH} {
+		values, err := f.DynString(tag)
+		if err == nil && len(values) > 0 {
+			return strings.Join(values, ":"), true
+		}
+	}
+	return "", true
+}
+
+// relocateRpath returns a RPATH with its directories in oldDir moved to newDir
+func relocateRpath(rpath string, oldDir string, newDir string) string {
+	dirs := strings.Split(rpath, ":")
+	for idx, dir := range dirs {
+		if relocated := relocatePath(dir, oldDir, newDir); relocated != "" {
+			dirs[idx] = relocated
+		}
+	}
+	return strings.Join(dirs, ":")
+}
+
+// relocateInstalledFiles updates the installed files of a stack that refer to its previous base directory, i.e.,
+// the RPATHs of the ELF binaries and libraries, using patchelf, and the text files such as pkg-config files (see
+// relocatedTextFileExts)
+func relocateInstalledFiles(stackBasedir string, oldStackBasedir string) error {
+	rpaths := make(map[string]string)
+	installDir := filepath.Join(stackBasedir, "install")
+	err := filepath.Walk(installDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+		for _, ext := range relocatedTextFileExts {
+			if strings.HasSuffix(path, ext) {
+				return relocateFileContent(path, oldStackBasedir, stackBasedir)
+			}
+		}
+		rpath, isELF := getELFRpath(path)
+		if !isELF || rpath == "" {
+			return nil
+		}
+		if newRpath := relocateRpath(rpath, oldStackBasedir, stackBasedir); newRpath != rpath {
+			rpaths[path] = newRpath
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to relocate the files of %s: %w", installDir, err)
+	}
+	if len(rpaths) == 0 {
+		return nil
+	}
+
+	patchelfBin, err := exec.LookPath("patchelf")
+	if err != nil {
+		log.Printf("[WARN] patchelf is not available, the RPATHs of the binaries and libraries of the stack still refer to %s", oldStackBasedir)
+		return nil
+	}
+	for path, rpath := range rpaths {
+		err = runBinutils(patchelfBin, "--set-rpath", rpath, path)
+		if err != nil {
+			return fmt.Errorf("unable to relocate %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// CloneTo copies the installed stack to another installation directory, e.g., to promote a stack from a staging
+// file system to production, and relocates the copy: the paths to the stack are updated in the modulefiles, the
+// pkg-config files, the RPATHs of the binaries and libraries and the state of the stack. Other paths embedded in
+// binaries are not updated. The build and source directories of the components are not copied.
+func (c *Config) CloneTo(newInstallDir string) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+
+	stackBasedir := c.getStackBasedir()
+	if !util.PathExists(filepath.Join(stackBasedir, "install")) {
+		return fmt.Errorf("%s is not installed", stackBasedir)
+	}
+	newInstallDir, err := filepath.Abs(newInstallDir)
+	if err != nil {
+		return fmt.Errorf("unable to get the absolute path of %s: %w", newInstallDir, err)
+	}
+	relStackBasedir, err := filepath.Rel(c.Data.StackConfig.InstallDir, stackBasedir)
+	if err != nil {
+		return fmt.Errorf("unable to get the path of %s in %s: %w", stackBasedir, c.Data.StackConfig.InstallDir, err)
+	}
+	newStackBasedir := filepath.Join(newInstallDir, relStackBasedir)
+	if util.PathExists(newStackBasedir) {
+		return fmt.Errorf("%s already exists", newStackBasedir)
+	}
+	unlock, err := c.lock("clone")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = os.MkdirAll(newStackBasedir, defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", newStackBasedir, err)
+	}
+	args := []string{"-a"}
+	for _, content := range clonedContent {
+		if util.PathExists(filepath.Join(stackBasedir, content)) {
+			args = append(args, filepath.Join(stackBasedir, content))
+		}
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("cp", append(args, newStackBasedir)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("unable to copy %s to %s: %w - stdout: %s - stderr: %s", stackBasedir, newStackBasedir, err, stdout.String(), stderr.String())
+	}
+
+	err = relocateModulefiles(newStackBasedir, stackBasedir)
+	if err != nil {
+		return err
+	}
+	err = relocateInstalledFiles(newStackBasedir, stackBasedir)
+	if err != nil {
+		return err
+	}
+	err = relocateMachO(newStackBasedir, stackBasedir)
+	if err != nil {
+		return err
+	}
+
+	s, err := loadState(newStackBasedir)
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the cloned stack: %w", err)
+	}
+	s.StackDir = stackBasedir
+	s.relocate(newStackBasedir)
+	err = s.save(newStackBasedir)
+	if err != nil {
+		return fmt.Errorf("unable to save the state of the cloned stack: %w", err)
+	}
+
+	fmt.Printf("Stack successfully cloned in %s\n", newStackBasedir)
+	return nil
+}
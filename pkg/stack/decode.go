@@ -0,0 +1,166 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"regexp"
+	"strings"
+)
+
+// unknownFieldRegexp matches the errors of encoding/json about unknown fields, the first group being the field
+var unknownFieldRegexp = regexp.MustCompile(`^json: unknown field "(.*)"$`)
+
+// maxFieldDistance is the maximum edit distance between an unknown field and a known one for the known field to
+// be suggested, e.g., configure_params for configure_parms
+const maxFieldDistance = 3
+
+// getPosition returns the line and the column, starting at 1, of an offset in a document
+func getPosition(content []byte, offset int64) (int, int) {
+	if offset > int64(len(content)) {
+		offset = int64(len(content))
+	}
+	before := content[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	column := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, column
+}
+
+// getFieldOffset returns the offset of the first occurrence of a field in a JSON document, -1 if not found
+func getFieldOffset(content []byte, field string) int64 {
+	fieldRegexp := regexp.MustCompile(regexp.QuoteMeta(fmt.Sprintf("%q", field)) + `\s*:`)
+	loc := fieldRegexp.FindIndex(content)
+	if loc == nil {
+		return -1
+	}
+	return int64(loc[0])
+}
+
+// getFieldNames adds the names of the JSON fields of a type, including the ones of the types it includes,
+// e.g., of the components of a stack definition, to fields
+func getFieldNames(t reflect.Type, fields map[string]bool, visited map[reflect.Type]bool) {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array, reflect.Map:
+		getFieldNames(t.Elem(), fields, visited)
+		return
+	case reflect.Struct:
+	default:
+		return
+	}
+	if visited[t] {
+		return
+	}
+	visited[t] = true
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" && !field.Anonymous {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" && !field.Anonymous {
+			name = field.Name
+		}
+		if name != "" {
+			fields[name] = true
+		}
+		getFieldNames(field.Type, fields, visited)
+	}
+}
+
+// getEditDistance returns the Levenshtein distance between two strings
+func getEditDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// suggestField returns the known field of a document closest to an unknown one, if any is close enough
+func suggestField(field string, v interface{}) string {
+	fields := make(map[string]bool)
+	getFieldNames(reflect.TypeOf(v), fields, make(map[reflect.Type]bool))
+	suggestion := ""
+	bestDistance := maxFieldDistance + 1
+	for name := range fields {
+		distance := getEditDistance(strings.ToLower(field), strings.ToLower(name))
+		if distance < bestDistance || (distance == bestDistance && name < suggestion) {
+			suggestion = name
+			bestDistance = distance
+		}
+	}
+	return suggestion
+}
+
+// decodeJSON decodes a JSON document, e.g., the definition of a stack, into v. Unlike json.Unmarshal(), fields
+// that are unknown, typically because of a typo, are rejected, and errors include where the problem is in the
+// document, e.g., unknown field "configure_parms" at line 12, column 7 (did you mean "configure_params"?).
+func decodeJSON(content []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err == nil {
+		if _, err := dec.Token(); err != io.EOF {
+			line, column := getPosition(content, dec.InputOffset())
+			return fmt.Errorf("unexpected content after the JSON document at line %d, column %d", line, column)
+		}
+		return nil
+	}
+
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		line, column := getPosition(content, syntaxErr.Offset)
+		return fmt.Errorf("syntax error at line %d, column %d: %w", line, column, err)
+	case errors.As(err, &typeErr):
+		line, column := getPosition(content, typeErr.Offset)
+		return fmt.Errorf("invalid value at line %d, column %d: %w", line, column, err)
+	case errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, io.EOF):
+		return fmt.Errorf("truncated JSON document: %w", err)
+	}
+	match := unknownFieldRegexp.FindStringSubmatch(err.Error())
+	if match == nil {
+		return err
+	}
+	field := match[1]
+	location := ""
+	if offset := getFieldOffset(content, field); offset != -1 {
+		line, column := getPosition(content, offset)
+		location = fmt.Sprintf(" at line %d, column %d", line, column)
+	}
+	if suggestion := suggestField(field, v); suggestion != "" {
+		return fmt.Errorf("unknown field %q%s (did you mean %q?)", field, location, suggestion)
+	}
+	return fmt.Errorf("unknown field %q%s", field, location)
+}
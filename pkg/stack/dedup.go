@@ -0,0 +1,144 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// DedupStats is the outcome of the deduplication of the installed files of a stack (see Dedup)
+type DedupStats struct {
+	// Files is the number of files replaced by a hard link to an identical file
+	Files int
+
+	// SavedBytes is the space saved by the deduplication
+	SavedBytes int64
+}
+
+// deduplicator hard links identical files, based on their content and mode
+type deduplicator struct {
+	// poolDir is the content-addressed pool the files are linked to, if any (see StackCfg.DedupPoolDir)
+	poolDir string
+
+	// files is the first file found for each content and mode when there is no pool
+	files map[string]string
+
+	stats DedupStats
+}
+
+// replaceWithLink replaces a file with a hard link to another one. The link is created next to the file and
+// renamed, so that the file is never missing.
+func replaceWithLink(path string, target string) error {
+	tmpPath := path + ".dedup"
+	os.Remove(tmpPath)
+	err := os.Link(target, tmpPath)
+	if err != nil {
+		return fmt.Errorf("unable to link %s to %s: %w", path, target, err)
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("unable to replace %s: %w", path, err)
+	}
+	return nil
+}
+
+// getTarget returns the file a file must be linked to, i.e., its copy in the pool or the first identical file found
+func (d *deduplicator) getTarget(path string, info os.FileInfo) (string, error) {
+	checksum, err := buildenv.FileChecksum(path)
+	if err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%s-%o", checksum, info.Mode().Perm())
+	if d.poolDir == "" {
+		target, ok := d.files[key]
+		if !ok {
+			d.files[key] = path
+		}
+		return target, nil
+	}
+
+	poolPath := filepath.Join(d.poolDir, checksum[:2], key)
+	if util.FileExists(poolPath) {
+		return poolPath, nil
+	}
+	err = os.MkdirAll(filepath.Dir(poolPath), defaultPermission)
+	if err != nil {
+		return "", fmt.Errorf("unable to create %s: %w", filepath.Dir(poolPath), err)
+	}
+	err = os.Link(path, poolPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to add %s to the pool: %w", path, err)
+	}
+	return "", nil
+}
+
+// dedupFile replaces a file with a hard link to an identical file, if any
+func (d *deduplicator) dedupFile(path string, info os.FileInfo) error {
+	target, err := d.getTarget(path, info)
+	if err != nil || target == "" {
+		return err
+	}
+	targetInfo, err := os.Stat(target)
+	if err != nil {
+		return fmt.Errorf("unable to stat %s: %w", target, err)
+	}
+	if os.SameFile(info, targetInfo) {
+		return nil
+	}
+	err = replaceWithLink(path, target)
+	if err != nil {
+		return err
+	}
+	d.stats.Files++
+	d.stats.SavedBytes += info.Size()
+	return nil
+}
+
+// Dedup replaces the identical installed files of the components of the stack, e.g., headers, documentation or
+// libraries shipped by several components, with hard links to a single copy, which significantly reduces the
+// footprint of stacks on shared file systems. When StackCfg.DedupPoolDir is set, files are linked to a
+// content-addressed pool, which deduplicates files across all the stacks using the same pool. Files are identical
+// when they have the same content and permissions; empty files and symbolic links are left untouched.
+// Since hard links share their content, installed files must not be modified in place after deduplication.
+func (c *Config) Dedup() (*DedupStats, error) {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return nil, fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+
+	installDir := filepath.Join(c.getStackBasedir(), "install")
+	if !util.PathExists(installDir) {
+		return nil, fmt.Errorf("%s does not exist", installDir)
+	}
+	d := &deduplicator{
+		poolDir: c.Data.StackConfig.DedupPoolDir,
+		files:   make(map[string]string),
+	}
+	err := filepath.Walk(installDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || info.Size() == 0 {
+			return nil
+		}
+		return d.dedupFile(path, info)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("unable to deduplicate the files in %s: %w", installDir, err)
+	}
+	log.Printf("-> %d files deduplicated in %s, %d bytes saved", d.stats.Files, installDir, d.stats.SavedBytes)
+	return &d.stats, nil
+}
@@ -0,0 +1,327 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// installLayoutDirs is the directories found at the top of the installation directory of a component, used to
+// tell them apart from the directories of the versions of a component, i.e., install/<name>/<version>
+var installLayoutDirs = map[string]bool{
+	"bin": true, "sbin": true, "lib": true, "lib64": true, "libexec": true, "include": true, "share": true,
+	"etc": true, "man": true, "doc": true,
+}
+
+// discoverInstallDir returns the installation directory of a component from install/<name> and the version of the
+// component when it is installed in install/<name>/<version>. When several versions are installed, the most
+// recent one is used.
+func discoverInstallDir(compInstallDir string) (string, string, error) {
+	if util.FileExists(filepath.Join(compInstallDir, ProvenanceFilename)) {
+		return compInstallDir, "", nil
+	}
+	entries, err := ioutil.ReadDir(compInstallDir)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to read content of %s: %w", compInstallDir, err)
+	}
+	var versions []os.FileInfo
+	for _, entry := range entries {
+		if !entry.IsDir() || installLayoutDirs[entry.Name()] {
+			return compInstallDir, "", nil
+		}
+		versions = append(versions, entry)
+	}
+	if len(versions) == 0 {
+		return compInstallDir, "", nil
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].ModTime().After(versions[j].ModTime())
+	})
+	if len(versions) > 1 {
+		log.Printf("[WARN] %d versions installed in %s, using the most recent one: %s", len(versions), compInstallDir, versions[0].Name())
+	}
+	return filepath.Join(compInstallDir, versions[0].Name()), versions[0].Name(), nil
+}
+
+// discoverModuleDeps returns the components the modulefile of a component loads, i.e., its dependencies
+func discoverModuleDeps(stackBasedir string, name string) []string {
+	var deps []string
+	modulefiles := []struct {
+		path   string
+		prefix string
+		suffix string
+	}{
+		{filepath.Join(stackBasedir, "modulefiles", name), "module load ", ""},
+		{filepath.Join(stackBasedir, "modulefiles_lua", name+".lua"), "load(\"", "\")"},
+	}
+	for _, m := range modulefiles {
+		content, err := ioutil.ReadFile(m.path)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if strings.HasPrefix(line, m.prefix) && strings.HasSuffix(line, m.suffix) {
+				deps = append(deps, strings.TrimSuffix(strings.TrimPrefix(line, m.prefix), m.suffix))
+			}
+		}
+		if len(deps) > 0 {
+			break
+		}
+	}
+	return deps
+}
+
+// discoverGitSource returns the URL and revision of the Git checkout in the build directory of a component, if any
+func discoverGitSource(stackBasedir string, name string) (string, string) {
+	buildDir, err := GetCompBuildDir(stackBasedir, name)
+	if err != nil || !util.PathExists(filepath.Join(buildDir, ".git")) {
+		return "", ""
+	}
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		return "", ""
+	}
+	var stdout bytes.Buffer
+	cmd := exec.Command(gitBin, "remote", "get-url", "origin")
+	cmd.Dir = buildDir
+	cmd.Stdout = &stdout
+	err = cmd.Run()
+	if err != nil {
+		return "", ""
+	}
+	revision, _ := buildenv.GitRevision(buildDir)
+	return strings.TrimSpace(stdout.String()), revision
+}
+
+// discoverTarball returns the path to the tarball of a component in the source directory of the stack, if any
+func discoverTarball(stackBasedir string, name string) string {
+	srcDir := filepath.Join(stackBasedir, "src")
+	// The tarball is in the source directory of the component, or directly in the source directory of the stack
+	// for stacks installed by previous versions
+	compSrcDir := filepath.Join(srcDir, name)
+	entries, err := ioutil.ReadDir(compSrcDir)
+	if err == nil {
+		for _, entry := range entries {
+			if entry.Mode().IsRegular() {
+				return filepath.Join(compSrcDir, entry.Name())
+			}
+		}
+	}
+	entries, err = ioutil.ReadDir(srcDir)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && strings.HasPrefix(entry.Name(), name) {
+			return filepath.Join(srcDir, entry.Name())
+		}
+	}
+	return ""
+}
+
+// discoverComponent returns the best-effort definition and state of a component from what is in the stack directory,
+// the provenance of the component taking precedence
+func discoverComponent(stackBasedir string, name string) (*Component, *ComponentState, error) {
+	comp := &Component{Name: name}
+	compState := new(ComponentState)
+
+	installDir, version, err := discoverInstallDir(filepath.Join(stackBasedir, "install", name))
+	if err != nil {
+		return nil, nil, err
+	}
+	comp.Version = version
+	comp.ConfigureDependency = strings.Join(discoverModuleDeps(stackBasedir, name), ",")
+
+	provenancePath := filepath.Join(installDir, ProvenanceFilename)
+	if util.FileExists(provenancePath) {
+		content, err := ioutil.ReadFile(provenancePath)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read %s: %w", provenancePath, err)
+		}
+		var p Provenance
+		err = json.Unmarshal(content, &p)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to parse %s: %w", provenancePath, err)
+		}
+		comp.URL = p.URL
+		compState.URL = p.URL
+		compState.SHA256 = p.SHA256
+		compState.Revision = p.Revision
+		comp.OptimizationProfile = p.OptimizationProfile
+		comp.BuildType = p.BuildType
+		comp.ConfigurePrelude = p.ConfigurePrelude
+		if p.BuildSystem != "" && p.BuildSystem != "autotools" {
+			comp.BuildSystem = p.BuildSystem
+		}
+		comp.BuildEnv = buildenv.Env(p.BuildEnv).String()
+
+		// Configure arguments for the dependencies are added back when the component is installed
+		var args []string
+		for _, arg := range p.ConfigureArgs {
+			isDepArg := false
+			for _, dep := range getDependencies(comp) {
+				depInstallDir := filepath.Join(stackBasedir, "install", dep) + "/"
+				if strings.HasPrefix(arg, "--with-") && strings.Contains(arg+"/", "="+depInstallDir) {
+					isDepArg = true
+				}
+			}
+			if !isDepArg {
+				args = append(args, arg)
+			}
+		}
+		comp.ConfigureParams = strings.Join(args, " ")
+		return comp, compState, nil
+	}
+
+	url, revision := discoverGitSource(stackBasedir, name)
+	if url != "" {
+		comp.URL = url
+		compState.URL = url
+		compState.Revision = revision
+		return comp, compState, nil
+	}
+
+	tarball := discoverTarball(stackBasedir, name)
+	if tarball != "" {
+		comp.URL = "file://" + tarball
+		compState.URL = comp.URL
+		compState.SHA256, err = buildenv.FileChecksum(tarball)
+		if err != nil {
+			return nil, nil, err
+		}
+		return comp, compState, nil
+	}
+
+	log.Printf("[WARN] unable to find where the source code of %s comes from, its URL must be set manually", name)
+	return comp, nil, nil
+}
+
+// sortByDependencies sorts components so that components come after their dependencies, which is the order
+// in which they are installed. Components that are not a dependency of each other are sorted by name.
+func sortByDependencies(components []Component) []Component {
+	sort.Slice(components, func(i, j int) bool {
+		return components[i].Name < components[j].Name
+	})
+	byName := make(map[string]*Component)
+	for idx := range components {
+		byName[components[idx].Name] = &components[idx]
+	}
+
+	var sorted []Component
+	visited := make(map[string]bool)
+	var visit func(comp *Component)
+	visit = func(comp *Component) {
+		if visited[comp.Name] {
+			return
+		}
+		visited[comp.Name] = true
+		for _, dep := range getDependencies(comp) {
+			if depComp, ok := byName[dep]; ok {
+				visit(depComp)
+			}
+		}
+		sorted = append(sorted, *comp)
+	}
+	for idx := range components {
+		visit(&components[idx])
+	}
+	return sorted
+}
+
+// DiscoverStack scans the base directory of a stack installed by this package, i.e., with install/, build/,
+// src/ and modulefiles/ subdirectories, and returns a best-effort definition of the stack and its state, e.g.,
+// to bring a stack that was installed or modified manually under management. The definition relies on the
+// provenance of the components when available, and on their Git checkout, tarball and modulefile otherwise;
+// it must be reviewed, for instance to set the URL of the components whose source code cannot be found.
+func DiscoverStack(stackBasedir string) (*StackDef, *State, error) {
+	installDir := filepath.Join(stackBasedir, "install")
+	entries, err := ioutil.ReadDir(installDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to read content of %s: %w", installDir, err)
+	}
+
+	def := &StackDef{Name: filepath.Base(stackBasedir)}
+	state := &State{
+		StackDir:   stackBasedir,
+		Components: make(map[string]*ComponentState),
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		comp, compState, err := discoverComponent(stackBasedir, entry.Name())
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to discover %s: %w", entry.Name(), err)
+		}
+		def.Components = append(def.Components, *comp)
+		if compState != nil {
+			state.Components[comp.Name] = compState
+		}
+	}
+
+	// Dependencies that are not installed would prevent the stack from being installed
+	installed := make(map[string]bool)
+	for _, comp := range def.Components {
+		installed[comp.Name] = true
+	}
+	for idx := range def.Components {
+		var deps []string
+		for _, dep := range getDependencies(&def.Components[idx]) {
+			if installed[dep] {
+				deps = append(deps, dep)
+			} else {
+				log.Printf("[WARN] %s depends on %s, which is not installed, ignoring the dependency", def.Components[idx].Name, dep)
+			}
+		}
+		def.Components[idx].ConfigureDependency = strings.Join(deps, ",")
+	}
+	def.Components = sortByDependencies(def.Components)
+	return def, state, nil
+}
+
+// AdoptStack discovers the definition of a stack installed in stackBasedir (see DiscoverStack), writes it to
+// defFilePath and writes the state of the stack in stackBasedir unless the stack already has a state
+func AdoptStack(stackBasedir string, defFilePath string) (*StackDef, error) {
+	def, state, err := DiscoverStack(stackBasedir)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := json.MarshalIndent(def, "", "\t")
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode the definition of the stack: %w", err)
+	}
+	err = ioutil.WriteFile(defFilePath, content, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to write %s: %w", defFilePath, err)
+	}
+
+	if util.FileExists(filepath.Join(stackBasedir, StateFilename)) {
+		log.Printf("-> %s already has a state, leaving it untouched", stackBasedir)
+		return def, nil
+	}
+	err = state.save(stackBasedir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to save the state of the stack: %w", err)
+	}
+	return def, nil
+}
@@ -0,0 +1,148 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// EncryptionAge is the encryption method relying on age, the encrypted tarball being <tarball>.age
+	EncryptionAge = "age"
+
+	// EncryptionGPG is the encryption method relying on GPG, the encrypted tarball being <tarball>.gpg
+	EncryptionGPG = "gpg"
+)
+
+// ExportEncryption specifies how the tarballs created by Export() are encrypted and how Import() decrypts them,
+// so that private stacks can be shipped over shared storage
+type ExportEncryption struct {
+	// Method is the encryption method, i.e., EncryptionAge or EncryptionGPG
+	Method string `json:"method"`
+
+	// Recipients are the recipients the tarball is encrypted for, i.e., age public keys or GPG key IDs
+	Recipients []string `json:"recipients"`
+
+	// RecipientsFile is the path to a file listing age recipients, one per line (optional)
+	RecipientsFile string `json:"recipients_file"`
+
+	// Identity is the path to the age identity file, i.e., private key, used to decrypt tarballs.
+	// GPG relies on the keys of the keyring instead.
+	Identity string `json:"identity"`
+}
+
+// getExportEncryption returns how the exported tarballs are encrypted, nil if they are not, relative paths
+// being resolved against the directory of the configuration file
+func (c *Config) getExportEncryption() *ExportEncryption {
+	if c.Data.StackConfig == nil || c.Data.StackConfig.ExportEncryption == nil {
+		return nil
+	}
+	e := *c.Data.StackConfig.ExportEncryption
+	for _, p := range []*string{&e.RecipientsFile, &e.Identity} {
+		if *p != "" && !filepath.IsAbs(*p) && c.ConfigFilePath != "" {
+			*p = filepath.Join(filepath.Dir(c.ConfigFilePath), *p)
+		}
+	}
+	return &e
+}
+
+// getExportFilename returns the name of the tarball created by Export()
+func (c *Config) getExportFilename() string {
+	tarballFilename := c.Data.StackDefinition.Name + ".tar.bz2"
+	if e := c.getExportEncryption(); e != nil {
+		tarballFilename += "." + e.Method
+	}
+	return tarballFilename
+}
+
+// encrypt encrypts a tarball, the tarball in clear being removed. The path to the encrypted tarball is returned.
+func (e *ExportEncryption) encrypt(tarballPath string) (string, error) {
+	encryptedPath := tarballPath + "." + e.Method
+	var bin string
+	var args []string
+	switch e.Method {
+	case EncryptionAge:
+		if len(e.Recipients) == 0 && e.RecipientsFile == "" {
+			return "", fmt.Errorf("no recipients to encrypt %s for", tarballPath)
+		}
+		bin = "age"
+		args = []string{"--encrypt", "--output", encryptedPath}
+		for _, recipient := range e.Recipients {
+			args = append(args, "--recipient", recipient)
+		}
+		if e.RecipientsFile != "" {
+			args = append(args, "--recipients-file", e.RecipientsFile)
+		}
+	case EncryptionGPG:
+		if len(e.Recipients) == 0 {
+			return "", fmt.Errorf("no recipients to encrypt %s for", tarballPath)
+		}
+		bin = "gpg"
+		// The recipients are explicitly configured, there is no need to check the trust of their keys
+		args = []string{"--batch", "--yes", "--trust-model", "always", "--encrypt", "--output", encryptedPath}
+		for _, recipient := range e.Recipients {
+			args = append(args, "--recipient", recipient)
+		}
+	default:
+		return "", fmt.Errorf("unsupported encryption method: %s", e.Method)
+	}
+	err := runCryptoCmd(bin, append(args, tarballPath))
+	if err != nil {
+		return "", fmt.Errorf("unable to encrypt %s: %w", tarballPath, err)
+	}
+	err = os.Remove(tarballPath)
+	if err != nil {
+		return "", fmt.Errorf("unable to remove %s: %w", tarballPath, err)
+	}
+	return encryptedPath, nil
+}
+
+// decrypt decrypts an encrypted tarball in dir. The path to the tarball in clear, which the caller must
+// remove, is returned.
+func decrypt(encryptedPath string, e *ExportEncryption, dir string) (string, error) {
+	method := strings.TrimPrefix(filepath.Ext(encryptedPath), ".")
+	f, err := ioutil.TempFile(dir, "import-*.tar.bz2")
+	if err != nil {
+		return "", fmt.Errorf("unable to create a temporary file in %s: %w", dir, err)
+	}
+	tarballPath := f.Name()
+	f.Close()
+
+	var bin string
+	var args []string
+	switch method {
+	case EncryptionAge:
+		if e == nil || e.Identity == "" {
+			os.Remove(tarballPath)
+			return "", fmt.Errorf("no identity to decrypt %s", encryptedPath)
+		}
+		bin = "age"
+		args = []string{"--decrypt", "--identity", e.Identity, "--output", tarballPath}
+	case EncryptionGPG:
+		bin = "gpg"
+		args = []string{"--batch", "--yes", "--decrypt", "--output", tarballPath}
+	default:
+		os.Remove(tarballPath)
+		return "", fmt.Errorf("unsupported encryption method: %s", method)
+	}
+	err = runCryptoCmd(bin, append(args, encryptedPath))
+	if err != nil {
+		os.Remove(tarballPath)
+		return "", fmt.Errorf("unable to decrypt %s: %w", encryptedPath, err)
+	}
+	return tarballPath, nil
+}
+
+// isEncrypted returns whether a tarball created by Export() is encrypted, based on its name
+func isEncrypted(tarballPath string) bool {
+	ext := strings.TrimPrefix(filepath.Ext(tarballPath), ".")
+	return ext == EncryptionAge || ext == EncryptionGPG
+}
@@ -0,0 +1,255 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// BuildTimesFilename is the name of the file recording the build durations of the components, shared by all
+	// the stacks (see Config.BuildTimesFile)
+	BuildTimesFilename = "build_times.json"
+)
+
+// buildTimesMutex serializes the updates of the build durations, which may be shared by several stacks
+var buildTimesMutex sync.Mutex
+
+// buildTimes is the history of the build durations of the components of the stacks
+type buildTimes struct {
+	// Components is the duration of the last build of each component, by name and version, the version being
+	// empty for components without version
+	Components map[string]map[string]time.Duration `json:"components"`
+}
+
+// Progress is the progress of the installation of a stack, reported when the installation of each component
+// starts and completes (see Config.OnProgress). Estimations are based on the durations of the previous builds of
+// the components, with the same or another version, by any stack.
+type Progress struct {
+	// Component is the name of the component whose installation started or completed
+	Component string
+
+	// Done specifies whether the installation of the component completed, successfully or not
+	Done bool
+
+	// Index is the position of the component in the installation of the stack, starting at 1
+	Index int
+
+	// Total is the number of components to install
+	Total int
+
+	// Elapsed is the time elapsed since the installation of the stack started
+	Elapsed time.Duration
+
+	// ComponentETA is the estimated duration of the installation of the component, 0 when it was never built
+	ComponentETA time.Duration
+
+	// StackETA is the estimated remaining time until the installation of the stack completes, not including the
+	// components that were never built
+	StackETA time.Duration
+
+	// Unestimated is the number of components remaining to install that were never built, hence not included in
+	// StackETA
+	Unestimated int
+}
+
+// ProgressFn is the function prototype for Go callbacks reporting the progress of the installation of a stack
+type ProgressFn func(c *Config, p *Progress)
+
+// getBuildTimesPath returns the path to the file recording the build durations of the components
+func (c *Config) getBuildTimesPath() (string, error) {
+	if c.BuildTimesFile != "" {
+		return c.BuildTimesFile, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the cache directory of the user: %w", err)
+	}
+	return filepath.Join(cacheDir, remoteCacheDirname, BuildTimesFilename), nil
+}
+
+// loadBuildTimes loads the build durations of the components, an empty history being returned when the file
+// does not exist yet
+func loadBuildTimes(path string) (*buildTimes, error) {
+	bt := &buildTimes{Components: make(map[string]map[string]time.Duration)}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return bt, nil
+		}
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	err = json.Unmarshal(content, bt)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", path, err)
+	}
+	if bt.Components == nil {
+		bt.Components = make(map[string]map[string]time.Duration)
+	}
+	return bt, nil
+}
+
+// save writes the build durations of the components, through a temporary file so that readers never get a
+// partial history
+func (bt *buildTimes) save(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(path), err)
+	}
+	content, err := json.MarshalIndent(bt, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to encode the build durations: %w", err)
+	}
+	tmpPath := path + ".tmp"
+	err = ioutil.WriteFile(tmpPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", tmpPath, err)
+	}
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return fmt.Errorf("unable to rename %s: %w", tmpPath, err)
+	}
+	return nil
+}
+
+// estimate returns the estimated build duration of a version of a component, i.e., the duration of its last
+// build or, when this version was never built, the average duration of the builds of its other versions
+func (bt *buildTimes) estimate(name string, version string) (time.Duration, bool) {
+	versions, ok := bt.Components[name]
+	if !ok || len(versions) == 0 {
+		return 0, false
+	}
+	if d, ok := versions[version]; ok {
+		return d, true
+	}
+	var total time.Duration
+	for _, d := range versions {
+		total += d
+	}
+	return total / time.Duration(len(versions)), true
+}
+
+// recordBuildTime saves the build duration of a component so that the next installations can be estimated
+func (c *Config) recordBuildTime(comp *Component, d time.Duration) {
+	path, err := c.getBuildTimesPath()
+	if err != nil {
+		log.Printf("[WARN] unable to record the build duration of %s: %s", comp.Name, err)
+		return
+	}
+
+	buildTimesMutex.Lock()
+	defer buildTimesMutex.Unlock()
+	bt, err := loadBuildTimes(path)
+	if err != nil {
+		log.Printf("[WARN] unable to record the build duration of %s: %s", comp.Name, err)
+		return
+	}
+	if _, ok := bt.Components[comp.Name]; !ok {
+		bt.Components[comp.Name] = make(map[string]time.Duration)
+	}
+	bt.Components[comp.Name][comp.Version] = d
+	err = bt.save(path)
+	if err != nil {
+		log.Printf("[WARN] unable to record the build duration of %s: %s", comp.Name, err)
+	}
+}
+
+// progressTracker tracks the progress of the installation of a stack to report it (see Progress)
+type progressTracker struct {
+	c         *Config
+	start     time.Time
+	estimates map[string]time.Duration
+	remaining []string
+	total     int
+	index     int
+}
+
+// newProgressTracker returns a tracker of the progress of the installation of the components of the stack, in the
+// order they are installed
+func (c *Config) newProgressTracker(order []int) *progressTracker {
+	t := &progressTracker{
+		c:         c,
+		start:     time.Now(),
+		estimates: make(map[string]time.Duration),
+	}
+	var bt *buildTimes
+	path, err := c.getBuildTimesPath()
+	if err == nil {
+		buildTimesMutex.Lock()
+		bt, err = loadBuildTimes(path)
+		buildTimesMutex.Unlock()
+	}
+	if err != nil {
+		log.Printf("[WARN] unable to load the build durations of the components, no estimation available: %s", err)
+		bt = &buildTimes{}
+	}
+	for _, idx := range order {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if comp.Disabled || comp.External != "" {
+			continue
+		}
+		t.remaining = append(t.remaining, comp.Name)
+		if d, ok := bt.estimate(comp.Name, comp.Version); ok {
+			t.estimates[comp.Name] = d
+		}
+	}
+	t.total = len(t.remaining)
+	return t
+}
+
+// report reports that the installation of a component started or completed and returns the progress of the
+// installation of the stack, nil for the components that are not installed, i.e., disabled and external components
+func (t *progressTracker) report(comp *Component, done bool) *Progress {
+	if comp.Disabled || comp.External != "" {
+		return nil
+	}
+	for idx, name := range t.remaining {
+		if name == comp.Name {
+			t.remaining = append(t.remaining[:idx], t.remaining[idx+1:]...)
+			t.index++
+			break
+		}
+	}
+
+	p := &Progress{
+		Component:    comp.Name,
+		Done:         done,
+		Index:        t.index,
+		Total:        t.total,
+		Elapsed:      time.Since(t.start),
+		ComponentETA: t.estimates[comp.Name],
+	}
+	if !done {
+		p.StackETA = p.ComponentETA
+	}
+	for _, name := range t.remaining {
+		d, ok := t.estimates[name]
+		if !ok {
+			p.Unestimated++
+		}
+		p.StackETA += d
+	}
+
+	if !done && len(t.estimates) > 0 {
+		eta := "unknown"
+		if _, ok := t.estimates[comp.Name]; ok {
+			eta = p.ComponentETA.Round(time.Second).String()
+		}
+		log.Printf("-> [%d/%d] %s: estimated duration %s, remaining time for the stack %s", p.Index, p.Total, comp.Name, eta, p.StackETA.Round(time.Second))
+	}
+	if t.c.OnProgress != nil {
+		t.c.OnProgress(t.c, p)
+	}
+	return p
+}
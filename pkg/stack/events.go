@@ -0,0 +1,201 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/builder"
+)
+
+const (
+	// ProgressStackStarted is the type of the event sent when the installation of the stack starts
+	ProgressStackStarted = "stack_started"
+
+	// ProgressComponentStarted is the type of the event sent when the installation of a component starts
+	ProgressComponentStarted = "component_started"
+
+	// ProgressStageStarted is the type of the event sent when a stage of the build of a component starts, e.g.,
+	// builder.StageConfigure
+	ProgressStageStarted = "stage_started"
+
+	// ProgressStageCompleted is the type of the event sent when a stage of the build of a component completes,
+	// successfully or not
+	ProgressStageCompleted = "stage_completed"
+
+	// ProgressComponentCompleted is the type of the event sent when the installation of a component completes,
+	// including when it is skipped
+	ProgressComponentCompleted = "component_completed"
+
+	// ProgressStackCompleted is the type of the event sent when the installation of the stack completes
+	ProgressStackCompleted = "stack_completed"
+)
+
+// ProgressEvent is an event of the stream of the progress of the installation of a stack (see Config.EventStream)
+type ProgressEvent struct {
+	// Type is the type of the event, e.g., ProgressStageStarted
+	Type string `json:"type"`
+
+	// Time is when the event occurred
+	Time time.Time `json:"time"`
+
+	// Stack is the name of the stack
+	Stack string `json:"stack"`
+
+	// Toolchain is the toolchain of the compiler matrix the stack is built with, if any
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// Component is the name of the component, for the events of components and stages
+	Component string `json:"component,omitempty"`
+
+	// Stage is the stage of the build of the component, e.g., compile, for the events of stages
+	Stage string `json:"stage,omitempty"`
+
+	// Status is the result of the installation of the component, e.g., StatusInstalled, for component_completed
+	// events, or of the stack, i.e., success or failure, for stack_completed events
+	Status string `json:"status,omitempty"`
+
+	// Index is the position of the component in the installation of the stack, starting at 1, and Total the
+	// number of components to install
+	Index int `json:"index,omitempty"`
+	Total int `json:"total,omitempty"`
+
+	// Percent is the percentage of the components of the stack whose installation completed
+	Percent float64 `json:"percent"`
+
+	// ETASeconds is the estimated remaining time until the installation of the stack completes, in seconds,
+	// when known (see Progress)
+	ETASeconds float64 `json:"eta_seconds,omitempty"`
+
+	// Error is the error that made the stage, the component or the stack fail, if any
+	Error string `json:"error,omitempty"`
+
+	// LogExcerpt is the end of the error, including the output of the command that failed, if any
+	LogExcerpt string `json:"log_excerpt,omitempty"`
+
+	// LogPath is the path to the file recording the commands executed to build the component that failed, if any
+	LogPath string `json:"log_path,omitempty"`
+}
+
+// emitEvent writes an event of the progress of the installation of the stack in its event stream, if any. The
+// installation of the stack does not fail when the event cannot be written, e.g., because the other end of a
+// socket is gone.
+func (c *Config) emitEvent(event *ProgressEvent) {
+	if c.EventStream == nil {
+		return
+	}
+	event.Time = time.Now()
+	if c.Data.StackDefinition != nil {
+		event.Stack = c.Data.StackDefinition.Name
+	}
+	if c.toolchain != nil {
+		event.Toolchain = c.toolchain.Name
+	}
+
+	c.eventMutex.Lock()
+	defer c.eventMutex.Unlock()
+	if c.eventStreamFailed {
+		return
+	}
+	// The percentage only changes when components start and complete
+	switch {
+	case event.Type == ProgressStackStarted:
+		c.eventPercent = 0
+	case event.Total > 0:
+		c.eventPercent = event.Percent
+	default:
+		event.Percent = c.eventPercent
+	}
+	content, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[WARN] unable to encode the %s event: %s", event.Type, err)
+		return
+	}
+	_, err = c.EventStream.Write(append(content, '\n'))
+	if err != nil {
+		// Warning once is enough
+		log.Printf("[WARN] unable to write the progress of the installation, not reporting it anymore: %s", err)
+		c.eventStreamFailed = true
+	}
+}
+
+// setEventError sets the error of an event, with the log of the failure when available
+func setEventError(event *ProgressEvent, err error) {
+	if err == nil {
+		return
+	}
+	event.Error = err.Error()
+	event.LogExcerpt = getLogExcerpt(err)
+	var buildErr *builder.BuildError
+	if errors.As(err, &buildErr) {
+		event.LogPath = buildErr.LogPath
+	}
+}
+
+// emitComponentEvent writes an event of the installation of a component, p being its progress in the installation
+// of the stack, if tracked, and status and err the result of the installation once it completed
+func (c *Config) emitComponentEvent(eventType string, comp *Component, p *Progress, status string, err error) {
+	event := &ProgressEvent{
+		Type:      eventType,
+		Component: comp.Name,
+		Status:    status,
+	}
+	if p != nil {
+		event.Index = p.Index
+		event.Total = p.Total
+		completed := p.Index - 1
+		if p.Done {
+			completed = p.Index
+		}
+		if p.Total > 0 {
+			event.Percent = 100 * float64(completed) / float64(p.Total)
+		}
+		event.ETASeconds = p.StackETA.Seconds()
+	}
+	setEventError(event, err)
+	c.emitEvent(event)
+}
+
+// getStageFn returns the function writing the events of the stages of the build of a component, nil when the
+// progress of the installations is not streamed (see builder.Builder.OnStage)
+func (c *Config) getStageFn(comp *Component) builder.StageFn {
+	if c.EventStream == nil {
+		return nil
+	}
+	return func(stage string, done bool, err error) {
+		event := &ProgressEvent{
+			Type:      ProgressStageStarted,
+			Component: comp.Name,
+			Stage:     stage,
+		}
+		if done {
+			event.Type = ProgressStageCompleted
+			if err != nil {
+				// The error of the stage gets the log of the failure
+				setEventError(event, c.newComponentError(comp, stage, err))
+			}
+		}
+		c.emitEvent(event)
+	}
+}
+
+// emitStackCompleted writes the event of the completion of the installation of the stack, err being the error that
+// made it fail, if any
+func (c *Config) emitStackCompleted(err error) {
+	event := &ProgressEvent{
+		Type:   ProgressStackCompleted,
+		Status: "success",
+	}
+	if err != nil {
+		event.Status = "failure"
+		event.Error = err.Error()
+	}
+	c.emitEvent(event)
+}
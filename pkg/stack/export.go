@@ -0,0 +1,262 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_software_build/internal/pkg/module"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// ExportManifestFilename is the name of the manifest of the tarballs created by Export(), written in the stack
+	// base directory next to the tarball, so that it can be the base of the next incremental export (see ExportBase)
+	ExportManifestFilename = "export_manifest.json"
+)
+
+// ExportManifest is the manifest of an export of a stack
+type ExportManifest struct {
+	// Stack is the name of the exported stack
+	Stack string `json:"stack"`
+
+	// Fingerprints is the fingerprint of the installation directory of all the exported components
+	Fingerprints map[string]string `json:"fingerprints"`
+
+	// Included is the components whose installation directory is included in the export, i.e., all of them unless
+	// the export is incremental
+	Included []string `json:"included"`
+
+	// InstallDirs is the name of the installation directory of the exported components whose installation prefix
+	// is hashed (see StackCfg.HashedInstallPrefixes)
+	InstallDirs map[string]string `json:"install_dirs,omitempty"`
+
+	// Incremental specifies whether the export only includes the components that changed since the export it is
+	// based on, which must be imported first (see ExportBase)
+	Incremental bool `json:"incremental,omitempty"`
+}
+
+// getExportedComponents returns the components included in the exports of the stack (see ExportComponents),
+// nil meaning all the components of the stack
+func (c *Config) getExportedComponents() (map[string]bool, error) {
+	if len(c.ExportComponents) == 0 {
+		return nil, nil
+	}
+
+	exported := make(map[string]bool)
+	toExport := append([]string{}, c.ExportComponents...)
+	for len(toExport) > 0 {
+		name := toExport[0]
+		toExport = toExport[1:]
+		if exported[name] {
+			continue
+		}
+		comp := c.getComponent(name)
+		if comp == nil {
+			return nil, fmt.Errorf("%s is not a component of the stack", name)
+		}
+		if comp.Disabled {
+			return nil, fmt.Errorf("%s is disabled and cannot be exported", name)
+		}
+		exported[name] = true
+		if c.ExportDependencies {
+			toExport = append(toExport, getDependencies(comp)...)
+		}
+	}
+	return exported, nil
+}
+
+// getExportContent returns the files and directories of the stack, relative to its base directory, included in
+// its exports for the installed components and their modulefiles: the components of exported, all of them when
+// nil, with only the installation directories of the components of changed, all of them when nil
+func (c *Config) getExportContent(exported map[string]bool, changed map[string]bool) []string {
+	stackBasedir := c.getStackBasedir()
+	modulefileDirs, _ := getModulefileDirs(stackBasedir, ModuleFormatBoth)
+	if exported == nil && changed == nil {
+		content := []string{"install"}
+		for _, modulefileDir := range []string{modulefileDirs[module.DialectTcl], modulefileDirs[module.DialectLua]} {
+			if util.PathExists(modulefileDir) {
+				content = append(content, filepath.Base(modulefileDir))
+			}
+		}
+		return content
+	}
+
+	var content []string
+	for _, comp := range c.Data.StackDefinition.Components {
+		if exported != nil && !exported[comp.Name] {
+			continue
+		}
+		// External components are not part of the stack, only their modulefiles are
+		var paths []string
+		if comp.External == "" && (changed == nil || changed[comp.Name]) {
+			paths = append(paths, filepath.Join("install", getInstallDirName(&comp)))
+		}
+		paths = append(paths, filepath.Join(filepath.Base(modulefileDirs[module.DialectTcl]), comp.Name))
+		paths = append(paths, filepath.Join(filepath.Base(modulefileDirs[module.DialectLua]), comp.Name+".lua"))
+		for _, path := range paths {
+			if util.PathExists(filepath.Join(stackBasedir, path)) {
+				content = append(content, path)
+			}
+		}
+	}
+	return content
+}
+
+// getFingerprint returns the fingerprint of the content of a directory, e.g., the installation directory of a
+// component, which changes when any of its files, their mode or the target of its symbolic links change
+func getFingerprint(dir string) (string, error) {
+	hasher := sha256.New()
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relPath, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		entry := fmt.Sprintf("%s %s", relPath, info.Mode())
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			entry += " " + target
+		case info.Mode().IsRegular():
+			checksum, err := buildenv.FileChecksum(path)
+			if err != nil {
+				return err
+			}
+			entry += " " + checksum
+		}
+		_, err = fmt.Fprintln(hasher, entry)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("unable to compute the fingerprint of %s: %w", dir, err)
+	}
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// loadExportManifest loads the manifest of an export (see ExportManifestFilename)
+func loadExportManifest(path string) (*ExportManifest, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", path, err)
+	}
+	manifest := new(ExportManifest)
+	err = json.Unmarshal(content, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// getExportManifest returns the manifest of an export of the components of exported, all of them when nil, with
+// the fingerprint of their installation directory. The components whose fingerprint did not change since the
+// export of ExportBase are not included in the export.
+func (c *Config) getExportManifest(exported map[string]bool) (*ExportManifest, error) {
+	stackBasedir := c.getStackBasedir()
+	manifest := &ExportManifest{
+		Stack:        c.Data.StackDefinition.Name,
+		Fingerprints: make(map[string]string),
+		InstallDirs:  make(map[string]string),
+	}
+	var base *ExportManifest
+	if c.ExportBase != "" {
+		var err error
+		base, err = loadExportManifest(c.ExportBase)
+		if err != nil {
+			return nil, err
+		}
+		if base.Stack != manifest.Stack {
+			return nil, fmt.Errorf("%s is the manifest of an export of %s, not %s", c.ExportBase, base.Stack, manifest.Stack)
+		}
+		manifest.Incremental = true
+	}
+
+	for _, comp := range c.Data.StackDefinition.Components {
+		if comp.Disabled || comp.External != "" || (exported != nil && !exported[comp.Name]) {
+			continue
+		}
+		compInstallDir := filepath.Join(stackBasedir, "install", getInstallDirName(&comp))
+		if !util.PathExists(compInstallDir) {
+			continue
+		}
+		if comp.installHash != "" {
+			manifest.InstallDirs[comp.Name] = getInstallDirName(&comp)
+		}
+		fingerprint, err := getFingerprint(compInstallDir)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Fingerprints[comp.Name] = fingerprint
+		if base == nil || base.Fingerprints[comp.Name] != fingerprint {
+			manifest.Included = append(manifest.Included, comp.Name)
+		}
+	}
+	return manifest, nil
+}
+
+// write writes the manifest of an export in a directory
+func (manifest *ExportManifest) write(dir string) error {
+	content, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to encode the manifest of the export: %w", err)
+	}
+	manifestPath := filepath.Join(dir, ExportManifestFilename)
+	err = ioutil.WriteFile(manifestPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", manifestPath, err)
+	}
+	return nil
+}
+
+// checkIncrementalImport checks, for an incremental export extracted in importDir, that the components it does
+// not include are already installed in the stack, i.e., that the export it is based on was imported
+func checkIncrementalImport(importDir string, stackBasedir string) error {
+	manifestPath := filepath.Join(importDir, ExportManifestFilename)
+	if !util.FileExists(manifestPath) {
+		return nil
+	}
+	manifest, err := loadExportManifest(manifestPath)
+	if err != nil {
+		return err
+	}
+	if !manifest.Incremental {
+		return nil
+	}
+	included := make(map[string]bool)
+	for _, name := range manifest.Included {
+		included[name] = true
+	}
+	var missing []string
+	for name := range manifest.Fingerprints {
+		installDirName := name
+		if dirName, ok := manifest.InstallDirs[name]; ok {
+			installDirName = dirName
+		}
+		if !included[name] && !util.PathExists(filepath.Join(stackBasedir, "install", installDirName)) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("unable to import an incremental export, the export it is based on must be imported first: %s not installed", strings.Join(missing, ", "))
+	}
+	return nil
+}
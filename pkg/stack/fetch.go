@@ -0,0 +1,251 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gvallee/go_software_build/pkg/builder"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// SourceManifestFilename is the name of the manifest created by DownloadSources()
+	SourceManifestFilename = "sources.json"
+)
+
+// SourceEntry describes the source code of a component that was downloaded with DownloadSources()
+type SourceEntry struct {
+	// Component is the name of the component
+	Component string `json:"component"`
+
+	// URL is the URL the source code was downloaded from
+	URL string `json:"URL"`
+
+	// Path is the path to the source code, relative to the directory passed to DownloadSources()
+	Path string `json:"path"`
+
+	// SHA256 is the checksum of the source code when it is a file, e.g., a tarball
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Revision is the SHA of the commit that was checked out when the source code is a Git repository
+	Revision string `json:"revision,omitempty"`
+
+	// Type is the type of the source code when it cannot be detected from Path, i.e., git for the Git
+	// bundles of the bundles created by CreateBundle()
+	Type string `json:"type,omitempty"`
+}
+
+// SourceManifest is the list of all the source code downloaded with DownloadSources()
+type SourceManifest struct {
+	// Stack is the name of the stack
+	Stack string `json:"stack"`
+
+	// Sources is the list of source code, one per component
+	Sources []SourceEntry `json:"sources"`
+}
+
+// forEachComponent calls fn for all the enabled components of the stack that are not external, with at most
+// jobs concurrent calls (0 meaning no limit). All the errors are reported at once.
+func (c *Config) forEachComponent(jobs int, fn func(comp *Component) error) error {
+	if jobs <= 0 {
+		jobs = len(c.Data.StackDefinition.Components)
+	}
+	if jobs <= 0 {
+		return nil
+	}
+	sem := make(chan struct{}, jobs)
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var errs []string
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if comp.Disabled || comp.External != "" {
+			continue
+		}
+
+		wg.Add(1)
+		go func(comp *Component) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			err := fn(comp)
+			if err != nil {
+				mutex.Lock()
+				errs = append(errs, err.Error())
+				mutex.Unlock()
+			}
+		}(comp)
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		sort.Strings(errs)
+		return fmt.Errorf("%d component(s) failed: %s", len(errs), strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Fetch gets the source code of all the enabled components of the stack in parallel, without
+// building anything. Source code that was already downloaded is not downloaded again.
+// Calling Fetch() before InstallStack() separates the network-bound and CPU-bound phases of the
+// installation and makes network problems surface before any build starts.
+func (c *Config) Fetch() error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("unable to load configuration: %w", err)
+		}
+	}
+
+	env := c.newComponentEnv()
+	for _, dir := range []string{env.ScratchDir, env.BuildDir, env.InstallDir, env.SrcDir} {
+		if !util.PathExists(dir) {
+			err := os.MkdirAll(dir, defaultPermission)
+			if err != nil {
+				return fmt.Errorf("unable to create %s: %w", dir, err)
+			}
+		}
+	}
+
+	err := c.loadStackState()
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+
+	var mutex sync.Mutex
+	fetched := make(map[string]bool)
+	err = c.forEachComponent(c.FetchJobs, func(comp *Component) error {
+		a, err := c.newComponentApp(comp)
+		if err != nil {
+			return c.newComponentError(comp, builder.StagePrepare, err)
+		}
+
+		compEnv := c.newComponentEnv()
+		err = c.setComponentDirs(&compEnv, comp)
+		if err != nil {
+			return c.newComponentError(comp, builder.StagePrepare, err)
+		}
+		log.Printf("-> Fetching %s", comp.Name)
+		err = compEnv.Get(&a)
+		if err != nil {
+			return c.newComponentError(comp, builder.StageDownload, err)
+		}
+		c.recordComponentSource(comp, a.Source.URL, &compEnv)
+		mutex.Lock()
+		fetched[comp.Name] = true
+		mutex.Unlock()
+		return nil
+	})
+
+	c.mutex.Lock()
+	if c.fetched == nil {
+		c.fetched = make(map[string]bool)
+	}
+	for name := range fetched {
+		c.fetched[name] = true
+	}
+	c.mutex.Unlock()
+
+	if err != nil {
+		return fmt.Errorf("failed to fetch the stack: %w", err)
+	}
+	return c.state.save(c.getStackBasedir())
+}
+
+// downloadComponentSource downloads the source code of a component into its own subdirectory of dir
+func (c *Config) downloadComponentSource(comp *Component, dir string) (*SourceEntry, error) {
+	a, err := c.newComponentApp(comp)
+	if err != nil {
+		return nil, err
+	}
+
+	env := c.newComponentEnv()
+	env.BuildDir = dir
+	env.SrcDir = filepath.Join(dir, comp.Name)
+	log.Printf("-> Downloading source code of %s", comp.Name)
+	err = env.Get(&a)
+	if err != nil {
+		return nil, c.newComponentError(comp, builder.StageDownload, err)
+	}
+
+	relPath, err := filepath.Rel(dir, env.SrcPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get relative path of %s: %w", env.SrcPath, err)
+	}
+	entry := &SourceEntry{
+		Component: comp.Name,
+		URL:       a.Source.URL,
+		Path:      relPath,
+		SHA256:    env.SrcChecksum,
+		Revision:  env.SrcRevision,
+	}
+	return entry, nil
+}
+
+// DownloadSources only downloads the source code of all the enabled components of the stack into
+// a directory, typically to prepare an offline installation. Each component is stored in its own
+// subdirectory and a manifest (see SourceManifestFilename) with the checksum of all the files is
+// created at the top of the directory.
+func (c *Config) DownloadSources(dir string) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("unable to load configuration: %w", err)
+		}
+	}
+
+	if !util.PathExists(dir) {
+		err := os.MkdirAll(dir, defaultPermission)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", dir, err)
+		}
+	}
+
+	var mutex sync.Mutex
+	var manifest SourceManifest
+	manifest.Stack = c.Data.StackDefinition.Name
+	err := c.forEachComponent(c.FetchJobs, func(comp *Component) error {
+		entry, err := c.downloadComponentSource(comp, dir)
+		if err != nil {
+			return err
+		}
+		mutex.Lock()
+		manifest.Sources = append(manifest.Sources, *entry)
+		mutex.Unlock()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download the source code of the stack: %w", err)
+	}
+
+	sort.Slice(manifest.Sources, func(i, j int) bool {
+		return manifest.Sources[i].Component < manifest.Sources[j].Component
+	})
+	content, err := json.MarshalIndent(&manifest, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to create the source manifest: %w", err)
+	}
+	manifestPath := filepath.Join(dir, SourceManifestFilename)
+	err = ioutil.WriteFile(manifestPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", manifestPath, err)
+	}
+
+	fmt.Printf("Source code successfully downloaded in %s\n", dir)
+	return nil
+}
@@ -0,0 +1,155 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// getOrphans returns the directories and files of the stack that belong to components that are not part of the
+// definition of the stack anymore, e.g., the installation directories of removed components or of previous versions
+// of components, as well as the names of the removed components
+func (c *Config) getOrphans() ([]string, []string, error) {
+	stackBasedir := c.getStackBasedir()
+	defined := make(map[string]bool)
+	installDirNames := make(map[string]string)
+	var orphans []string
+	for _, comp := range c.Data.StackDefinition.Components {
+		defined[comp.Name] = true
+		installDirNames[comp.Name] = getInstallDirName(&comp)
+
+		// Previous versions of the component, or its installation from before it had a version. With hashed
+		// installation prefixes, previous configurations of the component are in other directories.
+		if comp.Version == "" || comp.installHash != "" {
+			continue
+		}
+		compInstallDir := filepath.Join(stackBasedir, "install", comp.Name)
+		if !util.PathExists(compInstallDir) {
+			continue
+		}
+		entries, err := ioutil.ReadDir(compInstallDir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read content of %s: %w", compInstallDir, err)
+		}
+		for _, entry := range entries {
+			if entry.Name() != comp.Version {
+				orphans = append(orphans, filepath.Join(compInstallDir, entry.Name()))
+			}
+		}
+	}
+
+	orphanComps := make(map[string]bool)
+	for _, subdir := range []string{"install", "build", "scratch", debugDirname, "modulefiles", "modulefiles_lua"} {
+		dir := filepath.Join(stackBasedir, subdir)
+		if !util.PathExists(dir) {
+			continue
+		}
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return nil, nil, fmt.Errorf("unable to read content of %s: %w", dir, err)
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if subdir == "modulefiles_lua" {
+				name = strings.TrimSuffix(name, ".lua")
+			}
+			if subdir == "install" {
+				name = getInstallDirComponent(name, defined)
+				if defined[name] && entry.Name() != installDirNames[name] {
+					// Previous configuration of the component (see StackCfg.HashedInstallPrefixes)
+					orphans = append(orphans, filepath.Join(dir, entry.Name()))
+					continue
+				}
+			}
+			if defined[name] {
+				continue
+			}
+			orphans = append(orphans, filepath.Join(dir, entry.Name()))
+			orphanComps[name] = true
+		}
+	}
+
+	if c.state != nil {
+		c.state.mutex.Lock()
+		for name := range c.state.Components {
+			if !defined[name] {
+				orphanComps[name] = true
+			}
+		}
+		c.state.mutex.Unlock()
+	}
+
+	var names []string
+	for name := range orphanComps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return orphans, names, nil
+}
+
+// GC removes the installation, build directories and modulefiles of the components that are not part of the
+// definition of the stack anymore, as well as their state, and the installations of previous versions of the
+// components (see Component.Version). It returns the list of the directories and files that are removed;
+// when dryRun is true, they are only listed.
+func (c *Config) GC(dryRun bool) ([]string, error) {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return nil, fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+
+	stackBasedir := c.getStackBasedir()
+	if !util.PathExists(stackBasedir) {
+		return nil, fmt.Errorf("%s does not exist", stackBasedir)
+	}
+
+	err := c.loadStackState()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+
+	orphans, orphanComps, err := c.getOrphans()
+	if err != nil {
+		return nil, err
+	}
+	if dryRun {
+		for _, orphan := range orphans {
+			log.Printf("-> %s would be removed", orphan)
+		}
+		return orphans, nil
+	}
+
+	for _, orphan := range orphans {
+		log.Printf("-> Removing %s", orphan)
+		err := os.RemoveAll(orphan)
+		if err != nil {
+			return nil, fmt.Errorf("unable to remove %s: %w", orphan, err)
+		}
+	}
+
+	if len(orphanComps) > 0 {
+		c.state.mutex.Lock()
+		for _, name := range orphanComps {
+			delete(c.state.Components, name)
+		}
+		c.state.mutex.Unlock()
+		err = c.state.save(stackBasedir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to save the state of the stack: %w", err)
+		}
+	}
+	return orphans, nil
+}
@@ -0,0 +1,63 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// hermeticPath is the PATH of hermetic build environments, before the bin directories of the components
+	// of the stack that are already installed
+	hermeticPath = "/usr/local/bin:/usr/bin:/bin:/usr/local/sbin:/usr/sbin:/sbin"
+)
+
+// defaultHermeticVars are the variables of the environment of the caller that hermetic build environments inherit
+var defaultHermeticVars = []string{"HOME", "USER", "LOGNAME", "SHELL", "TMPDIR", "LANG", "LC_ALL", "TZ", "TERM"}
+
+// getHermeticEnv returns the hermetic build environment of a component (see StackCfg.Hermetic), customEnv
+// being the variables explicitly set for the component, e.g., CC=gcc-12. Variables of customEnv can refer to
+// the variables of the hermetic environment, e.g., PATH=/opt/cuda/bin:$PATH.
+func (c *Config) getHermeticEnv(customEnv buildenv.Env) buildenv.Env {
+	var env buildenv.Env
+	for _, name := range append(defaultHermeticVars, c.Data.StackConfig.HermeticEnv...) {
+		if value, ok := os.LookupEnv(name); ok {
+			env.Set(name, value)
+		}
+	}
+	if _, ok := env.Get("PATH"); !ok {
+		env.Set("PATH", hermeticPath)
+	}
+
+	// The binaries of the components installed so far can be used to build the next ones
+	c.mutex.RLock()
+	for _, comp := range c.Data.StackDefinition.Components {
+		compInstallDir, ok := c.InstalledComponents[comp.Name]
+		if !ok {
+			continue
+		}
+		compBinDir := filepath.Join(compInstallDir, "bin")
+		if util.PathExists(compBinDir) {
+			env.Prepend("PATH", compBinDir, ":")
+		}
+	}
+	c.mutex.RUnlock()
+
+	for _, e := range customEnv.Dedup() {
+		tokens := strings.SplitN(e, "=", 2)
+		env.Set(tokens[0], os.Expand(tokens[1], func(name string) string {
+			value, _ := env.Get(name)
+			return value
+		}))
+	}
+	return env
+}
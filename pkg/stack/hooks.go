@@ -0,0 +1,108 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_software_build/pkg/builder"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// HookFn is the function prototype for Go callbacks invoked at various stages of the installation of a stack.
+// comp is the component being installed, nil for stack-level events; compErr is the error that made
+// the component fail, nil when not applicable.
+type HookFn func(c *Config, comp *Component, compErr error) error
+
+// FailureAction is what to do when a component fails to install (see Config.OnFailure)
+type FailureAction string
+
+const (
+	// FailureAbort stops the installation of the stack
+	FailureAbort FailureAction = "abort"
+
+	// FailureSkip continues with the other components, the components depending on the one that failed being skipped
+	FailureSkip FailureAction = "skip"
+
+	// FailureRetry installs the component again, e.g., once the operator fixed the cause of the failure
+	FailureRetry FailureAction = "retry"
+)
+
+// FailureFn is the function prototype for Go callbacks choosing what to do when a component fails to install
+type FailureFn func(c *Config, comp *Component, err error) FailureAction
+
+// Hook represents an action to execute at a specific stage of the installation of a stack.
+// Both a command and a callback can be specified, in which case the command is executed first.
+type Hook struct {
+	// Cmd is a command executed through a shell from the stack base directory (optional).
+	// The following environment variables are set when the command is executed: STACK_NAME, STACK_DIR
+	// and when applicable STACK_COMPONENT, STACK_ERROR, STACK_STAGE, i.e., the stage that failed, e.g., compile,
+	// and STACK_LOG, i.e., the file recording the commands executed to build the component (see builder.BuildError).
+	Cmd string
+
+	// Fn is a Go callback (optional)
+	Fn HookFn
+}
+
+// runHook executes a hook; name is used to identify the hook in messages
+func (c *Config) runHook(name string, h *Hook, comp *Component, compErr error) error {
+	if h == nil || (h.Cmd == "" && h.Fn == nil) {
+		return nil
+	}
+
+	if h.Cmd != "" {
+		stackBasedir := c.getStackBasedir()
+		log.Printf("-> Running %s hook: %s", name, h.Cmd)
+		var cmd advexec.Advcmd
+		cmd.BinPath = "/bin/sh"
+		cmd.CmdArgs = []string{"-c", h.Cmd}
+		if util.PathExists(stackBasedir) {
+			cmd.ExecDir = stackBasedir
+		}
+		cmd.Env = append(os.Environ(), "STACK_NAME="+c.Data.StackDefinition.Name, "STACK_DIR="+stackBasedir)
+		if comp != nil {
+			cmd.Env = append(cmd.Env, "STACK_COMPONENT="+comp.Name)
+		}
+		if compErr != nil {
+			cmd.Env = append(cmd.Env, "STACK_ERROR="+compErr.Error())
+			var buildErr *builder.BuildError
+			if errors.As(compErr, &buildErr) {
+				cmd.Env = append(cmd.Env, "STACK_STAGE="+buildErr.Stage, "STACK_LOG="+buildErr.LogPath)
+			}
+		}
+		res := cmd.Run()
+		if res.Err != nil {
+			return fmt.Errorf("%s hook failed: %w - stdout: %s - stderr: %s", name, res.Err, res.Stdout, res.Stderr)
+		}
+	}
+
+	if h.Fn != nil {
+		err := h.Fn(c, comp, compErr)
+		if err != nil {
+			return fmt.Errorf("%s hook failed: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// getFailureAction returns what to do when a component failed to install (see Config.OnFailure)
+func (c *Config) getFailureAction(comp *Component, err error) FailureAction {
+	if c.OnFailure != nil {
+		if action := c.OnFailure(c, comp, err); action != "" {
+			return action
+		}
+	}
+	if c.KeepGoing {
+		return FailureSkip
+	}
+	return FailureAbort
+}
@@ -0,0 +1,367 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// ExportChecksumsFilename is the name of the file with the SHA256 checksum of all the files of the tarballs
+	// created by Export(), in the format of sha256sum, checked by Import()
+	ExportChecksumsFilename = "SHA256SUMS"
+)
+
+// ImportPolicy is how Import() resolves a conflict between an imported component and a component already installed
+// in the stack
+type ImportPolicy string
+
+const (
+	// ImportSkip keeps the component already installed, the imported one being ignored
+	ImportSkip ImportPolicy = "skip"
+
+	// ImportOverwrite replaces the component already installed with the imported one
+	ImportOverwrite ImportPolicy = "overwrite"
+
+	// ImportRename imports the component under a new name, i.e., <name>-<version> when the versions differ or
+	// <name>-imported, next to the component already installed
+	ImportRename ImportPolicy = "rename"
+)
+
+// ImportConflict is a component both in an imported stack and installed in the stack it is imported in
+type ImportConflict struct {
+	// Name is the name of the component
+	Name string
+
+	// InstalledVersion is the version of the component already installed, if any
+	InstalledVersion string
+
+	// ImportedVersion is the version of the imported component, if any
+	ImportedVersion string
+
+	// Resolution is how the conflict was resolved
+	Resolution ImportPolicy
+
+	// NewName is the name of the imported component when it is renamed (see ImportRename)
+	NewName string
+}
+
+// SameVersion returns whether the imported component and the component already installed have the same version
+func (conflict *ImportConflict) SameVersion() bool {
+	return conflict.InstalledVersion == conflict.ImportedVersion
+}
+
+// extractTarball extracts a tarball created by Export() in a directory. The tarball may have been recompressed,
+// e.g., with gzip, xz or zstd, its compression being detected from its content.
+func extractTarball(tarballPath string, dir string) error {
+	tarBin, err := exec.LookPath("tar")
+	if err != nil {
+		return fmt.Errorf("ERROR: tar is not available: %w", err)
+	}
+	tarCmd, err := buildenv.GetTarExtractCmd(tarBin, dir, tarballPath)
+	if err != nil {
+		return err
+	}
+	var stderr, stdout bytes.Buffer
+	tarCmd.Stderr = &stderr
+	tarCmd.Stdout = &stdout
+	err = tarCmd.Run()
+	if err != nil {
+		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+	}
+	return nil
+}
+
+// extractImport extracts a tarball created by Export() in a temporary directory of the stack, which the caller must
+// remove, and checks that its files match their checksums (see ExportChecksumsFilename) so that nothing is
+// installed from a corrupted tarball
+func (c *Config) extractImport(tarballPath string) (string, error) {
+	stackBasedir := c.getStackBasedir()
+	importDir, err := ioutil.TempDir(stackBasedir, ".import-")
+	if err != nil {
+		return "", fmt.Errorf("unable to create a temporary directory in %s: %w", stackBasedir, err)
+	}
+	err = extractTarball(tarballPath, importDir)
+	if err != nil {
+		os.RemoveAll(importDir)
+		return "", err
+	}
+
+	if !util.FileExists(filepath.Join(importDir, ExportChecksumsFilename)) {
+		log.Printf("[WARN] %s does not include the checksums of its files, they cannot be verified", tarballPath)
+		return importDir, nil
+	}
+	problems, err := checkChecksums(importDir, ExportChecksumsFilename)
+	if err == nil && len(problems) > 0 {
+		err = fmt.Errorf("invalid export %s: %s", tarballPath, strings.Join(problems, "; "))
+	}
+	if err != nil {
+		os.RemoveAll(importDir)
+		return "", err
+	}
+	return importDir, nil
+}
+
+// replacePath moves a file or directory to dst, replacing dst if it exists
+func replacePath(path string, dst string) error {
+	err := os.RemoveAll(dst)
+	if err != nil {
+		return fmt.Errorf("unable to remove %s: %w", dst, err)
+	}
+	err = os.MkdirAll(filepath.Dir(dst), defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(dst), err)
+	}
+	err = os.Rename(path, dst)
+	if err != nil {
+		return fmt.Errorf("unable to move %s to %s: %w", path, dst, err)
+	}
+	return nil
+}
+
+// moveImportedFiles moves the files extracted by extractImport() to the stack. The content of the directories
+// already in the stack, e.g., the installation directories of the components in install, is replaced entry by entry.
+func moveImportedFiles(importDir string, stackBasedir string) error {
+	entries, err := ioutil.ReadDir(importDir)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %w", importDir, err)
+	}
+	for _, entry := range entries {
+		if entry.Name() == ExportChecksumsFilename {
+			continue
+		}
+		path := filepath.Join(importDir, entry.Name())
+		dst := filepath.Join(stackBasedir, entry.Name())
+		if !entry.IsDir() || !util.IsDir(dst) {
+			err = replacePath(path, dst)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		subEntries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", path, err)
+		}
+		for _, subEntry := range subEntries {
+			err = replacePath(filepath.Join(path, subEntry.Name()), filepath.Join(dst, subEntry.Name()))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// getSBOMVersions returns the version of the components of the SBOM of a stack, if any
+func getSBOMVersions(stackBasedir string) (map[string]string, error) {
+	versions := make(map[string]string)
+	sbomPath := filepath.Join(stackBasedir, SBOMFilename)
+	if !util.FileExists(sbomPath) {
+		return versions, nil
+	}
+	content, err := ioutil.ReadFile(sbomPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", sbomPath, err)
+	}
+	var sbom SBOM
+	err = json.Unmarshal(content, &sbom)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", sbomPath, err)
+	}
+	for _, comp := range sbom.Components {
+		versions[comp.Name] = comp.Version
+	}
+	return versions, nil
+}
+
+// getInstalledVersion returns the version of a component installed in the stack, based on its definition
+func (c *Config) getInstalledVersion(name string) string {
+	if comp := c.getComponent(name); comp != nil {
+		return comp.Version
+	}
+	return ""
+}
+
+// getImportPolicy returns how a conflict is resolved (see ImportPolicy and OnImportConflict)
+func (c *Config) getImportPolicy(conflict *ImportConflict) (ImportPolicy, error) {
+	policy := c.ImportPolicy
+	if c.OnImportConflict != nil {
+		if p := c.OnImportConflict(conflict); p != "" {
+			policy = p
+		}
+	}
+	switch policy {
+	case ImportSkip, ImportOverwrite, ImportRename:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unsupported import policy for %s: %s", conflict.Name, policy)
+}
+
+// getRenamedComponent returns the name an imported component is renamed to so it does not conflict with the
+// components installed in the stack
+func getRenamedComponent(stackBasedir string, conflict *ImportConflict) string {
+	baseName := conflict.Name + "-imported"
+	if !conflict.SameVersion() && conflict.ImportedVersion != "" {
+		baseName = conflict.Name + "-" + conflict.ImportedVersion
+	}
+	newName := baseName
+	for i := 2; util.PathExists(filepath.Join(stackBasedir, "install", newName)); i++ {
+		newName = baseName + "-" + strconv.Itoa(i)
+	}
+	return newName
+}
+
+// getComponentFiles returns the installation directory and the modulefiles of a component, relative to the base
+// directory of its stack, installDirName being the name of its installation directory (see getInstallDirName)
+func getComponentFiles(name string, installDirName string) []string {
+	return []string{
+		filepath.Join("install", installDirName),
+		filepath.Join("modulefiles", name),
+		filepath.Join("modulefiles_lua", name+".lua"),
+	}
+}
+
+// moveImportedComponent moves the files of a component extracted in importDir to the stack, under a new name
+// when it is renamed, replacing the files of the component already installed, if any, and relocates its
+// modulefiles from the directory the stack was exported from. installDirName is the name of the installation
+// directory of the imported component, which is its new name when it is renamed.
+func moveImportedComponent(importDir string, stackBasedir string, oldStackBasedir string, name string, installDirName string, newName string) error {
+	importedFiles := getComponentFiles(name, installDirName)
+	newInstallDirName := installDirName
+	if newName != name {
+		newInstallDirName = newName
+	}
+	for idx, path := range getComponentFiles(newName, newInstallDirName) {
+		importedPath := filepath.Join(importDir, importedFiles[idx])
+		if !util.PathExists(importedPath) {
+			continue
+		}
+		path = filepath.Join(stackBasedir, path)
+		err := replacePath(importedPath, path)
+		if err != nil {
+			return err
+		}
+		if idx == 0 || oldStackBasedir == "" {
+			continue
+		}
+		err = relocateFileContent(path, filepath.Join(oldStackBasedir, "install", installDirName), filepath.Join(stackBasedir, "install", newInstallDirName))
+		if err != nil {
+			return fmt.Errorf("unable to relocate %s: %w", path, err)
+		}
+		err = relocateFileContent(path, oldStackBasedir, stackBasedir)
+		if err != nil {
+			return fmt.Errorf("unable to relocate %s: %w", path, err)
+		}
+	}
+	return nil
+}
+
+// mergeImport merges the components extracted by extractImport() with the components already installed in the
+// stack, resolving conflicts according to ImportPolicy and OnImportConflict. The state of the stack is updated
+// with the state of the imported components.
+func (c *Config) mergeImport(importDir string) error {
+	stackBasedir := c.getStackBasedir()
+	c.ImportConflicts = nil
+
+	importedState, err := loadState(importDir)
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the imported stack: %w", err)
+	}
+	oldStackBasedir := importedState.StackDir
+	if oldStackBasedir == stackBasedir {
+		oldStackBasedir = ""
+	}
+	importedState.relocate(stackBasedir)
+	importedVersions, err := getSBOMVersions(importDir)
+	if err != nil {
+		return err
+	}
+	err = c.loadStackState()
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(importDir, "install"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("unable to get the imported components: %w", err)
+	}
+	// The installation directories of the components may be hashed (see StackCfg.HashedInstallPrefixes)
+	importedComps := make(map[string]bool)
+	for name := range importedState.Components {
+		importedComps[name] = true
+	}
+	for name := range importedVersions {
+		importedComps[name] = true
+	}
+	for _, entry := range entries {
+		installDirName := entry.Name()
+		name := getInstallDirComponent(installDirName, importedComps)
+		newName := name
+		if util.PathExists(filepath.Join(stackBasedir, "install", installDirName)) {
+			conflict := ImportConflict{
+				Name:             name,
+				InstalledVersion: c.getInstalledVersion(name),
+				ImportedVersion:  importedVersions[name],
+			}
+			conflict.Resolution, err = c.getImportPolicy(&conflict)
+			if err != nil {
+				return err
+			}
+			if conflict.Resolution == ImportRename {
+				conflict.NewName = getRenamedComponent(stackBasedir, &conflict)
+				newName = conflict.NewName
+			}
+			c.ImportConflicts = append(c.ImportConflicts, conflict)
+			log.Printf("[WARN] %s is already installed (version: %q, imported version: %q), resolution: %s", name, conflict.InstalledVersion, conflict.ImportedVersion, conflict.Resolution)
+			if conflict.Resolution == ImportSkip {
+				continue
+			}
+		}
+
+		err = moveImportedComponent(importDir, stackBasedir, oldStackBasedir, name, installDirName, newName)
+		if err != nil {
+			return err
+		}
+		if compState, ok := importedState.Components[name]; ok {
+			if newName != name {
+				if dir := relocatePath(compState.InstallDir, filepath.Join(stackBasedir, "install", installDirName), filepath.Join(stackBasedir, "install", newName)); dir != "" {
+					compState.InstallDir = dir
+				}
+			}
+			c.state.mutex.Lock()
+			c.state.Components[newName] = compState
+			c.state.mutex.Unlock()
+		}
+	}
+
+	if oldStackBasedir != "" {
+		err = relocateMachO(stackBasedir, oldStackBasedir)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.state.save(stackBasedir)
+	if err != nil {
+		return fmt.Errorf("unable to save the state of the stack: %w", err)
+	}
+
+	fmt.Printf("Stack successfully merged in %s (%d conflict(s))\n", stackBasedir, len(c.ImportConflicts))
+	return nil
+}
@@ -0,0 +1,63 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"path/filepath"
+	"sort"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+)
+
+// getVisibleComponents returns the components whose installation is visible when a component is built in
+// isolation, i.e., its dependencies, their own dependencies and the toolchain components of the stack
+func (c *Config) getVisibleComponents(comp *Component) map[string]bool {
+	visible := make(map[string]bool)
+	toVisit := getDependencies(comp)
+	for idx := range c.Data.StackDefinition.Components {
+		toolchain := &c.Data.StackDefinition.Components[idx]
+		if toolchain.Toolchain && !toolchain.Disabled && toolchain.Name != comp.Name {
+			toVisit = append(toVisit, toolchain.Name)
+		}
+	}
+	for len(toVisit) > 0 {
+		name := toVisit[0]
+		toVisit = toVisit[1:]
+		if visible[name] {
+			continue
+		}
+		visible[name] = true
+		if dep := c.getComponent(name); dep != nil {
+			toVisit = append(toVisit, getDependencies(dep)...)
+		}
+	}
+	return visible
+}
+
+// getIsolation returns how the build commands of a component are isolated when the builds of the stack are
+// isolated (see StackCfg.IsolatedBuilds): only the installations of the components it depends on are visible,
+// read-only, in the installation directory of the stack
+func (c *Config) getIsolation(env *buildenv.Info, comp *Component) *buildenv.Isolation {
+	if !c.Data.StackConfig.IsolatedBuilds {
+		return nil
+	}
+
+	isolation := &buildenv.Isolation{
+		HiddenDirs:   []string{env.InstallDir},
+		WritableDirs: []string{getCompInstallDir(filepath.Dir(env.InstallDir), comp)},
+	}
+	visible := c.getVisibleComponents(comp)
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for name := range visible {
+		if installDir, installed := c.InstalledComponents[name]; installed {
+			isolation.ReadOnlyDirs = append(isolation.ReadOnlyDirs, installDir)
+		}
+	}
+	sort.Strings(isolation.ReadOnlyDirs)
+	return isolation
+}
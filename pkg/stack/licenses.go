@@ -0,0 +1,184 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// LicensesDirname is the name of the directory, in the base directory of the stack, where the license
+	// files of the components are collected, i.e., <stackdir>/licenses/<component>/
+	LicensesDirname = "licenses"
+
+	// UnknownLicense is the license reported for a license file whose license cannot be detected
+	UnknownLicense = "unknown"
+)
+
+// licenseFilePrefixes is the prefixes, in upper case, of the name of the files holding the license of
+// a software, e.g., LICENSE.txt or COPYING.LIB
+var licenseFilePrefixes = []string{"LICENSE", "LICENCE", "COPYING", "COPYRIGHT", "NOTICE"}
+
+// licensePatterns associates a license, identified by its SPDX identifier, to text that must all be found
+// in a license file for the file to be detected as this license. More specific licenses come first.
+var licensePatterns = []struct {
+	license  string
+	patterns []string
+}{
+	{"LGPL-3.0", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"LGPL-2.1", []string{"GNU LESSER GENERAL PUBLIC LICENSE", "Version 2.1"}},
+	{"LGPL-2.0", []string{"GNU LIBRARY GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"AGPL-3.0", []string{"GNU AFFERO GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-3.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 3"}},
+	{"GPL-2.0", []string{"GNU GENERAL PUBLIC LICENSE", "Version 2"}},
+	{"Apache-2.0", []string{"Apache License", "Version 2.0"}},
+	{"MPL-2.0", []string{"Mozilla Public License", "2.0"}},
+	{"BSL-1.0", []string{"Boost Software License"}},
+	{"MIT", []string{"Permission is hereby granted, free of charge"}},
+	{"BSD-3-Clause", []string{"Redistribution and use in source and binary forms", "Neither the name"}},
+	{"BSD-2-Clause", []string{"Redistribution and use in source and binary forms"}},
+	{"Unlicense", []string{"This is free and unencumbered software released into the public domain"}},
+}
+
+// isLicenseFile checks whether a file is a license file based on its name
+func isLicenseFile(name string) bool {
+	upperName := strings.ToUpper(name)
+	for _, prefix := range licenseFilePrefixes {
+		if strings.HasPrefix(upperName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// detectLicense returns the SPDX identifier of the license of a license file, UnknownLicense if the license
+// cannot be detected. An explicit SPDX-License-Identifier tag takes precedence over the text of the file.
+func detectLicense(content string) string {
+	const spdxTag = "SPDX-License-Identifier:"
+	if idx := strings.Index(content, spdxTag); idx != -1 {
+		fields := strings.Fields(content[idx+len(spdxTag):])
+		if len(fields) > 0 {
+			return fields[0]
+		}
+	}
+
+	// Line breaks and indentation of license texts vary
+	text := strings.Join(strings.Fields(content), " ")
+	for _, l := range licensePatterns {
+		found := true
+		for _, pattern := range l.patterns {
+			if !strings.Contains(text, pattern) {
+				found = false
+				break
+			}
+		}
+		if found {
+			return l.license
+		}
+	}
+	return UnknownLicense
+}
+
+// findLicenseFiles returns the path to the license files at the top of a source tree
+func findLicenseFiles(srcDir string) ([]string, error) {
+	entries, err := ioutil.ReadDir(srcDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", srcDir, err)
+	}
+	var files []string
+	for _, entry := range entries {
+		if entry.Mode().IsRegular() && isLicenseFile(entry.Name()) {
+			files = append(files, filepath.Join(srcDir, entry.Name()))
+		}
+	}
+	return files, nil
+}
+
+// getLicensesDir returns the directory where the license files of a component are collected
+func getLicensesDir(stackBasedir string, name string) string {
+	return filepath.Join(stackBasedir, LicensesDirname, name)
+}
+
+// collectLicenses copies the license files of a component from its source tree to the licenses directory of
+// the stack, replacing the ones of a previous installation. When the component is built from a subdirectory
+// of its source tree, the license files at the top of the source tree are collected as well.
+func collectLicenses(stackBasedir string, comp *Component, srcDir string) error {
+	srcDirs := []string{srcDir}
+	if comp.SubDir != "" {
+		topDir := strings.TrimSuffix(srcDir, string(filepath.Separator)+filepath.Clean(comp.SubDir))
+		if topDir != srcDir {
+			srcDirs = append([]string{topDir}, srcDirs...)
+		}
+	}
+
+	var files []string
+	for _, dir := range srcDirs {
+		dirFiles, err := findLicenseFiles(dir)
+		if err != nil {
+			return err
+		}
+		files = append(files, dirFiles...)
+	}
+
+	licensesDir := getLicensesDir(stackBasedir, comp.Name)
+	err := os.RemoveAll(licensesDir)
+	if err != nil {
+		return fmt.Errorf("unable to remove %s: %w", licensesDir, err)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+	err = os.MkdirAll(licensesDir, defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", licensesDir, err)
+	}
+	for _, file := range files {
+		// Files of the subdirectory have precedence over the ones with the same name at the top of the source tree
+		dst := filepath.Join(licensesDir, filepath.Base(file))
+		err = util.CopyFile(file, dst)
+		if err != nil {
+			return fmt.Errorf("unable to copy %s to %s: %w", file, dst, err)
+		}
+	}
+	return nil
+}
+
+// GetLicenses returns the licenses detected in the license files collected for a component of the stack,
+// sorted and without duplicates. The list is empty if no license file was found in the source code of the
+// component.
+func (c *Config) GetLicenses(name string) ([]string, error) {
+	licensesDir := getLicensesDir(c.getStackBasedir(), name)
+	if !util.PathExists(licensesDir) {
+		return nil, nil
+	}
+	entries, err := ioutil.ReadDir(licensesDir)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", licensesDir, err)
+	}
+	detected := make(map[string]bool)
+	for _, entry := range entries {
+		path := filepath.Join(licensesDir, entry.Name())
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read %s: %w", path, err)
+		}
+		detected[detectLicense(string(content))] = true
+	}
+	var licenses []string
+	for license := range detected {
+		licenses = append(licenses, license)
+	}
+	sort.Strings(licenses)
+	return licenses, nil
+}
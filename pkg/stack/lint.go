@@ -0,0 +1,214 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+)
+
+// componentRefKinds is the kinds of references to a component (see UpdateRefs)
+var componentRefKinds = []string{"install_dir", "bin_dir", "lib_dir", "include_dir", "build_dir", "src_dir", "version"}
+
+// LintWarning is a likely mistake found in the definition of a stack (see Lint)
+type LintWarning struct {
+	// Component is the name of the component the warning is about
+	Component string
+
+	// Message is the description of the mistake
+	Message string
+}
+
+// String returns a description of the warning suitable for users
+func (w LintWarning) String() string {
+	return fmt.Sprintf("%s: %s", w.Component, w.Message)
+}
+
+// getRefs returns the references, i.e., the strings between the reference delimiters, in a value of the definition
+// of a component, and whether a reference is not terminated
+func getRefs(value string) ([]string, bool) {
+	var refs []string
+	remaining := value
+	for {
+		startIdx := strings.Index(remaining, RefStartDelimiter)
+		if startIdx == -1 {
+			return refs, false
+		}
+		remaining = remaining[startIdx+len(RefStartDelimiter):]
+		endIdx := strings.Index(remaining, RefEndDelimiter)
+		if endIdx == -1 {
+			return refs, true
+		}
+		refs = append(refs, remaining[:endIdx])
+		remaining = remaining[endIdx+len(RefEndDelimiter):]
+	}
+}
+
+// getRefValues returns the values of the definition of a component that can include references (see UpdateRefs)
+func getRefValues(comp *Component) []string {
+	values := []string{comp.URL, comp.ReleaseTag, comp.BuildEnv, comp.ConfigureParams, comp.Plugin, comp.InstallCmd,
+		comp.PreInstallCmd, comp.PostInstallCmd}
+	values = append(values, comp.Mirrors...)
+	var names []string
+	for name := range comp.MakeVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values = append(values, comp.MakeVars[name])
+	}
+	return values
+}
+
+// lintURL returns what is suspicious about the URL of a component, if anything
+func lintURL(comp *Component) string {
+	url := comp.URL
+	switch {
+	case url == "":
+		return "undefined URL"
+	case strings.IndexFunc(url, func(r rune) bool { return r == ' ' || r == '\t' || r == '\n' }) != -1:
+		return fmt.Sprintf("URL %q includes spaces", url)
+	case strings.HasPrefix(url, RefStartDelimiter), strings.HasPrefix(url, "/"), strings.HasPrefix(url, "git@"):
+		return ""
+	case !strings.Contains(url, "://"):
+		return fmt.Sprintf("URL %s has no scheme, e.g., https://", url)
+	case strings.HasPrefix(url, "http://"):
+		return fmt.Sprintf("URL %s is not secure, https:// is recommended", url)
+	case strings.Contains(url, "github.com/") && (strings.Contains(url, "/blob/") || strings.Contains(url, "/tree/")):
+		return fmt.Sprintf("URL %s is a web page, not a Git repository or a file to download", url)
+	}
+	return ""
+}
+
+// lint returns the likely mistakes in the definition of a stack
+func (def *StackDef) lint() []LintWarning {
+	c := &Config{Data: Stack{StackDefinition: def}}
+	providers := def.getProviders()
+	// getDefinedComponent returns the enabled component a dependency or a reference refers to, if any
+	getDefinedComponent := func(name string) *Component {
+		if names, ok := providers[name]; ok {
+			name = names[0]
+		}
+		if comp := c.getComponent(name); comp != nil && !comp.Disabled {
+			return comp
+		}
+		return nil
+	}
+	order := make(map[string]int)
+	for pos, idx := range c.getInstallOrder() {
+		order[def.Components[idx].Name] = pos
+	}
+	// installedBefore returns whether a component is installed before another one, e.g., a dependency
+	installedBefore := func(comp *Component, other *Component) bool {
+		return comp.External != "" || order[comp.Name] < order[other.Name]
+	}
+
+	var warnings []LintWarning
+	used := make(map[string]bool)
+	for idx := range def.Components {
+		comp := &def.Components[idx]
+		if comp.Disabled {
+			continue
+		}
+		warn := func(format string, args ...interface{}) {
+			warnings = append(warnings, LintWarning{Component: comp.Name, Message: fmt.Sprintf(format, args...)})
+		}
+
+		for _, name := range getDependencies(comp) {
+			dep := getDefinedComponent(name)
+			switch {
+			case dep == nil:
+				warn("depends on %s, which is not defined or disabled", name)
+			case dep == comp:
+				warn("depends on itself")
+			case !installedBefore(dep, comp):
+				warn("depends on %s, which is defined after it", name)
+			}
+			if dep != nil {
+				used[dep.Name] = true
+			}
+		}
+
+		if comp.BuildEnv != "" {
+			if _, err := buildenv.ParseEnv(comp.BuildEnv); err != nil {
+				warn("invalid build environment: %s", err)
+			}
+		}
+
+		for _, value := range getRefValues(comp) {
+			refs, unterminated := getRefs(value)
+			if unterminated {
+				warn("reference in %s has no end delimiter '%s'", value, RefEndDelimiter)
+			}
+			for _, ref := range refs {
+				if ref == "stack_dir" {
+					continue
+				}
+				kind := ""
+				for _, k := range componentRefKinds {
+					if strings.HasSuffix(ref, "_"+k) {
+						kind = k
+						break
+					}
+				}
+				if kind == "" {
+					warn("unsupported type of reference %s", ref)
+					continue
+				}
+				name := strings.TrimSuffix(ref, "_"+kind)
+				target := getDefinedComponent(name)
+				switch {
+				case target == nil:
+					warn("reference %s refers to %s, which is not defined or disabled", ref, name)
+				case kind != "version" && target == comp:
+					warn("reference %s refers to the component itself, which is not installed yet", ref)
+				case kind != "version" && !installedBefore(target, comp):
+					warn("reference %s refers to %s, which is defined after it", ref, name)
+				}
+			}
+		}
+
+		if comp.External == "" {
+			if problem := lintURL(comp); problem != "" {
+				warn("%s", problem)
+			}
+		}
+	}
+
+	for idx := range def.Components {
+		comp := &def.Components[idx]
+		if !comp.Disabled && comp.ConfigId != "" && !used[comp.Name] {
+			warnings = append(warnings, LintWarning{
+				Component: comp.Name,
+				Message:   fmt.Sprintf("configure_id %s is unused, no component depends on %s", comp.ConfigId, comp.Name),
+			})
+		}
+	}
+	return warnings
+}
+
+// Lint checks the definition of a stack, e.g., before it is merged, and returns the likely mistakes it includes:
+// configure identifiers no component uses, dependencies defined after the components depending on them, entries
+// of build environments that are not NAME=value variables, references to components that are defined later or not
+// defined at all and suspicious URLs. Overlays and presets are not applied. An error is returned only when the
+// definition cannot be read or parsed.
+func Lint(defPath string) ([]LintWarning, error) {
+	content, err := ioutil.ReadFile(defPath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", defPath, err)
+	}
+	def := new(StackDef)
+	err = decodeJSON(content, def)
+	if err != nil {
+		return nil, fmt.Errorf("unable to unmarshal content of %s: %w", defPath, err)
+	}
+	return def.lint(), nil
+}
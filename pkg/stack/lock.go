@@ -0,0 +1,219 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+const (
+	// LockFilename is the name of the lock file, in the base directory of a stack, preventing concurrent
+	// operations from modifying the stack at the same time
+	LockFilename = ".stack.lock"
+)
+
+// lockPollInterval is how often an operation waiting for the lock of a stack checks whether it was released
+var lockPollInterval = time.Second
+
+// lockInfo is the content of the lock file of a stack, identifying the operation holding the lock
+type lockInfo struct {
+	// PID is the process identifier of the process holding the lock
+	PID int `json:"pid"`
+
+	// Host is the name of the host the process holding the lock runs on
+	Host string `json:"host"`
+
+	// Operation is the operation holding the lock, e.g., install
+	Operation string `json:"operation"`
+
+	// Time is when the lock was acquired
+	Time time.Time `json:"time"`
+}
+
+// isStale returns whether the process holding a lock is not running anymore. Locks held from other hosts are
+// never considered stale since their process cannot be checked.
+func (info *lockInfo) isStale() bool {
+	host, _ := os.Hostname()
+	if info.Host != host {
+		return false
+	}
+	if info.PID <= 0 {
+		return true
+	}
+	process, err := os.FindProcess(info.PID)
+	if err != nil {
+		return true
+	}
+	// Signal 0 only checks whether the process exists; EPERM means it exists but belongs to another user
+	err = process.Signal(syscall.Signal(0))
+	return err != nil && err != syscall.EPERM
+}
+
+// readLockInfo reads the content of a lock file
+func readLockInfo(lockPath string) (*lockInfo, error) {
+	content, err := ioutil.ReadFile(lockPath)
+	if err != nil {
+		return nil, err
+	}
+	info := new(lockInfo)
+	err = json.Unmarshal(content, info)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode %s: %w", lockPath, err)
+	}
+	return info, nil
+}
+
+// tryLock creates the lock file of a stack, returning false when it already exists
+func tryLock(lockPath string, operation string) (bool, error) {
+	f, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("unable to create %s: %w", lockPath, err)
+	}
+	defer f.Close()
+
+	info := lockInfo{
+		PID:       os.Getpid(),
+		Operation: operation,
+		Time:      time.Now(),
+	}
+	info.Host, _ = os.Hostname()
+	content, err := json.Marshal(info)
+	if err != nil {
+		os.Remove(lockPath)
+		return false, fmt.Errorf("unable to encode the content of %s: %w", lockPath, err)
+	}
+	_, err = f.Write(content)
+	if err != nil {
+		os.Remove(lockPath)
+		return false, fmt.Errorf("unable to write %s: %w", lockPath, err)
+	}
+	return true, nil
+}
+
+// removeStaleLock removes the stale lock of a stack. Since other processes may remove it and acquire the lock
+// concurrently, the lock file is first atomically moved to a unique name and only removed if it is still the stale
+// lock; it is restored otherwise.
+func removeStaleLock(lockPath string, stale *lockInfo) error {
+	stalePath := fmt.Sprintf("%s.stale.%d.%d", lockPath, os.Getpid(), time.Now().UnixNano())
+	err := os.Rename(lockPath, stalePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// Another process already removed it
+			return nil
+		}
+		return fmt.Errorf("unable to remove stale lock %s: %w", lockPath, err)
+	}
+	defer os.Remove(stalePath)
+
+	info, err := readLockInfo(stalePath)
+	if err == nil && info.PID == stale.PID && info.Host == stale.Host && info.Time.Equal(stale.Time) {
+		return nil
+	}
+	// The lock was acquired by another process in the meantime, linking it back fails if yet another process
+	// acquired the lock since it was moved
+	err = os.Link(stalePath, lockPath)
+	if err != nil {
+		return fmt.Errorf("unable to restore lock %s: %w", lockPath, err)
+	}
+	return nil
+}
+
+// acquireLock acquires the lock of the stack whose base directory is stackBasedir for an operation, waiting for
+// the operation holding it to release it for at most timeout, forever when timeout is negative. Stale locks, i.e.,
+// left by processes that are not running anymore, are removed.
+func acquireLock(stackBasedir string, operation string, timeout time.Duration) (string, error) {
+	lockPath := filepath.Join(stackBasedir, LockFilename)
+	start := time.Now()
+	waiting := false
+	for {
+		locked, err := tryLock(lockPath, operation)
+		if err != nil {
+			return "", err
+		}
+		if locked {
+			return lockPath, nil
+		}
+
+		info, err := readLockInfo(lockPath)
+		if err != nil {
+			if os.IsNotExist(err) {
+				// The lock was just released
+				continue
+			}
+			// The lock file may be being written, it is checked again after waiting
+			info = nil
+		}
+		if info != nil && info.isStale() {
+			log.Printf("[WARN] removing the stale lock of %s, held by process %d for %s since %s", stackBasedir, info.PID, info.Operation, info.Time.Format(time.RFC3339))
+			err = removeStaleLock(lockPath, info)
+			if err != nil {
+				return "", err
+			}
+			continue
+		}
+
+		if timeout >= 0 && time.Since(start) >= timeout {
+			if info == nil {
+				return "", fmt.Errorf("stack %s is locked (%s)", stackBasedir, lockPath)
+			}
+			return "", fmt.Errorf("stack %s is locked by process %d on %s for %s since %s (%s)", stackBasedir, info.PID, info.Host, info.Operation, info.Time.Format(time.RFC3339), lockPath)
+		}
+		if !waiting && info != nil {
+			log.Printf("-> Waiting for process %d on %s to complete %s of stack %s", info.PID, info.Host, info.Operation, stackBasedir)
+			waiting = true
+		}
+		time.Sleep(lockPollInterval)
+	}
+}
+
+// lock acquires the lock of the stack for an operation, e.g., install (see LockTimeout), and returns the function
+// releasing it. Operations called while the stack is already locked by the same configuration, e.g., modulefiles
+// generated during an installation, share the lock.
+func (c *Config) lock(operation string) (func(), error) {
+	c.mutex.Lock()
+	if c.lockCount > 0 {
+		c.lockCount++
+		c.mutex.Unlock()
+		return c.unlock, nil
+	}
+	c.mutex.Unlock()
+
+	lockPath, err := acquireLock(c.getStackBasedir(), operation, c.LockTimeout)
+	if err != nil {
+		return nil, err
+	}
+	c.mutex.Lock()
+	c.lockPath = lockPath
+	c.lockCount = 1
+	c.mutex.Unlock()
+	return c.unlock, nil
+}
+
+// unlock releases the lock of the stack acquired with lock()
+func (c *Config) unlock() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lockCount--
+	if c.lockCount > 0 {
+		return
+	}
+	err := os.Remove(c.lockPath)
+	if err != nil {
+		log.Printf("[WARN] unable to release the lock of the stack: %s", err)
+	}
+	c.lockPath = ""
+}
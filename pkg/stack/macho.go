@@ -0,0 +1,183 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+)
+
+// relocatePath returns the path p once the directory oldDir is moved to newDir, an empty string if p is not in oldDir
+func relocatePath(p string, oldDir string, newDir string) string {
+	if p != oldDir && !strings.HasPrefix(p, oldDir+"/") {
+		return ""
+	}
+	return newDir + strings.TrimPrefix(p, oldDir)
+}
+
+// runOtool runs otool with an option, e.g., -L, on a file and returns its output
+func runOtool(otoolBin string, option string, path string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(otoolBin, option, path)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return "", fmt.Errorf("otool %s %s failed: %w - stdout: %s - stderr: %s", option, path, err, stdout.String(), stderr.String())
+	}
+	return stdout.String(), nil
+}
+
+// parseOtoolID returns the install name of a library from the output of otool -D, an empty string for binaries
+func parseOtoolID(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	if len(lines) < 2 {
+		return ""
+	}
+	return strings.TrimSpace(lines[len(lines)-1])
+}
+
+// parseOtoolLibs returns the libraries a binary or library depends on from the output of otool -L
+func parseOtoolLibs(output string) []string {
+	var libs []string
+	for _, line := range strings.Split(output, "\n") {
+		// The first line is the name of the file itself, dependencies are indented
+		if !strings.HasPrefix(line, "\t") {
+			continue
+		}
+		libs = append(libs, strings.TrimSpace(strings.Split(line, " (")[0]))
+	}
+	return libs
+}
+
+// parseOtoolRpaths returns the run-time search paths of a binary or library from the output of otool -l,
+// i.e., the path of the LC_RPATH load commands
+func parseOtoolRpaths(output string) []string {
+	var rpaths []string
+	inRpath := false
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch {
+		case fields[0] == "cmd":
+			inRpath = fields[1] == "LC_RPATH"
+		case fields[0] == "path" && inRpath:
+			rpaths = append(rpaths, fields[1])
+			inRpath = false
+		}
+	}
+	return rpaths
+}
+
+// getMachORelocationArgs returns the install_name_tool arguments to relocate a Mach-O file from oldDir to newDir,
+// based on its install name, the libraries it depends on and its run-time search paths
+func getMachORelocationArgs(id string, libs []string, rpaths []string, oldDir string, newDir string) []string {
+	var args []string
+	if newID := relocatePath(id, oldDir, newDir); newID != "" {
+		args = append(args, "-id", newID)
+	}
+	for _, lib := range libs {
+		if lib == id {
+			continue
+		}
+		if newLib := relocatePath(lib, oldDir, newDir); newLib != "" {
+			args = append(args, "-change", lib, newLib)
+		}
+	}
+	for _, rpath := range rpaths {
+		if newRpath := relocatePath(rpath, oldDir, newDir); newRpath != "" {
+			args = append(args, "-rpath", rpath, newRpath)
+		}
+	}
+	return args
+}
+
+// relocateMachO rewrites, with install_name_tool, the install names, dependencies and run-time search paths of
+// the macOS binaries and libraries of a stack that was moved from oldStackBasedir to stackBasedir, e.g., when
+// imported on another system. Note that the new paths can be longer than the old ones only if the files were
+// linked with -headerpad_max_install_names.
+func relocateMachO(stackBasedir string, oldStackBasedir string) error {
+	var files []string
+	installDir := filepath.Join(stackBasedir, "install")
+	err := filepath.Walk(installDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && buildenv.IsMachO(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to get the files of %s: %w", installDir, err)
+	}
+	if len(files) == 0 {
+		return nil
+	}
+
+	installNameToolBin, err := exec.LookPath("install_name_tool")
+	if err != nil {
+		log.Printf("[WARN] install_name_tool is not available, the macOS binaries and libraries of the stack still refer to %s", oldStackBasedir)
+		return nil
+	}
+	otoolBin, err := exec.LookPath("otool")
+	if err != nil {
+		return fmt.Errorf("otool is required to relocate the macOS binaries and libraries: %w", err)
+	}
+	codesignBin, _ := exec.LookPath("codesign")
+
+	for _, f := range files {
+		idOutput, err := runOtool(otoolBin, "-D", f)
+		if err != nil {
+			return err
+		}
+		libsOutput, err := runOtool(otoolBin, "-L", f)
+		if err != nil {
+			return err
+		}
+		loadCmdsOutput, err := runOtool(otoolBin, "-l", f)
+		if err != nil {
+			return err
+		}
+		args := getMachORelocationArgs(parseOtoolID(idOutput), parseOtoolLibs(libsOutput), parseOtoolRpaths(loadCmdsOutput), oldStackBasedir, stackBasedir)
+		if len(args) == 0 {
+			continue
+		}
+
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(installNameToolBin, append(args, f)...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("unable to relocate %s: %w - stdout: %s - stderr: %s", f, err, stdout.String(), stderr.String())
+		}
+
+		// Modified files must be signed again to run on Apple Silicon
+		if codesignBin != "" {
+			stdout.Reset()
+			stderr.Reset()
+			cmd = exec.Command(codesignBin, "--force", "--sign", "-", f)
+			cmd.Stdout = &stdout
+			cmd.Stderr = &stderr
+			err = cmd.Run()
+			if err != nil {
+				return fmt.Errorf("unable to sign %s: %w - stdout: %s - stderr: %s", f, err, stdout.String(), stderr.String())
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_software_build/internal/pkg/module"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// ToolchainFamily is the family of the modulefiles of the toolchains of a compiler matrix, so that loading the
+	// modulefile of a toolchain swaps the modulefiles of the components built with another toolchain
+	ToolchainFamily = "compiler"
+)
+
+// Toolchain is a toolchain a stack is built with in a compiler matrix (see StackCfg.Toolchains). The components
+// built with a toolchain are installed in <stack>/<toolchain>, their modulefiles being made available by the
+// modulefile of the toolchain in <stack>/modulefiles, i.e., hierarchical modules.
+type Toolchain struct {
+	// Name is the name of the toolchain, e.g., gcc-12, which is also the name of its directory and modulefile
+	Name string `json:"name"`
+
+	// BuildEnv is the environment selecting the toolchain, e.g., CC=gcc-12 CXX=g++-12 FC=gfortran-12, the build
+	// environment of the components taking precedence. Values containing spaces must be quoted.
+	BuildEnv string `json:"build_env"`
+
+	// Modules is the modules loaded by the modulefile of the toolchain, e.g., ["nvhpc/23.9"], for toolchains
+	// that are themselves provided by modules (optional)
+	Modules []string `json:"modules"`
+}
+
+// getEnv returns the environment selecting the toolchain
+func (t *Toolchain) getEnv() (buildenv.Env, error) {
+	toolchainEnv, err := buildenv.ParseEnv(t.BuildEnv)
+	if err != nil {
+		return nil, fmt.Errorf("invalid build environment for toolchain %s: %w", t.Name, err)
+	}
+	return toolchainEnv, nil
+}
+
+// getMatrixBasedir returns the base directory of the stack, which includes the directory of each of its
+// toolchains when it is built with a compiler matrix
+func (c *Config) getMatrixBasedir() string {
+	return filepath.Join(c.Data.StackConfig.InstallDir, c.Data.StackDefinition.Name)
+}
+
+// getSrcBasedir returns the directory including the source code of the components of the stack, the base
+// directory of the compiler matrix when the stack is built with one of its toolchains since the source code is
+// shared by all the toolchains
+func (c *Config) getSrcBasedir() string {
+	if c.toolchain != nil {
+		return c.getMatrixBasedir()
+	}
+	return c.getStackBasedir()
+}
+
+// checkToolchains makes sure the toolchains of the compiler matrix can be installed side by side
+func (c *Config) checkToolchains() error {
+	toolchains := c.Data.StackConfig.Toolchains
+	if len(toolchains) == 0 {
+		return fmt.Errorf("no toolchain is defined for stack %s", c.Data.StackDefinition.Name)
+	}
+	// The directories of the toolchains are next to the source code and modulefiles shared by the matrix
+	reserved := map[string]bool{"src": true, "modulefiles": true, "modulefiles_lua": true}
+	names := make(map[string]bool)
+	for _, toolchain := range toolchains {
+		if toolchain.Name == "" || strings.ContainsRune(toolchain.Name, filepath.Separator) || toolchain.Name == "." || toolchain.Name == ".." {
+			return fmt.Errorf("invalid toolchain name: %q", toolchain.Name)
+		}
+		if reserved[toolchain.Name] {
+			return fmt.Errorf("invalid toolchain name %s: reserved name", toolchain.Name)
+		}
+		if names[toolchain.Name] {
+			return fmt.Errorf("toolchain %s is defined more than once", toolchain.Name)
+		}
+		names[toolchain.Name] = true
+	}
+	return nil
+}
+
+// GetToolchainConfig returns the configuration of the stack built with one of the toolchains of its compiler
+// matrix, e.g., to install a single component or to query the provenance of the components built with it
+func (c *Config) GetToolchainConfig(name string) (*Config, error) {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return nil, fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+	err := c.checkToolchains()
+	if err != nil {
+		return nil, err
+	}
+
+	for idx := range c.Data.StackConfig.Toolchains {
+		toolchain := c.Data.StackConfig.Toolchains[idx]
+		if toolchain.Name != name {
+			continue
+		}
+		stackDef := *c.Data.StackDefinition
+		stackDef.Components = append([]Component{}, c.Data.StackDefinition.Components...)
+		stackCfg := *c.Data.StackConfig
+		return &Config{
+			DefFilePath:        c.DefFilePath,
+			DefFileSHA256:      c.DefFileSHA256,
+			OverlayFilePaths:   c.OverlayFilePaths,
+			ConfigFilePath:     c.ConfigFilePath,
+			ConfigFileSHA256:   c.ConfigFileSHA256,
+			RemoteCacheDir:     c.RemoteCacheDir,
+			RemoteCABundle:     c.RemoteCABundle,
+			Loaded:             c.Loaded,
+			KeepGoing:          c.KeepGoing,
+			OnFailure:          c.OnFailure,
+			SourceOverrides:    c.SourceOverrides,
+			FetchJobs:          c.FetchJobs,
+			LockTimeout:        c.LockTimeout,
+			BuildTimesFile:     c.BuildTimesFile,
+			OnProgress:         c.OnProgress,
+			EventStream:        c.EventStream,
+			Notifiers:          c.Notifiers,
+			PreStack:           c.PreStack,
+			PostStack:          c.PostStack,
+			OnComponentFailure: c.OnComponentFailure,
+			Data: Stack{
+				Private:         c.Data.Private,
+				BuildEnv:        append(buildenv.Env{}, c.Data.BuildEnv...),
+				StackConfig:     &stackCfg,
+				StackDefinition: &stackDef,
+			},
+			bundle:    c.bundle,
+			toolchain: &toolchain,
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown toolchain %s", name)
+}
+
+// InstallMatrix installs the stack once per toolchain of its compiler matrix (see StackCfg.Toolchains), in
+// parallel installation trees, i.e., <stack>/<toolchain>. The source code of the components is downloaded once
+// and shared by all the toolchains. All the toolchains are installed even if some fail, the errors being
+// reported at once.
+func (c *Config) InstallMatrix() error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+	err := c.checkToolchains()
+	if err != nil {
+		return err
+	}
+
+	var errs []string
+	for _, toolchain := range c.Data.StackConfig.Toolchains {
+		log.Printf("-> Installing stack %s with toolchain %s", c.Data.StackDefinition.Name, toolchain.Name)
+		toolchainCfg, err := c.GetToolchainConfig(toolchain.Name)
+		if err != nil {
+			return err
+		}
+		err = toolchainCfg.InstallStack()
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %s", toolchain.Name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("unable to install the stack with all its toolchains:\n%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+// GenerateMatrixModules generates the modulefiles of the components of the stack for each toolchain of its
+// compiler matrix, in <stack>/<toolchain>/modulefiles, and the modulefile of each toolchain in
+// <stack>/modulefiles. Loading the modulefile of a toolchain makes the modulefiles of the components built with
+// it available, the modulefiles of the toolchains being in the same family so that module systems supporting
+// families swap them.
+func (c *Config) GenerateMatrixModules(copyright, customEnvVarPrefix string, format ModuleFormat) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+	err := c.checkToolchains()
+	if err != nil {
+		return err
+	}
+
+	matrixBasedir := c.getMatrixBasedir()
+	modulefileDirs, err := getModulefileDirs(matrixBasedir, format)
+	if err != nil {
+		return err
+	}
+	for _, modulefileDir := range modulefileDirs {
+		if !util.PathExists(modulefileDir) {
+			err := os.MkdirAll(modulefileDir, defaultPermission)
+			if err != nil {
+				return fmt.Errorf("unable to create %s: %w", modulefileDir, err)
+			}
+		}
+	}
+
+	for _, toolchain := range c.Data.StackConfig.Toolchains {
+		toolchainCfg, err := c.GetToolchainConfig(toolchain.Name)
+		if err != nil {
+			return err
+		}
+		toolchainBasedir := toolchainCfg.getStackBasedir()
+		if !util.PathExists(toolchainBasedir) {
+			log.Printf("[WARN] stack %s is not installed with toolchain %s, skipping", c.Data.StackDefinition.Name, toolchain.Name)
+			continue
+		}
+		err = toolchainCfg.GenerateModules(copyright, customEnvVarPrefix, format)
+		if err != nil {
+			return fmt.Errorf("unable to generate the modulefiles of toolchain %s: %w", toolchain.Name, err)
+		}
+
+		toolchainModulefileDirs, err := getModulefileDirs(toolchainBasedir, format)
+		if err != nil {
+			return err
+		}
+		for dialect, modulefileDir := range modulefileDirs {
+			modulefile := &module.Modulefile{
+				Name: toolchain.Name,
+				Stack: module.StackInfo{
+					Name:   c.Data.StackDefinition.Name,
+					System: c.Data.StackDefinition.System,
+					Type:   c.Data.StackDefinition.Type,
+					Dir:    matrixBasedir,
+				},
+				Copyright: copyright,
+				Requires:  toolchain.Modules,
+				Family:    ToolchainFamily,
+				Whatis:    []string{"Toolchain: " + toolchain.Name},
+				EnvLayout: map[string][]string{"MODULEPATH": {toolchainModulefileDirs[dialect]}},
+			}
+			err = module.Generate(modulefileDir, dialect, modulefile)
+			if err != nil {
+				return fmt.Errorf("module.Generate() failed: %w", err)
+			}
+		}
+	}
+
+	for _, modulefileDir := range modulefileDirs {
+		err = c.applyOwnership(modulefileDir)
+		if err != nil {
+			return fmt.Errorf("unable to set the ownership of the modulefiles: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+// Metadata is the descriptive metadata of a component, from its definition, so that stacks are self-documenting
+type Metadata struct {
+	// Description is a short description of the component
+	Description string `json:"description,omitempty"`
+
+	// Homepage is the URL of the website of the component
+	Homepage string `json:"homepage,omitempty"`
+
+	// License is the license of the component as declared in the stack definition
+	License string `json:"license,omitempty"`
+
+	// Maintainer is who maintains the component in the stack
+	Maintainer string `json:"maintainer,omitempty"`
+}
+
+// GetMetadata returns the metadata of a component
+func (comp *Component) GetMetadata() Metadata {
+	return Metadata{
+		Description: comp.Description,
+		Homepage:    comp.Homepage,
+		License:     comp.License,
+		Maintainer:  comp.Maintainer,
+	}
+}
+
+// whatis returns the lines describing a component in its modulefile, i.e., displayed by 'module whatis'
+func (m Metadata) whatis() []string {
+	var lines []string
+	fields := []struct {
+		name  string
+		value string
+	}{
+		{"Description", m.Description},
+		{"Homepage", m.Homepage},
+		{"License", m.License},
+		{"Maintainer", m.Maintainer},
+	}
+	for _, field := range fields {
+		if field.value != "" {
+			lines = append(lines, field.name+": "+field.value)
+		}
+	}
+	return lines
+}
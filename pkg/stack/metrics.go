@@ -0,0 +1,87 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"context"
+	"log"
+	"path/filepath"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/metrics"
+)
+
+// getTraceContext returns the context holding the span of the installation of the stack, the spans of the
+// installations of the components being its children
+func (c *Config) getTraceContext() context.Context {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	if c.traceContext == nil {
+		return context.Background()
+	}
+	return c.traceContext
+}
+
+// getStackName returns the name of the stack, empty when its definition is not loaded
+func (c *Config) getStackName() string {
+	if c.Data.StackDefinition == nil {
+		return ""
+	}
+	return c.Data.StackDefinition.Name
+}
+
+// recordComponentMetrics records the installation of a component, started at start, and ends its span
+func (c *Config) recordComponentMetrics(span metrics.Span, comp *Component, start time.Time, err error) {
+	status := StatusInstalled
+	if err != nil {
+		status = StatusFailed
+		span.RecordError(err)
+	} else if comp.External != "" {
+		status = StatusExternal
+	}
+	span.SetAttribute("stack", c.getStackName())
+	span.SetAttribute("component", comp.Name)
+	span.SetAttribute("version", comp.Version)
+	span.SetAttribute("status", status)
+	span.End()
+
+	metrics.Default.Inc(metrics.ComponentInstallsTotal, metrics.Labels{"component": comp.Name, "status": status})
+	if err == nil && comp.External == "" {
+		metrics.Default.ObserveSince(metrics.ComponentInstallDurationSeconds, metrics.Labels{"component": comp.Name}, start)
+	}
+}
+
+// recordStackMetrics records the installation of the stack, started at start, ends its span and writes the
+// metrics to the metrics file of the stack, if any (see StackCfg.MetricsFile)
+func (c *Config) recordStackMetrics(span metrics.Span, start time.Time, err error) {
+	status := "success"
+	if err != nil {
+		status = "failure"
+		span.RecordError(err)
+	}
+	stackName := c.getStackName()
+	span.SetAttribute("stack", stackName)
+	if c.toolchain != nil {
+		span.SetAttribute("toolchain", c.toolchain.Name)
+	}
+	span.End()
+
+	metrics.Default.Inc(metrics.StackInstallsTotal, metrics.Labels{"stack": stackName, "status": status})
+	metrics.Default.ObserveSince(metrics.StackInstallDurationSeconds, metrics.Labels{"stack": stackName}, start)
+
+	if c.Data.StackConfig == nil || c.Data.StackConfig.MetricsFile == "" {
+		return
+	}
+	metricsFile := c.Data.StackConfig.MetricsFile
+	if !filepath.IsAbs(metricsFile) && c.ConfigFilePath != "" {
+		metricsFile = filepath.Join(filepath.Dir(c.ConfigFilePath), metricsFile)
+	}
+	writeErr := metrics.Default.WriteFile(metricsFile)
+	if writeErr != nil {
+		log.Printf("[WARN] unable to write the metrics of stack %s: %s", stackName, writeErr)
+	}
+}
@@ -0,0 +1,149 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_software_build/internal/pkg/module"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// ModulefileProblem is a problem found in the modulefile of a component (see ValidateModules)
+type ModulefileProblem struct {
+	// Component is the name of the component
+	Component string
+
+	// Dialect is the dialect of the modulefile, i.e., tcl or lua
+	Dialect string
+
+	// Problem is the description of the problem, e.g., the error of the module command
+	Problem string
+}
+
+// String returns a description of the problem suitable for users
+func (p ModulefileProblem) String() string {
+	return fmt.Sprintf("%s modulefile of %s: %s", p.Dialect, p.Component, p.Problem)
+}
+
+// getModuleCmd returns the module command loading the modulefiles of a dialect (see StackCfg.ModuleCmd)
+func (c *Config) getModuleCmd(dialect module.Dialect) ([]string, error) {
+	if c.Data.StackConfig.ModuleCmd != "" {
+		return strings.Fields(c.Data.StackConfig.ModuleCmd), nil
+	}
+	return module.FindModuleCmd(dialect)
+}
+
+// checkModuleEnv compares the environment a modulefile defines with the environment it is meant to define,
+// returning the differences
+func checkModuleEnv(env map[string]string, envVars map[string]string, envLayout map[string][]string) []string {
+	var problems []string
+	var names []string
+	for name := range envVars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if value, ok := env[name]; !ok || value != envVars[name] {
+			problems = append(problems, fmt.Sprintf("%s is %q instead of %q", name, value, envVars[name]))
+		}
+	}
+
+	names = nil
+	for name := range envLayout {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		dirs := make(map[string]bool)
+		for _, dir := range strings.Split(env[name], ":") {
+			dirs[dir] = true
+		}
+		for _, dir := range envLayout[name] {
+			if !dirs[dir] {
+				problems = append(problems, fmt.Sprintf("%s does not include %s", name, dir))
+			}
+		}
+	}
+	return problems
+}
+
+// validateModules loads the modulefiles of the components in a clean shell and checks the environment they define
+func (c *Config) validateModules(customEnvVarPrefix string, format ModuleFormat) ([]ModulefileProblem, error) {
+	stackBasedir := c.getStackBasedir()
+	modulefileDirs, err := getModulefileDirs(stackBasedir, format)
+	if err != nil {
+		return nil, err
+	}
+	var dialects []string
+	for dialect := range modulefileDirs {
+		dialects = append(dialects, string(dialect))
+	}
+	sort.Strings(dialects)
+
+	var problems []ModulefileProblem
+	for _, dialect := range dialects {
+		modulefileDir := modulefileDirs[module.Dialect(dialect)]
+		moduleCmd, err := c.getModuleCmd(module.Dialect(dialect))
+		if err != nil {
+			return nil, err
+		}
+		for _, comp := range c.Data.StackDefinition.Components {
+			if comp.Disabled {
+				continue
+			}
+			modulefilePath := filepath.Join(modulefileDir, comp.Name)
+			if module.Dialect(dialect) == module.DialectLua {
+				modulefilePath += ".lua"
+			}
+			if !util.FileExists(modulefilePath) {
+				if c.Data.StackConfig.GateModulesOnSanityCheck && c.state.sanityCheckFailed(comp.Name) {
+					continue
+				}
+				problems = append(problems, ModulefileProblem{Component: comp.Name, Dialect: dialect, Problem: "no modulefile"})
+				continue
+			}
+
+			env, err := module.Eval(moduleCmd, modulefileDir, comp.Name)
+			if err != nil {
+				problems = append(problems, ModulefileProblem{Component: comp.Name, Dialect: dialect, Problem: err.Error()})
+				continue
+			}
+			envVars, envLayout := getModuleEnv(stackBasedir, customEnvVarPrefix, &comp)
+			for name, value := range buildenv.GetSanitizerEnv(c.getSanitizers(&comp)) {
+				envVars[name] = value
+			}
+			for _, problem := range checkModuleEnv(env, envVars, envLayout) {
+				problems = append(problems, ModulefileProblem{Component: comp.Name, Dialect: dialect, Problem: problem})
+			}
+		}
+	}
+	return problems, nil
+}
+
+// ValidateModules loads the modulefiles of all the components of the stack, in a given format, in a clean shell
+// with a module command, e.g., modulecmd or Lmod (see StackCfg.ModuleCmd), and checks that the environment they
+// define is the environment generated by GenerateModules() with the same prefix, so that broken modulefiles, e.g.,
+// because of a syntax error in a template, are detected before users load them. It returns the problems found.
+func (c *Config) ValidateModules(customEnvVarPrefix string, format ModuleFormat) ([]ModulefileProblem, error) {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return nil, fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+
+	err := c.loadStackState()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+	return c.validateModules(customEnvVarPrefix, format)
+}
@@ -0,0 +1,368 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/builder"
+)
+
+const (
+	// EventStackStarted is the event sent when the installation of a stack starts
+	EventStackStarted = "stack_started"
+
+	// EventComponentFailed is the event sent when the installation of a component of a stack fails
+	EventComponentFailed = "component_failed"
+
+	// EventStackCompleted is the event sent when the installation of a stack completes, successfully or not
+	EventStackCompleted = "stack_completed"
+
+	// eventLogLines is the maximum number of lines of the log excerpts of the events
+	eventLogLines = 30
+
+	// eventLogSize is the maximum size of the log excerpts of the events
+	eventLogSize = 4096
+)
+
+// defaultNotifierEvents is the events the notifiers pinging people are sent by default, i.e., not the start of
+// the installations
+var defaultNotifierEvents = []string{EventComponentFailed, EventStackCompleted}
+
+// Notifier is notified of the lifecycle events of the installations of the stack, e.g., to ping the engineer
+// responsible for the stack (see Config.Notifiers). Webhook, SlackNotifier and SMTPNotifier are the reference
+// implementations. Errors are logged but do not fail the installation.
+type Notifier interface {
+	Notify(event *Event) error
+}
+
+// EventComponent is the result of the installation of a component in the stack_completed events
+type EventComponent struct {
+	// Name is the name of the component
+	Name string `json:"name"`
+
+	// Status is the status of the component, e.g., StatusInstalled
+	Status string `json:"status"`
+
+	// Error is the error that made the installation of the component fail, if any
+	Error string `json:"error,omitempty"`
+}
+
+// Event is a lifecycle event of the installation of a stack, e.g., the failure of a component
+type Event struct {
+	// Event is the type of the event, e.g., EventStackStarted
+	Event string `json:"event"`
+
+	// Time is when the event occurred
+	Time time.Time `json:"time"`
+
+	// Stack is the name of the stack
+	Stack string `json:"stack"`
+
+	// Toolchain is the toolchain of the compiler matrix the stack is built with, if any
+	Toolchain string `json:"toolchain,omitempty"`
+
+	// Host is the name of the host installing the stack
+	Host string `json:"host,omitempty"`
+
+	// Component is the name of the component that failed, for component_failed events
+	Component string `json:"component,omitempty"`
+
+	// Version is the version of the component that failed, if known
+	Version string `json:"version,omitempty"`
+
+	// Status is the result of the installation of the stack, i.e., success or failure, for stack_completed
+	// events
+	Status string `json:"status,omitempty"`
+
+	// Error is the error that made the installation fail, if any
+	Error string `json:"error,omitempty"`
+
+	// LogExcerpt is the end of the error, including the output of the command that failed, if any
+	LogExcerpt string `json:"log_excerpt,omitempty"`
+
+	// Stage is the stage of the installation of the component that failed, e.g., compile, for component_failed
+	// events (see builder.BuildError)
+	Stage string `json:"stage,omitempty"`
+
+	// LogPath is the path to the file recording the commands executed to build the component that failed, if any
+	LogPath string `json:"log_path,omitempty"`
+
+	// Components is the result of the installation of each component, for stack_completed events
+	Components []EventComponent `json:"components,omitempty"`
+
+	// ReportURL is the link to the installation report, for stack_completed events (see Webhook.ReportURL)
+	ReportURL string `json:"report_url,omitempty"`
+}
+
+// String returns a one-line summary of the event, e.g., for chat messages or email subjects
+func (e *Event) String() string {
+	stack := e.Stack
+	if e.Toolchain != "" {
+		stack += " (" + e.Toolchain + ")"
+	}
+	switch e.Event {
+	case EventStackStarted:
+		return fmt.Sprintf("Installation of stack %s started on %s", stack, e.Host)
+	case EventComponentFailed:
+		return fmt.Sprintf("Installation of %s failed in stack %s on %s", e.Component, stack, e.Host)
+	case EventStackCompleted:
+		counts := make(map[string]int)
+		for _, comp := range e.Components {
+			counts[comp.Status]++
+		}
+		var details []string
+		for _, status := range []string{StatusInstalled, StatusExternal, StatusFailed, StatusSkipped} {
+			if counts[status] > 0 {
+				details = append(details, fmt.Sprintf("%d %s", counts[status], status))
+			}
+		}
+		summary := fmt.Sprintf("Installation of stack %s on %s completed: %s", stack, e.Host, e.Status)
+		if len(details) > 0 {
+			summary += " (" + strings.Join(details, ", ") + ")"
+		}
+		return summary
+	}
+	return fmt.Sprintf("%s: stack %s on %s", e.Event, stack, e.Host)
+}
+
+// details returns the details of the event following its summary, e.g., in the body of emails
+func (e *Event) details() string {
+	var sb strings.Builder
+	if e.Error != "" {
+		sb.WriteString("Error: " + e.Error + "\n")
+	}
+	if e.Stage != "" {
+		sb.WriteString("Stage: " + e.Stage + "\n")
+	}
+	if e.LogPath != "" {
+		sb.WriteString("Log: " + e.LogPath + "\n")
+	}
+	for _, comp := range e.Components {
+		sb.WriteString("- " + comp.Name + ": " + comp.Status)
+		if comp.Error != "" {
+			sb.WriteString(" (" + comp.Error + ")")
+		}
+		sb.WriteString("\n")
+	}
+	if e.ReportURL != "" {
+		sb.WriteString("Report: " + e.ReportURL + "\n")
+	}
+	if e.LogExcerpt != "" && e.LogExcerpt != e.Error {
+		sb.WriteString("\n" + e.LogExcerpt + "\n")
+	}
+	return sb.String()
+}
+
+// wantsEvent returns whether a notifier selecting events is notified of an event, all the events being selected
+// when events is empty
+func wantsEvent(events []string, event string) bool {
+	if len(events) == 0 {
+		return true
+	}
+	for _, e := range events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+// getLogExcerpt returns the last lines of an error, which include the output of the command that failed, if any
+func getLogExcerpt(err error) string {
+	lines := strings.Split(strings.TrimSpace(err.Error()), "\n")
+	if len(lines) > eventLogLines {
+		lines = lines[len(lines)-eventLogLines:]
+	}
+	excerpt := strings.Join(lines, "\n")
+	if len(excerpt) > eventLogSize {
+		excerpt = excerpt[len(excerpt)-eventLogSize:]
+	}
+	return excerpt
+}
+
+// getNotifiers returns all the notifiers of the stack, i.e., its webhooks and the notifiers set in code
+func (c *Config) getNotifiers() []Notifier {
+	var notifiers []Notifier
+	if c.Data.StackConfig != nil {
+		for idx := range c.Data.StackConfig.Webhooks {
+			notifiers = append(notifiers, &c.Data.StackConfig.Webhooks[idx])
+		}
+	}
+	return append(notifiers, c.Notifiers...)
+}
+
+// notify sends an event to all the notifiers of the stack (see StackCfg.Webhooks and Config.Notifiers)
+func (c *Config) notify(event *Event) {
+	notifiers := c.getNotifiers()
+	if len(notifiers) == 0 {
+		return
+	}
+	event.Time = time.Now()
+	event.Stack = c.getStackName()
+	event.Host, _ = os.Hostname()
+	if c.toolchain != nil {
+		event.Toolchain = c.toolchain.Name
+	}
+	for _, n := range notifiers {
+		err := n.Notify(event)
+		if err != nil {
+			log.Printf("[WARN] unable to send the %s event of stack %s: %s", event.Event, event.Stack, err)
+		}
+	}
+}
+
+// notifyComponentFailed sends the failure of the installation of a component to the notifiers of the stack
+func (c *Config) notifyComponentFailed(comp *Component, err error) {
+	event := &Event{
+		Event:      EventComponentFailed,
+		Component:  comp.Name,
+		Version:    comp.Version,
+		Error:      err.Error(),
+		LogExcerpt: getLogExcerpt(err),
+	}
+	var buildErr *builder.BuildError
+	if errors.As(err, &buildErr) {
+		event.Stage = buildErr.Stage
+		event.LogPath = buildErr.LogPath
+	}
+	c.notify(event)
+}
+
+// notifyStackCompleted sends the result of the installation of the stack to the notifiers of the stack
+func (c *Config) notifyStackCompleted(err error) {
+	event := &Event{
+		Event:  EventStackCompleted,
+		Status: "success",
+	}
+	if err != nil {
+		event.Status = "failure"
+		event.Error = err.Error()
+	}
+	if c.Report != nil {
+		c.Report.mutex.Lock()
+		for _, comp := range c.Report.Components {
+			result := EventComponent{Name: comp.Name, Status: comp.Status}
+			if comp.Err != nil {
+				result.Error = comp.Err.Error()
+			}
+			event.Components = append(event.Components, result)
+		}
+		c.Report.mutex.Unlock()
+	}
+	c.notify(event)
+}
+
+// SlackNotifier posts the events to a Slack channel through an incoming webhook
+type SlackNotifier struct {
+	// WebhookURL is the URL of the incoming webhook of the channel; as a secret, it may refer to an environment
+	// variable, e.g., "$SLACK_WEBHOOK_URL"
+	WebhookURL string
+
+	// Mention is who is mentioned in the messages, e.g., "<@U024BE7LH>" for a user or "<!here>" (optional)
+	Mention string
+
+	// Events is the events posted to the channel, component_failed and stack_completed when empty (optional)
+	Events []string
+}
+
+// Notify posts an event to the Slack channel, if it is wanted
+func (s *SlackNotifier) Notify(event *Event) error {
+	events := s.Events
+	if len(events) == 0 {
+		events = defaultNotifierEvents
+	}
+	if !wantsEvent(events, event.Event) {
+		return nil
+	}
+
+	text := event.String()
+	if s.Mention != "" {
+		text = s.Mention + " " + text
+	}
+	if details := event.details(); details != "" {
+		text += "\n```\n" + strings.TrimSpace(details) + "\n```"
+	}
+	content, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return fmt.Errorf("unable to encode the Slack message: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, os.ExpandEnv(s.WebhookURL), bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("invalid Slack webhook URL: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	return postRequest(req, "Slack")
+}
+
+// SMTPNotifier emails the events through an SMTP server
+type SMTPNotifier struct {
+	// Server is the address of the SMTP server, e.g., smtp.example.com:587
+	Server string
+
+	// From is the sender of the emails
+	From string
+
+	// To is the recipients of the emails
+	To []string
+
+	// Username and Password are the credentials to authenticate with the server, without authentication when
+	// empty. As secrets, they may refer to environment variables, e.g., "$SMTP_PASSWORD" (optional)
+	Username string
+	Password string
+
+	// Events is the events emailed, component_failed and stack_completed when empty (optional)
+	Events []string
+}
+
+// Notify emails an event, if it is wanted
+func (s *SMTPNotifier) Notify(event *Event) error {
+	events := s.Events
+	if len(events) == 0 {
+		events = defaultNotifierEvents
+	}
+	if !wantsEvent(events, event.Event) {
+		return nil
+	}
+	if len(s.To) == 0 {
+		return fmt.Errorf("no recipient for the emails")
+	}
+
+	var msg strings.Builder
+	msg.WriteString("From: " + s.From + "\r\n")
+	msg.WriteString("To: " + strings.Join(s.To, ", ") + "\r\n")
+	msg.WriteString("Subject: " + event.String() + "\r\n")
+	msg.WriteString("Date: " + event.Time.Format(time.RFC1123Z) + "\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	msg.WriteString("\r\n")
+	body := event.String() + "\n\n" + event.details()
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+
+	var auth smtp.Auth
+	if s.Username != "" {
+		host, _, err := net.SplitHostPort(s.Server)
+		if err != nil {
+			return fmt.Errorf("invalid SMTP server %s: %w", s.Server, err)
+		}
+		auth = smtp.PlainAuth("", os.ExpandEnv(s.Username), os.ExpandEnv(s.Password), host)
+	}
+	err := smtp.SendMail(s.Server, auth, s.From, s.To, []byte(msg.String()))
+	if err != nil {
+		return fmt.Errorf("unable to email %s: %w", strings.Join(s.To, ", "), err)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+)
+
+// overlayDef is an overlay patching a stack definition, e.g., with site-specific customizations (see ApplyOverlay)
+type overlayDef struct {
+	// Name, System and Type replace the ones of the stack when set
+	Name   *string `json:"name"`
+	System *string `json:"system"`
+	Type   *string `json:"type"`
+
+	// Components is the list of the patches of the components, in the format of a component
+	Components []json.RawMessage `json:"components"`
+}
+
+// overlayComponent is the fields of the patch of a component in addition to the ones of a component
+type overlayComponent struct {
+	// Name is the name of the component to patch or add
+	Name string `json:"name"`
+
+	// AppendConfigureParams is added at the end of the configure parameters of the component
+	AppendConfigureParams string `json:"append_configure_params"`
+
+	// AppendBuildEnv is added at the end of the build environment of the component
+	AppendBuildEnv string `json:"append_build_env"`
+
+	// Remove specifies whether the component must be removed from the stack
+	Remove bool `json:"remove"`
+}
+
+// appendParams adds parameters separated by spaces at the end of others
+func appendParams(params string, extraParams string) string {
+	if params == "" {
+		return extraParams
+	}
+	if extraParams == "" {
+		return params
+	}
+	return params + " " + extraParams
+}
+
+// ApplyOverlay patches the definition of a stack with an overlay, i.e., a JSON document such as
+//
+//	{"components": [
+//		{"name": "ompi", "URL": "https://mirror.example.com/ompi.tar.bz2", "append_configure_params": "--with-slurm"},
+//		{"name": "ucx", "disabled": true},
+//		{"name": "hcoll", "remove": true},
+//		{"name": "site-tools", "URL": "file:///opt/src/site-tools"}
+//	]}
+//
+// so that sites can customize a definition without copying it. Patches are applied in order. A component whose
+// patch has remove set to true is removed from the stack; otherwise, the patch is merged into the component in
+// the following order:
+// 1. the fields of the patch replace the ones of the component, maps such as make_vars being merged,
+// 2. append_configure_params and append_build_env are added at the end of the configure parameters and the
+// build environment.
+// Components that are not part of the stack are added at its end. The name, system and type of the stack can
+// also be replaced, e.g., {"system": "dpu"}.
+func (def *StackDef) ApplyOverlay(content []byte) error {
+	var overlay overlayDef
+	err := json.Unmarshal(content, &overlay)
+	if err != nil {
+		return fmt.Errorf("unable to parse the overlay: %w", err)
+	}
+	if overlay.Name != nil {
+		def.Name = *overlay.Name
+	}
+	if overlay.System != nil {
+		def.System = *overlay.System
+	}
+	if overlay.Type != nil {
+		def.Type = *overlay.Type
+	}
+
+	for _, rawComp := range overlay.Components {
+		var patch overlayComponent
+		err := json.Unmarshal(rawComp, &patch)
+		if err != nil {
+			return fmt.Errorf("unable to parse the overlay: %w", err)
+		}
+		if patch.Name == "" {
+			return fmt.Errorf("invalid overlay, the name of a component is undefined")
+		}
+
+		idx := -1
+		for i := range def.Components {
+			if def.Components[i].Name == patch.Name {
+				idx = i
+				break
+			}
+		}
+		if patch.Remove {
+			if idx == -1 {
+				return fmt.Errorf("invalid overlay, %s cannot be removed since it is not a component of the stack", patch.Name)
+			}
+			def.Components = append(def.Components[:idx], def.Components[idx+1:]...)
+			continue
+		}
+		if idx == -1 {
+			def.Components = append(def.Components, Component{})
+			idx = len(def.Components) - 1
+		}
+		comp := &def.Components[idx]
+		err = json.Unmarshal(rawComp, comp)
+		if err != nil {
+			return fmt.Errorf("unable to apply the overlay to %s: %w", patch.Name, err)
+		}
+		comp.ConfigureParams = appendParams(comp.ConfigureParams, patch.AppendConfigureParams)
+		comp.BuildEnv = appendParams(comp.BuildEnv, patch.AppendBuildEnv)
+	}
+	return nil
+}
+
+// applyOverlays patches the definition of the stack with the overlays of the configuration, in order
+// (see Config.OverlayFilePaths)
+func (c *Config) applyOverlays() error {
+	for _, overlayPath := range c.OverlayFilePaths {
+		localPath, err := c.getLocalFile(overlayPath, "")
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(localPath)
+		if err != nil {
+			return fmt.Errorf("unable to read %s: %w", overlayPath, err)
+		}
+		err = c.Data.StackDefinition.ApplyOverlay(content)
+		if err != nil {
+			return fmt.Errorf("unable to apply %s: %w", overlayPath, err)
+		}
+		log.Printf("-> Overlay %s applied to the definition of the stack", overlayPath)
+	}
+	return nil
+}
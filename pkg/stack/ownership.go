@@ -0,0 +1,92 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// lookupOwnership converts the user and group names into the numerical identifiers
+// expected by chown. -1 is returned for an identifier that is not specified, meaning
+// that it will not be changed.
+func lookupOwnership(owner, group string) (int, int, error) {
+	uid := -1
+	gid := -1
+
+	if owner != "" {
+		u, err := user.Lookup(owner)
+		if err != nil {
+			return -1, -1, fmt.Errorf("unable to find user %s: %w", owner, err)
+		}
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return -1, -1, fmt.Errorf("invalid UID for user %s: %w", owner, err)
+		}
+	}
+
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return -1, -1, fmt.Errorf("unable to find group %s: %w", group, err)
+		}
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return -1, -1, fmt.Errorf("invalid GID for group %s: %w", group, err)
+		}
+	}
+
+	return uid, gid, nil
+}
+
+// chownTree changes the ownership of a directory and of its entire content.
+// Symbolic links are updated themselves, their target is never followed.
+func chownTree(dir string, owner string, group string) error {
+	if owner == "" && group == "" {
+		return nil
+	}
+
+	if !util.PathExists(dir) {
+		return nil
+	}
+
+	if os.Geteuid() != 0 {
+		log.Printf("-> Not running as a privileged user, ownership of %s is not changed to %s:%s", dir, owner, group)
+		return nil
+	}
+
+	uid, gid, err := lookupOwnership(owner, group)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("-> Changing ownership of %s to %s:%s", dir, owner, group)
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		err = os.Lchown(path, uid, gid)
+		if err != nil {
+			return fmt.Errorf("unable to change ownership of %s: %w", path, err)
+		}
+		return nil
+	})
+}
+
+// applyOwnership changes the ownership of a directory based on the configuration of the stack
+func (c *Config) applyOwnership(dir string) error {
+	if c.Data.StackConfig == nil {
+		return nil
+	}
+	return chownTree(dir, c.Data.StackConfig.Owner, c.Data.StackConfig.Group)
+}
@@ -0,0 +1,34 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package stack
+
+import (
+	"os/user"
+	"testing"
+)
+
+func TestLookupOwnership(t *testing.T) {
+	u, err := user.Current()
+	if err != nil {
+		t.Skipf("unable to get the current user: %s", err)
+	}
+
+	uid, gid, err := lookupOwnership(u.Username, "")
+	if err != nil {
+		t.Fatalf("lookupOwnership() failed: %s", err)
+	}
+	if uid == -1 {
+		t.Fatalf("UID of %s was not resolved", u.Username)
+	}
+	if gid != -1 {
+		t.Fatalf("GID is %d while no group was specified", gid)
+	}
+
+	_, _, err = lookupOwnership("a_user_that_does_not_exist_for_sure", "")
+	if err == nil {
+		t.Fatalf("lookupOwnership() succeeded with an invalid user")
+	}
+}
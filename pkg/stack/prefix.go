@@ -0,0 +1,165 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// installHashLength is the length of the hash in the hashed installation prefixes of the components, e.g.,
+// install/ucx-0123456789ab (see StackCfg.HashedInstallPrefixes)
+const installHashLength = 12
+
+// hashedInstallDirRegexp matches the name of the hashed installation prefixes in the installation directory of a
+// stack, the first group being the name of the component
+var hashedInstallDirRegexp = regexp.MustCompile(fmt.Sprintf("^(.+)-[0-9a-f]{%d}$", installHashLength))
+
+// componentFingerprint is everything that changes the installation of a component, whose hash is the hash of its
+// installation prefix (see StackCfg.HashedInstallPrefixes)
+type componentFingerprint struct {
+	// Component is the definition of the component, without the fields that do not change its installation
+	Component Component `json:"component"`
+
+	BuildType           string   `json:"build_type"`
+	OptimizationProfile string   `json:"optimization_profile"`
+	Sanitizers          []string `json:"sanitizers"`
+	Strip               bool     `json:"strip"`
+
+	// Toolchain is the environment selecting the toolchain of the compiler matrix the stack is built with, if any
+	Toolchain string `json:"toolchain"`
+
+	// Dependencies is the hash of the components it depends on and of the toolchain components it is built with
+	Dependencies map[string]string `json:"dependencies"`
+}
+
+// getInstallDirName returns the name of the installation directory of a component in the installation directory
+// of the stack, i.e., <name>-<hash> when its installation prefix is hashed or <name>
+func getInstallDirName(comp *Component) string {
+	if comp.installHash != "" {
+		return comp.Name + "-" + comp.installHash
+	}
+	return comp.Name
+}
+
+// getInstallDirComponent returns the name of the component an entry of the installation directory of the stack
+// is the installation of, considering hashed installation prefixes for the components of defined
+func getInstallDirComponent(entry string, defined map[string]bool) string {
+	if defined[entry] {
+		return entry
+	}
+	if match := hashedInstallDirRegexp.FindStringSubmatch(entry); match != nil && defined[match[1]] {
+		return match[1]
+	}
+	return entry
+}
+
+// getComponentHash returns the hash of the fingerprint of a component, hashes being the hashes of the components
+// already computed. Dependency cycles are ignored, they are reported when the stack is installed.
+func (c *Config) getComponentHash(comp *Component, hashes map[string]string, bootstrap map[string]bool) (string, error) {
+	if hash, ok := hashes[comp.Name]; ok {
+		return hash, nil
+	}
+	// Marks the component as being visited
+	hashes[comp.Name] = ""
+
+	fingerprint := componentFingerprint{
+		Component:           *comp,
+		BuildType:           c.Data.StackConfig.BuildType,
+		OptimizationProfile: c.Data.StackConfig.OptimizationProfile,
+		Sanitizers:          c.getSanitizers(comp),
+		Strip:               (c.Data.StackConfig.Strip || c.Data.StackConfig.SplitDebugInfo) && !comp.NoStrip,
+		Dependencies:        make(map[string]string),
+	}
+	if comp.BuildType != "" {
+		fingerprint.BuildType = comp.BuildType
+	}
+	if comp.OptimizationProfile != "" {
+		fingerprint.OptimizationProfile = comp.OptimizationProfile
+	}
+	if c.toolchain != nil {
+		fingerprint.Toolchain = c.toolchain.BuildEnv
+	}
+	// The fields that do not change the installation of the component are ignored
+	def := &fingerprint.Component
+	def.Description, def.Homepage, def.License, def.Maintainer = "", "", "", ""
+	def.Test, def.TestsMustPass, def.SanityCheck, def.Verify = false, false, "", nil
+	def.Mirrors, def.NoConfigureCache = nil, false
+	def.PruneBuildTree, def.KeepBuildDir, def.ScratchBasedir, def.BuildBasedir = false, false, "", ""
+	def.InstallDir, def.BuildDir, def.SrcDir = "", "", ""
+
+	deps := getDependencies(comp)
+	if !bootstrap[comp.Name] {
+		for name := range bootstrap {
+			deps = append(deps, name)
+		}
+	}
+	for _, name := range deps {
+		dep := c.getComponent(name)
+		if dep == nil || dep.Disabled {
+			continue
+		}
+		if dep.External != "" {
+			fingerprint.Dependencies[name] = dep.External
+			continue
+		}
+		depHash, err := c.getComponentHash(dep, hashes, bootstrap)
+		if err != nil {
+			return "", err
+		}
+		fingerprint.Dependencies[name] = depHash
+	}
+
+	content, err := json.Marshal(fingerprint)
+	if err != nil {
+		return "", fmt.Errorf("unable to get the fingerprint of %s: %w", comp.Name, err)
+	}
+	checksum := sha256.Sum256(content)
+	hash := hex.EncodeToString(checksum[:])[:installHashLength]
+	hashes[comp.Name] = hash
+	return hash, nil
+}
+
+// setInstallHashes sets the hash of the installation prefix of the components when the installation prefixes of
+// the stack are hashed (see StackCfg.HashedInstallPrefixes)
+func (c *Config) setInstallHashes() error {
+	if !c.Data.StackConfig.HashedInstallPrefixes {
+		return nil
+	}
+	hashes := make(map[string]string)
+	bootstrap := c.getBootstrapComponents()
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if comp.Disabled || comp.External != "" {
+			continue
+		}
+		_, err := c.getComponentHash(comp, hashes, bootstrap)
+		if err != nil {
+			return err
+		}
+	}
+
+	stackDirRef := RefStartDelimiter + "stack_dir" + RefEndDelimiter
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if hashes[comp.Name] == "" {
+			continue
+		}
+		prefix := getCompInstallDir(stackDirRef, comp)
+		comp.installHash = hashes[comp.Name]
+		// The installation prefix may already be set, e.g., by the preset of the component (see Preset.PrefixMakeVar)
+		for name, value := range comp.MakeVars {
+			if value == prefix {
+				comp.MakeVars[name] = getCompInstallDir(stackDirRef, comp)
+			}
+		}
+	}
+	return nil
+}
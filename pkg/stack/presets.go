@@ -0,0 +1,269 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// Preset is the known-good configuration of a common HPC package a component can opt into (see Component.Preset)
+type Preset struct {
+	// ConfigureParams is the configure parameters, added before the ones of the component
+	ConfigureParams string
+
+	// ConfigId is the name of the configure option of the components depending on the package, e.g., 'ofi'
+	// for --with-ofi
+	ConfigId string
+
+	// BuildSystem is the build system of the package, detected when empty
+	BuildSystem string
+
+	// BuildTargets is the make targets building the package, the default target when empty
+	BuildTargets []string
+
+	// PrefixMakeVar is the make variable set to the installation directory when the package has no configure
+	// script, e.g., PREFIX
+	PrefixMakeVar string
+
+	// Dependencies is the packages the package can be built with; components of the stack providing them, by
+	// name or preset, become dependencies of the component when they come before it in the stack
+	Dependencies []string
+
+	// NoConfigureCache specifies whether the configure script of the package does not support configure caches
+	NoConfigureCache bool
+
+	// Variants is the configure parameters of each value of the variants of the package, e.g., "cuda=on" =>
+	// "--with-cuda" (see Component.Variants)
+	Variants map[string]string
+
+	// Metadata is the default descriptive metadata of the package
+	Metadata Metadata
+}
+
+// presets is the library of built-in presets, by name
+var presets = map[string]Preset{
+	"ucx": {
+		ConfigureParams: "--enable-mt --enable-optimizations --disable-logging --disable-debug --disable-assertions --disable-params-check --without-java",
+		ConfigId:        "ucx",
+		Dependencies:    []string{"gdrcopy", "knem", "xpmem"},
+		Variants: map[string]string{
+			"cuda=on": "--with-cuda", "cuda=off": "--without-cuda",
+			"verbs=on": "--with-verbs", "verbs=off": "--without-verbs",
+			"debug=on": "--enable-debug --enable-logging", "debug=off": "",
+		},
+		Metadata: Metadata{
+			Description: "Unified Communication X, a communication framework for high-performance applications",
+			Homepage:    "https://openucx.org",
+			License:     "BSD-3-Clause",
+		},
+	},
+	"openmpi": {
+		ConfigureParams: "--enable-mpirun-prefix-by-default --without-verbs",
+		Dependencies:    []string{"hwloc", "libevent", "pmix", "ucx", "libfabric"},
+		Variants: map[string]string{
+			"cuda=on": "--with-cuda", "cuda=off": "--without-cuda",
+			"fortran=on": "--enable-mpi-fortran", "fortran=off": "--disable-mpi-fortran",
+			"java=on": "--enable-mpi-java", "java=off": "--disable-mpi-java",
+		},
+		Metadata: Metadata{
+			Description: "Open MPI implementation of MPI",
+			Homepage:    "https://www.open-mpi.org",
+			License:     "BSD-3-Clause-Open-MPI",
+		},
+	},
+	"hwloc": {
+		ConfigureParams: "--disable-cairo --disable-libxml2 --disable-opencl",
+		ConfigId:        "hwloc",
+		Variants: map[string]string{
+			"cuda=on": "--enable-cuda --enable-nvml", "cuda=off": "--disable-cuda --disable-nvml",
+		},
+		Metadata: Metadata{
+			Description: "Portable Hardware Locality",
+			Homepage:    "https://www.open-mpi.org/projects/hwloc",
+			License:     "BSD-3-Clause",
+		},
+	},
+	"libevent": {
+		ConfigureParams: "--disable-openssl --disable-static",
+		ConfigId:        "libevent",
+		Metadata: Metadata{
+			Description: "Event notification library",
+			Homepage:    "https://libevent.org",
+			License:     "BSD-3-Clause",
+		},
+	},
+	"pmix": {
+		ConfigureParams: "--disable-static",
+		ConfigId:        "pmix",
+		Dependencies:    []string{"hwloc", "libevent"},
+		Metadata: Metadata{
+			Description: "Process Management Interface for Exascale",
+			Homepage:    "https://pmix.github.io",
+			License:     "BSD-3-Clause",
+		},
+	},
+	"libfabric": {
+		ConfigureParams: "--disable-static",
+		ConfigId:        "ofi",
+		Variants: map[string]string{
+			"verbs=on": "--enable-verbs", "verbs=off": "--disable-verbs",
+			"cuda=on": "--with-cuda", "cuda=off": "--without-cuda",
+		},
+		Metadata: Metadata{
+			Description: "Open Fabrics Interfaces",
+			Homepage:    "https://ofiwg.github.io/libfabric",
+			License:     "BSD-2-Clause OR GPL-2.0-only",
+		},
+	},
+	"nccl": {
+		BuildSystem:   "make",
+		BuildTargets:  []string{"src.build"},
+		PrefixMakeVar: "PREFIX",
+		ConfigId:      "nccl",
+		Metadata: Metadata{
+			Description: "NVIDIA Collective Communication Library",
+			Homepage:    "https://developer.nvidia.com/nccl",
+			License:     "BSD-3-Clause",
+		},
+	},
+	"hdf5": {
+		ConfigureParams: "--enable-shared --disable-static --enable-build-mode=production",
+		ConfigId:        "hdf5",
+		Dependencies:    []string{"zlib"},
+		Variants: map[string]string{
+			"fortran=on": "--enable-fortran", "fortran=off": "--disable-fortran",
+			"cxx=on": "--enable-cxx", "cxx=off": "--disable-cxx",
+			"parallel=on": "--enable-parallel", "parallel=off": "--disable-parallel",
+		},
+		Metadata: Metadata{
+			Description: "Hierarchical Data Format 5 library",
+			Homepage:    "https://www.hdfgroup.org/solutions/hdf5",
+			License:     "BSD-3-Clause",
+		},
+	},
+	"zlib": {
+		// zlib's configure script is not generated by autoconf and rejects unknown options
+		ConfigId:         "zlib",
+		NoConfigureCache: true,
+		Metadata: Metadata{
+			Description: "Compression library",
+			Homepage:    "https://zlib.net",
+			License:     "Zlib",
+		},
+	},
+}
+
+// GetPresets returns the name of all the built-in presets
+func GetPresets() []string {
+	var names []string
+	for name := range presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// GetPreset returns a built-in preset
+func GetPreset(name string) (Preset, error) {
+	preset, ok := presets[name]
+	if !ok {
+		return Preset{}, fmt.Errorf("unknown preset %s, valid presets are: %s", name, strings.Join(GetPresets(), ", "))
+	}
+	return preset, nil
+}
+
+// findPresetDependency returns the name of the component providing a package among components, by name or preset,
+// an empty string if there is none
+func findPresetDependency(components []Component, pkg string) string {
+	for _, comp := range components {
+		if !comp.Disabled && (comp.Name == pkg || comp.Preset == pkg) {
+			return comp.Name
+		}
+	}
+	return ""
+}
+
+// applyPreset completes the definition of the component at index idx of the stack with its preset. Fields set in the
+// definition take precedence, except the configure parameters of the preset that come before the ones of the component.
+func (def *StackDef) applyPreset(idx int) error {
+	comp := &def.Components[idx]
+	preset, err := GetPreset(comp.Preset)
+	if err != nil {
+		return fmt.Errorf("invalid preset for %s: %w", comp.Name, err)
+	}
+
+	comp.ConfigureParams = appendParams(preset.ConfigureParams, comp.ConfigureParams)
+	if comp.ConfigId == "" {
+		comp.ConfigId = preset.ConfigId
+	}
+	if comp.BuildSystem == "" && comp.Plugin == "" {
+		comp.BuildSystem = preset.BuildSystem
+	}
+	if len(comp.BuildTargets) == 0 {
+		comp.BuildTargets = preset.BuildTargets
+	}
+	if preset.PrefixMakeVar != "" {
+		if comp.MakeVars == nil {
+			comp.MakeVars = make(map[string]string)
+		}
+		if _, ok := comp.MakeVars[preset.PrefixMakeVar]; !ok {
+			comp.MakeVars[preset.PrefixMakeVar] = getCompInstallDir(RefStartDelimiter+"stack_dir"+RefEndDelimiter, comp)
+		}
+	}
+	comp.NoConfigureCache = comp.NoConfigureCache || preset.NoConfigureCache
+	if comp.Description == "" {
+		comp.Description = preset.Metadata.Description
+	}
+	if comp.Homepage == "" {
+		comp.Homepage = preset.Metadata.Homepage
+	}
+	if comp.License == "" {
+		comp.License = preset.Metadata.License
+	}
+
+	// Components are installed in order so only the components before can be dependencies
+	deps := getDependencies(comp)
+	for _, pkg := range preset.Dependencies {
+		depName := findPresetDependency(def.Components[:idx], pkg)
+		if depName == "" {
+			continue
+		}
+		alreadyDep := false
+		for _, dep := range deps {
+			if dep == depName {
+				alreadyDep = true
+			}
+		}
+		if !alreadyDep {
+			log.Printf("-> %s is built with %s", comp.Name, depName)
+			deps = append(deps, depName)
+		}
+	}
+	comp.ConfigureDependency = strings.Join(deps, ",")
+	comp.presetApplied = true
+	return nil
+}
+
+// ApplyPresets completes the definition of the components relying on a preset (see Component.Preset) with the
+// known-good configuration of the preset: configure parameters, build system, metadata and dependencies on the
+// components of the stack providing the packages the preset can be built with. The definition of the stack is
+// completed only once, ApplyPresets being automatically called when the stack is loaded or installed.
+func (def *StackDef) ApplyPresets() error {
+	for idx := range def.Components {
+		if def.Components[idx].Preset == "" || def.Components[idx].presetApplied {
+			continue
+		}
+		err := def.applyPreset(idx)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
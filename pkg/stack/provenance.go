@@ -0,0 +1,189 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/builder"
+)
+
+const (
+	// ProvenanceFilename is the name of the file, in the installation directory of each component, describing
+	// how the component was built
+	ProvenanceFilename = "provenance.json"
+)
+
+// Provenance describes how a component of the stack was built and installed
+type Provenance struct {
+	// Name is the name of the component
+	Name string `json:"name"`
+
+	// Version is the version of the component, if any
+	Version string `json:"version,omitempty"`
+
+	// URL is the URL the source code of the component was fetched from
+	URL string `json:"URL"`
+
+	// SHA256 is the checksum of the source code when it is a file, e.g., a tarball
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Revision is the SHA of the commit that was built when the source code comes from Git
+	Revision string `json:"revision,omitempty"`
+
+	// Host is the name of the host where the component was built
+	Host string `json:"host"`
+
+	// Toolchain is the compilers used to build the component, e.g., "CC" => "/usr/bin/cc: cc (GCC) 12.2.0"
+	Toolchain map[string]string `json:"toolchain,omitempty"`
+
+	// OptimizationProfile is the optimization profile the component was built with, if any
+	OptimizationProfile string `json:"optimization_profile,omitempty"`
+
+	// BuildType is the build type the component was built with, if any
+	BuildType string `json:"build_type,omitempty"`
+
+	// Sanitizers is the sanitizers the component is instrumented with, if any
+	Sanitizers []string `json:"sanitizers,omitempty"`
+
+	// Variants is the variants the component was built with, if any
+	Variants map[string]string `json:"variants,omitempty"`
+
+	// BuildSystem is the build system used to configure and build the component, e.g., autotools
+	BuildSystem string `json:"build_system,omitempty"`
+
+	// ConfigurePrelude is the command executed before configuring the component, if any
+	ConfigurePrelude string `json:"configure_prelude,omitempty"`
+
+	// ConfigureArgs is the arguments used to configure the component, in addition to the installation prefix
+	ConfigureArgs []string `json:"configure_args,omitempty"`
+
+	// BuildEnv is the part of the environment the component was built with that differs from the environment
+	// of the builder, e.g., CFLAGS
+	BuildEnv []string `json:"build_env,omitempty"`
+
+	// Start is the time the installation of the component started
+	Start time.Time `json:"start"`
+
+	// End is the time the installation of the component completed
+	End time.Time `json:"end"`
+}
+
+// getCustomEnv returns the elements of an environment that are not inherited from the environment of the current
+// process, which may include credentials that must not be recorded
+func getCustomEnv(env []string) []string {
+	inherited := make(map[string]bool)
+	for _, e := range os.Environ() {
+		inherited[e] = true
+	}
+	var customEnv []string
+	for _, e := range env {
+		if !inherited[e] {
+			customEnv = append(customEnv, e)
+		}
+	}
+	return customEnv
+}
+
+// getProvenance returns the provenance of a component that was just built and installed with a builder
+func getProvenance(b *builder.Builder, start time.Time) *Provenance {
+	p := &Provenance{
+		Name:                b.App.Name,
+		Version:             b.App.Version,
+		URL:                 b.App.Source.URL,
+		SHA256:              b.Env.SrcChecksum,
+		Revision:            b.Env.SrcRevision,
+		Toolchain:           b.Env.GetToolchain(),
+		OptimizationProfile: b.Env.OptimizationProfile,
+		BuildType:           b.Env.BuildType,
+		Sanitizers:          b.Env.Sanitizers,
+		ConfigurePrelude:    b.App.AutotoolsCfg.ConfigurePreludeCmd,
+		ConfigureArgs:       b.App.AutotoolsCfg.ExtraConfigureArgs,
+		BuildEnv:            getCustomEnv(b.Env.Env),
+		Start:               start,
+		End:                 time.Now(),
+	}
+	p.Host, _ = os.Hostname()
+	if b.BuildSystem != nil {
+		p.BuildSystem = b.BuildSystem.Name()
+	}
+	return p
+}
+
+// writeProvenance writes the provenance of a component in its installation directory
+func writeProvenance(compInstallDir string, p *Provenance) error {
+	content, err := json.MarshalIndent(p, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to encode the provenance of %s: %w", p.Name, err)
+	}
+	provenancePath := filepath.Join(compInstallDir, ProvenanceFilename)
+	err = ioutil.WriteFile(provenancePath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", provenancePath, err)
+	}
+	return nil
+}
+
+// GetProvenance returns the provenance of an installed component of the stack (see ProvenanceFilename)
+func (c *Config) GetProvenance(name string) (*Provenance, error) {
+	comp := c.getComponent(name)
+	if comp == nil {
+		return nil, fmt.Errorf("%s is not a component of the stack", name)
+	}
+	provenancePath := filepath.Join(getCompInstallDir(c.getStackBasedir(), comp), ProvenanceFilename)
+	content, err := ioutil.ReadFile(provenancePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", provenancePath, err)
+	}
+	p := new(Provenance)
+	err = json.Unmarshal(content, p)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", provenancePath, err)
+	}
+	return p, nil
+}
+
+// GetManifests returns the execution manifests of the commands executed to build and install a component of the
+// stack, e.g., configure, with their arguments and when they were executed, in the order they were executed
+func (c *Config) GetManifests(name string) ([]*builder.ExecManifest, error) {
+	comp := c.getComponent(name)
+	if comp == nil {
+		return nil, fmt.Errorf("%s is not a component of the stack", name)
+	}
+	installDir := getCompInstallDir(c.getStackBasedir(), comp)
+	if _, err := os.Stat(installDir); err != nil {
+		return nil, fmt.Errorf("%s is not installed: %w", name, err)
+	}
+	return builder.GetManifests(installDir)
+}
+
+// GetAllManifests returns the execution manifests of all the components of the stack that are installed, by
+// component (see GetManifests)
+func (c *Config) GetAllManifests() (map[string][]*builder.ExecManifest, error) {
+	manifests := make(map[string][]*builder.ExecManifest)
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if comp.Disabled || comp.External != "" {
+			continue
+		}
+		installDir := getCompInstallDir(c.getStackBasedir(), comp)
+		if _, err := os.Stat(installDir); err != nil {
+			continue
+		}
+		compManifests, err := builder.GetManifests(installDir)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the manifests of %s: %w", comp.Name, err)
+		}
+		manifests[comp.Name] = compManifests
+	}
+	return manifests, nil
+}
@@ -0,0 +1,123 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// getProviders returns the enabled components providing each virtual package of the stack, in the order of the
+// stack definition (see Component.Provides)
+func (def *StackDef) getProviders() map[string][]string {
+	providers := make(map[string][]string)
+	for _, comp := range def.Components {
+		if comp.Disabled {
+			continue
+		}
+		for _, virtual := range comp.Provides {
+			providers[virtual] = append(providers[virtual], comp.Name)
+		}
+	}
+	return providers
+}
+
+// selectProviders returns the component selected to provide each virtual package of the stack, i.e., the one
+// selected in the configuration of the stack (see StackCfg.Providers) or the first component providing it
+func (c *Config) selectProviders() (map[string]string, error) {
+	var selection map[string]string
+	if c.Data.StackConfig != nil {
+		selection = c.Data.StackConfig.Providers
+	}
+	providers := c.Data.StackDefinition.getProviders()
+	for virtual := range selection {
+		if _, ok := providers[virtual]; !ok {
+			return nil, fmt.Errorf("invalid provider selection, no component of the stack provides %s", virtual)
+		}
+	}
+
+	selected := make(map[string]string)
+	for virtual, names := range providers {
+		if c.getComponent(virtual) != nil {
+			return nil, fmt.Errorf("%s is both a component and a virtual package", virtual)
+		}
+		name, ok := selection[virtual]
+		if !ok {
+			if len(names) > 1 {
+				log.Printf("-> No provider selected for %s, using %s (available providers: %s)", virtual, names[0], strings.Join(names, ", "))
+			}
+			selected[virtual] = names[0]
+			continue
+		}
+		isProvider := false
+		for _, provider := range names {
+			if provider == name {
+				isProvider = true
+			}
+		}
+		if !isProvider {
+			return nil, fmt.Errorf("%s does not provide %s, valid providers are: %s", name, virtual, strings.Join(names, ", "))
+		}
+		selected[virtual] = name
+	}
+	return selected, nil
+}
+
+// resolveProviders selects the providers of the virtual packages of the stack, disables the components providing
+// virtual packages that are not selected and replaces the virtual packages in the dependencies of the components
+// with their providers
+func (c *Config) resolveProviders() error {
+	selected, err := c.selectProviders()
+	if err != nil {
+		return err
+	}
+	if len(selected) == 0 {
+		return nil
+	}
+	isSelected := make(map[string]bool)
+	for _, name := range selected {
+		isSelected[name] = true
+	}
+
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if len(comp.Provides) > 0 && !comp.Disabled && !isSelected[comp.Name] {
+			log.Printf("-> %s is not the selected provider of %s, disabling it", comp.Name, strings.Join(comp.Provides, ", "))
+			comp.Disabled = true
+		}
+		deps := getDependencies(comp)
+		for i, dep := range deps {
+			if provider, ok := selected[dep]; ok {
+				deps[i] = provider
+			}
+		}
+		comp.ConfigureDependency = strings.Join(deps, ",")
+	}
+	c.providers = selected
+	return nil
+}
+
+// GetProvider returns the component of the stack providing a virtual package, e.g., "mpi", or the name of the
+// component itself when it is not a virtual package
+func (c *Config) GetProvider(name string) string {
+	if provider, ok := c.providers[name]; ok {
+		return provider
+	}
+	return name
+}
+
+// GetVirtualPackages returns the virtual packages provided by the components of the stack
+func (c *Config) GetVirtualPackages() []string {
+	var virtuals []string
+	for virtual := range c.Data.StackDefinition.getProviders() {
+		virtuals = append(virtuals, virtual)
+	}
+	sort.Strings(virtuals)
+	return virtuals
+}
@@ -0,0 +1,128 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/builder"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// prunedKeptSuffixes is the suffixes of the files kept when the build and source directories of a component are
+// pruned, i.e., the execution manifests and the logs
+var prunedKeptSuffixes = []string{builder.ManifestSuffix, ".log"}
+
+// shouldPrune returns whether the build and source directories of a component are pruned once it is installed
+// (see StackCfg.PruneBuildTrees, Component.PruneBuildTree and Component.KeepBuildDir)
+func (c *Config) shouldPrune(comp *Component) bool {
+	if comp.KeepBuildDir {
+		return false
+	}
+	return c.Data.StackConfig.PruneBuildTrees || comp.PruneBuildTree
+}
+
+// isKeptWhenPruned returns whether a file is kept when the directory it is in is pruned
+func isKeptWhenPruned(path string) bool {
+	for _, suffix := range prunedKeptSuffixes {
+		if strings.HasSuffix(path, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// pruneDir removes the content of a directory except its manifests and logs (see prunedKeptSuffixes), as well as
+// the directory itself when nothing is kept
+func pruneDir(dir string) error {
+	var removed, dirs []string
+	kept := false
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			dirs = append(dirs, path)
+		case info.Mode().IsRegular() && isKeptWhenPruned(path):
+			kept = true
+		default:
+			removed = append(removed, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to get the content of %s: %w", dir, err)
+	}
+	if !kept {
+		return os.RemoveAll(dir)
+	}
+
+	for _, path := range removed {
+		err = os.Remove(path)
+		if err != nil {
+			return err
+		}
+	}
+	// Subdirectories come after their parent, empty directories are removed from the deepest one
+	sort.Sort(sort.Reverse(sort.StringSlice(dirs)))
+	for _, path := range dirs {
+		if entries, err := ioutil.ReadDir(path); err == nil && len(entries) == 0 {
+			err = os.Remove(path)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pruneComponent removes the content of the build and source directories of a component once it is installed,
+// except the manifests and logs, and returns the build and source directories that still exist, if any. The source
+// code is not removed when it is shared by the toolchains of a compiler matrix or when it is overridden.
+func (c *Config) pruneComponent(comp *Component, compBuildDir string, compSrcDir string) (string, string, error) {
+	stackBasedir := c.getStackBasedir()
+	buildDir := filepath.Join(stackBasedir, "build", comp.Name)
+	if util.PathExists(buildDir) {
+		// The build directory may be a link to the one where the component is built (see Component.BuildBasedir)
+		dir, err := filepath.EvalSymlinks(buildDir)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to resolve %s: %w", buildDir, err)
+		}
+		err = pruneDir(dir)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to prune the build directory of %s: %w", comp.Name, err)
+		}
+		if !util.PathExists(dir) {
+			os.Remove(buildDir)
+		}
+	}
+
+	_, overridden := c.SourceOverrides[comp.Name]
+	srcBasedir := filepath.Join(c.getSrcBasedir(), "src")
+	if c.toolchain == nil && !overridden && compSrcDir != "" && filepath.Clean(compSrcDir) != srcBasedir && util.PathExists(compSrcDir) {
+		err := pruneDir(compSrcDir)
+		if err != nil {
+			return "", "", fmt.Errorf("unable to prune the source directory of %s: %w", comp.Name, err)
+		}
+	}
+	log.Printf("-> Build and source directories of %s pruned", comp.Name)
+
+	if !util.PathExists(compBuildDir) {
+		compBuildDir = ""
+	}
+	if !util.PathExists(compSrcDir) {
+		compSrcDir = ""
+	}
+	return compBuildDir, compSrcDir, nil
+}
@@ -0,0 +1,187 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// gitPathSeparator separates the URL of a Git repository from the path to a file in the repository,
+	// e.g., https://github.com/org/stacks.git#v1.0:hpc/def.json
+	gitPathSeparator = ".git#"
+
+	// remoteCacheDirname is the name of the directory, in the cache directory of the user, where remote
+	// definition and configuration files are cached by default
+	remoteCacheDirname = "go_software_build"
+)
+
+// isRemoteFile checks whether the path to a definition or configuration file is a URL, i.e., a HTTP(S) URL
+// or a file in a Git repository
+func isRemoteFile(path string) bool {
+	return strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") || strings.Contains(path, gitPathSeparator)
+}
+
+// parseGitFileURL returns the URL of the repository, the reference and the path to the file of a URL in the
+// <repository>.git#[<ref>:]<path> format, the reference being HEAD when not specified
+func parseGitFileURL(url string) (string, string, string, error) {
+	idx := strings.LastIndex(url, gitPathSeparator)
+	repoURL := url[:idx+len(".git")]
+	ref := "HEAD"
+	path := url[idx+len(gitPathSeparator):]
+	if refIdx := strings.Index(path, ":"); refIdx != -1 {
+		ref = path[:refIdx]
+		path = path[refIdx+1:]
+	}
+	if path == "" || ref == "" {
+		return "", "", "", fmt.Errorf("invalid URL %s, <repository>.git#[<ref>:]<path> expected", url)
+	}
+	return repoURL, ref, path, nil
+}
+
+// fetchGitFile writes the content of a file of a Git repository to targetFile
+func fetchGitFile(url string, targetFile string) error {
+	repoURL, ref, path, err := parseGitFileURL(url)
+	if err != nil {
+		return err
+	}
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		return fmt.Errorf("failed to find git: %w", err)
+	}
+	tempDir, err := ioutil.TempDir(filepath.Dir(targetFile), "git-")
+	if err != nil {
+		return fmt.Errorf("unable to create a temporary directory: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, args := range [][]string{
+		{"clone", "--quiet", "--no-checkout", repoURL, tempDir},
+		{"-C", tempDir, "show", ref + ":" + path},
+	} {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(gitBin, args...)
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err = cmd.Run()
+		if err != nil {
+			return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+		}
+		if args[0] == "-C" {
+			err = ioutil.WriteFile(targetFile, stdout.Bytes(), 0644)
+			if err != nil {
+				return fmt.Errorf("unable to write %s: %w", targetFile, err)
+			}
+		}
+	}
+	return nil
+}
+
+// getRemoteCacheDir returns the directory where remote definition and configuration files are cached
+func (c *Config) getRemoteCacheDir() (string, error) {
+	if c.RemoteCacheDir != "" {
+		return c.RemoteCacheDir, nil
+	}
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("unable to find the cache directory of the user: %w", err)
+	}
+	return filepath.Join(cacheDir, remoteCacheDirname), nil
+}
+
+// checkCachedFile checks whether a cached file exists and, when expectedChecksum is not empty, has the
+// expected SHA256 checksum
+func checkCachedFile(path string, expectedChecksum string) bool {
+	if !util.FileExists(path) {
+		return false
+	}
+	if expectedChecksum == "" {
+		return true
+	}
+	checksum, err := buildenv.FileChecksum(path)
+	return err == nil && strings.EqualFold(checksum, expectedChecksum)
+}
+
+// newRemoteEnv returns the environment used to download remote definition, configuration and overlay files, i.e.,
+// with the download settings of the configuration of the stack once it is loaded and RemoteCABundle otherwise
+func (c *Config) newRemoteEnv() buildenv.Info {
+	var env buildenv.Info
+	env.CABundle = c.RemoteCABundle
+	if cfg := c.Data.StackConfig; cfg != nil {
+		env.Credentials = cfg.Credentials
+		env.NetrcFile = cfg.NetrcFile
+		env.ArtifactServers = cfg.ArtifactServers
+		env.InsecureTLS = cfg.InsecureTLS
+		if cfg.CABundle != "" {
+			env.CABundle = cfg.CABundle
+		}
+	}
+	return env
+}
+
+// getLocalFile returns the path to a local copy of a definition or configuration file. Remote files are fetched
+// into the cache directory (see Config.RemoteCacheDir) and checked against expectedChecksum when it is not empty.
+// A cached file with the expected checksum is used without fetching the file again and, when the file cannot be
+// fetched, e.g., without network access, the cached copy is used if any.
+func (c *Config) getLocalFile(path string, expectedChecksum string) (string, error) {
+	if !isRemoteFile(path) {
+		return path, nil
+	}
+
+	cacheDir, err := c.getRemoteCacheDir()
+	if err != nil {
+		return "", err
+	}
+	err = os.MkdirAll(cacheDir, defaultPermission)
+	if err != nil {
+		return "", fmt.Errorf("unable to create %s: %w", cacheDir, err)
+	}
+	hash := sha256.Sum256([]byte(path))
+	cachePath := filepath.Join(cacheDir, hex.EncodeToString(hash[:8])+"-"+filepath.Base(path))
+	if expectedChecksum != "" && checkCachedFile(cachePath, expectedChecksum) {
+		return cachePath, nil
+	}
+
+	log.Printf("-> Fetching %s", path)
+	tempFile := cachePath + ".tmp"
+	defer os.Remove(tempFile)
+	if strings.Contains(path, gitPathSeparator) {
+		err = fetchGitFile(path, tempFile)
+	} else {
+		env := c.newRemoteEnv()
+		err = env.Download(context.Background(), path, tempFile, "")
+	}
+	if err == nil && !checkCachedFile(tempFile, expectedChecksum) {
+		checksum, _ := buildenv.FileChecksum(tempFile)
+		return "", fmt.Errorf("checksum mismatch for %s: %s instead of %s", path, checksum, expectedChecksum)
+	}
+	if err != nil {
+		if checkCachedFile(cachePath, expectedChecksum) {
+			log.Printf("[WARN] unable to fetch %s, using the cached copy: %s", path, err)
+			return cachePath, nil
+		}
+		return "", fmt.Errorf("unable to fetch %s: %w", path, err)
+	}
+	err = os.Rename(tempFile, cachePath)
+	if err != nil {
+		return "", fmt.Errorf("unable to rename %s: %w", tempFile, err)
+	}
+	return cachePath, nil
+}
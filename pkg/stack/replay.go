@@ -0,0 +1,70 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/gvallee/go_software_build/pkg/builder"
+)
+
+const (
+	// CommandsDirname is the name of the directory, in the base directory of the stack, where the commands
+	// executed to build each component are recorded, so that they can be replayed (see ReplayComponent)
+	CommandsDirname = "commands"
+)
+
+// getCommandsPath returns the path to the file recording the commands executed to build a component
+func (c *Config) getCommandsPath(comp *Component) string {
+	return filepath.Join(c.getStackBasedir(), CommandsDirname, comp.Name+".json")
+}
+
+// GetRecordedCommands returns the commands executed during the last build of a component, with their arguments,
+// directory and environment
+func (c *Config) GetRecordedCommands(name string) ([]builder.RecordedCommand, error) {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return nil, fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+	comp := c.getComponent(name)
+	if comp == nil {
+		return nil, fmt.Errorf("%s is not a component of the stack", name)
+	}
+	return builder.LoadCommands(c.getCommandsPath(comp))
+}
+
+// ReplayComponent executes again the commands recorded during the last build of a component, by default the ones
+// of the stage that failed, e.g., make, optionally with an edited environment (see builder.ReplayOptions). It
+// makes it possible to iterate on the failing step of a component without installing the stack again; the
+// component must then be installed again, e.g., with InstallComponent(), for the stack to record it.
+func (c *Config) ReplayComponent(name string, opts *builder.ReplayOptions) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+	comp := c.getComponent(name)
+	if comp == nil {
+		return fmt.Errorf("%s is not a component of the stack", name)
+	}
+
+	unlock, err := c.lock("replay")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = builder.ReplayCommands(c.getCommandsPath(comp), opts)
+	if err != nil {
+		return fmt.Errorf("unable to replay the build of %s: %w", name, err)
+	}
+	return nil
+}
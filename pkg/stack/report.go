@@ -0,0 +1,182 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// StatusInstalled is the status of a component that was successfully installed
+	StatusInstalled = "installed"
+
+	// StatusFailed is the status of a component that failed to install
+	StatusFailed = "failed"
+
+	// StatusSkipped is the status of a component that was not installed because one of its dependencies failed
+	StatusSkipped = "skipped"
+
+	// StatusDisabled is the status of a component that is disabled in the stack definition or its configuration
+	StatusDisabled = "disabled"
+
+	// StatusExternal is the status of an external component, which is not built (see Component.External)
+	StatusExternal = "external"
+)
+
+// ComponentReport gathers the result of the installation of a component
+type ComponentReport struct {
+	// Name is the name of the component
+	Name string
+
+	// Status is the status of the component after the installation of the stack, e.g., StatusInstalled
+	Status string
+
+	// Err is the error that made the installation of the component fail, if any
+	Err error
+
+	// SanityCheckErr is the error of the sanity check of the component, if any (see Component.SanityCheck)
+	SanityCheckErr error
+
+	// Metadata is the metadata of the component, e.g., its description, from the stack definition
+	Metadata Metadata
+
+	// Licenses is the licenses detected in the license files of the component, if any (see GetLicenses)
+	Licenses []string
+}
+
+// Report gathers the result of the installation of a stack
+type Report struct {
+	// Components is the list of reports for all the components of the stack, in the order they were installed
+	Components []ComponentReport
+
+	// mutex protects the list of reports so that components can be reported concurrently
+	mutex sync.Mutex
+}
+
+// InstallError is the error returned when one or more components of a stack failed to install
+type InstallError struct {
+	// Report is the installation report of the stack
+	Report *Report
+}
+
+func (r *Report) add(comp *Component, status string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Components = append(r.Components, ComponentReport{Name: comp.Name, Status: status, Err: err, Metadata: comp.GetMetadata()})
+}
+
+// setSanityCheckErr records the error of the sanity check of a component
+func (r *Report) setSanityCheckErr(name string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for idx := range r.Components {
+		if r.Components[idx].Name == name {
+			r.Components[idx].SanityCheckErr = err
+		}
+	}
+}
+
+// setLicenses records the licenses of a component
+func (r *Report) setLicenses(name string, licenses []string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for idx := range r.Components {
+		if r.Components[idx].Name == name {
+			r.Components[idx].Licenses = licenses
+		}
+	}
+}
+
+// Get returns the report of a specific component, nil if the component is not part of the report
+func (r *Report) Get(name string) *ComponentReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for idx := range r.Components {
+		if r.Components[idx].Name == name {
+			return &r.Components[idx]
+		}
+	}
+	return nil
+}
+
+// WithStatus returns the reports of all the components with a given status
+func (r *Report) WithStatus(status string) []ComponentReport {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var list []ComponentReport
+	for _, comp := range r.Components {
+		if comp.Status == status {
+			list = append(list, comp)
+		}
+	}
+	return list
+}
+
+// String returns a human-readable summary of the report
+func (r *Report) String() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var lines []string
+	for _, comp := range r.Components {
+		line := comp.Name + ": " + comp.Status
+		if comp.Metadata.Description != "" {
+			line += " - " + comp.Metadata.Description
+		}
+		if comp.Err != nil {
+			line += " (" + comp.Err.Error() + ")"
+		}
+		if comp.SanityCheckErr != nil {
+			line += " (sanity check failed: " + comp.SanityCheckErr.Error() + ")"
+		}
+		if comp.Metadata.License != "" {
+			line += " [declared license: " + comp.Metadata.License + "]"
+		}
+		if len(comp.Licenses) > 0 {
+			line += " [licenses: " + strings.Join(comp.Licenses, ", ") + "]"
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// GetLicenses returns all the licenses of the components of the report, sorted and without duplicates,
+// which is the list of licenses to review before redistributing the stack
+func (r *Report) GetLicenses() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	detected := make(map[string]bool)
+	for _, comp := range r.Components {
+		for _, license := range comp.Licenses {
+			detected[license] = true
+		}
+	}
+	var licenses []string
+	for license := range detected {
+		licenses = append(licenses, license)
+	}
+	sort.Strings(licenses)
+	return licenses
+}
+
+func (e *InstallError) Error() string {
+	var failed []string
+	for _, comp := range e.Report.WithStatus(StatusFailed) {
+		failed = append(failed, comp.Name)
+	}
+	var skipped []string
+	for _, comp := range e.Report.WithStatus(StatusSkipped) {
+		skipped = append(skipped, comp.Name)
+	}
+	msg := fmt.Sprintf("%d component(s) failed to install (%s)", len(failed), strings.Join(failed, ", "))
+	if len(skipped) > 0 {
+		msg += fmt.Sprintf(", %d component(s) skipped (%s)", len(skipped), strings.Join(skipped, ", "))
+	}
+	return msg
+}
@@ -0,0 +1,89 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/gvallee/go_exec/pkg/advexec"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// getModuleEnvComponents returns a component and all the components it depends on, dependencies first,
+// i.e., the components whose modulefiles are loaded when the modulefile of the component is loaded
+func (c *Config) getModuleEnvComponents(comp *Component, visited map[string]bool) []*Component {
+	if visited[comp.Name] {
+		return nil
+	}
+	visited[comp.Name] = true
+
+	var list []*Component
+	for _, dep := range getDependencies(comp) {
+		depComp := c.getComponent(strings.TrimSpace(dep))
+		if depComp != nil {
+			list = append(list, c.getModuleEnvComponents(depComp, visited)...)
+		}
+	}
+	return append(list, comp)
+}
+
+// getSanityCheckEnv returns the environment defined by the modulefile of a component and the
+// modulefiles of its dependencies, without custom prefix, on top of the current environment
+func (c *Config) getSanityCheckEnv(comp *Component) []string {
+	stackBasedir := c.getStackBasedir()
+	env := buildenv.Env(os.Environ())
+	for _, envComp := range c.getModuleEnvComponents(comp, make(map[string]bool)) {
+		envVars, envLayout := getModuleEnv(stackBasedir, "", envComp)
+		for name, value := range envVars {
+			env.Set(name, value)
+		}
+		for name, dirs := range envLayout {
+			env.Prepend(name, strings.Join(dirs, ":"), ":")
+		}
+		for name, value := range buildenv.GetSanitizerEnv(c.getSanitizers(envComp)) {
+			env.Set(name, value)
+		}
+	}
+	return env
+}
+
+// runSanityCheck executes the sanity check of a component, if any, and records its result in
+// the state of the stack
+func (c *Config) runSanityCheck(comp *Component) error {
+	if comp.SanityCheck == "" {
+		return nil
+	}
+
+	log.Printf("-> Running sanity check of %s: %s", comp.Name, comp.SanityCheck)
+	var cmd advexec.Advcmd
+	cmd.BinPath = "/bin/sh"
+	cmd.CmdArgs = []string{"-c", comp.SanityCheck}
+	cmd.Env = c.getSanityCheckEnv(comp)
+	stackBasedir := c.getStackBasedir()
+	if util.PathExists(stackBasedir) {
+		cmd.ExecDir = stackBasedir
+	}
+	res := cmd.Run()
+	var err error
+	if res.Err != nil {
+		err = fmt.Errorf("sanity check of %s failed: %w - stdout: %s - stderr: %s", comp.Name, res.Err, res.Stdout, res.Stderr)
+		log.Printf("[WARN] %s", err)
+	}
+
+	if c.state != nil {
+		c.state.recordSanityCheck(comp.Name, err != nil)
+		saveErr := c.state.save(stackBasedir)
+		if saveErr != nil {
+			log.Printf("[WARN] unable to save the state of the stack: %s", saveErr)
+		}
+	}
+	return err
+}
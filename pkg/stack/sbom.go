@@ -0,0 +1,154 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SBOMFilename is the name of the software bill of materials, in the CycloneDX JSON format, generated
+	// in the stack base directory when the stack is exported
+	SBOMFilename = "sbom.json"
+)
+
+// SBOMHash is the checksum of a component of a SBOM
+type SBOMHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+// SBOMReference is an external reference of a component of a SBOM, e.g., where its source code comes from
+type SBOMReference struct {
+	Type    string `json:"type"`
+	URL     string `json:"url"`
+	Comment string `json:"comment,omitempty"`
+}
+
+// SBOMLicense is a license of a component of a SBOM, identified by its SPDX identifier or by its name
+type SBOMLicense struct {
+	ID   string `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+// SBOMLicenseChoice is a license of a component of a SBOM, either a license or a SPDX expression
+type SBOMLicenseChoice struct {
+	License    *SBOMLicense `json:"license,omitempty"`
+	Expression string       `json:"expression,omitempty"`
+}
+
+// SBOMComponent is a component of a SBOM
+type SBOMComponent struct {
+	Type               string              `json:"type"`
+	Name               string              `json:"name"`
+	Version            string              `json:"version,omitempty"`
+	Description        string              `json:"description,omitempty"`
+	Author             string              `json:"author,omitempty"`
+	Licenses           []SBOMLicenseChoice `json:"licenses,omitempty"`
+	Hashes             []SBOMHash          `json:"hashes,omitempty"`
+	ExternalReferences []SBOMReference     `json:"externalReferences,omitempty"`
+}
+
+// SBOMMetadata is the metadata of a SBOM, i.e., the stack it describes
+type SBOMMetadata struct {
+	Component SBOMComponent `json:"component"`
+}
+
+// SBOM is the software bill of materials of a stack, in the CycloneDX JSON format
+type SBOM struct {
+	BOMFormat   string          `json:"bomFormat"`
+	SpecVersion string          `json:"specVersion"`
+	Version     int             `json:"version"`
+	Metadata    SBOMMetadata    `json:"metadata"`
+	Components  []SBOMComponent `json:"components"`
+}
+
+// getSBOMLicense returns the license of a component of a SBOM from the license of the component in the stack
+// definition, which is either a single license or a SPDX expression, e.g., "MIT OR Apache-2.0"
+func getSBOMLicense(license string) SBOMLicenseChoice {
+	if strings.ContainsAny(license, " ()") {
+		return SBOMLicenseChoice{Expression: license}
+	}
+	return SBOMLicenseChoice{License: &SBOMLicense{ID: license}}
+}
+
+// getSBOM returns the SBOM of the enabled components of the stack, based on its definition and state, only
+// including a subset of the components when components is not nil
+func (c *Config) getSBOM(components map[string]bool) *SBOM {
+	sbom := &SBOM{
+		BOMFormat:   "CycloneDX",
+		SpecVersion: "1.4",
+		Version:     1,
+		Metadata: SBOMMetadata{
+			Component: SBOMComponent{
+				Type: "application",
+				Name: c.Data.StackDefinition.Name,
+			},
+		},
+	}
+
+	for _, comp := range c.Data.StackDefinition.Components {
+		if comp.Disabled || (components != nil && !components[comp.Name]) {
+			continue
+		}
+		sbomComp := SBOMComponent{
+			Type:        "library",
+			Name:        comp.Name,
+			Version:     comp.Version,
+			Description: comp.Description,
+			Author:      comp.Maintainer,
+		}
+		if comp.License != "" {
+			sbomComp.Licenses = append(sbomComp.Licenses, getSBOMLicense(comp.License))
+		}
+		if comp.Homepage != "" {
+			sbomComp.ExternalReferences = append(sbomComp.ExternalReferences, SBOMReference{Type: "website", URL: comp.Homepage})
+		}
+		url := comp.URL
+		var checksum, revision string
+		if c.state != nil {
+			c.state.mutex.Lock()
+			if compState, ok := c.state.Components[comp.Name]; ok {
+				url = compState.URL
+				checksum = compState.SHA256
+				revision = compState.Revision
+			}
+			c.state.mutex.Unlock()
+		}
+		if checksum != "" {
+			sbomComp.Hashes = append(sbomComp.Hashes, SBOMHash{Algorithm: "SHA-256", Content: checksum})
+		}
+		if url != "" {
+			ref := SBOMReference{Type: "distribution", URL: url}
+			if revision != "" {
+				ref.Type = "vcs"
+				ref.Comment = "revision " + revision
+			}
+			sbomComp.ExternalReferences = append(sbomComp.ExternalReferences, ref)
+		}
+		sbom.Components = append(sbom.Components, sbomComp)
+	}
+	return sbom
+}
+
+// writeSBOM generates the SBOM of the stack, or of a subset of its components, in its base directory
+func (c *Config) writeSBOM(components map[string]bool) error {
+	content, err := json.MarshalIndent(c.getSBOM(components), "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to encode the SBOM of the stack: %w", err)
+	}
+	sbomPath := filepath.Join(c.getStackBasedir(), SBOMFilename)
+	err = ioutil.WriteFile(sbomPath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", sbomPath, err)
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// SignatureGPG is the signing method relying on a GPG detached signature, <tarball>.asc
+	SignatureGPG = "gpg"
+
+	// SignatureCosign is the signing method relying on cosign, either with a key pair or keyless, i.e., with a
+	// short-lived certificate bound to an OIDC identity. The signature is <tarball>.sig and the certificate,
+	// for keyless signing, <tarball>.pem
+	SignatureCosign = "cosign"
+)
+
+// ExportSignature specifies how the tarballs created by Export() are signed and how Import() verifies them
+type ExportSignature struct {
+	// Method is the signing method, i.e., SignatureGPG or SignatureCosign
+	Method string `json:"method"`
+
+	// Key is, with GPG, the ID of the key used to sign the tarball, the default key when empty; with cosign,
+	// the path or URI, e.g., a KMS, of the private key, keyless signing being used when empty (optional)
+	Key string `json:"key"`
+
+	// PublicKey is, with GPG, the keyring used to verify signatures, the default keyring when empty; with cosign,
+	// the path or URI of the public key, required unless keyless signing is used (optional)
+	PublicKey string `json:"public_key"`
+
+	// Identity is the identity, e.g., an email address, the certificate of keyless cosign signatures must be issued for
+	Identity string `json:"identity"`
+
+	// Issuer is the OIDC issuer the certificate of keyless cosign signatures must come from,
+	// e.g., https://accounts.google.com
+	Issuer string `json:"issuer"`
+}
+
+// getExportSignature returns how the exported tarballs are signed, nil if they are not, relative paths to keys
+// being resolved against the directory of the configuration file
+func (c *Config) getExportSignature() *ExportSignature {
+	if c.Data.StackConfig == nil || c.Data.StackConfig.ExportSignature == nil {
+		return nil
+	}
+	s := *c.Data.StackConfig.ExportSignature
+	resolve := func(keyPath string) string {
+		// Keys may also be URIs, e.g., for a KMS
+		if keyPath == "" || filepath.IsAbs(keyPath) || strings.Contains(keyPath, "://") || c.ConfigFilePath == "" {
+			return keyPath
+		}
+		return filepath.Join(filepath.Dir(c.ConfigFilePath), keyPath)
+	}
+	if s.Method == SignatureCosign {
+		s.Key = resolve(s.Key)
+	}
+	s.PublicKey = resolve(s.PublicKey)
+	return &s
+}
+
+// keyless returns whether cosign keyless signing is used
+func (s *ExportSignature) keyless() bool {
+	return s.Method == SignatureCosign && s.Key == "" && s.PublicKey == ""
+}
+
+// getSignatureFiles returns the files, next to a tarball, storing its signature
+func (s *ExportSignature) getSignatureFiles(tarballPath string) []string {
+	if s.Method == SignatureGPG {
+		return []string{tarballPath + ".asc"}
+	}
+	if s.keyless() {
+		return []string{tarballPath + ".sig", tarballPath + ".pem"}
+	}
+	return []string{tarballPath + ".sig"}
+}
+
+// runCryptoCmd runs a gpg, cosign or age command
+func runCryptoCmd(bin string, args []string) error {
+	binPath, err := exec.LookPath(bin)
+	if err != nil {
+		return fmt.Errorf("%s is not available: %w", bin, err)
+	}
+	cmd := exec.Command(binPath, args...)
+	var stderr, stdout bytes.Buffer
+	cmd.Stderr = &stderr
+	cmd.Stdout = &stdout
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+	}
+	return nil
+}
+
+// sign signs a tarball created by Export()
+func (s *ExportSignature) sign(tarballPath string) error {
+	var bin string
+	var args []string
+	switch s.Method {
+	case SignatureGPG:
+		bin = "gpg"
+		args = []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", tarballPath + ".asc"}
+		if s.Key != "" {
+			args = append(args, "--local-user", s.Key)
+		}
+	case SignatureCosign:
+		bin = "cosign"
+		args = []string{"sign-blob", "--yes", "--output-signature", tarballPath + ".sig"}
+		if s.keyless() {
+			args = append(args, "--output-certificate", tarballPath+".pem")
+		} else {
+			if s.Key == "" {
+				return fmt.Errorf("the private key to sign %s is not specified", tarballPath)
+			}
+			args = append(args, "--key", s.Key)
+		}
+	default:
+		return fmt.Errorf("unsupported signing method: %s", s.Method)
+	}
+	err := runCryptoCmd(bin, append(args, tarballPath))
+	if err != nil {
+		return fmt.Errorf("unable to sign %s: %w", tarballPath, err)
+	}
+	return nil
+}
+
+// verify checks the signature of a tarball before it is imported
+func (s *ExportSignature) verify(tarballPath string) error {
+	for _, signatureFile := range s.getSignatureFiles(tarballPath) {
+		if !util.FileExists(signatureFile) {
+			return fmt.Errorf("%s is not signed: %s does not exist", tarballPath, signatureFile)
+		}
+	}
+
+	var bin string
+	var args []string
+	switch s.Method {
+	case SignatureGPG:
+		bin = "gpg"
+		args = []string{"--batch"}
+		if s.PublicKey != "" {
+			keyring, err := filepath.Abs(s.PublicKey)
+			if err != nil {
+				return fmt.Errorf("unable to get the absolute path of %s: %w", s.PublicKey, err)
+			}
+			args = append(args, "--no-default-keyring", "--keyring", keyring)
+		}
+		args = append(args, "--verify", tarballPath+".asc")
+	case SignatureCosign:
+		bin = "cosign"
+		args = []string{"verify-blob", "--signature", tarballPath + ".sig"}
+		if s.keyless() {
+			if s.Identity == "" || s.Issuer == "" {
+				return fmt.Errorf("the identity and issuer of the signature of %s are not specified", tarballPath)
+			}
+			args = append(args, "--certificate", tarballPath+".pem", "--certificate-identity", s.Identity, "--certificate-oidc-issuer", s.Issuer)
+		} else {
+			if s.PublicKey == "" {
+				return fmt.Errorf("the public key to verify %s is not specified", tarballPath)
+			}
+			args = append(args, "--key", s.PublicKey)
+		}
+	default:
+		return fmt.Errorf("unsupported signing method: %s", s.Method)
+	}
+	err := runCryptoCmd(bin, append(args, tarballPath))
+	if err != nil {
+		return fmt.Errorf("invalid signature for %s: %w", tarballPath, err)
+	}
+	log.Printf("-> Signature of %s successfully verified", tarballPath)
+	return nil
+}
@@ -8,17 +8,26 @@ package stack
 
 import (
 	"bytes"
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"text/template"
+	"time"
 
 	"github.com/gvallee/go_software_build/internal/pkg/module"
+	"github.com/gvallee/go_software_build/pkg/app"
 	"github.com/gvallee/go_software_build/pkg/builder"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_software_build/pkg/metrics"
 	"github.com/gvallee/go_util/pkg/util"
 )
 
@@ -28,6 +37,171 @@ type StackCfg struct {
 	InstallDir string `json:"installDir"`
 
 	System string `json:"system"`
+
+	// DisabledComponents is the components of the stack not to install on this deployment, in addition to the ones
+	// disabled in the stack definition, so that sites do not have to edit the shared definition (optional)
+	DisabledComponents []string `json:"disabled_components"`
+
+	// EnabledComponents is the components disabled in the stack definition to install on this deployment anyway
+	// (optional)
+	EnabledComponents []string `json:"enabled_components"`
+
+	// Owner is the user that should own the installed software and modulefiles (optional, requires privileges)
+	Owner string `json:"owner"`
+
+	// Group is the group that should own the installed software and modulefiles (optional, requires privileges)
+	Group string `json:"group"`
+
+	// MirrorRewrites is a set of rules to get the source code of the components from an organization-wide mirror,
+	// e.g., {"https://github.com/": "https://mirror.example.com/github/"}. A rewritten URL is tried first (optional).
+	MirrorRewrites map[string]string `json:"mirror_rewrites"`
+
+	// GitCacheDir is the directory where Git repositories are cached across installations of the stack (optional)
+	GitCacheDir string `json:"git_cache_dir"`
+
+	// Credentials are the credentials to use to download the source code of the components, based on their URL (optional).
+	// Values may refer to environment variables, e.g., {"url_prefix": "https://gitlab.example.com/", "headers": {"PRIVATE-TOKEN": "$GITLAB_TOKEN"}}
+	Credentials []buildenv.Credential `json:"credentials"`
+
+	// NetrcFile is the path to a netrc file providing credentials, ~/.netrc by default (optional)
+	NetrcFile string `json:"netrc"`
+
+	// CABundle is the path to a PEM file with the certificates of additional authorities to trust when downloading
+	// the source code of the components, e.g., the internal authority of an organization (optional)
+	CABundle string `json:"ca_bundle"`
+
+	// InsecureTLS specifies whether the certificates of the servers are not verified when downloading the source code
+	// of the components; credentials are never sent in this mode (optional)
+	InsecureTLS bool `json:"insecure_tls"`
+
+	// GitLabHosts are the host names of the self-hosted GitLab instances, e.g., gitlab.example.com, hosting components
+	// whose version is a constraint or that come from release assets; only gitlab.com is supported otherwise (optional)
+	GitLabHosts []string `json:"gitlab_hosts"`
+
+	// SharedConfigureCache specifies whether the autotools components share a configure cache, one per toolchain,
+	// which significantly reduces the time required to configure stacks with many small components (optional)
+	SharedConfigureCache bool `json:"shared_configure_cache"`
+
+	// StagedInstall specifies whether the components are installed in a staging directory before being copied to
+	// their installation directory, which records the exact list of their installed files and avoids partially
+	// written installation directories when an installation fails (optional)
+	StagedInstall bool `json:"staged_install"`
+
+	// Dedup specifies whether the identical files of the components are replaced with hard links once the stack
+	// is installed, reducing its footprint (optional, see Config.Dedup)
+	Dedup bool `json:"dedup"`
+
+	// DedupPoolDir is a content-addressed pool the identical files are linked to, which can be shared by several
+	// stacks to deduplicate files across them; it must be on the same file system as the stacks (optional)
+	DedupPoolDir string `json:"dedup_pool_dir"`
+
+	// Strip specifies whether the installed binaries and libraries are stripped, which significantly reduces the
+	// size of the stack and of its exports (optional)
+	Strip bool `json:"strip"`
+
+	// SplitDebugInfo specifies whether the debug information of the binaries and libraries is saved in the debug
+	// directory of the stack, which is not exported, before they are stripped; it implies Strip (optional)
+	SplitDebugInfo bool `json:"split_debug_info"`
+
+	// PruneBuildTrees specifies whether the content of the build and source directories of the components is removed
+	// once they are successfully installed, except the execution manifests and the logs, since the intermediate
+	// objects of a large stack can take tens of GB (optional, see Component.PruneBuildTree and Component.KeepBuildDir)
+	PruneBuildTrees bool `json:"prune_build_trees"`
+
+	// ArtifactServers are the Artifactory/Nexus servers hosting source code or exported stacks (optional).
+	// Component URLs pointing at these servers may use buildenv.LatestVersion to get the latest version available.
+	ArtifactServers []buildenv.ArtifactServer `json:"artifact_servers"`
+
+	// ResourceLimits is the resource limits of the build commands of all the components, e.g., niceness or
+	// maximum number of parallel jobs, so that builds on shared nodes do not starve other users (optional)
+	ResourceLimits buildenv.ResourceLimits `json:"resource_limits"`
+
+	// GateModulesOnSanityCheck specifies whether modulefiles are generated only for the components whose
+	// last sanity check succeeded (see Component.SanityCheck) (optional)
+	GateModulesOnSanityCheck bool `json:"gate_modules_on_sanity_check"`
+
+	// ValidateModulefiles specifies whether the modulefiles are loaded in a clean shell once they are generated,
+	// their generation failing when they cannot be loaded or do not define the expected environment (see
+	// Config.ValidateModules) (optional)
+	ValidateModulefiles bool `json:"validate_modulefiles"`
+
+	// ModuleCmd is the command loading modulefiles to validate them, e.g., "/usr/share/lmod/lmod/libexec/lmod",
+	// invoked as '<ModuleCmd> sh load <name>'. modulecmd or Lmod is used when not specified (optional)
+	ModuleCmd string `json:"module_cmd"`
+
+	// ModulefileTemplates is the path to the text/template used to generate the modulefiles of each format, e.g.,
+	// {"tcl": "/path/to/template"}, to include site-specific content such as logging hooks. Templates are executed
+	// with the content of the modulefile, e.g., {{.Name}}, {{.EnvVars}}, {{.Stack.Dir}} or {{.Content "tcl"}} for
+	// the default content. Relative paths are relative to the directory of the configuration file (optional)
+	ModulefileTemplates map[string]string `json:"modulefile_templates"`
+
+	// AttestationKey is the path to the Ed25519 private key, in the PKCS #8 PEM format, used to sign the in-toto/SLSA
+	// attestations generated for each installed component and for exported stacks, so that consumers can verify
+	// where binaries come from (see VerifyAttestation). Relative paths are relative to the directory of the
+	// configuration file (optional)
+	AttestationKey string `json:"attestation_key"`
+
+	// ExportSignature specifies how the tarballs created by Export() are signed. When set, Import() refuses
+	// tarballs whose signature cannot be verified (optional)
+	ExportSignature *ExportSignature `json:"export_signature"`
+
+	// ExportEncryption specifies how the tarballs created by Export() are encrypted, e.g., for private stacks
+	// shipped over shared storage, and how Import() decrypts them (optional)
+	ExportEncryption *ExportEncryption `json:"export_encryption"`
+
+	// OptimizationProfile is the optimization profile whose compiler flags are injected into the build environment
+	// of the components, i.e., generic, native, x86-64-v3 or neoverse-n1 (see buildenv.GetOptimizationFlags) (optional)
+	OptimizationProfile string `json:"optimization_profile"`
+
+	// BuildType is the build type of the components, i.e., debug, release or relwithdebinfo, which selects the
+	// compiler flags and the CMake and meson build types, e.g., to produce a debug variant of a stack (optional)
+	BuildType string `json:"build_type"`
+
+	// Sanitizers is the sanitizers the components are instrumented with, i.e., address, undefined and/or thread,
+	// the modulefiles of the components setting the matching runtime options, e.g., ASAN_OPTIONS (optional)
+	Sanitizers []string `json:"sanitizers"`
+
+	// SanitizedComponents is the components instrumented with Sanitizers, all the components when empty (optional)
+	SanitizedComponents []string `json:"sanitized_components"`
+
+	// Providers is the component providing each virtual package, e.g., {"mpi": "mvapich2"}, the first component
+	// of the stack providing a virtual package being used when not specified (see Component.Provides) (optional)
+	Providers map[string]string `json:"providers"`
+
+	// Hermetic specifies whether the components are built in a minimal environment instead of the environment of
+	// the caller, i.e., with only a few variables such as HOME and a standard PATH extended with the bin directories
+	// of the components of the stack, so that the stack does not depend on the shell configuration of whoever
+	// installs it. Other variables must be set explicitly with Component.BuildEnv or listed in HermeticEnv (optional)
+	Hermetic bool `json:"hermetic"`
+
+	// IsolatedBuilds specifies whether the build commands of each component are executed in unshared user and
+	// mount namespaces, using bubblewrap, in which only the installation directories of its dependencies are
+	// visible, read-only, in the installation directory of the stack and the file system of the host is read-only,
+	// so that undeclared dependencies and installations outside of the stack make the build fail (optional)
+	IsolatedBuilds bool `json:"isolated_builds"`
+
+	// HashedInstallPrefixes specifies whether each component is installed in install/<name>-<hash> instead of
+	// install/<name>/<version>, the hash being the hash of everything that changes its installation, e.g., its
+	// version, configure parameters, variants and the hashes of its dependencies, so that several configurations
+	// of a component can coexist and installations can be cached without collision (optional)
+	HashedInstallPrefixes bool `json:"hashed_install_prefixes"`
+
+	// HermeticEnv is the name of the variables of the environment of the caller that hermetic build environments
+	// inherit in addition to the default ones, e.g., http_proxy or PATH (optional)
+	HermeticEnv []string `json:"hermetic_env"`
+
+	// Toolchains is the toolchains the stack is built with by InstallMatrix(), e.g., gcc-12, clang-17 and nvhpc,
+	// each one in its own installation tree with its own modulefiles (see Toolchain) (optional)
+	Toolchains []Toolchain `json:"toolchains"`
+
+	// MetricsFile is the path to the file the metrics of the builds are written to, in the Prometheus text format,
+	// after each installation of the stack, e.g., for the textfile collector of the node exporter (see
+	// metrics.Default). Relative paths are relative to the directory of the configuration file (optional)
+	MetricsFile string `json:"metrics_file"`
+
+	// Webhooks is the URLs the lifecycle events of the installations of the stack are posted to, e.g., when a
+	// component fails (see Webhook) (optional)
+	Webhooks []Webhook `json:"webhooks"`
 }
 
 type Component struct {
@@ -47,17 +221,201 @@ type Component struct {
 	ConfigId string `json:"configure_id"`
 
 	// ConfigureDependency represents the dependencies for the software component, must be the name of another component
+	// or of a virtual package, e.g., 'mpi', replaced with the component providing it (see Provides)
 	ConfigureDependency string `json:"configure_dependency"`
 
+	// DependencyFlags specifies whether the locations of the dependencies are also added to CPPFLAGS, LDFLAGS,
+	// PKG_CONFIG_PATH and CMAKE_PREFIX_PATH, for software that does not take --with-<id>=<dir> options (optional)
+	DependencyFlags bool `json:"dependency_flags"`
+
 	// ConfigurePrelude is the command to execute before configuring the software component. Can be used to initialize Git submodules for example.
 	ConfigurePrelude string `json:"configure_prelude"`
 
 	// ConfigureParams represents the additional configure parameters
 	ConfigureParams string `json:"configure_params"`
 
-	// BuildEnv represents the environment to use while building the component
+	// BuildEnv represents the environment to use while building the component, e.g., CC=gcc-12 CFLAGS="-O2 -g".
+	// Values containing spaces must be quoted.
 	BuildEnv string `json:"build_env"`
 
+	// Version is the version of the software component (optional). When specified, the component is installed
+	// in install/<name>/<version> so that a new version can be installed before the previous one is removed (see GC).
+	// For components hosted on GitHub or GitLab, it can be "latest" or a constraint such as ">=4.1 <5", resolved
+	// with the releases of the project when the stack is loaded and recorded in the state of the stack, which is
+	// then used until the version is changed in the definition. The tag of the release is checked out for Git
+	// repositories when no branch is specified.
+	Version string `json:"version"`
+
+	// EnvVarPrefix is the prefix of the environment variables the modulefile of the component sets, e.g., 'HPCX_OMPI'
+	// for HPCX_OMPI_DIR and HPCX_OMPI_BUILD_DIR. It overrides the custom prefix of the stack's modulefiles.
+	// When not specified, the custom prefix followed by the name of the component in uppercase is used (optional)
+	EnvVarPrefix string `json:"env_var_prefix"`
+
+	// Family is the name shared by the components providing different versions or implementations of the
+	// same software, e.g., 'openmpi' or 'mpi'. The modulefiles of the components of a family conflict with
+	// each other. When not specified, it is the name of the component without its version, e.g., 'openmpi'
+	// for 'openmpi-5.0' with version '5.0' (optional)
+	Family string `json:"family"`
+
+	// Description is a short description of the component, e.g., "Open MPI implementation of MPI", included in its
+	// modulefile (module whatis), the SBOM, the installation report and the status of the stack (optional)
+	Description string `json:"description"`
+
+	// Homepage is the URL of the website of the component (optional)
+	Homepage string `json:"homepage"`
+
+	// License is the license of the component, preferably as a SPDX identifier or expression, e.g., BSD-3-Clause (optional)
+	License string `json:"license"`
+
+	// Maintainer is who maintains the component in the stack, e.g., "HPC team <hpc@example.com>" (optional)
+	Maintainer string `json:"maintainer"`
+
+	// Checksum is the expected SHA256 checksum of the component's tarball (optional).
+	// When not specified, the checksum recorded in the state of the stack during a previous installation is used.
+	Checksum string `json:"sha256"`
+
+	// SourceType is the type of the component's source code: tarball, git, dir or file (optional).
+	// It is only required when the type cannot be detected from the URL, e.g., for a Git repository
+	// whose URL does not end with .git.
+	SourceType string `json:"source_type"`
+
+	// SubDir is the subdirectory of the source tree from which the component is configured and built, e.g., contrib/tool (optional)
+	SubDir string `json:"sub_dir"`
+
+	// BuildSystem is the name of the build system of the component, e.g., cmake. It is automatically detected when not specified (optional)
+	BuildSystem string `json:"build_system"`
+
+	// Preset is the name of the built-in preset providing the known-good configuration of the component, e.g.,
+	// "openmpi", so that the definition of common HPC packages only requires a name and a URL (see GetPresets) (optional)
+	Preset string `json:"preset"`
+
+	// MakeVars is the variables to set on the make command line, e.g., {"PREFIX": "@ref:ompi_install_dir@"}. Values can refer to other components (optional)
+	MakeVars map[string]string `json:"make_vars"`
+
+	// BuildTargets is the list of make targets to build the component, e.g., ["all", "docs"] (optional)
+	BuildTargets []string `json:"build_targets"`
+
+	// InstallTargets is the list of make targets to install the component, e.g., ["install-strip"] (optional)
+	InstallTargets []string `json:"install_targets"`
+
+	// Test specifies whether the test suite of the component, e.g., 'make check', must be executed after its installation (optional)
+	Test bool `json:"test"`
+
+	// TestsMustPass specifies whether the installation of the stack fails when tests of the component fail. It implies Test (optional)
+	TestsMustPass bool `json:"tests_must_pass"`
+
+	// SanityCheck is a command, e.g., "ucx_info -d", executed through a shell after the installation of the component,
+	// in the environment its modulefile and the modulefiles of its dependencies define. Failures are reported in the
+	// report of the installation but do not make the installation fail (optional)
+	SanityCheck string `json:"sanity_check"`
+
+	// Verify specifies how to verify the installation of the component, e.g., expected binaries and libraries,
+	// so that a broken installation is detected at install time (optional)
+	Verify *builder.Verification `json:"verify"`
+
+	// Plugin is the path to an executable performing the configuration, build and installation of the component.
+	// It receives the build environment as JSON on its standard input (see builder.PluginInput). Cannot be used with BuildSystem (optional)
+	Plugin string `json:"plugin"`
+
+	// ReleaseAsset is the pattern of the name of the file to download from a release of the GitHub or GitLab
+	// project URL refers to, e.g., "openmpi-*.tar.bz2", instead of specifying the full download URL (optional)
+	ReleaseAsset string `json:"release_asset"`
+
+	// ReleaseTag is the tag of the release ReleaseAsset is downloaded from, e.g., "v4.1.5" or "latest". When not
+	// specified, it is the tag Version was resolved to or, when Version is an actual version, "v<version>" or
+	// "<version>". Can refer to other components, e.g., @ref:ompi_version@ (optional)
+	ReleaseTag string `json:"release_tag"`
+
+	// Mirrors is a list of alternate URLs to get the source code from, tried in order when URL is not available (optional)
+	Mirrors []string `json:"mirrors"`
+
+	// NoConfigureCache specifies whether the component must not use the shared configure cache, e.g., because
+	// its configure script is not compatible with it (see StackCfg.SharedConfigureCache)
+	NoConfigureCache bool `json:"no_configure_cache"`
+
+	// NoStagedInstall specifies whether the component must be installed directly in its installation directory,
+	// e.g., because it does not support DESTDIR (see StackCfg.StagedInstall)
+	NoStagedInstall bool `json:"no_staged_install"`
+
+	// NoStrip specifies whether the binaries and libraries of the component must not be stripped, e.g., because
+	// they must be debugged or are modified after installation (see StackCfg.Strip)
+	NoStrip bool `json:"no_strip"`
+
+	// PruneBuildTree specifies whether the content of the build and source directories of the component is removed
+	// once it is successfully installed, even when the build trees of the stack are not, unless KeepBuildDir is set (see StackCfg.PruneBuildTrees)
+	PruneBuildTree bool `json:"prune_build_tree"`
+
+	// KeepBuildDir specifies whether the build and source directories of the component must be kept once it is
+	// installed, e.g., because its tests are run from its build tree, regardless of the pruning of the build trees
+	// of the stack, so that its build directory remains valid (see GetCompBuildDir and StackCfg.PruneBuildTrees)
+	KeepBuildDir bool `json:"keep_build_dir"`
+
+	// ScratchBasedir is the directory where the temporary data of the component is stored instead of the scratch
+	// directory of the stack, e.g., /dev/shm or a node-local NVMe drive, in a subdirectory specific to the stack (optional)
+	ScratchBasedir string `json:"scratch_basedir"`
+
+	// BuildBasedir is the directory where the component is built instead of the build directory of the stack,
+	// e.g., /dev/shm for a small component with a configure-heavy build, in a subdirectory specific to the stack.
+	// The build directory of the component in the stack is a link to it (see GetCompBuildDir) (optional)
+	BuildBasedir string `json:"build_basedir"`
+
+	// OptimizationProfile is the optimization profile to build the component with, overriding the one of the
+	// stack (see StackCfg.OptimizationProfile), e.g., "generic" for a component that is sensitive to aggressive
+	// optimizations (optional)
+	OptimizationProfile string `json:"optimization_profile"`
+
+	// BuildType is the build type of the component, overriding the one of the stack (see StackCfg.BuildType),
+	// e.g., "debug" for a component being investigated (optional)
+	BuildType string `json:"build_type"`
+
+	// Disabled specifies whether the component must be ignored, e.g., not installed and no modulefile generated.
+	// It is the default of all the deployments of the stack, which can select their own components (see
+	// StackCfg.DisabledComponents and StackCfg.EnabledComponents)
+	Disabled bool `json:"disabled"`
+
+	// Variants is the variants the component is built with, e.g., {"cuda": "on", "fortran": "off"}, translated into
+	// configure parameters by VariantFlags or the preset of the component. The component is installed again when
+	// its variants change (optional)
+	Variants map[string]string `json:"variants"`
+
+	// VariantFlags is the configure or CMake parameters of each value of the variants of the component, e.g.,
+	// {"cuda=on": "-DENABLE_CUDA=ON", "cuda=off": "-DENABLE_CUDA=OFF"}, taking precedence over the ones of its
+	// preset. Parameters can refer to other components (optional)
+	VariantFlags map[string]string `json:"variant_flags"`
+
+	// Provides is the virtual packages the component provides, e.g., ["mpi"], so that other components can depend on
+	// a virtual package and the configuration of the stack selects the component providing it, the other providers
+	// being disabled (see StackCfg.Providers) (optional)
+	Provides []string `json:"provides"`
+
+	// Toolchain specifies whether the component is a toolchain, e.g., gcc or binutils, installed with the components it
+	// depends on before the other components of the stack, which are then built with it: its compilers are set in
+	// their environment, e.g., CC, and its libraries are used at build time and run time (optional)
+	Toolchain bool `json:"toolchain"`
+
+	// Compilers is the compilers a toolchain component provides, relative to its bin directory, e.g., {"CC": "gcc-13",
+	// "CXX": "g++-13"}, detected when not specified (see Toolchain) (optional)
+	Compilers map[string]string `json:"compilers"`
+
+	// External is the path to the installation of the component when it is pre-installed, e.g., /usr/local/cuda
+	// or a vendor MPI. External components are not built, their source code being ignored, but other components
+	// can depend on them and refer to them, and they have a modulefile (optional)
+	External string `json:"external"`
+
+	// InstallCmd is the command to execute to install the component when it does not rely on a standard
+	// 'make install', e.g., './b2 install --prefix=$PREFIX' or 'pip install --prefix "$PREFIX" .' (optional).
+	// It is executed by a shell from the component's source directory, PREFIX being the directory where the
+	// component must be installed.
+	InstallCmd string `json:"install_cmd"`
+
+	// PreInstallCmd is a shell command executed from the component's build directory right before it is installed,
+	// PREFIX being its install directory (optional)
+	PreInstallCmd string `json:"pre_install_cmd"`
+
+	// PostInstallCmd is a shell command executed from the component's install directory right after it is
+	// installed, PREFIX being its install directory (optional)
+	PostInstallCmd string `json:"post_install_cmd"`
+
 	// InstallDir is the absolute path to the directory where the component is installed
 	InstallDir string
 
@@ -66,6 +424,13 @@ type Component struct {
 
 	// SrcDir is the absolute path to the directory where the component's source code is
 	SrcDir string
+
+	// presetApplied specifies whether the definition of the component was completed with its preset
+	presetApplied bool
+
+	// installHash is the hash of the installation prefix of the component when the installation prefixes of the
+	// stack are hashed (see StackCfg.HashedInstallPrefixes)
+	installHash string
 }
 
 type StackDef struct {
@@ -84,18 +449,44 @@ type StackDef struct {
 
 type Stack struct {
 	Private         bool
-	BuildEnv        []string
+	BuildEnv        buildenv.Env
 	StackConfig     *StackCfg
 	StackDefinition *StackDef
 }
 
 type Config struct {
-	// DefFilePath is the path to the file defining the stack
+	// DefFilePath is the path to the file defining the stack. It can also be a HTTP(S) URL or a file in a Git
+	// repository, i.e., <repository>.git#[<ref>:]<path>, e.g., https://github.com/org/stacks.git#v1.0:hpc.json,
+	// so that sites can directly consume the definitions a central team publishes
 	DefFilePath string
 
-	// ConfigFilePath is the path to the file specifying the configuration of the stack
+	// DefFileSHA256 is the expected SHA256 checksum of the definition file when it is remote (optional)
+	DefFileSHA256 string
+
+	// OverlayFilePaths is the list of overlays patching the definition of the stack when it is loaded, e.g., to
+	// override the URL of a component, add configure parameters or disable a component on a specific site without
+	// copying the whole definition (see StackDef.ApplyOverlay). Overlays are applied in order, each one on top of
+	// the definition patched by the previous ones, so the last one takes precedence. Like DefFilePath, overlays can
+	// be remote (optional)
+	OverlayFilePaths []string
+
+	// ConfigFilePath is the path to the file specifying the configuration of the stack. Like DefFilePath, it can
+	// be remote, in which case relative paths in the configuration must not be used
 	ConfigFilePath string
 
+	// ConfigFileSHA256 is the expected SHA256 checksum of the configuration file when it is remote (optional)
+	ConfigFileSHA256 string
+
+	// RemoteCacheDir is the directory where remote definition and configuration files are cached, e.g., to
+	// load them without network access, go_software_build in the cache directory of the user by default
+	RemoteCacheDir string
+
+	// RemoteCABundle is the path to a PEM file with the certificates of additional authorities to trust when
+	// fetching a remote configuration file (optional). The configuration file is otherwise only fetched with the
+	// credentials of the netrc file; the definition and the overlays are fetched once the configuration is loaded,
+	// with its download settings, e.g., StackCfg.CABundle and StackCfg.Credentials.
+	RemoteCABundle string
+
 	// Loaded specifies is the stack configuration is ready to be used or not, either through manual setting or parsing of configuration files.
 	Loaded bool
 
@@ -110,6 +501,132 @@ type Config struct {
 
 	// SrcComponents is the map of all software components' source code for the stack. The key is the name of the component and the value the directory where the component's source code is
 	SrcComponents map[string]string
+
+	// KeepGoing specifies whether the installation of the stack continues when a component fails to install.
+	// Components depending on a component that failed are skipped and an InstallError is returned at the end.
+	KeepGoing bool
+
+	// OnFailure is called when a component fails to install to choose what to do, e.g., by asking the operator
+	// whether the component must be installed again. KeepGoing decides when it is not set or returns an empty
+	// action (optional)
+	OnFailure FailureFn
+
+	// Report is the report of the last installation of the stack
+	Report *Report
+
+	// SourceOverrides is the map of the components that must be built from a local copy of their source code,
+	// e.g., a developer's working tree, instead of their URL. The key is the name of the component and the value
+	// the path to the local source code, which is synchronized into the build directory before every build.
+	// Overridden components are not recorded in the state of the stack.
+	SourceOverrides map[string]string
+
+	// ExportComponents is the components included in the tarballs created by Export(), all the components of the
+	// stack when empty, e.g., to ship only the runtime components to compute nodes without the compiler toolchain.
+	// The state of the stack is always exported as a whole (optional)
+	ExportComponents []string
+
+	// ExportBase is the path to the manifest of a previous export of the stack (see ExportManifestFilename). When
+	// set, Export() creates an incremental export, i.e., only including the installation directories of the
+	// components that changed since then, which can be imported once the previous export is imported (optional)
+	ExportBase string
+
+	// ExportDependencies specifies whether the components ExportComponents depend on, directly or not, are also
+	// included in the tarballs created by Export() (optional)
+	ExportDependencies bool
+
+	// ImportPolicy specifies how Import() resolves the conflicts between the imported components and the components
+	// already in the stack. When set, the imported components are merged with the stack instead of being extracted
+	// over it, the conflicts being reported in ImportConflicts (optional)
+	ImportPolicy ImportPolicy
+
+	// OnImportConflict is called for each conflict detected by Import() to choose how it is resolved, ImportPolicy
+	// being used when it returns an empty policy (optional)
+	OnImportConflict func(conflict *ImportConflict) ImportPolicy
+
+	// ImportConflicts is the conflicts detected by the last Import() merging components with the stack
+	ImportConflicts []ImportConflict
+
+	// FetchJobs is the maximum number of components fetched concurrently by Fetch(), 0 meaning no limit
+	FetchJobs int
+
+	// BuildTimesFile is the path to the file recording the build durations of the components, shared by all the
+	// stacks to estimate the duration of the next installations (see OnProgress), build_times.json in the
+	// go_software_build cache directory of the user by default
+	BuildTimesFile string
+
+	// OnProgress is called when the installation of each component of the stack starts and completes, with the
+	// estimated remaining time (optional)
+	OnProgress ProgressFn
+
+	// EventStream is where the progress of the installations of the stack is written as it happens, as JSON
+	// lines, i.e., one ProgressEvent per line, e.g., a file or a socket, so that external user interfaces and CI
+	// plugins can render it live without parsing the logs (optional)
+	EventStream io.Writer
+
+	// Notifiers is notified of the lifecycle events of the installations of the stack, e.g., a SlackNotifier to
+	// ping the engineer responsible for the stack when a component fails, in addition to the webhooks of the
+	// stack configuration (optional)
+	Notifiers []Notifier
+
+	// LockTimeout is how long InstallStack(), Export(), Import() and GenerateModules() wait for another operation
+	// holding the lock of the stack to release it, 0 meaning failing immediately and a negative value waiting
+	// forever. Locks left by processes that are not running anymore are removed.
+	LockTimeout time.Duration
+
+	// state is the persistent state of the stack
+	state *State
+
+	// installedComponents and configIds are the components installed and their configuration identifiers when
+	// components are installed individually with InstallComponent()
+	installedComponents map[string]string
+	configIds           map[string]string
+
+	// mutex protects the maps of the components (e.g., InstalledComponents), the build environment of the
+	// stack, its state and the components fetched so that components can be installed concurrently
+	mutex sync.RWMutex
+
+	// loadMutex serializes the loading of the configuration by concurrent calls to InstallComponent(); it is
+	// distinct from mutex since loading the configuration takes mutex
+	loadMutex sync.Mutex
+
+	// fetched tracks the components fetched with Fetch() so their source code is not updated again during the installation
+	fetched map[string]bool
+
+	// retried tracks the components installed again after a failure (see FailureRetry)
+	retried map[string]bool
+
+	// bundle is the bundle the source code of the components comes from, if any (see UseBundle)
+	bundle *bundle
+
+	// providers is the component selected to provide each virtual package of the stack (see Component.Provides)
+	providers map[string]string
+
+	// toolchain is the toolchain of the compiler matrix the stack is built with, if any (see InstallMatrix)
+	toolchain *Toolchain
+
+	// lockPath is the path to the lock file of the stack when it is locked and lockCount the number of operations
+	// of the configuration sharing the lock (see lock())
+	lockPath  string
+	lockCount int
+
+	// traceContext is the context holding the span of the installation of the stack, if any (see metrics.SetTracer)
+	traceContext context.Context
+
+	// eventMutex serializes the writes to the event stream, eventStreamFailed specifies whether a write failed and
+	// eventPercent is the percentage of the stack installed so far, as reported in the event stream
+	eventMutex        sync.Mutex
+	eventStreamFailed bool
+	eventPercent      float64
+
+	// PreStack is executed before installing any component of the stack, e.g., to mount a filesystem or request licenses
+	PreStack Hook
+
+	// PostStack is executed once the installation of the stack completed, the error being the InstallError listing
+	// the components that failed when KeepGoing is set and some components failed (STACK_ERROR for commands)
+	PostStack Hook
+
+	// OnComponentFailure is executed when the installation of a component fails, e.g., to send a notification
+	OnComponentFailure Hook
 }
 
 const (
@@ -118,6 +635,71 @@ const (
 	RefEndDelimiter   = "@"
 )
 
+// ModuleFormat is the format of the modulefiles generated for a stack
+type ModuleFormat string
+
+const (
+	// ModuleFormatTcl generates Tcl modulefiles, for Environment Modules and Lmod, in <stack>/modulefiles
+	ModuleFormatTcl ModuleFormat = "tcl"
+
+	// ModuleFormatLua generates Lua modulefiles, for Lmod only, in <stack>/modulefiles_lua
+	ModuleFormatLua ModuleFormat = "lua"
+
+	// ModuleFormatBoth generates both Tcl and Lua modulefiles, in their respective directories
+	ModuleFormatBoth ModuleFormat = "both"
+)
+
+// getModulefileDirs returns the directory where the modulefiles of the stack are generated for each dialect of a format
+func getModulefileDirs(stackBasedir string, format ModuleFormat) (map[module.Dialect]string, error) {
+	tclDir := filepath.Join(stackBasedir, "modulefiles")
+	luaDir := filepath.Join(stackBasedir, "modulefiles_lua")
+	switch format {
+	case ModuleFormatTcl, "":
+		return map[module.Dialect]string{module.DialectTcl: tclDir}, nil
+	case ModuleFormatLua:
+		return map[module.Dialect]string{module.DialectLua: luaDir}, nil
+	case ModuleFormatBoth:
+		return map[module.Dialect]string{module.DialectTcl: tclDir, module.DialectLua: luaDir}, nil
+	}
+	return nil, fmt.Errorf("unsupported module format: %s", format)
+}
+
+// loadModulefileTemplates parses the modulefile templates of the stack configuration for the dialects being generated
+func (c *Config) loadModulefileTemplates(dialects map[module.Dialect]string) (map[module.Dialect]*template.Template, error) {
+	templates := make(map[module.Dialect]*template.Template)
+	for dialect, templatePath := range c.Data.StackConfig.ModulefileTemplates {
+		if _, ok := dialects[module.Dialect(dialect)]; !ok {
+			continue
+		}
+		if !filepath.IsAbs(templatePath) && c.ConfigFilePath != "" {
+			templatePath = filepath.Join(filepath.Dir(c.ConfigFilePath), templatePath)
+		}
+		tmpl, err := module.LoadTemplate(templatePath)
+		if err != nil {
+			return nil, err
+		}
+		templates[module.Dialect(dialect)] = tmpl
+	}
+	return templates, nil
+}
+
+// getCompInstallDir returns the directory where a component is installed, i.e., install/<name>/<version>
+// or install/<name> when the version of the component is not specified, install/<name>-<hash> when the
+// installation prefixes of the stack are hashed, or its path for external components
+func getCompInstallDir(stackBasedir string, comp *Component) string {
+	if comp.External != "" {
+		return comp.External
+	}
+	if comp.installHash != "" {
+		return filepath.Join(stackBasedir, "install", getInstallDirName(comp))
+	}
+	compInstallDir := filepath.Join(stackBasedir, "install", comp.Name)
+	if comp.Version != "" {
+		compInstallDir = filepath.Join(compInstallDir, comp.Version)
+	}
+	return compInstallDir
+}
+
 func GetCompBuildDir(stackBasedir string, compName string) (string, error) {
 	compBuildDir := filepath.Join(stackBasedir, "build", compName)
 	if util.PathExists(compBuildDir) {
@@ -147,6 +729,21 @@ func GetCompSrcDir(stackBasedir string, compName string) (string, error) {
 		// rather than build locally.
 		// If the source directory does exist, we set some optional additional environment
 		// variables.
+		if util.IsDir(filepath.Join(compSrcDir, compName)) {
+			// Each component has its own source directory, in which its tarball is unpacked
+			compSrcDir = filepath.Join(compSrcDir, compName)
+			listSrcDirs, err = ioutil.ReadDir(compSrcDir)
+			if err != nil {
+				return "", fmt.Errorf("unable to read %s: %w", compSrcDir, err)
+			}
+			for _, entry := range listSrcDirs {
+				if entry.IsDir() {
+					targetDir = entry.Name()
+					break
+				}
+			}
+			return filepath.Join(compSrcDir, targetDir), nil
+		}
 		for _, entry := range listSrcDirs {
 			if strings.Contains(entry.Name(), compName) {
 				targetDir = entry.Name()
@@ -159,8 +756,31 @@ func GetCompSrcDir(stackBasedir string, compName string) (string, error) {
 }
 
 func (c *Config) Load() error {
-	// unmarshale the two configuration files
-	defFile, err := os.Open(c.DefFilePath)
+	// unmarshale the two configuration files, the configuration first since its download settings apply to the
+	// definition and its overlays
+	cfgFilePath, err := c.getLocalFile(c.ConfigFilePath, c.ConfigFileSHA256)
+	if err != nil {
+		return err
+	}
+	cfgFile, err := os.Open(cfgFilePath)
+	if err != nil {
+		return fmt.Errorf("unable to open %s: %w", c.ConfigFilePath, err)
+	}
+	cfgContent, err := ioutil.ReadAll(cfgFile)
+	if err != nil {
+		return fmt.Errorf("unable to read the content of %s: %w", c.ConfigFilePath, err)
+	}
+	c.Data.StackConfig = new(StackCfg)
+	err = decodeJSON(cfgContent, c.Data.StackConfig)
+	if err != nil {
+		return fmt.Errorf("unable to unmarshal content of %s: %w", c.ConfigFilePath, err)
+	}
+
+	defFilePath, err := c.getLocalFile(c.DefFilePath, c.DefFileSHA256)
+	if err != nil {
+		return err
+	}
+	defFile, err := os.Open(defFilePath)
 	if err != nil {
 		return fmt.Errorf("unable to open %s: %w", c.DefFilePath, err)
 	}
@@ -169,32 +789,207 @@ func (c *Config) Load() error {
 		return fmt.Errorf("unable to read the content of %s: %w", c.DefFilePath, err)
 	}
 	c.Data.StackDefinition = new(StackDef)
-	err = json.Unmarshal(defContent, &c.Data.StackDefinition)
+	err = decodeJSON(defContent, c.Data.StackDefinition)
 	if err != nil {
 		return fmt.Errorf("unable to unmarshal content of %s: %w", c.DefFilePath, err)
 	}
+	err = c.applyOverlays()
+	if err != nil {
+		return err
+	}
 
-	cfgFile, err := os.Open(c.ConfigFilePath)
+	err = c.selectComponents()
 	if err != nil {
-		return fmt.Errorf("unable to open %s: %w", c.ConfigFilePath, err)
+		return err
 	}
-	cfgContent, err := ioutil.ReadAll(cfgFile)
+	err = c.resolveVersions()
 	if err != nil {
-		return fmt.Errorf("unable to read the content of %s: %w", c.ConfigFilePath, err)
+		return err
 	}
-	c.Data.StackConfig = new(StackCfg)
-	err = json.Unmarshal(cfgContent, &c.Data.StackConfig)
+	err = c.resolveProviders()
 	if err != nil {
-		return fmt.Errorf("unable to unmarshal content of %s: %w", c.ConfigFilePath, err)
+		return err
+	}
+	err = c.Data.StackDefinition.ApplyPresets()
+	if err != nil {
+		return err
+	}
+	err = c.setInstallHashes()
+	if err != nil {
+		return err
+	}
+	err = c.loadComponentLocations()
+	if err != nil {
+		return err
 	}
 	c.Loaded = true
 
 	return nil
 }
 
-func createNewPathForComp(compBinDir string) string {
-	existingPath := os.Getenv("PATH")
-	return "PATH=" + compBinDir + ":" + existingPath + ":$PATH"
+// selectComponents applies the selection of the components of the deployment to the stack definition (see
+// StackCfg.DisabledComponents and StackCfg.EnabledComponents)
+func (c *Config) selectComponents() error {
+	if c.Data.StackConfig == nil {
+		return nil
+	}
+	for _, selection := range []struct {
+		names    []string
+		disabled bool
+	}{
+		{c.Data.StackConfig.EnabledComponents, false},
+		{c.Data.StackConfig.DisabledComponents, true},
+	} {
+		for _, name := range selection.names {
+			comp := c.getComponent(name)
+			if comp == nil {
+				return fmt.Errorf("unknown component %s in the selection of the components of the stack", name)
+			}
+			comp.Disabled = selection.disabled
+		}
+	}
+	return nil
+}
+
+// resolveVersions replaces the versions of the components that are constraints, e.g., "latest", with the
+// actual versions they resolve to, using the versions recorded in the state of the stack when available
+func (c *Config) resolveVersions() error {
+	resolved := false
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if comp.Disabled || !buildenv.IsVersionConstraint(comp.Version) {
+			continue
+		}
+
+		err := c.loadStackState()
+		if err != nil {
+			return fmt.Errorf("unable to load the state of the stack: %w", err)
+		}
+		version, tag := c.state.resolvedVersion(comp.Name, comp.Version)
+		if version == "" {
+			env := c.newComponentEnv()
+			version, tag, err = env.ResolveVersion(context.Background(), comp.URL, comp.Version)
+			if err != nil {
+				return fmt.Errorf("unable to resolve version %s of %s: %w", comp.Version, comp.Name, err)
+			}
+			c.state.recordVersion(comp.Name, comp.Version, version, tag)
+			resolved = true
+		}
+
+		comp.Version = version
+		isGit := comp.SourceType == app.SourceTypeGit || util.DetectURLType(comp.URL) == util.GitURL
+		if comp.Branch == "" && isGit {
+			comp.Branch = tag
+		}
+	}
+
+	stackBasedir := c.getStackBasedir()
+	if resolved && util.PathExists(stackBasedir) {
+		err := c.state.save(stackBasedir)
+		if err != nil {
+			return fmt.Errorf("unable to save the state of the stack: %w", err)
+		}
+	}
+	return nil
+}
+
+// getStackBasedir returns the directory where all the data of the stack is stored, the directory of its
+// toolchain in the base directory of the compiler matrix when it is built with one of its toolchains
+func (c *Config) getStackBasedir() string {
+	if c.toolchain != nil {
+		return filepath.Join(c.getMatrixBasedir(), c.toolchain.Name)
+	}
+	return c.getMatrixBasedir()
+}
+
+// getComponent returns the definition of a component based on its name
+func (c *Config) getComponent(name string) *Component {
+	if c.Data.StackDefinition == nil {
+		return nil
+	}
+	for idx := range c.Data.StackDefinition.Components {
+		if c.Data.StackDefinition.Components[idx].Name == name {
+			return &c.Data.StackDefinition.Components[idx]
+		}
+	}
+	return nil
+}
+
+// getSanitizers returns the sanitizers a component is instrumented with (see StackCfg.Sanitizers)
+func (c *Config) getSanitizers(comp *Component) []string {
+	stackCfg := c.Data.StackConfig
+	if stackCfg == nil || len(stackCfg.Sanitizers) == 0 {
+		return nil
+	}
+	if len(stackCfg.SanitizedComponents) == 0 {
+		return stackCfg.Sanitizers
+	}
+	for _, name := range stackCfg.SanitizedComponents {
+		if name == comp.Name {
+			return stackCfg.Sanitizers
+		}
+	}
+	return nil
+}
+
+// resolveRef returns the value of a reference, i.e., the string between the reference delimiters
+// such as foo_install_dir.
+func (c *Config) resolveRef(ref string) (string, error) {
+	if ref == "stack_dir" {
+		if c.Data.StackConfig == nil || c.Data.StackDefinition == nil {
+			return "", fmt.Errorf("invalid reference %s: stack is not configured", ref)
+		}
+		return c.getStackBasedir(), nil
+	}
+
+	if strings.HasSuffix(ref, "_version") {
+		compName := c.GetProvider(strings.TrimSuffix(ref, "_version"))
+		comp := c.getComponent(compName)
+		if comp == nil {
+			return "", fmt.Errorf("invalid reference %s: component %s is not defined", ref, compName)
+		}
+		if comp.Version == "" {
+			return "", fmt.Errorf("invalid reference %s: version of %s is undefined", ref, compName)
+		}
+		return comp.Version, nil
+	}
+
+	// References to directories of the installed components; the value is the subdirectory
+	// in the installation directory
+	installRefKinds := map[string]string{
+		"install_dir": "",
+		"bin_dir":     "bin",
+		"lib_dir":     "lib",
+		"include_dir": "include",
+	}
+	c.mutex.RLock()
+	refKinds := map[string]map[string]string{
+		"build_dir": c.BuiltComponents,
+		"src_dir":   c.SrcComponents,
+	}
+	for kind := range installRefKinds {
+		refKinds[kind] = c.InstalledComponents
+	}
+	defer c.mutex.RUnlock()
+	for kind, values := range refKinds {
+		if !strings.HasSuffix(ref, "_"+kind) {
+			continue
+		}
+		// Component names may include underscores so the name is everything before the kind of reference
+		compName := c.GetProvider(strings.TrimSuffix(ref, "_"+kind))
+		if compName == "" {
+			return "", fmt.Errorf("invalid reference %s: undefined component", ref)
+		}
+		if _, ok := c.InstalledComponents[compName]; !ok {
+			return "", fmt.Errorf("invalid reference %s: component %s is unknown or not installed yet", ref, compName)
+		}
+		if subdir, ok := installRefKinds[kind]; ok && subdir != "" {
+			return filepath.Join(values[compName], subdir), nil
+		}
+		return values[compName], nil
+	}
+
+	return "", fmt.Errorf("invalid reference %s: unsupported type of reference", ref)
 }
 
 // UpdateRefs updates all references to other components with the actual appropriate paths.
@@ -206,46 +1001,65 @@ func createNewPathForComp(compBinDir string) string {
 // For example, it is possible to express a reference to the software package foo in
 // a environment variable as follow:
 //		FOO_LIB_DIR=@ref:foo_install_dir@/lib
-// in which case @foo_install_dir@ will be replaced by the actual path where the foo
+// in which case @ref:foo_install_dir@ will be replaced by the actual path where the foo
 // package has been installed.
+// All the references in the string are expanded and component names can include underscores,
+// e.g., @ref:my_comp_install_dir@. An error is returned if a reference is malformed, refers
+// to a component that is not installed or to an unsupported type of reference.
 // The following references are supported:
 // - install_dir: installation directory,
 // - build_dir: where the build is,
-// - src_dir: where the source code is.
+// - src_dir: where the source code is,
+// - bin_dir, lib_dir and include_dir: the bin, lib and include subdirectories of the installation directory,
+// - version: the version of the component as specified in the stack definition,
+// - stack_dir: the base directory of the stack, which is not specific to a component, i.e., @ref:stack_dir@.
+// References can be used in the build environment, the configure parameters and the URL of components.
 func (c *Config) UpdateRefs(token string) (string, error) {
-	startIdx := strings.Index(token, RefStartDelimiter)
-	if startIdx == -1 {
-		return "", fmt.Errorf("unable to find start delimiter '%s' in %s", RefStartDelimiter, token)
-	}
-	endIdx := strings.Index(token[startIdx+len(RefStartDelimiter):], RefEndDelimiter)
-	if endIdx == -1 {
-		return "", fmt.Errorf("unable to find end delimiter '%s' in %s", RefEndDelimiter, token)
-	}
-	endIdx += startIdx + len(RefStartDelimiter)
-	strToUpdate := token[startIdx+len(RefStartDelimiter) : endIdx]
-	nameDelimiter := strings.Index(strToUpdate, "_")
-	softwareComponentName := strToUpdate[:nameDelimiter]
-	ref := strToUpdate[nameDelimiter+1:]
-	for compName, _ := range c.InstalledComponents {
-		if compName == softwareComponentName {
-			if ref == "install_dir" {
-				ref = c.InstalledComponents[compName]
-			}
-			if ref == "build_dir" {
-				ref = c.BuiltComponents[compName]
-			}
-			if ref == "src_dir" {
-				ref = c.SrcComponents[compName]
-			}
-			token = token[:startIdx] + ref + token[endIdx+len(RefEndDelimiter):]
+	result := ""
+	remaining := token
+	for {
+		startIdx := strings.Index(remaining, RefStartDelimiter)
+		if startIdx == -1 {
+			break
+		}
+		refStart := startIdx + len(RefStartDelimiter)
+		endIdx := strings.Index(remaining[refStart:], RefEndDelimiter)
+		if endIdx == -1 {
+			return "", fmt.Errorf("unable to find end delimiter '%s' in %s", RefEndDelimiter, token)
+		}
+		endIdx += refStart
+		value, err := c.resolveRef(remaining[refStart:endIdx])
+		if err != nil {
+			return "", fmt.Errorf("unable to update references in %s: %w", token, err)
 		}
+		result += remaining[:startIdx] + value
+		remaining = remaining[endIdx+len(RefEndDelimiter):]
 	}
 
-	return token, nil
+	return result + remaining, nil
 }
 
 // InstallStack installs an entire stack based on its configuration.
 func (c *Config) InstallStack() error {
+	start := time.Now()
+	ctx, span := metrics.StartSpan(context.Background(), "install stack")
+	c.mutex.Lock()
+	c.traceContext = ctx
+	c.mutex.Unlock()
+
+	err := c.installStack()
+
+	c.mutex.Lock()
+	c.traceContext = nil
+	c.mutex.Unlock()
+	c.recordStackMetrics(span, start, err)
+	c.notifyStackCompleted(err)
+	c.emitStackCompleted(err)
+	return err
+}
+
+// installStack installs all the components of the stack
+func (c *Config) installStack() error {
 	// A map of all the installed components where the key of the component's name and the value the directory where it is installed
 	installedComponents := make(map[string]string)
 
@@ -270,201 +1084,968 @@ func (c *Config) InstallStack() error {
 		}
 	}
 
-	for _, softwareComponent := range c.Data.StackDefinition.Components {
-		// Set a builder
-		b := new(builder.Builder)
+	stackBasedir := c.getStackBasedir()
+	if !util.PathExists(stackBasedir) {
+		err := os.MkdirAll(stackBasedir, defaultPermission)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", stackBasedir, err)
+		}
+	}
 
-		stackBasedir := filepath.Join(c.Data.StackConfig.InstallDir, c.Data.StackDefinition.Name)
-		if !util.PathExists(stackBasedir) {
-			err := os.MkdirAll(stackBasedir, defaultPermission)
-			if err != nil {
-				return fmt.Errorf("unable to create %s: %w", stackBasedir, err)
-			}
+	unlock, err := c.lock("install")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	err = c.loadStackState()
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+
+	// Components, versions, providers and presets of stacks configured in code are resolved here
+	err = c.selectComponents()
+	if err != nil {
+		return err
+	}
+	err = c.resolveVersions()
+	if err != nil {
+		return err
+	}
+	err = c.resolveProviders()
+	if err != nil {
+		return err
+	}
+	err = c.Data.StackDefinition.ApplyPresets()
+	if err != nil {
+		return err
+	}
+
+	c.notify(&Event{Event: EventStackStarted})
+	err = c.runHook("pre_stack", &c.PreStack, nil, nil)
+	if err != nil {
+		return err
+	}
+
+	c.Report = new(Report)
+	// Components that failed or were skipped, used to skip components depending on them
+	notInstalled := make(map[string]bool)
+	installOrder := c.getInstallOrder()
+	progress := c.newProgressTracker(installOrder)
+	c.emitEvent(&ProgressEvent{Type: ProgressStackStarted, Total: progress.total})
+	for _, idx := range installOrder {
+		softwareComponent := &c.Data.StackDefinition.Components[idx]
+		if softwareComponent.Disabled {
+			log.Printf("-> %s is disabled, skipping", softwareComponent.Name)
+			c.Report.add(softwareComponent, StatusDisabled, nil)
+			c.emitComponentEvent(ProgressComponentCompleted, softwareComponent, nil, StatusDisabled, nil)
+			continue
 		}
-		b.Env.ScratchDir = filepath.Join(stackBasedir, "scratch")
-		b.Env.InstallDir = filepath.Join(stackBasedir, "install")
-		b.Env.BuildDir = filepath.Join(stackBasedir, "build")
-		b.Env.SrcDir = filepath.Join(stackBasedir, "src")
-		if softwareComponent.BuildEnv != "" {
-			customEnv := strings.Split(softwareComponent.BuildEnv, " ")
-
-			// Elements of the environment may refer to directories specific
-			// to other software components being installed. In such a case,
-			// we need to update the reference with the actual path
-			for idx, e := range customEnv {
-				if strings.Contains(e, "@") {
-					var err error
-					customEnv[idx], err = c.UpdateRefs(e)
-					if err != nil {
-						return fmt.Errorf("updateTestRefs() failed: %w", err)
-					}
-				}
+
+		failedDep := ""
+		for _, dep := range getDependencies(softwareComponent) {
+			if notInstalled[dep] {
+				failedDep = dep
+				break
 			}
-			b.Env.Env = customEnv
 		}
-		if len(c.Data.BuildEnv) > 0 {
-			b.Env.Env = append(b.Env.Env, c.Data.BuildEnv...)
+		if failedDep != "" {
+			log.Printf("-> %s depends on %s, which was not installed, skipping", softwareComponent.Name, failedDep)
+			skipErr := fmt.Errorf("dependency %s was not installed", failedDep)
+			c.Report.add(softwareComponent, StatusSkipped, skipErr)
+			notInstalled[softwareComponent.Name] = true
+			p := progress.report(softwareComponent, true)
+			c.emitComponentEvent(ProgressComponentCompleted, softwareComponent, p, StatusSkipped, skipErr)
+			continue
 		}
 
-		if !util.PathExists(b.Env.ScratchDir) {
-			err := os.MkdirAll(b.Env.ScratchDir, defaultPermission)
-			if err != nil {
-				return fmt.Errorf("unable to create %s: %w", b.Env.ScratchDir, err)
+		var p *Progress
+		var err error
+		action := FailureAbort
+		for {
+			p = progress.report(softwareComponent, false)
+			c.emitComponentEvent(ProgressComponentStarted, softwareComponent, p, "", nil)
+			err = c.installComponent(softwareComponent, installedComponents, configIds)
+			p = progress.report(softwareComponent, true)
+			if err == nil {
+				break
 			}
-		}
-
-		if !util.PathExists(b.Env.InstallDir) {
-			err := os.MkdirAll(b.Env.InstallDir, defaultPermission)
-			if err != nil {
-				return fmt.Errorf("unable to create %s: %w", b.Env.InstallDir, err)
+			c.emitComponentEvent(ProgressComponentCompleted, softwareComponent, p, StatusFailed, err)
+			action = c.getFailureAction(softwareComponent, err)
+			if action != FailureRetry {
+				break
 			}
+			log.Printf("-> Installing %s again after its failure: %s", softwareComponent.Name, err)
+			c.mutex.Lock()
+			if c.retried == nil {
+				c.retried = make(map[string]bool)
+			}
+			c.retried[softwareComponent.Name] = true
+			c.mutex.Unlock()
 		}
-
-		if !util.PathExists(b.Env.BuildDir) {
-			err := os.MkdirAll(b.Env.BuildDir, defaultPermission)
-			if err != nil {
-				return fmt.Errorf("unable to create %s: %w", b.Env.BuildDir, err)
+		if err != nil {
+			c.Report.add(softwareComponent, StatusFailed, err)
+			notInstalled[softwareComponent.Name] = true
+			c.notifyComponentFailed(softwareComponent, err)
+			hookErr := c.runHook("on_component_failure", &c.OnComponentFailure, softwareComponent, err)
+			if hookErr != nil {
+				log.Printf("[WARN] %s", hookErr)
 			}
+			if action == FailureAbort {
+				return err
+			}
+			log.Printf("[ERROR] %s; continuing with the other components", err)
+			continue
+		}
+		status := StatusInstalled
+		if softwareComponent.External != "" {
+			status = StatusExternal
 		}
+		c.Report.add(softwareComponent, status, nil)
+		c.emitComponentEvent(ProgressComponentCompleted, softwareComponent, p, status, nil)
+		c.Report.setSanityCheckErr(softwareComponent.Name, c.runSanityCheck(softwareComponent))
+		licenses, err := c.GetLicenses(softwareComponent.Name)
+		if err != nil {
+			log.Printf("[WARN] unable to get the licenses of %s: %s", softwareComponent.Name, err)
+		}
+		c.Report.setLicenses(softwareComponent.Name, licenses)
+	}
 
-		if !util.PathExists(b.Env.SrcDir) {
-			err := os.MkdirAll(b.Env.SrcDir, defaultPermission)
+	if len(notInstalled) > 0 {
+		installErr := &InstallError{Report: c.Report}
+		// The components that were installed can be used anyway
+		for _, compReport := range c.Report.Components {
+			comp := c.getComponent(compReport.Name)
+			if compReport.Status != StatusInstalled || comp == nil {
+				continue
+			}
+			err = c.applyOwnership(getCompInstallDir(stackBasedir, comp))
 			if err != nil {
-				return fmt.Errorf("unable to create %s: %w", b.Env.SrcDir, err)
+				log.Printf("[WARN] unable to set the ownership of %s: %s", comp.Name, err)
 			}
 		}
-
-		log.Printf("-> Installing %s", softwareComponent.Name)
-		b.App.Name = softwareComponent.Name
-		b.App.Source.URL = softwareComponent.URL
-		b.App.Source.Branch = softwareComponent.Branch
-
-		if softwareComponent.ConfigureDependency != "" {
-			deps := strings.Split(softwareComponent.ConfigureDependency, ",")
-			for _, dep := range deps {
-				ref := dep
-				_, ok := configIds[dep]
-				if ok {
-					ref = configIds[dep]
-				}
-				configureOption := fmt.Sprintf("--with-%s=%s", ref, installedComponents[dep])
-				b.App.AutotoolsCfg.ExtraConfigureArgs = append(b.App.AutotoolsCfg.ExtraConfigureArgs, configureOption)
-			}
+		err = c.runHook("post_stack", &c.PostStack, nil, installErr)
+		if err != nil {
+			log.Printf("[WARN] %s", err)
 		}
+		return installErr
+	}
 
-		if softwareComponent.ConfigureParams != "" {
-			args := strings.Split(softwareComponent.ConfigureParams, " ")
-			b.App.AutotoolsCfg.ExtraConfigureArgs = append(b.App.AutotoolsCfg.ExtraConfigureArgs, args...)
-		}
+	err = c.applyOwnership(filepath.Join(stackBasedir, "install"))
+	if err != nil {
+		return fmt.Errorf("unable to set the ownership of the installed software: %w", err)
+	}
 
-		if softwareComponent.ConfigurePrelude != "" {
-			b.App.AutotoolsCfg.ConfigurePreludeCmd = softwareComponent.ConfigurePrelude
+	if c.Data.StackConfig.Dedup {
+		_, err = c.Dedup()
+		if err != nil {
+			return err
 		}
+	}
 
-		if softwareComponent.BranchCheckoutPrelude != "" {
-			b.App.Source.BranchCheckoutPrelude = softwareComponent.BranchCheckoutPrelude
-		}
+	return c.runHook("post_stack", &c.PostStack, nil, nil)
+}
 
-		err := b.Load(true)
+// InstallComponent installs a single component of the stack, assuming the components it depends on are
+// already installed. It is safe to call InstallComponent() concurrently for independent components.
+// The error of the sanity check of the component, if any, is returned, the component remaining installed
+// (see Component.SanityCheck).
+func (c *Config) InstallComponent(name string) error {
+	c.loadMutex.Lock()
+	if !c.Loaded {
+		err := c.Load()
 		if err != nil {
-			return fmt.Errorf("unable to load the builder for %s: %w", b.App.Name, err)
-		}
-
-		res := b.Install()
-		if res.Err != nil {
-			return fmt.Errorf("unable to install %s: %w", softwareComponent.Name, res.Err)
+			c.loadMutex.Unlock()
+			return fmt.Errorf("unable to load configuration: %w", err)
 		}
+	}
+	c.loadMutex.Unlock()
 
-		if softwareComponent.ConfigId != "" {
-			configIds[softwareComponent.Name] = softwareComponent.ConfigId
-		}
+	comp := c.getComponent(name)
+	if comp == nil {
+		return fmt.Errorf("component %s is not defined", name)
+	}
+	if comp.Disabled {
+		return fmt.Errorf("component %s is disabled", name)
+	}
 
-		// Track what was installed, both locally and globally
-		compInstallDir := filepath.Join(b.Env.InstallDir, softwareComponent.Name)
-		installedComponents[softwareComponent.Name] = compInstallDir
-		if c.InstalledComponents == nil {
-			c.InstalledComponents = make(map[string]string)
-		}
-		c.InstalledComponents[softwareComponent.Name] = compInstallDir
+	err := c.loadStackState()
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
 
-		// Note: it is not required for components to have a build directory. For instance
-		// the code is compiled directly from the source directory when the component is
-		// packaged in the form of a tarball
-		compBuildDir, _ := GetCompBuildDir(stackBasedir, softwareComponent.Name)
-		if c.BuiltComponents == nil {
-			c.BuiltComponents = make(map[string]string)
+	// Components installed previously, for instance by another call to InstallComponent(),
+	// are the dependencies the component can rely on
+	c.mutex.Lock()
+	if c.installedComponents == nil {
+		c.installedComponents = make(map[string]string)
+		c.configIds = make(map[string]string)
+	}
+	for compName, installDir := range c.InstalledComponents {
+		c.installedComponents[compName] = installDir
+		if depComp := c.getComponent(compName); depComp != nil && depComp.ConfigId != "" {
+			c.configIds[compName] = depComp.ConfigId
 		}
-		c.BuiltComponents[softwareComponent.Name] = compBuildDir
+	}
+	c.mutex.Unlock()
 
-		compSrcDir, err := GetCompSrcDir(stackBasedir, softwareComponent.Name)
-		if err != nil {
-			return fmt.Errorf("unable to get source dir from component %s: %w", softwareComponent.Name, err)
+	c.emitComponentEvent(ProgressComponentStarted, comp, nil, "", nil)
+	err = c.installComponent(comp, c.installedComponents, c.configIds)
+	if err != nil {
+		c.emitComponentEvent(ProgressComponentCompleted, comp, nil, StatusFailed, err)
+		return err
+	}
+	status := StatusInstalled
+	if comp.External != "" {
+		status = StatusExternal
+	}
+	c.emitComponentEvent(ProgressComponentCompleted, comp, nil, status, nil)
+	return c.runSanityCheck(comp)
+}
+
+// GetInstalledComponents returns a copy of the map of the components installed for the stack (see InstalledComponents),
+// which is safe to use while components are being installed
+func (c *Config) GetInstalledComponents() map[string]string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	installed := make(map[string]string)
+	for name, dir := range c.InstalledComponents {
+		installed[name] = dir
+	}
+	return installed
+}
+
+// newComponentEnv returns the build environment to use for a component of the stack
+func (c *Config) newComponentEnv() buildenv.Info {
+	stackBasedir := c.getStackBasedir()
+	// The directories of a build environment must be absolute
+	if absBasedir, err := filepath.Abs(stackBasedir); err == nil {
+		stackBasedir = absBasedir
+	}
+	var env buildenv.Info
+	env.ScratchDir = filepath.Join(stackBasedir, "scratch")
+	env.InstallDir = filepath.Join(stackBasedir, "install")
+	env.BuildDir = filepath.Join(stackBasedir, "build")
+	env.SrcDir = filepath.Join(stackBasedir, "src")
+	if c.toolchain != nil {
+		env.SrcDir = filepath.Join(filepath.Dir(stackBasedir), "src")
+	}
+	env.MirrorRewrites = c.Data.StackConfig.MirrorRewrites
+	if c.bundle != nil {
+		// The copies of the source code in the bundle are used first
+		rewrites := c.bundle.getRewrites()
+		for prefix, newPrefix := range c.Data.StackConfig.MirrorRewrites {
+			if _, ok := rewrites[prefix]; !ok {
+				rewrites[prefix] = newPrefix
+			}
+		}
+		env.MirrorRewrites = rewrites
+	}
+	env.GitCacheDir = c.Data.StackConfig.GitCacheDir
+	env.Credentials = c.Data.StackConfig.Credentials
+	env.NetrcFile = c.Data.StackConfig.NetrcFile
+	env.CABundle = c.Data.StackConfig.CABundle
+	env.InsecureTLS = c.Data.StackConfig.InsecureTLS
+	env.GitLabHosts = c.Data.StackConfig.GitLabHosts
+	env.ArtifactServers = c.Data.StackConfig.ArtifactServers
+	env.Limits = c.Data.StackConfig.ResourceLimits
+	return env
+}
+
+// setComponentDirs sets the source directory of a component in its build environment and updates the environment
+// when its temporary data are stored or it is built outside of the stack (see Component.ScratchBasedir and
+// Component.BuildBasedir), the build directory of the component in the stack being replaced with a link to the one
+// where it is built
+func (c *Config) setComponentDirs(env *buildenv.Info, comp *Component) error {
+	// The source code of the components would otherwise be mixed up when they are fetched concurrently and their
+	// tarballs have the same name, e.g., v1.0.tar.gz
+	env.SrcDir = filepath.Join(env.SrcDir, comp.Name)
+	if comp.ScratchBasedir == "" && comp.BuildBasedir == "" {
+		return nil
+	}
+	stackBasedir, err := filepath.Abs(c.getStackBasedir())
+	if err != nil {
+		return fmt.Errorf("unable to get the absolute path of %s: %w", c.getStackBasedir(), err)
+	}
+	installDir, err := filepath.Abs(c.Data.StackConfig.InstallDir)
+	if err != nil {
+		return fmt.Errorf("unable to get the absolute path of %s: %w", c.Data.StackConfig.InstallDir, err)
+	}
+	// The directories are specific to the stack, including its toolchain, so they can be shared between stacks
+	relStackBasedir, err := filepath.Rel(installDir, stackBasedir)
+	if err != nil {
+		return fmt.Errorf("unable to get the path of %s in %s: %w", stackBasedir, installDir, err)
+	}
+
+	if comp.ScratchBasedir != "" {
+		env.ScratchDir = filepath.Join(comp.ScratchBasedir, relStackBasedir, "scratch")
+	}
+	if comp.BuildBasedir == "" {
+		return nil
+	}
+	env.BuildDir = filepath.Join(comp.BuildBasedir, relStackBasedir, "build")
+	compBuildDir := filepath.Join(env.BuildDir, comp.Name)
+	err = os.MkdirAll(compBuildDir, defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", compBuildDir, err)
+	}
+	link := filepath.Join(stackBasedir, "build", comp.Name)
+	if target, err := os.Readlink(link); err == nil && target == compBuildDir {
+		return nil
+	}
+	// The component was previously built in another directory
+	err = os.RemoveAll(link)
+	if err != nil {
+		return fmt.Errorf("unable to remove %s: %w", link, err)
+	}
+	err = os.MkdirAll(filepath.Dir(link), defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", filepath.Dir(link), err)
+	}
+	err = os.Symlink(compBuildDir, link)
+	if err != nil {
+		return fmt.Errorf("unable to create link to %s: %w", compBuildDir, err)
+	}
+	return nil
+}
+
+// newComponentApp returns the description of a component that is suitable to get its source code
+func (c *Config) newComponentApp(comp *Component) (app.Info, error) {
+	var a app.Info
+	a.Name = comp.Name
+	a.Version = comp.Version
+
+	overridePath, overridden, err := c.getSourceOverride(comp.Name)
+	if err != nil {
+		return a, err
+	}
+	if overridden {
+		log.Printf("-> Using local source code from %s for %s", overridePath, comp.Name)
+		a.Source.URL = "file://" + overridePath
+		return a, nil
+	}
+
+	url, err := c.UpdateRefs(comp.URL)
+	if err != nil {
+		return a, fmt.Errorf("invalid URL for %s: %w", comp.Name, err)
+	}
+	if entry, ok := c.getBundleSource(comp.Name); ok && comp.ReleaseAsset != "" {
+		// The release asset was resolved when the bundle was created
+		url = entry.URL
+		a.Tarball = filepath.Base(entry.Path)
+	} else if comp.ReleaseAsset != "" {
+		url, a.Tarball, err = c.getReleaseAssetURL(comp, url)
+		if err != nil {
+			return a, err
 		}
-		if c.SrcComponents == nil {
-			c.SrcComponents = make(map[string]string)
+	}
+	a.Source.URL = url
+	a.Source.Branch = comp.Branch
+	a.Source.BranchCheckoutPrelude = comp.BranchCheckoutPrelude
+	switch comp.SourceType {
+	case "", app.SourceTypeTarball, app.SourceTypeGit, app.SourceTypeDir, app.SourceTypeFile:
+		a.Source.Type = comp.SourceType
+	default:
+		return a, fmt.Errorf("invalid source type for %s: %s", comp.Name, comp.SourceType)
+	}
+	if a.Source.Type == "" && comp.ReleaseAsset != "" {
+		// The URL of the asset does not always allow to detect the type of the source code
+		a.Source.Type = app.SourceTypeTarball
+	}
+	for _, mirror := range comp.Mirrors {
+		mirrorURL, err := c.UpdateRefs(mirror)
+		if err != nil {
+			return a, fmt.Errorf("invalid mirror for %s: %w", comp.Name, err)
 		}
-		c.SrcComponents[softwareComponent.Name] = compSrcDir
+		a.Source.Mirrors = append(a.Source.Mirrors, mirrorURL)
+	}
+	a.Source.Checksum = comp.Checksum
+	// The checksum of the latest version of an artifact changes with every new release
+	if c.state != nil && !strings.Contains(url, buildenv.LatestVersion) {
+		checksum, revision := c.state.expectedSource(comp.Name, url, comp.Branch, comp.Version)
+		if a.Source.Checksum == "" {
+			a.Source.Checksum = checksum
+		}
+		a.Source.Revision = revision
+	}
+	return a, nil
+}
 
-		// If the component has binaries, we update PATH accordingly so we can
-		// benefit from them as we progress installing the stack, i.e., handle
-		// dependencies between components of the stack
-		compBinDir := filepath.Join(compInstallDir, "bin")
-		if util.PathExists(compBinDir) {
-			if len(c.Data.BuildEnv) == 0 {
-				// No build environment; create a new environment
-				pathEnvStr := createNewPathForComp(compBinDir)
-				c.Data.BuildEnv = append(c.Data.BuildEnv, pathEnvStr)
-			} else {
-				// A build environment already exists and may include a specific PATH
-
-				// Do we have a PATH env already?
-				pathFound := false
-				for idx, envvar := range c.Data.BuildEnv {
-					tokens := strings.Split(envvar, "=")
-					if tokens[0] == "PATH" {
-						newPath := "PATH=" + compBinDir + ":" + tokens[1]
-						c.Data.BuildEnv[idx] = newPath
-						pathFound = true
-						break
-					}
-				}
-				if pathFound == false {
-					// We can set a new one
-					pathEnvStr := createNewPathForComp(compBinDir)
-					c.Data.BuildEnv = append(c.Data.BuildEnv, pathEnvStr)
-				}
+// getReleaseAssetURL returns the URL and name of the release asset of a component, projectURL being the URL
+// of the GitHub or GitLab project (see Component.ReleaseAsset)
+func (c *Config) getReleaseAssetURL(comp *Component, projectURL string) (string, string, error) {
+	tag, err := c.UpdateRefs(comp.ReleaseTag)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid release tag for %s: %w", comp.Name, err)
+	}
+	var tags []string
+	switch {
+	case tag != "":
+		tags = []string{tag}
+	case comp.Version == "":
+		tags = []string{buildenv.LatestRelease}
+	default:
+		if c.state != nil {
+			c.state.mutex.Lock()
+			compState, ok := c.state.Components[comp.Name]
+			if ok && compState.Version == comp.Version && compState.Tag != "" {
+				tags = append(tags, compState.Tag)
 			}
+			c.state.mutex.Unlock()
 		}
+		tags = append(tags, "v"+comp.Version, comp.Version)
+	}
+
+	env := c.newComponentEnv()
+	var errs []string
+	for _, t := range tags {
+		assetURL, assetName, err := env.GetReleaseAssetURL(context.Background(), projectURL, t, comp.ReleaseAsset)
+		if err == nil {
+			return assetURL, assetName, nil
+		}
+		errs = append(errs, err.Error())
+	}
+	return "", "", fmt.Errorf("unable to get release asset %s of %s: %s", comp.ReleaseAsset, comp.Name, strings.Join(errs, "; "))
+}
+
+// getSourceOverride returns the absolute path to the local source code to use for a component, if any
+func (c *Config) getSourceOverride(name string) (string, bool, error) {
+	overridePath, ok := c.SourceOverrides[name]
+	if !ok || overridePath == "" {
+		return "", false, nil
+	}
+	absPath, err := filepath.Abs(overridePath)
+	if err != nil {
+		return "", false, fmt.Errorf("unable to get absolute path of %s: %w", overridePath, err)
+	}
+	if !util.PathExists(absPath) {
+		return "", false, fmt.Errorf("local source code of %s does not exist: %s", name, absPath)
+	}
+	return absPath, true, nil
+}
 
-		log.Printf("-> %s was successfully installed in %s", softwareComponent.Name, compInstallDir)
+// recordComponentSource saves in the state of the stack the details of the source code used for a component
+func (c *Config) recordComponentSource(comp *Component, url string, env *buildenv.Info) {
+	if c.state == nil {
+		return
 	}
+	if _, overridden := c.SourceOverrides[comp.Name]; overridden {
+		// Local source code changes all the time and must not pollute the state of the stack
+		return
+	}
+	c.state.recordSource(comp.Name, url, comp.Branch, comp.Version, env.SrcChecksum, env.SrcRevision)
+}
+
+// getDependencies returns the name of all the components a component depends on
+func getDependencies(comp *Component) []string {
+	if comp.ConfigureDependency == "" {
+		return nil
+	}
+	return strings.Split(comp.ConfigureDependency, ",")
+}
+
+// installComponent installs a single software component of the stack.
+// installedComponents and configIds are respectively the components installed so far and the identifiers
+// to use to configure components that depend on them; both are updated once the component is installed
+// and, like the other maps of the stack, protected by c.mutex.
+func (c *Config) installComponent(softwareComponent *Component, installedComponents map[string]string, configIds map[string]string) error {
+	start := time.Now()
+	ctx, span := metrics.StartSpan(c.getTraceContext(), "install "+softwareComponent.Name)
+	err := c.doInstallComponent(ctx, softwareComponent, installedComponents, configIds)
+	if err != nil {
+		// Errors that are not the failure of a stage of the build happen while preparing it
+		err = c.newComponentError(softwareComponent, builder.StagePrepare, err)
+	}
+	c.recordComponentMetrics(span, softwareComponent, start, err)
+	return err
+}
 
+// newComponentError returns the error of a component failing at a given stage of its installation, e.g.,
+// builder.StagePrepare, so that callers get the component, the stage and the log of the failure with errors.As()
+// (see builder.BuildError). Errors that are already the failure of a stage of the component are returned as is.
+func (c *Config) newComponentError(comp *Component, stage string, err error) error {
+	var buildErr *builder.BuildError
+	if errors.As(err, &buildErr) && buildErr.Component == comp.Name {
+		return err
+	}
+	compErr := &builder.BuildError{Component: comp.Name, Stage: stage, Err: err}
+	// Commands are recorded from the configure stage on, the file being left over by a previous build otherwise
+	commandsPath := c.getCommandsPath(comp)
+	if stage != builder.StagePrepare && stage != builder.StageDownload && util.FileExists(commandsPath) {
+		compErr.LogPath = commandsPath
+	}
+	return compErr
+}
+
+// doInstallComponent installs a single software component of the stack, the spans of its build being children of
+// the span of ctx (see installComponent)
+func (c *Config) doInstallComponent(ctx context.Context, softwareComponent *Component, installedComponents map[string]string, configIds map[string]string) error {
+	if softwareComponent.External != "" {
+		return c.installExternalComponent(softwareComponent, installedComponents, configIds)
+	}
+
+	// Set a builder
+	b := new(builder.Builder)
+	b.TraceContext = ctx
+	b.OnStage = c.getStageFn(softwareComponent)
+
+	stackBasedir := c.getStackBasedir()
+	if !util.PathExists(stackBasedir) {
+		err := os.MkdirAll(stackBasedir, defaultPermission)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", stackBasedir, err)
+		}
+	}
+	b.Env = c.newComponentEnv()
+	if err := c.setComponentDirs(&b.Env, softwareComponent); err != nil {
+		return err
+	}
+	// Components are installed in version-qualified directories, e.g., install/ucx/1.15, when their version is known
+	b.Env.InstallVersion = softwareComponent.Version
+	b.Env.InstallHash = softwareComponent.installHash
+	c.mutex.RLock()
+	b.Env.SkipUpdate = c.fetched[softwareComponent.Name]
+	stackBuildEnv := append(buildenv.Env{}, c.Data.BuildEnv...)
+	// The partial installation left by a failure must not be mistaken for a complete one
+	retried := c.retried[softwareComponent.Name]
+	c.mutex.RUnlock()
+	// Local source code may have changed since the last installation
+	_, overridden := c.SourceOverrides[softwareComponent.Name]
+	b.Force = overridden || retried
+	if c.Data.StackConfig.SharedConfigureCache && !softwareComponent.NoConfigureCache {
+		b.Env.ConfigureCacheDir = filepath.Join(stackBasedir, "configure_cache")
+	}
+	b.StagedInstall = c.Data.StackConfig.StagedInstall && !softwareComponent.NoStagedInstall
+	b.CommandsFile = c.getCommandsPath(softwareComponent)
+	if c.toolchain != nil {
+		toolchainEnv, err := c.toolchain.getEnv()
+		if err != nil {
+			return err
+		}
+		if !c.Data.StackConfig.Hermetic {
+			// Env is the entire environment when set
+			toolchainEnv = buildenv.Env(os.Environ()).Merge(toolchainEnv)
+		}
+		b.Env.Env = toolchainEnv
+	}
+	if softwareComponent.BuildEnv != "" {
+		// Elements of the environment may refer to directories specific
+		// to other software components being installed. In such a case,
+		// we need to update the reference with the actual path
+		buildEnv, err := c.UpdateRefs(softwareComponent.BuildEnv)
+		if err != nil {
+			return fmt.Errorf("UpdateRefs() failed: %w", err)
+		}
+		compEnv, err := buildenv.ParseEnv(buildEnv)
+		if err != nil {
+			return fmt.Errorf("invalid build environment for %s: %w", softwareComponent.Name, err)
+		}
+		// The environment of the component takes precedence over the one of the toolchain
+		b.Env.Env = b.Env.Env.Merge(compEnv)
+	}
+	if c.Data.StackConfig.Hermetic {
+		// The PATH of the stack build environment is replaced with the one of the hermetic environment
+		b.Env.Env = c.getHermeticEnv(b.Env.Env)
+	} else if len(stackBuildEnv) > 0 {
+		b.Env.Env = b.Env.Env.Merge(stackBuildEnv)
+	}
+	c.setBootstrapToolchainEnv(&b.Env, softwareComponent)
+	b.Env.Isolation = c.getIsolation(&b.Env, softwareComponent)
+	// The build type comes first so that its optimization level takes precedence over the one of the profile
+	buildType := c.Data.StackConfig.BuildType
+	if softwareComponent.BuildType != "" {
+		buildType = softwareComponent.BuildType
+	}
+	if buildType != "" {
+		err := b.Env.SetBuildType(buildType)
+		if err != nil {
+			return fmt.Errorf("invalid build type for %s: %w", softwareComponent.Name, err)
+		}
+	}
+	if sanitizers := c.getSanitizers(softwareComponent); len(sanitizers) > 0 {
+		err := b.Env.SetSanitizers(sanitizers)
+		if err != nil {
+			return fmt.Errorf("invalid sanitizers for %s: %w", softwareComponent.Name, err)
+		}
+	}
+	optimizationProfile := c.Data.StackConfig.OptimizationProfile
+	if softwareComponent.OptimizationProfile != "" {
+		optimizationProfile = softwareComponent.OptimizationProfile
+	}
+	if optimizationProfile != "" {
+		err := b.Env.SetOptimizationProfile(optimizationProfile)
+		if err != nil {
+			return fmt.Errorf("invalid optimization profile for %s: %w", softwareComponent.Name, err)
+		}
+	}
+
+	if !util.PathExists(b.Env.ScratchDir) {
+		err := os.MkdirAll(b.Env.ScratchDir, defaultPermission)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", b.Env.ScratchDir, err)
+		}
+	}
+
+	if !util.PathExists(b.Env.InstallDir) {
+		err := os.MkdirAll(b.Env.InstallDir, defaultPermission)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", b.Env.InstallDir, err)
+		}
+	}
+
+	if !util.PathExists(b.Env.BuildDir) {
+		err := os.MkdirAll(b.Env.BuildDir, defaultPermission)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", b.Env.BuildDir, err)
+		}
+	}
+
+	if !util.PathExists(b.Env.SrcDir) {
+		err := os.MkdirAll(b.Env.SrcDir, defaultPermission)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", b.Env.SrcDir, err)
+		}
+	}
+
+	log.Printf("-> Installing %s", softwareComponent.Name)
+	var err error
+	b.App, err = c.newComponentApp(softwareComponent)
+	if err != nil {
+		return err
+	}
+
+	if softwareComponent.ConfigureDependency != "" {
+		deps := getDependencies(softwareComponent)
+		for _, dep := range deps {
+			depComp := c.getComponent(dep)
+			if depComp != nil && depComp.Disabled {
+				return fmt.Errorf("%s depends on %s, which is disabled", softwareComponent.Name, dep)
+			}
+			ref := dep
+			c.mutex.RLock()
+			_, ok := configIds[dep]
+			if ok {
+				ref = configIds[dep]
+			}
+			depInstallDir := installedComponents[dep]
+			c.mutex.RUnlock()
+			configureOption := fmt.Sprintf("--with-%s=%s", ref, depInstallDir)
+			b.App.AutotoolsCfg.ExtraConfigureArgs = append(b.App.AutotoolsCfg.ExtraConfigureArgs, configureOption)
+			if softwareComponent.DependencyFlags && depInstallDir != "" {
+				b.Env.AddDependencyFlags(depInstallDir)
+			}
+		}
+	}
+
+	if softwareComponent.ConfigureParams != "" {
+		configureParams, err := c.UpdateRefs(softwareComponent.ConfigureParams)
+		if err != nil {
+			return fmt.Errorf("invalid configure parameters for %s: %w", softwareComponent.Name, err)
+		}
+		args := strings.Split(configureParams, " ")
+		b.App.AutotoolsCfg.ExtraConfigureArgs = append(b.App.AutotoolsCfg.ExtraConfigureArgs, args...)
+	}
+
+	variantParams, err := getVariantParams(softwareComponent)
+	if err != nil {
+		return err
+	}
+	if variantParams != "" {
+		variantParams, err = c.UpdateRefs(variantParams)
+		if err != nil {
+			return fmt.Errorf("invalid variant parameters for %s: %w", softwareComponent.Name, err)
+		}
+		b.App.AutotoolsCfg.ExtraConfigureArgs = append(b.App.AutotoolsCfg.ExtraConfigureArgs, strings.Split(variantParams, " ")...)
+	}
+	if c.state != nil && c.state.variantsChanged(softwareComponent.Name, softwareComponent.Variants) {
+		log.Printf("-> Variants of %s changed, installing it again", softwareComponent.Name)
+		b.Force = true
+	}
+
+	if softwareComponent.ConfigurePrelude != "" {
+		b.App.AutotoolsCfg.ConfigurePreludeCmd = softwareComponent.ConfigurePrelude
+	}
+
+	b.App.SubDir = softwareComponent.SubDir
+	b.Env.BuildTargets = softwareComponent.BuildTargets
+	if len(softwareComponent.MakeVars) > 0 {
+		b.Env.MakeVars = make(map[string]string)
+		for name, value := range softwareComponent.MakeVars {
+			b.Env.MakeVars[name], err = c.UpdateRefs(value)
+			if err != nil {
+				return fmt.Errorf("invalid make variable %s for %s: %w", name, softwareComponent.Name, err)
+			}
+		}
+	}
+	b.Env.InstallTargets = softwareComponent.InstallTargets
+
+	if softwareComponent.BuildSystem != "" && softwareComponent.Plugin != "" {
+		return fmt.Errorf("%s cannot have both a build system and a plugin", softwareComponent.Name)
+	}
+	if softwareComponent.BuildSystem != "" {
+		b.BuildSystem = builder.LookupBuildSystem(softwareComponent.BuildSystem)
+		if b.BuildSystem == nil {
+			return fmt.Errorf("unknown build system for %s: %s", softwareComponent.Name, softwareComponent.BuildSystem)
+		}
+	}
+	if softwareComponent.Plugin != "" {
+		pluginPath, err := c.UpdateRefs(softwareComponent.Plugin)
+		if err != nil {
+			return fmt.Errorf("invalid plugin for %s: %w", softwareComponent.Name, err)
+		}
+		b.BuildSystem, err = builder.NewPluginBuildSystem(pluginPath)
+		if err != nil {
+			return fmt.Errorf("unable to load the plugin for %s: %w", softwareComponent.Name, err)
+		}
+	}
+
+	b.App.InstallCmd, err = c.UpdateRefs(softwareComponent.InstallCmd)
+	if err != nil {
+		return fmt.Errorf("invalid install command for %s: %w", softwareComponent.Name, err)
+	}
+
+	b.PreInstallCmd, err = c.UpdateRefs(softwareComponent.PreInstallCmd)
+	if err != nil {
+		return fmt.Errorf("invalid pre-install command for %s: %w", softwareComponent.Name, err)
+	}
+	b.PostInstallCmd, err = c.UpdateRefs(softwareComponent.PostInstallCmd)
+	if err != nil {
+		return fmt.Errorf("invalid post-install command for %s: %w", softwareComponent.Name, err)
+	}
+
+	err = b.Load(true)
+	if err != nil {
+		return fmt.Errorf("unable to load the builder for %s: %w", b.App.Name, err)
+	}
+	// Misconfigured directories would otherwise only surface in the middle of the build
+	err = b.Env.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid build environment for %s: %w", b.App.Name, err)
+	}
+
+	start := time.Now()
+	res := b.Install()
+	if res.Err != nil {
+		return fmt.Errorf("unable to install %s: %w", softwareComponent.Name, res.Err)
+	}
+	if b.Built() {
+		c.recordBuildTime(softwareComponent, time.Since(start))
+	}
+	// The steps following the installation, e.g., stripping the binaries, are part of the install stage
+	installErr := func(err error) error {
+		return c.newComponentError(softwareComponent, builder.StageInstall, err)
+	}
+
+	if (softwareComponent.Test || softwareComponent.TestsMustPass) && b.Built() {
+		testRes := b.Test()
+		if !testRes.Success() {
+			if softwareComponent.TestsMustPass {
+				return fmt.Errorf("tests of %s failed: %w", softwareComponent.Name, testRes.Err)
+			}
+			log.Printf("[WARN] tests of %s failed: %s", softwareComponent.Name, testRes.Err)
+		}
+	}
+
+	err = b.Verify(softwareComponent.Verify)
+	if err != nil {
+		return err
+	}
+
+	stackCfg := c.Data.StackConfig
+	if b.Built() && (stackCfg.Strip || stackCfg.SplitDebugInfo) && !softwareComponent.NoStrip {
+		err = stripInstallDir(stackBasedir, b.Env.GetAppInstallDir(&b.App), stackCfg.SplitDebugInfo)
+		if err != nil {
+			return installErr(fmt.Errorf("unable to strip %s: %w", softwareComponent.Name, err))
+		}
+	}
+
+	if b.Built() {
+		// The provenance of a component that is already installed is left untouched
+		provenance := getProvenance(b, start)
+		provenance.Variants = softwareComponent.Variants
+		err = collectLicenses(stackBasedir, softwareComponent, b.Env.SrcDir)
+		if err != nil {
+			return installErr(fmt.Errorf("unable to collect the license files of %s: %w", softwareComponent.Name, err))
+		}
+		err = writeProvenance(b.Env.GetAppInstallDir(&b.App), provenance)
+		if err != nil {
+			return installErr(err)
+		}
+		attestationKey, err := c.loadAttestationKey()
+		if err != nil {
+			return installErr(err)
+		}
+		if attestationKey != nil {
+			err = writeComponentAttestation(b.Env.GetAppInstallDir(&b.App), attestationKey, provenance)
+			if err != nil {
+				return installErr(fmt.Errorf("unable to generate the attestation of %s: %w", softwareComponent.Name, err))
+			}
+		}
+	}
+
+	// Note: it is not required for components to have a build directory. For instance
+	// the code is compiled directly from the source directory when the component is
+	// packaged in the form of a tarball
+	compBuildDir, _ := GetCompBuildDir(stackBasedir, softwareComponent.Name)
+	compSrcDir, err := GetCompSrcDir(c.getSrcBasedir(), softwareComponent.Name)
+	if err != nil {
+		return installErr(fmt.Errorf("unable to get source dir from component %s: %w", softwareComponent.Name, err))
+	}
+	compInstallDir := b.Env.GetAppInstallDir(&b.App)
+	if b.Built() && c.shouldPrune(softwareComponent) {
+		compBuildDir, compSrcDir, err = c.pruneComponent(softwareComponent, compBuildDir, compSrcDir)
+		if err != nil {
+			return installErr(err)
+		}
+	}
+
+	if c.state != nil {
+		c.recordComponentSource(softwareComponent, b.App.Source.URL, &b.Env)
+		if _, overridden := c.SourceOverrides[softwareComponent.Name]; !overridden {
+			c.state.recordVariants(softwareComponent.Name, softwareComponent.Variants)
+			c.state.recordLocations(softwareComponent.Name, compInstallDir, compBuildDir, compSrcDir)
+		}
+		err = c.state.save(stackBasedir)
+		if err != nil {
+			return installErr(fmt.Errorf("unable to save the state of the stack: %w", err))
+		}
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.trackInstalledComponent(softwareComponent, compInstallDir, installedComponents, configIds)
+
+	if c.BuiltComponents == nil {
+		c.BuiltComponents = make(map[string]string)
+	}
+	c.BuiltComponents[softwareComponent.Name] = compBuildDir
+
+	if c.SrcComponents == nil {
+		c.SrcComponents = make(map[string]string)
+	}
+	c.SrcComponents[softwareComponent.Name] = compSrcDir
+
+	log.Printf("-> %s was successfully installed in %s", softwareComponent.Name, compInstallDir)
 	return nil
 }
 
+// trackInstalledComponent records that a component is installed in compInstallDir, both locally and globally, so
+// that the components installed next can depend on it. The caller must hold the mutex of the configuration.
+func (c *Config) trackInstalledComponent(softwareComponent *Component, compInstallDir string, installedComponents map[string]string, configIds map[string]string) {
+	if softwareComponent.ConfigId != "" {
+		configIds[softwareComponent.Name] = softwareComponent.ConfigId
+	}
+
+	installedComponents[softwareComponent.Name] = compInstallDir
+	if c.InstalledComponents == nil {
+		c.InstalledComponents = make(map[string]string)
+	}
+	c.InstalledComponents[softwareComponent.Name] = compInstallDir
+
+	// If the component has binaries, we update PATH accordingly so we can
+	// benefit from them as we progress installing the stack, i.e., handle
+	// dependencies between components of the stack
+	compBinDir := filepath.Join(compInstallDir, "bin")
+	if util.PathExists(compBinDir) {
+		if _, ok := c.Data.BuildEnv.Get("PATH"); !ok {
+			// The PATH of the build environment extends the one of the caller
+			c.Data.BuildEnv.Set("PATH", os.Getenv("PATH"))
+		}
+		c.Data.BuildEnv.Prepend("PATH", compBinDir, ":")
+	}
+}
+
+// installExternalComponent checks that an external component (see Component.External) is installed and records it
+// as installed, without building it
+func (c *Config) installExternalComponent(softwareComponent *Component, installedComponents map[string]string, configIds map[string]string) error {
+	if !util.IsDir(softwareComponent.External) {
+		return fmt.Errorf("external component %s is not installed in %s", softwareComponent.Name, softwareComponent.External)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.trackInstalledComponent(softwareComponent, softwareComponent.External, installedComponents, configIds)
+	log.Printf("-> %s is external, using %s", softwareComponent.Name, softwareComponent.External)
+	return nil
+}
+
+// Export creates a tarball of the stack, in its base directory, with the installed components, the
+// modulefiles, the state of the stack and its SBOM (see SBOMFilename), so that the stack can be
+// imported and used on another system. The tarball is encrypted and signed when configured to, see
+// StackCfg.ExportEncryption and StackCfg.ExportSignature. Only a subset of the components can be exported, see
+// ExportComponents, or only the components that changed since a previous export, see ExportBase. The
+// configuration files of the stack are not loaded when the configuration is already loaded, e.g., when it is
+// set in code.
 func (c *Config) Export() error {
-	err := c.Load()
-	if err != nil {
-		return fmt.Errorf("c.Load() failed: %w", err)
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
 	}
 
-	stackBasedir := filepath.Join(c.Data.StackConfig.InstallDir, c.Data.StackDefinition.Name)
+	stackBasedir := c.getStackBasedir()
 	if !util.PathExists(stackBasedir) {
 		return fmt.Errorf("%s does not exist", stackBasedir)
 	}
+	unlock, err := c.lock("export")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
 	installDir := filepath.Join(stackBasedir, "install")
 	if !util.PathExists(installDir) {
 		return fmt.Errorf("%s does not exist", installDir)
 	}
+	exported, err := c.getExportedComponents()
+	if err != nil {
+		return err
+	}
+
+	err = c.loadStackState()
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+	err = c.writeSBOM(exported)
+	if err != nil {
+		return err
+	}
+	// The state records the base directory of the stack so that it can be relocated when imported
+	err = c.state.save(stackBasedir)
+	if err != nil {
+		return fmt.Errorf("unable to save the state of the stack: %w", err)
+	}
+
+	manifest, err := c.getExportManifest(exported)
+	if err != nil {
+		return err
+	}
+	var changed map[string]bool
+	if manifest.Incremental {
+		changed = make(map[string]bool)
+		for _, name := range manifest.Included {
+			changed[name] = true
+		}
+	}
+	err = manifest.write(stackBasedir)
+	if err != nil {
+		return err
+	}
+
+	content := append(c.getExportContent(exported, changed), SBOMFilename, StateFilename, ExportManifestFilename)
+	err = writeChecksums(stackBasedir, content, ExportChecksumsFilename)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(filepath.Join(stackBasedir, ExportChecksumsFilename))
+	content = append(content, ExportChecksumsFilename)
 
 	tarballFilename := c.Data.StackDefinition.Name + ".tar.bz2"
 	tarBin, err := exec.LookPath("tar")
 	if err != nil {
 		return fmt.Errorf("tar is not available: %w", err)
 	}
-	tarCmd := exec.Command(tarBin, "-cjf", tarballFilename, "install")
-	tarCmd.Dir = stackBasedir
+	tarCmd := buildenv.GetTarCreateCmd(tarBin, stackBasedir, tarballFilename, content)
 	var stderr, stdout bytes.Buffer
 	tarCmd.Stderr = &stderr
 	tarCmd.Stdout = &stdout
@@ -473,133 +2054,449 @@ func (c *Config) Export() error {
 		return fmt.Errorf("command failed: %w - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
 	}
 
+	if encryption := c.getExportEncryption(); encryption != nil {
+		encryptedPath, err := encryption.encrypt(filepath.Join(stackBasedir, tarballFilename))
+		if err != nil {
+			return err
+		}
+		tarballFilename = filepath.Base(encryptedPath)
+	} else if c.Data.StackDefinition.Type == "private" {
+		log.Printf("[WARN] %s is a private stack but its export is not encrypted", c.Data.StackDefinition.Name)
+	}
+
+	attestationKey, err := c.loadAttestationKey()
+	if err != nil {
+		return err
+	}
+	if attestationKey != nil {
+		err = c.writeExportAttestation(filepath.Join(stackBasedir, tarballFilename), attestationKey)
+		if err != nil {
+			return fmt.Errorf("unable to generate the attestation of the stack: %w", err)
+		}
+	}
+
+	if signature := c.getExportSignature(); signature != nil {
+		err = signature.sign(filepath.Join(stackBasedir, tarballFilename))
+		if err != nil {
+			return err
+		}
+	}
+
 	fmt.Printf("Stack successfully export: %s\n", filepath.Join(stackBasedir, tarballFilename))
 	return nil
 }
 
-func (c *Config) Import(filePath string) error {
-	err := c.Load()
+// PublishExport uploads the tarball created by Export() to an artifact server, see StackCfg.ArtifactServers.
+// If url ends with a '/', the name of the tarball is appended to it.
+func (c *Config) PublishExport(url string) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+
+	tarballPath := filepath.Join(c.getStackBasedir(), c.getExportFilename())
+	if !util.FileExists(tarballPath) {
+		return fmt.Errorf("%s does not exist, the stack must be exported first", tarballPath)
+	}
+	env := c.newComponentEnv()
+	err := env.Publish(tarballPath, url)
 	if err != nil {
-		return fmt.Errorf("c.Load() failed: %w", err)
+		return fmt.Errorf("unable to publish %s: %w", tarballPath, err)
 	}
+	if signature := c.getExportSignature(); signature != nil {
+		// Signatures are published next to the tarball
+		tarballURL := url
+		if strings.HasSuffix(tarballURL, "/") {
+			tarballURL += filepath.Base(tarballPath)
+		}
+		for _, signatureFile := range signature.getSignatureFiles(tarballPath) {
+			err = env.Publish(signatureFile, tarballURL+strings.TrimPrefix(signatureFile, tarballPath))
+			if err != nil {
+				return fmt.Errorf("unable to publish %s: %w", signatureFile, err)
+			}
+		}
+	}
+
+	fmt.Printf("Stack successfully published: %s\n", url)
+	return nil
+}
 
-	stackBasedir := filepath.Join(c.Data.StackConfig.InstallDir, c.Data.StackDefinition.Name)
+// Import extracts a tarball created by Export() in the base directory of the stack, restoring the
+// installed components, the modulefiles, the state and the SBOM of the stack. Encrypted tarballs, i.e.,
+// with a .age or .gpg suffix, are decrypted, after their signature is verified when configured to. Nothing is
+// installed when the extracted files do not match the checksums of the export. The imported components are merged with the ones already in the stack when ImportPolicy is set.
+func (c *Config) Import(filePath string) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+
+	stackBasedir := c.getStackBasedir()
 	if !util.PathExists(stackBasedir) {
 		err := os.MkdirAll(stackBasedir, defaultPermission)
 		if err != nil {
 			return fmt.Errorf("unable to create %s: %w", stackBasedir, err)
 		}
 	}
+	unlock, err := c.lock("import")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	tarBin, err := exec.LookPath("tar")
+	// Signatures are verified before anything is extracted
+	if signature := c.getExportSignature(); signature != nil {
+		err = signature.verify(filePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	if isEncrypted(filePath) {
+		filePath, err = decrypt(filePath, c.getExportEncryption(), stackBasedir)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(filePath)
+	}
+
+	importDir, err := c.extractImport(filePath)
 	if err != nil {
-		return fmt.Errorf("ERROR: tar is not available: %w", err)
+		return err
 	}
-	tarCmd := exec.Command(tarBin, "-xjf", filePath)
-	tarCmd.Dir = stackBasedir
-	var stderr, stdout bytes.Buffer
-	tarCmd.Stderr = &stderr
-	tarCmd.Stdout = &stdout
-	err = tarCmd.Run()
+	defer os.RemoveAll(importDir)
+	err = checkIncrementalImport(importDir, stackBasedir)
+	if err != nil {
+		return err
+	}
+	if c.ImportPolicy != "" {
+		return c.mergeImport(importDir)
+	}
+	err = moveImportedFiles(importDir, stackBasedir)
 	if err != nil {
-		return fmt.Errorf("command failed: %s - stdout: %s - stderr: %s", err, stdout.String(), stderr.String())
+		return err
+	}
+
+	// The state of the stack is the imported one
+	c.mutex.Lock()
+	c.state = nil
+	c.mutex.Unlock()
+	err = c.loadStackState()
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the imported stack: %w", err)
+	}
+
+	// The modulefiles refer to the directory where the stack was exported from
+	if c.state.StackDir != "" && c.state.StackDir != stackBasedir {
+		err = relocateModulefiles(stackBasedir, c.state.StackDir)
+		if err != nil {
+			return err
+		}
+		err = relocateMachO(stackBasedir, c.state.StackDir)
+		if err != nil {
+			return err
+		}
+		c.state.relocate(stackBasedir)
+		err = c.state.save(stackBasedir)
+		if err != nil {
+			return fmt.Errorf("unable to save the state of the stack: %w", err)
+		}
 	}
 
 	fmt.Printf("Stack successfully import in %s\n", stackBasedir)
 	return nil
 }
 
-func (c *Config) GenerateModules(copyright, customEnvVarPrefix string) error {
-	err := c.Load()
+// relocateModulefiles rewrites the paths of the modulefiles of a stack that was moved from oldStackBasedir
+// to stackBasedir, e.g., when imported on another system
+func relocateModulefiles(stackBasedir string, oldStackBasedir string) error {
+	for _, modulefileDir := range []string{"modulefiles", "modulefiles_lua"} {
+		modulefileDir = filepath.Join(stackBasedir, modulefileDir)
+		if !util.PathExists(modulefileDir) {
+			continue
+		}
+		err := filepath.Walk(modulefileDir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+			return relocateFileContent(path, oldStackBasedir, stackBasedir)
+		})
+		if err != nil {
+			return fmt.Errorf("unable to relocate the modulefiles in %s: %w", modulefileDir, err)
+		}
+	}
+	log.Printf("-> Modulefiles relocated from %s to %s", oldStackBasedir, stackBasedir)
+	return nil
+}
+
+// relocateFileContent replaces the references to the directory oldDir in a text file, e.g., a modulefile, with newDir
+func relocateFileContent(path string, oldDir string, newDir string) error {
+	// The old directory must not be replaced when it is the prefix of another directory, e.g., /opt/stack2 for /opt/stack
+	re, err := regexp.Compile(regexp.QuoteMeta(oldDir) + `([/"'\s:;}]|$)`)
 	if err != nil {
-		return fmt.Errorf("c.Load() failed: %w", err)
+		return fmt.Errorf("unable to relocate %s from %s: %w", path, oldDir, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	newContent := re.ReplaceAll(content, []byte(newDir+"${1}"))
+	if bytes.Equal(content, newContent) {
+		return nil
+	}
+	return ioutil.WriteFile(path, newContent, info.Mode())
+}
+
+// getEnvVarPrefix returns the prefix of the environment variables set by the modulefile of a component,
+// e.g., HPCX_OMPI, characters that are not valid in environment variable names being replaced by '_'
+func getEnvVarPrefix(customEnvVarPrefix string, softwareComponent *Component) string {
+	if softwareComponent.EnvVarPrefix != "" {
+		return softwareComponent.EnvVarPrefix
+	}
+	name := strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, strings.ToUpper(softwareComponent.Name))
+	if customEnvVarPrefix == "" || strings.HasPrefix(name, customEnvVarPrefix) {
+		return name
+	}
+	return customEnvVarPrefix + name
+}
+
+// getModuleEnv returns the environment variables set by the modulefile of a component, as well as
+// the layout of the existing environment variables, e.g., PATH, the modulefile prepends.
+// customEnvVarPrefix is the prefix of the names of the environment variables, e.g., HPCX_ (see getEnvVarPrefix)
+func getModuleEnv(stackBasedir, customEnvVarPrefix string, softwareComponent *Component) (map[string]string, map[string][]string) {
+	envVars := make(map[string]string)
+	envLayout := make(map[string][]string)
+
+	compInstallDir := getCompInstallDir(stackBasedir, softwareComponent)
+	compBinDir := filepath.Join(compInstallDir, "bin")
+	compLibDir := filepath.Join(compInstallDir, "lib")
+	compIncDir := filepath.Join(compInstallDir, "include")
+	compManDir := filepath.Join(compInstallDir, "man")
+	compPkgDir := filepath.Join(compLibDir, "pkgconfig")
+
+	// Set the new environment variables
+	envVarPrefix := getEnvVarPrefix(customEnvVarPrefix, softwareComponent)
+	compBasedirVarName := envVarPrefix + "_DIR"
+	compBasedirVarValue := compInstallDir
+	envVars[compBasedirVarName] = compBasedirVarValue
+
+	// Note: it is not required for components to have a build directory. For instance
+	// the code is compiled directly from the source directory when the component is
+	// packaged in the form of a tarball
+	targetDir, _ := GetCompBuildDir(stackBasedir, softwareComponent.Name)
+	if targetDir != "" {
+		compBuildDirVarName := envVarPrefix + "_BUILD_DIR"
+		compBuildDirVarValue := targetDir
+		envVars[compBuildDirVarName] = compBuildDirVarValue
+	} else {
+		targetDir, err := GetCompSrcDir(stackBasedir, softwareComponent.Name)
+		if targetDir != "" && err == nil {
+			compSrcDirVarName := envVarPrefix + "_BUILD_DIR"
+			compSrcDirVarValue := targetDir
+			envVars[compSrcDirVarName] = compSrcDirVarValue
+		}
+	}
+
+	// Prepend existing environment variables
+	if util.PathExists(compBinDir) {
+		envLayout["PATH"] = append(envLayout["PATH"], compBinDir)
+	}
+
+	if util.PathExists(compLibDir) {
+		envLayout["LIBRARY_PATH"] = append(envLayout["LIBRARY_PATH"], compLibDir)
+		libPathVar := buildenv.LibraryPathVar()
+		envLayout[libPathVar] = append(envLayout[libPathVar], compLibDir)
+	}
+
+	if util.PathExists(compIncDir) {
+		envLayout["CPATH"] = append(envLayout["CPATH"], compIncDir)
+	}
+
+	if util.PathExists(compManDir) {
+		envLayout["MANPATH"] = append(envLayout["MANPATH"], compManDir)
+	}
+
+	if util.PathExists(compPkgDir) {
+		envLayout["PKG_CONFIG_PATH"] = append(envLayout["PKG_CONFIG_PATH"], compPkgDir)
+	}
+
+	return envVars, envLayout
+}
+
+// getFamily returns the family of a component, i.e., the name of the software it provides without its version
+func getFamily(comp *Component) string {
+	if comp.Family != "" {
+		return comp.Family
+	}
+	if comp.Version != "" && strings.HasSuffix(comp.Name, comp.Version) {
+		family := strings.TrimRight(strings.TrimSuffix(comp.Name, comp.Version), "-_/.")
+		if family != "" {
+			return family
+		}
+	}
+	return comp.Name
+}
+
+// getFamilies returns the components of each family of the stack that are not disabled
+func (c *Config) getFamilies() map[string][]string {
+	families := make(map[string][]string)
+	for _, comp := range c.Data.StackDefinition.Components {
+		if comp.Disabled {
+			continue
+		}
+		family := getFamily(&comp)
+		families[family] = append(families[family], comp.Name)
+	}
+	return families
+}
+
+// GenerateModules generates the modulefiles of all the components of the stack in a given format,
+// Tcl when not specified. Each dialect is generated in its own directory so that a single
+// installation of the stack can be used with both Environment Modules and Lmod. The configuration files of
+// the stack are not loaded when the configuration is already loaded, e.g., when it is set in code.
+func (c *Config) GenerateModules(copyright, customEnvVarPrefix string, format ModuleFormat) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
 	}
 
-	stackBasedir := filepath.Join(c.Data.StackConfig.InstallDir, c.Data.StackDefinition.Name)
+	stackBasedir := c.getStackBasedir()
 	if !util.PathExists(stackBasedir) {
 		return fmt.Errorf("stack base directory %s does not exist", stackBasedir)
 	}
+	unlock, err := c.lock("modulefile generation")
+	if err != nil {
+		return err
+	}
+	defer unlock()
 
-	modulefileDir := filepath.Join(stackBasedir, "modulefiles")
-	if !util.PathExists(modulefileDir) {
-		err := os.MkdirAll(modulefileDir, defaultPermission)
-		if err != nil {
-			return fmt.Errorf("unable to create %s: %w", modulefileDir, err)
+	err = c.loadStackState()
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+
+	modulefileDirs, err := getModulefileDirs(stackBasedir, format)
+	if err != nil {
+		return err
+	}
+	templates, err := c.loadModulefileTemplates(modulefileDirs)
+	if err != nil {
+		return err
+	}
+	for _, modulefileDir := range modulefileDirs {
+		if !util.PathExists(modulefileDir) {
+			err := os.MkdirAll(modulefileDir, defaultPermission)
+			if err != nil {
+				return fmt.Errorf("unable to create %s: %w", modulefileDir, err)
+			}
 		}
 	}
 
+	families := c.getFamilies()
 	for _, softwareComponent := range c.Data.StackDefinition.Components {
+		if softwareComponent.Disabled {
+			continue
+		}
+
+		if c.Data.StackConfig.GateModulesOnSanityCheck && c.state.sanityCheckFailed(softwareComponent.Name) {
+			log.Printf("[WARN] the sanity check of %s failed, not generating its modulefile", softwareComponent.Name)
+			continue
+		}
+
 		var requires []string
 		vars := make(map[string]string)
-		envVars := make(map[string]string)
-		envLayout := make(map[string][]string)
 
 		// Set the requirements
-		if softwareComponent.ConfigureDependency != "" {
-			deps := strings.Split(softwareComponent.ConfigureDependency, ",")
-			requires = append(requires, deps...)
+		requires = append(requires, getDependencies(&softwareComponent)...)
+
+		// Set the conflicts with the other versions of the component. The family directive is only
+		// required when the stack provides several versions or when the family is explicitly set.
+		var conflicts []string
+		family := getFamily(&softwareComponent)
+		for _, name := range families[family] {
+			if name != softwareComponent.Name {
+				conflicts = append(conflicts, name)
+			}
+		}
+		if len(conflicts) == 0 && softwareComponent.Family == "" {
+			family = ""
 		}
 
 		// Set the vars
 		vars["software_stack_dir"] = stackBasedir
 
-		compInstallDir := filepath.Join(stackBasedir, "install", softwareComponent.Name)
-		compBinDir := filepath.Join(compInstallDir, "bin")
-		compLibDir := filepath.Join(compInstallDir, "lib")
-		compIncDir := filepath.Join(compInstallDir, "include")
-		compManDir := filepath.Join(compInstallDir, "man")
-		compPkgDir := filepath.Join(compLibDir, "pkgconfig")
-
-		// Set the new environment variables
-		compBasedirVarName := strings.ToUpper(softwareComponent.Name) + "_DIR"
-		compBasedirVarValue := compInstallDir
-		envVars[compBasedirVarName] = compBasedirVarValue
-
-		// Note: it is not required for components to have a build directory. For instance
-		// the code is compiled directly from the source directory when the component is
-		// packaged in the form of a tarball
-		targetDir, _ := GetCompBuildDir(stackBasedir, softwareComponent.Name)
-		if targetDir != "" {
-			compBuildDirVarName := strings.ToUpper(softwareComponent.Name) + "_BUILD_DIR"
-			compBuildDirVarValue := targetDir
-			envVars[compBuildDirVarName] = compBuildDirVarValue
-		} else {
-			targetDir, err := GetCompSrcDir(stackBasedir, softwareComponent.Name)
-			if targetDir != "" && err == nil {
-				compSrcDirVarName := strings.ToUpper(softwareComponent.Name) + "_BUILD_DIR"
-				compSrcDirVarValue := targetDir
-				envVars[compSrcDirVarName] = compSrcDirVarValue
-			}
-		}
-
-		// Prepend existing environment variables
-		if util.PathExists(compBinDir) {
-			envLayout["PATH"] = append(envLayout["PATH"], compBinDir)
+		envVars, envLayout := getModuleEnv(stackBasedir, customEnvVarPrefix, &softwareComponent)
+		for name, value := range buildenv.GetSanitizerEnv(c.getSanitizers(&softwareComponent)) {
+			envVars[name] = value
 		}
-
-		if util.PathExists(compLibDir) {
-			envLayout["LIBRARY_PATH"] = append(envLayout["LIBRARY_PATH"], compLibDir)
-			envLayout["LD_LIBRARY_PATH"] = append(envLayout["LD_LIBRARY_PATH"], compLibDir)
+		modulefile := &module.Modulefile{
+			Name:    softwareComponent.Name,
+			Version: softwareComponent.Version,
+			Stack: module.StackInfo{
+				Name:   c.Data.StackDefinition.Name,
+				System: c.Data.StackDefinition.System,
+				Type:   c.Data.StackDefinition.Type,
+				Dir:    stackBasedir,
+			},
+			Copyright: copyright,
+			Requires:  requires,
+			Conflicts: conflicts,
+			Family:    family,
+			Vars:      vars,
+			EnvVars:   envVars,
+			EnvLayout: envLayout,
+			Whatis:    softwareComponent.GetMetadata().whatis(),
 		}
-
-		if util.PathExists(compIncDir) {
-			envLayout["CPATH"] = append(envLayout["CPATH"], compIncDir)
-		}
-
-		if util.PathExists(compManDir) {
-			envLayout["MANPATH"] = append(envLayout["MANPATH"], compManDir)
+		for dialect, modulefileDir := range modulefileDirs {
+			if tmpl, ok := templates[dialect]; ok {
+				err = module.GenerateFromTemplate(modulefileDir, dialect, tmpl, modulefile)
+			} else {
+				err = module.Generate(modulefileDir, dialect, modulefile)
+			}
+			if err != nil {
+				return fmt.Errorf("module.Generate() failed: %w", err)
+			}
 		}
+	}
 
-		if util.PathExists(compPkgDir) {
-			envLayout["PKG_CONFIG_PATH"] = append(envLayout["PKG_CONFIG_PATH"], compPkgDir)
+	for _, modulefileDir := range modulefileDirs {
+		err = c.applyOwnership(modulefileDir)
+		if err != nil {
+			return fmt.Errorf("unable to set the ownership of the modulefiles: %w", err)
 		}
+	}
 
-		err = module.Generate(modulefileDir, copyright, customEnvVarPrefix, softwareComponent.Name, requires, nil, vars, envVars, envLayout)
+	if c.Data.StackConfig.ValidateModulefiles {
+		problems, err := c.validateModules(customEnvVarPrefix, format)
 		if err != nil {
-			return fmt.Errorf("module.Generate() failed: %w", err)
+			return fmt.Errorf("unable to validate the modulefiles: %w", err)
+		}
+		if len(problems) > 0 {
+			var msgs []string
+			for _, problem := range problems {
+				msgs = append(msgs, problem.String())
+			}
+			return fmt.Errorf("invalid modulefiles: %s", strings.Join(msgs, "; "))
 		}
 	}
 
-	fmt.Printf("modules successfully creates, to use them: module use %s\n", modulefileDir)
+	for dialect, modulefileDir := range modulefileDirs {
+		fmt.Printf("%s modules successfully creates, to use them: module use %s\n", dialect, modulefileDir)
+	}
 	return nil
 }
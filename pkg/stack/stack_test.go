@@ -6,12 +6,87 @@
 package stack
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"os/exec"
+	"os/user"
 	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
+	"time"
+
+	"github.com/gvallee/go_software_build/internal/pkg/testutil"
+	"github.com/gvallee/go_software_build/pkg/buildenv"
+	"github.com/gvallee/go_software_build/pkg/builder"
+	"github.com/gvallee/go_software_build/pkg/metrics"
+	"github.com/gvallee/go_util/pkg/util"
 )
 
+// newLocalStack returns the configuration of a stack installed in a temporary directory and
+// composed of components created with testutil.CreateLocalSoftware()
+func newLocalStack(t *testing.T, srcDir string, components []Component) (*Config, string) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create the temporary directory for testing: %s", err)
+	}
+
+	for idx := range components {
+		if components[idx].URL == "" {
+			components[idx].URL = "file://" + srcDir
+		}
+	}
+
+	cfg := &Config{
+		Loaded: true,
+		Data: Stack{
+			StackConfig: &StackCfg{
+				InstallDir: testDir,
+				System:     "host",
+			},
+			StackDefinition: &StackDef{
+				Name:       "test",
+				System:     "host",
+				Type:       "public",
+				Components: components,
+			},
+		},
+	}
+	return cfg, testDir
+}
+
+// writeStackFiles writes the definition and configuration of a stack configured in code to files,
+// for the operations loading them, e.g., a new Config for the same stack
+func writeStackFiles(t *testing.T, cfg *Config, testDir string) {
+	cfg.DefFilePath = filepath.Join(testDir, "def.json")
+	cfg.ConfigFilePath = filepath.Join(testDir, "config.json")
+	for path, data := range map[string]interface{}{cfg.DefFilePath: cfg.Data.StackDefinition, cfg.ConfigFilePath: cfg.Data.StackConfig} {
+		content, err := json.Marshal(data)
+		if err != nil {
+			t.Fatalf("unable to encode %s: %s", path, err)
+		}
+		err = ioutil.WriteFile(path, content, 0644)
+		if err != nil {
+			t.Fatalf("unable to write %s: %s", path, err)
+		}
+	}
+}
+
 func TestInstallStack(t *testing.T) {
 	dummyCompName := "Comp1"
 	testDir, err := ioutil.TempDir("", "")
@@ -72,3 +147,4009 @@ func TestInstallStack(t *testing.T) {
 		t.Fatalf("install directory is %s instead of %s", cfg.InstalledComponents[dummyCompName], expectedInstallDir)
 	}
 }
+
+func TestUpdateRefs(t *testing.T) {
+	cfg := Config{
+		InstalledComponents: map[string]string{
+			"foo":     "/stack/install/foo",
+			"foo_bar": "/stack/install/foo_bar",
+		},
+		BuiltComponents: map[string]string{
+			"foo":     "/stack/build/foo",
+			"foo_bar": "",
+		},
+		SrcComponents: map[string]string{
+			"foo":     "/stack/src/foo",
+			"foo_bar": "/stack/src/foo_bar",
+		},
+		Data: Stack{
+			StackConfig: &StackCfg{
+				InstallDir: "/",
+			},
+			StackDefinition: &StackDef{
+				Name: "stack",
+				Components: []Component{
+					{
+						Name:    "foo",
+						Version: "1.2.3",
+					},
+					{
+						Name: "foo_bar",
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		token       string
+		expected    string
+		expectError bool
+	}{
+		{
+			name:     "no reference",
+			token:    "CC=gcc",
+			expected: "CC=gcc",
+		},
+		{
+			name:     "single reference",
+			token:    "FOO_LIB_DIR=@ref:foo_install_dir@/lib",
+			expected: "FOO_LIB_DIR=/stack/install/foo/lib",
+		},
+		{
+			name:     "multiple references",
+			token:    "--with-foo=@ref:foo_install_dir@ --with-foo-src=@ref:foo_src_dir@",
+			expected: "--with-foo=/stack/install/foo --with-foo-src=/stack/src/foo",
+		},
+		{
+			name:     "component name with underscore",
+			token:    "@ref:foo_bar_install_dir@:@ref:foo_build_dir@",
+			expected: "/stack/install/foo_bar:/stack/build/foo",
+		},
+		{
+			name:     "install subdirectories",
+			token:    "@ref:foo_bin_dir@:@ref:foo_lib_dir@:@ref:foo_bar_include_dir@",
+			expected: "/stack/install/foo/bin:/stack/install/foo/lib:/stack/install/foo_bar/include",
+		},
+		{
+			name:     "version and stack directory",
+			token:    "@ref:stack_dir@/foo-@ref:foo_version@.tar.gz",
+			expected: "/stack/foo-1.2.3.tar.gz",
+		},
+		{
+			name:        "undefined version",
+			token:       "@ref:foo_bar_version@",
+			expectError: true,
+		},
+		{
+			name:        "unknown component",
+			token:       "@ref:unknown_install_dir@",
+			expectError: true,
+		},
+		{
+			name:        "unknown reference",
+			token:       "@ref:foo_something_else@",
+			expectError: true,
+		},
+		{
+			name:        "missing end delimiter",
+			token:       "@ref:foo_install_dir",
+			expectError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		result, err := cfg.UpdateRefs(tt.token)
+		if tt.expectError {
+			if err == nil {
+				t.Fatalf("%s: UpdateRefs() succeeded while expected to fail", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: UpdateRefs() failed: %s", tt.name, err)
+		}
+		if result != tt.expected {
+			t.Fatalf("%s: result is %s instead of %s", tt.name, result, tt.expected)
+		}
+	}
+}
+
+func TestStackHooks(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(testDir)
+
+	postStackDone := false
+	// Hook commands run from the stack base directory
+	cfg.PreStack.Cmd = "touch pre_stack_done"
+	cfg.PostStack.Fn = func(c *Config, comp *Component, compErr error) error {
+		postStackDone = true
+		return nil
+	}
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("stack installation failed: %s", err)
+	}
+	if !util.FileExists(filepath.Join(cfg.getStackBasedir(), "pre_stack_done")) {
+		t.Fatalf("pre-stack command was not executed from the stack base directory")
+	}
+	if !postStackDone {
+		t.Fatalf("post-stack callback was not executed")
+	}
+
+	failingCfg, failingTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", ConfigureParams: "@ref:undefined_install_dir@"}})
+	defer os.RemoveAll(failingTestDir)
+	failedComp := ""
+	failingCfg.OnComponentFailure.Fn = func(c *Config, comp *Component, compErr error) error {
+		failedComp = comp.Name
+		return nil
+	}
+	err = failingCfg.InstallStack()
+	if err == nil {
+		t.Fatalf("stack installation succeeded while expected to fail")
+	}
+	if failedComp != "comp1" {
+		t.Fatalf("failure hook was not executed for comp1")
+	}
+}
+
+func TestDisabledComponent(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", Disabled: true}})
+	defer os.RemoveAll(testDir)
+
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("stack installation failed: %s", err)
+	}
+	if _, ok := cfg.InstalledComponents["comp2"]; ok {
+		t.Fatalf("disabled component was installed")
+	}
+	if util.PathExists(filepath.Join(testDir, "test", "install", "comp2")) {
+		t.Fatalf("disabled component was installed")
+	}
+
+	depCfg, depTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Disabled: true}, {Name: "comp2", ConfigureDependency: "comp1"}})
+	defer os.RemoveAll(depTestDir)
+	err = depCfg.InstallStack()
+	if err == nil {
+		t.Fatalf("installation succeeded while a component depends on a disabled component")
+	}
+
+	// Deployments select their components without editing the stack definition
+	siteCfg, siteTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", Disabled: true}})
+	defer os.RemoveAll(siteTestDir)
+	siteCfg.Data.StackConfig.DisabledComponents = []string{"comp1"}
+	siteCfg.Data.StackConfig.EnabledComponents = []string{"comp2"}
+	err = siteCfg.InstallStack()
+	if err != nil {
+		t.Fatalf("stack installation failed: %s", err)
+	}
+	if _, ok := siteCfg.InstalledComponents["comp1"]; ok {
+		t.Fatalf("component disabled by the configuration was installed")
+	}
+	if _, ok := siteCfg.InstalledComponents["comp2"]; !ok {
+		t.Fatalf("component enabled by the configuration was not installed")
+	}
+
+	siteCfg.Data.StackConfig.DisabledComponents = []string{"unknown"}
+	err = siteCfg.InstallStack()
+	if err == nil || !strings.Contains(err.Error(), "unknown component unknown") {
+		t.Fatalf("unknown component in the selection was accepted: %v", err)
+	}
+}
+
+func TestKeepGoing(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "broken", ConfigureParams: "@ref:undefined_install_dir@"},
+		{Name: "dependent", ConfigureDependency: "broken"},
+		{Name: "independent"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.KeepGoing = true
+
+	err := cfg.InstallStack()
+	if err == nil {
+		t.Fatalf("stack installation succeeded while expected to fail")
+	}
+	installErr, ok := err.(*InstallError)
+	if !ok {
+		t.Fatalf("unexpected type of error: %s", err)
+	}
+
+	expectedStatus := map[string]string{
+		"broken":      StatusFailed,
+		"dependent":   StatusSkipped,
+		"independent": StatusInstalled,
+	}
+	for name, status := range expectedStatus {
+		compReport := installErr.Report.Get(name)
+		if compReport == nil {
+			t.Fatalf("%s is not in the report", name)
+		}
+		if compReport.Status != status {
+			t.Fatalf("status of %s is %s instead of %s", name, compReport.Status, status)
+		}
+	}
+}
+
+func TestKeepGoingOwnership(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing the ownership requires privileges, skipping test")
+	}
+	group, err := user.LookupGroup("daemon")
+	if err != nil {
+		t.Skipf("unable to find the daemon group: %s", err)
+	}
+	gid, err := strconv.Atoi(group.Gid)
+	if err != nil {
+		t.Fatalf("invalid GID %s: %s", group.Gid, err)
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "failing", InstallCmd: "false"},
+		{Name: "working"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.KeepGoing = true
+	cfg.Data.StackConfig.Group = "daemon"
+	var postStackErr error
+	cfg.PostStack.Fn = func(c *Config, comp *Component, compErr error) error {
+		postStackErr = compErr
+		return nil
+	}
+
+	err = cfg.InstallStack()
+	if _, ok := err.(*InstallError); !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := postStackErr.(*InstallError); !ok {
+		t.Fatalf("post-stack hook was not executed with the failures: %v", postStackErr)
+	}
+	binPath := filepath.Join(getCompInstallDir(cfg.getStackBasedir(), &components[1]), "bin", "helloworld")
+	info, err := os.Stat(binPath)
+	if err != nil {
+		t.Fatalf("unable to stat %s: %s", binPath, err)
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); !ok || int(stat.Gid) != gid {
+		t.Fatalf("ownership of the successfully installed component was not changed")
+	}
+}
+
+func TestFailureActions(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	// The installation fails the first time, once the installation directory exists
+	marker := filepath.Join(srcDir, "failed_once")
+	configureScript := `#!/bin/sh
+prefix=/usr/local
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--prefix) shift; prefix="$1" ;;
+		--prefix=*) prefix="${1#--prefix=}" ;;
+	esac
+	shift
+done
+printf 'PREFIX=%s\n\nall:\n\ttrue\n\ninstall:\n\tmkdir -p $(PREFIX)/bin\n\ttest -f ` + marker + ` || { touch ` + marker + `; exit 1; }\n\ttouch $(PREFIX)/bin/helloworld\n' "$prefix" > Makefile
+`
+	flakyDir := filepath.Join(srcDir, "flaky")
+	err := os.MkdirAll(flakyDir, 0755)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", flakyDir, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(flakyDir, "configure"), []byte(configureScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create configure script: %s", err)
+	}
+
+	components := []Component{
+		{Name: "flaky", URL: "file://" + flakyDir},
+		{Name: "broken", ConfigureParams: "@ref:undefined_install_dir@"},
+		{Name: "independent"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	var failures []string
+	cfg.OnFailure = func(c *Config, comp *Component, err error) FailureAction {
+		failures = append(failures, comp.Name)
+		if comp.Name == "flaky" {
+			return FailureRetry
+		}
+		return FailureSkip
+	}
+
+	err = cfg.InstallStack()
+	installErr, ok := err.(*InstallError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if strings.Join(failures, ",") != "flaky,broken" {
+		t.Fatalf("OnFailure was called for %v instead of flaky and broken", failures)
+	}
+	expectedStatus := map[string]string{
+		"flaky":       StatusInstalled,
+		"broken":      StatusFailed,
+		"independent": StatusInstalled,
+	}
+	for name, status := range expectedStatus {
+		compReport := installErr.Report.Get(name)
+		if compReport == nil || compReport.Status != status {
+			t.Fatalf("status of %s is not %s: %+v", name, status, compReport)
+		}
+	}
+	if !util.FileExists(filepath.Join(getCompInstallDir(cfg.getStackBasedir(), &components[0]), "bin", "helloworld")) {
+		t.Fatalf("flaky was not installed again after its failure")
+	}
+
+	// Without decision, KeepGoing decides
+	cfg, testDir2 := newLocalStack(t, srcDir, []Component{{Name: "broken", ConfigureParams: "@ref:undefined_install_dir@"}, {Name: "independent"}})
+	defer os.RemoveAll(testDir2)
+	cfg.OnFailure = func(c *Config, comp *Component, err error) FailureAction {
+		return ""
+	}
+	err = cfg.InstallStack()
+	if _, ok := err.(*InstallError); err == nil || ok {
+		t.Fatalf("installation was not aborted: %v", err)
+	}
+}
+
+func TestComponentErrors(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "broken", ConfigureParams: "@ref:undefined_install_dir@"},
+		{Name: "missing", URL: "file://" + filepath.Join(srcDir, "does_not_exist")},
+		{Name: "failing", InstallCmd: "false"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.KeepGoing = true
+	var hookEnv []string
+	cfg.OnComponentFailure.Cmd = "echo $STACK_COMPONENT $STACK_STAGE $STACK_LOG >> " + filepath.Join(testDir, "failures")
+
+	err := cfg.InstallStack()
+	installErr, ok := err.(*InstallError)
+	if !ok {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := map[string]struct {
+		stage   string
+		logPath string
+	}{
+		"broken":  {builder.StagePrepare, ""},
+		"missing": {builder.StageDownload, ""},
+		"failing": {builder.StageInstall, cfg.getCommandsPath(&components[2])},
+	}
+	for name, tt := range expected {
+		compReport := installErr.Report.Get(name)
+		if compReport == nil {
+			t.Fatalf("%s is not in the report", name)
+		}
+		var buildErr *builder.BuildError
+		if !errors.As(compReport.Err, &buildErr) {
+			t.Fatalf("error of %s does not give the stage that failed: %s", name, compReport.Err)
+		}
+		if buildErr.Component != name || buildErr.Stage != tt.stage || buildErr.LogPath != tt.logPath {
+			t.Fatalf("%s failed at stage %s of %s with log %q instead of %s with log %q", name, buildErr.Stage, buildErr.Component, buildErr.LogPath, tt.stage, tt.logPath)
+		}
+		hookEnv = append(hookEnv, strings.TrimSpace(fmt.Sprintf("%s %s %s", name, tt.stage, tt.logPath)))
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(testDir, "failures"))
+	if err != nil {
+		t.Fatalf("unable to read the failures recorded by the hook: %s", err)
+	}
+	failures := strings.Split(strings.TrimSpace(string(content)), "\n")
+	sort.Strings(failures)
+	sort.Strings(hookEnv)
+	if strings.Join(failures, "\n") != strings.Join(hookEnv, "\n") {
+		t.Fatalf("failures recorded by the hook are %v instead of %v", failures, hookEnv)
+	}
+}
+
+// failingWriter is a writer that always fails, e.g., a socket whose other end is gone
+type failingWriter struct{}
+
+func (w failingWriter) Write(p []byte) (int, error) {
+	return 0, fmt.Errorf("broken pipe")
+}
+
+func TestEventStream(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "comp1"},
+		{Name: "comp2", InstallCmd: "false"},
+		{Name: "comp3", ConfigureDependency: "comp2"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.KeepGoing = true
+	var stream bytes.Buffer
+	cfg.EventStream = &stream
+
+	err := cfg.InstallStack()
+	if err == nil {
+		t.Fatalf("installation succeeded while comp2 is expected to fail")
+	}
+
+	var events []ProgressEvent
+	scanner := bufio.NewScanner(&stream)
+	for scanner.Scan() {
+		var event ProgressEvent
+		err := json.Unmarshal(scanner.Bytes(), &event)
+		if err != nil {
+			t.Fatalf("invalid event %s: %s", scanner.Text(), err)
+		}
+		if event.Stack != "test" {
+			t.Fatalf("event of stack %s instead of test: %s", event.Stack, scanner.Text())
+		}
+		events = append(events, event)
+	}
+	var summary []string
+	for _, event := range events {
+		summary = append(summary, strings.Join([]string{event.Type, event.Component, event.Stage, event.Status}, ":"))
+	}
+	expected := []string{
+		"stack_started:::",
+		"component_started:comp1::",
+		"stage_started:comp1:download:",
+		"stage_completed:comp1:download:",
+		"stage_started:comp1:unpack:",
+		"stage_completed:comp1:unpack:",
+		"stage_started:comp1:configure:",
+		"stage_completed:comp1:configure:",
+		"stage_started:comp1:compile:",
+		"stage_completed:comp1:compile:",
+		"stage_started:comp1:install:",
+		"stage_completed:comp1:install:",
+		"component_completed:comp1::installed",
+		"component_started:comp2::",
+		"stage_started:comp2:download:",
+		"stage_completed:comp2:download:",
+		"stage_started:comp2:unpack:",
+		"stage_completed:comp2:unpack:",
+		"stage_started:comp2:configure:",
+		"stage_completed:comp2:configure:",
+		"stage_started:comp2:compile:",
+		"stage_completed:comp2:compile:",
+		"stage_started:comp2:install:",
+		"stage_completed:comp2:install:",
+		"component_completed:comp2::failed",
+		"component_completed:comp3::skipped",
+		"stack_completed:::failure",
+	}
+	if strings.Join(summary, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected events:\n%s\ninstead of:\n%s", strings.Join(summary, "\n"), strings.Join(expected, "\n"))
+	}
+
+	stageFailure := events[23]
+	if stageFailure.Error == "" || stageFailure.LogPath != cfg.getCommandsPath(&components[1]) {
+		t.Fatalf("failure of the install stage of comp2 is not reported: %+v", stageFailure)
+	}
+	for idx, percent := range map[int]float64{0: 0, 1: 0, 12: 100.0 / 3, 13: 100.0 / 3, 20: 100.0 / 3, 24: 200.0 / 3, 25: 100, 26: 100} {
+		if events[idx].Percent != percent {
+			t.Fatalf("%s event is at %f%% instead of %f%%", summary[idx], events[idx].Percent, percent)
+		}
+	}
+	if events[0].Total != 3 || events[13].Index != 2 {
+		t.Fatalf("unexpected position of the components: %+v %+v", events[0], events[13])
+	}
+
+	// The installation does not depend on the consumer of the events
+	cfg.EventStream = failingWriter{}
+	cfg.KeepGoing = false
+	cfg.Data.StackDefinition.Components = cfg.Data.StackDefinition.Components[:1]
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("installation failed because the event stream is broken: %s", err)
+	}
+}
+
+func TestFetch(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2"}, {Name: "comp3", Disabled: true}})
+	defer os.RemoveAll(testDir)
+
+	err := cfg.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch() failed: %s", err)
+	}
+
+	for _, name := range []string{"comp1", "comp2"} {
+		expectedFile := filepath.Join(testDir, "test", "build", name, filepath.Base(srcDir), "configure")
+		if !util.FileExists(expectedFile) {
+			t.Fatalf("expected file %s does not exist", expectedFile)
+		}
+	}
+	if util.PathExists(filepath.Join(testDir, "test", "build", "comp3")) {
+		t.Fatalf("disabled component was fetched")
+	}
+
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("stack installation failed: %s", err)
+	}
+}
+
+func TestFetchSameTarballName(t *testing.T) {
+	// The tarballs of both components are named after their version
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer server.Close()
+
+	cfg, testDir := newLocalStack(t, "", []Component{
+		{Name: "comp1", URL: server.URL + "/comp1/v1.0.tar.gz"},
+		{Name: "comp2", URL: server.URL + "/comp2/v1.0.tar.gz"},
+	})
+	defer os.RemoveAll(testDir)
+	cfg.FetchJobs = 2
+
+	err := cfg.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch() failed: %s", err)
+	}
+	for _, name := range []string{"comp1", "comp2"} {
+		tarballPath := filepath.Join(cfg.getStackBasedir(), "src", name, "v1.0.tar.gz")
+		content, err := ioutil.ReadFile(tarballPath)
+		if err != nil {
+			t.Fatalf("unable to read %s: %s", tarballPath, err)
+		}
+		if string(content) != "/"+name+"/v1.0.tar.gz" {
+			t.Fatalf("%s has the tarball of another component: %s", name, content)
+		}
+	}
+}
+
+func TestDownloadSources(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	tarballDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(tarballDir)
+	tarballPath := filepath.Join(tarballDir, "comp2-1.0.tar.gz")
+	err = ioutil.WriteFile(tarballPath, []byte("not really a tarball"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create file: %s", err)
+	}
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", URL: "file://" + tarballPath}})
+	defer os.RemoveAll(testDir)
+
+	downloadDir := filepath.Join(testDir, "sources")
+	err = cfg.DownloadSources(downloadDir)
+	if err != nil {
+		t.Fatalf("DownloadSources() failed: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(downloadDir, SourceManifestFilename))
+	if err != nil {
+		t.Fatalf("unable to read manifest: %s", err)
+	}
+	var manifest SourceManifest
+	err = json.Unmarshal(content, &manifest)
+	if err != nil {
+		t.Fatalf("unable to parse manifest: %s", err)
+	}
+	if len(manifest.Sources) != 2 {
+		t.Fatalf("manifest has %d entries instead of 2", len(manifest.Sources))
+	}
+	tarballEntry := manifest.Sources[1]
+	if tarballEntry.Path != filepath.Join("comp2", "comp2-1.0.tar.gz") {
+		t.Fatalf("invalid path for comp2: %s", tarballEntry.Path)
+	}
+	if tarballEntry.SHA256 == "" {
+		t.Fatalf("checksum of comp2 is undefined")
+	}
+	if !util.FileExists(filepath.Join(downloadDir, manifest.Sources[0].Path, "configure")) {
+		t.Fatalf("source code of comp1 was not downloaded")
+	}
+}
+
+func TestSourceChecksums(t *testing.T) {
+	tarballPath, err := filepath.Abs(filepath.Join("..", "buildenv", "helloworld", "1.0.0.tar.gz"))
+	if err != nil {
+		t.Fatalf("unable to get path to test tarball: %s", err)
+	}
+
+	cfg, testDir := newLocalStack(t, "", []Component{{Name: "helloworld", URL: "file://" + tarballPath}})
+	defer os.RemoveAll(testDir)
+
+	err = cfg.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch() failed: %s", err)
+	}
+
+	s, err := loadState(filepath.Join(testDir, "test"))
+	if err != nil {
+		t.Fatalf("unable to load the state of the stack: %s", err)
+	}
+	compState, ok := s.Components["helloworld"]
+	if !ok || compState.SHA256 == "" {
+		t.Fatalf("checksum of the tarball was not recorded")
+	}
+
+	// A new configuration relying on the recorded state must succeed
+	cfg2, _ := newLocalStack(t, "", []Component{{Name: "helloworld", URL: "file://" + tarballPath}})
+	defer os.RemoveAll(cfg2.Data.StackConfig.InstallDir)
+	cfg2.Data.StackConfig.InstallDir = testDir
+	err = cfg2.Fetch()
+	if err != nil {
+		t.Fatalf("Fetch() failed with recorded checksums: %s", err)
+	}
+
+	// An invalid checksum must be detected
+	cfg3, _ := newLocalStack(t, "", []Component{{Name: "helloworld", URL: "file://" + tarballPath, Checksum: "1234"}})
+	defer os.RemoveAll(cfg3.Data.StackConfig.InstallDir)
+	cfg3.Data.StackConfig.InstallDir = testDir
+	err = cfg3.Fetch()
+	if err == nil {
+		t.Fatalf("Fetch() succeeded with an invalid checksum")
+	}
+}
+
+func TestSourceOverrides(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", URL: "file:///a/path/that/does/not/exist"}})
+	defer os.RemoveAll(testDir)
+	cfg.SourceOverrides = map[string]string{"comp2": srcDir}
+
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("stack installation failed: %s", err)
+	}
+	if !util.FileExists(filepath.Join(testDir, "test", "install", "comp2", "bin", "helloworld")) {
+		t.Fatalf("overridden component was not installed")
+	}
+
+	s, err := loadState(filepath.Join(testDir, "test"))
+	if err != nil {
+		t.Fatalf("unable to load the state of the stack: %s", err)
+	}
+	if _, ok := s.Components["comp2"]; ok {
+		t.Fatalf("overridden component was recorded in the state of the stack")
+	}
+
+	// Changes to the local source code must be picked up by the next build
+	err = ioutil.WriteFile(filepath.Join(srcDir, "NEWFILE"), []byte("new"), 0644)
+	if err != nil {
+		t.Fatalf("unable to modify local source code: %s", err)
+	}
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("second stack installation failed: %s", err)
+	}
+	if !util.FileExists(filepath.Join(testDir, "test", "build", "comp2", filepath.Base(srcDir), "NEWFILE")) {
+		t.Fatalf("local source code was not synchronized")
+	}
+
+	cfg.SourceOverrides["comp2"] = "/a/path/that/does/not/exist"
+	err = cfg.InstallStack()
+	if err == nil {
+		t.Fatalf("stack installation succeeded with an invalid override")
+	}
+}
+
+func TestInvalidSourceType(t *testing.T) {
+	cfg, testDir := newLocalStack(t, "/tmp", []Component{{Name: "comp1", SourceType: "svn"}})
+	defer os.RemoveAll(testDir)
+
+	err := cfg.Fetch()
+	if err == nil {
+		t.Fatalf("Fetch() succeeded with an invalid source type")
+	}
+}
+
+func TestSharedConfigureCache(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", NoConfigureCache: true}})
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackConfig.SharedConfigureCache = true
+
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("stack installation failed: %s", err)
+	}
+
+	for name, expected := range map[string]bool{"comp1": true, "comp2": false} {
+		manifest, err := ioutil.ReadFile(filepath.Join(testDir, "test", "install", name, "configure.MANIFEST"))
+		if err != nil {
+			t.Fatalf("unable to read configure manifest of %s: %s", name, err)
+		}
+		if strings.Contains(string(manifest), "--cache-file=") != expected {
+			t.Fatalf("invalid use of the configure cache for %s:\n%s", name, manifest)
+		}
+	}
+}
+
+func TestInstallComponentFromFiles(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp_a"}, {Name: "comp_b"}})
+	defer os.RemoveAll(testDir)
+	writeStackFiles(t, cfg, testDir)
+
+	// The configuration is loaded by the first call to InstallComponent()
+	fileCfg := &Config{DefFilePath: cfg.DefFilePath, ConfigFilePath: cfg.ConfigFilePath}
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for _, name := range []string{"comp_a", "comp_b"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			errs <- fileCfg.InstallComponent(name)
+		}(name)
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(60 * time.Second):
+		t.Fatalf("InstallComponent() did not complete")
+	}
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unable to install component: %s", err)
+		}
+	}
+	installed := fileCfg.GetInstalledComponents()
+	for _, name := range []string{"comp_a", "comp_b"} {
+		if !util.FileExists(filepath.Join(installed[name], "bin", "helloworld")) {
+			t.Fatalf("%s was not installed", name)
+		}
+	}
+}
+
+func TestConcurrentInstallComponent(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+	names := []string{"comp_a", "comp_b", "comp_c"}
+	var components []Component
+	for _, name := range names {
+		components = append(components, Component{Name: name, ConfigId: name + "_id"})
+	}
+	components = append(components, Component{Name: "comp_d", ConfigureDependency: "comp_a"})
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, len(names))
+	for _, name := range names {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			errs <- cfg.InstallComponent(name)
+		}(name)
+	}
+	// Read the state of the stack while components are being installed
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			cfg.UpdateRefs("@ref:comp_a_install_dir@")
+			cfg.GetInstalledComponents()
+		}
+	}()
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("unable to install component: %s", err)
+		}
+	}
+
+	installed := cfg.GetInstalledComponents()
+	for _, name := range names {
+		if !util.FileExists(filepath.Join(installed[name], "bin", "helloworld")) {
+			t.Fatalf("%s was not installed", name)
+		}
+	}
+
+	err := cfg.InstallComponent("comp_d")
+	if err != nil {
+		t.Fatalf("unable to install component: %s", err)
+	}
+	manifest, err := ioutil.ReadFile(filepath.Join(testDir, "test", "install", "comp_d", "configure.MANIFEST"))
+	if err != nil {
+		t.Fatalf("unable to read the configure manifest: %s", err)
+	}
+	if !strings.Contains(string(manifest), "--with-comp_a_id="+installed["comp_a"]) {
+		t.Fatalf("comp_d was not configured with its dependency: %s", string(manifest))
+	}
+
+	err = cfg.InstallComponent("unknown")
+	if err == nil {
+		t.Fatalf("installing an unknown component succeeded")
+	}
+}
+
+func TestSanityCheck(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "base", SanityCheck: "helloworld"},
+		{Name: "dependent", ConfigureDependency: "base", SanityCheck: "helloworld && test -n \"$BASE_DIR\""},
+		{Name: "broken", SanityCheck: "false"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackConfig.GateModulesOnSanityCheck = true
+
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	for _, name := range []string{"base", "dependent", "broken"} {
+		compReport := cfg.Report.Get(name)
+		if compReport == nil || compReport.Status != StatusInstalled {
+			t.Fatalf("unexpected report for %s: %+v", name, compReport)
+		}
+		if (compReport.SanityCheckErr != nil) != (name == "broken") {
+			t.Fatalf("unexpected sanity check result for %s: %v", name, compReport.SanityCheckErr)
+		}
+	}
+
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	modulefileDir := filepath.Join(testDir, "test", "modulefiles")
+	for _, name := range []string{"base", "dependent"} {
+		if !util.PathExists(filepath.Join(modulefileDir, name)) {
+			t.Fatalf("modulefile of %s was not generated", name)
+		}
+	}
+	if util.PathExists(filepath.Join(modulefileDir, "broken")) {
+		t.Fatalf("modulefile of broken was generated while its sanity check failed")
+	}
+
+	// The failure of the sanity check is also reported when installing a single component
+	err = cfg.InstallComponent("broken")
+	if err == nil || !strings.Contains(err.Error(), "sanity check of broken failed") {
+		t.Fatalf("the failure of the sanity check of broken was not reported: %v", err)
+	}
+	err = cfg.InstallComponent("base")
+	if err != nil {
+		t.Fatalf("unable to install base: %s", err)
+	}
+}
+
+func TestModuleConflicts(t *testing.T) {
+	components := []Component{
+		{Name: "openmpi-4.1", Version: "4.1"},
+		{Name: "openmpi-5.0", Version: "5.0"},
+		{Name: "mpich", Family: "mpi"},
+		{Name: "ucx", Version: "1.15"},
+	}
+	cfg, testDir := newLocalStack(t, "", components)
+	defer os.RemoveAll(testDir)
+	err := os.MkdirAll(cfg.getStackBasedir(), 0755)
+	if err != nil {
+		t.Fatalf("unable to create the stack directory: %s", err)
+	}
+	writeStackFiles(t, cfg, testDir)
+
+	err = cfg.GenerateModules("# Copyright", "", ModuleFormatBoth)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+
+	tests := map[string][]string{
+		"openmpi-4.1": {"conflict openmpi-5.0\n", "family openmpi\n"},
+		"openmpi-5.0": {"conflict openmpi-4.1\n", "family openmpi\n"},
+		"mpich":       {"family mpi\n"},
+		"ucx":         nil,
+	}
+	for name, expected := range tests {
+		content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), "modulefiles", name))
+		if err != nil {
+			t.Fatalf("unable to read the modulefile of %s: %s", name, err)
+		}
+		for _, e := range expected {
+			if !strings.Contains(string(content), e) {
+				t.Fatalf("modulefile of %s does not include %q:\n%s", name, e, content)
+			}
+		}
+		if expected == nil && (strings.Contains(string(content), "conflict ") || strings.Contains(string(content), "family ")) {
+			t.Fatalf("modulefile of %s has unexpected conflicts:\n%s", name, content)
+		}
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), "modulefiles_lua", "openmpi-4.1.lua"))
+	if err != nil {
+		t.Fatalf("unable to read the Lua modulefile: %s", err)
+	}
+	for _, e := range []string{"-- Copyright\n", "conflict(\"openmpi-5.0\")\n", "family(\"openmpi\")\n", "local software_stack_dir = "} {
+		if !strings.Contains(string(content), e) {
+			t.Fatalf("Lua modulefile does not include %q:\n%s", e, content)
+		}
+	}
+}
+
+func TestModulefileTemplate(t *testing.T) {
+	components := []Component{
+		{Name: "ucx", Version: "1.15"},
+	}
+	cfg, testDir := newLocalStack(t, "", components)
+	defer os.RemoveAll(testDir)
+	err := os.MkdirAll(cfg.getStackBasedir(), 0755)
+	if err != nil {
+		t.Fatalf("unable to create the stack directory: %s", err)
+	}
+
+	tmpl := "{{.Content \"tcl\"}}\n# {{.Name}} {{.Version}} from {{.Stack.Name}}, contact: admin@example.com\n"
+	err = ioutil.WriteFile(filepath.Join(testDir, "modulefile.tmpl"), []byte(tmpl), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the template: %s", err)
+	}
+	cfg.Data.StackConfig.ModulefileTemplates = map[string]string{"tcl": "modulefile.tmpl"}
+	writeStackFiles(t, cfg, testDir)
+
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), "modulefiles", "ucx"))
+	if err != nil {
+		t.Fatalf("unable to read the modulefile: %s", err)
+	}
+	for _, e := range []string{"#%Module1.0", "setenv UCX_DIR ", "# ucx 1.15 from test, contact: admin@example.com"} {
+		if !strings.Contains(string(content), e) {
+			t.Fatalf("modulefile does not include %q:\n%s", e, content)
+		}
+	}
+}
+
+func TestEnvVarPrefix(t *testing.T) {
+	components := []Component{
+		{Name: "openmpi-4.1"},
+		{Name: "ucx", EnvVarPrefix: "MY_UCX"},
+	}
+	cfg, testDir := newLocalStack(t, "", components)
+	defer os.RemoveAll(testDir)
+	err := os.MkdirAll(cfg.getStackBasedir(), 0755)
+	if err != nil {
+		t.Fatalf("unable to create the stack directory: %s", err)
+	}
+	writeStackFiles(t, cfg, testDir)
+
+	err = cfg.GenerateModules("", "HPCX_", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	tests := map[string]string{
+		"openmpi-4.1": "setenv HPCX_OPENMPI_4_1_DIR ",
+		"ucx":         "setenv MY_UCX_DIR ",
+	}
+	for name, expected := range tests {
+		content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), "modulefiles", name))
+		if err != nil {
+			t.Fatalf("unable to read the modulefile of %s: %s", name, err)
+		}
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("modulefile of %s does not include %q:\n%s", name, expected, content)
+		}
+	}
+}
+
+func TestExportImport(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available, skipping test")
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "1.0"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	err = cfg.Export()
+	if err != nil {
+		t.Fatalf("unable to export the stack: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), SBOMFilename))
+	if err != nil {
+		t.Fatalf("unable to read the SBOM: %s", err)
+	}
+	var sbom SBOM
+	err = json.Unmarshal(content, &sbom)
+	if err != nil {
+		t.Fatalf("unable to parse the SBOM: %s", err)
+	}
+	if len(sbom.Components) != 1 || sbom.Components[0].Name != "comp1" || sbom.Components[0].Version != "1.0" {
+		t.Fatalf("unexpected SBOM: %s", content)
+	}
+
+	importCfg, importTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "1.0"}})
+	defer os.RemoveAll(importTestDir)
+	err = importCfg.Import(filepath.Join(cfg.getStackBasedir(), "test.tar.bz2"))
+	if err != nil {
+		t.Fatalf("unable to import the stack: %s", err)
+	}
+	for _, f := range []string{"install/comp1/1.0/bin/helloworld", "modulefiles/comp1", StateFilename, SBOMFilename} {
+		if !util.PathExists(filepath.Join(importCfg.getStackBasedir(), f)) {
+			t.Fatalf("%s was not imported", f)
+		}
+	}
+
+	// The modulefiles must refer to the new location of the stack
+	content, err = ioutil.ReadFile(filepath.Join(importCfg.getStackBasedir(), "modulefiles", "comp1"))
+	if err != nil {
+		t.Fatalf("unable to read the imported modulefile: %s", err)
+	}
+	if strings.Contains(string(content), cfg.getStackBasedir()) || !strings.Contains(string(content), importCfg.getStackBasedir()+"/install/comp1/1.0") {
+		t.Fatalf("imported modulefile was not relocated:\n%s", content)
+	}
+	if util.PathExists(filepath.Join(importCfg.getStackBasedir(), ExportChecksumsFilename)) {
+		t.Fatalf("the checksums of the export were imported")
+	}
+
+	// Corrupted tarballs are not imported
+	corruptedDir := filepath.Join(testDir, "corrupted")
+	err = os.MkdirAll(corruptedDir, 0755)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", corruptedDir, err)
+	}
+	err = extractTarball(filepath.Join(cfg.getStackBasedir(), "test.tar.bz2"), corruptedDir)
+	if err != nil {
+		t.Fatalf("unable to extract the exported stack: %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(corruptedDir, "install", "comp1", "1.0", "bin", "helloworld"), []byte("corrupted"), 0755)
+	if err != nil {
+		t.Fatalf("unable to corrupt the exported stack: %s", err)
+	}
+	corruptedTarball := filepath.Join(testDir, "corrupted.tar.bz2")
+	output, err := exec.Command("tar", "-cjf", corruptedTarball, "-C", corruptedDir, ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to create the corrupted tarball: %s", output)
+	}
+	corruptedCfg, corruptedTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "1.0"}})
+	defer os.RemoveAll(corruptedTestDir)
+	err = corruptedCfg.Import(corruptedTarball)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Fatalf("importing a corrupted tarball did not fail as expected: %v", err)
+	}
+	if util.PathExists(filepath.Join(corruptedCfg.getStackBasedir(), "install")) {
+		t.Fatalf("files of a corrupted tarball were installed")
+	}
+}
+
+func TestSelectiveExport(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available, skipping test")
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{{Name: "compiler"}, {Name: "base"}, {Name: "runtime", ConfigureDependency: "base"}}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+
+	tarballPath := filepath.Join(cfg.getStackBasedir(), "test.tar.bz2")
+	for _, tc := range []struct {
+		dependencies bool
+		expected     []string
+	}{
+		{false, []string{"runtime"}},
+		{true, []string{"base", "runtime"}},
+	} {
+		cfg.ExportComponents = []string{"runtime"}
+		cfg.ExportDependencies = tc.dependencies
+		err = cfg.Export()
+		if err != nil {
+			t.Fatalf("unable to export the stack: %s", err)
+		}
+		output, err := exec.Command("tar", "-tjf", tarballPath).CombinedOutput()
+		if err != nil {
+			t.Fatalf("unable to list the content of %s: %s", tarballPath, output)
+		}
+		exported := make(map[string]bool)
+		for _, f := range strings.Split(string(output), "\n") {
+			if strings.HasPrefix(f, "install/") || strings.HasPrefix(f, "modulefiles/") {
+				exported[strings.Split(f, "/")[1]] = true
+			}
+		}
+		delete(exported, "")
+		if len(exported) != len(tc.expected) {
+			t.Fatalf("unexpected exported components: %v (expected: %v)", exported, tc.expected)
+		}
+		for _, name := range tc.expected {
+			if !exported[name] {
+				t.Fatalf("%s was not exported: %v", name, exported)
+			}
+		}
+
+		content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), SBOMFilename))
+		if err != nil {
+			t.Fatalf("unable to read the SBOM: %s", err)
+		}
+		var sbom SBOM
+		err = json.Unmarshal(content, &sbom)
+		if err != nil {
+			t.Fatalf("unable to parse the SBOM: %s", err)
+		}
+		if len(sbom.Components) != len(tc.expected) {
+			t.Fatalf("unexpected SBOM: %s", content)
+		}
+	}
+
+	cfg.ExportComponents = []string{"unknown"}
+	err = cfg.Export()
+	if err == nil {
+		t.Fatalf("exporting an unknown component succeeded")
+	}
+}
+
+func TestIncrementalExport(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available, skipping test")
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	err = cfg.Export()
+	if err != nil {
+		t.Fatalf("unable to export the stack: %s", err)
+	}
+	stackBasedir := cfg.getStackBasedir()
+	baseTarball := filepath.Join(testDir, "base.tar.bz2")
+	baseManifest := filepath.Join(testDir, "base.json")
+	for src, dst := range map[string]string{filepath.Join(stackBasedir, "test.tar.bz2"): baseTarball, filepath.Join(stackBasedir, ExportManifestFilename): baseManifest} {
+		err = os.Rename(src, dst)
+		if err != nil {
+			t.Fatalf("unable to move %s: %s", src, err)
+		}
+	}
+
+	newFile := filepath.Join(stackBasedir, "install", "comp2", "NEWFILE")
+	err = ioutil.WriteFile(newFile, []byte("new"), 0644)
+	if err != nil {
+		t.Fatalf("unable to modify comp2: %s", err)
+	}
+	cfg.ExportBase = baseManifest
+	err = cfg.Export()
+	if err != nil {
+		t.Fatalf("unable to export the stack: %s", err)
+	}
+	manifest, err := loadExportManifest(filepath.Join(stackBasedir, ExportManifestFilename))
+	if err != nil {
+		t.Fatalf("unable to load the manifest of the export: %s", err)
+	}
+	if !manifest.Incremental || len(manifest.Fingerprints) != 2 || len(manifest.Included) != 1 || manifest.Included[0] != "comp2" {
+		t.Fatalf("unexpected manifest: %+v", manifest)
+	}
+	tarball := filepath.Join(stackBasedir, "test.tar.bz2")
+	output, err := exec.Command("tar", "-tjf", tarball).CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to list the content of %s: %s", tarball, output)
+	}
+	if strings.Contains(string(output), "install/comp1") || !strings.Contains(string(output), "install/comp2/NEWFILE") {
+		t.Fatalf("unexpected content of the incremental export:\n%s", output)
+	}
+
+	// Incremental exports can only be imported after the export they are based on
+	importCfg, importTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2"}})
+	defer os.RemoveAll(importTestDir)
+	err = importCfg.Import(tarball)
+	if err == nil {
+		t.Fatalf("importing an incremental export without its base succeeded")
+	}
+	for _, path := range []string{baseTarball, tarball} {
+		err = importCfg.Import(path)
+		if err != nil {
+			t.Fatalf("unable to import %s: %s", path, err)
+		}
+	}
+	for _, f := range []string{"install/comp1/bin/helloworld", "install/comp2/NEWFILE"} {
+		if !util.PathExists(filepath.Join(importCfg.getStackBasedir(), f)) {
+			t.Fatalf("%s was not imported", f)
+		}
+	}
+}
+
+func TestImportMerge(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available, skipping test")
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "1.0"}, {Name: "comp2"}, {Name: "comp3"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	err = cfg.Export()
+	if err != nil {
+		t.Fatalf("unable to export the stack: %s", err)
+	}
+	tarballPath := filepath.Join(cfg.getStackBasedir(), "test.tar.bz2")
+
+	importCfg, importTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "0.9"}, {Name: "comp2"}})
+	defer os.RemoveAll(importTestDir)
+	err = importCfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	importStackDir := importCfg.getStackBasedir()
+	marker := filepath.Join(importStackDir, "install", "comp2", "marker")
+	err = ioutil.WriteFile(marker, []byte("marker"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", marker, err)
+	}
+
+	importCfg.ImportPolicy = ImportSkip
+	importCfg.OnImportConflict = func(conflict *ImportConflict) ImportPolicy {
+		if conflict.Name == "comp1" {
+			return ImportRename
+		}
+		return ""
+	}
+	err = importCfg.Import(tarballPath)
+	if err != nil {
+		t.Fatalf("unable to import the stack: %s", err)
+	}
+	if len(importCfg.ImportConflicts) != 2 {
+		t.Fatalf("unexpected conflicts: %+v", importCfg.ImportConflicts)
+	}
+	for _, conflict := range importCfg.ImportConflicts {
+		switch conflict.Name {
+		case "comp1":
+			if conflict.SameVersion() || conflict.Resolution != ImportRename || conflict.NewName != "comp1-1.0" {
+				t.Fatalf("unexpected conflict: %+v", conflict)
+			}
+		case "comp2":
+			if !conflict.SameVersion() || conflict.Resolution != ImportSkip {
+				t.Fatalf("unexpected conflict: %+v", conflict)
+			}
+		default:
+			t.Fatalf("unexpected conflict: %+v", conflict)
+		}
+	}
+	for _, f := range []string{"install/comp1/0.9/bin/helloworld", "install/comp1-1.0/1.0/bin/helloworld", "install/comp3/bin/helloworld", "modulefiles/comp1-1.0", "modulefiles/comp3", "install/comp2/marker"} {
+		if !util.PathExists(filepath.Join(importStackDir, f)) {
+			t.Fatalf("%s does not exist after the import", f)
+		}
+	}
+	content, err := ioutil.ReadFile(filepath.Join(importStackDir, "modulefiles", "comp1-1.0"))
+	if err != nil {
+		t.Fatalf("unable to read the imported modulefile: %s", err)
+	}
+	if strings.Contains(string(content), cfg.getStackBasedir()) || !strings.Contains(string(content), importStackDir+"/install/comp1-1.0/1.0") {
+		t.Fatalf("renamed modulefile was not relocated:\n%s", content)
+	}
+	s, err := loadState(importStackDir)
+	if err != nil {
+		t.Fatalf("unable to load the state of the stack: %s", err)
+	}
+	for _, name := range []string{"comp1", "comp1-1.0", "comp2", "comp3"} {
+		if _, ok := s.Components[name]; !ok {
+			t.Fatalf("%s is not in the state of the stack", name)
+		}
+	}
+	if s.Components["comp1-1.0"].InstallDir != filepath.Join(importStackDir, "install", "comp1-1.0", "1.0") {
+		t.Fatalf("invalid installation directory of the renamed component: %s", s.Components["comp1-1.0"].InstallDir)
+	}
+
+	// Overwritten components are replaced with the imported ones
+	importCfg.ImportPolicy = ImportOverwrite
+	importCfg.OnImportConflict = nil
+	err = importCfg.Import(tarballPath)
+	if err != nil {
+		t.Fatalf("unable to import the stack: %s", err)
+	}
+	if util.PathExists(marker) {
+		t.Fatalf("comp2 was not overwritten")
+	}
+}
+
+func TestCloneTo(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	stackBasedir := cfg.getStackBasedir()
+	pcDir := filepath.Join(stackBasedir, "install", "comp1", "lib", "pkgconfig")
+	err = os.MkdirAll(pcDir, 0755)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", pcDir, err)
+	}
+	err = ioutil.WriteFile(filepath.Join(pcDir, "comp1.pc"), []byte("prefix="+stackBasedir+"/install/comp1\nlibdir=${prefix}/lib\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the pkg-config file: %s", err)
+	}
+
+	cloneDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create the temporary directory for testing: %s", err)
+	}
+	defer os.RemoveAll(cloneDir)
+	err = cfg.CloneTo(cloneDir)
+	if err != nil {
+		t.Fatalf("unable to clone the stack: %s", err)
+	}
+	newStackBasedir := filepath.Join(cloneDir, "test")
+	if !util.FileExists(filepath.Join(newStackBasedir, "install", "comp1", "bin", "helloworld")) {
+		t.Fatalf("the components of the stack were not cloned")
+	}
+	for _, f := range []string{"modulefiles/comp1", "install/comp1/lib/pkgconfig/comp1.pc"} {
+		content, err := ioutil.ReadFile(filepath.Join(newStackBasedir, f))
+		if err != nil {
+			t.Fatalf("unable to read %s: %s", f, err)
+		}
+		if strings.Contains(string(content), stackBasedir) || !strings.Contains(string(content), newStackBasedir+"/install/comp1") {
+			t.Fatalf("%s was not relocated:\n%s", f, content)
+		}
+	}
+	s, err := loadState(newStackBasedir)
+	if err != nil {
+		t.Fatalf("unable to load the state of the cloned stack: %s", err)
+	}
+	if s.StackDir != newStackBasedir || s.Components["comp1"] == nil || s.Components["comp1"].InstallDir != filepath.Join(newStackBasedir, "install", "comp1") {
+		t.Fatalf("the state of the cloned stack was not relocated: %+v", s)
+	}
+
+	err = cfg.CloneTo(cloneDir)
+	if err == nil {
+		t.Fatalf("cloning the stack over an existing stack succeeded")
+	}
+
+	rpath := relocateRpath("$ORIGIN/../lib:/opt/stack/install/comp1/lib:/opt/stack2/lib", "/opt/stack", "/prod/stack")
+	if rpath != "$ORIGIN/../lib:/prod/stack/install/comp1/lib:/opt/stack2/lib" {
+		t.Fatalf("invalid relocated RPATH: %s", rpath)
+	}
+}
+
+func TestPruneBuildTrees(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{{Name: "comp1", PruneBuildTree: true}, {Name: "comp2"}, {Name: "comp3", PruneBuildTree: true, KeepBuildDir: true}}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	stackBasedir := filepath.Join(testDir, "test")
+	logFile := filepath.Join(stackBasedir, "build", "comp1", "build.log")
+	cfg.PreStack = Hook{Fn: func(c *Config, comp *Component, compErr error) error {
+		err := os.MkdirAll(filepath.Dir(logFile), 0755)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(logFile, []byte("log"), 0644)
+	}}
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	if !util.FileExists(filepath.Join(stackBasedir, "install", "comp1", "bin", "helloworld")) {
+		t.Fatalf("comp1 was not installed")
+	}
+
+	// Only the logs of comp1 are left
+	err = filepath.Walk(filepath.Join(stackBasedir, "build", "comp1"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && path != logFile {
+			t.Fatalf("%s was not pruned", path)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("unable to get the content of the build directory: %s", err)
+	}
+	if !util.FileExists(logFile) {
+		t.Fatalf("the logs of comp1 were pruned")
+	}
+	for _, name := range []string{"comp2", "comp3"} {
+		buildDir, err := GetCompBuildDir(stackBasedir, name)
+		if err != nil || buildDir != cfg.BuiltComponents[name] || !util.FileExists(filepath.Join(buildDir, "Makefile")) {
+			t.Fatalf("the build directory of %s was pruned", name)
+		}
+	}
+}
+
+func TestComponentBuildBasedir(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+	localDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(localDir)
+
+	components := []Component{{Name: "comp1", BuildBasedir: localDir, ScratchBasedir: localDir}, {Name: "comp2"}}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	stackBasedir := filepath.Join(testDir, "test")
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	if !util.FileExists(filepath.Join(stackBasedir, "install", "comp1", "bin", "helloworld")) {
+		t.Fatalf("comp1 was not installed")
+	}
+
+	localBuildDir := filepath.Join(localDir, "test", "build", "comp1", filepath.Base(srcDir))
+	if !util.FileExists(filepath.Join(localBuildDir, "Makefile")) {
+		t.Fatalf("comp1 was not built in %s", localBuildDir)
+	}
+	buildDir, err := GetCompBuildDir(stackBasedir, "comp1")
+	if err != nil || !util.FileExists(filepath.Join(buildDir, "Makefile")) {
+		t.Fatalf("invalid build directory of comp1: %s (err: %v)", buildDir, err)
+	}
+	if util.PathExists(filepath.Join(localDir, "test", "build", "comp2")) {
+		t.Fatalf("comp2 was not built in the stack")
+	}
+	buildDir, err = GetCompBuildDir(stackBasedir, "comp2")
+	if err != nil || !util.FileExists(filepath.Join(buildDir, "Makefile")) {
+		t.Fatalf("invalid build directory of comp2: %s (err: %v)", buildDir, err)
+	}
+}
+
+func TestIsolatedBuilds(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "comp1"},
+		{Name: "comp2", ConfigureDependency: "comp1"},
+		{Name: "comp3", ConfigureDependency: "comp2"},
+		{Name: "comp4"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	comp := cfg.getComponent("comp3")
+	env := cfg.newComponentEnv()
+	if cfg.getIsolation(&env, comp) != nil {
+		t.Fatalf("builds are isolated by default")
+	}
+	cfg.Data.StackConfig.IsolatedBuilds = true
+	isolation := cfg.getIsolation(&env, comp)
+	if isolation == nil {
+		t.Fatalf("the builds are not isolated")
+	}
+	expectedDirs := []string{cfg.InstalledComponents["comp1"], cfg.InstalledComponents["comp2"]}
+	if strings.Join(isolation.ReadOnlyDirs, ",") != strings.Join(expectedDirs, ",") {
+		t.Fatalf("unexpected visible directories: %v instead of %v", isolation.ReadOnlyDirs, expectedDirs)
+	}
+	if len(isolation.HiddenDirs) != 1 || isolation.HiddenDirs[0] != env.InstallDir {
+		t.Fatalf("unexpected hidden directories: %v", isolation.HiddenDirs)
+	}
+	if len(isolation.WritableDirs) != 1 || isolation.WritableDirs[0] != filepath.Join(env.InstallDir, "comp3") {
+		t.Fatalf("unexpected writable directories: %v", isolation.WritableDirs)
+	}
+}
+
+func TestHashedInstallPrefixes(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", ConfigureDependency: "comp1"}})
+	defer os.RemoveAll(testDir)
+	stackBasedir := filepath.Join(testDir, "test")
+	cfg.Data.StackConfig.HashedInstallPrefixes = true
+	writeStackFiles(t, cfg, testDir)
+	installDirs := make(map[string]string)
+	for i := 0; i < 2; i++ {
+		if i == 1 {
+			// A new configuration of comp1, and therefore of comp2, is installed next to the previous one
+			cfg.Data.StackDefinition.Components[0].ConfigureParams = "--enable-test"
+			writeStackFiles(t, cfg, testDir)
+		}
+		newCfg := &Config{DefFilePath: cfg.DefFilePath, ConfigFilePath: cfg.ConfigFilePath}
+		err := newCfg.InstallStack()
+		if err != nil {
+			t.Fatalf("unable to install the stack: %s", err)
+		}
+		for _, name := range []string{"comp1", "comp2"} {
+			installDir := newCfg.InstalledComponents[name]
+			if !hashedInstallDirRegexp.MatchString(filepath.Base(installDir)) || filepath.Dir(installDir) != filepath.Join(stackBasedir, "install") {
+				t.Fatalf("%s is not installed in a hashed installation prefix: %s", name, installDir)
+			}
+			if !util.FileExists(filepath.Join(installDir, "bin", "helloworld")) {
+				t.Fatalf("%s was not installed in %s", name, installDir)
+			}
+			if installDirs[name] == installDir {
+				t.Fatalf("the new configuration of %s is installed in the same directory as the previous one", name)
+			}
+			if i == 1 && !util.IsDir(installDirs[name]) {
+				t.Fatalf("the previous configuration of %s was removed", name)
+			}
+			installDirs[name] = installDir
+		}
+
+		if i == 1 {
+			orphans, err := newCfg.GC(true)
+			if err != nil {
+				t.Fatalf("unable to get the orphans of the stack: %s", err)
+			}
+			if len(orphans) != 2 {
+				t.Fatalf("the previous configurations of the components are not orphans: %v", orphans)
+			}
+		}
+	}
+}
+
+func TestValidateModules(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", ConfigureDependency: "comp1"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	// Module command only supporting setenv and prepend-path
+	moduleCmd := filepath.Join(testDir, "modulecmd")
+	moduleCmdScript := `#!/bin/sh
+file="$MODULEPATH/$3"
+head -n 1 "$file" | grep -q '^#%Module' || { echo "ERROR: $file is not a modulefile" >&2; exit 1; }
+awk '$1 == "setenv" { printf "export %s=\"%s\";\n", $2, $3 } $1 == "prepend-path" { printf "export %s=\"%s${%s:+:$%s}\";\n", $2, $3, $2, $2 }' "$file"
+`
+	err = ioutil.WriteFile(moduleCmd, []byte(moduleCmdScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", moduleCmd, err)
+	}
+	cfg.Data.StackConfig.ModuleCmd = moduleCmd
+	cfg.Data.StackConfig.ValidateModulefiles = true
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate valid modulefiles: %s", err)
+	}
+
+	modulefileDir := filepath.Join(testDir, "test", "modulefiles")
+	err = ioutil.WriteFile(filepath.Join(modulefileDir, "comp2"), []byte("broken"), 0644)
+	if err != nil {
+		t.Fatalf("unable to update the modulefile of comp2: %s", err)
+	}
+	problems, err := cfg.ValidateModules("", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to validate the modulefiles: %s", err)
+	}
+	if len(problems) != 1 || problems[0].Component != "comp2" || !strings.Contains(problems[0].Problem, "not a modulefile") {
+		t.Fatalf("the syntax error in the modulefile of comp2 was not reported: %v", problems)
+	}
+
+	// The modulefiles do not define the environment generated with a prefix
+	problems, err = cfg.ValidateModules("HPCX_", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to validate the modulefiles: %s", err)
+	}
+	if len(problems) != 3 || problems[0].Component != "comp1" || !strings.Contains(problems[1].Problem, "HPCX_COMP1_DIR") {
+		t.Fatalf("the unexpected environment of comp1 was not reported: %v", problems)
+	}
+}
+
+func TestGC(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	// comp2 is removed from the definition of the stack
+	cfg.Data.StackDefinition.Components = cfg.Data.StackDefinition.Components[:1]
+	orphanInstallDir := filepath.Join(cfg.getStackBasedir(), "install", "comp2")
+	orphans, err := cfg.GC(true)
+	if err != nil {
+		t.Fatalf("GC failed: %s", err)
+	}
+	found := false
+	for _, orphan := range orphans {
+		if strings.Contains(orphan, "comp1") {
+			t.Fatalf("%s is not an orphan", orphan)
+		}
+		if orphan == orphanInstallDir {
+			found = true
+		}
+	}
+	if !found || !util.PathExists(orphanInstallDir) {
+		t.Fatalf("dry run did not list %s or removed it: %s", orphanInstallDir, orphans)
+	}
+
+	_, err = cfg.GC(false)
+	if err != nil {
+		t.Fatalf("GC failed: %s", err)
+	}
+	if util.PathExists(orphanInstallDir) {
+		t.Fatalf("%s was not removed", orphanInstallDir)
+	}
+	if !util.PathExists(filepath.Join(cfg.getStackBasedir(), "install", "comp1")) {
+		t.Fatalf("installation of comp1 was removed")
+	}
+}
+
+func TestVersionedInstallDir(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "1.0"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	oldInstallDir := filepath.Join(cfg.getStackBasedir(), "install", "comp1", "1.0")
+	if cfg.InstalledComponents["comp1"] != oldInstallDir || !util.FileExists(filepath.Join(oldInstallDir, "bin", "helloworld")) {
+		t.Fatalf("comp1 is not installed in %s: %s", oldInstallDir, cfg.InstalledComponents["comp1"])
+	}
+	result, err := cfg.UpdateRefs("@ref:comp1_install_dir@")
+	if err != nil || result != oldInstallDir {
+		t.Fatalf("reference resolved to %s instead of %s (%v)", result, oldInstallDir, err)
+	}
+
+	// Upgrade the component, both versions coexist until the old one is garbage collected
+	cfg.Data.StackDefinition.Components[0].Version = "2.0"
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to upgrade the stack: %s", err)
+	}
+	newInstallDir := filepath.Join(cfg.getStackBasedir(), "install", "comp1", "2.0")
+	if !util.FileExists(filepath.Join(newInstallDir, "bin", "helloworld")) || !util.PathExists(oldInstallDir) {
+		t.Fatalf("versions 1.0 and 2.0 of comp1 do not coexist")
+	}
+	_, err = cfg.GC(false)
+	if err != nil {
+		t.Fatalf("GC failed: %s", err)
+	}
+	if util.PathExists(oldInstallDir) || !util.PathExists(newInstallDir) {
+		t.Fatalf("previous version of comp1 was not garbage collected")
+	}
+}
+
+func TestResolveVersionFromState(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "latest"}})
+	defer os.RemoveAll(testDir)
+
+	// The version was resolved during a previous installation
+	s := &State{Components: map[string]*ComponentState{
+		"comp1": {VersionConstraint: "latest", Version: "1.5", Tag: "v1.5"},
+	}}
+	err := os.MkdirAll(cfg.getStackBasedir(), 0755)
+	if err != nil {
+		t.Fatalf("unable to create the stack directory: %s", err)
+	}
+	err = s.save(cfg.getStackBasedir())
+	if err != nil {
+		t.Fatalf("unable to save the state: %s", err)
+	}
+
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	if cfg.Data.StackDefinition.Components[0].Version != "1.5" {
+		t.Fatalf("version was resolved to %s instead of 1.5", cfg.Data.StackDefinition.Components[0].Version)
+	}
+	if !util.FileExists(filepath.Join(cfg.getStackBasedir(), "install", "comp1", "1.5", "bin", "helloworld")) {
+		t.Fatalf("comp1 was not installed with the resolved version")
+	}
+}
+
+func TestSwitchTags(t *testing.T) {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not available, skipping test")
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	// Two releases of the software, tagged in the same repository
+	upstreamDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(upstreamDir)
+	repoDir := filepath.Join(upstreamDir, "hello.git")
+	for _, cmdline := range [][]string{
+		{"cp", "-r", srcDir, repoDir},
+		{gitBin, "-C", repoDir, "init", "-q"},
+		{gitBin, "-C", repoDir, "add", "configure"},
+		{gitBin, "-C", repoDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+		{gitBin, "-C", repoDir, "tag", "v1.0"},
+		{gitBin, "-C", repoDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "release"},
+		{gitBin, "-C", repoDir, "tag", "v1.1"},
+	} {
+		out, err := exec.Command(cmdline[0], cmdline[1:]...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s failed: %s - %s", strings.Join(cmdline, " "), err, out)
+		}
+	}
+	revisions := make(map[string]string)
+	for _, tag := range []string{"v1.0", "v1.1"} {
+		out, err := exec.Command(gitBin, "-C", repoDir, "rev-parse", tag+"^{commit}").Output()
+		if err != nil {
+			t.Fatalf("unable to get the revision of %s: %s", tag, err)
+		}
+		revisions[tag] = strings.TrimSpace(string(out))
+	}
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", URL: repoDir, SourceType: "git", PruneBuildTree: true}})
+	defer os.RemoveAll(testDir)
+	for _, tag := range []string{"v1.0", "v1.1"} {
+		// The user moves to the next release of the component
+		cfg.Data.StackDefinition.Components[0].Branch = tag
+		cfg.Data.StackDefinition.Components[0].Version = strings.TrimPrefix(tag, "v")
+		err = cfg.InstallStack()
+		if err != nil {
+			t.Fatalf("unable to install the stack with %s: %s", tag, err)
+		}
+		state, err := loadState(cfg.getStackBasedir())
+		if err != nil {
+			t.Fatalf("unable to load the state of the stack: %s", err)
+		}
+		compState := state.Components["comp1"]
+		if compState == nil || compState.Branch != tag || compState.SourceVersion != strings.TrimPrefix(tag, "v") || compState.Revision != revisions[tag] {
+			t.Fatalf("the revision of %s is not recorded: %+v", tag, compState)
+		}
+	}
+}
+
+func TestProvenance(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "1.0", ConfigureParams: "--enable-foo"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	p, err := cfg.GetProvenance("comp1")
+	if err != nil {
+		t.Fatalf("unable to get the provenance of comp1: %s", err)
+	}
+	if p.Name != "comp1" || p.Version != "1.0" || p.URL == "" || p.Host == "" || p.BuildSystem != "autotools" {
+		t.Fatalf("invalid provenance: %+v", p)
+	}
+	if len(p.ConfigureArgs) != 1 || p.ConfigureArgs[0] != "--enable-foo" {
+		t.Fatalf("invalid configure arguments: %v", p.ConfigureArgs)
+	}
+	if p.Start.IsZero() || p.End.Before(p.Start) {
+		t.Fatalf("invalid timestamps: %s - %s", p.Start, p.End)
+	}
+	if _, err := cfg.GetProvenance("comp2"); err == nil {
+		t.Fatalf("provenance of an unknown component did not fail")
+	}
+}
+
+// writeTestKeys generates an Ed25519 key pair in dir and returns the paths to the private and public keys
+func writeTestKeys(t *testing.T, dir string) (string, string) {
+	publicKey, privateKey, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatalf("unable to generate a key: %s", err)
+	}
+	privateDER, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("unable to encode the private key: %s", err)
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		t.Fatalf("unable to encode the public key: %s", err)
+	}
+	privateKeyPath := filepath.Join(dir, "key.pem")
+	publicKeyPath := filepath.Join(dir, "key.pub")
+	err = ioutil.WriteFile(privateKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privateDER}), 0600)
+	if err != nil {
+		t.Fatalf("unable to write the private key: %s", err)
+	}
+	err = ioutil.WriteFile(publicKeyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER}), 0644)
+	if err != nil {
+		t.Fatalf("unable to write the public key: %s", err)
+	}
+	return privateKeyPath, publicKeyPath
+}
+
+func TestAttestations(t *testing.T) {
+	if _, err := exec.LookPath("tar"); err != nil {
+		t.Skip("tar not available, skipping test")
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "1.0"}})
+	defer os.RemoveAll(testDir)
+	privateKeyPath, publicKeyPath := writeTestKeys(t, testDir)
+	cfg.Data.StackConfig.AttestationKey = privateKeyPath
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	compInstallDir := cfg.InstalledComponents["comp1"]
+	statement, err := VerifyAttestation(filepath.Join(compInstallDir, AttestationFilename), publicKeyPath, compInstallDir)
+	if err != nil {
+		t.Fatalf("unable to verify the attestation of comp1: %s", err)
+	}
+	if statement.PredicateType != SLSAProvenanceType || len(statement.Predicate.Materials) != 1 || statement.Predicate.Materials[0].URI != "file://"+srcDir {
+		t.Fatalf("invalid attestation: %+v", statement)
+	}
+	foundBinary := false
+	for _, subject := range statement.Subject {
+		if subject.Name == filepath.Join("bin", "helloworld") {
+			foundBinary = true
+		}
+	}
+	if !foundBinary {
+		t.Fatalf("the binary of comp1 is not a subject of its attestation: %+v", statement.Subject)
+	}
+
+	// The attestation of the stack export covers the tarball
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.Export()
+	if err != nil {
+		t.Fatalf("unable to export the stack: %s", err)
+	}
+	tarballPath := filepath.Join(cfg.getStackBasedir(), "test.tar.bz2")
+	_, err = VerifyAttestation(tarballPath+AttestationSuffix, publicKeyPath, cfg.getStackBasedir())
+	if err != nil {
+		t.Fatalf("unable to verify the attestation of the export: %s", err)
+	}
+
+	// Tampered binaries and other keys are detected
+	err = ioutil.WriteFile(filepath.Join(compInstallDir, "bin", "helloworld"), []byte("tampered"), 0755)
+	if err != nil {
+		t.Fatalf("unable to modify the binary of comp1: %s", err)
+	}
+	_, err = VerifyAttestation(filepath.Join(compInstallDir, AttestationFilename), publicKeyPath, compInstallDir)
+	if err == nil {
+		t.Fatalf("tampered binary was not detected")
+	}
+	_, otherPublicKeyPath := writeTestKeys(t, srcDir)
+	_, err = VerifyAttestation(tarballPath+AttestationSuffix, otherPublicKeyPath, cfg.getStackBasedir())
+	if err == nil {
+		t.Fatalf("attestation was verified with the wrong key")
+	}
+}
+
+// setupGPG creates a throwaway GPG home in dir, with a stack@example.com key without passphrase that can sign
+// and encrypt. The path to a keyring with the public key and a function restoring the environment are returned.
+func setupGPG(t *testing.T, dir string) (string, func()) {
+	gpgHome := filepath.Join(dir, "gnupg")
+	err := os.MkdirAll(gpgHome, 0700)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", gpgHome, err)
+	}
+	savedGPGHome, gpgHomeSet := os.LookupEnv("GNUPGHOME")
+	os.Setenv("GNUPGHOME", gpgHome)
+	cleanup := func() {
+		exec.Command("gpgconf", "--kill", "gpg-agent").Run()
+		if gpgHomeSet {
+			os.Setenv("GNUPGHOME", savedGPGHome)
+		} else {
+			os.Unsetenv("GNUPGHOME")
+		}
+	}
+	out, err := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "stack@example.com", "default", "default", "never").CombinedOutput()
+	if err != nil {
+		cleanup()
+		t.Skipf("unable to generate a GPG key, skipping test: %s - %s", err, out)
+	}
+	keyring := filepath.Join(dir, "pubring.gpg")
+	out, err = exec.Command("gpg", "--batch", "--output", keyring, "--export", "stack@example.com").CombinedOutput()
+	if err != nil {
+		cleanup()
+		t.Fatalf("unable to export the public key: %s - %s", err, out)
+	}
+	return keyring, cleanup
+}
+
+func TestSignedExport(t *testing.T) {
+	for _, bin := range []string{"tar", "gpg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not available, skipping test", bin)
+		}
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(testDir)
+
+	keyring, cleanup := setupGPG(t, testDir)
+	defer cleanup()
+
+	cfg.Data.StackConfig.ExportSignature = &ExportSignature{Method: SignatureGPG, Key: "stack@example.com"}
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.Export()
+	if err != nil {
+		t.Fatalf("unable to export the stack: %s", err)
+	}
+	tarballPath := filepath.Join(cfg.getStackBasedir(), "test.tar.bz2")
+	if !util.FileExists(tarballPath + ".asc") {
+		t.Fatalf("the exported stack was not signed")
+	}
+
+	importCfg, importTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(importTestDir)
+	importCfg.Data.StackConfig.ExportSignature = &ExportSignature{Method: SignatureGPG, PublicKey: keyring}
+	writeStackFiles(t, importCfg, importTestDir)
+	err = importCfg.Import(tarballPath)
+	if err != nil {
+		t.Fatalf("unable to import the signed stack: %s", err)
+	}
+
+	// Tampered and unsigned tarballs are not extracted
+	err = os.RemoveAll(importCfg.getStackBasedir())
+	if err != nil {
+		t.Fatalf("unable to remove the imported stack: %s", err)
+	}
+	f, err := os.OpenFile(tarballPath, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("unable to open %s: %s", tarballPath, err)
+	}
+	f.Write([]byte("tampered"))
+	f.Close()
+	err = importCfg.Import(tarballPath)
+	if err == nil {
+		t.Fatalf("tampered stack was imported")
+	}
+	err = os.Remove(tarballPath + ".asc")
+	if err != nil {
+		t.Fatalf("unable to remove the signature: %s", err)
+	}
+	err = importCfg.Import(tarballPath)
+	if err == nil {
+		t.Fatalf("unsigned stack was imported")
+	}
+	if util.PathExists(filepath.Join(importCfg.getStackBasedir(), "install")) {
+		t.Fatalf("stack was extracted despite an invalid signature")
+	}
+}
+
+func TestEncryptedExport(t *testing.T) {
+	for _, bin := range []string{"tar", "gpg"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not available, skipping test", bin)
+		}
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(testDir)
+	keyring, cleanup := setupGPG(t, testDir)
+	defer cleanup()
+
+	cfg.Data.StackDefinition.Type = "private"
+	cfg.Data.StackConfig.ExportEncryption = &ExportEncryption{Method: EncryptionGPG, Recipients: []string{"stack@example.com"}}
+	cfg.Data.StackConfig.ExportSignature = &ExportSignature{Method: SignatureGPG, Key: "stack@example.com"}
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.Export()
+	if err != nil {
+		t.Fatalf("unable to export the stack: %s", err)
+	}
+	encryptedPath := filepath.Join(cfg.getStackBasedir(), "test.tar.bz2.gpg")
+	if !util.FileExists(encryptedPath) || !util.FileExists(encryptedPath+".asc") {
+		t.Fatalf("the exported stack was not encrypted and signed")
+	}
+	if util.FileExists(filepath.Join(cfg.getStackBasedir(), "test.tar.bz2")) {
+		t.Fatalf("the exported stack is available in clear")
+	}
+
+	importCfg, importTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(importTestDir)
+	importCfg.Data.StackConfig.ExportSignature = &ExportSignature{Method: SignatureGPG, PublicKey: keyring}
+	writeStackFiles(t, importCfg, importTestDir)
+	err = importCfg.Import(encryptedPath)
+	if err != nil {
+		t.Fatalf("unable to import the encrypted stack: %s", err)
+	}
+	if !util.FileExists(filepath.Join(importCfg.getStackBasedir(), "install", "comp1", "bin", "helloworld")) {
+		t.Fatalf("comp1 was not imported")
+	}
+	entries, err := ioutil.ReadDir(importCfg.getStackBasedir())
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", importCfg.getStackBasedir(), err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "import-") {
+			t.Fatalf("the decrypted tarball was not removed: %s", entry.Name())
+		}
+	}
+
+	// age tarballs cannot be decrypted without an identity
+	_, err = decrypt(filepath.Join(testDir, "test.tar.bz2.age"), &ExportEncryption{Method: EncryptionAge}, testDir)
+	if err == nil {
+		t.Fatalf("decryption without identity did not fail")
+	}
+}
+
+func TestMachORelocation(t *testing.T) {
+	id := parseOtoolID("/old/stack/install/ucx/lib/libucp.0.dylib:\n/old/stack/install/ucx/lib/libucp.0.dylib\n")
+	if id != "/old/stack/install/ucx/lib/libucp.0.dylib" {
+		t.Fatalf("invalid install name: %s", id)
+	}
+	if parseOtoolID("/old/stack/install/ucx/bin/ucx_info:\n") != "" {
+		t.Fatalf("binaries do not have an install name")
+	}
+	libs := parseOtoolLibs("/old/stack/install/ucx/lib/libucp.0.dylib:\n" +
+		"\t/old/stack/install/ucx/lib/libucp.0.dylib (compatibility version 1.0.0, current version 1.0.0)\n" +
+		"\t/old/stack/install/ucx/lib/libucs.0.dylib (compatibility version 1.0.0, current version 1.0.0)\n" +
+		"\t@rpath/libhwloc.15.dylib (compatibility version 1.0.0, current version 1.0.0)\n" +
+		"\t/usr/lib/libSystem.B.dylib (compatibility version 1.0.0, current version 1319.0.0)\n")
+	if len(libs) != 4 || libs[1] != "/old/stack/install/ucx/lib/libucs.0.dylib" {
+		t.Fatalf("invalid dependencies: %v", libs)
+	}
+	rpaths := parseOtoolRpaths("Load command 12\n          cmd LC_LOAD_DYLIB\n      cmdsize 56\n         name /usr/lib/libSystem.B.dylib (offset 24)\n" +
+		"Load command 13\n          cmd LC_RPATH\n      cmdsize 48\n         path /old/stack/install/hwloc/lib (offset 12)\n" +
+		"Load command 14\n          cmd LC_RPATH\n      cmdsize 32\n         path @loader_path/../lib (offset 12)\n")
+	if len(rpaths) != 2 || rpaths[0] != "/old/stack/install/hwloc/lib" {
+		t.Fatalf("invalid run-time search paths: %v", rpaths)
+	}
+
+	args := getMachORelocationArgs(id, libs, rpaths, "/old/stack", "/new/stack")
+	expected := []string{
+		"-id", "/new/stack/install/ucx/lib/libucp.0.dylib",
+		"-change", "/old/stack/install/ucx/lib/libucs.0.dylib", "/new/stack/install/ucx/lib/libucs.0.dylib",
+		"-rpath", "/old/stack/install/hwloc/lib", "/new/stack/install/hwloc/lib",
+	}
+	if strings.Join(args, " ") != strings.Join(expected, " ") {
+		t.Fatalf("invalid install_name_tool arguments: %v", args)
+	}
+	if len(getMachORelocationArgs(id, libs, rpaths, "/old/stack2", "/new/stack")) != 0 {
+		t.Fatalf("paths of another stack were relocated")
+	}
+}
+
+func TestOptimizationProfile(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", OptimizationProfile: buildenv.ProfileGeneric}, {Name: "comp3", BuildType: buildenv.BuildTypeDebug}})
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackConfig.OptimizationProfile = buildenv.ProfileNative
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	for name, profile := range map[string]string{"comp1": buildenv.ProfileNative, "comp2": buildenv.ProfileGeneric} {
+		p, err := cfg.GetProvenance(name)
+		if err != nil {
+			t.Fatalf("unable to get the provenance of %s: %s", name, err)
+		}
+		flags, _ := buildenv.GetOptimizationFlags(profile)
+		foundFlags := false
+		for _, e := range p.BuildEnv {
+			if e == "CFLAGS="+flags {
+				foundFlags = true
+			}
+		}
+		if p.OptimizationProfile != profile || !foundFlags {
+			t.Fatalf("%s was not built with the %s profile: %+v", name, profile, p)
+		}
+	}
+
+	// The optimization level of the build type takes precedence over the one of the profile
+	p, err := cfg.GetProvenance("comp3")
+	if err != nil {
+		t.Fatalf("unable to get the provenance of comp3: %s", err)
+	}
+	flags, _ := buildenv.GetOptimizationFlags(buildenv.ProfileNative)
+	foundFlags := false
+	for _, e := range p.BuildEnv {
+		if e == "CFLAGS="+flags+" -O0 -g" {
+			foundFlags = true
+		}
+	}
+	if p.BuildType != buildenv.BuildTypeDebug || !foundFlags {
+		t.Fatalf("comp3 was not built with the debug build type: %+v", p)
+	}
+
+	cfg.Data.StackDefinition.Components[0].OptimizationProfile = "unknown"
+	err = cfg.installComponent(&cfg.Data.StackDefinition.Components[0], make(map[string]string), make(map[string]string))
+	if err == nil {
+		t.Fatalf("installation with an unknown optimization profile did not fail")
+	}
+}
+
+func TestHermeticEnv(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	os.Setenv("GSB_LEAKY_VAR", "1")
+	defer os.Unsetenv("GSB_LEAKY_VAR")
+	cfg, testDir := newLocalStack(t, srcDir, []Component{
+		{Name: "comp1"},
+		{Name: "comp2", BuildEnv: `FOO=bar CFLAGS="-O2 -g" PATH=/opt/tools/bin:$PATH`, PostInstallCmd: "env > env.txt"},
+	})
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackConfig.Hermetic = true
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack in hermetic mode: %s", err)
+	}
+
+	content, err := ioutil.ReadFile(filepath.Join(cfg.InstalledComponents["comp2"], "env.txt"))
+	if err != nil {
+		t.Fatalf("unable to read the build environment of comp2: %s", err)
+	}
+	env := string(content)
+	expectedPath := "PATH=/opt/tools/bin:" + filepath.Join(cfg.InstalledComponents["comp1"], "bin") + ":" + hermeticPath + "\n"
+	if !strings.Contains(env, "FOO=bar\n") || !strings.Contains(env, "CFLAGS=-O2 -g\n") || !strings.Contains(env, expectedPath) {
+		t.Fatalf("invalid hermetic environment, PATH should be %s:\n%s", expectedPath, env)
+	}
+	if strings.Contains(env, "GSB_LEAKY_VAR") {
+		t.Fatalf("hermetic environment inherited a variable of the caller:\n%s", env)
+	}
+
+	// Values with spaces must be quoted
+	cfg.Data.StackDefinition.Components[1].BuildEnv = "CFLAGS=-O2 -g"
+	err = cfg.installComponent(&cfg.Data.StackDefinition.Components[1], make(map[string]string), make(map[string]string))
+	if err == nil {
+		t.Fatalf("installation with an invalid build environment did not fail")
+	}
+
+	// Variables can explicitly be inherited
+	cfg.Data.StackConfig.HermeticEnv = []string{"GSB_LEAKY_VAR"}
+	found := false
+	for _, e := range cfg.getHermeticEnv(nil) {
+		if e == "GSB_LEAKY_VAR=1" {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("variable listed in hermetic_env was not inherited")
+	}
+}
+
+func TestBundle(t *testing.T) {
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not available, skipping test")
+	}
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	// The same software is also available as a Git repository and a tarball
+	upstreamDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(upstreamDir)
+	repoDir := filepath.Join(upstreamDir, "hello.git")
+	tarballPath := filepath.Join(upstreamDir, "hello-1.0.tar.gz")
+	for _, cmdline := range [][]string{
+		{"cp", "-r", srcDir, repoDir},
+		{gitBin, "-C", repoDir, "init", "-q"},
+		{gitBin, "-C", repoDir, "add", "configure"},
+		{gitBin, "-C", repoDir, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+		{"tar", "-czf", tarballPath, "-C", filepath.Dir(srcDir), filepath.Base(srcDir)},
+	} {
+		out, err := exec.Command(cmdline[0], cmdline[1:]...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("%s failed: %s - %s", strings.Join(cmdline, " "), err, out)
+		}
+	}
+
+	components := []Component{
+		{Name: "comp1"},
+		{Name: "comp2", URL: repoDir},
+		{Name: "comp3", URL: "file://" + tarballPath},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	writeStackFiles(t, cfg, testDir)
+	bundlePath := filepath.Join(testDir, "test-bundle.tar.bz2")
+	err = cfg.CreateBundle(bundlePath)
+	if err != nil {
+		t.Fatalf("CreateBundle() failed: %s", err)
+	}
+
+	// The stack is then installed on a system without access to the original source code
+	os.RemoveAll(upstreamDir)
+	os.RemoveAll(srcDir)
+	offlineCfg, offlineInstallDir := newLocalStack(t, srcDir, nil)
+	defer os.RemoveAll(offlineInstallDir)
+	writeStackFiles(t, offlineCfg, offlineInstallDir)
+	offlineCfg.Loaded = false
+	offlineCfg.DefFilePath = ""
+	err = offlineCfg.UseBundle(bundlePath)
+	if err != nil {
+		t.Fatalf("UseBundle() failed: %s", err)
+	}
+	err = offlineCfg.InstallStack()
+	if err != nil {
+		t.Fatalf("offline installation failed: %s", err)
+	}
+	for _, comp := range components {
+		binPath := filepath.Join(offlineInstallDir, "test", "install", comp.Name, "bin", "helloworld")
+		if !util.FileExists(binPath) {
+			t.Fatalf("%s does not exist", binPath)
+		}
+	}
+
+	// Bundles that were modified are rejected
+	extractedDir := strings.TrimSuffix(bundlePath, ".tar.bz2")
+	err = ioutil.WriteFile(filepath.Join(extractedDir, BundleDefinitionFilename), []byte("{}"), 0644)
+	if err != nil {
+		t.Fatalf("unable to modify the bundle: %s", err)
+	}
+	err = (&Config{}).UseBundle(extractedDir)
+	if err == nil || !strings.Contains(err.Error(), "checksum mismatch for "+BundleDefinitionFilename) {
+		t.Fatalf("modified bundle was not detected: %v", err)
+	}
+}
+
+func TestLicenses(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+	licenseFiles := map[string]string{
+		"LICENSE":     "MIT License\n\nPermission is hereby granted, free of charge, to any person obtaining a copy\n",
+		"COPYING.LIB": "SPDX-License-Identifier: LGPL-2.1-or-later\n",
+		"NOTICE.txt":  "Some notice without any license\n",
+	}
+	for name, content := range licenseFiles {
+		err := ioutil.WriteFile(filepath.Join(srcDir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("unable to create %s: %s", name, err)
+		}
+	}
+
+	components := []Component{
+		{Name: "comp1"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	for name := range licenseFiles {
+		if !util.FileExists(filepath.Join(testDir, "test", LicensesDirname, "comp1", name)) {
+			t.Fatalf("%s was not collected", name)
+		}
+	}
+	if util.PathExists(filepath.Join(testDir, "test", LicensesDirname, "comp1", "configure")) {
+		t.Fatalf("a file that is not a license file was collected")
+	}
+
+	expectedLicenses := []string{"LGPL-2.1-or-later", "MIT", UnknownLicense}
+	compReport := cfg.Report.Get("comp1")
+	if compReport == nil || strings.Join(compReport.Licenses, ",") != strings.Join(expectedLicenses, ",") {
+		t.Fatalf("unexpected report for comp1: %+v", compReport)
+	}
+	if strings.Join(cfg.Report.GetLicenses(), ",") != strings.Join(expectedLicenses, ",") {
+		t.Fatalf("unexpected licenses for the stack: %s", cfg.Report.GetLicenses())
+	}
+	if !strings.Contains(cfg.Report.String(), "[licenses: LGPL-2.1-or-later, MIT, unknown]") {
+		t.Fatalf("licenses are not summarized in the report: %s", cfg.Report.String())
+	}
+
+	for content, expectedLicense := range map[string]string{
+		"                                 Apache License\n                           Version 2.0, January 2004": "Apache-2.0",
+		"GNU GENERAL PUBLIC LICENSE\n                       Version 3, 29 June 2007":                            "GPL-3.0",
+		"Redistribution and use in source and binary forms, with or without\nmodification ... Neither the name": "BSD-3-Clause",
+	} {
+		license := detectLicense(content)
+		if license != expectedLicense {
+			t.Fatalf("%s detected instead of %s", license, expectedLicense)
+		}
+	}
+}
+
+func TestComponentMetadata(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{
+			Name:        "comp1",
+			Description: "Hello \"world\" [demo]",
+			Homepage:    "https://example.com/hello",
+			License:     "MIT OR Apache-2.0",
+			Maintainer:  "HPC team <hpc@example.com>",
+		},
+		{Name: "comp2", License: "BSD-3-Clause"},
+		{Name: "comp3", Disabled: true},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	if !strings.Contains(cfg.Report.String(), "comp1: installed - Hello \"world\" [demo] [declared license: MIT OR Apache-2.0]") {
+		t.Fatalf("metadata is not included in the report: %s", cfg.Report.String())
+	}
+
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.GenerateModules("", "", ModuleFormatBoth)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), "modulefiles", "comp1"))
+	if err != nil {
+		t.Fatalf("unable to read the modulefile: %s", err)
+	}
+	for _, expected := range []string{
+		`module-whatis "Description: Hello \"world\" \[demo\]"`,
+		`module-whatis "Homepage: https://example.com/hello"`,
+		`module-whatis "Maintainer: HPC team <hpc@example.com>"`,
+	} {
+		if !strings.Contains(string(content), expected) {
+			t.Fatalf("%s not found in the modulefile:\n%s", expected, content)
+		}
+	}
+	content, err = ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), "modulefiles_lua", "comp2.lua"))
+	if err != nil {
+		t.Fatalf("unable to read the modulefile: %s", err)
+	}
+	if !strings.Contains(string(content), `whatis("License: BSD-3-Clause")`) || strings.Contains(string(content), "Description") {
+		t.Fatalf("unexpected whatis in the modulefile:\n%s", content)
+	}
+
+	sbom := cfg.getSBOM(nil)
+	if len(sbom.Components) != 2 {
+		t.Fatalf("unexpected components in the SBOM: %+v", sbom.Components)
+	}
+	if sbom.Components[0].Description != components[0].Description || sbom.Components[0].Author != components[0].Maintainer ||
+		len(sbom.Components[0].Licenses) != 1 || sbom.Components[0].Licenses[0].Expression != "MIT OR Apache-2.0" ||
+		sbom.Components[0].ExternalReferences[0].Type != "website" {
+		t.Fatalf("unexpected SBOM component: %+v", sbom.Components[0])
+	}
+	if len(sbom.Components[1].Licenses) != 1 || sbom.Components[1].Licenses[0].License.ID != "BSD-3-Clause" {
+		t.Fatalf("unexpected SBOM component: %+v", sbom.Components[1])
+	}
+
+	status, err := cfg.Status()
+	if err != nil {
+		t.Fatalf("unable to get the status of the stack: %s", err)
+	}
+	if len(status.Components) != 3 || !status.Components[0].Installed || status.Components[2].Installed || !status.Components[2].Disabled {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	if status.Components[0].Metadata != components[0].GetMetadata() {
+		t.Fatalf("unexpected metadata in the status: %+v", status.Components[0].Metadata)
+	}
+	output := status.String()
+	for _, expected := range []string{"comp3: disabled", "    Homepage: https://example.com/hello", "    License: BSD-3-Clause"} {
+		if !strings.Contains(output, expected) {
+			t.Fatalf("%s not found in the status:\n%s", expected, output)
+		}
+	}
+}
+
+func TestDiscoverStack(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "zlib", Version: "1.0", BuildEnv: `CFLAGS="-O2 -g" PATH=` + os.Getenv("PATH")},
+		{Name: "app", ConfigureDependency: "zlib", ConfigureParams: "--enable-foo"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+
+	// A component installed manually, from a tarball
+	stackBasedir := cfg.getStackBasedir()
+	err = os.MkdirAll(filepath.Join(stackBasedir, "install", "manual", "bin"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create the installation directory: %s", err)
+	}
+	tarballPath := filepath.Join(stackBasedir, "src", "manual-2.0.tar.gz")
+	err = ioutil.WriteFile(tarballPath, []byte("tarball"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create the tarball: %s", err)
+	}
+	err = os.Remove(filepath.Join(stackBasedir, StateFilename))
+	if err != nil {
+		t.Fatalf("unable to remove the state: %s", err)
+	}
+
+	defPath := filepath.Join(testDir, "discovered.json")
+	def, err := AdoptStack(stackBasedir, defPath)
+	if err != nil {
+		t.Fatalf("unable to discover the stack: %s", err)
+	}
+	if def.Name != "test" || len(def.Components) != 3 {
+		t.Fatalf("unexpected definition: %+v", def)
+	}
+	expectedOrder := []string{"zlib", "app", "manual"}
+	for idx, name := range expectedOrder {
+		if def.Components[idx].Name != name {
+			t.Fatalf("unexpected order of the components: %+v", def.Components)
+		}
+	}
+	zlib := def.Components[0]
+	if zlib.Version != "1.0" || zlib.URL != "file://"+srcDir || zlib.BuildEnv != `CFLAGS="-O2 -g"` {
+		t.Fatalf("unexpected definition of zlib: %+v", zlib)
+	}
+	app := def.Components[1]
+	if app.Version != "" || app.ConfigureDependency != "zlib" || app.ConfigureParams != "--enable-foo" {
+		t.Fatalf("unexpected definition of app: %+v", app)
+	}
+	manual := def.Components[2]
+	if manual.URL != "file://"+tarballPath {
+		t.Fatalf("unexpected definition of manual: %+v", manual)
+	}
+
+	if !util.FileExists(defPath) {
+		t.Fatalf("the definition was not written")
+	}
+	state, err := loadState(stackBasedir)
+	if err != nil {
+		t.Fatalf("unable to load the state: %s", err)
+	}
+	checksum, _ := buildenv.FileChecksum(tarballPath)
+	if state.Components["manual"] == nil || state.Components["manual"].SHA256 != checksum || state.Components["zlib"].URL != zlib.URL {
+		t.Fatalf("unexpected state: %+v", state.Components)
+	}
+}
+
+func TestRemoteDefinition(t *testing.T) {
+	cfg, testDir := newLocalStack(t, "", []Component{{Name: "comp1", Version: "1.0"}})
+	defer os.RemoveAll(testDir)
+	writeStackFiles(t, cfg, testDir)
+	defContent, err := ioutil.ReadFile(cfg.DefFilePath)
+	if err != nil {
+		t.Fatalf("unable to read the definition: %s", err)
+	}
+	defChecksum, err := buildenv.FileChecksum(cfg.DefFilePath)
+	if err != nil {
+		t.Fatalf("unable to compute the checksum of the definition: %s", err)
+	}
+	available := true
+	fileServer := http.FileServer(http.Dir(testDir))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available {
+			http.NotFound(w, r)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	remoteCfg := &Config{
+		DefFilePath:    server.URL + "/def.json",
+		DefFileSHA256:  defChecksum,
+		ConfigFilePath: server.URL + "/config.json",
+		RemoteCacheDir: filepath.Join(testDir, "cache"),
+	}
+	err = remoteCfg.Load()
+	if err != nil {
+		t.Fatalf("unable to load the remote stack: %s", err)
+	}
+	if remoteCfg.Data.StackDefinition.Components[0].Version != "1.0" {
+		t.Fatalf("unexpected definition: %+v", remoteCfg.Data.StackDefinition)
+	}
+
+	// The cached files are used when the files are not available anymore
+	available = false
+	err = remoteCfg.Load()
+	if err != nil {
+		t.Fatalf("unable to load the stack from the cache: %s", err)
+	}
+	remoteCfg.DefFileSHA256 = "1234"
+	err = remoteCfg.Load()
+	if err == nil || !strings.Contains(err.Error(), "unable to fetch") {
+		t.Fatalf("the cached definition was used with another checksum: %v", err)
+	}
+
+	// Definitions can also be in Git repositories
+	gitBin, err := exec.LookPath("git")
+	if err != nil {
+		t.Skip("git not available, skipping test")
+	}
+	repoDir := filepath.Join(testDir, "stacks.git")
+	err = os.MkdirAll(filepath.Join(repoDir, "hpc"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create the repository: %s", err)
+	}
+	err = ioutil.WriteFile(filepath.Join(repoDir, "hpc", "def.json"), defContent, 0644)
+	if err != nil {
+		t.Fatalf("unable to write the definition: %s", err)
+	}
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "hpc/def.json"},
+		{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "-m", "initial"},
+		{"tag", "v1.0"},
+	} {
+		out, err := exec.Command(gitBin, append([]string{"-C", repoDir}, args...)...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s failed: %s - %s", strings.Join(args, " "), err, out)
+		}
+	}
+	gitCfg := &Config{
+		DefFilePath:    repoDir + "#v1.0:hpc/def.json",
+		DefFileSHA256:  defChecksum,
+		ConfigFilePath: cfg.ConfigFilePath,
+		RemoteCacheDir: filepath.Join(testDir, "cache"),
+	}
+	err = gitCfg.Load()
+	if err != nil {
+		t.Fatalf("unable to load the stack from Git: %s", err)
+	}
+	if gitCfg.Data.StackDefinition.Name != "test" {
+		t.Fatalf("unexpected definition: %+v", gitCfg.Data.StackDefinition)
+	}
+	gitCfg.DefFilePath = repoDir + "#v1.0:hpc/missing.json"
+	err = gitCfg.Load()
+	if err == nil {
+		t.Fatalf("a missing file was loaded from Git")
+	}
+}
+
+func TestRemoteDefinitionDownloadSettings(t *testing.T) {
+	cfg, testDir := newLocalStack(t, "", []Component{{Name: "comp1", Version: "1.0"}})
+	defer os.RemoveAll(testDir)
+	fileServer := http.FileServer(http.Dir(testDir))
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/def.json" && r.Header.Get("PRIVATE-TOKEN") != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fileServer.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	caBundle := filepath.Join(testDir, "ca.pem")
+	err := ioutil.WriteFile(caBundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0644)
+	if err != nil {
+		t.Fatalf("unable to write %s: %s", caBundle, err)
+	}
+	// The definition is only available with the credentials of the configuration
+	cfg.Data.StackConfig.CABundle = caBundle
+	cfg.Data.StackConfig.Credentials = []buildenv.Credential{{URLPrefix: server.URL + "/", Headers: map[string]string{"PRIVATE-TOKEN": "secret"}}}
+	writeStackFiles(t, cfg, testDir)
+
+	remoteCfg := &Config{
+		DefFilePath:    server.URL + "/def.json",
+		ConfigFilePath: server.URL + "/config.json",
+		RemoteCacheDir: filepath.Join(testDir, "cache"),
+	}
+	err = remoteCfg.Load()
+	if err == nil {
+		t.Fatalf("the configuration was fetched from a server with an unknown authority")
+	}
+	remoteCfg.RemoteCABundle = caBundle
+	err = remoteCfg.Load()
+	if err != nil {
+		t.Fatalf("unable to load the remote stack: %s", err)
+	}
+	if remoteCfg.Data.StackDefinition.Components[0].Version != "1.0" {
+		t.Fatalf("unexpected definition: %+v", remoteCfg.Data.StackDefinition)
+	}
+}
+
+func TestOverlays(t *testing.T) {
+	components := []Component{
+		{Name: "comp1", URL: "https://example.com/comp1.tar.gz", ConfigureParams: "--enable-foo", MakeVars: map[string]string{"A": "1"}},
+		{Name: "comp2", URL: "https://example.com/comp2.tar.gz"},
+		{Name: "comp3", URL: "https://example.com/comp3.tar.gz"},
+	}
+	cfg, testDir := newLocalStack(t, "", components)
+	defer os.RemoveAll(testDir)
+	writeStackFiles(t, cfg, testDir)
+
+	overlays := map[string]string{
+		"site.json": `{"system": "dpu", "components": [
+			{"name": "comp1", "URL": "https://mirror.example.com/comp1.tar.gz", "append_configure_params": "--with-slurm", "make_vars": {"B": "2"}},
+			{"name": "comp2", "disabled": true},
+			{"name": "comp3", "remove": true},
+			{"name": "comp4", "URL": "file:///opt/src/comp4"}
+		]}`,
+		"user.json": `{"components": [{"name": "comp1", "append_configure_params": "--enable-debug"}]}`,
+	}
+	for name, content := range overlays {
+		path := filepath.Join(testDir, name)
+		err := ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatalf("unable to write %s: %s", path, err)
+		}
+	}
+	// The order of the overlays matters
+	cfg.OverlayFilePaths = []string{filepath.Join(testDir, "site.json"), filepath.Join(testDir, "user.json")}
+
+	err := cfg.Load()
+	if err != nil {
+		t.Fatalf("unable to load the stack: %s", err)
+	}
+	def := cfg.Data.StackDefinition
+	if def.System != "dpu" || len(def.Components) != 3 {
+		t.Fatalf("unexpected definition: %+v", def)
+	}
+	comp1 := def.Components[0]
+	if comp1.URL != "https://mirror.example.com/comp1.tar.gz" || comp1.ConfigureParams != "--enable-foo --with-slurm --enable-debug" ||
+		comp1.MakeVars["A"] != "1" || comp1.MakeVars["B"] != "2" {
+		t.Fatalf("unexpected patched component: %+v", comp1)
+	}
+	if !def.Components[1].Disabled || def.Components[1].URL != components[1].URL {
+		t.Fatalf("unexpected patched component: %+v", def.Components[1])
+	}
+	if def.Components[2].Name != "comp4" || def.Components[2].URL != "file:///opt/src/comp4" {
+		t.Fatalf("unexpected added component: %+v", def.Components[2])
+	}
+
+	err = def.ApplyOverlay([]byte(`{"components": [{"name": "comp3", "remove": true}]}`))
+	if err == nil {
+		t.Fatalf("a component that is not part of the stack was removed")
+	}
+	err = def.ApplyOverlay([]byte(`{"components": [{"URL": "https://example.com"}]}`))
+	if err == nil {
+		t.Fatalf("a component without name was added")
+	}
+}
+
+func TestGenerateView(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	// Both components provide bin/helloworld
+	viewDir := filepath.Join(testDir, "view")
+	err = cfg.GenerateView(viewDir)
+	if err == nil || !strings.Contains(err.Error(), "bin/helloworld is provided by both comp1 and comp2") {
+		t.Fatalf("conflict was not detected: %v", err)
+	}
+	if util.PathExists(viewDir) {
+		t.Fatalf("view was created despite conflicts")
+	}
+
+	err = cfg.GenerateView(viewDir, "comp1")
+	if err != nil {
+		t.Fatalf("unable to generate the view: %s", err)
+	}
+	target, err := os.Readlink(filepath.Join(viewDir, "bin", "helloworld"))
+	if err != nil || target != filepath.Join(cfg.getStackBasedir(), "install", "comp1", "bin", "helloworld") {
+		t.Fatalf("invalid symbolic link in the view: %s (%v)", target, err)
+	}
+
+	// The view is regenerated with both components once the conflict is solved
+	comp2InstallDir := filepath.Join(cfg.getStackBasedir(), "install", "comp2")
+	err = os.Rename(filepath.Join(comp2InstallDir, "bin", "helloworld"), filepath.Join(comp2InstallDir, "bin", "helloworld2"))
+	if err != nil {
+		t.Fatalf("unable to rename the binary of comp2: %s", err)
+	}
+	err = cfg.GenerateView(viewDir)
+	if err != nil {
+		t.Fatalf("unable to generate the view: %s", err)
+	}
+	for _, f := range []string{"helloworld", "helloworld2"} {
+		if !util.FileExists(filepath.Join(viewDir, "bin", f)) {
+			t.Fatalf("%s is not in the view", f)
+		}
+	}
+
+	// Directories that are not views are never overwritten
+	err = cfg.GenerateView(cfg.getStackBasedir())
+	if err == nil {
+		t.Fatalf("view overwrote the directory of the stack")
+	}
+}
+
+func TestDedup(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2"}})
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackConfig.Dedup = true
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	// Both components install the same binary
+	installDir := filepath.Join(cfg.getStackBasedir(), "install")
+	var infos []os.FileInfo
+	for _, comp := range []string{"comp1", "comp2"} {
+		info, err := os.Stat(filepath.Join(installDir, comp, "bin", "helloworld"))
+		if err != nil {
+			t.Fatalf("unable to stat the binary of %s: %s", comp, err)
+		}
+		infos = append(infos, info)
+	}
+	if !os.SameFile(infos[0], infos[1]) {
+		t.Fatalf("identical binaries were not deduplicated")
+	}
+
+	// Files are linked to the pool when there is one
+	cfg.Data.StackConfig.DedupPoolDir = filepath.Join(testDir, "pool")
+	_, err = cfg.Dedup()
+	if err != nil {
+		t.Fatalf("unable to deduplicate the stack: %s", err)
+	}
+	entries, err := ioutil.ReadDir(cfg.Data.StackConfig.DedupPoolDir)
+	if err != nil || len(entries) == 0 {
+		t.Fatalf("pool is empty (%v)", err)
+	}
+	stats, err := cfg.Dedup()
+	if err != nil {
+		t.Fatalf("unable to deduplicate the stack: %s", err)
+	}
+	if stats.Files != 0 {
+		t.Fatalf("deduplication is not idempotent: %d files deduplicated", stats.Files)
+	}
+}
+
+func TestStrip(t *testing.T) {
+	gccBin, err := exec.LookPath("gcc")
+	if err != nil {
+		t.Skip("gcc not available, skipping test")
+	}
+	for _, bin := range []string{"strip", "objcopy"} {
+		if _, err := exec.LookPath(bin); err != nil {
+			t.Skipf("%s not available, skipping test", bin)
+		}
+	}
+
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+	compInstallDir := filepath.Join(testDir, "install", "comp1")
+	err = os.MkdirAll(filepath.Join(compInstallDir, "bin"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+	srcFile := filepath.Join(testDir, "main.c")
+	err = ioutil.WriteFile(srcFile, []byte("int main(void) { return 0; }\n"), 0644)
+	if err != nil {
+		t.Fatalf("unable to create file: %s", err)
+	}
+	binPath := filepath.Join(compInstallDir, "bin", "main")
+	out, err := exec.Command(gccBin, "-g", "-o", binPath, srcFile).CombinedOutput()
+	if err != nil {
+		t.Fatalf("unable to compile: %s - %s", err, string(out))
+	}
+	if _, hasDebug := getStrippableELF(binPath); !hasDebug {
+		t.Fatalf("%s has no debug information", binPath)
+	}
+
+	err = stripInstallDir(testDir, compInstallDir, true)
+	if err != nil {
+		t.Fatalf("unable to strip the binaries: %s", err)
+	}
+	if strippable, hasDebug := getStrippableELF(binPath); !strippable || hasDebug {
+		t.Fatalf("%s was not stripped", binPath)
+	}
+	debugFile := filepath.Join(testDir, debugDirname, "comp1", "bin", "main.debug")
+	if _, hasDebug := getStrippableELF(debugFile); !hasDebug {
+		t.Fatalf("debug information was not saved in %s", debugFile)
+	}
+	out, err = exec.Command(binPath).CombinedOutput()
+	if err != nil {
+		t.Fatalf("stripped binary does not run: %s - %s", err, string(out))
+	}
+}
+
+func TestSanitizers(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2"}})
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackConfig.Sanitizers = []string{buildenv.SanitizerUndefined}
+	cfg.Data.StackConfig.SanitizedComponents = []string{"comp1"}
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	for name, sanitized := range map[string]bool{"comp1": true, "comp2": false} {
+		p, err := cfg.GetProvenance(name)
+		if err != nil {
+			t.Fatalf("unable to get the provenance of %s: %s", name, err)
+		}
+		if (len(p.Sanitizers) == 1) != sanitized {
+			t.Fatalf("invalid sanitizers for %s: %v", name, p.Sanitizers)
+		}
+	}
+
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	for name, sanitized := range map[string]bool{"comp1": true, "comp2": false} {
+		content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), "modulefiles", name))
+		if err != nil {
+			t.Fatalf("unable to read the modulefile of %s: %s", name, err)
+		}
+		if strings.Contains(string(content), "setenv UBSAN_OPTIONS ") != sanitized {
+			t.Fatalf("invalid runtime options in the modulefile of %s:\n%s", name, content)
+		}
+	}
+
+	cfg.Data.StackConfig.Sanitizers = []string{buildenv.SanitizerAddress, buildenv.SanitizerThread}
+	err = cfg.installComponent(&cfg.Data.StackDefinition.Components[0], make(map[string]string), make(map[string]string))
+	if err == nil {
+		t.Fatalf("installation with incompatible sanitizers did not fail")
+	}
+}
+
+func TestPresets(t *testing.T) {
+	def := &StackDef{
+		Components: []Component{
+			{Name: "hwloc-2.9", Preset: "hwloc"},
+			{Name: "libevent"},
+			{Name: "pmix", Preset: "pmix", ConfigureDependency: "libevent"},
+			{Name: "ompi", Preset: "openmpi", ConfigureParams: "--with-slurm"},
+			{Name: "ucx", Preset: "ucx"},
+			{Name: "nccl", Preset: "nccl", Version: "2.18"},
+		},
+	}
+	err := def.ApplyPresets()
+	if err != nil {
+		t.Fatalf("unable to apply the presets: %s", err)
+	}
+	// Applying presets again has no effect
+	err = def.ApplyPresets()
+	if err != nil {
+		t.Fatalf("unable to apply the presets: %s", err)
+	}
+
+	hwloc := def.Components[0]
+	if hwloc.ConfigId != "hwloc" || hwloc.License == "" || !strings.HasPrefix(hwloc.ConfigureParams, "--disable-cairo") {
+		t.Fatalf("preset was not applied to hwloc: %+v", hwloc)
+	}
+	pmix := def.Components[2]
+	if pmix.ConfigureDependency != "libevent,hwloc-2.9" {
+		t.Fatalf("invalid dependencies of pmix: %s", pmix.ConfigureDependency)
+	}
+	// ucx comes after ompi so it is not a dependency
+	ompi := def.Components[3]
+	if ompi.ConfigureDependency != "hwloc-2.9,libevent,pmix" {
+		t.Fatalf("invalid dependencies of ompi: %s", ompi.ConfigureDependency)
+	}
+	if ompi.ConfigureParams != presets["openmpi"].ConfigureParams+" --with-slurm" {
+		t.Fatalf("invalid configure parameters of ompi: %s", ompi.ConfigureParams)
+	}
+	nccl := def.Components[5]
+	if nccl.BuildSystem != "make" || nccl.MakeVars["PREFIX"] != "@ref:stack_dir@/install/nccl/2.18" {
+		t.Fatalf("preset was not applied to nccl: %+v", nccl)
+	}
+
+	def = &StackDef{Components: []Component{{Name: "comp1", Preset: "unknown"}}}
+	err = def.ApplyPresets()
+	if err == nil {
+		t.Fatalf("unknown preset was accepted")
+	}
+}
+
+func TestDependencyFlags(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "comp1"},
+		{Name: "comp2", ConfigureDependency: "comp1", DependencyFlags: true},
+		{Name: "comp3", ConfigureDependency: "comp1"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	expected := "CMAKE_PREFIX_PATH=" + filepath.Join(cfg.getStackBasedir(), "install", "comp1")
+	for name, withFlags := range map[string]bool{"comp2": true, "comp3": false} {
+		p, err := cfg.GetProvenance(name)
+		if err != nil {
+			t.Fatalf("unable to get the provenance of %s: %s", name, err)
+		}
+		found := false
+		for _, e := range p.BuildEnv {
+			if strings.HasPrefix(e, expected) {
+				found = true
+			}
+		}
+		if found != withFlags {
+			t.Fatalf("invalid build environment of %s: %v", name, p.BuildEnv)
+		}
+	}
+}
+
+func TestExternalComponent(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	externalDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(externalDir)
+	err = os.MkdirAll(filepath.Join(externalDir, "bin"), 0755)
+	if err != nil {
+		t.Fatalf("unable to create directory: %s", err)
+	}
+
+	components := []Component{
+		{Name: "cuda", External: externalDir, ConfigId: "cuda"},
+		{Name: "comp1", ConfigureDependency: "cuda", ConfigureParams: "--with-cuda-bin=@ref:cuda_bin_dir@"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackDefinition.Components[0].URL = ""
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	if cfg.Report.Components[0].Status != StatusExternal || cfg.Report.Components[1].Status != StatusInstalled {
+		t.Fatalf("invalid report: %+v", cfg.Report.Components)
+	}
+	if util.PathExists(filepath.Join(cfg.getStackBasedir(), "install", "cuda")) {
+		t.Fatalf("external component was installed in the stack")
+	}
+	p, err := cfg.GetProvenance("comp1")
+	if err != nil {
+		t.Fatalf("unable to get the provenance of comp1: %s", err)
+	}
+	expectedArgs := []string{"--with-cuda=" + externalDir, "--with-cuda-bin=" + filepath.Join(externalDir, "bin")}
+	if strings.Join(p.ConfigureArgs, " ") != strings.Join(expectedArgs, " ") {
+		t.Fatalf("invalid configure arguments: %v", p.ConfigureArgs)
+	}
+
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(cfg.getStackBasedir(), "modulefiles", "cuda"))
+	if err != nil {
+		t.Fatalf("unable to read the modulefile of cuda: %s", err)
+	}
+	if !strings.Contains(string(content), filepath.Join(externalDir, "bin")) {
+		t.Fatalf("modulefile of cuda does not refer to the external installation:\n%s", content)
+	}
+
+	cfg.Data.StackDefinition.Components[0].External = filepath.Join(externalDir, "does_not_exist")
+	err = cfg.InstallComponent("cuda")
+	if err == nil {
+		t.Fatalf("installation of a missing external component did not fail")
+	}
+}
+
+func TestVirtualPackages(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	components := []Component{
+		{Name: "openmpi", Provides: []string{"mpi"}},
+		{Name: "mvapich", Provides: []string{"mpi"}},
+		{Name: "app", ConfigureDependency: "mpi", ConfigureParams: "--mpi=@ref:mpi_install_dir@"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackConfig.Providers = map[string]string{"mpi": "mvapich"}
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	if cfg.Report.Components[0].Status != StatusDisabled || cfg.Report.Components[1].Status != StatusInstalled {
+		t.Fatalf("invalid report: %+v", cfg.Report.Components)
+	}
+	if cfg.GetProvider("mpi") != "mvapich" || cfg.Data.StackDefinition.Components[2].ConfigureDependency != "mvapich" {
+		t.Fatalf("mpi was not resolved to mvapich")
+	}
+	p, err := cfg.GetProvenance("app")
+	if err != nil {
+		t.Fatalf("unable to get the provenance of app: %s", err)
+	}
+	mvapichInstallDir := filepath.Join(cfg.getStackBasedir(), "install", "mvapich")
+	if strings.Join(p.ConfigureArgs, " ") != "--with-mvapich="+mvapichInstallDir+" --mpi="+mvapichInstallDir {
+		t.Fatalf("invalid configure arguments: %v", p.ConfigureArgs)
+	}
+
+	// The first provider is used by default
+	cfg, testDir2 := newLocalStack(t, srcDir, []Component{{Name: "openmpi", Provides: []string{"mpi"}}, {Name: "mvapich", Provides: []string{"mpi"}}})
+	defer os.RemoveAll(testDir2)
+	err = cfg.resolveProviders()
+	if err != nil || cfg.GetProvider("mpi") != "openmpi" || !cfg.Data.StackDefinition.Components[1].Disabled {
+		t.Fatalf("openmpi was not selected by default (%v)", err)
+	}
+
+	invalidSelections := []map[string]string{
+		{"mpi": "app"},
+		{"blas": "openblas"},
+	}
+	for _, selection := range invalidSelections {
+		cfg, testDir3 := newLocalStack(t, srcDir, []Component{{Name: "openmpi", Provides: []string{"mpi"}}, {Name: "app"}})
+		defer os.RemoveAll(testDir3)
+		cfg.Data.StackConfig.Providers = selection
+		err = cfg.resolveProviders()
+		if err == nil {
+			t.Fatalf("invalid provider selection %v was accepted", selection)
+		}
+	}
+}
+
+func TestVariants(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	variantFlags := map[string]string{
+		"cuda=on":  "--with-cuda=@ref:stack_dir@/cuda",
+		"cuda=off": "--without-cuda",
+		"mt=on":    "--enable-mt",
+	}
+	components := []Component{{Name: "comp1", VariantFlags: variantFlags, Variants: map[string]string{"mt": "on", "cuda": "on"}}}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	p, err := cfg.GetProvenance("comp1")
+	if err != nil {
+		t.Fatalf("unable to get the provenance of comp1: %s", err)
+	}
+	expectedArgs := "--with-cuda=" + cfg.getStackBasedir() + "/cuda --enable-mt"
+	if strings.Join(p.ConfigureArgs, " ") != expectedArgs {
+		t.Fatalf("invalid configure arguments: %v (expected %s)", p.ConfigureArgs, expectedArgs)
+	}
+	if p.Variants["cuda"] != "on" || p.Variants["mt"] != "on" {
+		t.Fatalf("invalid variants in the provenance: %v", p.Variants)
+	}
+
+	// Changing the variants installs the component again
+	cfg.Data.StackDefinition.Components[0].Variants = map[string]string{"cuda": "off"}
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack with new variants: %s", err)
+	}
+	p, err = cfg.GetProvenance("comp1")
+	if err != nil {
+		t.Fatalf("unable to get the provenance of comp1: %s", err)
+	}
+	if strings.Join(p.ConfigureArgs, " ") != "--without-cuda" || p.Variants["cuda"] != "off" {
+		t.Fatalf("comp1 was not installed again with its new variants: %v %v", p.ConfigureArgs, p.Variants)
+	}
+
+	cfg.Data.StackDefinition.Components[0].Variants = map[string]string{"fortran": "on"}
+	err = cfg.InstallStack()
+	if err == nil {
+		t.Fatalf("installation with an unknown variant did not fail")
+	}
+
+	params, err := getVariantParams(&Component{Name: "ompi", Preset: "openmpi", Variants: map[string]string{"fortran": "off", "cuda": "on"}})
+	if err != nil {
+		t.Fatalf("unable to get the parameters of the variants of the preset: %s", err)
+	}
+	if params != "--with-cuda --disable-mpi-fortran" {
+		t.Fatalf("invalid parameters of the variants of the preset: %s", params)
+	}
+}
+
+func TestCompilerMatrix(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", ConfigureDependency: "comp1"}})
+	defer os.RemoveAll(testDir)
+	cfg.Data.StackConfig.Toolchains = []Toolchain{
+		{Name: "gcc-12", BuildEnv: "MATRIX_TOOLCHAIN=gcc-12"},
+		{Name: "clang-17", BuildEnv: "MATRIX_TOOLCHAIN=clang-17", Modules: []string{"llvm/17"}},
+	}
+	err := cfg.InstallMatrix()
+	if err != nil {
+		t.Fatalf("unable to install the compiler matrix: %s", err)
+	}
+	matrixBasedir := filepath.Join(testDir, "test")
+	for _, toolchain := range cfg.Data.StackConfig.Toolchains {
+		toolchainCfg, err := cfg.GetToolchainConfig(toolchain.Name)
+		if err != nil {
+			t.Fatalf("unable to get the configuration of toolchain %s: %s", toolchain.Name, err)
+		}
+		if !util.FileExists(filepath.Join(matrixBasedir, toolchain.Name, "install", "comp2", "bin", "helloworld")) {
+			t.Fatalf("comp2 was not installed with toolchain %s", toolchain.Name)
+		}
+		p, err := toolchainCfg.GetProvenance("comp2")
+		if err != nil {
+			t.Fatalf("unable to get the provenance of comp2 with toolchain %s: %s", toolchain.Name, err)
+		}
+		found := false
+		for _, e := range p.BuildEnv {
+			if e == "MATRIX_TOOLCHAIN="+toolchain.Name {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("comp2 was not built with toolchain %s: %v", toolchain.Name, p.BuildEnv)
+		}
+		expectedDep := "--with-comp1=" + filepath.Join(matrixBasedir, toolchain.Name, "install", "comp1")
+		if len(p.ConfigureArgs) != 1 || p.ConfigureArgs[0] != expectedDep {
+			t.Fatalf("invalid configure arguments with toolchain %s: %v", toolchain.Name, p.ConfigureArgs)
+		}
+	}
+	if util.PathExists(filepath.Join(matrixBasedir, "install")) {
+		t.Fatalf("components were installed outside of the directories of the toolchains")
+	}
+
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.GenerateMatrixModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("unable to generate the modulefiles of the compiler matrix: %s", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(matrixBasedir, "modulefiles", "clang-17"))
+	if err != nil {
+		t.Fatalf("unable to read the modulefile of the toolchain: %s", err)
+	}
+	expectedPath := "prepend-path MODULEPATH " + filepath.Join(matrixBasedir, "clang-17", "modulefiles")
+	if !strings.Contains(string(content), expectedPath) || !strings.Contains(string(content), "module load llvm/17") {
+		t.Fatalf("invalid modulefile of the toolchain:\n%s", content)
+	}
+	if !util.FileExists(filepath.Join(matrixBasedir, "gcc-12", "modulefiles", "comp2")) {
+		t.Fatalf("the modulefile of comp2 was not generated for toolchain gcc-12")
+	}
+
+	cfg.Data.StackConfig.Toolchains = []Toolchain{{Name: "src"}}
+	err = cfg.InstallMatrix()
+	if err == nil {
+		t.Fatalf("toolchain with a reserved name was accepted")
+	}
+}
+
+func TestBootstrapToolchain(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	// The toolchain is a wrapper around the compiler of the system
+	toolchainSrcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(toolchainSrcDir)
+	toolchainFiles := map[string]string{
+		"configure": `#!/bin/sh
+prefix=/usr/local
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--prefix) shift; prefix="$1" ;;
+		--prefix=*) prefix="${1#--prefix=}" ;;
+	esac
+	shift
+done
+{ printf 'PREFIX=%s\n' "$prefix"; cat Makefile.in; } > Makefile
+`,
+		"Makefile.in": "all:\n\ninstall:\n\tmkdir -p $(PREFIX)/bin $(PREFIX)/lib64\n\tcp gcc $(PREFIX)/bin/\n",
+		"gcc":         "#!/bin/sh\nexec cc \"$@\"\n",
+	}
+	for name, content := range toolchainFiles {
+		err = ioutil.WriteFile(filepath.Join(toolchainSrcDir, name), []byte(content), 0755)
+		if err != nil {
+			t.Fatalf("unable to create %s: %s", name, err)
+		}
+	}
+
+	components := []Component{
+		{Name: "app", BuildEnv: "CXX=my-c++"},
+		{Name: "gmp"},
+		{Name: "gcc", URL: "file://" + toolchainSrcDir, Toolchain: true, ConfigureDependency: "gmp"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	var order []string
+	for _, comp := range cfg.Report.Components {
+		order = append(order, comp.Name)
+	}
+	if strings.Join(order, ",") != "gmp,gcc,app" {
+		t.Fatalf("invalid installation order: %v", order)
+	}
+
+	toolchainDir := filepath.Join(cfg.getStackBasedir(), "install", "gcc")
+	p, err := cfg.GetProvenance("app")
+	if err != nil {
+		t.Fatalf("unable to get the provenance of app: %s", err)
+	}
+	buildEnv := strings.Join(p.BuildEnv, "\n")
+	for _, expected := range []string{"CC=" + filepath.Join(toolchainDir, "bin", "gcc"), "CXX=my-c++", "LDFLAGS=-Wl,-rpath," + filepath.Join(toolchainDir, "lib64")} {
+		if !strings.Contains(buildEnv, expected) {
+			t.Fatalf("app was not built with the toolchain, %s is missing from:\n%s", expected, buildEnv)
+		}
+	}
+	p, err = cfg.GetProvenance("gmp")
+	if err != nil {
+		t.Fatalf("unable to get the provenance of gmp: %s", err)
+	}
+	if strings.Contains(strings.Join(p.BuildEnv, "\n"), toolchainDir) {
+		t.Fatalf("gmp was built with the toolchain it is a dependency of: %v", p.BuildEnv)
+	}
+}
+
+func TestStackLock(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(testDir)
+	stackBasedir := cfg.getStackBasedir()
+	err := os.MkdirAll(stackBasedir, 0755)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", stackBasedir, err)
+	}
+	lockPath := filepath.Join(stackBasedir, LockFilename)
+	host, _ := os.Hostname()
+	writeLock := func(pid int) {
+		content, err := json.Marshal(lockInfo{PID: pid, Host: host, Operation: "install", Time: time.Now()})
+		if err != nil {
+			t.Fatalf("unable to encode the lock: %s", err)
+		}
+		err = ioutil.WriteFile(lockPath, content, 0644)
+		if err != nil {
+			t.Fatalf("unable to write %s: %s", lockPath, err)
+		}
+	}
+
+	// The stack is locked by a running process, i.e., the test itself
+	writeLock(os.Getpid())
+	err = cfg.InstallStack()
+	if err == nil || !strings.Contains(err.Error(), "is locked") {
+		t.Fatalf("installation of a locked stack did not fail (%v)", err)
+	}
+
+	// The lock is released while waiting for it
+	lockPollInterval = 10 * time.Millisecond
+	defer func() { lockPollInterval = time.Second }()
+	cfg.LockTimeout = 10 * time.Second
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		os.Remove(lockPath)
+	}()
+	err = cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack once its lock was released: %s", err)
+	}
+	if util.PathExists(lockPath) {
+		t.Fatalf("the lock of the stack was not released")
+	}
+
+	// Locks of processes that are not running anymore are stale
+	cmd := exec.Command("true")
+	err = cmd.Run()
+	if err != nil {
+		t.Fatalf("unable to run a process: %s", err)
+	}
+	writeLock(cmd.Process.Pid)
+	cfg.LockTimeout = 0
+	writeStackFiles(t, cfg, testDir)
+	err = cfg.GenerateModules("", "", ModuleFormatTcl)
+	if err != nil {
+		t.Fatalf("stale lock was not removed: %s", err)
+	}
+	if util.PathExists(lockPath) {
+		t.Fatalf("the lock of the stack was not released")
+	}
+
+	// A lock acquired by another process once the stale lock was read is not removed
+	writeLock(cmd.Process.Pid)
+	staleInfo, err := readLockInfo(lockPath)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", lockPath, err)
+	}
+	writeLock(os.Getpid())
+	err = removeStaleLock(lockPath, staleInfo)
+	if err != nil {
+		t.Fatalf("unable to remove the stale lock: %s", err)
+	}
+	info, err := readLockInfo(lockPath)
+	if err != nil || info.PID != os.Getpid() {
+		t.Fatalf("the lock of another process was removed (%v)", err)
+	}
+	entries, err := ioutil.ReadDir(stackBasedir)
+	if err != nil {
+		t.Fatalf("unable to read %s: %s", stackBasedir, err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), LockFilename+".") {
+			t.Fatalf("%s was left in the stack directory", entry.Name())
+		}
+	}
+}
+
+func TestProgressEstimation(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "1.0"}, {Name: "comp2"}})
+	defer os.RemoveAll(testDir)
+	cfg.BuildTimesFile = filepath.Join(testDir, BuildTimesFilename)
+	var events []Progress
+	cfg.OnProgress = func(c *Config, p *Progress) {
+		events = append(events, *p)
+	}
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	if len(events) != 4 || events[0].ComponentETA != 0 || events[0].Unestimated != 1 || events[3].Index != 2 || !events[3].Done {
+		t.Fatalf("invalid progress without build durations: %+v", events)
+	}
+	bt, err := loadBuildTimes(cfg.BuildTimesFile)
+	if err != nil {
+		t.Fatalf("unable to load the build durations: %s", err)
+	}
+	if _, ok := bt.Components["comp1"]["1.0"]; !ok {
+		t.Fatalf("the build duration of comp1 was not recorded: %v", bt.Components)
+	}
+
+	// A similar stack, with another version of comp1, is estimated with the durations of the first one
+	similarCfg, similarTestDir := newLocalStack(t, srcDir, []Component{{Name: "comp1", Version: "2.0"}, {Name: "comp2"}, {Name: "comp3"}})
+	defer os.RemoveAll(similarTestDir)
+	similarCfg.BuildTimesFile = cfg.BuildTimesFile
+	events = nil
+	similarCfg.OnProgress = cfg.OnProgress
+	err = similarCfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the similar stack: %s", err)
+	}
+	if len(events) != 6 {
+		t.Fatalf("invalid number of progress events: %+v", events)
+	}
+	first := events[0]
+	if first.Component != "comp1" || first.Done || first.Total != 3 || first.ComponentETA != bt.Components["comp1"]["1.0"] {
+		t.Fatalf("invalid estimation of comp1: %+v", first)
+	}
+	if first.StackETA != bt.Components["comp1"]["1.0"]+bt.Components["comp2"][""] || first.Unestimated != 1 {
+		t.Fatalf("invalid estimation of the stack: %+v", first)
+	}
+	last := events[5]
+	if last.Component != "comp3" || !last.Done || last.StackETA != 0 || last.Unestimated != 0 {
+		t.Fatalf("invalid progress at the end of the installation: %+v", last)
+	}
+}
+
+type testSpan struct {
+	parent     *testSpan
+	name       string
+	attributes map[string]string
+	err        error
+	ended      bool
+}
+
+func (s *testSpan) SetAttribute(key string, value string) { s.attributes[key] = value }
+func (s *testSpan) RecordError(err error)                 { s.err = err }
+func (s *testSpan) End()                                  { s.ended = true }
+
+type testSpanKey struct{}
+
+type testTracer struct {
+	mutex sync.Mutex
+	spans []*testSpan
+}
+
+func (tr *testTracer) Start(ctx context.Context, name string) (context.Context, metrics.Span) {
+	parent, _ := ctx.Value(testSpanKey{}).(*testSpan)
+	s := &testSpan{parent: parent, name: name, attributes: make(map[string]string)}
+	tr.mutex.Lock()
+	tr.spans = append(tr.spans, s)
+	tr.mutex.Unlock()
+	return context.WithValue(ctx, testSpanKey{}, s), s
+}
+
+func (tr *testTracer) getSpan(name string) *testSpan {
+	for _, s := range tr.spans {
+		if s.name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+func TestMetrics(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(testDir)
+	cfg.BuildTimesFile = filepath.Join(testDir, BuildTimesFilename)
+	cfg.Data.StackConfig.MetricsFile = filepath.Join(testDir, "stack.prom")
+	tracer := new(testTracer)
+	metrics.SetTracer(tracer)
+	defer metrics.SetTracer(nil)
+
+	installedLabels := metrics.Labels{"component": "comp1", "status": StatusInstalled}
+	installed := metrics.Default.Value(metrics.ComponentInstallsTotal, installedLabels)
+	configured := metrics.Default.Value(metrics.StageDurationSeconds, metrics.Labels{"stage": "configure"})
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	if metrics.Default.Value(metrics.ComponentInstallsTotal, installedLabels) != installed+1 {
+		t.Fatalf("the installation of comp1 was not counted")
+	}
+	if metrics.Default.Value(metrics.StageDurationSeconds, metrics.Labels{"stage": "configure"}) != configured+1 {
+		t.Fatalf("the configure stage of comp1 was not observed")
+	}
+	if metrics.Default.Value(metrics.StackInstallsTotal, metrics.Labels{"stack": "test", "status": "success"}) == 0 {
+		t.Fatalf("the installation of the stack was not counted")
+	}
+
+	content, err := ioutil.ReadFile(cfg.Data.StackConfig.MetricsFile)
+	if err != nil {
+		t.Fatalf("the metrics file was not written: %s", err)
+	}
+	if !strings.Contains(string(content), metrics.ComponentInstallsTotal+`{component="comp1",status="installed"}`) {
+		t.Fatalf("invalid metrics file:\n%s", string(content))
+	}
+
+	stackSpan := tracer.getSpan("install stack")
+	compSpan := tracer.getSpan("install comp1")
+	stageSpan := tracer.getSpan("build configure")
+	if stackSpan == nil || compSpan == nil || stageSpan == nil {
+		t.Fatalf("missing spans: %+v", tracer.spans)
+	}
+	if compSpan.parent != stackSpan || stageSpan.parent != compSpan {
+		t.Fatalf("invalid span hierarchy")
+	}
+	if !stackSpan.ended || !compSpan.ended || !stageSpan.ended || compSpan.attributes["status"] != StatusInstalled {
+		t.Fatalf("invalid span of comp1: %+v", compSpan)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	var mutex sync.Mutex
+	events := make(map[string][]Event)
+	var authorization string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event Event
+		err := json.NewDecoder(r.Body).Decode(&event)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mutex.Lock()
+		defer mutex.Unlock()
+		events[r.URL.Path] = append(events[r.URL.Path], event)
+		if r.URL.Path == "/all" {
+			authorization = r.Header.Get("Authorization")
+		}
+	}))
+	defer server.Close()
+	os.Setenv("TEST_WEBHOOK_TOKEN", "secret")
+	defer os.Unsetenv("TEST_WEBHOOK_TOKEN")
+
+	components := []Component{
+		{Name: "broken", ConfigureParams: "@ref:undefined_install_dir@"},
+		{Name: "independent"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.KeepGoing = true
+	cfg.Data.StackConfig.Webhooks = []Webhook{
+		{URL: server.URL + "/all", Headers: map[string]string{"Authorization": "Bearer $TEST_WEBHOOK_TOKEN"}, ReportURL: "https://ci.example.com/$TEST_WEBHOOK_TOKEN"},
+		{URL: server.URL + "/completed", Events: []string{EventStackCompleted}},
+		// Failed deliveries do not fail the installation
+		{URL: "http://127.0.0.1:0/invalid", Events: []string{EventStackStarted}},
+	}
+	err := cfg.InstallStack()
+	if err == nil {
+		t.Fatalf("installation succeeded with a broken component")
+	}
+
+	all := events["/all"]
+	if len(all) != 3 || all[0].Event != EventStackStarted || all[1].Event != EventComponentFailed || all[2].Event != EventStackCompleted {
+		t.Fatalf("invalid events: %+v", all)
+	}
+	if authorization != "Bearer secret" {
+		t.Fatalf("invalid authorization header: %s", authorization)
+	}
+	failed := all[1]
+	if failed.Stack != "test" || failed.Component != "broken" || failed.Error == "" || !strings.Contains(failed.LogExcerpt, "undefined_install_dir") {
+		t.Fatalf("invalid component_failed event: %+v", failed)
+	}
+	completed := all[2]
+	if completed.Status != "failure" || len(completed.Components) != 2 || completed.Components[1].Status != StatusInstalled || completed.ReportURL != "https://ci.example.com/secret" {
+		t.Fatalf("invalid stack_completed event: %+v", completed)
+	}
+	if len(events["/completed"]) != 1 || events["/completed"][0].Event != EventStackCompleted || events["/completed"][0].ReportURL != "" {
+		t.Fatalf("invalid filtered events: %+v", events["/completed"])
+	}
+}
+
+type recordingNotifier struct {
+	events []Event
+}
+
+func (n *recordingNotifier) Notify(event *Event) error {
+	n.events = append(n.events, *event)
+	return nil
+}
+
+// startSMTPServer starts a minimal SMTP server sending the messages it receives to the returned channel
+func startSMTPServer(t *testing.T) (string, chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to start the SMTP server: %s", err)
+	}
+	messages := make(chan string, 10)
+	go func() {
+		defer listener.Close()
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			reader := bufio.NewReader(conn)
+			fmt.Fprintf(conn, "220 localhost ESMTP\r\n")
+			for {
+				line, err := reader.ReadString('\n')
+				if err != nil {
+					break
+				}
+				cmd := strings.ToUpper(strings.TrimSpace(line))
+				if strings.HasPrefix(cmd, "DATA") {
+					fmt.Fprintf(conn, "354 go ahead\r\n")
+					var msg strings.Builder
+					for {
+						dataLine, err := reader.ReadString('\n')
+						if err != nil || dataLine == ".\r\n" {
+							break
+						}
+						msg.WriteString(dataLine)
+					}
+					messages <- msg.String()
+					fmt.Fprintf(conn, "250 OK\r\n")
+				} else if strings.HasPrefix(cmd, "QUIT") {
+					fmt.Fprintf(conn, "221 bye\r\n")
+					break
+				} else {
+					fmt.Fprintf(conn, "250 OK\r\n")
+				}
+			}
+			conn.Close()
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func TestNotifiers(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	var slackMessages []string
+	slackServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var msg map[string]string
+		err := json.NewDecoder(r.Body).Decode(&msg)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		slackMessages = append(slackMessages, msg["text"])
+	}))
+	defer slackServer.Close()
+	os.Setenv("TEST_SLACK_WEBHOOK_URL", slackServer.URL)
+	defer os.Unsetenv("TEST_SLACK_WEBHOOK_URL")
+	smtpServer, emails := startSMTPServer(t)
+
+	components := []Component{
+		{Name: "broken", ConfigureParams: "@ref:undefined_install_dir@"},
+		{Name: "independent"},
+	}
+	cfg, testDir := newLocalStack(t, srcDir, components)
+	defer os.RemoveAll(testDir)
+	cfg.KeepGoing = true
+	recorder := new(recordingNotifier)
+	cfg.Notifiers = []Notifier{
+		recorder,
+		&SlackNotifier{WebhookURL: "$TEST_SLACK_WEBHOOK_URL", Mention: "<!here>"},
+		&SMTPNotifier{Server: smtpServer, From: "builder@example.com", To: []string{"owner@example.com"}, Events: []string{EventStackCompleted}},
+	}
+	err := cfg.InstallStack()
+	if err == nil {
+		t.Fatalf("installation succeeded with a broken component")
+	}
+
+	if len(recorder.events) != 3 || recorder.events[1].Component != "broken" {
+		t.Fatalf("invalid events: %+v", recorder.events)
+	}
+	if len(slackMessages) != 2 || !strings.HasPrefix(slackMessages[0], "<!here> Installation of broken failed in stack test") {
+		t.Fatalf("invalid Slack messages: %q", slackMessages)
+	}
+	if !strings.Contains(slackMessages[1], "completed: failure (1 installed, 1 failed)") {
+		t.Fatalf("invalid Slack message: %s", slackMessages[1])
+	}
+	select {
+	case email := <-emails:
+		if !strings.Contains(email, "To: owner@example.com") || !strings.Contains(email, "Subject: Installation of stack test") || !strings.Contains(email, "- broken: failed") {
+			t.Fatalf("invalid email:\n%s", email)
+		}
+	default:
+		t.Fatalf("no email was sent")
+	}
+	if len(emails) != 0 {
+		t.Fatalf("unexpected emails were sent")
+	}
+}
+
+func TestGetManifests(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}, {Name: "comp2", Disabled: true}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+
+	manifests, err := cfg.GetManifests("comp1")
+	if err != nil {
+		t.Fatalf("GetManifests() failed: %s", err)
+	}
+	if len(manifests) == 0 || manifests[0].Name != "configure" || len(manifests[0].Args) == 0 {
+		t.Fatalf("invalid manifests of comp1: %+v", manifests)
+	}
+	_, err = cfg.GetManifests("comp2")
+	if err == nil {
+		t.Fatalf("manifests of a component that is not installed were returned")
+	}
+	all, err := cfg.GetAllManifests()
+	if err != nil {
+		t.Fatalf("GetAllManifests() failed: %s", err)
+	}
+	if len(all) != 1 || len(all["comp1"]) != len(manifests) {
+		t.Fatalf("invalid manifests of the stack: %+v", all)
+	}
+}
+
+func TestReplayComponent(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not available, skipping test")
+	}
+	srcDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+	// The software only builds when FIXED is set to yes in the environment
+	configureScript := `#!/bin/sh
+printf 'all:\n\ttest "$(FIXED)" = yes\n\ninstall:\n\ttrue\n' > Makefile
+`
+	err = ioutil.WriteFile(filepath.Join(srcDir, "configure"), []byte(configureScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create configure script: %s", err)
+	}
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "broken"}})
+	defer os.RemoveAll(testDir)
+	err = cfg.InstallStack()
+	if err == nil {
+		t.Fatalf("installation succeeded while the component does not build")
+	}
+
+	commands, err := cfg.GetRecordedCommands("broken")
+	if err != nil {
+		t.Fatalf("GetRecordedCommands() failed: %s", err)
+	}
+	if len(commands) != 2 || commands[0].Stage != "configure" || commands[1].Stage != "compile" || commands[1].Error == "" {
+		t.Fatalf("invalid recorded commands: %+v", commands)
+	}
+
+	err = cfg.ReplayComponent("broken", &builder.ReplayOptions{Stdout: ioutil.Discard, Stderr: ioutil.Discard})
+	if err == nil {
+		t.Fatalf("replaying the failed step succeeded without fixing it")
+	}
+	err = cfg.ReplayComponent("broken", &builder.ReplayOptions{Env: buildenv.Env{"FIXED=yes"}, Stdout: ioutil.Discard, Stderr: ioutil.Discard})
+	if err != nil {
+		t.Fatalf("unable to replay the failed step with the fixed environment: %s", err)
+	}
+	err = cfg.ReplayComponent("broken", &builder.ReplayOptions{Stage: "configure", Stdout: ioutil.Discard, Stderr: ioutil.Discard})
+	if err != nil {
+		t.Fatalf("unable to replay the configure step: %s", err)
+	}
+	err = cfg.ReplayComponent("broken", &builder.ReplayOptions{Stage: "install"})
+	if err == nil {
+		t.Fatalf("a stage that was not executed was replayed")
+	}
+}
+
+func TestLoadComponentLocations(t *testing.T) {
+	srcDir := testutil.CreateLocalSoftware(t)
+	defer os.RemoveAll(srcDir)
+
+	cfg, testDir := newLocalStack(t, srcDir, []Component{{Name: "comp1"}})
+	defer os.RemoveAll(testDir)
+	err := cfg.InstallStack()
+	if err != nil {
+		t.Fatalf("unable to install the stack: %s", err)
+	}
+	writeStackFiles(t, cfg, testDir)
+
+	// A new configuration, e.g., in another process, knows about the components already installed
+	newCfg := &Config{
+		DefFilePath:    cfg.DefFilePath,
+		ConfigFilePath: cfg.ConfigFilePath,
+	}
+	err = newCfg.Load()
+	if err != nil {
+		t.Fatalf("unable to load the stack: %s", err)
+	}
+	for name, maps := range map[string][2]map[string]string{
+		"InstalledComponents": {cfg.InstalledComponents, newCfg.InstalledComponents},
+		"BuiltComponents":     {cfg.BuiltComponents, newCfg.BuiltComponents},
+		"SrcComponents":       {cfg.SrcComponents, newCfg.SrcComponents},
+	} {
+		if len(maps[1]) != 1 || maps[1]["comp1"] != maps[0]["comp1"] {
+			t.Fatalf("invalid %s: %v (expected: %v)", name, maps[1], maps[0])
+		}
+	}
+	installDir, err := newCfg.UpdateRefs("@ref:comp1_install_dir@")
+	if err != nil {
+		t.Fatalf("UpdateRefs() failed: %s", err)
+	}
+	if installDir != cfg.InstalledComponents["comp1"] {
+		t.Fatalf("invalid installation directory: %s", installDir)
+	}
+
+	// Components that are not installed anymore are ignored
+	err = os.RemoveAll(installDir)
+	if err != nil {
+		t.Fatalf("unable to remove %s: %s", installDir, err)
+	}
+	newCfg = &Config{
+		DefFilePath:    cfg.DefFilePath,
+		ConfigFilePath: cfg.ConfigFilePath,
+	}
+	err = newCfg.Load()
+	if err != nil {
+		t.Fatalf("unable to load the stack: %s", err)
+	}
+	if len(newCfg.InstalledComponents) != 0 {
+		t.Fatalf("a component that is not installed anymore was loaded: %v", newCfg.InstalledComponents)
+	}
+}
+
+func TestLint(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	def := StackDef{
+		Name: "test",
+		Components: []Component{
+			{Name: "comp1", URL: "https://example.com/comp1.tar.gz", ConfigureDependency: "comp2", ConfigId: "comp1"},
+			{Name: "comp2", URL: "http://example.com/comp2.tar.gz", BuildEnv: "CC=gcc -O2"},
+			{Name: "comp3", URL: "https://github.com/org/comp3/tree/main", ConfigureParams: "--with-comp4=@ref:comp4_install_dir@ --with-comp1=@ref:comp1_install_dir@"},
+			{Name: "comp4", URL: "example.com/comp4.tar.gz", ConfigureParams: "--version=@ref:comp1_version@ --prefix=@ref:stack_dir@"},
+			{Name: "comp5", URL: "https://example.com/comp5.git", MakeVars: map[string]string{"MPI": "@ref:mpi_install_dir@"}, ConfigureDependency: "mpi"},
+			{Name: "comp6", URL: "https://example.com/comp6.git", Provides: []string{"mpi"}, ConfigId: "mpi"},
+		},
+	}
+	content, err := json.Marshal(def)
+	if err != nil {
+		t.Fatalf("unable to encode the definition of the stack: %s", err)
+	}
+	defPath := filepath.Join(testDir, "stack.json")
+	err = ioutil.WriteFile(defPath, content, 0644)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", defPath, err)
+	}
+
+	warnings, err := Lint(defPath)
+	if err != nil {
+		t.Fatalf("Lint() failed: %s", err)
+	}
+	expected := []string{
+		"comp1: depends on comp2, which is defined after it",
+		"comp2: invalid build environment",
+		"comp2: URL http://example.com/comp2.tar.gz is not secure",
+		"comp3: reference comp4_install_dir refers to comp4, which is defined after it",
+		"comp3: URL https://github.com/org/comp3/tree/main is a web page",
+		"comp5: reference mpi_install_dir refers to mpi, which is defined after it",
+		"comp5: depends on mpi, which is defined after it",
+		"comp4: URL example.com/comp4.tar.gz has no scheme",
+		"comp1: configure_id comp1 is unused",
+	}
+	if len(warnings) != len(expected) {
+		t.Fatalf("Lint() returned %d warnings instead of %d: %v", len(warnings), len(expected), warnings)
+	}
+	for _, prefix := range expected {
+		found := false
+		for _, w := range warnings {
+			if strings.HasPrefix(w.String(), prefix) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("%q was not reported: %v", prefix, warnings)
+		}
+	}
+
+	err = ioutil.WriteFile(defPath, []byte(`{"name": "test", "components": [{"name": "comp1", "URL": "https://example.com/comp1.tar.gz", "build_env": "CC=gcc"}]}`), 0644)
+	if err != nil {
+		t.Fatalf("unable to update %s: %s", defPath, err)
+	}
+	warnings, err = Lint(defPath)
+	if err != nil {
+		t.Fatalf("Lint() failed: %s", err)
+	}
+	if len(warnings) != 0 {
+		t.Fatalf("warnings reported for a valid definition: %v", warnings)
+	}
+}
+
+func TestStrictLoad(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("unable to create temporary directory: %s", err)
+	}
+	defer os.RemoveAll(testDir)
+
+	cfg := &Config{
+		DefFilePath:    filepath.Join(testDir, "def.json"),
+		ConfigFilePath: filepath.Join(testDir, "config.json"),
+	}
+	err = ioutil.WriteFile(cfg.ConfigFilePath, []byte(`{"installDir": "`+testDir+`"}`), 0644)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", cfg.ConfigFilePath, err)
+	}
+
+	tests := []struct {
+		name     string
+		def      string
+		expected []string
+	}{
+		{
+			name:     "typo",
+			def:      "{\n\t\"name\": \"test\",\n\t\"components\": [\n\t\t{\"name\": \"comp1\", \"configure_parms\": \"--enable-foo\"}\n\t]\n}",
+			expected: []string{`unknown field "configure_parms"`, "line 4, column 21", `did you mean "configure_params"`},
+		},
+		{
+			name:     "invalid value",
+			def:      "{\n\t\"name\": \"test\",\n\t\"components\": [{\"name\": \"comp1\", \"build_targets\": \"all\"}]\n}",
+			expected: []string{"invalid value at line 3", "build_targets"},
+		},
+		{
+			name:     "syntax error",
+			def:      "{\n\t\"name\": \"test\",\n\t\"components\": [{\"name\": \"comp1\",}]\n}",
+			expected: []string{"syntax error at line 3"},
+		},
+		{
+			name:     "trailing content",
+			def:      `{"name": "test"} {"name": "test2"}`,
+			expected: []string{"unexpected content after the JSON document at line 1"},
+		},
+	}
+	for _, tt := range tests {
+		err = ioutil.WriteFile(cfg.DefFilePath, []byte(tt.def), 0644)
+		if err != nil {
+			t.Fatalf("unable to create %s: %s", cfg.DefFilePath, err)
+		}
+		err = cfg.Load()
+		if err == nil {
+			t.Fatalf("%s: Load() succeeded while expected to fail", tt.name)
+		}
+		for _, expected := range tt.expected {
+			if !strings.Contains(err.Error(), expected) {
+				t.Fatalf("%s: error %q does not include %q", tt.name, err, expected)
+			}
+		}
+	}
+
+	err = ioutil.WriteFile(cfg.DefFilePath, []byte(`{"name": "test", "components": [{"name": "comp1"}]}`), 0644)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", cfg.DefFilePath, err)
+	}
+	err = ioutil.WriteFile(cfg.ConfigFilePath, []byte(`{"installDir": "`+testDir+`", "shared_configure_cahce": true}`), 0644)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", cfg.ConfigFilePath, err)
+	}
+	err = cfg.Load()
+	if err == nil || !strings.Contains(err.Error(), `unknown field "shared_configure_cahce"`) {
+		t.Fatalf("the unknown field of the configuration was not reported: %v", err)
+	}
+}
@@ -0,0 +1,345 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+const (
+	// StateFilename is the name of the file, in the stack base directory, where the state of the stack is saved.
+	// The state acts as a lockfile: it records what was resolved while installing the stack, e.g., the checksums
+	// of the tarballs and the Git revisions, so that subsequent installations can be checked against it.
+	StateFilename = "stack_state.json"
+)
+
+// ComponentState is the state of a component of the stack
+type ComponentState struct {
+	// URL is the URL used to get the component's source code
+	URL string `json:"URL"`
+
+	// Branch and SourceVersion are the branch or tag and the version of the component when its source code was
+	// recorded; SHA256 and Revision are only relevant as long as they, and URL, do not change
+	Branch        string `json:"branch,omitempty"`
+	SourceVersion string `json:"source_version,omitempty"`
+
+	// SHA256 is the checksum of the component's source code when it is a file, e.g., a tarball
+	SHA256 string `json:"sha256,omitempty"`
+
+	// Revision is the SHA of the commit used when the component's source code comes from Git
+	Revision string `json:"revision,omitempty"`
+
+	// VersionConstraint is the version of the component as specified in the stack definition when it is not an
+	// actual version, e.g., "latest" or ">=4.1 <5"
+	VersionConstraint string `json:"version_constraint,omitempty"`
+
+	// Version is the version VersionConstraint was resolved to, e.g., 4.1.5
+	Version string `json:"version,omitempty"`
+
+	// Tag is the tag of the release VersionConstraint was resolved to, e.g., v4.1.5
+	Tag string `json:"tag,omitempty"`
+
+	// SanityCheckFailed specifies whether the last sanity check of the component failed
+	SanityCheckFailed bool `json:"sanity_check_failed,omitempty"`
+
+	// Variants is the variants the component was installed with (see Component.Variants)
+	Variants map[string]string `json:"variants,omitempty"`
+
+	// InstallDir, BuildDir and SrcDir are the directories where the component was last installed and built and
+	// where its source code is, so that they are known without installing the stack again (see
+	// Config.InstalledComponents)
+	InstallDir string `json:"install_dir,omitempty"`
+	BuildDir   string `json:"build_dir,omitempty"`
+	SrcDir     string `json:"src_dir,omitempty"`
+}
+
+// State is the persistent state of a stack
+type State struct {
+	// StackDir is the base directory of the stack when the state was saved, e.g., to relocate an imported stack
+	StackDir string `json:"stack_dir,omitempty"`
+
+	// Components is the state of all the components of the stack, the key being the name of the component
+	Components map[string]*ComponentState `json:"components"`
+
+	mutex sync.Mutex
+}
+
+// loadState loads the state of a stack from its base directory. An empty state is returned if the
+// state does not exist yet.
+func loadState(stackBasedir string) (*State, error) {
+	s := new(State)
+	s.Components = make(map[string]*ComponentState)
+
+	statePath := filepath.Join(stackBasedir, StateFilename)
+	if !util.FileExists(statePath) {
+		return s, nil
+	}
+
+	content, err := ioutil.ReadFile(statePath)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read %s: %w", statePath, err)
+	}
+	err = json.Unmarshal(content, s)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse %s: %w", statePath, err)
+	}
+	if s.Components == nil {
+		s.Components = make(map[string]*ComponentState)
+	}
+	return s, nil
+}
+
+// save writes the state of a stack in its base directory
+func (s *State) save(stackBasedir string) error {
+	s.mutex.Lock()
+	s.StackDir = stackBasedir
+	s.mutex.Unlock()
+	return s.write(filepath.Join(stackBasedir, StateFilename))
+}
+
+// write writes the state of a stack in a file, e.g., the lockfile of a bundle
+func (s *State) write(statePath string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	content, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return fmt.Errorf("unable to encode the state of the stack: %w", err)
+	}
+	err = ioutil.WriteFile(statePath, content, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", statePath, err)
+	}
+	return nil
+}
+
+// getComponent returns the state of a component, creating it when necessary
+func (s *State) getComponent(name string) *ComponentState {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	compState, ok := s.Components[name]
+	if !ok {
+		compState = new(ComponentState)
+		s.Components[name] = compState
+	}
+	return compState
+}
+
+// recordSource saves the checksum and revision of the source code of a component that was just fetched from a URL,
+// with a branch or tag and a version. Values that are empty, for instance because the source code was not fetched
+// again, do not override previously recorded values.
+func (s *State) recordSource(name string, url string, branch string, version string, checksum string, revision string) {
+	compState := s.getComponent(name)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if compState.URL != url || compState.Branch != branch || compState.SourceVersion != version {
+		// The source changed, e.g., the branch moved to another tag, previous values are not relevant anymore
+		compState.SHA256 = ""
+		compState.Revision = ""
+	}
+	compState.URL = url
+	compState.Branch = branch
+	compState.SourceVersion = version
+	if checksum != "" {
+		compState.SHA256 = checksum
+	}
+	if revision != "" {
+		compState.Revision = revision
+	}
+}
+
+// expectedSource returns the checksum and revision recorded for a component, as long as the URL, the branch or tag
+// and the version did not change since they were recorded
+func (s *State) expectedSource(name string, url string, branch string, version string) (string, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	compState, ok := s.Components[name]
+	if !ok || compState.URL != url || compState.Branch != branch || compState.SourceVersion != version {
+		return "", ""
+	}
+	return compState.SHA256, compState.Revision
+}
+
+// recordVersion saves the version and tag a version constraint of a component was resolved to
+func (s *State) recordVersion(name string, constraint string, version string, tag string) {
+	compState := s.getComponent(name)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	compState.VersionConstraint = constraint
+	compState.Version = version
+	compState.Tag = tag
+}
+
+// resolvedVersion returns the version and tag a version constraint of a component was previously resolved to, if any
+func (s *State) resolvedVersion(name string, constraint string) (string, string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	compState, ok := s.Components[name]
+	if !ok || compState.VersionConstraint != constraint {
+		return "", ""
+	}
+	return compState.Version, compState.Tag
+}
+
+// recordSanityCheck saves the result of the sanity check of a component
+func (s *State) recordSanityCheck(name string, failed bool) {
+	compState := s.getComponent(name)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	compState.SanityCheckFailed = failed
+}
+
+// sanityCheckFailed returns whether the last sanity check of a component failed
+func (s *State) sanityCheckFailed(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	compState, ok := s.Components[name]
+	return ok && compState.SanityCheckFailed
+}
+
+// recordVariants saves the variants a component was installed with
+func (s *State) recordVariants(name string, variants map[string]string) {
+	compState := s.getComponent(name)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	compState.Variants = variants
+}
+
+// variantsChanged returns whether a component was installed with variants different from the given ones
+func (s *State) variantsChanged(name string, variants map[string]string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	compState, ok := s.Components[name]
+	if !ok || compState.URL == "" {
+		// The component was never installed
+		return false
+	}
+	if len(compState.Variants) != len(variants) {
+		return true
+	}
+	for name, value := range variants {
+		if recordedValue, ok := compState.Variants[name]; !ok || recordedValue != value {
+			return true
+		}
+	}
+	return false
+}
+
+// recordLocations saves the directories where a component is installed, built and where its source code is
+func (s *State) recordLocations(name string, installDir string, buildDir string, srcDir string) {
+	compState := s.getComponent(name)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	compState.InstallDir = installDir
+	compState.BuildDir = buildDir
+	compState.SrcDir = srcDir
+}
+
+// relocate updates the directories of the components that are in the base directory of the stack when the
+// state was saved so they are in stackBasedir, e.g., for an imported stack
+func (s *State) relocate(stackBasedir string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.StackDir == "" || s.StackDir == stackBasedir {
+		return
+	}
+	for _, compState := range s.Components {
+		for _, dir := range []*string{&compState.InstallDir, &compState.BuildDir, &compState.SrcDir} {
+			if relocated := relocatePath(*dir, s.StackDir, stackBasedir); relocated != "" {
+				*dir = relocated
+			}
+		}
+	}
+	s.StackDir = stackBasedir
+}
+
+// loadComponentLocations populates the maps of the directories of the components (see InstalledComponents,
+// BuiltComponents and SrcComponents) from the state of the stack, so that references to components installed
+// by a previous process can be resolved (see UpdateRefs). Components whose installation directory does not
+// exist anymore are ignored.
+func (c *Config) loadComponentLocations() error {
+	stackBasedir := c.getStackBasedir()
+	s, err := loadState(stackBasedir)
+	if err != nil {
+		return fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+	// The stack may have been moved since the state was saved
+	s.relocate(stackBasedir)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		if comp.Disabled {
+			continue
+		}
+		installDir := comp.External
+		compState, ok := s.Components[comp.Name]
+		if installDir == "" && ok {
+			installDir = compState.InstallDir
+		}
+		if installDir == "" || !util.PathExists(installDir) {
+			continue
+		}
+		if comp.installHash != "" && installDir != getCompInstallDir(stackBasedir, comp) {
+			// A previous configuration of the component is installed (see StackCfg.HashedInstallPrefixes)
+			continue
+		}
+		if _, ok := c.InstalledComponents[comp.Name]; ok {
+			// Components installed by this process are more accurate
+			continue
+		}
+
+		if c.InstalledComponents == nil {
+			c.InstalledComponents = make(map[string]string)
+		}
+		c.InstalledComponents[comp.Name] = installDir
+		if comp.External != "" || !ok {
+			continue
+		}
+		if c.BuiltComponents == nil {
+			c.BuiltComponents = make(map[string]string)
+		}
+		c.BuiltComponents[comp.Name] = compState.BuildDir
+		if c.SrcComponents == nil {
+			c.SrcComponents = make(map[string]string)
+		}
+		c.SrcComponents[comp.Name] = compState.SrcDir
+	}
+	return nil
+}
+
+// loadStackState makes sure the state of the stack is loaded
+func (c *Config) loadStackState() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.state != nil {
+		return nil
+	}
+	s, err := loadState(c.getStackBasedir())
+	if err != nil {
+		return err
+	}
+	c.state = s
+	return nil
+}
@@ -0,0 +1,121 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// ComponentStatus is the status of a component of an installed stack
+type ComponentStatus struct {
+	// Name is the name of the component
+	Name string
+
+	// Version is the version of the component, if any
+	Version string
+
+	// Metadata is the metadata of the component from the stack definition
+	Metadata Metadata
+
+	// Disabled specifies whether the component is disabled in the stack definition or its configuration
+	Disabled bool
+
+	// Installed specifies whether the component is installed
+	Installed bool
+
+	// InstallDir is the directory where the component is, or would be, installed
+	InstallDir string
+
+	// SanityCheckFailed specifies whether the last sanity check of the component failed
+	SanityCheckFailed bool
+
+	// Licenses is the licenses detected in the license files collected for the component (see GetLicenses)
+	Licenses []string
+}
+
+// Status is the status of an installed stack
+type Status struct {
+	// Name is the name of the stack
+	Name string
+
+	// Dir is the base directory of the stack
+	Dir string
+
+	// Components is the status of all the components of the stack, in the order of the stack definition
+	Components []ComponentStatus
+}
+
+// Status returns the status of the stack, i.e., which components are installed, based on its definition,
+// its state and its base directory
+func (c *Config) Status() (*Status, error) {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return nil, fmt.Errorf("unable to load configuration: %w", err)
+		}
+	}
+	err := c.loadStackState()
+	if err != nil {
+		return nil, fmt.Errorf("unable to load the state of the stack: %w", err)
+	}
+
+	stackBasedir := c.getStackBasedir()
+	status := &Status{
+		Name: c.Data.StackDefinition.Name,
+		Dir:  stackBasedir,
+	}
+	for idx := range c.Data.StackDefinition.Components {
+		comp := &c.Data.StackDefinition.Components[idx]
+		compStatus := ComponentStatus{
+			Name:              comp.Name,
+			Version:           comp.Version,
+			Metadata:          comp.GetMetadata(),
+			Disabled:          comp.Disabled,
+			InstallDir:        getCompInstallDir(stackBasedir, comp),
+			SanityCheckFailed: c.state.sanityCheckFailed(comp.Name),
+		}
+		compStatus.Installed = util.IsDir(compStatus.InstallDir)
+		compStatus.Licenses, err = c.GetLicenses(comp.Name)
+		if err != nil {
+			return nil, err
+		}
+		status.Components = append(status.Components, compStatus)
+	}
+	return status, nil
+}
+
+// String returns a human-readable description of the status of the stack
+func (s *Status) String() string {
+	var lines []string
+	lines = append(lines, fmt.Sprintf("Stack %s (%s)", s.Name, s.Dir))
+	for _, comp := range s.Components {
+		name := comp.Name
+		if comp.Version != "" {
+			name += " " + comp.Version
+		}
+		state := "not installed"
+		switch {
+		case comp.Disabled:
+			state = "disabled"
+		case comp.Installed && comp.SanityCheckFailed:
+			state = "installed, sanity check failed"
+		case comp.Installed:
+			state = "installed in " + comp.InstallDir
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", name, state))
+		for _, line := range comp.Metadata.whatis() {
+			lines = append(lines, "    "+line)
+		}
+		if len(comp.Licenses) > 0 {
+			lines = append(lines, "    Detected licenses: "+strings.Join(comp.Licenses, ", "))
+		}
+	}
+	return strings.Join(lines, "\n")
+}
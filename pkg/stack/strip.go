@@ -0,0 +1,128 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"debug/elf"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// debugDirname is the name of the directory, in the base directory of the stack, where the debug information of
+// the installed binaries and libraries is saved when split from them (see StackCfg.SplitDebugInfo)
+const debugDirname = "debug"
+
+// getStrippableELF checks whether a file is an ELF binary or shared library that can be stripped and whether it
+// has debug information
+func getStrippableELF(path string) (bool, bool) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return false, false
+	}
+	defer f.Close()
+	if f.Type != elf.ET_EXEC && f.Type != elf.ET_DYN {
+		return false, false
+	}
+	return true, f.Section(".debug_info") != nil
+}
+
+// runBinutils runs a binutils command, e.g., strip or objcopy
+func runBinutils(bin string, args ...string) error {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(bin, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if err != nil {
+		return fmt.Errorf("%s %v failed: %w - stdout: %s - stderr: %s", filepath.Base(bin), args, err, stdout.String(), stderr.String())
+	}
+	return nil
+}
+
+// stripFile strips a binary or library, saving its debug information in debugFile first when not empty
+func stripFile(stripBin string, objcopyBin string, path string, debugFile string) error {
+	if debugFile != "" {
+		err := os.MkdirAll(filepath.Dir(debugFile), defaultPermission)
+		if err != nil {
+			return fmt.Errorf("unable to create %s: %w", filepath.Dir(debugFile), err)
+		}
+		err = runBinutils(objcopyBin, "--only-keep-debug", path, debugFile)
+		if err != nil {
+			return err
+		}
+	}
+	err := runBinutils(stripBin, "--strip-unneeded", path)
+	if err != nil {
+		return err
+	}
+	if debugFile != "" {
+		// Debuggers find the debug information through the debug link
+		return runBinutils(objcopyBin, "--add-gnu-debuglink="+debugFile, path)
+	}
+	return nil
+}
+
+// stripInstallDir strips the binaries and libraries installed in compInstallDir. When splitDebug is true, their
+// debug information is saved beforehand in a parallel tree in the debug directory of the stack, e.g.,
+// debug/<name>/lib/libfoo.so.debug for install/<name>/lib/libfoo.so, which is not part of exports.
+func stripInstallDir(stackBasedir string, compInstallDir string, splitDebug bool) error {
+	stripBin, err := exec.LookPath("strip")
+	if err != nil {
+		log.Printf("[WARN] strip is not available, the binaries and libraries in %s are not stripped", compInstallDir)
+		return nil
+	}
+	objcopyBin, err := exec.LookPath("objcopy")
+	if err != nil && splitDebug {
+		return fmt.Errorf("objcopy is required to split the debug information: %w", err)
+	}
+
+	installDir := filepath.Join(stackBasedir, "install")
+	stripped := 0
+	err = filepath.Walk(compInstallDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		strippable, hasDebug := getStrippableELF(path)
+		if !strippable {
+			return nil
+		}
+		debugFile := ""
+		if splitDebug && hasDebug {
+			relPath, err := filepath.Rel(installDir, path)
+			if err != nil {
+				return err
+			}
+			debugFile = filepath.Join(stackBasedir, debugDirname, relPath+".debug")
+		}
+		// Libraries are often installed read-only
+		if info.Mode().Perm()&0200 == 0 {
+			err = os.Chmod(path, info.Mode().Perm()|0200)
+			if err != nil {
+				return fmt.Errorf("unable to make %s writable: %w", path, err)
+			}
+			defer os.Chmod(path, info.Mode().Perm())
+		}
+		err = stripFile(stripBin, objcopyBin, path, debugFile)
+		if err != nil {
+			return fmt.Errorf("unable to strip %s: %w", path, err)
+		}
+		stripped++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Printf("-> %d binaries and libraries stripped in %s", stripped, compInstallDir)
+	return nil
+}
@@ -0,0 +1,63 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// getVariantFlags returns the flags of each value of the variants of a component, e.g., "cuda=on" => "--with-cuda",
+// from the component and its preset, the ones of the component taking precedence
+func getVariantFlags(comp *Component) map[string]string {
+	flags := make(map[string]string)
+	if comp.Preset != "" {
+		preset, err := GetPreset(comp.Preset)
+		if err == nil {
+			for variant, variantFlags := range preset.Variants {
+				flags[variant] = variantFlags
+			}
+		}
+	}
+	for variant, variantFlags := range comp.VariantFlags {
+		flags[variant] = variantFlags
+	}
+	return flags
+}
+
+// getVariantParams returns the configure parameters selecting the variants of a component (see Component.Variants),
+// in the order of the names of the variants
+func getVariantParams(comp *Component) (string, error) {
+	if len(comp.Variants) == 0 {
+		return "", nil
+	}
+	flags := getVariantFlags(comp)
+	var names []string
+	for name := range comp.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var params []string
+	for _, name := range names {
+		variant := name + "=" + comp.Variants[name]
+		variantFlags, ok := flags[variant]
+		if !ok {
+			var known []string
+			for knownVariant := range flags {
+				known = append(known, knownVariant)
+			}
+			sort.Strings(known)
+			return "", fmt.Errorf("unknown variant %s of %s, known variants are: %s", variant, comp.Name, strings.Join(known, ", "))
+		}
+		if variantFlags != "" {
+			params = append(params, variantFlags)
+		}
+	}
+	return strings.Join(params, " "), nil
+}
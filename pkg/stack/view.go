@@ -0,0 +1,188 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/gvallee/go_util/pkg/util"
+)
+
+// ViewMarkerFilename is the name of the file identifying a directory as a view of a stack (see GenerateView),
+// listing the components of the view, one per line
+const ViewMarkerFilename = ".stack_view"
+
+// viewDirs is the directories of the installation directory of the components that are merged into a view
+var viewDirs = []string{"bin", "sbin", "lib", "lib64", "libexec", "include", "share"}
+
+// viewEntry is a file or directory of a view and the component it comes from
+type viewEntry struct {
+	compName string
+	target   string
+	isDir    bool
+}
+
+// addToView adds the files of the installation directory of a component to the entries of a view, returning the
+// conflicts with the entries of the other components
+func addToView(entries map[string]*viewEntry, compName string, compInstallDir string) ([]string, error) {
+	var conflicts []string
+	for _, viewDir := range viewDirs {
+		dir := filepath.Join(compInstallDir, viewDir)
+		if !util.IsDir(dir) {
+			continue
+		}
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			relPath, err := filepath.Rel(compInstallDir, path)
+			if err != nil {
+				return err
+			}
+			existing, ok := entries[relPath]
+			if !ok {
+				entries[relPath] = &viewEntry{compName: compName, target: path, isDir: info.IsDir()}
+				return nil
+			}
+			if existing.isDir && info.IsDir() {
+				return nil
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%s is provided by both %s and %s", relPath, existing.compName, compName))
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to list the files in %s: %w", dir, err)
+		}
+	}
+	return conflicts, nil
+}
+
+// getViewComponents returns the components of a view, i.e., the selected components or all the enabled components
+// of the stack when none is selected
+func (c *Config) getViewComponents(names []string) ([]*Component, error) {
+	var components []*Component
+	if len(names) == 0 {
+		for idx := range c.Data.StackDefinition.Components {
+			if !c.Data.StackDefinition.Components[idx].Disabled {
+				components = append(components, &c.Data.StackDefinition.Components[idx])
+			}
+		}
+		return components, nil
+	}
+	for _, name := range names {
+		comp := c.getComponent(name)
+		if comp == nil {
+			return nil, fmt.Errorf("%s is not a component of the stack", name)
+		}
+		components = append(components, comp)
+	}
+	return components, nil
+}
+
+// prepareViewDir makes sure a view can be generated in dir, removing the previous view if any. Directories that are
+// not empty and are not a view are never removed.
+func prepareViewDir(dir string) error {
+	if util.PathExists(dir) {
+		entries, err := ioutil.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("unable to read content of %s: %w", dir, err)
+		}
+		if len(entries) > 0 && !util.FileExists(filepath.Join(dir, ViewMarkerFilename)) {
+			return fmt.Errorf("%s is not empty and is not a view, refusing to overwrite it", dir)
+		}
+		err = os.RemoveAll(dir)
+		if err != nil {
+			return fmt.Errorf("unable to remove the previous view %s: %w", dir, err)
+		}
+	}
+	err := os.MkdirAll(dir, defaultPermission)
+	if err != nil {
+		return fmt.Errorf("unable to create %s: %w", dir, err)
+	}
+	return nil
+}
+
+// GenerateView creates in dir a merged tree of the bin, lib, include, etc. directories of the installed components
+// of the stack using symbolic links, similarly to GNU Stow, so that users who do not use modules can add a single
+// directory to their PATH. Only the given components are part of the view when specified, and all the enabled
+// components of the stack otherwise. Files that are provided by several components are conflicts, reported in the
+// returned error before anything is created. A view that already exists in dir is replaced.
+func (c *Config) GenerateView(dir string, components ...string) error {
+	if !c.Loaded {
+		err := c.Load()
+		if err != nil {
+			return fmt.Errorf("c.Load() failed: %w", err)
+		}
+	}
+
+	viewComponents, err := c.getViewComponents(components)
+	if err != nil {
+		return err
+	}
+	stackBasedir := c.getStackBasedir()
+	entries := make(map[string]*viewEntry)
+	var conflicts []string
+	var names []string
+	for _, comp := range viewComponents {
+		compInstallDir := getCompInstallDir(stackBasedir, comp)
+		if !util.IsDir(compInstallDir) {
+			return fmt.Errorf("%s is not installed in %s", comp.Name, compInstallDir)
+		}
+		compConflicts, err := addToView(entries, comp.Name, compInstallDir)
+		if err != nil {
+			return err
+		}
+		conflicts = append(conflicts, compConflicts...)
+		names = append(names, comp.Name)
+	}
+	if len(conflicts) > 0 {
+		return fmt.Errorf("unable to generate the view, conflicting files: %s", strings.Join(conflicts, "; "))
+	}
+
+	err = prepareViewDir(dir)
+	if err != nil {
+		return err
+	}
+
+	// Parent directories are sorted before their content
+	var relPaths []string
+	for relPath := range entries {
+		relPaths = append(relPaths, relPath)
+	}
+	sort.Strings(relPaths)
+	for _, relPath := range relPaths {
+		entry := entries[relPath]
+		path := filepath.Join(dir, relPath)
+		if entry.isDir {
+			err = os.MkdirAll(path, defaultPermission)
+			if err != nil {
+				return fmt.Errorf("unable to create %s: %w", path, err)
+			}
+			continue
+		}
+		err = os.Symlink(entry.target, path)
+		if err != nil {
+			return fmt.Errorf("unable to create the symbolic link %s: %w", path, err)
+		}
+	}
+
+	err = ioutil.WriteFile(filepath.Join(dir, ViewMarkerFilename), []byte(strings.Join(names, "\n")+"\n"), 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write %s: %w", ViewMarkerFilename, err)
+	}
+	log.Printf("-> View of %s generated in %s", strings.Join(names, ", "), dir)
+	return nil
+}
@@ -0,0 +1,83 @@
+//
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+//
+// See LICENSE.txt for license information
+//
+
+package stack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+)
+
+// webhookTimeout is the maximum duration of the delivery of an event to a webhook
+var webhookTimeout = 10 * time.Second
+
+// Webhook is a URL the lifecycle events of the installations of the stack are posted to, in JSON (see
+// Event), e.g., for integration with an orchestration service. Failed deliveries are logged but do not
+// fail the installation. As for buildenv.Credential, values may refer to environment variables, e.g.,
+// "Bearer $WEBHOOK_TOKEN".
+type Webhook struct {
+	// URL is the URL the events are posted to
+	URL string `json:"url"`
+
+	// Events is the events posted to the webhook, e.g., ["component_failed"], all the events when empty
+	// (optional)
+	Events []string `json:"events"`
+
+	// Headers is the additional HTTP headers of the requests, e.g., {"Authorization": "Bearer $TOKEN"} (optional)
+	Headers map[string]string `json:"headers"`
+
+	// ReportURL is the link to the installation report included in the stack_completed events, e.g., the page
+	// of the CI job installing the stack, "${CI_JOB_URL}" (optional)
+	ReportURL string `json:"report_url"`
+}
+
+// Notify posts an event to the webhook, if it wants it
+func (w *Webhook) Notify(event *Event) error {
+	if !wantsEvent(w.Events, event.Event) {
+		return nil
+	}
+	e := *event
+	if e.Event == EventStackCompleted {
+		e.ReportURL = os.ExpandEnv(w.ReportURL)
+	}
+	content, err := json.Marshal(&e)
+	if err != nil {
+		return fmt.Errorf("unable to encode the event: %w", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, w.URL, bytes.NewReader(content))
+	if err != nil {
+		return fmt.Errorf("invalid request for %s: %w", w.URL, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+	return postRequest(req, w.URL)
+}
+
+// postRequest sends a request posting a notification to target, e.g., the URL of a webhook, and checks that it
+// was accepted
+func postRequest(req *http.Request, target string) error {
+	client := &http.Client{Timeout: webhookTimeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error includes the URL, which may be a secret, e.g., for Slack
+		return fmt.Errorf("unable to post to %s: %w", target, errors.Unwrap(err))
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unable to post to %s: %s", target, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,620 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+// Package tui implements a terminal user interface to install stacks interactively, e.g., on login nodes: the
+// components of the stack with the status of their installation, the log of the selected component and, when a
+// component fails to install, the choice to install it again, to skip it or to abort the installation.
+package tui
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/stack"
+)
+
+const (
+	// maxLogLines is the maximum number of lines of log kept for each component
+	maxLogLines = 1000
+
+	// defaultWidth and defaultHeight are the size of the terminal when it cannot be detected
+	defaultWidth  = 80
+	defaultHeight = 24
+
+	// statusPending and statusRunning are the statuses of the components whose installation is not completed
+	statusPending = "pending"
+	statusRunning = "running"
+)
+
+// statusMarkers are the markers of the statuses of the components in the list of components
+var statusMarkers = map[string]string{
+	statusPending:         " ",
+	statusRunning:         "*",
+	stack.StatusInstalled: "+",
+	stack.StatusFailed:    "x",
+	stack.StatusSkipped:   "-",
+	stack.StatusDisabled:  ".",
+	stack.StatusExternal:  "=",
+}
+
+// component is the state of a component of the stack in the interface
+type component struct {
+	name   string
+	status string
+	stage  string
+	lines  []string
+}
+
+// addLine adds a line to the log of the component, dropping the oldest ones when too many
+func (comp *component) addLine(line string) {
+	comp.lines = append(comp.lines, line)
+	if len(comp.lines) > maxLogLines {
+		comp.lines = comp.lines[len(comp.lines)-maxLogLines:]
+	}
+}
+
+// prompt is the question asked to the operator when a component failed to install
+type prompt struct {
+	component string
+	reply     chan stack.FailureAction
+}
+
+// logLine is a line logged during the installation
+type logLine string
+
+// key is a key pressed by the operator, e.g., "r" or "up"
+type key string
+
+// inputClosed notifies that the input of the operator is closed
+type inputClosed struct{}
+
+// installDone notifies that the installation of the stack completed
+type installDone struct {
+	err error
+}
+
+// session is the state of the interface during the installation of a stack
+type session struct {
+	out         io.Writer
+	interactive bool
+	width       int
+	height      int
+
+	msgs chan interface{}
+	quit chan struct{}
+
+	stackName  string
+	components []*component
+	byName     map[string]*component
+	selected   int
+	follow     bool
+	running    string
+	stackLines []string
+
+	percent float64
+	index   int
+	total   int
+	eta     time.Duration
+
+	prompts     []*prompt
+	inputClosed bool
+	done        bool
+	quitting    bool
+	err         error
+}
+
+// send sends a message to the interface, unless it is gone
+func (s *session) send(msg interface{}) {
+	select {
+	case s.msgs <- msg:
+	case <-s.quit:
+	}
+}
+
+// lineWriter is a writer calling a function for each line written to it
+type lineWriter struct {
+	buf []byte
+	fn  func(line string)
+}
+
+// Write implements io.Writer
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		idx := bytes.IndexByte(w.buf, '\n')
+		if idx == -1 {
+			break
+		}
+		w.fn(string(w.buf[:idx]))
+		w.buf = w.buf[idx+1:]
+	}
+	return len(p), nil
+}
+
+// getComponent returns the state of a component, adding it to the list when unknown
+func (s *session) getComponent(name string) *component {
+	comp, ok := s.byName[name]
+	if !ok {
+		comp = &component{name: name, status: statusPending}
+		s.components = append(s.components, comp)
+		s.byName[name] = comp
+	}
+	return comp
+}
+
+// selectComponent selects a component in the list, whose log is displayed
+func (s *session) selectComponent(name string) {
+	for idx, comp := range s.components {
+		if comp.name == name {
+			s.selected = idx
+			return
+		}
+	}
+}
+
+// handleEvent updates the state of the interface based on an event of the installation (see stack.Config.EventStream)
+func (s *session) handleEvent(event *stack.ProgressEvent) {
+	s.percent = event.Percent
+	if event.Total > 0 {
+		s.index = event.Index
+		s.total = event.Total
+		s.eta = time.Duration(event.ETASeconds * float64(time.Second))
+	}
+	if event.Component == "" {
+		return
+	}
+	comp := s.getComponent(event.Component)
+	switch event.Type {
+	case stack.ProgressComponentStarted:
+		comp.status = statusRunning
+		comp.stage = ""
+		s.running = comp.name
+		if s.follow {
+			s.selectComponent(comp.name)
+		}
+	case stack.ProgressStageStarted:
+		comp.stage = event.Stage
+	case stack.ProgressStageCompleted:
+		if event.Error != "" {
+			comp.addLine(fmt.Sprintf("%s failed: %s", event.Stage, event.Error))
+		}
+	case stack.ProgressComponentCompleted:
+		comp.status = event.Status
+		comp.stage = ""
+		if event.Error != "" {
+			comp.addLine(fmt.Sprintf("[ERROR] %s", event.Error))
+			if event.LogExcerpt != "" {
+				for _, line := range strings.Split(strings.TrimRight(event.LogExcerpt, "\n"), "\n") {
+					comp.addLine("  " + line)
+				}
+			}
+			if event.LogPath != "" {
+				comp.addLine(fmt.Sprintf("Log: %s", event.LogPath))
+			}
+		}
+		if s.running == comp.name {
+			s.running = ""
+		}
+	}
+}
+
+// answer answers the current prompt, if any
+func (s *session) answer(action stack.FailureAction) {
+	if len(s.prompts) == 0 {
+		return
+	}
+	p := s.prompts[0]
+	s.prompts = s.prompts[1:]
+	p.reply <- action
+	if comp, ok := s.byName[p.component]; ok {
+		comp.addLine(fmt.Sprintf("-> %s", action))
+	}
+}
+
+// handleKey handles a key pressed by the operator
+func (s *session) handleKey(k key) {
+	if len(s.prompts) > 0 {
+		switch k {
+		case "r":
+			s.answer(stack.FailureRetry)
+			return
+		case "s":
+			s.answer(stack.FailureSkip)
+			return
+		case "a":
+			s.answer(stack.FailureAbort)
+			return
+		}
+	}
+	switch k {
+	case "up", "k":
+		if s.selected > 0 {
+			s.selected--
+		}
+		s.follow = false
+	case "down", "j":
+		if s.selected < len(s.components)-1 {
+			s.selected++
+		}
+		s.follow = false
+	case "f":
+		s.follow = true
+		if s.running != "" {
+			s.selectComponent(s.running)
+		}
+	case "q":
+		if s.done {
+			s.quitting = true
+		}
+	}
+}
+
+// handle updates the state of the interface based on a message
+func (s *session) handle(msg interface{}) {
+	switch m := msg.(type) {
+	case *stack.ProgressEvent:
+		s.handleEvent(m)
+	case logLine:
+		if comp, ok := s.byName[s.running]; ok {
+			comp.addLine(string(m))
+		} else {
+			s.stackLines = append(s.stackLines, string(m))
+			if len(s.stackLines) > maxLogLines {
+				s.stackLines = s.stackLines[len(s.stackLines)-maxLogLines:]
+			}
+		}
+	case *prompt:
+		s.prompts = append(s.prompts, m)
+		s.selectComponent(m.component)
+		if s.inputClosed {
+			// Nobody can answer
+			s.answer(stack.FailureAbort)
+		}
+	case key:
+		s.handleKey(m)
+	case inputClosed:
+		s.inputClosed = true
+		for len(s.prompts) > 0 {
+			s.answer(stack.FailureAbort)
+		}
+	case installDone:
+		s.done = true
+		s.err = m.err
+	}
+	if s.done && (s.inputClosed || !s.interactive) {
+		s.quitting = true
+	}
+}
+
+// fit truncates or pads a string to a width
+func fit(str string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	str = strings.Replace(str, "\t", "    ", -1)
+	runes := []rune(str)
+	if len(runes) > width {
+		return string(runes[:width])
+	}
+	return str + strings.Repeat(" ", width-len(runes))
+}
+
+// render draws the interface
+func (s *session) render() {
+	var frame []string
+
+	// Header: progress of the installation of the stack
+	barWidth := 20
+	filled := int(s.percent * float64(barWidth) / 100)
+	header := fmt.Sprintf("Stack %s [%s%s] %3.0f%%", s.stackName, strings.Repeat("#", filled),
+		strings.Repeat(".", barWidth-filled), s.percent)
+	if s.total > 0 {
+		header += fmt.Sprintf(" (%d/%d)", s.index, s.total)
+	}
+	if s.eta > 0 && !s.done {
+		header += fmt.Sprintf(" ETA %s", s.eta.Round(time.Second))
+	}
+	if s.done {
+		if s.err != nil {
+			header += " - failed"
+		} else {
+			header += " - completed"
+		}
+	}
+	frame = append(frame, fit(header, s.width), strings.Repeat("-", s.width))
+
+	// Body: the list of components next to the log of the selected one
+	listWidth := 0
+	for _, comp := range s.components {
+		if len(comp.name) > listWidth {
+			listWidth = len(comp.name)
+		}
+	}
+	listWidth += 6
+	if listWidth > s.width/3 {
+		listWidth = s.width / 3
+	}
+	bodyHeight := s.height - 4
+	if bodyHeight < 1 {
+		bodyHeight = 1
+	}
+	logLines := s.stackLines
+	logTitle := "Log"
+	if s.selected < len(s.components) {
+		comp := s.components[s.selected]
+		logLines = comp.lines
+		logTitle = fmt.Sprintf("Log of %s (%s)", comp.name, comp.status)
+		if comp.stage != "" {
+			logTitle = fmt.Sprintf("Log of %s (%s: %s)", comp.name, comp.status, comp.stage)
+		}
+	}
+	if len(logLines) > bodyHeight-1 {
+		logLines = logLines[len(logLines)-(bodyHeight-1):]
+	}
+	logWidth := s.width - listWidth - 3
+	for row := 0; row < bodyHeight; row++ {
+		left := ""
+		if row < len(s.components) {
+			comp := s.components[row]
+			cursor := " "
+			if row == s.selected {
+				cursor = ">"
+			}
+			marker, ok := statusMarkers[comp.status]
+			if !ok {
+				marker = "?"
+			}
+			left = fmt.Sprintf("%s[%s] %s", cursor, marker, comp.name)
+		}
+		right := ""
+		if row == 0 {
+			right = logTitle
+		} else if row-1 < len(logLines) {
+			right = logLines[row-1]
+		}
+		frame = append(frame, fit(left, listWidth)+" | "+fit(right, logWidth))
+	}
+
+	// Footer: the question to the operator or the available keys
+	footer := "j/k: select, f: follow the installation"
+	switch {
+	case len(s.prompts) > 0:
+		footer = fmt.Sprintf("%s failed: [r]etry, [s]kip or [a]bort?", s.prompts[0].component)
+	case s.done:
+		footer = "q: quit, j/k: select"
+	}
+	frame = append(frame, strings.Repeat("-", s.width), fit(footer, s.width))
+
+	// Drawing from the top left corner of a cleared screen
+	fmt.Fprint(s.out, "\x1b[H\x1b[2J"+strings.Join(frame, "\r\n"))
+}
+
+// printSummary prints the result of the installation of the stack, which stays on the terminal once the interface
+// is gone
+func (s *session) printSummary() {
+	counts := make(map[string]int)
+	var statuses []string
+	for _, comp := range s.components {
+		if counts[comp.status] == 0 {
+			statuses = append(statuses, comp.status)
+		}
+		counts[comp.status]++
+	}
+	var results []string
+	for _, status := range statuses {
+		results = append(results, fmt.Sprintf("%d %s", counts[status], status))
+	}
+	fmt.Fprintf(s.out, "Stack %s: %s\n", s.stackName, strings.Join(results, ", "))
+	if s.err != nil {
+		fmt.Fprintf(s.out, "[ERROR] %s\n", s.err)
+	}
+}
+
+// readKeys reads the keys pressed by the operator, including the arrow keys, and sends them to the interface
+func (s *session) readKeys(in io.Reader) {
+	reader := bufio.NewReader(in)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			s.send(inputClosed{})
+			return
+		}
+		k := key(string(b))
+		if b == 0x1b {
+			// Arrow keys are ESC [ A and ESC [ B
+			seq := make([]byte, 2)
+			_, err := io.ReadFull(reader, seq)
+			if err != nil {
+				s.send(inputClosed{})
+				return
+			}
+			switch string(seq) {
+			case "[A":
+				k = "up"
+			case "[B":
+				k = "down"
+			default:
+				continue
+			}
+		}
+		s.send(k)
+	}
+}
+
+// stty runs stty on a terminal and returns its output
+func stty(terminal *os.File, args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = terminal
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("stty %s failed: %w", strings.Join(args, " "), err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// getSize returns the size of the terminal, i.e., its number of columns and lines
+func getSize(terminal *os.File) (int, int) {
+	if terminal != nil {
+		if size, err := stty(terminal, "size"); err == nil {
+			var lines, columns int
+			if _, err := fmt.Sscanf(size, "%d %d", &lines, &columns); err == nil && lines > 0 && columns > 0 {
+				return columns, lines
+			}
+		}
+	}
+	width, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || width <= 0 {
+		width = defaultWidth
+	}
+	height, err := strconv.Atoi(os.Getenv("LINES"))
+	if err != nil || height <= 0 {
+		height = defaultHeight
+	}
+	return width, height
+}
+
+// setupTerminal makes a terminal send the keys as soon as they are pressed, without echoing them, and switches to
+// the alternate screen. It returns the function restoring the terminal.
+func setupTerminal(terminal *os.File, out io.Writer) (func(), error) {
+	state, err := stty(terminal, "-g")
+	if err != nil {
+		return nil, err
+	}
+	_, err = stty(terminal, "-icanon", "-echo", "min", "1")
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprint(out, "\x1b[?1049h\x1b[?25l")
+	return func() {
+		fmt.Fprint(out, "\x1b[?25h\x1b[?1049l")
+		_, err := stty(terminal, state)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[WARN] unable to restore the terminal: %s\n", err)
+		}
+	}, nil
+}
+
+// Run installs a stack interactively: the components of the stack are listed with the status of their
+// installation, next to the log of the selected component, and the operator chooses what to do when a component
+// fails to install, i.e., installing it again, e.g., once the cause of the failure is fixed, skipping it or aborting
+// the installation. Keys are read from in, which is switched to a mode where keys are sent as soon as they are
+// pressed when it is a terminal, and the interface is drawn on out. Run returns once the installation completed and
+// the operator quit, or in is closed, with the error of the installation, if any. The log of the installation is
+// only displayed in the interface while it runs.
+func Run(cfg *stack.Config, in io.Reader, out io.Writer) error {
+	if !cfg.Loaded {
+		err := cfg.Load()
+		if err != nil {
+			return fmt.Errorf("cfg.Load() failed: %w", err)
+		}
+	}
+
+	s := &session{
+		out:    out,
+		msgs:   make(chan interface{}),
+		quit:   make(chan struct{}),
+		byName: make(map[string]*component),
+		follow: true,
+	}
+	defer close(s.quit)
+	s.stackName = cfg.Data.StackDefinition.Name
+	for _, comp := range cfg.Data.StackDefinition.Components {
+		s.getComponent(comp.Name)
+	}
+
+	defer s.printSummary()
+
+	var terminal *os.File
+	if f, ok := in.(*os.File); ok {
+		restore, err := setupTerminal(f, out)
+		if err == nil {
+			terminal = f
+			s.interactive = true
+			defer restore()
+
+			// Interrupting the installation must not leave the terminal unusable
+			interrupted := make(chan os.Signal, 1)
+			signal.Notify(interrupted, os.Interrupt)
+			defer signal.Stop(interrupted)
+			go func() {
+				select {
+				case sig := <-interrupted:
+					restore()
+					signal.Stop(interrupted)
+					if p, err := os.FindProcess(os.Getpid()); err == nil {
+						_ = p.Signal(sig)
+					}
+				case <-s.quit:
+				}
+			}()
+		}
+	}
+	s.width, s.height = getSize(terminal)
+
+	// The interface is built on the events of the installation and on its log
+	prevEventStream := cfg.EventStream
+	prevOnFailure := cfg.OnFailure
+	prevLogOutput := log.Writer()
+	defer func() {
+		cfg.EventStream = prevEventStream
+		cfg.OnFailure = prevOnFailure
+		log.SetOutput(prevLogOutput)
+	}()
+	eventWriter := &lineWriter{fn: func(line string) {
+		event := new(stack.ProgressEvent)
+		if err := json.Unmarshal([]byte(line), event); err == nil {
+			s.send(event)
+		}
+	}}
+	cfg.EventStream = eventWriter
+	if prevEventStream != nil {
+		cfg.EventStream = io.MultiWriter(eventWriter, prevEventStream)
+	}
+	cfg.OnFailure = func(c *stack.Config, comp *stack.Component, err error) stack.FailureAction {
+		p := &prompt{component: comp.Name, reply: make(chan stack.FailureAction, 1)}
+		s.send(p)
+		select {
+		case action := <-p.reply:
+			return action
+		case <-s.quit:
+			return stack.FailureAbort
+		}
+	}
+	log.SetOutput(&lineWriter{fn: func(line string) { s.send(logLine(line)) }})
+
+	go func() {
+		err := cfg.InstallStack()
+		s.send(installDone{err: err})
+	}()
+	go s.readKeys(in)
+
+	for !s.quitting {
+		s.render()
+		s.handle(<-s.msgs)
+		// Drawing once for all the pending messages, e.g., when the installation logs a lot
+	drain:
+		for !s.quitting {
+			select {
+			case msg := <-s.msgs:
+				s.handle(msg)
+			default:
+				break drain
+			}
+		}
+	}
+	s.render()
+	fmt.Fprint(out, "\r\n")
+	return s.err
+}
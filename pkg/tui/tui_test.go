@@ -0,0 +1,188 @@
+// Copyright (c) 2023, NVIDIA CORPORATION. All rights reserved.
+// This software is licensed under a 3-clause BSD license. Please consult the
+// LICENSE.md file distributed with the sources of this project regarding your
+// rights to use or distribute this software.
+
+package tui
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gvallee/go_software_build/pkg/stack"
+)
+
+// syncBuffer is a buffer the interface writes to while the test reads it
+type syncBuffer struct {
+	mutex sync.Mutex
+	buf   bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buf.String()
+}
+
+// waitFor waits until the output of the interface includes a string
+func waitFor(t *testing.T, out *syncBuffer, str string) {
+	deadline := time.Now().Add(30 * time.Second)
+	for !strings.Contains(out.String(), str) {
+		if time.Now().After(deadline) {
+			t.Fatalf("%q never displayed", str)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// createFlakySoftware creates the source code of a software package whose installation fails the first time
+func createFlakySoftware(t *testing.T, dir string) string {
+	srcDir := filepath.Join(dir, "flaky")
+	err := os.MkdirAll(srcDir, 0755)
+	if err != nil {
+		t.Fatalf("unable to create %s: %s", srcDir, err)
+	}
+	marker := filepath.Join(dir, "failed_once")
+	configureScript := `#!/bin/sh
+prefix=/usr/local
+while [ $# -gt 0 ]; do
+	case "$1" in
+		--prefix) shift; prefix="$1" ;;
+		--prefix=*) prefix="${1#--prefix=}" ;;
+	esac
+	shift
+done
+printf 'PREFIX=%s\n\nall:\n\ttrue\n\ninstall:\n\tmkdir -p $(PREFIX)/bin\n\ttest -f ` + marker + ` || { echo first failure; touch ` + marker + `; exit 1; }\n\ttouch $(PREFIX)/bin/flaky\n' "$prefix" > Makefile
+`
+	err = ioutil.WriteFile(filepath.Join(srcDir, "configure"), []byte(configureScript), 0755)
+	if err != nil {
+		t.Fatalf("unable to create configure script: %s", err)
+	}
+	return srcDir
+}
+
+func TestRun(t *testing.T) {
+	if _, err := exec.LookPath("make"); err != nil {
+		t.Skip("make not available, skipping test")
+	}
+
+	tests := []struct {
+		key      string
+		expected string
+		status   string
+	}{
+		{key: "r", expected: "-> retry", status: stack.StatusInstalled},
+		{key: "s", expected: "-> skip", status: stack.StatusFailed},
+	}
+	for _, tt := range tests {
+		testDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatalf("unable to create the temporary directory for testing: %s", err)
+		}
+		defer os.RemoveAll(testDir)
+		srcDir := createFlakySoftware(t, testDir)
+
+		cfg := &stack.Config{
+			Loaded: true,
+			Data: stack.Stack{
+				StackConfig: &stack.StackCfg{
+					InstallDir: filepath.Join(testDir, "install"),
+					System:     "host",
+				},
+				StackDefinition: &stack.StackDef{
+					Name:   "test",
+					System: "host",
+					Type:   "public",
+					Components: []stack.Component{
+						{Name: "flaky", URL: "file://" + srcDir},
+					},
+				},
+			},
+		}
+
+		in, keys := io.Pipe()
+		out := new(syncBuffer)
+		result := make(chan error, 1)
+		go func() {
+			result <- Run(cfg, in, out)
+		}()
+
+		waitFor(t, out, "flaky failed: [r]etry, [s]kip or [a]bort?")
+		if !strings.Contains(out.String(), "first failure") {
+			t.Fatalf("the log of the failure is not displayed:\n%s", out.String())
+		}
+		_, err = keys.Write([]byte(tt.key))
+		if err != nil {
+			t.Fatalf("unable to send %s: %s", tt.key, err)
+		}
+		var runErr error
+		select {
+		case runErr = <-result:
+		case <-time.After(30 * time.Second):
+			t.Fatalf("the installation did not complete after %s", tt.key)
+		}
+		keys.Close()
+
+		waitFor(t, out, tt.expected)
+		waitFor(t, out, "Stack test: 1 "+tt.status)
+		if tt.status == stack.StatusInstalled && runErr != nil {
+			t.Fatalf("installation failed after retrying: %s", runErr)
+		}
+		if tt.status == stack.StatusFailed && runErr == nil {
+			t.Fatalf("installation succeeded after skipping a component")
+		}
+		if cfg.OnFailure != nil || cfg.EventStream != nil {
+			t.Fatalf("the configuration of the stack was not restored")
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	out := new(bytes.Buffer)
+	s := &session{
+		out:       out,
+		width:     60,
+		height:    8,
+		byName:    make(map[string]*component),
+		stackName: "test",
+		follow:    true,
+	}
+	for _, name := range []string{"zlib", "openmpi"} {
+		s.getComponent(name)
+	}
+	s.handle(&stack.ProgressEvent{Type: stack.ProgressComponentStarted, Component: "zlib", Index: 1, Total: 2})
+	s.handle(logLine("building zlib"))
+	s.handle(&stack.ProgressEvent{Type: stack.ProgressComponentCompleted, Component: "zlib", Status: stack.StatusInstalled, Index: 1, Total: 2, Percent: 50})
+	s.handle(&stack.ProgressEvent{Type: stack.ProgressComponentStarted, Component: "openmpi", Index: 2, Total: 2, Percent: 50})
+	s.handle(&stack.ProgressEvent{Type: stack.ProgressStageStarted, Component: "openmpi", Stage: "compile", Percent: 50})
+	s.handle(key("k"))
+	s.render()
+
+	frame := out.String()
+	for _, expected := range []string{"Stack test [##########..........]  50% (2/2)", ">[+] zlib", " [*] openmpi", "Log of zlib (installed)", "building zlib"} {
+		if !strings.Contains(frame, expected) {
+			t.Fatalf("%q is not displayed:\n%s", expected, frame)
+		}
+	}
+
+	out.Reset()
+	s.handle(key("f"))
+	s.render()
+	if !strings.Contains(out.String(), "Log of openmpi (running: compile)") {
+		t.Fatalf("the running component is not followed:\n%s", out.String())
+	}
+}